@@ -0,0 +1,77 @@
+// Package access decides which notes a caller may see. Notes carry an access level from
+// their "access" frontmatter field; callers carry a scope from the API key they used.
+package access
+
+import (
+	"context"
+	"strings"
+)
+
+// MetadataKey is the chunk metadata key holding the note's access level
+const MetadataKey = "access"
+
+// Access levels of a note
+const (
+	// Shared notes are visible to every key; notes without an access field are shared
+	Shared = "shared"
+	// Private notes are only visible to the full-access key
+	Private = "private"
+)
+
+// Scope is what an API key may see and do.
+type Scope string
+
+const (
+	// ScopeFull sees every note and may use every endpoint
+	ScopeFull Scope = "full"
+	// ScopeShared is read-only and only sees shared notes
+	ScopeShared Scope = "shared"
+)
+
+type ctxKey struct{}
+
+// WithScope returns a context carrying the caller's scope.
+func WithScope(ctx context.Context, scope Scope) context.Context {
+	return context.WithValue(ctx, ctxKey{}, scope)
+}
+
+// ScopeFrom returns the caller's scope. Work not started by an API request (webhook,
+// scheduled jobs) has no scope and is treated as full access.
+func ScopeFrom(ctx context.Context) Scope {
+	if scope, ok := ctx.Value(ctxKey{}).(Scope); ok {
+		return scope
+	}
+	return ScopeFull
+}
+
+// Level normalizes a frontmatter access value. Unset means shared; anything other than
+// shared is private, so a mistyped level hides a note rather than exposing it.
+func Level(value string) string {
+	switch strings.ToLower(strings.Trim(strings.TrimSpace(value), `"'`)) {
+	case "", Shared:
+		return Shared
+	default:
+		return Private
+	}
+}
+
+// Visible reports whether a chunk with the given metadata may be shown to the caller of
+// ctx. Chunks indexed before access levels were recorded have none and are only visible
+// with full access.
+func Visible(ctx context.Context, metadata map[string]string) bool {
+	return ScopeFrom(ctx) == ScopeFull || metadata[MetadataKey] == Shared
+}
+
+// Where returns where restricted to the notes visible to the caller of ctx. where itself
+// is not modified.
+func Where(ctx context.Context, where map[string]string) map[string]string {
+	if ScopeFrom(ctx) == ScopeFull {
+		return where
+	}
+	out := make(map[string]string, len(where)+1)
+	for k, v := range where {
+		out[k] = v
+	}
+	out[MetadataKey] = Shared
+	return out
+}
@@ -0,0 +1,342 @@
+// Package analytics keeps the history of /query, when QUERY_HISTORY opts into it, and answers
+// what was asked: the most frequent queries, the queries that found nothing (a sign of notes
+// that are missing) and how similar the best chunk retrieved was over time. Queries are
+// redacted with the built-in and REDACT_RULES rules before they are kept, in a JSON lines file.
+package analytics
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"vex-backend/config"
+	"vex-backend/debugtrace"
+	"vex-backend/redact"
+)
+
+// LogFile is the name of the query history inside VECTOR_STORAGE_FOLDER
+const LogFile = "query_history.jsonl"
+
+// compactInterval is how often the history file is rewritten without the entries that are
+// past QUERY_HISTORY_RETENTION
+const compactInterval = 24 * time.Hour
+
+// maxQueryLength bounds the query kept, since the history is meant for grouping and reading
+const maxQueryLength = 500
+
+// Entry is a query of the history.
+type Entry struct {
+	Time time.Time `json:"time"`
+	// Query is the question with the redaction rules applied
+	Query      string `json:"query"`
+	Route      string `json:"route,omitempty"`
+	Collection string `json:"collection,omitempty"`
+	// Chunks is how many chunks were retrieved; TopSimilarity and MeanSimilarity are the
+	// similarity of the best of them and their mean
+	Chunks         int     `json:"chunks"`
+	TopSimilarity  float32 `json:"top_similarity"`
+	MeanSimilarity float32 `json:"mean_similarity"`
+	// NoAnswer is whether the answer said the notes don't cover the query, its chunks all
+	// being less similar than NO_ANSWER_SIMILARITY
+	NoAnswer  bool   `json:"no_answer,omitempty"`
+	LatencyMS int64  `json:"latency_ms"`
+	Error     string `json:"error,omitempty"`
+}
+
+// retrieved reports whether the query searched the notes, which direct answers don't.
+func (e Entry) retrieved() bool {
+	return e.Error == "" && e.Route != "direct"
+}
+
+// found reports whether the query retrieved a chunk at least minSimilarity similar and was
+// answered from it.
+func (e Entry) found(minSimilarity float64) bool {
+	return !e.NoAnswer && e.Chunks > 0 && float64(e.TopSimilarity) >= minSimilarity
+}
+
+// QueryStats sums up the times a query was asked.
+type QueryStats struct {
+	// Query is the query as last asked; queries differing only in case, spacing and
+	// trailing punctuation count as the same
+	Query     string    `json:"query"`
+	Count     int       `json:"count"`
+	LastAsked time.Time `json:"last_asked"`
+	// ZeroResults is how often it found nothing
+	ZeroResults int `json:"zero_results"`
+	// MeanTopSimilarity is the mean similarity of the best chunk, over the times it searched
+	MeanTopSimilarity float32 `json:"mean_top_similarity"`
+	MeanLatencyMS     int64   `json:"mean_latency_ms"`
+
+	searched int
+	topSum   float64
+	latSum   int64
+}
+
+// DayStats sums up the queries of a day.
+type DayStats struct {
+	Day         string `json:"day"`
+	Queries     int    `json:"queries"`
+	ZeroResults int    `json:"zero_results"`
+	// MeanTopSimilarity is the mean similarity of the best chunk of the queries that searched
+	MeanTopSimilarity float32 `json:"mean_top_similarity"`
+	MeanLatencyMS     int64   `json:"mean_latency_ms"`
+
+	searched int
+	topSum   float64
+	latSum   int64
+}
+
+// History keeps the queries asked.
+type History struct {
+	cfg     config.Source
+	logPath string
+
+	mu          sync.Mutex
+	entries     []Entry
+	lastCompact time.Time
+}
+
+// New returns a History kept at logPath, reading the queries kept so far and dropping those
+// past QUERY_HISTORY_RETENTION. A missing file means none were kept yet.
+func New(cfg config.Source, logPath string) (*History, error) {
+	h := &History{cfg: cfg, logPath: logPath}
+	f, err := os.Open(logPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return h, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("failed to read the query history %s, line %d: %w", logPath, line, err)
+		}
+		h.entries = append(h.entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read the query history %s: %w", logPath, err)
+	}
+
+	h.mu.Lock()
+	h.compactLocked(cfg().QueryHistoryRetention)
+	h.mu.Unlock()
+	return h, nil
+}
+
+// Record keeps a query answered by route, from collection, after latency, with the chunks of
+// trace, whether it was a no-answer and the error it failed with if any. It does nothing
+// unless QUERY_HISTORY is on.
+func (h *History) Record(query, route, collection string, trace debugtrace.Report, noAnswer bool, latency time.Duration, err error) {
+	cfg := h.cfg()
+	if !cfg.QueryHistory {
+		return
+	}
+	query, _ = redact.Apply(strings.TrimSpace(query), redact.QueryRules(cfg))
+	if r := []rune(query); len(r) > maxQueryLength {
+		query = string(r[:maxQueryLength]) + "…"
+	}
+	e := Entry{Time: time.Now().UTC(), Query: query, Route: route, Collection: collection, Chunks: len(trace.Chunks), NoAnswer: noAnswer, LatencyMS: latency.Milliseconds()}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	var sum float32
+	for _, c := range trace.Chunks {
+		sum += c.Similarity
+		e.TopSimilarity = max(e.TopSimilarity, c.Similarity)
+	}
+	if e.Chunks > 0 {
+		e.MeanSimilarity = sum / float32(e.Chunks)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.entries = append(h.entries, e)
+	data, merr := json.Marshal(e)
+	if merr == nil {
+		merr = appendLine(h.logPath, data)
+	}
+	if merr != nil {
+		log.Printf("[Analytics] failed to keep a query in the history: %v", merr)
+	}
+	if time.Since(h.lastCompact) >= compactInterval {
+		h.compactLocked(cfg.QueryHistoryRetention)
+	}
+}
+
+// TopQueries returns the queries asked since since, most often asked first, at most limit.
+func (h *History) TopQueries(since time.Time, limit int) []QueryStats {
+	return h.queries(since, limit, false)
+}
+
+// ZeroResults returns the queries asked since since that found nothing, no chunk at least
+// QUERY_HISTORY_MIN_SIMILARITY similar or a no-answer, most often first, at most limit.
+func (h *History) ZeroResults(since time.Time, limit int) []QueryStats {
+	return h.queries(since, limit, true)
+}
+
+func (h *History) queries(since time.Time, limit int, zeroOnly bool) []QueryStats {
+	minSimilarity := h.cfg().QueryHistoryMinSimilarity
+	groups := map[string]*QueryStats{}
+
+	h.mu.Lock()
+	for _, e := range h.entries {
+		if e.Time.Before(since) || (zeroOnly && (!e.retrieved() || e.found(minSimilarity))) {
+			continue
+		}
+		key := normalize(e.Query)
+		s := groups[key]
+		if s == nil {
+			s = &QueryStats{}
+			groups[key] = s
+		}
+		s.Query, s.LastAsked = e.Query, e.Time
+		s.Count++
+		s.latSum += e.LatencyMS
+		if e.retrieved() {
+			s.searched++
+			s.topSum += float64(e.TopSimilarity)
+			if !e.found(minSimilarity) {
+				s.ZeroResults++
+			}
+		}
+	}
+	h.mu.Unlock()
+
+	out := make([]QueryStats, 0, len(groups))
+	for _, s := range groups {
+		s.MeanLatencyMS = s.latSum / int64(s.Count)
+		if s.searched > 0 {
+			s.MeanTopSimilarity = float32(s.topSum / float64(s.searched))
+		}
+		out = append(out, *s)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count != out[j].Count {
+			return out[i].Count > out[j].Count
+		}
+		return out[i].LastAsked.After(out[j].LastAsked)
+	})
+	if limit > 0 && len(out) > limit {
+		out = out[:limit]
+	}
+	return out
+}
+
+// Days returns the queries asked since since per day, oldest first, along with the totals
+// over all of them.
+func (h *History) Days(since time.Time) ([]DayStats, DayStats) {
+	minSimilarity := h.cfg().QueryHistoryMinSimilarity
+	days := map[string]*DayStats{}
+	total := &DayStats{}
+
+	h.mu.Lock()
+	for _, e := range h.entries {
+		if e.Time.Before(since) {
+			continue
+		}
+		day := e.Time.Format(time.DateOnly)
+		d := days[day]
+		if d == nil {
+			d = &DayStats{Day: day}
+			days[day] = d
+		}
+		for _, s := range []*DayStats{d, total} {
+			s.Queries++
+			s.latSum += e.LatencyMS
+			if e.retrieved() {
+				s.searched++
+				s.topSum += float64(e.TopSimilarity)
+				if !e.found(minSimilarity) {
+					s.ZeroResults++
+				}
+			}
+		}
+	}
+	h.mu.Unlock()
+
+	out := make([]DayStats, 0, len(days))
+	for _, d := range days {
+		d.finish()
+		out = append(out, *d)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Day < out[j].Day })
+	total.finish()
+	return out, *total
+}
+
+func (d *DayStats) finish() {
+	if d.Queries > 0 {
+		d.MeanLatencyMS = d.latSum / int64(d.Queries)
+	}
+	if d.searched > 0 {
+		d.MeanTopSimilarity = float32(d.topSum / float64(d.searched))
+	}
+}
+
+// normalize is what queries are grouped by: lower case, single spaces and no trailing
+// punctuation.
+func normalize(query string) string {
+	return strings.TrimRight(strings.Join(strings.Fields(strings.ToLower(query)), " "), "?!.")
+}
+
+// compactLocked drops the entries past retention, rewriting the file if any were; 0 keeps
+// every entry. h.mu must be held.
+func (h *History) compactLocked(retention time.Duration) {
+	h.lastCompact = time.Now()
+	if retention <= 0 {
+		return
+	}
+	cutoff := time.Now().Add(-retention)
+	keep := sort.Search(len(h.entries), func(i int) bool { return !h.entries[i].Time.Before(cutoff) })
+	if keep == 0 {
+		return
+	}
+	h.entries = append([]Entry{}, h.entries[keep:]...)
+
+	var b strings.Builder
+	for _, e := range h.entries {
+		data, err := json.Marshal(e)
+		if err != nil {
+			log.Printf("[Analytics] failed to compact the query history: %v", err)
+			return
+		}
+		b.Write(data)
+		b.WriteByte('\n')
+	}
+	tmp := h.logPath + ".tmp"
+	err := os.WriteFile(tmp, []byte(b.String()), 0o600)
+	if err == nil {
+		err = os.Rename(tmp, h.logPath)
+	}
+	if err != nil {
+		log.Printf("[Analytics] failed to compact the query history: %v", err)
+	}
+}
+
+// appendLine appends data and a newline to the file at path, creating it if needed.
+func appendLine(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(data, '\n'))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"vex-backend/audit"
+	"vex-backend/breaker"
+	"vex-backend/catalog"
+	"vex-backend/chunking"
+	"vex-backend/config"
+	"vex-backend/git"
+	"vex-backend/httpclient"
+	"vex-backend/manifest"
+	"vex-backend/redact"
+	"vex-backend/usage"
+	"vex-backend/vector"
+	vectormgr "vex-backend/vector/manager"
+)
+
+// app holds what every command shares: the configuration, the vector store with its
+// catalog and audit log, the indexing manifest and the notes repository.
+type app struct {
+	cfg    config.Source
+	client httpclient.Doer
+	// store is the undecorated vector store, flushed before the process exits
+	store vectormgr.Manager
+	// vectors is store kept in step with the catalog and recorded in the audit log
+	vectors vectormgr.Manager
+	cat     *catalog.Catalog
+	man     *manifest.Manifest
+	repo    *git.Repo
+}
+
+// newApp loads the configuration and opens the vector store and the files kept next to it.
+func newApp() (*app, error) {
+	// Initialize config ONCE at startup
+	if err := config.InitConfig(); err != nil {
+		return nil, err
+	}
+
+	// Everything below gets its configuration through cfg; config.Current follows reloads
+	cfg := config.Source(config.Current)
+
+	// Validate chunking up front; the embedder then follows reloads of the chunk settings
+	if _, err := chunking.OptionsFrom(cfg()); err != nil {
+		return nil, err
+	}
+
+	// Provider circuit breakers trip after BREAKER_THRESHOLD failures for BREAKER_COOLDOWN
+	breaker.Configure(cfg)
+
+	// One pooled client for Voyage and OpenAI
+	client := httpclient.New(cfg().HTTPTimeout)
+
+	// Secrets are redacted from chunks before they reach Voyage; the audit log records what
+	redactor := redact.New(cfg, filepath.Join(cfg().VectorStorageFolder, "redactions.jsonl"))
+	// The embedder and vector store come from the backends registered with vectormgr.Register
+	// and embed.Register, as selected by EMBED_PROVIDER and VECTOR_BACKEND
+	store, err := vectormgr.Open(cfg, client, redactor)
+	if err != nil {
+		return nil, err
+	}
+
+	// Per-file indexing state lives next to the vectors so it survives restarts with them
+	man, err := manifest.Load(filepath.Join(cfg().VectorStorageFolder, "index_manifest.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := usage.Init(filepath.Join(cfg().VectorStorageFolder, "usage.json")); err != nil {
+		return nil, err
+	}
+
+	if err := audit.Init(filepath.Join(cfg().VectorStorageFolder, "audit.jsonl")); err != nil {
+		return nil, err
+	}
+	// The catalog indexes the stored notes for listing and path filters in SQLite next to the
+	// vectors (in memory when they are); it is reconciled with the vectors and kept up to date
+	// by every modification, which is also recorded in the audit log
+	catPath := filepath.Join(cfg().VectorStorageFolder, "catalog.db")
+	if cfg().VectorBackend == "memory" {
+		catPath = catalog.InMemory
+	}
+	cat, err := catalog.Open(catPath)
+	if err != nil {
+		return nil, err
+	}
+	indexed, err := catalog.Maintain(context.Background(), store, cat)
+	if err != nil {
+		return nil, err
+	}
+
+	return &app{
+		cfg:     cfg,
+		client:  client,
+		store:   store,
+		vectors: vectormgr.WithAudit(indexed),
+		cat:     cat,
+		man:     man,
+		repo:    git.NewRepo(cfg()),
+	}, nil
+}
+
+// flush writes out pending changes of a store that persists asynchronously (the encrypted
+// vector store).
+func (a *app) flush() error {
+	if f, ok := a.store.(vectormgr.Flusher); ok {
+		if err := f.Flush(); err != nil {
+			return fmt.Errorf("failed to flush vectors: %w", err)
+		}
+	}
+	return nil
+}
+
+// collection returns the vector store and indexing manifest of the named collection, a.vectors
+// and a.man for "" or VECTOR_COLLECTION. Other collections have a manifest of their own next
+// to the default one; with create set, a missing collection is created.
+func (a *app) collection(name string, create bool) (vectormgr.Manager, *manifest.Manifest, error) {
+	if name == "" || name == a.vectors.CollectionName() {
+		return a.vectors, a.man, nil
+	}
+	m, err := a.vectors.Collection(name)
+	if errors.Is(err, vector.ErrNotFound) && create {
+		if err = a.vectors.CreateCollection(context.Background(), name); err == nil {
+			m, err = a.vectors.Collection(name)
+		}
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	man, err := manifest.Load(filepath.Join(a.cfg().VectorStorageFolder, "index_manifest."+name+".json"))
+	if err != nil {
+		return nil, nil, err
+	}
+	return m, man, nil
+}
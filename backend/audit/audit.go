@@ -0,0 +1,213 @@
+// Package audit keeps an append-only JSONL log of every operation that modifies the stored
+// vectors or the server's state, with who triggered it and what it touched.
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+	"vex-backend/usage"
+)
+
+// Actions recorded in the log
+const (
+	ActionStore            = "store"
+	ActionReindex          = "reindex"
+	ActionDelete           = "delete"
+	ActionTrash            = "trash"
+	ActionRestore          = "restore"
+	ActionPurge            = "purge"
+	ActionDedup            = "dedup"
+	ActionImport           = "import"
+	ActionSnapshot         = "snapshot"
+	ActionSnapshotRestore  = "snapshot_restore"
+	ActionReloadConfig     = "reload_config"
+	ActionCreateCollection = "create_collection"
+)
+
+// Entry is one line of the audit log.
+type Entry struct {
+	Time   string `json:"time"`
+	Action string `json:"action"`
+	// Actor is the API key ID (see WithActor) or the internal caller, e.g. "webhook"
+	Actor string   `json:"actor"`
+	Paths []string `json:"paths,omitempty"`
+	IDs   []string `json:"ids,omitempty"`
+	// Detail describes the operation where paths and IDs don't, e.g. a metadata filter
+	Detail string `json:"detail,omitempty"`
+	// Count is the number of chunks affected, when known
+	Count int    `json:"count,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// Log appends entries to a JSONL file.
+type Log struct {
+	path string
+	mu   sync.Mutex
+}
+
+// Global log instance, nil until Init is called
+var auditLog *Log
+
+// Init checks that the audit log at path can be written, creating it if needed, and
+// installs it as the global log.
+func Init(path string) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	f.Close()
+
+	auditLog = &Log{path: path}
+	return nil
+}
+
+type ctxKey struct{}
+
+// WithActor returns a context whose operations are attributed to actor, typically the
+// fingerprint of the API key that authenticated the request.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, actor)
+}
+
+// Actor returns who the operations of ctx are attributed to: the actor set by WithActor,
+// else the usage source (e.g. "webhook", "digest"), else "system" for background jobs.
+func Actor(ctx context.Context) string {
+	if actor, ok := ctx.Value(ctxKey{}).(string); ok && actor != "" {
+		return actor
+	}
+	if source := usage.SourceFrom(ctx); source != "" {
+		return source
+	}
+	return "system"
+}
+
+// Record appends e to the global log, filling in the time and actor. err, if not nil, is
+// recorded as the outcome. Failing to write is logged but never fails the operation.
+func Record(ctx context.Context, e Entry, err error) {
+	if auditLog == nil {
+		return
+	}
+	e.Time = time.Now().UTC().Format(time.RFC3339Nano)
+	e.Actor = Actor(ctx)
+	if err != nil {
+		e.Error = err.Error()
+	}
+	if werr := auditLog.append(e); werr != nil {
+		log.Printf("[Audit] failed to record %s: %v", e.Action, werr)
+	}
+}
+
+func (l *Log) append(e Entry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	f, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+// Filter selects audit entries. Zero fields match everything.
+type Filter struct {
+	Since  time.Time
+	Until  time.Time
+	Action string
+	Actor  string
+	// Path matches entries that touched this file
+	Path string
+	// Limit caps the number of entries returned, newest first
+	Limit int
+}
+
+func (f Filter) matches(e Entry) bool {
+	if f.Action != "" && e.Action != f.Action {
+		return false
+	}
+	if f.Actor != "" && e.Actor != f.Actor {
+		return false
+	}
+	if f.Path != "" {
+		found := false
+		for _, p := range e.Paths {
+			if p == f.Path {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if !f.Since.IsZero() || !f.Until.IsZero() {
+		t, err := time.Parse(time.RFC3339Nano, e.Time)
+		if err != nil {
+			return false
+		}
+		if !f.Since.IsZero() && t.Before(f.Since) {
+			return false
+		}
+		if !f.Until.IsZero() && !t.Before(f.Until) {
+			return false
+		}
+	}
+	return true
+}
+
+// Query returns the entries of the global log matching f, newest first.
+func Query(f Filter) ([]Entry, error) {
+	if auditLog == nil {
+		return []Entry{}, nil
+	}
+	auditLog.mu.Lock()
+	defer auditLog.mu.Unlock()
+
+	file, err := os.Open(auditLog.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []Entry{}, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	var matched []Entry
+	scanner := bufio.NewScanner(file)
+	// entries listing many chunk IDs can be long
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			// a torn last line after a crash shouldn't hide the rest of the log
+			continue
+		}
+		if f.matches(e) {
+			matched = append(matched, e)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	// the log is in chronological order
+	out := make([]Entry, 0, len(matched))
+	for i := len(matched) - 1; i >= 0; i-- {
+		if f.Limit > 0 && len(out) == f.Limit {
+			break
+		}
+		out = append(out, matched[i])
+	}
+	return out, nil
+}
@@ -0,0 +1,157 @@
+// Package bootstrap indexes the whole notes repository when the server starts
+// (BOOTSTRAP_ON_START), so a fresh deployment can answer queries before the first push
+// fires the webhook. Its progress is kept for /health and the readiness probe.
+package bootstrap
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"vex-backend/config"
+	"vex-backend/git"
+	"vex-backend/httpclient"
+	"vex-backend/indexer"
+	"vex-backend/manifest"
+	"vex-backend/notify"
+	"vex-backend/usage"
+	vectormgr "vex-backend/vector/manager"
+)
+
+// batchSize is how many files are indexed between progress updates
+const batchSize = 25
+
+// State is how far the startup indexing got.
+type State string
+
+const (
+	// StateOff means BOOTSTRAP_ON_START is not set
+	StateOff     State = "off"
+	StateRunning State = "running"
+	StateDone    State = "done"
+	// StateFailed means the repository couldn't be fetched or indexing was cut short
+	StateFailed State = "failed"
+)
+
+// Status is the progress of the startup indexing.
+type Status struct {
+	State State `json:"state"`
+	// Files is the number of files in the repository, Done how many of them were handled
+	Files      int    `json:"files"`
+	Done       int    `json:"done"`
+	Indexed    int    `json:"indexed"`
+	Skipped    int    `json:"skipped"`
+	Failed     int    `json:"failed"`
+	StartedAt  string `json:"started_at,omitempty"`
+	FinishedAt string `json:"finished_at,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+var (
+	mu     sync.Mutex
+	status = Status{State: StateOff}
+)
+
+// Current returns the progress of the startup indexing.
+func Current() Status {
+	mu.Lock()
+	defer mu.Unlock()
+	return status
+}
+
+// Ready reports whether the server is done starting up, i.e. no startup indexing is
+// running. A failed bootstrap still counts as ready: the server answers from whatever
+// was indexed, and the failure is reported by /health.
+func Ready() bool {
+	return Current().State != StateRunning
+}
+
+func update(f func(*Status)) {
+	mu.Lock()
+	defer mu.Unlock()
+	f(&status)
+}
+
+// Run clones or pulls the repository and indexes all of its files, logging progress as it
+// goes. Files whose content didn't change since they were last indexed reuse their stored
+// embeddings, so bootstrapping an already indexed deployment costs little.
+func Run(ctx context.Context, cfg config.Source, client httpclient.Doer, repo *git.Repo, m vectormgr.Manager, man *manifest.Manifest) error {
+	start := time.Now()
+	update(func(s *Status) {
+		*s = Status{State: StateRunning, StartedAt: start.UTC().Format(time.RFC3339)}
+	})
+	err := run(usage.WithSource(ctx, "bootstrap"), cfg, client, repo, m, man)
+	update(func(s *Status) {
+		s.FinishedAt = time.Now().UTC().Format(time.RFC3339)
+		s.State = StateDone
+		if err != nil {
+			s.State, s.Error = StateFailed, err.Error()
+		}
+	})
+	notify.Send(cfg, client, event(Current(), err, time.Since(start)))
+	if err != nil {
+		log.Printf("[Bootstrap] failed after %s: %v", time.Since(start), err)
+		notify.AlertError(cfg, client, "startup indexing", err)
+		return err
+	}
+	s := Current()
+	log.Printf("[Bootstrap] completed: files=%d indexed=%d skipped=%d failed=%d duration=%s", s.Files, s.Indexed, s.Skipped, s.Failed, time.Since(start))
+	return nil
+}
+
+func run(ctx context.Context, cfg config.Source, client httpclient.Doer, repo *git.Repo, m vectormgr.Manager, man *manifest.Manifest) error {
+	log.Printf("[Bootstrap] fetching notes repo: %s", repo.URL)
+	if _, err := repo.ChangedFiles(); err != nil {
+		return fmt.Errorf("git error: %w", err)
+	}
+	files, err := repo.Files("")
+	if err != nil {
+		return err
+	}
+	update(func(s *Status) { s.Files = len(files) })
+	log.Printf("[Bootstrap] indexing %d files", len(files))
+
+	policy, err := indexer.LoadPolicy(cfg(), client, repo.Path())
+	if err != nil {
+		return fmt.Errorf("ignore rules error: %w", err)
+	}
+
+	for i := 0; i < len(files); i += batchSize {
+		batch := files[i:min(i+batchSize, len(files))]
+		res, runErr := indexer.Run(ctx, m, man, policy, repo.Path(), batch)
+		update(func(s *Status) {
+			s.Done += len(batch) - len(res.Pending)
+			s.Indexed += len(res.Processed)
+			s.Skipped += len(res.Skipped)
+			s.Failed += len(res.Failed)
+		})
+		if runErr != nil {
+			// the circuit breaker is open; the files left over are indexed by the next start
+			return runErr
+		}
+		log.Printf("[Bootstrap] progress: %d/%d files", i+len(batch), len(files))
+	}
+	return nil
+}
+
+// event describes the finished startup indexing s, which returned err after d.
+func event(s Status, err error, d time.Duration) notify.Event {
+	ev := notify.Event{
+		Trigger:    "bootstrap",
+		Status:     notify.StatusSuccess,
+		Processed:  s.Indexed,
+		Skipped:    s.Skipped,
+		Failed:     s.Failed,
+		DurationMS: d.Milliseconds(),
+		FinishedAt: time.Now().UTC(),
+	}
+	switch {
+	case err != nil:
+		ev.Status, ev.Error = notify.StatusFailed, err.Error()
+	case s.Failed > 0:
+		ev.Status = notify.StatusPartial
+	}
+	return ev
+}
@@ -0,0 +1,228 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"vex-backend/config"
+)
+
+// ErrOpen is returned by Allow when the breaker is open and calls should fail fast.
+var ErrOpen = errors.New("circuit breaker is open")
+
+// State is the current state of a Breaker.
+type State string
+
+const (
+	StateClosed   State = "closed"
+	StateOpen     State = "open"
+	StateHalfOpen State = "half-open"
+)
+
+// Default threshold and cooldown of breakers until Configure is called
+const (
+	defaultThreshold = 5
+	defaultCooldown  = 30 * time.Second
+)
+
+// Breaker trips after BREAKER_THRESHOLD consecutive failures and rejects calls until
+// BREAKER_COOLDOWN has elapsed. After the cooldown a single trial call is let through
+// (half-open); its outcome either closes the breaker again or re-opens it.
+type Breaker struct {
+	name string
+
+	mu       sync.Mutex
+	state    State
+	failures int
+	openedAt time.Time
+	trial    bool
+}
+
+// Status is a point-in-time view of a Breaker, suitable for JSON output.
+type Status struct {
+	Name     string `json:"name"`
+	State    State  `json:"state"`
+	Failures int    `json:"consecutive_failures"`
+	OpenedAt string `json:"opened_at,omitempty"`
+}
+
+var (
+	// source yields BREAKER_THRESHOLD and BREAKER_COOLDOWN once Configure was called
+	source atomic.Pointer[config.Source]
+
+	registryMu sync.Mutex
+	registry   = map[string]*Breaker{}
+	// openHooks are called with the status of a breaker that just opened
+	openHooks []func(Status)
+)
+
+// OnOpen registers f to be called whenever a breaker opens, i.e. calls to its service start
+// failing fast. f runs on the goroutine whose failure opened the breaker, so it must not block.
+func OnOpen(f func(Status)) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	openHooks = append(openHooks, f)
+}
+
+// Configure makes every breaker follow BREAKER_THRESHOLD and BREAKER_COOLDOWN of cfg,
+// including their reloads. Until it is called breakers trip after 5 failures for 30s.
+func Configure(cfg config.Source) {
+	source.Store(&cfg)
+}
+
+// limits returns the threshold and cooldown breakers currently trip with.
+func limits() (int, time.Duration) {
+	if src := source.Load(); src != nil {
+		if c := (*src)(); c != nil {
+			return max(c.BreakerThreshold, 1), c.BreakerCooldown
+		}
+	}
+	return defaultThreshold, defaultCooldown
+}
+
+// New creates a Breaker and registers it under name so its state can be reported.
+func New(name string) *Breaker {
+	b := &Breaker{
+		name:  name,
+		state: StateClosed,
+	}
+
+	registryMu.Lock()
+	registry[name] = b
+	registryMu.Unlock()
+
+	return b
+}
+
+// Allow reports whether a call may proceed. It returns an error wrapping
+// ErrOpen when the breaker is open.
+func (b *Breaker) Allow() error {
+	_, cooldown := limits()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case StateOpen:
+		if time.Since(b.openedAt) < cooldown {
+			return fmt.Errorf("%s: %w", b.name, ErrOpen)
+		}
+		// cooldown elapsed, let a single trial request through
+		b.state = StateHalfOpen
+		b.trial = true
+		return nil
+	case StateHalfOpen:
+		if b.trial {
+			return fmt.Errorf("%s: %w", b.name, ErrOpen)
+		}
+		b.trial = true
+		return nil
+	}
+	return nil
+}
+
+// Success records a successful call and closes the breaker.
+func (b *Breaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.state = StateClosed
+	b.failures = 0
+	b.trial = false
+}
+
+// Failure records a failed call, opening the breaker once the threshold is hit
+// or immediately if the trial call in half-open state failed.
+func (b *Breaker) Failure() {
+	threshold, _ := limits()
+	b.mu.Lock()
+	b.failures++
+	b.trial = false
+	opened := false
+	if b.state == StateHalfOpen || b.failures >= threshold {
+		opened = b.state != StateOpen
+		b.state = StateOpen
+		b.openedAt = time.Now()
+	}
+	b.mu.Unlock()
+
+	if opened {
+		s := b.Status()
+		registryMu.Lock()
+		hooks := openHooks
+		registryMu.Unlock()
+		for _, f := range hooks {
+			f(s)
+		}
+	}
+}
+
+// Record records the outcome of an HTTP call let through by Allow, made with ctx, resp and
+// err being what the client's Do returned. Transport errors, HTTP_TIMEOUT included, and
+// server errors are failures of the service. A call whose ctx was canceled or ran out
+// (context.Canceled, context.DeadlineExceeded) and a 4xx response, rate limiting included,
+// say nothing about the service's health and are only let go, so a half-open breaker tries
+// again.
+func (b *Breaker) Record(ctx context.Context, resp *http.Response, err error) {
+	switch {
+	case err != nil && ctx.Err() != nil:
+		b.release()
+	case err != nil || resp.StatusCode >= 500:
+		b.Failure()
+	case resp.StatusCode >= 400:
+		b.release()
+	default:
+		b.Success()
+	}
+}
+
+// release ends a call without counting it, letting the next trial through if it was one.
+func (b *Breaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trial = false
+}
+
+// Status returns the current state of the breaker.
+func (b *Breaker) Status() Status {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	s := Status{
+		Name:     b.name,
+		State:    b.state,
+		Failures: b.failures,
+	}
+	if b.state != StateClosed {
+		s.OpenedAt = b.openedAt.UTC().Format(time.RFC3339)
+	}
+	return s
+}
+
+// All returns the status of every registered breaker, sorted by name.
+func All() []Status {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	out := make([]Status, 0, len(registry))
+	for _, b := range registry {
+		out = append(out, b.Status())
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// AnyOpen reports whether any registered breaker is currently not closed.
+func AnyOpen() bool {
+	for _, s := range All() {
+		if s.State != StateClosed {
+			return true
+		}
+	}
+	return false
+}
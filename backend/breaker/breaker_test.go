@@ -0,0 +1,66 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"vex-backend/config"
+)
+
+func TestRecord(t *testing.T) {
+	Configure(config.Static(&config.EnvConfig{BreakerThreshold: 2, BreakerCooldown: time.Hour}))
+	defer source.Store(nil)
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	ctx := context.Background()
+	status := func(code int) *http.Response { return &http.Response{StatusCode: code} }
+
+	b := New("test-record")
+	for _, call := range []struct {
+		ctx  context.Context
+		resp *http.Response
+		err  error
+	}{
+		{canceled, nil, context.Canceled},
+		{ctx, status(http.StatusBadRequest), nil},
+		{ctx, status(http.StatusTooManyRequests), nil},
+		{ctx, nil, errors.New("connection refused")},
+	} {
+		b.Record(call.ctx, call.resp, call.err)
+	}
+	if s := b.Status(); s.State != StateClosed || s.Failures != 1 {
+		t.Fatalf("after one failure among ignored calls: %+v, want closed with 1 failure", s)
+	}
+
+	b.Record(ctx, status(http.StatusBadGateway), nil)
+	if err := b.Allow(); !errors.Is(err, ErrOpen) {
+		t.Fatalf("Allow after BREAKER_THRESHOLD failures = %v, want ErrOpen", err)
+	}
+}
+
+func TestIgnoredTrialReleasesHalfOpen(t *testing.T) {
+	Configure(config.Static(&config.EnvConfig{BreakerThreshold: 1, BreakerCooldown: time.Millisecond}))
+	defer source.Store(nil)
+
+	b := New("test-half-open")
+	b.Failure()
+	time.Sleep(2 * time.Millisecond)
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := b.Allow(); err != nil {
+		t.Fatalf("trial call after the cooldown: %v", err)
+	}
+	b.Record(canceled, nil, context.Canceled)
+	if err := b.Allow(); err != nil {
+		t.Fatalf("a canceled trial kept the breaker from trying again: %v", err)
+	}
+	b.Record(context.Background(), &http.Response{StatusCode: http.StatusOK}, nil)
+	if s := b.Status(); s.State != StateClosed {
+		t.Errorf("state after a successful trial = %s, want closed", s.State)
+	}
+}
@@ -0,0 +1,579 @@
+// Package catalog keeps a per-note index of the vector store (path, title, tags, modification
+// time, content hash, chunk IDs) in SQLite, where it can be listed and filtered in ways
+// chromem's exact-match metadata filters can't, e.g. by path prefix or LIKE pattern. Its
+// results are joined with the chunks table to drive which chunk IDs are fetched from the
+// vector store.
+package catalog
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"vex-backend/access"
+	"vex-backend/git"
+	"vex-backend/vector"
+	"vex-backend/vector/embed"
+	vectormgr "vex-backend/vector/manager"
+
+	// registers the sqlite3 driver
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Document is one indexed note.
+type Document struct {
+	Path  string   `json:"path"`
+	Title string   `json:"title"`
+	Tags  []string `json:"tags"`
+	// Headings are the note's section headings, in order and without duplicates
+	Headings []string `json:"headings"`
+	// ModTime is the file's modification time when it was indexed (RFC 3339), if known
+	ModTime string `json:"mod_time,omitempty"`
+	// CommitDate is when the note was last committed (RFC 3339), if it lives in a git repository
+	CommitDate string `json:"commit_date,omitempty"`
+	// Commit and Author are the hash and author of that commit
+	Commit string `json:"commit,omitempty"`
+	Author string `json:"author,omitempty"`
+	// Hash changes whenever any chunk of the note changes
+	Hash     string   `json:"hash"`
+	Access   string   `json:"access"`
+	ChunkIDs []string `json:"chunk_ids"`
+	// Feedback tallies the feedback on answers given from the note, if there was any
+	Feedback *Feedback `json:"feedback,omitempty"`
+}
+
+// Feedback tallies the answers given from a note that were rated helpful and unhelpful.
+type Feedback struct {
+	Up   int `json:"up"`
+	Down int `json:"down"`
+}
+
+// InMemory is the path Open takes for a catalog that isn't written to disk.
+const InMemory = ":memory:"
+
+// schemaVersion is stored in the database's user_version. The catalog is derived from the
+// vector store, so a database of another version is simply dropped and rebuilt.
+const schemaVersion = 1
+
+// schema keeps one row per note in notes, with its tags and chunk IDs normalized into
+// note_tags and chunks for filtering and joins. The lowercased path and title back the
+// case-insensitive LIKE and title order, and date_unix (NULL when the note has no date)
+// the date bounds.
+const schema = `
+CREATE TABLE notes (
+	path        TEXT PRIMARY KEY,
+	path_lower  TEXT NOT NULL,
+	title       TEXT NOT NULL,
+	title_lower TEXT NOT NULL,
+	tags        TEXT NOT NULL,
+	headings    TEXT NOT NULL,
+	mod_time    TEXT NOT NULL,
+	commit_date TEXT NOT NULL,
+	commit_hash TEXT NOT NULL,
+	author      TEXT NOT NULL,
+	hash        TEXT NOT NULL,
+	access      TEXT NOT NULL,
+	date        TEXT NOT NULL,
+	date_unix   INTEGER
+);
+CREATE TABLE note_tags (
+	tag  TEXT NOT NULL,
+	path TEXT NOT NULL,
+	PRIMARY KEY (tag, path)
+);
+CREATE TABLE chunks (
+	path     TEXT NOT NULL,
+	position INTEGER NOT NULL,
+	id       TEXT NOT NULL,
+	PRIMARY KEY (path, position)
+);
+CREATE INDEX chunks_id ON chunks (id);
+`
+
+// Catalog is the index of the indexed notes, kept in a SQLite database next to the vectors
+// so that it survives restarts with them; Maintain reconciles it with the vector store at
+// startup. The feedback on answers isn't derived from the vector store and survives
+// rebuilds in memory; its owner (see the feedback package) adds it again at startup.
+type Catalog struct {
+	db *sql.DB
+	// writeMu orders the writes to db with those to typeahead
+	writeMu sync.Mutex
+
+	mu sync.RWMutex
+	// typeahead holds the titles and headings of the notes prepared for Typeahead, which
+	// runs on every keystroke and so doesn't go through db
+	typeahead map[string]typeaheadNote
+	feedback  map[string]Feedback
+}
+
+// Open opens the catalog database at path, creating it if needed, or an empty catalog in
+// memory for InMemory.
+func Open(path string) (*Catalog, error) {
+	dsn := InMemory
+	if path != InMemory {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create catalog folder: %w", err)
+		}
+		dsn = path + "?_busy_timeout=5000&_journal_mode=WAL"
+	}
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open catalog: %w", err)
+	}
+	// a single connection serializes the writes, and keeps an in-memory database alive
+	db.SetMaxOpenConns(1)
+	db.SetConnMaxIdleTime(0)
+
+	c := &Catalog{db: db, typeahead: map[string]typeaheadNote{}, feedback: map[string]Feedback{}}
+	if err := c.migrate(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to set up catalog %s: %w", path, err)
+	}
+	if err := c.loadTypeahead(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to read catalog %s: %w", path, err)
+	}
+	return c, nil
+}
+
+// migrate creates the tables, dropping those of another schema version.
+func (c *Catalog) migrate() error {
+	var version int
+	if err := c.db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+	if version == schemaVersion {
+		return nil
+	}
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, table := range []string{"notes", "note_tags", "chunks"} {
+		if _, err := tx.Exec("DROP TABLE IF EXISTS " + table); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(schema); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", schemaVersion)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// loadTypeahead prepares the notes already in the database for Typeahead.
+func (c *Catalog) loadTypeahead() error {
+	rows, err := c.db.Query("SELECT path, title, headings, access, date FROM notes")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	notes := map[string]typeaheadNote{}
+	for rows.Next() {
+		var path, title, headings, acc, date string
+		if err := rows.Scan(&path, &title, &headings, &acc, &date); err != nil {
+			return err
+		}
+		var hs []string
+		if err := json.Unmarshal([]byte(headings), &hs); err != nil {
+			return fmt.Errorf("headings of %s: %w", path, err)
+		}
+		notes[path] = newTypeaheadNote(title, acc, date, hs)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.typeahead = notes
+	c.mu.Unlock()
+	return nil
+}
+
+// Close closes the catalog database.
+func (c *Catalog) Close() error {
+	return c.db.Close()
+}
+
+// AddFeedback counts up helpful and down unhelpful answers for each note of paths; negative
+// counts take back feedback that was replaced.
+func (c *Catalog) AddFeedback(paths []string, up, down int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, path := range paths {
+		f := c.feedback[path]
+		f.Up, f.Down = f.Up+up, f.Down+down
+		if f == (Feedback{}) {
+			delete(c.feedback, path)
+			continue
+		}
+		c.feedback[path] = f
+	}
+}
+
+// withFeedback returns docs with the feedback on them.
+func (c *Catalog) withFeedback(docs []Document) []Document {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for i := range docs {
+		if f, ok := c.feedback[docs[i].Path]; ok {
+			docs[i].Feedback = &f
+		}
+	}
+	return docs
+}
+
+// FromChunks describes the note at path from its chunks, in position order.
+func FromChunks(path string, chunks []vector.VectorData) Document {
+	doc := Document{Path: path, Tags: []string{}, Headings: []string{}, ChunkIDs: make([]string, 0, len(chunks))}
+	h := sha256.New()
+	// overlapping chunks can repeat a heading
+	seen := map[string]bool{}
+	for i, c := range chunks {
+		doc.ChunkIDs = append(doc.ChunkIDs, c.Id)
+		for _, heading := range embed.ExtractHeadings(c.Content) {
+			if !seen[heading] {
+				seen[heading] = true
+				doc.Headings = append(doc.Headings, heading)
+			}
+		}
+		hash := c.Metadata[vectormgr.ContentHashMetadataKey]
+		if hash == "" {
+			hash = c.Content
+		}
+		h.Write([]byte(hash))
+		if i > 0 {
+			continue
+		}
+		doc.Title = embed.ExtractTitle(c.Content)
+		doc.ModTime = c.Metadata["mod_time"]
+		doc.CommitDate = c.Metadata[git.CommitDateMetadataKey]
+		doc.Commit = c.Metadata[git.CommitHashMetadataKey]
+		doc.Author = c.Metadata[git.CommitAuthorMetadataKey]
+		doc.Access = c.Metadata[access.MetadataKey]
+		if tags := c.Metadata["tags"]; tags != "" {
+			doc.Tags = strings.Split(tags, ",")
+		}
+	}
+	if doc.Title == "" {
+		doc.Title = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	doc.Hash = hex.EncodeToString(h.Sum(nil))[:16]
+	return doc
+}
+
+// Date returns the note's commit date if known, otherwise its modification time. Both are
+// RFC 3339 in UTC, so dates compare as strings.
+func (d Document) Date() string {
+	if d.CommitDate != "" {
+		return d.CommitDate
+	}
+	return d.ModTime
+}
+
+// Rebuild replaces the catalog with the notes of chunks, which must be ordered by file and
+// position. Chunks without a filepath (derived documents) are skipped.
+func (c *Catalog) Rebuild(chunks []vector.VectorData) error {
+	var docs []Document
+	for start := 0; start < len(chunks); {
+		path := chunks[start].Metadata["filepath"]
+		end := start
+		for end < len(chunks) && chunks[end].Metadata["filepath"] == path {
+			end++
+		}
+		if path != "" {
+			docs = append(docs, FromChunks(path, chunks[start:end]))
+		}
+		start = end
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	err := c.write(func(tx *sql.Tx) error {
+		for _, table := range []string{"notes", "note_tags", "chunks"} {
+			if _, err := tx.Exec("DELETE FROM " + table); err != nil {
+				return err
+			}
+		}
+		for _, doc := range docs {
+			if err := insert(tx, doc); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to rebuild catalog: %w", err)
+	}
+
+	notes := make(map[string]typeaheadNote, len(docs))
+	for _, doc := range docs {
+		notes[doc.Path] = newTypeaheadNote(doc.Title, doc.Access, doc.Date(), doc.Headings)
+	}
+	c.mu.Lock()
+	c.typeahead = notes
+	c.mu.Unlock()
+	return nil
+}
+
+// Update replaces the entry of the note at path with its current chunks, removing it when
+// it has none.
+func (c *Catalog) Update(path string, chunks []vector.VectorData) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	var doc Document
+	err := c.write(func(tx *sql.Tx) error {
+		if err := remove(tx, path); err != nil || len(chunks) == 0 {
+			return err
+		}
+		doc = FromChunks(path, chunks)
+		return insert(tx, doc)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update catalog entry of %s: %w", path, err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(chunks) == 0 {
+		delete(c.typeahead, path)
+		return nil
+	}
+	c.typeahead[path] = newTypeaheadNote(doc.Title, doc.Access, doc.Date(), doc.Headings)
+	return nil
+}
+
+// write runs f in a transaction, committed when f succeeds.
+func (c *Catalog) write(f func(tx *sql.Tx) error) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := f(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// insert adds doc, whose path must not be in the catalog.
+func insert(tx *sql.Tx, doc Document) error {
+	tags, err := json.Marshal(doc.Tags)
+	if err != nil {
+		return err
+	}
+	headings, err := json.Marshal(doc.Headings)
+	if err != nil {
+		return err
+	}
+	var dateUnix sql.NullInt64
+	if t, err := time.Parse(time.RFC3339, doc.Date()); err == nil {
+		dateUnix = sql.NullInt64{Int64: t.UnixNano(), Valid: true}
+	}
+	_, err = tx.Exec(`INSERT INTO notes (path, path_lower, title, title_lower, tags, headings,
+		mod_time, commit_date, commit_hash, author, hash, access, date, date_unix)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		doc.Path, strings.ToLower(doc.Path), doc.Title, strings.ToLower(doc.Title), string(tags), string(headings),
+		doc.ModTime, doc.CommitDate, doc.Commit, doc.Author, doc.Hash, doc.Access, doc.Date(), dateUnix)
+	if err != nil {
+		return err
+	}
+	for _, tag := range doc.Tags {
+		if _, err := tx.Exec("INSERT OR IGNORE INTO note_tags (tag, path) VALUES (?, ?)", tag, doc.Path); err != nil {
+			return err
+		}
+	}
+	for i, id := range doc.ChunkIDs {
+		if _, err := tx.Exec("INSERT INTO chunks (path, position, id) VALUES (?, ?, ?)", doc.Path, i, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// remove deletes the note at path, if it is in the catalog.
+func remove(tx *sql.Tx, path string) error {
+	for _, table := range []string{"notes", "note_tags", "chunks"} {
+		if _, err := tx.Exec("DELETE FROM "+table+" WHERE path = ?", path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// noteColumns selects a Document from notes n, with its chunk IDs in position order.
+const noteColumns = `n.path, n.title, n.tags, n.headings, n.mod_time, n.commit_date, n.commit_hash,
+	n.author, n.hash, n.access,
+	(SELECT json_group_array(c.id ORDER BY c.position) FROM chunks c WHERE c.path = n.path)`
+
+// scanDocument reads a row of noteColumns.
+func scanDocument(rows interface{ Scan(...any) error }) (Document, error) {
+	var doc Document
+	var tags, headings, chunkIDs string
+	if err := rows.Scan(&doc.Path, &doc.Title, &tags, &headings, &doc.ModTime, &doc.CommitDate, &doc.Commit,
+		&doc.Author, &doc.Hash, &doc.Access, &chunkIDs); err != nil {
+		return Document{}, err
+	}
+	for _, field := range []struct {
+		raw string
+		dst *[]string
+	}{{tags, &doc.Tags}, {headings, &doc.Headings}, {chunkIDs, &doc.ChunkIDs}} {
+		if err := json.Unmarshal([]byte(field.raw), field.dst); err != nil {
+			return Document{}, fmt.Errorf("catalog entry of %s: %w", doc.Path, err)
+		}
+	}
+	return doc, nil
+}
+
+// Get returns the entry of the note at path.
+func (c *Catalog) Get(path string) (Document, bool, error) {
+	doc, err := scanDocument(c.db.QueryRow("SELECT "+noteColumns+" FROM notes n WHERE n.path = ?", path))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Document{}, false, nil
+	}
+	if err != nil {
+		return Document{}, false, fmt.Errorf("failed to read catalog: %w", err)
+	}
+	return c.withFeedback([]Document{doc})[0], true, nil
+}
+
+// Query selects catalog entries. Zero fields match everything.
+type Query struct {
+	// Prefix matches paths starting with it
+	Prefix string
+	// Like matches paths against an SQL LIKE pattern: % is any run of characters, _ one
+	// character, case-insensitive
+	Like string
+	// Tags must all be carried by the note
+	Tags []string
+	// ModifiedSince and ModifiedBefore bound the note's Date; notes without one don't match
+	// a bound
+	ModifiedSince  time.Time
+	ModifiedBefore time.Time
+	// Sort is "path" (default), "title" or "modified" (newest Date first)
+	Sort   string
+	Offset int
+	// Limit caps the entries returned; 0 returns all
+	Limit int
+}
+
+// where returns the WHERE clause on notes n selecting the entries matching q, and its
+// arguments.
+func (q Query) where() (string, []any) {
+	var conds []string
+	var args []any
+	if q.Prefix != "" {
+		conds = append(conds, "instr(n.path, ?) = 1")
+		args = append(args, q.Prefix)
+	}
+	if q.Like != "" {
+		// both sides lowercased, as SQLite only folds the case of ASCII letters
+		conds = append(conds, "n.path_lower LIKE ?")
+		args = append(args, strings.ToLower(q.Like))
+	}
+	for _, tag := range q.Tags {
+		conds = append(conds, "EXISTS (SELECT 1 FROM note_tags t WHERE t.tag = ? AND t.path = n.path)")
+		args = append(args, embed.NormalizeTag(tag))
+	}
+	if !q.ModifiedSince.IsZero() {
+		conds = append(conds, "n.date_unix >= ?")
+		args = append(args, q.ModifiedSince.UnixNano())
+	}
+	if !q.ModifiedBefore.IsZero() {
+		conds = append(conds, "n.date_unix < ?")
+		args = append(args, q.ModifiedBefore.UnixNano())
+	}
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
+
+// orderBy returns the ORDER BY clause on notes n of q.Sort.
+func (q Query) orderBy() string {
+	switch q.Sort {
+	case "title":
+		return " ORDER BY n.title_lower, n.path"
+	case "modified":
+		return " ORDER BY n.date DESC, n.path"
+	}
+	return " ORDER BY n.path"
+}
+
+// List returns the entries matching q, sorted and paginated, along with the number of
+// matches before pagination.
+func (c *Catalog) List(q Query) ([]Document, int, error) {
+	where, args := q.where()
+	// one transaction, so that the total counts the entries listed
+	tx, err := c.db.Begin()
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read catalog: %w", err)
+	}
+	defer tx.Rollback()
+
+	var total int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM notes n"+where, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count catalog entries: %w", err)
+	}
+	limit := q.Limit
+	if limit <= 0 {
+		// no limit in SQLite
+		limit = -1
+	}
+	rows, err := tx.Query("SELECT "+noteColumns+" FROM notes n"+where+q.orderBy()+" LIMIT ? OFFSET ?",
+		append(args, limit, max(q.Offset, 0))...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list catalog entries: %w", err)
+	}
+	defer rows.Close()
+	docs := []Document{}
+	for rows.Next() {
+		doc, err := scanDocument(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		docs = append(docs, doc)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("failed to list catalog entries: %w", err)
+	}
+	return c.withFeedback(docs), total, nil
+}
+
+// ChunkIDs returns the chunk IDs of every note matching q, in its order and ignoring its
+// pagination.
+func (c *Catalog) ChunkIDs(q Query) ([]string, error) {
+	where, args := q.where()
+	rows, err := c.db.Query("SELECT c.id FROM notes n JOIN chunks c ON c.path = n.path"+where+q.orderBy()+", c.position", args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list catalog chunks: %w", err)
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// Len returns the number of notes in the catalog.
+func (c *Catalog) Len() (int, error) {
+	var n int
+	if err := c.db.QueryRow("SELECT COUNT(*) FROM notes").Scan(&n); err != nil {
+		return 0, fmt.Errorf("failed to count catalog entries: %w", err)
+	}
+	return n, nil
+}
@@ -0,0 +1,111 @@
+package catalog_test
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"vex-backend/catalog"
+	"vex-backend/vector"
+)
+
+// note returns the chunks of a note at path with title, tags and modification time.
+func note(path, title, tags, modTime string, chunks int) []vector.VectorData {
+	vs := make([]vector.VectorData, chunks)
+	for i := range vs {
+		vs[i] = vector.VectorData{
+			Id:       fmt.Sprintf("%s#%d", path, i),
+			Content:  fmt.Sprintf("# %s\n\nPart %d.", title, i),
+			Metadata: map[string]string{"filepath": path, "tags": tags, "mod_time": modTime},
+		}
+	}
+	return vs
+}
+
+func paths(docs []catalog.Document) []string {
+	out := []string{}
+	for _, doc := range docs {
+		out = append(out, doc.Path)
+	}
+	return out
+}
+
+func TestCatalogQueries(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "catalog.db")
+	cat, err := catalog.Open(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var chunks []vector.VectorData
+	chunks = append(chunks, note("/notes/daily/2025-01-02.md", "Standup", "work", "2025-01-02T09:00:00Z", 1)...)
+	chunks = append(chunks, note("/notes/daily/2025-02-03.md", "Retro", "work,team", "2025-02-03T09:00:00Z", 2)...)
+	chunks = append(chunks, note("/notes/Études/Zebras.md", "zebras", "", "", 3)...)
+	if err := cat.Rebuild(chunks); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		q    catalog.Query
+		want []string
+	}{
+		{"all", catalog.Query{}, []string{"/notes/daily/2025-01-02.md", "/notes/daily/2025-02-03.md", "/notes/Études/Zebras.md"}},
+		{"prefix", catalog.Query{Prefix: "/notes/daily/"}, []string{"/notes/daily/2025-01-02.md", "/notes/daily/2025-02-03.md"}},
+		{"like", catalog.Query{Like: "%/ÉTUDES/%"}, []string{"/notes/Études/Zebras.md"}},
+		{"like one character", catalog.Query{Like: "/notes/daily/2025-0_-02.md"}, []string{"/notes/daily/2025-01-02.md"}},
+		{"tags", catalog.Query{Tags: []string{"work", "team"}}, []string{"/notes/daily/2025-02-03.md"}},
+		{"since", catalog.Query{ModifiedSince: time.Date(2025, 2, 3, 9, 0, 0, 0, time.UTC)}, []string{"/notes/daily/2025-02-03.md"}},
+		{"before", catalog.Query{ModifiedBefore: time.Date(2025, 2, 3, 9, 0, 0, 0, time.UTC)}, []string{"/notes/daily/2025-01-02.md"}},
+		{"title", catalog.Query{Sort: "title"}, []string{"/notes/daily/2025-02-03.md", "/notes/daily/2025-01-02.md", "/notes/Études/Zebras.md"}},
+		{"modified", catalog.Query{Sort: "modified", Offset: 1, Limit: 1}, []string{"/notes/daily/2025-01-02.md"}},
+	} {
+		docs, total, err := cat.List(tc.q)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if got := paths(docs); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: List = %v, want %v", tc.name, got, tc.want)
+		}
+		if tc.q.Limit == 0 && total != len(tc.want) {
+			t.Errorf("%s: total = %d, want %d", tc.name, total, len(tc.want))
+		}
+	}
+
+	ids, err := cat.ChunkIDs(catalog.Query{Prefix: "/notes/daily/2025-02", Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"/notes/daily/2025-02-03.md#0", "/notes/daily/2025-02-03.md#1"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("ChunkIDs = %v, want %v", ids, want)
+	}
+
+	if err := cat.Update("/notes/daily/2025-01-02.md", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := cat.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the entries survive a restart
+	cat, err = catalog.Open(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cat.Close()
+	if n, err := cat.Len(); err != nil || n != 2 {
+		t.Errorf("Len after reopening = %d, %v, want 2", n, err)
+	}
+	doc, ok, err := cat.Get("/notes/Études/Zebras.md")
+	if err != nil || !ok {
+		t.Fatalf("Get after reopening = %v, %v", ok, err)
+	}
+	if doc.Title != "zebras" || len(doc.ChunkIDs) != 3 || doc.ChunkIDs[2] != "/notes/Études/Zebras.md#2" {
+		t.Errorf("Get after reopening = %+v", doc)
+	}
+	if m := cat.Typeahead(context.Background(), "retr", 5); len(m) != 1 || m[0].Title != "Retro" {
+		t.Errorf("Typeahead after reopening = %+v", m)
+	}
+}
@@ -0,0 +1,158 @@
+package catalog
+
+import (
+	"context"
+	"io"
+	"log"
+	"path/filepath"
+	"time"
+	"vex-backend/vector"
+	vectormgr "vex-backend/vector/manager"
+)
+
+// maintained keeps a Catalog in step with the wrapped Manager: after every modification the
+// entries of the affected notes are refreshed from the vector store, and operations that may
+// touch any note rebuild the catalog.
+type maintained struct {
+	vectormgr.Manager
+	cat *Catalog
+}
+
+// Maintain fills cat from m and returns m wrapped so that every later modification made
+// through it updates cat.
+func Maintain(ctx context.Context, m vectormgr.Manager, cat *Catalog) (vectormgr.Manager, error) {
+	mm := maintained{Manager: m, cat: cat}
+	if err := mm.rebuild(ctx); err != nil {
+		return nil, err
+	}
+	return mm, nil
+}
+
+func (mm maintained) rebuild(ctx context.Context) error {
+	// chunks come back ordered by file and position
+	chunks, err := mm.Manager.GetByMetadata(ctx, nil)
+	if err != nil {
+		return err
+	}
+	return mm.cat.Rebuild(chunks)
+}
+
+// refresh re-reads the chunks of the notes at paths into the catalog.
+func (mm maintained) refresh(ctx context.Context, paths ...string) {
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		chunks, err := mm.Manager.GetChunksByFile(ctx, path)
+		if err != nil {
+			log.Printf("[Catalog] failed to refresh %s: %v", path, err)
+			continue
+		}
+		if err := mm.cat.Update(path, chunks); err != nil {
+			log.Printf("[Catalog] %v", err)
+		}
+	}
+}
+
+// refreshAll rebuilds the catalog after an operation that may have touched any note.
+func (mm maintained) refreshAll(ctx context.Context) {
+	if err := mm.rebuild(ctx); err != nil {
+		log.Printf("[Catalog] failed to rebuild: %v", err)
+	}
+}
+
+// pathsOf returns the distinct source files of vs.
+func pathsOf(vs []vector.VectorData) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, v := range vs {
+		if p := v.Metadata["filepath"]; p != "" && !seen[p] {
+			seen[p] = true
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+func (mm maintained) StoreVectorInDB(ctx context.Context, v vector.VectorData) error {
+	err := mm.Manager.StoreVectorInDB(ctx, v)
+	mm.refresh(ctx, pathsOf([]vector.VectorData{v})...)
+	return err
+}
+func (mm maintained) StoreVectorsInDB(ctx context.Context, vs []vector.VectorData) error {
+	err := mm.Manager.StoreVectorsInDB(ctx, vs)
+	mm.refresh(ctx, pathsOf(vs)...)
+	return err
+}
+func (mm maintained) StoreFileAsVectorsInDB(ctx context.Context, filename string) error {
+	err := mm.Manager.StoreFileAsVectorsInDB(ctx, filename)
+	if abs, absErr := filepath.Abs(filepath.Clean(filename)); absErr == nil {
+		mm.refresh(ctx, abs)
+	}
+	return err
+}
+func (mm maintained) ReplaceFileVectorsInDB(ctx context.Context, filename string) error {
+	err := mm.Manager.ReplaceFileVectorsInDB(ctx, filename)
+	if abs, absErr := filepath.Abs(filepath.Clean(filename)); absErr == nil {
+		mm.refresh(ctx, abs)
+	}
+	return err
+}
+func (mm maintained) DeleteVectorWithID(ctx context.Context, id string) error {
+	path := ""
+	if v, err := mm.Manager.GetByID(ctx, id); err == nil {
+		path = v.Metadata["filepath"]
+	}
+	err := mm.Manager.DeleteVectorWithID(ctx, id)
+	mm.refresh(ctx, path)
+	return err
+}
+func (mm maintained) DeleteVectorsWithMetaData(ctx context.Context, key string, data string) error {
+	matched, _ := mm.Manager.GetByMetadata(ctx, map[string]string{key: data})
+	err := mm.Manager.DeleteVectorsWithMetaData(ctx, key, data)
+	mm.refresh(ctx, pathsOf(matched)...)
+	return err
+}
+func (mm maintained) TrashVectorsWithMetaData(ctx context.Context, key string, data string) (int, error) {
+	matched, _ := mm.Manager.GetByMetadata(ctx, map[string]string{key: data})
+	n, err := mm.Manager.TrashVectorsWithMetaData(ctx, key, data)
+	mm.refresh(ctx, pathsOf(matched)...)
+	return n, err
+}
+func (mm maintained) RestoreFromTrash(ctx context.Context, path string, deletedAt string) (int, error) {
+	n, err := mm.Manager.RestoreFromTrash(ctx, path, deletedAt)
+	mm.refresh(ctx, path)
+	return n, err
+}
+func (mm maintained) PurgeTrash(ctx context.Context, before time.Time) (int, error) {
+	// only the trash changes, which the catalog doesn't cover
+	return mm.Manager.PurgeTrash(ctx, before)
+}
+func (mm maintained) Import(ctx context.Context, r io.ReadSeeker) error {
+	err := mm.Manager.Import(ctx, r)
+	mm.refreshAll(ctx)
+	return err
+}
+func (mm maintained) DeduplicateVectors(ctx context.Context, threshold float32) (int, error) {
+	n, err := mm.Manager.DeduplicateVectors(ctx, threshold)
+	if n > 0 {
+		mm.refreshAll(ctx)
+	}
+	return n, err
+}
+func (mm maintained) Reindex(ctx context.Context, build func(staging vectormgr.Manager) error) error {
+	err := mm.Manager.Reindex(ctx, build)
+	if err == nil {
+		mm.refreshAll(ctx)
+	}
+	return err
+}
+
+// Collection returns mm itself for its own collection. The catalog only covers that one, so
+// the managers of other collections are returned as they are.
+func (mm maintained) Collection(name string) (vectormgr.Manager, error) {
+	if name == mm.CollectionName() {
+		return mm, nil
+	}
+	return mm.Manager.Collection(name)
+}
@@ -0,0 +1,155 @@
+package catalog
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"unicode"
+	"vex-backend/access"
+)
+
+// Match is a note whose title or one of whose headings matches a typeahead query.
+type Match struct {
+	Path  string `json:"path"`
+	Title string `json:"title"`
+	// Heading is the matching heading, empty when the title matched
+	Heading string `json:"heading,omitempty"`
+}
+
+// Typeahead ranks matches, best first: the title starts with the query, every query word
+// starts a word of the title, then the same for a heading.
+const (
+	rankTitlePrefix = iota
+	rankTitleWords
+	rankHeadingPrefix
+	rankHeadingWords
+	rankNone
+)
+
+// searchText is a title or heading lowercased and split into words once, when the note is
+// cataloged, so that matching it on every keystroke allocates nothing.
+type searchText struct {
+	text  string
+	lower string
+	words []string
+}
+
+// typeaheadNote is what Typeahead needs of a note.
+type typeaheadNote struct {
+	title  string
+	access string
+	date   string
+	// search holds the title followed by the headings
+	search []searchText
+}
+
+// newTypeaheadNote prepares a note with title and headings, access level and Date.
+func newTypeaheadNote(title, level, date string, headings []string) typeaheadNote {
+	search := make([]searchText, 0, len(headings)+1)
+	for _, text := range append([]string{title}, headings...) {
+		lower := strings.ToLower(text)
+		search = append(search, searchText{text: text, lower: lower, words: splitWords(lower)})
+	}
+	return typeaheadNote{title: title, access: level, date: date, search: search}
+}
+
+// Typeahead returns up to limit notes visible to the caller of ctx whose title or headings
+// match text as the user types it: the text as a prefix, or each of its words as the start
+// of a word, case-insensitively. Title matches come before heading matches and, within a
+// rank, recently changed notes first. It only reads the catalog, so it is cheap enough to
+// call on every keystroke.
+func (c *Catalog) Typeahead(ctx context.Context, text string, limit int) []Match {
+	text = strings.ToLower(strings.TrimSpace(text))
+	words := splitWords(text)
+	if len(words) == 0 || limit <= 0 {
+		return []Match{}
+	}
+	// the same rule as access.Visible, without building a metadata map per note
+	sharedOnly := access.ScopeFrom(ctx) != access.ScopeFull
+
+	type ranked struct {
+		Match
+		rank int
+		date string
+	}
+	var found []ranked
+
+	c.mu.RLock()
+	for path, doc := range c.typeahead {
+		if sharedOnly && doc.access != access.Shared {
+			continue
+		}
+		best := ranked{rank: rankNone}
+		for i, s := range doc.search {
+			r := s.match(text, words)
+			if i > 0 {
+				r += rankHeadingPrefix
+			}
+			if r < best.rank {
+				best.rank = r
+				if i > 0 {
+					best.Heading = s.text
+				}
+			}
+			if best.rank <= rankTitleWords {
+				// no heading can beat a title match
+				break
+			}
+		}
+		if best.rank < rankNone {
+			best.Path, best.Title, best.date = path, doc.title, doc.date
+			found = append(found, best)
+		}
+	}
+	c.mu.RUnlock()
+
+	sort.Slice(found, func(i, j int) bool {
+		if found[i].rank != found[j].rank {
+			return found[i].rank < found[j].rank
+		}
+		if found[i].date != found[j].date {
+			return found[i].date > found[j].date
+		}
+		return found[i].Path < found[j].Path
+	})
+
+	out := make([]Match, 0, min(limit, len(found)))
+	for _, f := range found {
+		if len(out) == limit {
+			break
+		}
+		out = append(out, f.Match)
+	}
+	return out
+}
+
+// match returns how s matches the lowercased text and its words: rankTitlePrefix if s starts
+// with text, rankTitleWords if every word starts one of its words, else rankNone.
+func (s searchText) match(text string, words []string) int {
+	if strings.HasPrefix(s.lower, text) {
+		return rankTitlePrefix
+	}
+	if len(s.words) == 0 {
+		return rankNone
+	}
+	for _, w := range words {
+		found := false
+		for _, have := range s.words {
+			if strings.HasPrefix(have, w) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return rankNone
+		}
+	}
+	return rankTitleWords
+}
+
+// splitWords splits s into runs of letters and digits.
+func splitWords(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
@@ -0,0 +1,329 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+	"vex-backend/config"
+	"vex-backend/debugtrace"
+	"vex-backend/vector"
+	"vex-backend/vector/embed"
+	"vex-backend/vector/manager"
+)
+
+const (
+	// agentSearchLimit caps how many chunks a single search_notes call may return
+	agentSearchLimit = 8
+	// agentToolResultLimit caps the size of a tool result sent back to the model
+	agentToolResultLimit = 12000
+	// agentTraceResultLimit caps the size of a tool result kept in the trace
+	agentTraceResultLimit = 500
+)
+
+// ToolStep records one tool call made by the agent, for debugging.
+type ToolStep struct {
+	Step      int             `json:"step"`
+	Tool      string          `json:"tool"`
+	Arguments json.RawMessage `json:"arguments"`
+	Result    string          `json:"result,omitempty"`
+	Error     string          `json:"error,omitempty"`
+}
+
+var agentTools = []Tool{
+	{
+		Type: "function",
+		Function: ToolFunction{
+			Name:        "search_notes",
+			Description: "Semantic search over the user's notes. Returns the best matching chunks with their file paths.",
+			Parameters: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"query": map[string]any{"type": "string", "description": "What to search for"},
+					"limit": map[string]any{"type": "integer", "description": fmt.Sprintf("Number of chunks to return (1-%d)", agentSearchLimit)},
+					"tags":  map[string]any{"type": "array", "items": map[string]any{"type": "string"}, "description": "Only return notes carrying all of these tags"},
+				},
+				"required": []string{"query"},
+			},
+		},
+	},
+	{
+		Type: "function",
+		Function: ToolFunction{
+			Name:        "get_file",
+			Description: "Returns the full indexed content of one note, by the file path reported by search_notes.",
+			Parameters: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"filepath": map[string]any{"type": "string", "description": "The note's file path"},
+				},
+				"required": []string{"filepath"},
+			},
+		},
+	},
+	{
+		Type: "function",
+		Function: ToolFunction{
+			Name:        "list_tags",
+			Description: "Lists every tag used in the notes with the number of notes carrying it.",
+			Parameters:  map[string]any{"type": "object", "properties": map[string]any{}},
+		},
+	},
+}
+
+const agentPrompt = `You are V_E_X, an assistant that answers questions using the user's personal Obsidian notes.
+You can call tools to search the notes, read whole notes and list tags. Call them as often as needed until you
+have enough context, then answer. Base your answer on what the tools returned, cite the notes you used by file
+name, and say clearly when the notes don't contain the answer. If you are going to use math equations, write
+them as $${math}$$ or ${math}$.`
+
+// answerWithAgent lets the LLM gather context itself through tool calls, for at most
+// AGENT_MAX_STEPS rounds, and returns its answer along with the trace of tool calls.
+func answerWithAgent(ctx context.Context, cfg *config.EnvConfig, fc *fallbackChatter, vm manager.Manager, query string, opts QueryOptions) (string, []ToolStep, error) {
+	messages := []ChatMessage{
+		{Role: "system", Content: agentPrompt + opts.instructions(cfg)},
+		{Role: "user", Content: query},
+	}
+	trace := []ToolStep{}
+
+	for step := 1; step <= cfg.AgentMaxSteps; step++ {
+		reply, err := fc.CompleteWithTools(ctx, messages, agentTools)
+		if err != nil {
+			return "", trace, err
+		}
+		if len(reply.ToolCalls) == 0 {
+			tracePrompt(ctx, messages)
+			return reply.Content, trace, nil
+		}
+		messages = append(messages, reply)
+
+		for _, call := range reply.ToolCalls {
+			result, err := runAgentTool(ctx, vm, call.Function.Name, call.Function.Arguments, opts)
+			ts := ToolStep{
+				Step:      step,
+				Tool:      call.Function.Name,
+				Arguments: json.RawMessage(call.Function.Arguments),
+			}
+			if !json.Valid(ts.Arguments) {
+				ts.Arguments, _ = json.Marshal(call.Function.Arguments)
+			}
+			if err != nil {
+				ts.Error = err.Error()
+				result = "error: " + err.Error()
+			} else {
+				ts.Result = truncate(result, agentTraceResultLimit)
+			}
+			trace = append(trace, ts)
+
+			messages = append(messages, ChatMessage{
+				Role:       "tool",
+				ToolCallID: call.ID,
+				Content:    truncate(result, agentToolResultLimit),
+			})
+		}
+	}
+
+	// out of steps: ask for an answer from what was gathered, without offering tools
+	messages = append(messages, ChatMessage{
+		Role:    "system",
+		Content: "The tool call limit has been reached. Answer now using only the information gathered so far.",
+	})
+	tracePrompt(ctx, messages)
+	reply, err := fc.CompleteWithTools(ctx, messages, nil)
+	if err != nil {
+		return "", trace, err
+	}
+	return reply.Content, trace, nil
+}
+
+// tracePrompt adds the conversation the agent answered from to the debug trace of ctx.
+func tracePrompt(ctx context.Context, messages []ChatMessage) {
+	t := debugtrace.FromContext(ctx)
+	if t == nil {
+		return
+	}
+	prompt := make([]debugtrace.Message, len(messages))
+	for i, m := range messages {
+		prompt[i] = debugtrace.Message{Role: m.Role, Content: m.Content}
+	}
+	t.SetPrompt(prompt...)
+}
+
+// runAgentTool executes one tool call and returns its result as JSON text.
+func runAgentTool(ctx context.Context, vm manager.Manager, name, arguments string, opts QueryOptions) (string, error) {
+	var result any
+	var err error
+	switch name {
+	case "search_notes":
+		var args struct {
+			Query string   `json:"query"`
+			Limit int      `json:"limit"`
+			Tags  []string `json:"tags"`
+		}
+		if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+			return "", fmt.Errorf("invalid arguments: %w", err)
+		}
+		result, err = agentSearchNotes(ctx, vm, args.Query, args.Limit, append(append([]string{}, opts.Tags...), args.Tags...))
+	case "get_file":
+		var args struct {
+			Filepath string `json:"filepath"`
+		}
+		if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+			return "", fmt.Errorf("invalid arguments: %w", err)
+		}
+		result, err = agentGetFile(ctx, vm, args.Filepath)
+	case "list_tags":
+		result, err = agentListTags(ctx, vm)
+	default:
+		return "", fmt.Errorf("unknown tool %q", name)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func agentSearchNotes(ctx context.Context, vm manager.Manager, query string, limit int, tags []string) (any, error) {
+	if strings.TrimSpace(query) == "" {
+		return nil, errors.New("query is required")
+	}
+	if limit <= 0 || limit > agentSearchLimit {
+		limit = agentSearchLimit
+	}
+
+	results, err := vm.RetriveNVectorsByQueryWithFilter(ctx, query, limit, QueryOptions{Tags: tags}.where())
+	if errors.Is(err, vector.ErrEmptyCollection) {
+		results, err = nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	traceChunks(ctx, results)
+
+	type hit struct {
+		Filepath   string  `json:"filepath"`
+		Similarity float32 `json:"similarity"`
+		Content    string  `json:"content"`
+	}
+	hits := []hit{}
+	for _, r := range results {
+		hits = append(hits, hit{Filepath: r.Metadata["filepath"], Similarity: r.Similarity, Content: r.Content})
+	}
+	return hits, nil
+}
+
+func agentGetFile(ctx context.Context, vm manager.Manager, path string) (any, error) {
+	if path == "" {
+		return nil, errors.New("filepath is required")
+	}
+	chunks, err := vm.GetChunksByFile(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	if len(chunks) == 0 {
+		return nil, fmt.Errorf("no indexed note with filepath %q", path)
+	}
+	return map[string]string{
+		"filepath": path,
+		"content":  stitchChunks(chunks),
+	}, nil
+}
+
+func agentListTags(ctx context.Context, vm manager.Manager) (any, error) {
+	chunks, err := vm.GetByMetadata(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// count notes, not chunks
+	files := map[string]map[string]bool{}
+	for _, c := range chunks {
+		for _, tag := range strings.Split(c.Metadata["tags"], ",") {
+			if tag == "" {
+				continue
+			}
+			if files[tag] == nil {
+				files[tag] = map[string]bool{}
+			}
+			files[tag][c.Metadata["filepath"]] = true
+		}
+	}
+
+	type tagCount struct {
+		Tag   string `json:"tag"`
+		Notes int    `json:"notes"`
+	}
+	out := []tagCount{}
+	for tag, f := range files {
+		out = append(out, tagCount{Tag: tag, Notes: len(f)})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Notes != out[j].Notes {
+			return out[i].Notes > out[j].Notes
+		}
+		return out[i].Tag < out[j].Tag
+	})
+	return out, nil
+}
+
+// stitchChunks reassembles a file's content from its chunks (ordered by position), using the
+// recorded byte offsets to drop the overlap between consecutive chunks and to separate chunks
+// the white space between them was trimmed from. Chunks without offsets are simply
+// concatenated.
+func stitchChunks(chunks []vector.VectorData) string {
+	var b strings.Builder
+	prevEnd, prevLine := -1, 0
+	for _, c := range chunks {
+		start, errStart := strconv.Atoi(c.Metadata[embed.StartByteMetadataKey])
+		end, errEnd := strconv.Atoi(c.Metadata[embed.EndByteMetadataKey])
+		if errStart != nil || errEnd != nil || prevEnd < 0 {
+			if b.Len() > 0 {
+				b.WriteString("\n")
+			}
+			b.WriteString(c.Content)
+			if errStart == nil && errEnd == nil {
+				prevEnd = end
+				prevLine, _ = strconv.Atoi(c.Metadata[embed.EndLineMetadataKey])
+			}
+			continue
+		}
+		if end <= prevEnd {
+			continue
+		}
+		text := c.Content
+		if skip := prevEnd - start; skip > 0 && skip <= len(text) {
+			text = text[skip:]
+		} else if skip < 0 {
+			// as many line breaks as there were, for a blank line between paragraphs
+			line, _ := strconv.Atoi(c.Metadata[embed.StartLineMetadataKey])
+			b.WriteString(strings.Repeat("\n", min(max(line-prevLine, 0), 2)))
+			if line <= prevLine {
+				b.WriteString(" ")
+			}
+		}
+		b.WriteString(text)
+		prevEnd = end
+		prevLine, _ = strconv.Atoi(c.Metadata[embed.EndLineMetadataKey])
+	}
+	return b.String()
+}
+
+// truncate shortens s to at most n bytes without splitting a UTF-8 sequence, marking that it was cut.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n] + "…(truncated)"
+}
@@ -1,8 +1,71 @@
 package chat
 
-import "context"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"vex-backend/config"
+	"vex-backend/httpclient"
+)
 
 type chatter interface {
 	GetResponse(ctx context.Context, query string) (string, error)
 	GetResponseWithSystemPrompt(ctx context.Context, query string, systemprompt string) (string, error)
 }
+
+// toolChatter is implemented by chatters whose provider supports function calling.
+type toolChatter interface {
+	CompleteWithTools(ctx context.Context, messages []ChatMessage, tools []Tool) (ChatMessage, error)
+}
+
+// streamChatter is implemented by chatters that can deliver an answer while it is generated.
+type streamChatter interface {
+	StreamWithMessages(ctx context.Context, messages []ChatMessage, onDelta func(string)) (string, error)
+}
+
+// Chat providers selectable through CHAT_PROVIDER
+const (
+	ProviderOpenAI = "openai"
+	ProviderLocal  = "local"
+)
+
+// newChatter returns the chatter for the configured CHAT_PROVIDER priority list. With a
+// single provider the chain simply has one entry.
+func newChatter(cfg *config.EnvConfig, client httpclient.Doer) *fallbackChatter {
+	fc := &fallbackChatter{timeout: cfg.ChatProviderTimeout}
+	for _, name := range cfg.ChatProviders() {
+		switch name {
+		case ProviderLocal:
+			fc.providers = append(fc.providers, namedChatter{name: name, chatter: newLocalChatter(cfg, client)})
+		case ProviderOpenAI:
+			fc.providers = append(fc.providers, namedChatter{name: name, chatter: newOpenAIChatter(cfg, client)})
+		}
+	}
+	if len(fc.providers) == 0 {
+		fc.providers = append(fc.providers, namedChatter{name: ProviderOpenAI, chatter: newOpenAIChatter(cfg, client)})
+	}
+	return fc
+}
+
+// pingPrompt asks for the shortest possible answer, so a ping costs a handful of tokens
+const pingPrompt = "Reply with the single word OK."
+
+// Ping sends a minimal prompt to the chat provider named provider alone, without falling
+// back to the others, to check that it answers.
+func Ping(ctx context.Context, cfg *config.EnvConfig, client httpclient.Doer, provider string) error {
+	var c chatter
+	switch provider {
+	case ProviderLocal:
+		c = newLocalChatter(cfg, client)
+	case ProviderOpenAI:
+		c = newOpenAIChatter(cfg, client)
+	default:
+		return fmt.Errorf("unknown chat provider %q", provider)
+	}
+	answer, err := c.GetResponseWithSystemPrompt(ctx, "ping", pingPrompt)
+	if err == nil && strings.TrimSpace(answer) == "" {
+		err = errors.New("empty answer")
+	}
+	return err
+}
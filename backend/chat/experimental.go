@@ -0,0 +1,182 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"vex-backend/config"
+	"vex-backend/debugtrace"
+	"vex-backend/flags"
+	"vex-backend/vector"
+	"vex-backend/vector/manager"
+)
+
+// The stages below are experimental and only run when their feature flag is on.
+const (
+	// rerankFactor is how many more chunks than answered from are retrieved for reranking
+	rerankFactor = 3
+	// maxExpansions caps the rephrasings of a question retrieved for with multi_query
+	maxExpansions = 3
+)
+
+const expandPrompt = `You help search a personal knowledge base. Rephrase the user's question into 3 different search queries that could find notes answering it: use other words, synonyms and related concepts.
+Return one query per line and nothing else.`
+
+const rerankPrompt = `You rank passages from a personal knowledge base by how useful they are for answering the user's question.
+The passages are numbered. Reply with the numbers of the passages, most useful first, separated by commas, and nothing else. Leave out passages that don't help at all.`
+
+const groundingPrompt = `You check an answer for claims that are not supported by the documents it was based on.
+Respond with a JSON object only, no code fences, of the form:
+{"supported": true or false, "unsupported_claims": ["<claim not backed by the documents>", ...]}
+An answer saying the documents don't contain the information is supported.`
+
+// Grounding is the verdict of the hallucination check on a RAG answer.
+type Grounding struct {
+	// Supported is whether every claim of the answer is backed by the retrieved chunks
+	Supported         bool     `json:"supported"`
+	UnsupportedClaims []string `json:"unsupported_claims"`
+}
+
+// retrieveForAnswer retrieves the n chunks a RAG answer is given for query: with
+// multi_query, for the rephrasings of the question too, and with rerank, rerankFactor times
+// as many that the LLM then narrows down to n.
+func retrieveForAnswer(ctx context.Context, cfg *config.EnvConfig, chat_platform chatter, vm manager.Manager, question, query string, n int, opts QueryOptions) ([]vector.VectorData, error) {
+	rerank := flags.Enabled(ctx, cfg, flags.Rerank)
+	candidates := n
+	if rerank {
+		candidates = n * rerankFactor
+	}
+
+	queries := []string{query}
+	if flags.Enabled(ctx, cfg, flags.MultiQuery) {
+		stageCtx, done := debugtrace.StartStage(ctx, "expand_query")
+		queries = append(queries, expandQuery(stageCtx, chat_platform, question)...)
+		done()
+	}
+
+	stageCtx, done := debugtrace.StartStage(ctx, "retrieve")
+	results, err := retrieveMerged(stageCtx, cfg, vm, queries, candidates, opts)
+	done()
+	if err != nil {
+		return nil, err
+	}
+
+	if rerank && len(results) > 1 {
+		stageCtx, done := debugtrace.StartStage(ctx, "rerank")
+		results = rerankChunks(stageCtx, chat_platform, question, results)
+		done()
+	}
+	if len(results) > n {
+		results = results[:n]
+	}
+	return results, nil
+}
+
+// retrieveMerged retrieves the n chunks most relevant to each of queries and merges them,
+// keeping each chunk once and ordering them by their best similarity.
+func retrieveMerged(ctx context.Context, cfg *config.EnvConfig, vm manager.Manager, queries []string, n int, opts QueryOptions) ([]vector.VectorData, error) {
+	if len(queries) == 1 {
+		return retrieve(ctx, cfg, vm, queries[0], n, opts)
+	}
+	var merged []vector.VectorData
+	index := map[string]int{}
+	for _, q := range queries {
+		results, err := retrieve(ctx, cfg, vm, q, n, opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range results {
+			if i, ok := index[r.Id]; ok {
+				if r.Similarity > merged[i].Similarity {
+					merged[i].Similarity = r.Similarity
+				}
+				continue
+			}
+			index[r.Id] = len(merged)
+			merged = append(merged, r)
+		}
+	}
+	sort.SliceStable(merged, func(i, j int) bool { return merged[i].Similarity > merged[j].Similarity })
+	return merged, nil
+}
+
+// expandQuery has the LLM rephrase question into other search queries, none if that fails.
+func expandQuery(ctx context.Context, chat_platform chatter, question string) []string {
+	response, err := chat_platform.GetResponseWithSystemPrompt(ctx, question, expandPrompt)
+	if err != nil {
+		log.Printf("[Chat] query expansion failed: %v", err)
+		return nil
+	}
+	var queries []string
+	for _, line := range strings.Split(response, "\n") {
+		line = strings.TrimSpace(reListItem.ReplaceAllString(line, ""))
+		if line != "" && len(queries) < maxExpansions {
+			queries = append(queries, line)
+		}
+	}
+	return queries
+}
+
+var reNumber = regexp.MustCompile(`\d+`)
+
+// rerankChunks has the LLM order results by their use for answering question. Chunks it
+// leaves out go last in their retrieved order; if the LLM fails, results are kept as they are.
+func rerankChunks(ctx context.Context, chat_platform chatter, question string, results []vector.VectorData) []vector.VectorData {
+	var passages strings.Builder
+	for i, r := range results {
+		fmt.Fprintf(&passages, "--- Passage %d ---\n%s\n\n", i+1, r.Content)
+	}
+	response, err := chat_platform.GetResponseWithSystemPrompt(ctx, "Question: "+question+"\n\n"+passages.String(), rerankPrompt)
+	if err != nil {
+		log.Printf("[Chat] reranking failed, keeping the retrieval order: %v", err)
+		return results
+	}
+
+	ranked := make([]vector.VectorData, 0, len(results))
+	used := make([]bool, len(results))
+	for _, m := range reNumber.FindAllString(response, -1) {
+		i, err := strconv.Atoi(m)
+		if err != nil || i < 1 || i > len(results) || used[i-1] {
+			continue
+		}
+		used[i-1] = true
+		ranked = append(ranked, results[i-1])
+	}
+	for i, r := range results {
+		if !used[i] {
+			ranked = append(ranked, r)
+		}
+	}
+	return ranked
+}
+
+// checkGrounding has the LLM check answer against sources, the chunks it was given. It
+// returns nil if the check fails, since a missing verdict shouldn't fail the answer.
+func checkGrounding(ctx context.Context, chat_platform chatter, answer string, sources []vector.VectorData) *Grounding {
+	var input strings.Builder
+	for i, s := range sources {
+		fmt.Fprintf(&input, "--- Document %d ---\n%s\n\n", i+1, s.Content)
+	}
+	input.WriteString("--- Answer ---\n" + answer)
+	response, err := chat_platform.GetResponseWithSystemPrompt(ctx, input.String(), groundingPrompt)
+	if err != nil {
+		log.Printf("[Chat] hallucination check failed: %v", err)
+		return nil
+	}
+
+	start, end := strings.Index(response, "{"), strings.LastIndex(response, "}")
+	g := &Grounding{}
+	if start < 0 || end < start || json.Unmarshal([]byte(response[start:end+1]), g) != nil {
+		log.Printf("[Chat] hallucination check returned no verdict: %q", response)
+		return nil
+	}
+	if g.UnsupportedClaims == nil {
+		g.UnsupportedClaims = []string{}
+	}
+	return g
+}
@@ -0,0 +1,103 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+type namedChatter struct {
+	name string
+	chatter
+}
+
+// fallbackChatter tries its providers in priority order, giving each at most timeout, and
+// returns the first answer. Any failure (rate limiting, 5xx, open breaker, timeout) moves on
+// to the next provider unless the caller's own context is done.
+type fallbackChatter struct {
+	providers []namedChatter
+	timeout   time.Duration
+
+	// answeredBy is the provider of the most recent successful response
+	answeredBy string
+}
+
+func (fc *fallbackChatter) GetResponse(ctx context.Context, query string) (string, error) {
+	msg, err := fc.try(ctx, func(ctx context.Context, c chatter) (ChatMessage, error) {
+		answer, err := c.GetResponse(ctx, query)
+		return ChatMessage{Content: answer}, err
+	})
+	return msg.Content, err
+}
+
+func (fc *fallbackChatter) GetResponseWithSystemPrompt(ctx context.Context, query string, systemprompt string) (string, error) {
+	msg, err := fc.try(ctx, func(ctx context.Context, c chatter) (ChatMessage, error) {
+		answer, err := c.GetResponseWithSystemPrompt(ctx, query, systemprompt)
+		return ChatMessage{Content: answer}, err
+	})
+	return msg.Content, err
+}
+
+// CompleteWithTools runs a tool-calling completion on the first provider that supports
+// function calling and answers.
+func (fc *fallbackChatter) CompleteWithTools(ctx context.Context, messages []ChatMessage, tools []Tool) (ChatMessage, error) {
+	return fc.try(ctx, func(ctx context.Context, c chatter) (ChatMessage, error) {
+		tc, ok := c.(toolChatter)
+		if !ok {
+			return ChatMessage{}, errors.New("provider does not support tool calling")
+		}
+		return tc.CompleteWithTools(ctx, messages, tools)
+	})
+}
+
+// errNoFallback marks failures the next provider must not be tried for, such as a stream
+// that already delivered part of its answer.
+var errNoFallback = errors.New("no fallback possible")
+
+// Stream answers the conversation on the first provider that supports streaming, passing
+// the answer to onDelta as it arrives. Once a provider has delivered part of an answer, a
+// failure is returned as is: the next provider would start the answer over.
+func (fc *fallbackChatter) Stream(ctx context.Context, messages []ChatMessage, onDelta func(string)) (string, error) {
+	msg, err := fc.try(ctx, func(ctx context.Context, c chatter) (ChatMessage, error) {
+		sc, ok := c.(streamChatter)
+		if !ok {
+			return ChatMessage{}, errors.New("provider does not support streaming")
+		}
+		streamed := false
+		answer, err := sc.StreamWithMessages(ctx, messages, func(delta string) {
+			streamed = true
+			onDelta(delta)
+		})
+		if err != nil && streamed {
+			err = fmt.Errorf("%w: %w", errNoFallback, err)
+		}
+		return ChatMessage{Role: "assistant", Content: answer}, err
+	})
+	return msg.Content, err
+}
+
+func (fc *fallbackChatter) try(ctx context.Context, call func(context.Context, chatter) (ChatMessage, error)) (ChatMessage, error) {
+	var errs []error
+	for i, p := range fc.providers {
+		attemptCtx := ctx
+		cancel := func() {}
+		if fc.timeout > 0 {
+			attemptCtx, cancel = context.WithTimeout(ctx, fc.timeout)
+		}
+		msg, err := call(attemptCtx, p.chatter)
+		cancel()
+		if err == nil {
+			fc.answeredBy = p.name
+			return msg, nil
+		}
+
+		errs = append(errs, fmt.Errorf("%s: %w", p.name, err))
+		if ctx.Err() != nil || errors.Is(err, errNoFallback) || i == len(fc.providers)-1 {
+			break
+		}
+		log.Printf("[Chat] provider %s failed, falling back to %s: %v", p.name, fc.providers[i+1].name, err)
+	}
+	return ChatMessage{}, errors.Join(errs...)
+}
@@ -0,0 +1,224 @@
+package chat
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"vex-backend/git"
+	"vex-backend/vector"
+)
+
+// Format is the shape an answer is returned in.
+type Format string
+
+const (
+	// FormatMarkdown is the answer as the LLM writes it, in Markdown (the default)
+	FormatMarkdown Format = "markdown"
+	// FormatPlain is plain text without Markdown syntax, for clients that can't render it
+	FormatPlain Format = "plain"
+	// FormatJSON also splits the answer into its key points and the notes it cites
+	FormatJSON Format = "json"
+)
+
+// ParseFormat returns the Format named s; empty selects FormatMarkdown.
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(strings.ToLower(strings.TrimSpace(s))); f {
+	case "":
+		return FormatMarkdown, nil
+	case FormatMarkdown, FormatPlain, FormatJSON:
+		return f, nil
+	}
+	return "", fmt.Errorf("must be one of %s, %s or %s", FormatMarkdown, FormatPlain, FormatJSON)
+}
+
+// Citation is a note an answer drew on, with the passage it relied on if the LLM named one.
+// Commit, Author and CommitDate identify the version of the note that was embedded, when it
+// is one of the answer's sources and in git, to tell whether the answer is based on an
+// outdated version.
+type Citation struct {
+	Note       string `json:"note"`
+	Quote      string `json:"quote,omitempty"`
+	Commit     string `json:"commit,omitempty"`
+	Author     string `json:"author,omitempty"`
+	CommitDate string `json:"commit_date,omitempty"`
+}
+
+// newCitation cites note, adding the commit of src, the chunk the citation refers to, if any.
+func newCitation(note, quote string, src *vector.VectorData) Citation {
+	c := Citation{Note: note, Quote: quote}
+	if src != nil {
+		c.Commit = src.Metadata[git.CommitHashMetadataKey]
+		c.Author = src.Metadata[git.CommitAuthorMetadataKey]
+		c.CommitDate = src.Metadata[git.CommitDateMetadataKey]
+	}
+	return c
+}
+
+// StructuredAnswer is the part of a FormatJSON answer beyond its text.
+type StructuredAnswer struct {
+	Bullets   []string   `json:"bullets"`
+	Citations []Citation `json:"citations"`
+}
+
+// formatInstruction asks the LLM to write its answer in format; Markdown needs no asking.
+func formatInstruction(format Format) string {
+	switch format {
+	case FormatPlain:
+		return "\n\nWrite plain text only, without any Markdown: no headings, bold or italics, tables, code blocks or links. For lists, start each item on its own line with \"- \"."
+	case FormatJSON:
+		return `
+
+Respond with a single JSON object and nothing else, shaped like this:
+{"answer": "the answer in a few sentences", "bullets": ["one key point per entry"], "citations": [{"source": "the document number or note name you used", "quote": "the passage you relied on"}]}
+Write plain text without Markdown inside the strings, and leave citations empty if you used no notes.`
+	}
+	return ""
+}
+
+// formatAnswer turns what the LLM answered into format, returning the answer text and, for
+// FormatJSON, its structure. The prompt asked for the format already, so this mostly
+// cleans up where the LLM didn't comply; answers written without an LLM (note listings) are
+// converted entirely here. sources are the documents a RAG answer was given, so citations
+// of "Document n" can be resolved to the note's name.
+func formatAnswer(format Format, answer string, sources []vector.VectorData) (string, *StructuredAnswer) {
+	switch format {
+	case FormatPlain:
+		return stripMarkdown(answer), nil
+	case FormatJSON:
+		text, structured, ok := parseStructured(answer, sources)
+		if !ok {
+			text, structured = structureMarkdown(answer, sources)
+		}
+		return text, structured
+	}
+	return answer, nil
+}
+
+var (
+	reCodeFence  = regexp.MustCompile("(?m)^\\s*```[\\w-]*\\s*$\\n?")
+	reHeading    = regexp.MustCompile(`(?m)^\s{0,3}#{1,6}\s+`)
+	reBold       = regexp.MustCompile(`\*\*([^*\n]+)\*\*|__([^_\n]+)__`)
+	reItalic     = regexp.MustCompile(`(^|[^*\w])\*([^*\s][^*\n]*)\*`)
+	reInlineCode = regexp.MustCompile("`([^`\n]+)`")
+	reLink       = regexp.MustCompile(`\[([^\]\n]+)\]\(([^)\s]+)\)`)
+	reWikiLink   = regexp.MustCompile(`\[\[([^\]|\n]+)(?:\|([^\]\n]+))?\]\]`)
+	reListItem   = regexp.MustCompile(`^\s*(?:[-*+]|\d+[.)])\s+`)
+	reDocument   = regexp.MustCompile(`(?i)^\s*(?:document|doc\.?)\s*#?(\d+)\s*$`)
+)
+
+// stripMarkdown removes the Markdown syntax of text, keeping its words: headings, emphasis
+// and code become plain text, links their text (and URL), and list items lines starting
+// with "- ".
+func stripMarkdown(text string) string {
+	text = reCodeFence.ReplaceAllString(text, "")
+	text = reHeading.ReplaceAllString(text, "")
+	text = reBold.ReplaceAllString(text, "$1$2")
+	text = reItalic.ReplaceAllString(text, "$1$2")
+	text = reInlineCode.ReplaceAllString(text, "$1")
+	text = reLink.ReplaceAllString(text, "$1 ($2)")
+	text = reWikiLink.ReplaceAllStringFunc(text, func(link string) string {
+		m := reWikiLink.FindStringSubmatch(link)
+		if m[2] != "" {
+			return m[2]
+		}
+		return m[1]
+	})
+
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		if loc := reListItem.FindStringIndex(line); loc != nil && !strings.HasPrefix(strings.TrimSpace(line), "-") {
+			// numbered and starred items alike, so plain clients see one list style
+			lines[i] = line[:len(line)-len(strings.TrimLeft(line, " \t"))] + "- " + line[loc[1]:]
+		}
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+// parseStructured decodes an answer written as the JSON object formatInstruction asks for,
+// tolerating a code fence or text around it. ok is false if there is no such object.
+func parseStructured(answer string, sources []vector.VectorData) (string, *StructuredAnswer, bool) {
+	start, end := strings.Index(answer, "{"), strings.LastIndex(answer, "}")
+	if start < 0 || end < start {
+		return "", nil, false
+	}
+	var raw struct {
+		Answer    string   `json:"answer"`
+		Bullets   []string `json:"bullets"`
+		Citations []struct {
+			Source string `json:"source"`
+			Quote  string `json:"quote"`
+		} `json:"citations"`
+	}
+	if err := json.Unmarshal([]byte(answer[start:end+1]), &raw); err != nil || raw.Answer == "" {
+		return "", nil, false
+	}
+
+	structured := &StructuredAnswer{Bullets: []string{}, Citations: []Citation{}}
+	for _, b := range raw.Bullets {
+		if b = strings.TrimSpace(stripMarkdown(b)); b != "" {
+			structured.Bullets = append(structured.Bullets, b)
+		}
+	}
+	for _, c := range raw.Citations {
+		if note, src := citedNote(c.Source, sources); note != "" {
+			structured.Citations = append(structured.Citations, newCitation(note, strings.TrimSpace(c.Quote), src))
+		}
+	}
+	return stripMarkdown(raw.Answer), structured, true
+}
+
+// structureMarkdown splits a Markdown answer into its list items, as bullets, and the rest,
+// as plain text; the notes it links to are its citations.
+func structureMarkdown(answer string, sources []vector.VectorData) (string, *StructuredAnswer) {
+	structured := &StructuredAnswer{Bullets: []string{}, Citations: []Citation{}}
+	seen := map[string]bool{}
+	for _, m := range reWikiLink.FindAllStringSubmatch(answer, -1) {
+		if note, src := citedNote(m[1], sources); note != "" && !seen[note] {
+			seen[note] = true
+			structured.Citations = append(structured.Citations, newCitation(note, "", src))
+		}
+	}
+
+	var text []string
+	for _, line := range strings.Split(answer, "\n") {
+		if loc := reListItem.FindStringIndex(line); loc != nil {
+			structured.Bullets = append(structured.Bullets, stripMarkdown(line[loc[1]:]))
+			continue
+		}
+		text = append(text, line)
+	}
+	return stripMarkdown(strings.Join(text, "\n")), structured
+}
+
+// citedNote resolves the source of a citation, "Document n" of sources or a note's name or
+// file, to the note's name and the first of sources from that note, if any; the name is
+// empty for a document that wasn't given.
+func citedNote(source string, sources []vector.VectorData) (string, *vector.VectorData) {
+	if m := reDocument.FindStringSubmatch(source); m != nil {
+		n, _ := strconv.Atoi(m[1])
+		if n < 1 || n > len(sources) {
+			return "", nil
+		}
+		return noteName(sources[n-1].Metadata["filepath"]), &sources[n-1]
+	}
+	// names given by the LLM may contain dots of their own, so only a note's extension goes
+	source = strings.Trim(strings.TrimSpace(source), "[]")
+	if source == "" {
+		return "", nil
+	}
+	note := strings.TrimSuffix(filepath.Base(source), ".md")
+	for i := range sources {
+		if noteName(sources[i].Metadata["filepath"]) == note {
+			return note, &sources[i]
+		}
+	}
+	return note, nil
+}
+
+// noteName is the name of the note at path, its file name without the extension.
+func noteName(path string) string {
+	return strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+}
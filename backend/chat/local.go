@@ -0,0 +1,25 @@
+package chat
+
+import (
+	"strings"
+	"vex-backend/breaker"
+	"vex-backend/config"
+	"vex-backend/httpclient"
+)
+
+// localLLMBreaker fails chat requests fast once the local LLM server has failed repeatedly.
+var localLLMBreaker = breaker.New("local-llm")
+
+// newLocalChatter returns a chatter for a self-hosted server with an OpenAI-compatible API
+// (Ollama, llama.cpp server, LM Studio, vLLM) at LOCAL_LLM_BASE_URL, so prompts and notes
+// never leave the network. The base URL is the API root, e.g. http://localhost:11434/v1.
+func newLocalChatter(cfg *config.EnvConfig, client httpclient.Doer) chatter {
+	return &openAiChatter{
+		provider: "local LLM",
+		endpoint: strings.TrimRight(cfg.LocalLLMBaseURL, "/") + "/chat/completions",
+		apiKey:   cfg.LocalLLMAPIKey,
+		model:    cfg.LocalLLMModel,
+		breaker:  localLLMBreaker,
+		client:   client,
+	}
+}
@@ -0,0 +1,66 @@
+package chat
+
+import (
+	"context"
+	"log"
+
+	"vex-backend/config"
+	"vex-backend/debugtrace"
+	"vex-backend/vector"
+)
+
+// No-answer modes of NO_ANSWER_MODE
+const (
+	// NoAnswerSkip answers with a fixed message without calling the LLM
+	NoAnswerSkip = "skip"
+	// NoAnswerInstruct has the LLM say that the notes don't cover the question
+	NoAnswerInstruct = "instruct"
+)
+
+const noAnswerPrompt = `You are a helpful assistant answering questions from a personal knowledge base. A search of the notes found nothing relevant to the user's question.
+Say briefly that the notes don't cover this. Do not answer from general knowledge and do not guess. If it helps, suggest in one sentence what kind of note would answer the question.`
+
+// noAnswerMessages are the answers NoAnswerSkip gives, by the language asked for
+var noAnswerMessages = map[string]string{
+	"de": "Dazu habe ich in deinen Notizen nichts Passendes gefunden.",
+	"en": "I couldn't find anything in your notes about this.",
+	"es": "No he encontrado nada sobre esto en tus notas.",
+	"fr": "Je n'ai rien trouvé à ce sujet dans tes notes.",
+	"it": "Non ho trovato nulla su questo nelle tue note.",
+	"nl": "Ik heb hierover niets in je notities gevonden.",
+}
+
+// bestSimilarity returns the similarity of the chunk of results most similar to the query,
+// which after reranking needn't be the first.
+func bestSimilarity(results []vector.VectorData) float32 {
+	var best float32
+	for _, r := range results {
+		best = max(best, r.Similarity)
+	}
+	return best
+}
+
+// relevant reports whether results hold a chunk at least NO_ANSWER_SIMILARITY similar to the
+// query, so that an answer can be given from them.
+func relevant(cfg *config.EnvConfig, results []vector.VectorData) bool {
+	return len(results) > 0 && float64(bestSimilarity(results)) >= cfg.NoAnswerSimilarity
+}
+
+// answerNothingFound answers query when retrieval found nothing relevant, as NO_ANSWER_MODE
+// says: with a fixed message in the answer's language, or by having the LLM say that the
+// notes don't cover it instead of answering from chunks that don't.
+func answerNothingFound(ctx context.Context, cfg *config.EnvConfig, chat_platform chatter, query string, results []vector.VectorData, opts QueryOptions) (string, error) {
+	log.Printf("[Chat] nothing relevant found for %q (%d chunks, best similarity %.2f), answering with %s", query, len(results), bestSimilarity(results), cfg.NoAnswerMode)
+	if cfg.NoAnswerMode != NoAnswerInstruct {
+		if msg, ok := noAnswerMessages[opts.AnswerLanguage]; ok {
+			return msg, nil
+		}
+		return noAnswerMessages["en"], nil
+	}
+
+	systemPrompt := noAnswerPrompt + opts.instructions(cfg)
+	debugtrace.FromContext(ctx).SetPrompt(debugtrace.Message{Role: "system", Content: systemPrompt}, debugtrace.Message{Role: "user", Content: query})
+	stageCtx, done := debugtrace.StartStage(ctx, "no_answer")
+	defer done()
+	return chat_platform.GetResponseWithSystemPrompt(stageCtx, query, systemPrompt)
+}
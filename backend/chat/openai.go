@@ -1,6 +1,7 @@
 package chat
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
@@ -8,27 +9,101 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
+	"time"
+	"vex-backend/breaker"
 	"vex-backend/config"
+	"vex-backend/debugtrace"
+	"vex-backend/httpclient"
+	"vex-backend/usage"
+	"vex-backend/vector"
 )
 
+// openAIBreaker fails chat requests fast once OpenAI has failed repeatedly.
+var openAIBreaker = breaker.New("openai")
+
+// openAIEndpoint is the chat completions URL of the OpenAI API.
+const openAIEndpoint = "https://api.openai.com/v1/chat/completions"
+
+// openAiChatter talks to an OpenAI-compatible chat completions endpoint. Besides OpenAI
+// itself it serves local servers speaking the same protocol (see newLocalChatter).
 type openAiChatter struct {
-	model string
+	// provider names the backend in errors, e.g. "OpenAI"
+	provider string
+	endpoint string
+	apiKey   string
+	model    string
+	breaker  *breaker.Breaker
+	// recordUsage tracks token usage; off for providers that cost nothing per token
+	recordUsage bool
+	// client sends the API requests; nil uses httpclient.Default
+	client httpclient.Doer
 }
 
-func newOpenAIChatter() chatter {
+func newOpenAIChatter(cfg *config.EnvConfig, client httpclient.Doer) chatter {
 	return &openAiChatter{
-		model: "gpt-4o",
+		provider:    "OpenAI",
+		endpoint:    openAIEndpoint,
+		apiKey:      cfg.OpenAiAPIKey,
+		model:       cfg.OpenAIModel,
+		breaker:     openAIBreaker,
+		recordUsage: true,
+		client:      client,
 	}
 }
 
 type ChatMessage struct {
 	Role    string `json:"role"`
 	Content string `json:"content"`
+	// ToolCalls are the function calls requested by an assistant message
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+	// ToolCallID links a "tool" message to the call it answers
+	ToolCallID string `json:"tool_call_id,omitempty"`
+}
+
+// Tool describes a function the model may call (OpenAI function calling).
+type Tool struct {
+	Type     string       `json:"type"`
+	Function ToolFunction `json:"function"`
+}
+
+type ToolFunction struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	// Parameters is the JSON schema of the arguments object
+	Parameters map[string]any `json:"parameters"`
+}
+
+// ToolCall is a function call requested by the model.
+type ToolCall struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Function struct {
+		Name string `json:"name"`
+		// Arguments is a JSON object encoded as a string
+		Arguments string `json:"arguments"`
+	} `json:"function"`
 }
 
 type ChatCompletionRequest struct {
 	Model    string        `json:"model"`
 	Messages []ChatMessage `json:"messages"`
+	Tools    []Tool        `json:"tools,omitempty"`
+	// Stream asks for the answer as server-sent events of ChatCompletionChunk
+	Stream        bool           `json:"stream,omitempty"`
+	StreamOptions *StreamOptions `json:"stream_options,omitempty"`
+}
+
+type StreamOptions struct {
+	// IncludeUsage adds a final event carrying the token counts
+	IncludeUsage bool `json:"include_usage"`
+}
+
+// ChatCompletionUsage is the token count of a completion.
+type ChatCompletionUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
 }
 
 type ChatCompletionResponse struct {
@@ -37,18 +112,28 @@ type ChatCompletionResponse struct {
 	Created int64  `json:"created"`
 	Model   string `json:"model"`
 	Choices []struct {
-		Index   int `json:"index"`
-		Message struct {
-			Role    string `json:"role"`
+		Index        int         `json:"index"`
+		Message      ChatMessage `json:"message"`
+		FinishReason string      `json:"finish_reason"`
+	} `json:"choices"`
+	Usage ChatCompletionUsage `json:"usage"`
+	Error *struct {
+		Message string `json:"message"`
+		Type    string `json:"type"`
+		Code    string `json:"code"`
+	} `json:"error,omitempty"`
+}
+
+// ChatCompletionChunk is one event of a streamed completion.
+type ChatCompletionChunk struct {
+	Choices []struct {
+		Index int `json:"index"`
+		Delta struct {
 			Content string `json:"content"`
-		} `json:"message"`
+		} `json:"delta"`
 		FinishReason string `json:"finish_reason"`
 	} `json:"choices"`
-	Usage struct {
-		PromptTokens     int `json:"prompt_tokens"`
-		CompletionTokens int `json:"completion_tokens"`
-		TotalTokens      int `json:"total_tokens"`
-	} `json:"usage"`
+	Usage *ChatCompletionUsage `json:"usage,omitempty"`
 	Error *struct {
 		Message string `json:"message"`
 		Type    string `json:"type"`
@@ -99,47 +184,91 @@ func (oac openAiChatter) GetResponseWithSystemPrompt(ctx context.Context, query
 	return oac.makeRequest(ctx, reqBody)
 }
 
+// CompleteWithTools sends the conversation with the tools the model may call and returns
+// the assistant's reply, which either answers or requests tool calls.
+func (oac openAiChatter) CompleteWithTools(ctx context.Context, messages []ChatMessage, tools []Tool) (ChatMessage, error) {
+	return oac.complete(ctx, ChatCompletionRequest{
+		Model:    oac.model,
+		Messages: messages,
+		Tools:    tools,
+	})
+}
+
 // makeRequest is a helper function to make the HTTP request
 func (oac openAiChatter) makeRequest(ctx context.Context, reqBody ChatCompletionRequest) (string, error) {
+	msg, err := oac.complete(ctx, reqBody)
+	if err != nil {
+		return "", err
+	}
+	return msg.Content, nil
+}
+
+// post sends reqBody to the chat completions endpoint through the breaker and returns the
+// response, whose body the caller must close.
+func (oac openAiChatter) post(ctx context.Context, reqBody ChatCompletionRequest) (*http.Response, error) {
 	// Marshal request to JSON
 	jsonData, err := json.Marshal(reqBody)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal request: %w", err)
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
 	// Create HTTP request
-	req, err := http.NewRequestWithContext(ctx, "POST", "https://api.openai.com/v1/chat/completions", bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, "POST", oac.endpoint, bytes.NewBuffer(jsonData))
 	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
+		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	// Set headers
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", config.Config.OpenAiAPIKey))
+	// local servers usually run without authentication
+	if oac.apiKey != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", oac.apiKey))
+	}
+
+	if err := oac.breaker.Allow(); err != nil {
+		return nil, err
+	}
 
-	httpClient := http.Client{}
 	// Make the request
-	resp, err := httpClient.Do(req)
+	resp, err := httpclient.OrDefault(oac.client).Do(req)
+	oac.breaker.Record(ctx, resp, err)
 	if err != nil {
-		return "", fmt.Errorf("failed to make request: %w", err)
+		return nil, fmt.Errorf("failed to make request: %w", err)
+	}
+	return resp, nil
+}
+
+// complete sends a chat completion request and returns the first choice's message.
+func (oac openAiChatter) complete(ctx context.Context, reqBody ChatCompletionRequest) (msg ChatMessage, err error) {
+	var tokens ChatCompletionUsage
+	defer oac.recordCall(ctx, time.Now(), &tokens, &err)
+
+	resp, err := oac.post(ctx, reqBody)
+	if err != nil {
+		return ChatMessage{}, err
 	}
 	defer resp.Body.Close()
 
 	// Read response body
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", fmt.Errorf("failed to read response: %w", err)
+		return ChatMessage{}, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return ChatMessage{}, fmt.Errorf("%w: %s API returned status %d: %s", vector.ErrRateLimited, oac.provider, resp.StatusCode, string(body))
 	}
 
 	// Parse response
 	var completion ChatCompletionResponse
 	if err := json.Unmarshal(body, &completion); err != nil {
-		return "", fmt.Errorf("failed to parse response: %w", err)
+		return ChatMessage{}, fmt.Errorf("failed to parse response: %w", err)
 	}
 
 	// Check for API errors
 	if completion.Error != nil {
-		return "", fmt.Errorf("OpenAI API error: %s (type: %s, code: %s)",
+		return ChatMessage{}, fmt.Errorf("%s API error: %s (type: %s, code: %s)",
+			oac.provider,
 			completion.Error.Message,
 			completion.Error.Type,
 			completion.Error.Code)
@@ -147,13 +276,104 @@ func (oac openAiChatter) makeRequest(ctx context.Context, reqBody ChatCompletion
 
 	// Check HTTP status code
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("unexpected status code: %d, body: %s", resp.StatusCode, string(body))
+		return ChatMessage{}, fmt.Errorf("unexpected status code: %d, body: %s", resp.StatusCode, string(body))
+	}
+
+	tokens = completion.Usage
+	if oac.recordUsage {
+		usage.RecordOpenAI(ctx, completion.Usage.PromptTokens, completion.Usage.CompletionTokens)
 	}
 
 	// Check if we got a response
 	if len(completion.Choices) == 0 {
-		return "", errors.New("no response from OpenAI")
+		return ChatMessage{}, fmt.Errorf("no response from %s", oac.provider)
+	}
+
+	return completion.Choices[0].Message, nil
+}
+
+// StreamWithMessages sends the conversation as a streaming completion, passing each piece
+// of the answer to onDelta as it arrives, and returns the whole answer.
+func (oac openAiChatter) StreamWithMessages(ctx context.Context, messages []ChatMessage, onDelta func(string)) (_ string, err error) {
+	var tokens ChatCompletionUsage
+	defer oac.recordCall(ctx, time.Now(), &tokens, &err)
+
+	reqBody := ChatCompletionRequest{Model: oac.model, Messages: messages, Stream: true}
+	// token counts of a stream only come with the last event, and only when asked for
+	if oac.recordUsage {
+		reqBody.StreamOptions = &StreamOptions{IncludeUsage: true}
+	}
+
+	resp, err := oac.post(ctx, reqBody)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode == http.StatusTooManyRequests {
+			return "", fmt.Errorf("%w: %s API returned status %d: %s", vector.ErrRateLimited, oac.provider, resp.StatusCode, string(body))
+		}
+		return "", fmt.Errorf("unexpected status code: %d, body: %s", resp.StatusCode, string(body))
 	}
 
-	return completion.Choices[0].Message.Content, nil
+	// the body is a series of server-sent events, one JSON chunk per "data:" line
+	var answer strings.Builder
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue
+		}
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			break
+		}
+
+		var chunk ChatCompletionChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return answer.String(), fmt.Errorf("failed to parse stream event: %w", err)
+		}
+		if chunk.Error != nil {
+			return answer.String(), fmt.Errorf("%s API error: %s (type: %s, code: %s)",
+				oac.provider, chunk.Error.Message, chunk.Error.Type, chunk.Error.Code)
+		}
+		for _, choice := range chunk.Choices {
+			if choice.Delta.Content != "" {
+				answer.WriteString(choice.Delta.Content)
+				onDelta(choice.Delta.Content)
+			}
+		}
+		if chunk.Usage != nil {
+			tokens = *chunk.Usage
+			if oac.recordUsage {
+				usage.RecordOpenAI(ctx, chunk.Usage.PromptTokens, chunk.Usage.CompletionTokens)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return answer.String(), fmt.Errorf("failed to read stream: %w", err)
+	}
+	if answer.Len() == 0 {
+		return "", fmt.Errorf("no response from %s", oac.provider)
+	}
+	return answer.String(), nil
+}
+
+// recordCall adds a completion that started at start to the debug trace of ctx, with the
+// token counts and error it ended with.
+func (oac openAiChatter) recordCall(ctx context.Context, start time.Time, tokens *ChatCompletionUsage, err *error) {
+	call := debugtrace.Call{
+		Kind:             "chat",
+		Provider:         oac.provider,
+		Model:            oac.model,
+		PromptTokens:     tokens.PromptTokens,
+		CompletionTokens: tokens.CompletionTokens,
+	}
+	if *err != nil {
+		call.Error = (*err).Error()
+	}
+	debugtrace.RecordCall(ctx, start, call)
 }
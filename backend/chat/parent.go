@@ -0,0 +1,144 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"maps"
+	"strings"
+	"vex-backend/chunking"
+	"vex-backend/config"
+	"vex-backend/vector"
+	"vex-backend/vector/manager"
+)
+
+// ParentMode is what a retrieved chunk is expanded to before a RAG answer is given from it.
+type ParentMode string
+
+const (
+	// ParentOff answers from the chunks as retrieved
+	ParentOff ParentMode = "off"
+	// ParentSection expands a chunk to the Markdown sections it lies in, each running from a
+	// heading to the next
+	ParentSection ParentMode = "section"
+	// ParentNote expands a chunk to its whole note, or to its sections if the note doesn't
+	// fit the budget
+	ParentNote ParentMode = "note"
+)
+
+// ParentMetadataKey is set on a chunk expanded to its parent, to ParentSection or ParentNote
+const ParentMetadataKey = "parent_context"
+
+// ParseParentMode returns the ParentMode named s; empty leaves the choice to PARENT_CONTEXT.
+func ParseParentMode(s string) (ParentMode, error) {
+	switch m := ParentMode(strings.ToLower(strings.TrimSpace(s))); m {
+	case "", ParentOff, ParentSection, ParentNote:
+		return m, nil
+	}
+	return "", fmt.Errorf("must be one of %s, %s or %s", ParentOff, ParentSection, ParentNote)
+}
+
+// parent returns the parent mode of the query: the one asked for, or PARENT_CONTEXT.
+func (o QueryOptions) parent(cfg *config.EnvConfig) ParentMode {
+	if o.Parent != "" {
+		return o.Parent
+	}
+	return ParentMode(cfg.ParentContext)
+}
+
+// locateSnippet is how many bytes from the end of a chunk are looked for in its stitched note
+// when the chunk isn't found there whole; stitching drops overlaps from the start of chunks,
+// never from their end.
+const locateSnippet = 200
+
+// expandToParents replaces the content of results, best first, with their parent under mode,
+// while the parents fit PARENT_CONTEXT_TOKENS: the whole note or the sections of it each chunk
+// lies in, reassembled from the note's stored chunks by their offsets. A chunk whose parent
+// doesn't fit is kept as retrieved, and a chunk already contained in an earlier parent is
+// dropped, so the context doesn't repeat itself.
+func expandToParents(ctx context.Context, cfg *config.EnvConfig, vm manager.Manager, results []vector.VectorData, mode ParentMode) []vector.VectorData {
+	// the chunks themselves are always given, expanding only spends what is left
+	remaining := cfg.ParentContextTokens
+	for _, r := range results {
+		remaining -= approxTokens(r.Content)
+	}
+
+	notes := map[string]string{}
+	out := make([]vector.VectorData, 0, len(results))
+	expanded := 0
+next:
+	for _, r := range results {
+		for _, prev := range out {
+			if prev.Metadata[ParentMetadataKey] != "" && strings.Contains(prev.Content, r.Content) {
+				remaining += approxTokens(r.Content)
+				continue next
+			}
+		}
+
+		path := r.Metadata["filepath"]
+		note, ok := notes[path]
+		if !ok && path != "" {
+			chunks, err := vm.GetChunksByFile(ctx, path)
+			if err != nil {
+				log.Printf("[Chat] failed to get the chunks of %s to expand a chunk to its parent: %v", path, err)
+			}
+			note = stitchChunks(chunks)
+			notes[path] = note
+		}
+
+		for i, parent := range parentCandidates(note, r.Content, mode) {
+			if extra := approxTokens(parent) - approxTokens(r.Content); extra > 0 && extra <= remaining {
+				kind := ParentSection
+				if mode == ParentNote && i == 0 {
+					kind = ParentNote
+				}
+				remaining -= extra
+				r.Content = parent
+				r.Metadata = maps.Clone(r.Metadata)
+				r.Metadata[ParentMetadataKey] = string(kind)
+				expanded++
+				break
+			}
+		}
+		out = append(out, r)
+	}
+	log.Printf("[Chat] expanded %d of %d chunks to their parent (%s)", expanded, len(results), mode)
+	return out
+}
+
+// parentCandidates returns what chunk may be expanded to within note under mode, largest
+// first: the note itself for ParentNote, then the sections the chunk lies in. It returns none
+// if the chunk can't be found in the note.
+func parentCandidates(note, chunk string, mode ParentMode) []string {
+	start := strings.Index(note, chunk)
+	if start < 0 {
+		tail := chunk[max(0, len(chunk)-locateSnippet):]
+		if i := strings.Index(note, tail); i >= 0 {
+			start = max(0, i-(len(chunk)-len(tail)))
+		}
+	}
+	if start < 0 {
+		return nil
+	}
+	end := min(start+len(chunk), len(note))
+
+	var out []string
+	if mode == ParentNote {
+		out = append(out, note)
+	}
+	sectionStart, sectionEnd := start, end
+	for _, s := range chunking.Sections(note) {
+		if s.Start <= start && start < s.End {
+			sectionStart = s.Start
+		}
+		if s.Start < end && end <= s.End {
+			sectionEnd = s.End
+		}
+	}
+	return append(out, strings.TrimSpace(note[sectionStart:sectionEnd]))
+}
+
+// approxTokens estimates the tokens of s at about four characters per token.
+func approxTokens(s string) int {
+	return len(s) / 4
+}
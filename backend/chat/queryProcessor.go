@@ -2,14 +2,334 @@ package chat
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"slices"
+	"strings"
+	"time"
+	"vex-backend/config"
+	"vex-backend/debugtrace"
+	"vex-backend/flags"
+	"vex-backend/httpclient"
+	"vex-backend/importer"
+	"vex-backend/lang"
+	"vex-backend/vector"
+	"vex-backend/vector/embed"
 	"vex-backend/vector/manager"
 )
 
-func ProcessQuery(ctx context.Context, vm manager.Manager, query string) (string, error) {
-	chat_platform := newOpenAIChatter()
+// QueryOptions tunes how ProcessQuery retrieves and answers.
+type QueryOptions struct {
+	// Tags restricts retrieval to chunks carrying all of the given tags
+	Tags []string
+	// Recency blends document age into the ranking so newer notes win close calls
+	Recency bool
+	// Agent lets the LLM gather its own context through tool calls instead of a single
+	// retrieve-then-answer pass
+	Agent bool
+	// Paths restricts retrieval to notes under a path prefix and/or matching a glob
+	Paths manager.PathFilter
+	// Dates restricts retrieval to notes committed or modified within the range
+	Dates manager.DateRange
+	// AsOf answers from the notes as they were at that time, if set; the collection must
+	// keep its history (VERSION_HISTORY)
+	AsOf time.Time
+	// Language restricts retrieval to chunks detected as this ISO 639-1 language
+	Language string
+	// AnswerLanguage is the ISO 639-1 language the answer is written in, quoted passages
+	// included; if empty it is detected from the question, and left to the LLM if that fails
+	AnswerLanguage string
+	// Format is the shape of the answer; empty is FormatMarkdown
+	Format Format
+	// Persona replaces ANSWER_PERSONA for this query, e.g. to try out a different tone
+	Persona string
+	// Channels restricts retrieval to the conversations of these Slack channels
+	Channels []string
+	// Strategy is how the chunks of a RAG answer are put before the LLM; empty is
+	// ANSWER_STRATEGY
+	Strategy Strategy
+	// Parent is what the chunks of a RAG answer are expanded to; empty is PARENT_CONTEXT
+	Parent ParentMode
+}
+
+// where builds the metadata filter for the options, or nil if retrieval is unscoped.
+func (o QueryOptions) where() map[string]string {
+	if len(o.Tags) == 0 {
+		return nil
+	}
+	where := make(map[string]string, len(o.Tags))
+	for _, tag := range o.Tags {
+		if tag = embed.NormalizeTag(tag); tag != "" {
+			where[embed.TagMetadataPrefix+tag] = "true"
+		}
+	}
+	return where
+}
+
+// scope wraps vm so that retrieval only sees the notes selected by the as-of, path, date,
+// channel and language options.
+func (o QueryOptions) scope(vm manager.Manager) (manager.Manager, error) {
+	// innermost, since it needs the collection itself to find its history
+	if !o.AsOf.IsZero() {
+		past, err := manager.AsOf(vm, o.AsOf)
+		if err != nil {
+			return nil, err
+		}
+		vm = past
+	}
+	if !o.Paths.IsZero() {
+		filtered, err := manager.WithPathFilter(vm, o.Paths)
+		if err != nil {
+			return nil, err
+		}
+		vm = filtered
+	}
+	if !o.Dates.IsZero() {
+		vm = manager.WithDateRange(vm, o.Dates)
+	}
+	if len(o.Channels) > 0 {
+		channels := make([]string, len(o.Channels))
+		for i, c := range o.Channels {
+			channels[i] = importer.NormalizeSlackChannel(c)
+		}
+		vm = manager.WithPostFilter(vm, func(v vector.VectorData) bool {
+			return slices.Contains(channels, v.Metadata[importer.SlackChannelMetadataKey])
+		})
+	}
+	// outermost, since it embeds queries with the language's model
+	if o.Language != "" {
+		vm = manager.WithLanguage(vm, o.Language)
+	}
+	return vm, nil
+}
+
+// QueryResult is the outcome of ProcessQuery.
+type QueryResult struct {
+	Answer string
+	// Route is the pipeline that produced the answer
+	Route Route
+	// Provider is the chat provider that generated the answer; empty if no LLM was involved
+	Provider string
+	// Trace lists the tool calls made in agent mode
+	Trace []ToolStep
+	// AnswerLanguage is the language the answer was asked for, empty if none was
+	AnswerLanguage string
+	// Structured holds the key points and citations of a FormatJSON answer, nil otherwise
+	Structured *StructuredAnswer
+	// Grounding is the verdict of the hallucination check, nil if it didn't run
+	Grounding *Grounding
+	// Flags are the feature flags the query ran with
+	Flags map[string]bool
+	// NoAnswer is whether retrieval found nothing relevant enough to answer from, so the
+	// answer only says so (see NO_ANSWER_MODE)
+	NoAnswer bool
+	// Strategy is the context strategy of a RAG answer, empty on the other routes
+	Strategy Strategy
+}
+
+// ProcessQuery classifies the query and answers it through the matching route:
+// directly with the LLM, with retrieval-augmented generation, or with a note listing.
+// In agent mode classification is skipped and the LLM drives retrieval with tool calls.
+// cfg supplies the model, prompts and ranking for this query; client (nil for the shared
+// default) sends the LLM requests.
+func ProcessQuery(ctx context.Context, cfg *config.EnvConfig, client httpclient.Doer, vm manager.Manager, query string, opts QueryOptions) (QueryResult, error) {
+	vm, err := opts.scope(vm)
+	if err != nil {
+		return QueryResult{}, err
+	}
+	chat_platform := newChatter(cfg, client)
+	if opts.AnswerLanguage == "" {
+		opts.AnswerLanguage = answerLanguage(query)
+	}
+
+	route := classifyQuery(query)
+	if opts.Agent {
+		route = RouteAgent
+	}
+
+	var answer string
+	var sources []vector.VectorData
+	var trace []ToolStep
+	var noAnswer bool
+	var strategy Strategy
+	switch route {
+	case RouteAgent:
+		stageCtx, done := debugtrace.StartStage(ctx, "agent")
+		answer, trace, err = answerWithAgent(stageCtx, cfg, chat_platform, vm, query, opts)
+		done()
+	case RouteDirect:
+		stageCtx, done := debugtrace.StartStage(ctx, "answer")
+		answer, err = answerDirect(stageCtx, chat_platform, query, opts.instructions(cfg))
+		done()
+	case RouteMetadata:
+		stageCtx, done := debugtrace.StartStage(ctx, "list_notes")
+		answer, err = listMatchingNotes(stageCtx, cfg, vm, query, opts)
+		done()
+	default:
+		strategy = opts.strategy(cfg)
+		answer, sources, noAnswer, err = answerWithRAG(ctx, cfg, chat_platform, vm, query, opts)
+	}
+	if err != nil {
+		return QueryResult{}, err
+	}
+	provider := chat_platform.answeredBy
+
+	var grounding *Grounding
+	if len(sources) > 0 && flags.Enabled(ctx, cfg, flags.HallucinationCheck) {
+		stageCtx, done := debugtrace.StartStage(ctx, "hallucination_check")
+		grounding = checkGrounding(stageCtx, chat_platform, answer, sources)
+		done()
+	}
+
+	answer, structured := formatAnswer(opts.Format, answer, sources)
+	return QueryResult{Answer: answer, Route: route, Provider: provider, Trace: trace, AnswerLanguage: opts.AnswerLanguage, Structured: structured, Grounding: grounding, Flags: flags.Effective(ctx, cfg), NoAnswer: noAnswer, Strategy: strategy}, nil
+}
+
+// instructions are what the options add to the system prompt of every route answered by
+// the LLM: the persona, then the answer's language and format, which it mustn't override.
+func (o QueryOptions) instructions(cfg *config.EnvConfig) string {
+	persona := cfg.AnswerPersona
+	if o.Persona != "" {
+		persona = o.Persona
+	}
+	return personaInstruction(persona) + languageInstruction(o.AnswerLanguage) + formatInstruction(o.Format)
+}
+
+// personaInstruction merges a persona into a system prompt, or is empty if there is none.
+func personaInstruction(persona string) string {
+	if persona = strings.TrimSpace(persona); persona == "" {
+		return ""
+	}
+	return "\n\nPersona and style:\n" + persona
+}
+
+// answerLanguage detects the language of the question, or returns "" if it is too short or
+// too mixed to tell.
+func answerLanguage(query string) string {
+	if l := lang.Detect(query); l != lang.Unknown {
+		return l
+	}
+	return ""
+}
+
+// languageInstruction asks the LLM to answer in language, or is empty if language is.
+// Passages quoted from the notes are translated as well, so a bilingual vault doesn't
+// produce answers that switch language with every citation.
+func languageInstruction(language string) string {
+	if language == "" {
+		return ""
+	}
+	name := lang.Name(language)
+	return fmt.Sprintf("\n\nWrite your answer in %s. When you quote or cite passages from the notes, give them in %s too, translating them if they are written in another language, but keep note and file names as they are.", name, name)
+}
+
+// Retrieve returns the n chunks most relevant to query under opts, without involving an
+// LLM: the query is embedded as given instead of being rewritten first. It is the
+// retrieval step of a RAG answer on its own, for checking what a question would find.
+func Retrieve(ctx context.Context, cfg *config.EnvConfig, vm manager.Manager, query string, n int, opts QueryOptions) ([]vector.VectorData, error) {
+	vm, err := opts.scope(vm)
+	if err != nil {
+		return nil, err
+	}
+	return retrieve(ctx, cfg, vm, query, n, opts)
+}
+
+// retrieve queries the already scoped vm for the n chunks most relevant to query.
+func retrieve(ctx context.Context, cfg *config.EnvConfig, vm manager.Manager, query string, n int, opts QueryOptions) ([]vector.VectorData, error) {
+	var results []vector.VectorData
+	var err error
+	if opts.Recency {
+		results, err = vm.RetriveNVectorsByQueryRanked(ctx, query, n, opts.where(), manager.RankOptionsFrom(cfg))
+	} else {
+		results, err = vm.RetriveNVectorsByQueryWithFilter(ctx, query, n, opts.where())
+	}
+	// an empty collection just means there is nothing to answer from yet
+	if errors.Is(err, vector.ErrEmptyCollection) {
+		return nil, nil
+	}
+	return results, err
+}
+
+// answerWithRAG retrieves relevant chunks and has the LLM answer from them with the query's
+// context strategy, returning the answer and the chunks, in the order the prompts numbered
+// them. If none of the chunks is
+// relevant enough, the answer only says that nothing was found, noAnswer is true and no
+// chunks are returned.
+func answerWithRAG(ctx context.Context, cfg *config.EnvConfig, chat_platform chatter, vm manager.Manager, query string, opts QueryOptions) (answer string, sources []vector.VectorData, noAnswer bool, err error) {
+	t := debugtrace.FromContext(ctx)
 
 	// Step 1: Use the chatter to translate the query into a better vector database query
+	stageCtx, done := debugtrace.StartStage(ctx, "optimize_query")
+	optimizedQuery := optimizeQuery(stageCtx, cfg, chat_platform, query)
+	done()
+	t.SetOptimizedQuery(optimizedQuery)
+
+	// Step 2: Query the vector database for the most relevant results, as many as the
+	// strategy answers from
+	strategy := opts.strategy(cfg)
+	results, err := retrieveForAnswer(ctx, cfg, chat_platform, vm, query, optimizedQuery, strategy.chunks(cfg), opts)
+	if err != nil {
+		return "", nil, false, err
+	}
+	traceChunks(ctx, results)
+
+	// Nothing relevant enough: rather than have the LLM make do with unrelated chunks, say so
+	if !relevant(cfg, results) {
+		msg, err := answerNothingFound(ctx, cfg, chat_platform, query, results, opts)
+		if err != nil {
+			return "", nil, false, err
+		}
+		return msg, nil, true, nil
+	}
+
+	// Matched on small chunks, answered from what surrounds them
+	results = withWindows(results)
+	if mode := opts.parent(cfg); mode != ParentOff {
+		stageCtx, done := debugtrace.StartStage(ctx, "expand_parents")
+		results = expandToParents(stageCtx, cfg, vm, results, mode)
+		done()
+	}
+
+	// Steps 3 and 4 with many chunks: have the LLM work through them in batches
+	if strategy != StrategyStuff {
+		answerFrom := answerMapReduce
+		if strategy == StrategyRefine {
+			answerFrom = answerRefine
+		}
+		response, err := answerFrom(ctx, cfg, chat_platform, query, results, opts)
+		if err != nil {
+			return "", nil, false, err
+		}
+		return response, results, false, nil
+	}
+
+	// Steps 3 and 4: Use the chatter with the retrieved context to generate the final answer
+	systemPrompt := answerPrompt(cfg, results) + opts.instructions(cfg)
+	t.SetPrompt(debugtrace.Message{Role: "system", Content: systemPrompt}, debugtrace.Message{Role: "user", Content: query})
+	stageCtx, done = debugtrace.StartStage(ctx, "answer")
+	response, err := chat_platform.GetResponseWithSystemPrompt(stageCtx, query, systemPrompt)
+	done()
+	if err != nil {
+		return "", nil, false, err
+	}
+
+	return response, results, false, nil
+}
+
+// traceChunks adds retrieved chunks to the debug trace of ctx, if any.
+func traceChunks(ctx context.Context, results []vector.VectorData) {
+	t := debugtrace.FromContext(ctx)
+	if t == nil {
+		return
+	}
+	for _, r := range results {
+		t.AddChunks(debugtrace.Chunk{ID: r.Id, Path: r.Metadata["filepath"], Similarity: r.Similarity, Content: r.Content, Metadata: r.Metadata})
+	}
+}
+
+// optimizeQuery has the LLM turn a question into search terms for the vector database,
+// falling back to the question itself if that fails.
+func optimizeQuery(ctx context.Context, cfg *config.EnvConfig, chat_platform chatter, query string) string {
 	queryOptimizationPrompt := `You are a search query optimizer. Your job is to take a user's question and convert it into the best possible search terms for a vector database containing notes and documentation.
 
 Rules:
@@ -20,32 +340,44 @@ Rules:
 - Return only the optimized search terms, no explanation
 
 Convert this user question into optimized search terms:`
+	if cfg.QueryOptimizationPrompt != "" {
+		queryOptimizationPrompt = cfg.QueryOptimizationPrompt
+	}
 
 	optimizedQuery, err := chat_platform.GetResponseWithSystemPrompt(ctx, query, queryOptimizationPrompt)
 	if err != nil {
 		// Fallback to original query if optimization fails
-		optimizedQuery = query
-	}
-
-	// Step 2: Query the vector database for top 4 relevant results
-	results, err := vm.RetriveNVectorsByQuery(ctx, optimizedQuery, 4)
-	if err != nil {
-		return "", err
+		return query
 	}
+	return optimizedQuery
+}
 
-	// Step 3: Build context from the retrieved results
+// answerPrompt builds the system prompt that has the LLM answer from results, which it
+// refers to as Document 1, 2 and so on.
+func answerPrompt(cfg *config.EnvConfig, results []vector.VectorData) string {
+	// Build context from the retrieved results
 	var context string
 	if len(results) == 0 {
 		context = "No relevant information found in the knowledge base."
 	} else {
-		context = "Relevant information from the knowledge base:\n\n"
-		for i, result := range results {
-			context += fmt.Sprintf("--- Document %d ---\n%s\n\n", i+1, result.Content)
-		}
+		context = "Relevant information from the knowledge base:\n\n" + documentContext(results, 1)
 	}
+	return answerInstructions(cfg) + context
+}
 
-	// Step 4: Use the chatter with system prompt to generate final answer
-	answerPrompt := `You are a helpful assistant that answers questions using the provided knowledge base information.
+// documentContext lists results for a prompt as Document first, first+1 and so on.
+func documentContext(results []vector.VectorData, first int) string {
+	var b strings.Builder
+	for i, result := range results {
+		fmt.Fprintf(&b, "--- Document %d ---\n%s\n\n", first+i, result.Content)
+	}
+	return b.String()
+}
+
+// answerInstructions is the start of the answer prompt, ANSWER_PROMPT or the built-in one,
+// which the context it answers from is appended to.
+func answerInstructions(cfg *config.EnvConfig) string {
+	prompt := `You are a helpful assistant that answers questions using the provided knowledge base information.
 
 Instructions:
 - Use the provided context to answer the user's question
@@ -57,12 +389,9 @@ Instructions:
 - If you are going to use math equations, make sure to put like so $${math}$$ or ${math}$, this way the formatting will be done correctly
 
 Context:
-` + context
-
-	response, err := chat_platform.GetResponseWithSystemPrompt(ctx, query, answerPrompt)
-	if err != nil {
-		return "", err
+`
+	if cfg.AnswerPrompt != "" {
+		prompt = cfg.AnswerPrompt + "\n\nContext:\n"
 	}
-
-	return response, nil
+	return prompt
 }
@@ -0,0 +1,118 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"vex-backend/config"
+	"vex-backend/debugtrace"
+	"vex-backend/vector"
+	"vex-backend/vector/manager"
+)
+
+// Route is the pipeline a query is answered through.
+type Route string
+
+const (
+	// RouteDirect answers with the LLM alone (chit-chat, questions about the assistant)
+	RouteDirect Route = "direct"
+	// RouteRAG answers with the LLM using retrieved notes as context
+	RouteRAG Route = "rag"
+	// RouteMetadata lists matching notes instead of generating an answer
+	RouteMetadata Route = "metadata"
+	// RouteAgent lets the LLM retrieve context itself through tool calls (requested explicitly)
+	RouteAgent Route = "agent"
+)
+
+// metadataListLimit is how many chunks are inspected when listing matching notes
+const metadataListLimit = 20
+
+var (
+	reListNotes = regexp.MustCompile(`(?i)^\s*(please\s+)?(list|show|find|which|what)\b.*\b(notes?|files?|documents?|pages?)\b.*\b(about|on|regarding|mention(s|ing)?|tagged|with|related to)\b`)
+	reSmallTalk = regexp.MustCompile(`(?i)^\s*(hi|hey|hello|yo|thanks|thank you|thx|good (morning|afternoon|evening|night)|bye|goodbye|ok(ay)?|cool|nice)\b[\s!.?]*$`)
+	reAboutBot  = regexp.MustCompile(`(?i)\b(who|what) are you\b|\bwhat can you do\b|\bhow do you work\b|\bare you (an? )?(ai|bot|human)\b`)
+)
+
+// classifyQuery picks a route with cheap heuristics so that routing adds no LLM round trip.
+// Anything that is not clearly small talk or a note listing request goes through RAG.
+func classifyQuery(query string) Route {
+	q := strings.TrimSpace(query)
+	switch {
+	case reListNotes.MatchString(q):
+		return RouteMetadata
+	case reSmallTalk.MatchString(q), reAboutBot.MatchString(q):
+		return RouteDirect
+	default:
+		return RouteRAG
+	}
+}
+
+// answerDirect answers without consulting the knowledge base; instructions are appended to
+// its system prompt.
+func answerDirect(ctx context.Context, chat_platform chatter, query, instructions string) (string, error) {
+	directPrompt := `You are V_E_X, a friendly assistant that answers questions using the user's personal Obsidian notes.
+This message does not need the notes: reply briefly and conversationally. If asked what you can do, explain that you search the user's notes and answer questions from them.` + instructions
+
+	debugtrace.FromContext(ctx).SetPrompt(debugtrace.Message{Role: "system", Content: directPrompt}, debugtrace.Message{Role: "user", Content: query})
+	return chat_platform.GetResponseWithSystemPrompt(ctx, query, directPrompt)
+}
+
+// listMatchingNotes answers a "list my notes about X" query with the notes whose chunks
+// best match the query, most relevant first, without involving the LLM.
+func listMatchingNotes(ctx context.Context, cfg *config.EnvConfig, vm manager.Manager, query string, opts QueryOptions) (string, error) {
+	var results []vector.VectorData
+	var err error
+	if opts.Recency {
+		results, err = vm.RetriveNVectorsByQueryRanked(ctx, query, metadataListLimit, opts.where(), manager.RankOptionsFrom(cfg))
+	} else {
+		results, err = vm.RetriveNVectorsByQueryWithFilter(ctx, query, metadataListLimit, opts.where())
+	}
+	// an empty collection just means there is nothing to answer from yet
+	if errors.Is(err, vector.ErrEmptyCollection) {
+		results, err = nil, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	traceChunks(ctx, results)
+
+	seen := map[string]bool{}
+	var b strings.Builder
+	for _, r := range results {
+		path := r.Metadata["filepath"]
+		if path == "" || seen[path] {
+			continue
+		}
+		seen[path] = true
+
+		name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		fmt.Fprintf(&b, "- [[%s]]", name)
+		if tags := r.Metadata["tags"]; tags != "" {
+			fmt.Fprintf(&b, " (tags: %s)", tags)
+		}
+		b.WriteString("\n")
+	}
+
+	text, ok := listingTexts[opts.AnswerLanguage]
+	if !ok {
+		text = listingTexts["en"]
+	}
+	if len(seen) == 0 {
+		return text.none, nil
+	}
+	return fmt.Sprintf("%s\n\n%s", text.header, b.String()), nil
+}
+
+// listingTexts are the sentences of a note listing per answer language; it isn't written by
+// the LLM, so it is translated here.
+var listingTexts = map[string]struct{ header, none string }{
+	"en": {"Notes matching your request:", "I couldn't find any notes matching that."},
+	"de": {"Notizen zu deiner Anfrage:", "Ich konnte keine passenden Notizen finden."},
+	"fr": {"Notes correspondant à ta demande :", "Je n'ai trouvé aucune note correspondante."},
+	"es": {"Notas que coinciden con tu solicitud:", "No encontré ninguna nota que coincida."},
+	"it": {"Note corrispondenti alla tua richiesta:", "Non ho trovato note corrispondenti."},
+	"nl": {"Notities die bij je verzoek passen:", "Ik heb geen passende notities gevonden."},
+}
@@ -0,0 +1,85 @@
+package chat
+
+import (
+	"context"
+	"vex-backend/config"
+	"vex-backend/httpclient"
+	"vex-backend/vector"
+	"vex-backend/vector/manager"
+)
+
+// maxHistory is how many earlier messages (questions and answers) a Session sends along
+// with each question
+const maxHistory = 10
+
+// Session is a conversation about the notes: every question retrieves its own context and
+// is answered with the earlier turns as history, so follow-up questions work.
+type Session struct {
+	cfg     *config.EnvConfig
+	vm      manager.Manager
+	opts    QueryOptions
+	chatter *fallbackChatter
+	history []ChatMessage
+}
+
+// Turn is the outcome of one question of a Session.
+type Turn struct {
+	Answer string
+	// Sources are the chunks the answer was given from, in the order the LLM saw them as
+	// Document 1, 2 and so on
+	Sources []vector.VectorData
+	// Provider is the chat provider that generated the answer
+	Provider string
+}
+
+// NewSession starts a conversation retrieving from vm under opts. cfg and client are used
+// for every question, as by ProcessQuery; opts.Agent is ignored.
+func NewSession(cfg *config.EnvConfig, client httpclient.Doer, vm manager.Manager, opts QueryOptions) (*Session, error) {
+	vm, err := opts.scope(vm)
+	if err != nil {
+		return nil, err
+	}
+	return &Session{cfg: cfg, vm: vm, opts: opts, chatter: newChatter(cfg, client)}, nil
+}
+
+// Ask answers question through retrieval-augmented generation, passing the answer to
+// onDelta while it is generated. The answer is only added to the history once complete.
+func (s *Session) Ask(ctx context.Context, question string, onDelta func(string)) (Turn, error) {
+	// a follow-up ("and in 2023?") means little on its own, so the previous question
+	// is searched for along with it
+	search := question
+	for i := len(s.history) - 1; i >= 0; i-- {
+		if s.history[i].Role == "user" {
+			search = s.history[i].Content + "\n" + question
+			break
+		}
+	}
+
+	results, err := retrieve(ctx, s.cfg, s.vm, optimizeQuery(ctx, s.cfg, s.chatter, search), s.cfg.RAGTopK, s.opts)
+	if err != nil {
+		return Turn{}, err
+	}
+
+	messages := make([]ChatMessage, 0, len(s.history)+2)
+	messages = append(messages, ChatMessage{Role: "system", Content: answerPrompt(s.cfg, results) + s.opts.instructions(s.cfg)})
+	messages = append(messages, s.history...)
+	messages = append(messages, ChatMessage{Role: "user", Content: question})
+
+	answer, err := s.chatter.Stream(ctx, messages, onDelta)
+	if err != nil {
+		return Turn{}, err
+	}
+
+	s.history = append(s.history,
+		ChatMessage{Role: "user", Content: question},
+		ChatMessage{Role: "assistant", Content: answer})
+	if len(s.history) > maxHistory {
+		s.history = s.history[len(s.history)-maxHistory:]
+	}
+	return Turn{Answer: answer, Sources: results, Provider: s.chatter.answeredBy}, nil
+}
+
+// Reset forgets the earlier turns.
+func (s *Session) Reset() {
+	s.history = nil
+}
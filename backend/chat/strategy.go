@@ -0,0 +1,141 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"vex-backend/config"
+	"vex-backend/debugtrace"
+	"vex-backend/vector"
+)
+
+// Strategy is how the chunks retrieved for a RAG answer are put before the LLM.
+type Strategy string
+
+const (
+	// StrategyStuff puts every chunk into a single prompt
+	StrategyStuff Strategy = "stuff"
+	// StrategyMapReduce has the LLM take notes on each batch of chunks, then answer from
+	// the notes
+	StrategyMapReduce Strategy = "map_reduce"
+	// StrategyRefine answers from the first batch of chunks, then has the LLM refine the
+	// answer with each following batch
+	StrategyRefine Strategy = "refine"
+)
+
+// ParseStrategy returns the Strategy named s; empty leaves the choice to ANSWER_STRATEGY.
+func ParseStrategy(s string) (Strategy, error) {
+	switch st := Strategy(strings.ToLower(strings.TrimSpace(s))); st {
+	case "", StrategyStuff, StrategyMapReduce, StrategyRefine:
+		return st, nil
+	}
+	return "", fmt.Errorf("must be one of %s, %s or %s", StrategyStuff, StrategyMapReduce, StrategyRefine)
+}
+
+// strategy returns the strategy of the query: the one asked for, or ANSWER_STRATEGY.
+func (o QueryOptions) strategy(cfg *config.EnvConfig) Strategy {
+	if o.Strategy != "" {
+		return o.Strategy
+	}
+	return Strategy(cfg.AnswerStrategy)
+}
+
+// chunks returns how many chunks are retrieved for an answer with strategy s: RAG_TOP_K for
+// StrategyStuff, which must fit them into one prompt, and ANSWER_STRATEGY_TOP_K for the
+// others, which only ever put RAG_TOP_K of them into one.
+func (s Strategy) chunks(cfg *config.EnvConfig) int {
+	if s == StrategyStuff {
+		return cfg.RAGTopK
+	}
+	return max(cfg.AnswerStrategyTopK, cfg.RAGTopK)
+}
+
+const mapPrompt = `You take notes for answering the user's question from part of a personal knowledge base. The documents below are numbered.
+Write down every fact from the documents that helps answer the question as concise bullet points, each naming the document it comes from as "Document n".
+Don't answer the question itself and don't add information that isn't in the documents. If none of the documents helps, reply with NONE and nothing else.
+
+Documents:
+`
+
+const refinePrompt = `You refine an answer to the user's question using more documents from a personal knowledge base.
+
+The answer so far:
+%s
+
+Improve the answer with the new documents below where they add to or correct it, and keep its references to documents as "Document n". Don't add information that isn't in the documents or the answer. If the new documents add nothing, return the answer unchanged. Return only the refined answer.
+
+New documents:
+`
+
+// noNotes is what the map step replies for a batch without anything relevant
+const noNotes = "NONE"
+
+// answerMapReduce answers query from results with StrategyMapReduce: the LLM takes notes on
+// each batch of RAG_TOP_K chunks, then answers from the notes of all batches. The chunks are
+// numbered across batches, so the notes and the answer cite them as Document n like a
+// stuffed prompt does.
+func answerMapReduce(ctx context.Context, cfg *config.EnvConfig, chat_platform chatter, query string, results []vector.VectorData, opts QueryOptions) (string, error) {
+	var notes []string
+	all := batches(results, cfg.RAGTopK)
+	for i, batch := range all {
+		stageCtx, done := debugtrace.StartStage(ctx, "map")
+		note, err := chat_platform.GetResponseWithSystemPrompt(stageCtx, query, mapPrompt+documentContext(batch, i*cfg.RAGTopK+1))
+		done()
+		if err != nil {
+			return "", err
+		}
+		if note = strings.TrimSpace(note); note != "" && !strings.EqualFold(strings.Trim(note, ". "), noNotes) {
+			notes = append(notes, note)
+		}
+	}
+	log.Printf("[Chat] map-reduce took notes on %d of %d batches of chunks", len(notes), len(all))
+
+	context := "No relevant information found in the knowledge base."
+	if len(notes) > 0 {
+		context = "Notes taken from the knowledge base, naming the documents they come from:\n\n" + strings.Join(notes, "\n\n")
+	}
+	systemPrompt := answerInstructions(cfg) + context + opts.instructions(cfg)
+	debugtrace.FromContext(ctx).SetPrompt(debugtrace.Message{Role: "system", Content: systemPrompt}, debugtrace.Message{Role: "user", Content: query})
+	stageCtx, done := debugtrace.StartStage(ctx, "reduce")
+	defer done()
+	return chat_platform.GetResponseWithSystemPrompt(stageCtx, query, systemPrompt)
+}
+
+// answerRefine answers query from results with StrategyRefine: the LLM answers from the first
+// batch of RAG_TOP_K chunks like a stuffed prompt, then refines the answer with each following
+// batch, numbered on from the ones before.
+func answerRefine(ctx context.Context, cfg *config.EnvConfig, chat_platform chatter, query string, results []vector.VectorData, opts QueryOptions) (string, error) {
+	t := debugtrace.FromContext(ctx)
+	var answer string
+	for i, batch := range batches(results, cfg.RAGTopK) {
+		stage := "refine"
+		systemPrompt := fmt.Sprintf(refinePrompt, answer) + documentContext(batch, i*cfg.RAGTopK+1)
+		if i == 0 {
+			stage = "answer"
+			systemPrompt = answerPrompt(cfg, batch)
+		}
+		systemPrompt += opts.instructions(cfg)
+		t.SetPrompt(debugtrace.Message{Role: "system", Content: systemPrompt}, debugtrace.Message{Role: "user", Content: query})
+
+		stageCtx, done := debugtrace.StartStage(ctx, stage)
+		refined, err := chat_platform.GetResponseWithSystemPrompt(stageCtx, query, systemPrompt)
+		done()
+		if err != nil {
+			return "", err
+		}
+		if refined = strings.TrimSpace(refined); refined != "" {
+			answer = refined
+		}
+	}
+	return answer, nil
+}
+
+// batches splits results into batches of size chunks, in order.
+func batches(results []vector.VectorData, size int) [][]vector.VectorData {
+	var out [][]vector.VectorData
+	for first := 0; first < len(results); first += size {
+		out = append(out, results[first:min(first+size, len(results))])
+	}
+	return out
+}
@@ -0,0 +1,80 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"vex-backend/config"
+	"vex-backend/httpclient"
+)
+
+// suggestExcerptLimit caps each note excerpt shown to the LLM
+const suggestExcerptLimit = 500
+
+const suggestPrompt = `You help people get started with a personal knowledge base. The input lists some of its notes,
+each with an excerpt. Write example questions a user could ask that these notes answer: short, natural and
+specific to what the notes actually say, each about a single note, and spread across different notes.
+Respond with a JSON array only, no code fences, of objects like {"question": "...", "note": 1}, where note
+is the number of the note that answers the question.`
+
+// NoteExcerpt is a note shown to the LLM when suggesting questions.
+type NoteExcerpt struct {
+	Title   string
+	Excerpt string
+}
+
+// SuggestedQuestion is an example question and the index into the given notes of the note
+// answering it.
+type SuggestedQuestion struct {
+	Question string
+	Note     int
+}
+
+// SuggestQuestions asks the LLM for up to n example questions answered by notes, in a single
+// call, returning them and the provider that answered. Questions naming a note that wasn't
+// given are dropped.
+func SuggestQuestions(ctx context.Context, cfg *config.EnvConfig, client httpclient.Doer, notes []NoteExcerpt, n int) ([]SuggestedQuestion, string, error) {
+	if len(notes) == 0 || n <= 0 {
+		return []SuggestedQuestion{}, "", nil
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Write %d questions.\n\n", n)
+	for i, note := range notes {
+		excerpt := strings.Join(strings.Fields(note.Excerpt), " ")
+		fmt.Fprintf(&b, "## Note %d: %s\n%s\n\n", i+1, note.Title, truncate(excerpt, suggestExcerptLimit))
+	}
+
+	chat_platform := newChatter(cfg, client)
+	raw, err := chat_platform.GetResponseWithSystemPrompt(ctx, b.String(), suggestPrompt)
+	if err != nil {
+		return nil, "", err
+	}
+
+	text := strings.TrimSpace(raw)
+	text = strings.TrimPrefix(text, "```json")
+	text = strings.TrimPrefix(text, "```")
+	text = strings.TrimSuffix(text, "```")
+
+	var parsed []struct {
+		Question string `json:"question"`
+		Note     int    `json:"note"`
+	}
+	_ = json.Unmarshal([]byte(strings.TrimSpace(text)), &parsed)
+
+	questions := make([]SuggestedQuestion, 0, n)
+	seen := map[string]bool{}
+	for _, p := range parsed {
+		q := strings.TrimSpace(p.Question)
+		if q == "" || p.Note < 1 || p.Note > len(notes) || seen[strings.ToLower(q)] {
+			continue
+		}
+		seen[strings.ToLower(q)] = true
+		questions = append(questions, SuggestedQuestion{Question: q, Note: p.Note - 1})
+		if len(questions) == n {
+			break
+		}
+	}
+	return questions, chat_platform.answeredBy, nil
+}
@@ -0,0 +1,279 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"vex-backend/chunking"
+	"vex-backend/config"
+	"vex-backend/httpclient"
+	"vex-backend/vector"
+	"vex-backend/vector/manager"
+)
+
+const (
+	// summarizeInputLimit is the most text sent to the LLM in one summarization call
+	summarizeInputLimit = 24000
+	// summarizeMaxFiles bounds how many notes of a folder are summarized
+	summarizeMaxFiles = 100
+	// summarizeMaxRounds bounds the reduce rounds in case summaries stop getting shorter
+	summarizeMaxRounds = 4
+
+	// DerivedMetadataKey marks documents generated from other documents (e.g. summaries)
+	// rather than embedded from a note
+	DerivedMetadataKey = "derived"
+	// SourceMetadataKey names what a derived document was generated from
+	SourceMetadataKey = "source"
+)
+
+const summarizeMapPrompt = `You summarize part of a personal knowledge base. Summarize the following text as concise bullet points.
+Keep key facts, decisions, definitions, names, dates and numbers. Don't add information that isn't in the text.`
+
+const summarizeReducePrompt = `You summarize a personal knowledge base. The input is a note, a set of notes, or partial summaries of them.
+Respond with a JSON object only, no code fences, of the form:
+{"summary": "<one or two paragraphs>", "key_points": ["<point>", ...], "topics": ["<short topic>", ...]}
+Don't add information that isn't in the input.`
+
+// Summary is the structured summary of a note or folder.
+type Summary struct {
+	Summary   string   `json:"summary"`
+	KeyPoints []string `json:"key_points"`
+	Topics    []string `json:"topics"`
+	// Files are the notes that were summarized
+	Files []string `json:"files"`
+	// Truncated is set when the folder had more notes than were summarized
+	Truncated bool `json:"truncated,omitempty"`
+	// StoredID is the ID of the derived document, when the summary was stored
+	StoredID string `json:"stored_id,omitempty"`
+	Provider string `json:"provider,omitempty"`
+}
+
+// SummarizeTarget selects what to summarize: a single note by absolute Path, every note
+// whose path starts with the absolute folder Prefix, or the notes listed in Paths.
+type SummarizeTarget struct {
+	Path   string
+	Prefix string
+	Paths  []string
+}
+
+func (t SummarizeTarget) name() string {
+	switch {
+	case t.Path != "":
+		return t.Path
+	case len(t.Paths) > 0:
+		return fmt.Sprintf("%d notes", len(t.Paths))
+	}
+	return t.Prefix
+}
+
+// Summarize summarizes the stored chunks of the target with the configured chat providers.
+// Inputs longer than one LLM call allows are summarized map-reduce style: each piece is
+// condensed to bullet points, which are condensed again until they fit a final structured
+// summary. With store set, the summary is embedded and stored as a derived document.
+func Summarize(ctx context.Context, cfg *config.EnvConfig, client httpclient.Doer, vm manager.Manager, target SummarizeTarget, store bool) (Summary, error) {
+	files, truncated, err := summarizeInputs(ctx, vm, target)
+	if err != nil {
+		return Summary{}, err
+	}
+
+	chat_platform := newChatter(cfg, client)
+
+	var texts []string
+	paths := make([]string, 0, len(files))
+	for _, f := range files {
+		paths = append(paths, f.path)
+		texts = append(texts, fmt.Sprintf("# %s\n\n%s", filepath.Base(f.path), f.content))
+	}
+
+	input, err := reduceToLimit(ctx, chat_platform, texts)
+	if err != nil {
+		return Summary{}, err
+	}
+
+	raw, err := chat_platform.GetResponseWithSystemPrompt(ctx, input, summarizeReducePrompt)
+	if err != nil {
+		return Summary{}, err
+	}
+	summary := parseSummary(raw)
+	summary.Files = paths
+	summary.Truncated = truncated
+	summary.Provider = chat_platform.answeredBy
+
+	if store {
+		id, err := storeSummary(ctx, vm, target, summary)
+		if err != nil {
+			return Summary{}, err
+		}
+		summary.StoredID = id
+	}
+
+	return summary, nil
+}
+
+type summarizeFile struct {
+	path    string
+	content string
+}
+
+// summarizeInputs collects the stitched content of every note selected by target,
+// ignoring derived documents.
+func summarizeInputs(ctx context.Context, vm manager.Manager, target SummarizeTarget) ([]summarizeFile, bool, error) {
+	var chunks []vector.VectorData
+	var err error
+	if target.Path != "" {
+		chunks, err = vm.GetChunksByFile(ctx, target.Path)
+	} else {
+		chunks, err = vm.GetByMetadata(ctx, nil)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	prefix := strings.TrimRight(target.Prefix, string(filepath.Separator)) + string(filepath.Separator)
+	listed := map[string]bool{}
+	for _, p := range target.Paths {
+		listed[p] = true
+	}
+	byFile := map[string][]vector.VectorData{}
+	for _, c := range chunks {
+		if c.Metadata[DerivedMetadataKey] != "" {
+			continue
+		}
+		path := c.Metadata["filepath"]
+		switch {
+		case target.Path != "":
+		case len(target.Paths) > 0:
+			if !listed[path] {
+				continue
+			}
+		case !strings.HasPrefix(path, prefix):
+			continue
+		}
+		byFile[path] = append(byFile[path], c)
+	}
+	if len(byFile) == 0 {
+		return nil, false, fmt.Errorf("no indexed notes for %q: %w", target.name(), vector.ErrNotFound)
+	}
+
+	paths := make([]string, 0, len(byFile))
+	for path := range byFile {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	truncated := false
+	if len(paths) > summarizeMaxFiles {
+		paths = paths[:summarizeMaxFiles]
+		truncated = true
+	}
+
+	files := make([]summarizeFile, 0, len(paths))
+	for _, path := range paths {
+		// chunks come back in position order per file, which stitching relies on
+		files = append(files, summarizeFile{path: path, content: stitchChunks(byFile[path])})
+	}
+	return files, truncated, nil
+}
+
+// reduceToLimit condenses texts until they fit into a single summarization call and
+// returns them joined. Oversized texts are split first (map), then the bullet point
+// summaries are batched and condensed again (reduce) for up to summarizeMaxRounds rounds.
+func reduceToLimit(ctx context.Context, c chatter, texts []string) (string, error) {
+	splitter := chunking.WordChunker{Size: summarizeInputLimit}
+	for round := 0; ; round++ {
+		joined := strings.Join(texts, "\n\n")
+		if len(joined) <= summarizeInputLimit {
+			return joined, nil
+		}
+		if round == summarizeMaxRounds {
+			return truncate(joined, summarizeInputLimit), nil
+		}
+
+		// pack the pieces into batches that each fit one call
+		var batches []string
+		var cur strings.Builder
+		for _, t := range texts {
+			for _, piece := range chunking.Texts(splitter.Chunk(t)) {
+				if cur.Len() > 0 && cur.Len()+len(piece)+2 > summarizeInputLimit {
+					batches = append(batches, cur.String())
+					cur.Reset()
+				}
+				if cur.Len() > 0 {
+					cur.WriteString("\n\n")
+				}
+				cur.WriteString(piece)
+			}
+		}
+		if cur.Len() > 0 {
+			batches = append(batches, cur.String())
+		}
+
+		next := make([]string, 0, len(batches))
+		for _, batch := range batches {
+			partial, err := c.GetResponseWithSystemPrompt(ctx, batch, summarizeMapPrompt)
+			if err != nil {
+				return "", err
+			}
+			next = append(next, partial)
+		}
+		texts = next
+	}
+}
+
+// parseSummary decodes the structured summary, falling back to the raw text as the
+// summary if the model didn't return valid JSON.
+func parseSummary(raw string) Summary {
+	text := strings.TrimSpace(raw)
+	text = strings.TrimPrefix(text, "```json")
+	text = strings.TrimPrefix(text, "```")
+	text = strings.TrimSuffix(text, "```")
+
+	var s Summary
+	if err := json.Unmarshal([]byte(strings.TrimSpace(text)), &s); err != nil || s.Summary == "" {
+		return Summary{Summary: strings.TrimSpace(raw), KeyPoints: []string{}, Topics: []string{}}
+	}
+	if s.KeyPoints == nil {
+		s.KeyPoints = []string{}
+	}
+	if s.Topics == nil {
+		s.Topics = []string{}
+	}
+	return s
+}
+
+// storeSummary embeds the summary and stores it as a derived document, replacing an earlier
+// summary of the same target. It has no filepath metadata, so re-indexing the source notes
+// leaves it alone and it never shows up as a chunk of them.
+func storeSummary(ctx context.Context, vm manager.Manager, target SummarizeTarget, s Summary) (string, error) {
+	content := s.Summary
+	if len(s.KeyPoints) > 0 {
+		content += "\n\n- " + strings.Join(s.KeyPoints, "\n- ")
+	}
+
+	embedding, err := vm.GetEmbedder().EmbedToVector(ctx, content)
+	if err != nil {
+		return "", err
+	}
+
+	id := "summary:" + target.name()
+	if err := vm.DeleteVectorWithID(ctx, id); err != nil {
+		return "", err
+	}
+	err = vm.StoreVectorInDB(ctx, vector.VectorData{
+		Id:        id,
+		Content:   content,
+		Embedding: embedding,
+		Metadata: map[string]string{
+			DerivedMetadataKey: "summary",
+			SourceMetadataKey:  target.name(),
+			"filename":         "Summary of " + filepath.Base(target.name()),
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
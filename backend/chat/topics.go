@@ -0,0 +1,69 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"vex-backend/config"
+	"vex-backend/httpclient"
+	"vex-backend/vector/manager"
+)
+
+const (
+	// topicSampleNotes is how many representative notes of a cluster are shown to the LLM
+	topicSampleNotes = 5
+	// topicExcerptLimit caps each note excerpt shown to the LLM
+	topicExcerptLimit = 300
+)
+
+const topicLabelPrompt = `You name topics in a personal knowledge base. The input lists groups of related notes,
+each with some of its most representative notes. Give every group a short topic label (two to five words)
+describing what its notes have in common. Respond with a JSON array of strings only, no code fences, with
+exactly one label per group in the order given.`
+
+// LabelClusters names each cluster with a short topic label in a single LLM call, returning
+// the labels in cluster order and the provider that answered. Clusters the model didn't
+// label get a numbered placeholder.
+func LabelClusters(ctx context.Context, cfg *config.EnvConfig, client httpclient.Doer, clusters []manager.Cluster) ([]string, string, error) {
+	if len(clusters) == 0 {
+		return []string{}, "", nil
+	}
+
+	var b strings.Builder
+	for i, c := range clusters {
+		fmt.Fprintf(&b, "## Group %d (%d notes)\n", i+1, len(c.Notes))
+		for j, n := range c.Notes {
+			if j == topicSampleNotes {
+				break
+			}
+			excerpt := strings.Join(strings.Fields(n.Excerpt), " ")
+			fmt.Fprintf(&b, "- %s: %s\n", n.Filename, truncate(excerpt, topicExcerptLimit))
+		}
+		b.WriteString("\n")
+	}
+
+	chat_platform := newChatter(cfg, client)
+	raw, err := chat_platform.GetResponseWithSystemPrompt(ctx, b.String(), topicLabelPrompt)
+	if err != nil {
+		return nil, "", err
+	}
+
+	text := strings.TrimSpace(raw)
+	text = strings.TrimPrefix(text, "```json")
+	text = strings.TrimPrefix(text, "```")
+	text = strings.TrimSuffix(text, "```")
+
+	var parsed []string
+	_ = json.Unmarshal([]byte(strings.TrimSpace(text)), &parsed)
+
+	labels := make([]string, len(clusters))
+	for i := range labels {
+		if i < len(parsed) && strings.TrimSpace(parsed[i]) != "" {
+			labels[i] = strings.TrimSpace(parsed[i])
+		} else {
+			labels[i] = fmt.Sprintf("Topic %d", i+1)
+		}
+	}
+	return labels, chat_platform.answeredBy, nil
+}
@@ -0,0 +1,59 @@
+package chat
+
+import (
+	"strings"
+	"vex-backend/vector"
+	"vex-backend/vector/embed"
+)
+
+// minWindowOverlap is the fewest bytes two windows must share to be joined, so a common
+// word or two at their edges doesn't join unrelated passages
+const minWindowOverlap = 20
+
+// withWindows returns results with the content of chunks stored with a window (see
+// chunking.SentenceChunker) replaced by the window, so the LLM answers from the sentences
+// around the one that matched. Neighbouring sentences often match together, so a window
+// overlapping an earlier one of the same note is joined with it instead of repeating it.
+func withWindows(results []vector.VectorData) []vector.VectorData {
+	out := make([]vector.VectorData, 0, len(results))
+next:
+	for _, r := range results {
+		window := r.Metadata[embed.WindowMetadataKey]
+		if window == "" {
+			out = append(out, r)
+			continue
+		}
+		for i, prev := range out {
+			if prev.Metadata[embed.WindowMetadataKey] == "" || prev.Metadata["filepath"] != r.Metadata["filepath"] {
+				continue
+			}
+			if joined, ok := joinWindows(prev.Content, window); ok {
+				out[i].Content = joined
+				continue next
+			}
+		}
+		r.Content = window
+		out = append(out, r)
+	}
+	return out
+}
+
+// joinWindows returns the text covered by two overlapping windows a and b, in either order,
+// reporting false if they don't overlap.
+func joinWindows(a, b string) (string, bool) {
+	if strings.Contains(a, b) {
+		return a, true
+	}
+	if strings.Contains(b, a) {
+		return b, true
+	}
+	for _, pair := range [][2]string{{a, b}, {b, a}} {
+		first, second := pair[0], pair[1]
+		for n := min(len(first), len(second)) - 1; n >= minWindowOverlap; n-- {
+			if strings.HasSuffix(first, second[:n]) {
+				return first + second[n:], true
+			}
+		}
+	}
+	return "", false
+}
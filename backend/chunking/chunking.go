@@ -0,0 +1,194 @@
+package chunking
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"vex-backend/config"
+)
+
+// Chunker splits content into chunks small enough to embed.
+type Chunker interface {
+	Chunk(content string) []Span
+}
+
+// Span is a chunk's text along with the byte range [Start, End) of the source content it
+// was taken from. Text may differ from the source range in whitespace.
+type Span struct {
+	Text  string
+	Start int
+	End   int
+	// Window is the text around the chunk to answer from in its place, if the chunker
+	// gives one (see SentenceChunker)
+	Window string
+}
+
+// Texts returns the text of each span.
+func Texts(spans []Span) []string {
+	texts := make([]string, 0, len(spans))
+	for _, s := range spans {
+		texts = append(texts, s.Text)
+	}
+	return texts
+}
+
+// LineRange returns the 1-based first and last line of content covered by span.
+func LineRange(content string, span Span) (int, int) {
+	start := strings.Count(content[:span.Start], "\n") + 1
+	end := start + strings.Count(content[span.Start:span.End], "\n")
+	// a range ending right after a newline doesn't cover the following line
+	if span.End > span.Start && content[span.End-1] == '\n' {
+		end--
+	}
+	return start, end
+}
+
+// Strategy selects how content is split into chunks.
+type Strategy string
+
+const (
+	// StrategyWords splits on word boundaries so no word is cut in half
+	StrategyWords Strategy = "words"
+	// StrategyFixed splits into fixed-size windows regardless of word boundaries
+	StrategyFixed Strategy = "fixed"
+	// StrategyMarkdown keeps heading sections together where possible
+	StrategyMarkdown Strategy = "markdown"
+	// StrategyCode keeps blank-line separated top-level blocks together where possible
+	StrategyCode Strategy = "code"
+	// StrategySentence embeds every sentence on its own, with the sentences around it as
+	// its window
+	StrategySentence Strategy = "sentence"
+)
+
+const (
+	// DefaultSize is a large chunk size for comprehensive content sections
+	DefaultSize = 50000
+	// DefaultOverlap is a fifth of DefaultSize
+	DefaultOverlap = DefaultSize / 5
+)
+
+// Options controls chunk size (in bytes), overlap between consecutive chunks and strategy.
+// Window is the sentences on either side of a chunk given as its window by StrategySentence.
+type Options struct {
+	Size     int
+	Overlap  int
+	Strategy Strategy
+	Window   int
+}
+
+// DefaultOptions returns the chunking used when nothing is configured.
+func DefaultOptions() Options {
+	return Options{
+		Size:     DefaultSize,
+		Overlap:  DefaultOverlap,
+		Strategy: StrategyWords,
+	}
+}
+
+// OptionsFrom reads CHUNK_SIZE, CHUNK_OVERLAP, CHUNK_STRATEGY and CHUNK_WINDOW from cfg. A negative
+// overlap (the default) means a fifth of the chunk size. A nil cfg yields the defaults.
+func OptionsFrom(cfg *config.EnvConfig) (Options, error) {
+	opts := DefaultOptions()
+	if cfg == nil {
+		return opts, nil
+	}
+
+	opts.Size = cfg.ChunkSize
+	opts.Overlap = cfg.ChunkOverlap
+	if opts.Overlap < 0 {
+		opts.Overlap = opts.Size / 5
+	}
+	opts.Strategy = Strategy(strings.ToLower(cfg.ChunkStrategy))
+	opts.Window = cfg.ChunkWindow
+
+	return opts, opts.Validate()
+}
+
+// OptionsFromConfig is OptionsFrom for the global configuration.
+func OptionsFromConfig() (Options, error) {
+	return OptionsFrom(config.Current())
+}
+
+// Validate checks that the options describe a usable chunking.
+func (o Options) Validate() error {
+	if o.Size <= 0 {
+		return fmt.Errorf("chunk size must be > 0")
+	}
+	if o.Overlap < 0 || o.Overlap >= o.Size {
+		return fmt.Errorf("chunk overlap must be >= 0 and smaller than the chunk size (%d)", o.Size)
+	}
+	switch o.Strategy {
+	case StrategyWords, StrategyFixed, StrategyMarkdown, StrategyCode, StrategySentence:
+		return nil
+	default:
+		return fmt.Errorf("unknown chunking strategy %q", o.Strategy)
+	}
+}
+
+// New returns the Chunker for the options' strategy.
+func New(o Options) (Chunker, error) {
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+	switch o.Strategy {
+	case StrategyFixed:
+		return FixedChunker{Size: o.Size, Overlap: o.Overlap}, nil
+	case StrategyMarkdown:
+		return MarkdownChunker{Size: o.Size, Overlap: o.Overlap}, nil
+	case StrategyCode:
+		return CodeChunker{Size: o.Size, Overlap: o.Overlap}, nil
+	case StrategySentence:
+		return SentenceChunker{Size: o.Size, Overlap: o.Overlap, Window: o.Window}, nil
+	default:
+		return WordChunker{Size: o.Size, Overlap: o.Overlap}, nil
+	}
+}
+
+// ConfigChunker resolves its options from the current configuration on every call, so
+// reloaded chunk settings apply to the next file embedded. Config is validated before it
+// is swapped in, but should it ever be invalid the defaults are used.
+// A nil Source reads the global configuration.
+type ConfigChunker struct {
+	Source config.Source
+}
+
+func (cc ConfigChunker) Chunk(content string) []Span {
+	src := cc.Source
+	if src == nil {
+		src = config.Current
+	}
+	opts, err := OptionsFrom(src())
+	if err != nil {
+		opts = DefaultOptions()
+	}
+	c, err := New(opts)
+	if err != nil {
+		c, _ = New(DefaultOptions())
+	}
+	return c.Chunk(content)
+}
+
+// prepare trims content and reports whether it still needs splitting, along with the
+// offset of the trimmed content in the original. Every chunker returns no chunks for blank
+// content and a single chunk for content that already fits.
+func prepare(content string, size int) (string, int, []Span, bool) {
+	trimmed := strings.TrimLeftFunc(content, unicode.IsSpace)
+	offset := len(content) - len(trimmed)
+	trimmed = strings.TrimRightFunc(trimmed, unicode.IsSpace)
+	if trimmed == "" {
+		return "", 0, []Span{}, false
+	}
+	if len(trimmed) <= size {
+		return trimmed, offset, []Span{{Text: trimmed, Start: offset, End: offset + len(trimmed)}}, false
+	}
+	return trimmed, offset, nil, true
+}
+
+// shift moves spans computed on a substring to offsets of the enclosing content.
+func shift(spans []Span, offset int) []Span {
+	for i := range spans {
+		spans[i].Start += offset
+		spans[i].End += offset
+	}
+	return spans
+}
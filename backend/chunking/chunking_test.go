@@ -0,0 +1,209 @@
+package chunking
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"vex-backend/config"
+)
+
+// span is a chunk as the tests spell it: its text and byte range.
+type span struct {
+	Text       string
+	Start, End int
+}
+
+func spansOf(spans []Span) []span {
+	out := make([]span, 0, len(spans))
+	for _, s := range spans {
+		out = append(out, span{s.Text, s.Start, s.End})
+	}
+	return out
+}
+
+func TestChunkers(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		chunker Chunker
+		content string
+		want    []span
+	}{
+		{"blank", WordChunker{Size: 10}, " \n\t ", []span{}},
+		{"fits", WordChunker{Size: 100}, "\n  short note \n", []span{{"short note", 3, 13}}},
+		{
+			"fixed overlap",
+			FixedChunker{Size: 10, Overlap: 3},
+			"abcdefghijklmnopqrstuvwxyz",
+			[]span{{"abcdefghij", 0, 10}, {"hijklmnopq", 7, 17}, {"opqrstuvwx", 14, 24}, {"vwxyz", 21, 26}},
+		},
+		{
+			"fixed cuts at rune boundaries",
+			FixedChunker{Size: 5, Overlap: 0},
+			"ééééé",
+			[]span{{"éé", 0, 4}, {"éé", 4, 8}, {"é", 8, 10}},
+		},
+		{
+			"words overlap",
+			WordChunker{Size: 13, Overlap: 3},
+			"one two three four five six seven eight",
+			[]span{{"one two three", 0, 13}, {"three four", 8, 18}, {"four five six", 14, 27}, {"six seven", 24, 33}, {"seven eight", 28, 39}},
+		},
+		{
+			"words without overlap",
+			WordChunker{Size: 13},
+			"one two three four five",
+			[]span{{"one two three", 0, 13}, {"four five", 14, 23}},
+		},
+		{
+			"words offsets after trimming",
+			WordChunker{Size: 8},
+			"\n\n  alpha\nbeta  gamma\n",
+			[]span{{"alpha", 4, 9}, {"beta", 10, 14}, {"gamma", 16, 21}},
+		},
+		{
+			"markdown splits before headings",
+			MarkdownChunker{Size: 20},
+			"# A\nfirst section\n# B\nsecond section\n",
+			[]span{{"# A\nfirst section", 0, 18}, {"# B\nsecond section", 18, 36}},
+		},
+		{
+			"markdown packs small sections",
+			MarkdownChunker{Size: 40},
+			"# A\nfirst\n# B\nsecond\n# C\nthird section here\n",
+			[]span{{"# A\nfirst\n# B\nsecond", 0, 21}, {"# C\nthird section here", 21, 43}},
+		},
+		{
+			"markdown ignores headings in code blocks",
+			MarkdownChunker{Size: 30},
+			"# A\n```\n# not a heading\n```\n# B\nafter the block\n",
+			[]span{{"# A\n```\n# not a heading\n```", 0, 28}, {"# B\nafter the block", 28, 47}},
+		},
+		{
+			"markdown falls back to words",
+			MarkdownChunker{Size: 12},
+			"# A\nalpha beta gamma\n# B\nx\n",
+			[]span{{"# A alpha", 0, 9}, {"beta gamma", 10, 20}, {"# B\nx", 21, 26}},
+		},
+		{
+			"code splits between top-level blocks",
+			CodeChunker{Size: 30},
+			"func a() {\n\treturn 1\n}\n\nfunc b() {\n\treturn 2\n}\n",
+			[]span{{"func a() {\n\treturn 1\n}", 0, 24}, {"func b() {\n\treturn 2\n}", 24, 46}},
+		},
+		{
+			"code keeps indented blocks after blank lines",
+			CodeChunker{Size: 40},
+			"func a() {\n\tx := 1\n\n\treturn x\n}\n\nfunc b() {}\n",
+			[]span{{"func a() {\n\tx := 1\n\n\treturn x\n}", 0, 33}, {"func b() {}", 33, 44}},
+		},
+		{
+			"code falls back to lines",
+			CodeChunker{Size: 16, Overlap: 8},
+			"var a = 1\nvar b = 2\nvar c = 3\n",
+			[]span{{"var a = 1", 0, 10}, {"var b = 2", 10, 20}, {"var c = 3", 20, 29}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := spansOf(tc.chunker.Chunk(tc.content)); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Chunk(%q) =\n%+v\nwant\n%+v", tc.content, got, tc.want)
+			}
+		})
+	}
+}
+
+// TestSpansMatchTheirSource checks what stitching chunks back together relies on: every
+// span's text is its byte range of the source, give or take whitespace, and the ranges of
+// consecutive spans move forward.
+func TestSpansMatchTheirSource(t *testing.T) {
+	content := strings.Repeat("## Heading\n\nSome text of a paragraph, with a few words.\n\n```go\nfunc f() {\n\treturn\n}\n```\n\n", 8)
+	for _, strategy := range []Strategy{StrategyWords, StrategyFixed, StrategyMarkdown, StrategyCode, StrategySentence} {
+		c, err := New(Options{Size: 60, Overlap: 12, Strategy: strategy, Window: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		spans := c.Chunk(content)
+		if len(spans) < 2 {
+			t.Fatalf("%s: %d spans, want several", strategy, len(spans))
+		}
+		for i, s := range spans {
+			if s.Start < 0 || s.End > len(content) || s.Start >= s.End {
+				t.Fatalf("%s: span %d has range [%d, %d) in %d bytes", strategy, i, s.Start, s.End, len(content))
+			}
+			if got, want := strings.Fields(s.Text), strings.Fields(content[s.Start:s.End]); !reflect.DeepEqual(got, want) {
+				t.Errorf("%s: span %d is %q, its range %q", strategy, i, s.Text, content[s.Start:s.End])
+			}
+			if i > 0 && (s.Start < spans[i-1].Start || s.End <= spans[i-1].End) {
+				t.Errorf("%s: span %d [%d, %d) doesn't follow [%d, %d)", strategy, i, s.Start, s.End, spans[i-1].Start, spans[i-1].End)
+			}
+		}
+	}
+}
+
+func TestLineRange(t *testing.T) {
+	content := "one\ntwo\nthree\n\nfive"
+	for _, tc := range []struct {
+		start, end          int
+		firstLine, lastLine int
+	}{
+		{0, 3, 1, 1},
+		{0, 4, 1, 1},
+		{4, 13, 2, 3},
+		{8, 14, 3, 3},
+		{15, 19, 5, 5},
+	} {
+		first, last := LineRange(content, Span{Start: tc.start, End: tc.end})
+		if first != tc.firstLine || last != tc.lastLine {
+			t.Errorf("LineRange of [%d, %d) = %d-%d, want %d-%d", tc.start, tc.end, first, last, tc.firstLine, tc.lastLine)
+		}
+	}
+}
+
+func TestOptionsFrom(t *testing.T) {
+	if opts, err := OptionsFrom(nil); err != nil || opts != DefaultOptions() {
+		t.Errorf("OptionsFrom(nil) = %+v, %v, want the defaults", opts, err)
+	}
+
+	for _, tc := range []struct {
+		name    string
+		cfg     config.EnvConfig
+		want    Options
+		wantErr bool
+	}{
+		{"default overlap", config.EnvConfig{ChunkSize: 1000, ChunkOverlap: -1, ChunkStrategy: "Markdown"}, Options{Size: 1000, Overlap: 200, Strategy: StrategyMarkdown}, false},
+		{"explicit overlap", config.EnvConfig{ChunkSize: 1000, ChunkOverlap: 0, ChunkStrategy: "fixed"}, Options{Size: 1000, Strategy: StrategyFixed}, false},
+		{"zero size", config.EnvConfig{ChunkSize: 0, ChunkOverlap: 0, ChunkStrategy: "words"}, Options{}, true},
+		{"negative size", config.EnvConfig{ChunkSize: -5, ChunkOverlap: 0, ChunkStrategy: "words"}, Options{}, true},
+		{"overlap as large as the size", config.EnvConfig{ChunkSize: 100, ChunkOverlap: 100, ChunkStrategy: "words"}, Options{}, true},
+		{"unknown strategy", config.EnvConfig{ChunkSize: 100, ChunkOverlap: 10, ChunkStrategy: "paragraphs"}, Options{}, true},
+	} {
+		opts, err := OptionsFrom(&tc.cfg)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("%s: OptionsFrom accepted %+v", tc.name, opts)
+			}
+			continue
+		}
+		if err != nil || opts != tc.want {
+			t.Errorf("%s: OptionsFrom = %+v, %v, want %+v", tc.name, opts, err, tc.want)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	for strategy, want := range map[Strategy]Chunker{
+		StrategyWords:    WordChunker{Size: 100, Overlap: 10},
+		StrategyFixed:    FixedChunker{Size: 100, Overlap: 10},
+		StrategyMarkdown: MarkdownChunker{Size: 100, Overlap: 10},
+		StrategyCode:     CodeChunker{Size: 100, Overlap: 10},
+		StrategySentence: SentenceChunker{Size: 100, Overlap: 10, Window: 2},
+	} {
+		c, err := New(Options{Size: 100, Overlap: 10, Strategy: strategy, Window: 2})
+		if err != nil || c != want {
+			t.Errorf("New(%s) = %#v, %v, want %#v", strategy, c, err, want)
+		}
+	}
+	if _, err := New(Options{Size: 100, Overlap: 200, Strategy: StrategyWords}); err == nil {
+		t.Error("New accepted an overlap larger than the size")
+	}
+}
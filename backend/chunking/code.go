@@ -0,0 +1,73 @@
+package chunking
+
+import "strings"
+
+// CodeChunker splits source code into top-level blocks (a blank line followed by an
+// unindented line starts a new block) and packs them into chunks of at most Size bytes.
+// Oversized blocks fall back to line-based chunking with roughly Overlap bytes of lines shared.
+type CodeChunker struct {
+	Size    int
+	Overlap int
+}
+
+func (c CodeChunker) Chunk(content string) []Span {
+	content, offset, spans, split := prepare(content, c.Size)
+	if !split {
+		return spans
+	}
+
+	prevBlank := false
+	blocks := splitLines(content, func(line string, first bool) bool {
+		blank := strings.TrimSpace(line) == ""
+		topLevel := !blank && line[0] != ' ' && line[0] != '\t'
+		boundary := !first && prevBlank && topLevel
+		prevBlank = blank
+		return boundary
+	})
+
+	return shift(pack(content, blocks, c.Size, lineChunker{Size: c.Size, Overlap: c.Overlap}), offset)
+}
+
+// lineChunker packs whole lines into chunks of at most Size bytes; a single line longer
+// than Size is cut with FixedChunker.
+type lineChunker struct {
+	Size    int
+	Overlap int
+}
+
+func (c lineChunker) Chunk(content string) []Span {
+	lines := splitLines(content, func(string, bool) bool { return true })
+	var spans []Span
+
+	for start := 0; start < len(lines); {
+		if len(lines[start].Text) > c.Size {
+			spans = append(spans, shift(FixedChunker{Size: c.Size, Overlap: c.Overlap}.Chunk(lines[start].Text), lines[start].Start)...)
+			start++
+			continue
+		}
+
+		end := start
+		for end < len(lines) && lines[end].End-lines[start].Start <= c.Size {
+			end++
+		}
+		if end == start {
+			end++
+		}
+		s, e := lines[start].Start, lines[end-1].End
+		if text := strings.TrimSpace(content[s:e]); text != "" {
+			spans = append(spans, Span{Text: text, Start: s, End: e})
+		}
+		if end >= len(lines) {
+			break
+		}
+
+		// step back over roughly Overlap bytes of lines
+		newStart := end
+		for newStart-1 > start && lines[end-1].End-lines[newStart-1].Start <= c.Overlap {
+			newStart--
+		}
+		start = newStart
+	}
+
+	return spans
+}
@@ -0,0 +1,51 @@
+package chunking
+
+import "strings"
+
+// FixedChunker cuts content into windows of Size bytes, each starting Overlap bytes
+// before the end of the previous one. Cuts are moved back to rune boundaries.
+type FixedChunker struct {
+	Size    int
+	Overlap int
+}
+
+func (c FixedChunker) Chunk(content string) []Span {
+	content, offset, spans, split := prepare(content, c.Size)
+	if !split {
+		return spans
+	}
+	return shift(splitFixed(content, c.Size, c.Overlap), offset)
+}
+
+func splitFixed(content string, size, overlap int) []Span {
+	var spans []Span
+	for start := 0; start < len(content); {
+		end := start + size
+		if end >= len(content) {
+			end = len(content)
+		} else {
+			for end > start+1 && !isRuneStart(content[end]) {
+				end--
+			}
+		}
+		spans = append(spans, Span{Text: strings.TrimSpace(content[start:end]), Start: start, End: end})
+		if end >= len(content) {
+			break
+		}
+
+		newStart := end - overlap
+		for newStart > start && !isRuneStart(content[newStart]) {
+			newStart--
+		}
+		// ensure progress
+		if newStart <= start {
+			newStart = end
+		}
+		start = newStart
+	}
+	return spans
+}
+
+func isRuneStart(b byte) bool {
+	return b&0xC0 != 0x80
+}
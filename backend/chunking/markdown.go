@@ -0,0 +1,97 @@
+package chunking
+
+import (
+	"regexp"
+	"strings"
+)
+
+var reHeading = regexp.MustCompile(`^#{1,6}\s`)
+
+// MarkdownChunker splits before headings and packs consecutive sections into chunks of
+// at most Size bytes, so a section is only cut when it doesn't fit a chunk on its own.
+// Oversized sections fall back to word chunking with Overlap; whole sections never overlap.
+type MarkdownChunker struct {
+	Size    int
+	Overlap int
+}
+
+func (c MarkdownChunker) Chunk(content string) []Span {
+	content, offset, spans, split := prepare(content, c.Size)
+	if !split {
+		return spans
+	}
+
+	return shift(pack(content, Sections(content), c.Size, WordChunker{Size: c.Size, Overlap: c.Overlap}), offset)
+}
+
+// Sections splits Markdown content into its heading sections, each running from a heading
+// to the next; text before the first heading is a section of its own.
+func Sections(content string) []Span {
+	inFence := false
+	return splitLines(content, func(line string, first bool) bool {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			inFence = !inFence
+		}
+		// a "# comment" inside a fenced code block is not a heading
+		return !first && !inFence && reHeading.MatchString(line)
+	})
+}
+
+// splitLines groups the lines of content into sections, starting a new section before
+// every line for which isBoundary returns true. first is set for the very first line.
+func splitLines(content string, isBoundary func(line string, first bool) bool) []Span {
+	var sections []Span
+	sectionStart := 0
+	for pos := 0; pos < len(content); {
+		end := strings.IndexByte(content[pos:], '\n')
+		if end < 0 {
+			end = len(content)
+		} else {
+			end += pos
+		}
+		if isBoundary(content[pos:end], pos == 0) && pos > sectionStart {
+			sections = append(sections, Span{Start: sectionStart, End: pos})
+			sectionStart = pos
+		}
+		pos = end + 1
+	}
+	sections = append(sections, Span{Start: sectionStart, End: len(content)})
+
+	for i := range sections {
+		sections[i].Text = content[sections[i].Start:sections[i].End]
+	}
+	return sections
+}
+
+// pack greedily merges consecutive sections of content into chunks of at most size bytes.
+// Sections larger than size are split with the fallback chunker.
+func pack(content string, sections []Span, size int, fallback Chunker) []Span {
+	var spans []Span
+	start, end := -1, -1
+	flush := func() {
+		if start >= 0 {
+			if text := strings.TrimSpace(content[start:end]); text != "" {
+				spans = append(spans, Span{Text: text, Start: start, End: end})
+			}
+		}
+		start, end = -1, -1
+	}
+
+	for _, section := range sections {
+		if len(section.Text) > size {
+			flush()
+			spans = append(spans, shift(fallback.Chunk(section.Text), section.Start)...)
+			continue
+		}
+		if start >= 0 && section.End-start > size {
+			flush()
+		}
+		if start < 0 {
+			start = section.Start
+		}
+		end = section.End
+	}
+	flush()
+
+	return spans
+}
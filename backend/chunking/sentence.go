@@ -0,0 +1,106 @@
+package chunking
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// reBlockStart matches lines that start a Markdown block of their own: headings, list
+// items, quotes and table rows
+var reBlockStart = regexp.MustCompile(`^\s*([#>|]|[-*+]\s|\d+[.)]\s)`)
+
+// SentenceChunker makes every sentence a chunk of its own, so a factual lookup matches the
+// one sentence that answers it, and gives each chunk the Window sentences before and after
+// it as Span.Window, the context it is answered from. Headings, list items, quotes, table rows
+// and paragraphs end a sentence too, so bullet points without full stops are sentences of
+// their own, and fenced code blocks are kept whole. Sentences longer than Size fall back to
+// word chunking with Overlap.
+type SentenceChunker struct {
+	Size    int
+	Overlap int
+	Window  int
+}
+
+func (c SentenceChunker) Chunk(content string) []Span {
+	all := sentences(content)
+	spans := make([]Span, 0, len(all))
+	for i, s := range all {
+		lo, hi := max(0, i-c.Window), min(len(all)-1, i+c.Window)
+		window := strings.TrimSpace(content[all[lo].Start:all[hi].End])
+
+		parts := []Span{s}
+		if len(s.Text) > c.Size {
+			parts = shift(WordChunker{Size: c.Size, Overlap: c.Overlap}.Chunk(s.Text), s.Start)
+		}
+		for _, p := range parts {
+			if window != p.Text {
+				p.Window = window
+			}
+			spans = append(spans, p)
+		}
+	}
+	return spans
+}
+
+// sentences splits content into its sentences. A sentence ends at a full stop, question or
+// exclamation mark followed by white space and neither a lower case letter nor a digit
+// (as in "e.g. this" or "Fig. 2"), and at the end of a Markdown block.
+func sentences(content string) []Span {
+	inFence, prevBlank, afterFence := false, false, false
+	blocks := splitLines(content, func(line string, first bool) bool {
+		trimmed := strings.TrimSpace(line)
+		fence := strings.HasPrefix(trimmed, "```")
+		boundary := !first && !inFence && (prevBlank || afterFence || fence || reBlockStart.MatchString(line))
+		afterFence = fence && inFence
+		if fence {
+			inFence = !inFence
+		}
+		prevBlank = trimmed == ""
+		return boundary
+	})
+
+	var out []Span
+	for _, b := range blocks {
+		if strings.HasPrefix(strings.TrimSpace(b.Text), "```") {
+			out = appendTrimmed(out, content, b.Start, b.End)
+			continue
+		}
+		start := b.Start
+		for i := b.Start; i < b.End; i++ {
+			if c := content[i]; c != '.' && c != '!' && c != '?' {
+				continue
+			}
+			// closing quotes, brackets and emphasis stay with the sentence
+			end := i + 1
+			for end < b.End && strings.IndexByte(`"')]*_`, content[end]) >= 0 {
+				end++
+			}
+			if end < b.End && !unicode.IsSpace(rune(content[end])) {
+				continue
+			}
+			next := strings.TrimLeftFunc(content[end:b.End], unicode.IsSpace)
+			if r, _ := utf8.DecodeRuneInString(next); unicode.IsLower(r) || unicode.IsDigit(r) {
+				continue
+			}
+			out = appendTrimmed(out, content, start, end)
+			start, i = end, end-1
+		}
+		out = appendTrimmed(out, content, start, b.End)
+	}
+	return out
+}
+
+// appendTrimmed appends content[start:end] without its surrounding white space to spans,
+// unless it is blank.
+func appendTrimmed(spans []Span, content string, start, end int) []Span {
+	text := content[start:end]
+	trimmed := strings.TrimLeftFunc(text, unicode.IsSpace)
+	start += len(text) - len(trimmed)
+	trimmed = strings.TrimRightFunc(trimmed, unicode.IsSpace)
+	if trimmed == "" {
+		return spans
+	}
+	return append(spans, Span{Text: trimmed, Start: start, End: start + len(trimmed)})
+}
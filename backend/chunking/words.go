@@ -0,0 +1,121 @@
+package chunking
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// WordChunker splits on word boundaries so that chunks don't exceed Size bytes and
+// consecutive chunks share roughly Overlap bytes of words.
+type WordChunker struct {
+	Size    int
+	Overlap int
+}
+
+func (c WordChunker) Chunk(content string) []Span {
+	content, offset, spans, split := prepare(content, c.Size)
+	if !split {
+		return spans
+	}
+	return shift(splitWords(content, c.Size, c.Overlap), offset)
+}
+
+// wordSpan is a whitespace-delimited word and its byte range in the content.
+type wordSpan struct {
+	text       string
+	start, end int
+}
+
+// fields is strings.Fields that also records where each word is.
+func fields(content string) []wordSpan {
+	var words []wordSpan
+	start := -1
+	for i := 0; i < len(content); {
+		r, size := utf8.DecodeRuneInString(content[i:])
+		if unicode.IsSpace(r) {
+			if start >= 0 {
+				words = append(words, wordSpan{text: content[start:i], start: start, end: i})
+				start = -1
+			}
+		} else if start < 0 {
+			start = i
+		}
+		i += size
+	}
+	if start >= 0 {
+		words = append(words, wordSpan{text: content[start:], start: start, end: len(content)})
+	}
+	return words
+}
+
+func splitWords(content string, size, overlap int) []Span {
+	var spans []Span
+	words := fields(content)
+	if len(words) == 0 {
+		return []Span{{Text: content, Start: 0, End: len(content)}}
+	}
+
+	for start := 0; start < len(words); {
+		cur := 0
+		end := start
+
+		// build chunk from start..end (exclusive) not exceeding size
+		for end < len(words) {
+			wlen := len(words[end].text)
+			add := wlen
+			if end > start {
+				add += 1 // space
+			}
+			if cur+add > size {
+				// if no progress (single word larger than limit), include it anyway
+				if end == start {
+					end++
+				}
+				break
+			}
+			cur += add
+			end++
+		}
+
+		// create chunk string from this range of words
+		texts := make([]string, 0, end-start)
+		for _, w := range words[start:end] {
+			texts = append(texts, w.text)
+		}
+		spans = append(spans, Span{
+			Text:  strings.TrimSpace(strings.Join(texts, " ")),
+			Start: words[start].start,
+			End:   words[end-1].end,
+		})
+
+		// if we've reached the end, break
+		if end >= len(words) {
+			break
+		}
+
+		// determine how many words to overlap to reach approximately overlap bytes
+		ovAccum := 0
+		overlapCount := 0
+		for k := end - 1; k >= start && overlap > 0; k-- {
+			if overlapCount == 0 {
+				ovAccum += len(words[k].text)
+			} else {
+				ovAccum += 1 + len(words[k].text) // space + word
+			}
+			overlapCount++
+			if ovAccum >= overlap {
+				break
+			}
+		}
+
+		newStart := end - overlapCount
+		// ensure progress; if overlap would not move forward, advance to end
+		if newStart <= start {
+			newStart = end
+		}
+		start = newStart
+	}
+
+	return spans
+}
@@ -0,0 +1,512 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"vex-backend/chat"
+	"vex-backend/eval"
+	"vex-backend/indexer"
+	"vex-backend/lang"
+	"vex-backend/manifest"
+	"vex-backend/usage"
+	"vex-backend/vector"
+	vectormgr "vex-backend/vector/manager"
+)
+
+// The commands below work on the same vector store as the server, but a running server
+// keeps its own copy in memory and won't see their changes until it restarts. Their usage
+// is attributed to "cli". Logging goes to stderr and is off unless -v is given, so stdout
+// only carries the result.
+
+// newFlagSet returns the flag set of a command with the shared -v flag.
+func newFlagSet(name, usage string) (*flag.FlagSet, *bool) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: vex %s\n\n", usage)
+		fs.PrintDefaults()
+	}
+	verbose := fs.Bool("v", false, "log progress to stderr")
+	return fs, verbose
+}
+
+// setVerbose discards the log output of the packages unless verbose is set.
+func setVerbose(verbose bool) {
+	if verbose {
+		log.SetOutput(os.Stderr)
+	} else {
+		log.SetOutput(io.Discard)
+	}
+}
+
+func cliContext() context.Context {
+	return usage.WithSource(context.Background(), "cli")
+}
+
+// printJSON writes v to stdout as indented JSON.
+func printJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// runIndex embeds files of the local clone, like the webhook does for the files of a push.
+func runIndex(args []string) error {
+	fs, verbose := newFlagSet("index", "index [-pull] [-collection name] [-v] [path...]")
+	pull := fs.Bool("pull", false, "pull the repository first; without paths, index only the files that changed")
+	collection := fs.String("collection", "", "index into this named collection instead of VECTOR_COLLECTION, creating it if needed")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	setVerbose(*verbose)
+
+	a, err := newApp()
+	if err != nil {
+		return err
+	}
+	root := a.repo.Path()
+	vectors, man, err := a.collection(*collection, true)
+	if err != nil {
+		return err
+	}
+
+	var files []string
+	if *pull {
+		changed, err := a.repo.ChangedFiles()
+		if err != nil {
+			return fmt.Errorf("git error: %w", err)
+		}
+		if fs.NArg() == 0 {
+			files = changed
+		}
+	}
+	if !*pull || fs.NArg() > 0 {
+		// paths are relative to the repository root; no path indexes all of it
+		paths := fs.Args()
+		if len(paths) == 0 {
+			paths = []string{""}
+		}
+		for _, p := range paths {
+			rel, err := repoRelative(root, p)
+			if err != nil {
+				return err
+			}
+			found, err := a.repo.Files(rel)
+			if err != nil {
+				return err
+			}
+			files = append(files, found...)
+		}
+	}
+
+	policy, err := indexer.LoadPolicy(a.cfg(), a.client, root)
+	if err != nil {
+		return fmt.Errorf("ignore rules error: %w", err)
+	}
+	ctx := cliContext()
+	res, runErr := indexer.Run(ctx, vectors, man, policy, root, files)
+	if err := a.flush(); err != nil {
+		return err
+	}
+
+	u := usage.FromContext(ctx)
+	fmt.Printf("processed %d, skipped %d, failed %d, pending %d, deleted %d (%d Voyage tokens)\n",
+		len(res.Processed), len(res.Skipped), len(res.Failed), len(res.Pending), len(res.Deleted), u.VoyageTokens)
+	failed := make([]string, 0, len(res.Failed))
+	for rel := range res.Failed {
+		failed = append(failed, rel)
+	}
+	sort.Strings(failed)
+	for _, rel := range failed {
+		fmt.Printf("failed: %s: %v\n", rel, res.Failed[rel])
+	}
+	if runErr != nil {
+		return runErr
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d files failed to index", len(failed))
+	}
+	return nil
+}
+
+// repoRelative turns p, absolute or relative to the repository root, into a path relative
+// to root, refusing paths outside of it.
+func repoRelative(root, p string) (string, error) {
+	rel := filepath.Clean(p)
+	if filepath.IsAbs(p) {
+		absRoot, err := filepath.Abs(root)
+		if err != nil {
+			return "", err
+		}
+		if rel, err = filepath.Rel(absRoot, p); err != nil {
+			return "", err
+		}
+	}
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is outside of the notes repository %s", p, root)
+	}
+	if rel == "." {
+		rel = ""
+	}
+	return rel, nil
+}
+
+// filterFlags are the retrieval filters of /query as command line flags.
+type filterFlags struct {
+	tags, pathPrefix, pathGlob, language, asOf, channels *string
+	recency                                              *bool
+	withinDays                                           *int
+}
+
+func addFilterFlags(fs *flag.FlagSet) filterFlags {
+	return filterFlags{
+		tags:       fs.String("tags", "", "comma-separated tags every retrieved note must carry"),
+		recency:    fs.Bool("recency", false, "favour newer notes"),
+		pathPrefix: fs.String("path-prefix", "", "only retrieve notes under this path of the repository"),
+		pathGlob:   fs.String("path-glob", "", "only retrieve notes matching this glob"),
+		withinDays: fs.Int("within-days", 0, "only retrieve notes committed in the last n days"),
+		language:   fs.String("lang", "", "only retrieve chunks in this language ("+strings.Join(lang.Languages(), ", ")+")"),
+		asOf:       fs.String("as-of", "", "answer from the notes as they were at this RFC 3339 time (needs VERSION_HISTORY)"),
+		channels:   fs.String("channels", "", "comma-separated Slack channels imported conversations are retrieved from"),
+	}
+}
+
+// options validates the parsed flags and turns them into query options; paths are
+// relative to cloneFolder, as on /query.
+func (f filterFlags) options(cloneFolder string) (chat.QueryOptions, error) {
+	language := strings.ToLower(strings.TrimSpace(*f.language))
+	if language != "" && !slices.Contains(lang.Languages(), language) {
+		return chat.QueryOptions{}, fmt.Errorf("-lang must be one of %s", strings.Join(lang.Languages(), ", "))
+	}
+	if *f.withinDays < 0 {
+		return chat.QueryOptions{}, fmt.Errorf("-within-days must not be negative")
+	}
+
+	opts := chat.QueryOptions{
+		Recency:  *f.recency,
+		Paths:    vectormgr.PathFilter{Root: cloneFolder, Prefix: *f.pathPrefix, Glob: *f.pathGlob},
+		Language: language,
+	}
+	if _, err := opts.Paths.Matcher(); err != nil {
+		return chat.QueryOptions{}, fmt.Errorf("-path-glob: %w", err)
+	}
+	for _, tag := range strings.Split(*f.tags, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			opts.Tags = append(opts.Tags, tag)
+		}
+	}
+	for _, channel := range strings.Split(*f.channels, ",") {
+		if channel = strings.TrimSpace(channel); channel != "" {
+			opts.Channels = append(opts.Channels, channel)
+		}
+	}
+	if *f.withinDays > 0 {
+		opts.Dates.Since = time.Now().AddDate(0, 0, -*f.withinDays)
+	}
+	if *f.asOf != "" {
+		t, err := time.Parse(time.RFC3339, *f.asOf)
+		if err != nil {
+			return chat.QueryOptions{}, fmt.Errorf("-as-of must be an RFC 3339 time")
+		}
+		opts.AsOf = t
+	}
+	return opts, nil
+}
+
+// runQuery answers a question like /query does, or with -retrieve only lists the chunks
+// retrieval finds for it. The command line has full access, private notes included.
+func runQuery(args []string) error {
+	fs, verbose := newFlagSet("query", `query [flags] "question"`)
+	filters := addFilterFlags(fs)
+	agent := fs.Bool("agent", false, "let the LLM search the notes itself through tool calls")
+	format := fs.String("format", "markdown", "shape of the answer: markdown, plain or json (with bullets and citations)")
+	persona := fs.String("persona", "", "persona merged into the answer prompts instead of ANSWER_PERSONA")
+	answerLang := fs.String("answer-lang", "", "language to answer in ("+strings.Join(lang.Languages(), ", ")+"), detected from the question if empty")
+	parent := fs.String("parent", "", "what retrieved chunks are expanded to: off, section or note (default PARENT_CONTEXT)")
+	strategy := fs.String("strategy", "", "how retrieved chunks are put before the LLM: stuff, map_reduce or refine (default ANSWER_STRATEGY)")
+	retrieve := fs.Bool("retrieve", false, "list the retrieved chunks instead of answering, without calling an LLM")
+	n := fs.Int("n", 4, "number of chunks listed by -retrieve")
+	asJSON := fs.Bool("json", false, "print the result as JSON")
+	collection := fs.String("collection", "", "answer from this named collection instead of VECTOR_COLLECTION")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	setVerbose(*verbose)
+
+	question := strings.TrimSpace(strings.Join(fs.Args(), " "))
+	if question == "" {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+	if *n <= 0 {
+		return fmt.Errorf("-n must be positive")
+	}
+
+	a, err := newApp()
+	if err != nil {
+		return err
+	}
+	cfg := a.cfg()
+	vectors, _, err := a.collection(*collection, false)
+	if err != nil {
+		return err
+	}
+	opts, err := filters.options(cfg.CloneFolder)
+	if err != nil {
+		return err
+	}
+	opts.Agent = *agent
+	opts.Persona = *persona
+	opts.AnswerLanguage = strings.ToLower(strings.TrimSpace(*answerLang))
+	if opts.AnswerLanguage != "" && !slices.Contains(lang.Languages(), opts.AnswerLanguage) {
+		return fmt.Errorf("-answer-lang must be one of %s", strings.Join(lang.Languages(), ", "))
+	}
+	if opts.Format, err = chat.ParseFormat(*format); err != nil {
+		return fmt.Errorf("-format %w", err)
+	}
+	if opts.Strategy, err = chat.ParseStrategy(*strategy); err != nil {
+		return fmt.Errorf("-strategy %w", err)
+	}
+	if opts.Parent, err = chat.ParseParentMode(*parent); err != nil {
+		return fmt.Errorf("-parent %w", err)
+	}
+
+	ctx := cliContext()
+	if *retrieve {
+		results, err := chat.Retrieve(ctx, cfg, vectors, question, *n, opts)
+		if err != nil {
+			return err
+		}
+		return printChunks(results, a.repo.Path(), *asJSON)
+	}
+
+	result, err := chat.ProcessQuery(ctx, cfg, a.client, vectors, question, opts)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return printJSON(struct {
+			Query          string          `json:"query"`
+			Answer         string          `json:"answer"`
+			AnswerLanguage string          `json:"answer_language,omitempty"`
+			Route          chat.Route      `json:"route"`
+			Provider       string          `json:"provider,omitempty"`
+			Trace          []chat.ToolStep `json:"trace,omitempty"`
+			Usage          usage.Totals    `json:"usage"`
+			*chat.StructuredAnswer
+		}{question, result.Answer, result.AnswerLanguage, result.Route, result.Provider, result.Trace, usage.FromContext(ctx), result.Structured})
+	}
+	fmt.Println(result.Answer)
+	if s := result.Structured; s != nil {
+		for _, b := range s.Bullets {
+			fmt.Println("- " + b)
+		}
+		for _, c := range s.Citations {
+			line := strings.TrimSpace("[" + c.Note + "] " + c.Quote)
+			if len(c.Commit) >= 7 {
+				line += fmt.Sprintf(" (%s, %s by %s)", c.Commit[:7], c.CommitDate, c.Author)
+			}
+			fmt.Println(line)
+		}
+	}
+	return nil
+}
+
+// printChunks lists retrieved chunks with their similarity and path relative to root.
+func printChunks(results []vector.VectorData, root string, asJSON bool) error {
+	type chunk struct {
+		Path       string            `json:"path"`
+		Similarity float32           `json:"similarity"`
+		Content    string            `json:"content"`
+		Metadata   map[string]string `json:"metadata"`
+	}
+	chunks := make([]chunk, len(results))
+	for i, r := range results {
+		chunks[i] = chunk{Path: displayPath(root, r.Metadata["filepath"]), Similarity: r.Similarity, Content: r.Content, Metadata: r.Metadata}
+	}
+	if asJSON {
+		return printJSON(chunks)
+	}
+
+	if len(chunks) == 0 {
+		fmt.Println("no matching chunks")
+		return nil
+	}
+	for i, c := range chunks {
+		fmt.Printf("%d. %.3f  %s\n   %s\n", i+1, c.Similarity, c.Path, excerpt(c.Content, 160))
+	}
+	return nil
+}
+
+// displayPath shortens the absolute path of a chunk to its path in the repository at root.
+func displayPath(root, path string) string {
+	if abs, err := filepath.Abs(root); err == nil {
+		if rel, err := filepath.Rel(abs, path); err == nil && !strings.HasPrefix(rel, "..") {
+			return rel
+		}
+	}
+	return path
+}
+
+// excerpt flattens content to one line of at most max characters.
+func excerpt(content string, max int) string {
+	runes := []rune(strings.Join(strings.Fields(content), " "))
+	if len(runes) > max {
+		return string(runes[:max]) + "..."
+	}
+	return string(runes)
+}
+
+// runExport writes every stored and trashed chunk in the format of a snapshot, which
+// /admin/snapshot/restore can read back.
+func runExport(args []string) error {
+	fs, verbose := newFlagSet("export", "export [-o file] [-v]")
+	out := fs.String("o", "", "write to this file instead of stdout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	setVerbose(*verbose)
+
+	a, err := newApp()
+	if err != nil {
+		return err
+	}
+
+	if *out == "" {
+		return a.vectors.Export(cliContext(), os.Stdout)
+	}
+	f, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	if err := a.vectors.Export(cliContext(), f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// runStats prints the size of the vector store and the indexing state of the repository.
+func runStats(args []string) error {
+	fs, verbose := newFlagSet("stats", "stats [-json] [-v]")
+	asJSON := fs.Bool("json", false, "print the statistics as JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	setVerbose(*verbose)
+
+	a, err := newApp()
+	if err != nil {
+		return err
+	}
+	count, err := a.vectors.Count(cliContext())
+	if err != nil {
+		return err
+	}
+	notes, err := a.cat.Len()
+	if err != nil {
+		return err
+	}
+	counts := a.man.Counts()
+
+	if *asJSON {
+		return printJSON(map[string]any{
+			"document_count": count,
+			"note_count":     notes,
+			"files":          counts,
+		})
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "chunks\t%d\n", count)
+	fmt.Fprintf(tw, "notes\t%d\n", notes)
+	for _, state := range []manifest.State{manifest.StateIndexed, manifest.StateSkipped, manifest.StatePending, manifest.StateFailed, manifest.StateDeleted} {
+		fmt.Fprintf(tw, "%s files\t%d\n", state, counts[state])
+	}
+	return tw.Flush()
+}
+
+// runEval scores retrieval against an evaluation set, by default the EVAL_FILE of the
+// repository, and compares the result with the previous run.
+func runEval(args []string) error {
+	fs, verbose := newFlagSet("eval", "eval [-file path] [-k n] [-rag] [-json] [-v]")
+	file := fs.String("file", "", "evaluation set to run instead of EVAL_FILE")
+	k := fs.Int("k", eval.DefaultK, "number of retrieved files an expected file must be among")
+	rag := fs.Bool("rag", false, "also answer every question through the LLM")
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	setVerbose(*verbose)
+	if *k <= 0 {
+		return fmt.Errorf("-k must be positive")
+	}
+
+	a, err := newApp()
+	if err != nil {
+		return err
+	}
+	cfg := a.cfg()
+	path := *file
+	if path == "" {
+		path = eval.FilePath(cfg, a.repo.Path())
+	}
+	cases, err := eval.Load(path)
+	if err != nil {
+		return err
+	}
+
+	runner := eval.Runner{
+		Cfg:         cfg,
+		Client:      a.client,
+		M:           a.vectors,
+		Root:        a.repo.Path(),
+		HistoryPath: filepath.Join(cfg.VectorStorageFolder, eval.HistoryFile),
+	}
+	report, err := runner.Run(cliContext(), cases, eval.Options{K: *k, RAG: *rag})
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return printJSON(report)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "rank\tquestion\tretrieved")
+	for _, res := range report.Results {
+		rank := "-"
+		if res.Hit {
+			rank = fmt.Sprint(res.Rank)
+		}
+		retrieved := strings.Join(res.Retrieved, ", ")
+		if res.Error != "" {
+			retrieved = "error: " + res.Error
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", rank, excerpt(res.Question, 60), retrieved)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if *rag {
+		for _, res := range report.Results {
+			fmt.Printf("\n%s\n%s\n", res.Question, res.Answer)
+		}
+	}
+
+	fmt.Printf("\n%d questions, k=%d: hit rate %.3f, MRR %.3f", report.Cases, report.K, report.HitRate, report.MRR)
+	if prev := report.Previous; prev != nil {
+		fmt.Printf(" (previous %s: %+.3f, %+.3f)", prev.Time, report.HitRate-prev.HitRate, report.MRR-prev.MRR)
+	}
+	fmt.Println()
+	return nil
+}
@@ -3,30 +3,648 @@ package config
 import (
 	"bufio"
 	"fmt"
+	"net/url"
 	"os"
 	"os/user"
 	"path/filepath"
 	"reflect"
+	"slices"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 )
 
-// Global config instance
+// current is the global config instance, set by InitConfig and swapped by Reload. It is
+// read through Current, so a reload never races with the requests reading it. New code
+// should take a Source (or the fields it needs) through its constructor instead.
+var current atomic.Pointer[EnvConfig]
+
+// Config mirrors the global config instance for callers that haven't been converted to a
+// Source; InitConfig and Reload keep it current.
+//
+// Deprecated: reading it races with Reload. Use Current, or take a Source.
 var Config *EnvConfig
 
+// Source yields the configuration to use for one unit of work (a request, a file).
+// Components whose settings can be reloaded take a Source rather than a snapshot.
+type Source func() *EnvConfig
+
+// Current is the Source backed by the global config, following reloads. It returns nil
+// before InitConfig.
+func Current() *EnvConfig {
+	return current.Load()
+}
+
+// Static returns a Source that always yields cfg, for running the packages with a fixed
+// configuration independent of the global, e.g. as a library or in tests.
+func Static(cfg *EnvConfig) Source {
+	return func() *EnvConfig { return cfg }
+}
+
+// ChatProviders returns the CHAT_PROVIDER priority list, lower-cased, without blanks.
+func (c *EnvConfig) ChatProviders() []string {
+	var out []string
+	for _, p := range strings.Split(c.ChatProvider, ",") {
+		if p = strings.ToLower(strings.TrimSpace(p)); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// SharedKeys returns the SHARED_API_KEYS list without blanks.
+func (c *EnvConfig) SharedKeys() []string {
+	return splitList(c.SharedAPIKeys)
+}
+
+// LanguageModels returns the VOYAGE_LANGUAGE_MODELS overrides keyed by lower-case
+// language code.
+func (c *EnvConfig) LanguageModels() map[string]string {
+	out := map[string]string{}
+	for _, item := range splitList(c.VoyageLanguageModels) {
+		if code, model, ok := strings.Cut(item, "="); ok {
+			out[strings.ToLower(strings.TrimSpace(code))] = strings.TrimSpace(model)
+		}
+	}
+	return out
+}
+
+// IndexIncludes returns the INDEX_INCLUDE globs without blanks.
+func (c *EnvConfig) IndexIncludes() []string {
+	return splitList(c.IndexInclude)
+}
+
+// IndexExcludes returns the INDEX_EXCLUDE globs without blanks.
+func (c *EnvConfig) IndexExcludes() []string {
+	return splitList(c.IndexExclude)
+}
+
+// IMAPFolderList returns the IMAP_FOLDERS without blanks.
+func (c *EnvConfig) IMAPFolderList() []string {
+	return splitList(c.IMAPFolders)
+}
+
+// IMAPAttachmentExtensionList returns the IMAP_ATTACHMENT_EXTENSIONS, lowercased and with a
+// leading dot.
+func (c *EnvConfig) IMAPAttachmentExtensionList() []string {
+	var out []string
+	for _, ext := range splitList(c.IMAPAttachmentExtensions) {
+		out = append(out, "."+strings.TrimPrefix(strings.ToLower(ext), "."))
+	}
+	return out
+}
+
+// FeedURLList returns the FEED_URLS without blanks.
+func (c *EnvConfig) FeedURLList() []string {
+	return splitList(c.FeedURLs)
+}
+
+// FeedTagList returns the FEED_TAGS without blanks.
+func (c *EnvConfig) FeedTagList() []string {
+	return splitList(c.FeedTags)
+}
+
+// BinaryExtensionList returns the BINARY_EXTENSIONS, lower-case and with a leading dot.
+func (c *EnvConfig) BinaryExtensionList() []string {
+	var out []string
+	for _, ext := range splitList(c.BinaryExtensions) {
+		out = append(out, "."+strings.TrimPrefix(strings.ToLower(ext), "."))
+	}
+	return out
+}
+
+// PathCollection is a PATH_COLLECTIONS entry: the files matching Glob, relative to the
+// repository root, are indexed into Collection.
+type PathCollection struct {
+	Glob       string
+	Collection string
+}
+
+// PathCollectionList returns the PATH_COLLECTIONS entries in order, keeping the valid ones if
+// any is invalid.
+func (c *EnvConfig) PathCollectionList() []PathCollection {
+	out, _ := c.pathCollections()
+	return out
+}
+
+// pathCollections parses PATH_COLLECTIONS, checking the collection names.
+func (c *EnvConfig) pathCollections() ([]PathCollection, error) {
+	var out []PathCollection
+	var firstErr error
+	for _, item := range splitList(c.PathCollections) {
+		glob, name, _ := strings.Cut(item, "=")
+		pc := PathCollection{Glob: strings.TrimSpace(glob), Collection: strings.TrimSpace(name)}
+		if err := CheckCollectionName(pc.Collection); err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("PATH_COLLECTIONS: %q: %v", item, err)
+			}
+			continue
+		}
+		out = append(out, pc)
+	}
+	return out, firstErr
+}
+
+// NotifyWebhook is a NOTIFY_WEBHOOKS entry: URL is posted to in the payload Format.
+type NotifyWebhook struct {
+	Format string
+	URL    string
+}
+
+// NotifyFormats are the payload formats of NOTIFY_WEBHOOKS
+var NotifyFormats = []string{"slack", "discord", "json"}
+
+// NotifyWebhookList returns the NOTIFY_WEBHOOKS entries in order, keeping the valid ones if
+// any is invalid.
+func (c *EnvConfig) NotifyWebhookList() []NotifyWebhook {
+	out, _ := c.notifyWebhooks()
+	return out
+}
+
+// notifyWebhooks parses NOTIFY_WEBHOOKS, checking the formats and URLs.
+func (c *EnvConfig) notifyWebhooks() ([]NotifyWebhook, error) {
+	var out []NotifyWebhook
+	var firstErr error
+	for _, item := range splitList(c.NotifyWebhooks) {
+		format, target, _ := strings.Cut(item, "=")
+		hook := NotifyWebhook{Format: strings.ToLower(strings.TrimSpace(format)), URL: strings.TrimSpace(target)}
+		var err error
+		if !slices.Contains(NotifyFormats, hook.Format) {
+			err = fmt.Errorf("format %q must be one of %s", hook.Format, strings.Join(NotifyFormats, ", "))
+		} else if u, perr := url.Parse(hook.URL); perr != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			err = fmt.Errorf("the URL of a %s webhook must be an absolute http(s) URL", hook.Format)
+		}
+		if err != nil {
+			// the URL holds the webhook's secret, so it is left out of the error
+			if firstErr == nil {
+				firstErr = fmt.Errorf("NOTIFY_WEBHOOKS: %v", err)
+			}
+			continue
+		}
+		out = append(out, hook)
+	}
+	return out, firstErr
+}
+
+// TelegramPollTimeout is how long the bot's getUpdates calls wait for a message. The bot
+// shares the client bounded by HTTP_TIMEOUT, which must therefore be longer.
+const TelegramPollTimeout = 30 * time.Second
+
+// TelegramAllowedUserIDs returns the TELEGRAM_ALLOWED_USERS, skipping entries that aren't
+// numeric IDs.
+func (c *EnvConfig) TelegramAllowedUserIDs() []int64 {
+	out, _ := c.telegramAllowedUsers()
+	return out
+}
+
+// telegramAllowedUsers parses TELEGRAM_ALLOWED_USERS.
+func (c *EnvConfig) telegramAllowedUsers() ([]int64, error) {
+	var out []int64
+	var firstErr error
+	for _, item := range splitList(c.TelegramAllowedUsers) {
+		id, err := strconv.ParseInt(item, 10, 64)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("TELEGRAM_ALLOWED_USERS: %q is not a numeric user ID", item)
+			}
+			continue
+		}
+		out = append(out, id)
+	}
+	return out, firstErr
+}
+
+// KnownFeatureFlags are the names FEATURE_FLAGS and the X-Vex-Flags header accept
+var KnownFeatureFlags = []string{"rerank", "multi_query", "hallucination_check"}
+
+// FeatureFlagSet returns the flags FEATURE_FLAGS turns on, skipping unknown names.
+func (c *EnvConfig) FeatureFlagSet() map[string]bool {
+	out, _ := c.featureFlags()
+	return out
+}
+
+// featureFlags parses FEATURE_FLAGS, checking the names.
+func (c *EnvConfig) featureFlags() (map[string]bool, error) {
+	out := map[string]bool{}
+	var firstErr error
+	for _, name := range splitList(c.FeatureFlags) {
+		name = strings.ToLower(name)
+		if !slices.Contains(KnownFeatureFlags, name) {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("FEATURE_FLAGS: unknown flag %q, expected one of %s", name, strings.Join(KnownFeatureFlags, ", "))
+			}
+			continue
+		}
+		out[name] = true
+	}
+	return out, firstErr
+}
+
+// TranscribeKey returns the key sent to TRANSCRIBE_URL: TRANSCRIBE_API_KEY, or
+// OPENAI_API_KEY when it is unset.
+func (c *EnvConfig) TranscribeKey() string {
+	if c.TranscribeAPIKey != "" {
+		return c.TranscribeAPIKey
+	}
+	return c.OpenAiAPIKey
+}
+
+// RouteLimit is the concurrency limit of a route (see ConcurrencyLimit).
+type RouteLimit struct {
+	// Concurrency is how many requests may run at once, 0 for no limit
+	Concurrency int
+	// Queue is how many more requests may wait for a slot
+	Queue int
+}
+
+// RouteLimit returns the concurrency limit of route: its CONCURRENCY_ROUTES entry if any,
+// CONCURRENCY_LIMIT and CONCURRENCY_QUEUE otherwise. A queue left out of an entry keeps
+// CONCURRENCY_QUEUE.
+func (c *EnvConfig) RouteLimit(route string) RouteLimit {
+	limits, _ := c.routeLimits()
+	if limit, ok := limits[route]; ok {
+		return limit
+	}
+	return RouteLimit{Concurrency: c.ConcurrencyLimit, Queue: c.ConcurrencyQueue}
+}
+
+// routeLimits parses CONCURRENCY_ROUTES, keeping the valid entries if any is invalid.
+func (c *EnvConfig) routeLimits() (map[string]RouteLimit, error) {
+	out := map[string]RouteLimit{}
+	var firstErr error
+	for _, item := range splitList(c.ConcurrencyRoutes) {
+		route, value, _ := strings.Cut(item, "=")
+		limit := RouteLimit{Queue: c.ConcurrencyQueue}
+		concurrency, queue, hasQueue := strings.Cut(strings.TrimSpace(value), ":")
+		var err error
+		if limit.Concurrency, err = strconv.Atoi(concurrency); err == nil && hasQueue {
+			limit.Queue, err = strconv.Atoi(queue)
+		}
+		if err != nil || limit.Concurrency < 0 || limit.Queue < 0 {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("CONCURRENCY_ROUTES: %q must be route=limit or route=limit:queue with non-negative integers", item)
+			}
+			continue
+		}
+		out[strings.TrimSpace(route)] = limit
+	}
+	return out, firstErr
+}
+
+// splitList splits a comma-separated variable, trimming entries and dropping blanks.
+func splitList(raw string) []string {
+	var out []string
+	for _, v := range strings.Split(raw, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// SnapshotDir returns SNAPSHOT_FOLDER, or a snapshots folder inside VECTOR_STORAGE_FOLDER
+// when it is unset.
+func (c *EnvConfig) SnapshotDir() string {
+	if c.SnapshotFolder != "" {
+		return c.SnapshotFolder
+	}
+	return filepath.Join(c.VectorStorageFolder, "snapshots")
+}
+
 // Env holds all environment variables loaded from .env file
 type Env map[string]string
 
-// EnvConfig holds the configuration loaded from .env file
+// EnvConfig holds the configuration loaded from .env file.
+//
+// Fields are populated from the variable named in the env tag, whose options are
+// "required" (must be set and non-empty) and "secret" (masked in the startup report).
+// A default tag supplies the value when the variable is unset, and a validate tag
+// checks the final value (see validators). Fields tagged reload:"true" are swapped in
+// by Reload at runtime; all others only take effect on restart.
 type EnvConfig struct {
-	ServerPort            string `env:"SERVER_PORT,required"`
-	GitUser               string `env:"GIT_USER,required"`
-	GitPAT                string `env:"GIT_PAT,required"`
-	CloneFolder           string `env:"CLONE_FOLDER,required"`
-	NotesRepo             string `env:"NOTES_REPO,required"`
-	VoyageAPIKey          string `env:"VOYAGE_API_KEY,required"`
-	OpenAiAPIKey          string `env:"OPENAI_API_KEY,required"`
-	VectorStorageFolder   string `env:"VECTOR_STORAGE_FOLDER,required"`
-	HardCodedAPIKeyForNow string `env:"HARD_CODED_API_KEY,required"`
+	ServerPort  int    `env:"SERVER_PORT" default:"8080" validate:"port"`
+	GitUser     string `env:"GIT_USER,required"`
+	GitPAT      string `env:"GIT_PAT,required,secret"`
+	CloneFolder string `env:"CLONE_FOLDER,required" validate:"dir"`
+	NotesRepo   string `env:"NOTES_REPO,required" validate:"url"`
+	// GitSubmodules checks out the notes repository's submodules and indexes their files
+	GitSubmodules bool `env:"GIT_SUBMODULES" default:"false"`
+	// EmbedProvider names the embedding provider registered with embed.Register
+	EmbedProvider string `env:"EMBED_PROVIDER" default:"voyage"`
+	// EnsembleProvider, if set, also embeds every chunk with this registered provider into a
+	// parallel collection and merges the results of both at query time; EnsembleModel
+	// replaces VOYAGE_MODEL for it, e.g. to compare two Voyage models
+	EnsembleProvider string `env:"ENSEMBLE_PROVIDER"`
+	EnsembleModel    string `env:"ENSEMBLE_MODEL"`
+	VoyageAPIKey     string `env:"VOYAGE_API_KEY,required,secret"`
+	// OpenAiAPIKey is only required when CHAT_PROVIDER is openai (see checkDependencies)
+	OpenAiAPIKey        string `env:"OPENAI_API_KEY,secret"`
+	VectorStorageFolder string `env:"VECTOR_STORAGE_FOLDER,required" validate:"dir"`
+	// VectorCollection names the collection the notes are indexed into and queried from by
+	// default; other named collections are created through /admin/collections
+	VectorCollection string `env:"VECTOR_COLLECTION" default:"notes" validate:"collection"`
+	// VectorBackend selects where the vectors are stored: chromem files in
+	// VECTOR_STORAGE_FOLDER, an existing Weaviate (WEAVIATE_URL), Milvus (MILVUS_URL),
+	// Redis with the search module (REDIS_URL) or OpenSearch (OPENSEARCH_URL), or only in
+	// memory, lost on restart (memory). It names a backend registered with
+	// manager.Register, checked when the store is opened
+	VectorBackend  string `env:"VECTOR_BACKEND" default:"chromem"`
+	WeaviateURL    string `env:"WEAVIATE_URL" default:"http://localhost:8080" validate:"url"`
+	WeaviateAPIKey string `env:"WEAVIATE_API_KEY,secret"`
+	// WeaviateClassPrefix starts the name of every class vex-backend creates, one per collection;
+	// Weaviate requires class names to start with an upper case letter
+	WeaviateClassPrefix string `env:"WEAVIATE_CLASS_PREFIX" default:"Vex"`
+	// WeaviateHybridAlpha below 1 answers queries with hybrid search, weighting the vector
+	// half by alpha and the keyword half by 1-alpha; 1 is a pure nearVector search
+	WeaviateHybridAlpha float64 `env:"WEAVIATE_HYBRID_ALPHA" default:"1" validate:"fraction" reload:"true"`
+	MilvusURL           string  `env:"MILVUS_URL" default:"http://localhost:19530" validate:"url"`
+	// MilvusToken is "user:password" or an API key, sent as the bearer token
+	MilvusToken            string `env:"MILVUS_TOKEN,secret"`
+	MilvusDatabase         string `env:"MILVUS_DATABASE" default:"default"`
+	MilvusCollectionPrefix string `env:"MILVUS_COLLECTION_PREFIX" default:"vex"`
+	// MilvusDimension is the vector length of the collections, which Milvus fixes when they
+	// are created; it must match the embedding model (1024 for voyage-4-large)
+	MilvusDimension int `env:"MILVUS_DIMENSION" default:"1024" validate:"positive"`
+	// MilvusHNSWM and MilvusHNSWEfConstruction are the HNSW index parameters of collections
+	// created from now on; MilvusSearchEf is the candidate list size of each search
+	MilvusHNSWM              int `env:"MILVUS_HNSW_M" default:"16" validate:"positive"`
+	MilvusHNSWEfConstruction int `env:"MILVUS_HNSW_EF_CONSTRUCTION" default:"200" validate:"positive"`
+	MilvusSearchEf           int `env:"MILVUS_SEARCH_EF" default:"64" validate:"positive" reload:"true"`
+	// RedisURL is redis:// or rediss:// (TLS), with the password and database number
+	RedisURL       string `env:"REDIS_URL,secret" default:"redis://localhost:6379/0" validate:"url=redis rediss"`
+	RedisKeyPrefix string `env:"REDIS_KEY_PREFIX" default:"vex"`
+	// RedisDimension is the vector length of the indexes, fixed when they are created
+	RedisDimension        int    `env:"REDIS_DIMENSION" default:"1024" validate:"positive"`
+	OpenSearchURL         string `env:"OPENSEARCH_URL" default:"http://localhost:9200" validate:"url"`
+	OpenSearchUsername    string `env:"OPENSEARCH_USERNAME"`
+	OpenSearchPassword    string `env:"OPENSEARCH_PASSWORD,secret"`
+	OpenSearchIndexPrefix string `env:"OPENSEARCH_INDEX_PREFIX" default:"vex"`
+	// OpenSearchDimension is the vector length of the indexes, fixed when they are created
+	OpenSearchDimension int `env:"OPENSEARCH_DIMENSION" default:"1024" validate:"positive"`
+	// OpenSearchHybridAlpha below 1 answers queries with hybrid search, weighting the
+	// normalized k-NN score by alpha and the BM25 score by 1-alpha; 1 is a pure k-NN search
+	OpenSearchHybridAlpha float64 `env:"OPENSEARCH_HYBRID_ALPHA" default:"1" validate:"fraction" reload:"true"`
+	// MemoryMaxDocuments caps the chunks per collection of VECTOR_BACKEND=memory, evicting
+	// the least recently used; 0 is unbounded
+	MemoryMaxDocuments int `env:"MEMORY_MAX_DOCUMENTS" default:"10000" validate:"nonnegative"`
+	// VectorQuantization stores the embeddings of VECTOR_BACKEND=memory as int8 (a quarter of
+	// the memory) or binary (a thirty-second) codes, and is rejected with any other backend;
+	// VectorQuantizationRescore > 0 keeps the full-precision vectors in a file to rescore that
+	// many times the requested results
+	VectorQuantization        string `env:"VECTOR_QUANTIZATION" default:"none" validate:"oneof=none int8 binary"`
+	VectorQuantizationRescore int    `env:"VECTOR_QUANTIZATION_RESCORE" default:"4" validate:"nonnegative"`
+	HardCodedAPIKeyForNow     string `env:"HARD_CODED_API_KEY,required,secret"`
+	// SharedAPIKeys are comma-separated read-only keys that never see private notes
+	SharedAPIKeys string `env:"SHARED_API_KEYS,secret" reload:"true"`
+	// HTTPTimeout bounds each request to the Voyage and OpenAI APIs
+	HTTPTimeout time.Duration `env:"HTTP_TIMEOUT" default:"2m" validate:"positive"`
+	// BreakerThreshold consecutive failures of a provider open its circuit breaker, failing
+	// calls to it fast for BreakerCooldown
+	BreakerThreshold int           `env:"BREAKER_THRESHOLD" default:"5" validate:"positive" reload:"true"`
+	BreakerCooldown  time.Duration `env:"BREAKER_COOLDOWN" default:"30s" validate:"positive" reload:"true"`
+
+	// VoyageModel is structural: changing it changes the embedding space and needs a re-index
+	VoyageModel string `env:"VOYAGE_MODEL" default:"voyage-4-large"`
+	// VoyageLanguageModels overrides VoyageModel for chunks detected as a given language, as
+	// comma-separated "code=model" pairs; just as structural as VoyageModel
+	VoyageLanguageModels string `env:"VOYAGE_LANGUAGE_MODELS" validate:"pairs"`
+	// EmbedDimensions truncates every embedding to this many dimensions, e.g. 512, to save
+	// memory; 0 keeps the model's full length. Structural like VoyageModel
+	EmbedDimensions int `env:"EMBED_DIMENSIONS" default:"0" validate:"nonnegative"`
+	// VoyageConcurrency is how many chunks of a file are embedded at once
+	VoyageConcurrency int `env:"VOYAGE_CONCURRENCY" default:"4" validate:"positive" reload:"true"`
+	// VoyageRPM and VoyageTPM are the account's requests and tokens per minute; embedding
+	// requests are paced to stay within them, 0 doesn't limit
+	VoyageRPM int `env:"VOYAGE_RPM" default:"2000" validate:"nonnegative" reload:"true"`
+	VoyageTPM int `env:"VOYAGE_TPM" default:"3000000" validate:"nonnegative" reload:"true"`
+	// QueryCacheSize and QueryCacheTTL bound the cache of query embeddings; 0 for either
+	// disables it
+	QueryCacheSize int           `env:"QUERY_CACHE_SIZE" default:"1000" validate:"nonnegative" reload:"true"`
+	QueryCacheTTL  time.Duration `env:"QUERY_CACHE_TTL" default:"1h" validate:"nonnegative" reload:"true"`
+
+	OpenAIModel string `env:"OPENAI_MODEL" default:"gpt-4o" reload:"true"`
+	// ChatProvider selects where answers are generated: openai, or local for a self-hosted
+	// OpenAI-compatible server (Ollama, llama.cpp, LM Studio, vLLM). A comma-separated list
+	// is a fallback chain tried in order, e.g. "openai,local".
+	ChatProvider string `env:"CHAT_PROVIDER" default:"openai" validate:"listof=openai local" reload:"true"`
+	// Digests summarize the notes changed since the previous digest
+	DigestSchedule string `env:"DIGEST_SCHEDULE" default:"off" validate:"oneof=off daily weekly" reload:"true"`
+	DigestCommit   bool   `env:"DIGEST_COMMIT" default:"false" reload:"true"`
+	DigestFolder   string `env:"DIGEST_FOLDER" default:"digests" reload:"true"`
+	// RAGTopK is how many chunks a RAG answer is generated from
+	RAGTopK int `env:"RAG_TOP_K" default:"4" validate:"positive" reload:"true"`
+	// AnswerStrategy is how the chunks of a RAG answer are put before the LLM: all in one
+	// prompt (stuff), in batches of RAG_TOP_K the LLM takes notes on before answering from
+	// the notes (map_reduce), or in batches it refines its answer with (refine). The latter
+	// two are given AnswerStrategyTopK chunks
+	AnswerStrategy     string `env:"ANSWER_STRATEGY" default:"stuff" validate:"oneof=stuff map_reduce refine" reload:"true"`
+	AnswerStrategyTopK int    `env:"ANSWER_STRATEGY_TOP_K" default:"16" validate:"positive" reload:"true"`
+	// ParentContext expands the chunks a RAG answer is given to the Markdown sections they
+	// lie in (section) or their whole note (note), as long as the context stays within
+	// ParentContextTokens; retrieval still matches on the chunks
+	ParentContext       string `env:"PARENT_CONTEXT" default:"off" validate:"oneof=off section note" reload:"true"`
+	ParentContextTokens int    `env:"PARENT_CONTEXT_TOKENS" default:"8000" validate:"positive" reload:"true"`
+	// A RAG query none of whose chunks is at least NoAnswerSimilarity similar is answered
+	// with "not in the notes": a fixed message without calling the LLM (skip), or by
+	// having the LLM say so (instruct)
+	NoAnswerSimilarity float64 `env:"NO_ANSWER_SIMILARITY" default:"0.25" validate:"fraction" reload:"true"`
+	NoAnswerMode       string  `env:"NO_ANSWER_MODE" default:"skip" validate:"oneof=skip instruct" reload:"true"`
+	// AgentMaxSteps caps the tool-calling rounds of a query in agent mode
+	AgentMaxSteps int `env:"AGENT_MAX_STEPS" default:"6" validate:"positive" reload:"true"`
+	// ChatProviderTimeout bounds each provider's attempt before moving down the chain
+	ChatProviderTimeout time.Duration `env:"CHAT_PROVIDER_TIMEOUT" default:"60s" validate:"positive" reload:"true"`
+	LocalLLMBaseURL     string        `env:"LOCAL_LLM_BASE_URL" default:"http://localhost:11434/v1" validate:"url" reload:"true"`
+	LocalLLMModel       string        `env:"LOCAL_LLM_MODEL" default:"llama3.1" reload:"true"`
+	LocalLLMAPIKey      string        `env:"LOCAL_LLM_API_KEY,secret" reload:"true"`
+	// Prompt overrides; empty keeps the built-in prompts
+	QueryOptimizationPrompt string `env:"QUERY_OPTIMIZATION_PROMPT" reload:"true"`
+	AnswerPrompt            string `env:"ANSWER_PROMPT" reload:"true"`
+	// AnswerPersona is merged into the prompts of every answer, built-in or overridden, to
+	// set tone, verbosity and citation style; a query may bring its own
+	AnswerPersona string `env:"ANSWER_PERSONA" reload:"true"`
+
+	RecencyWeight       float64 `env:"RECENCY_WEIGHT" default:"0.3" validate:"fraction" reload:"true"`
+	RecencyHalfLifeDays float64 `env:"RECENCY_HALF_LIFE_DAYS" default:"90" validate:"positive" reload:"true"`
+	// DedupSimilarityThreshold enables near-duplicate chunk removal when > 0
+	DedupSimilarityThreshold float64 `env:"DEDUP_SIMILARITY_THRESHOLD" default:"0" validate:"fraction" reload:"true"`
+	// SoftDelete moves deleted chunks to a trash for SoftDeleteRetention instead of dropping them
+	SoftDelete          bool          `env:"SOFT_DELETE" default:"false" reload:"true"`
+	SoftDeleteRetention time.Duration `env:"SOFT_DELETE_RETENTION" default:"168h" validate:"positive" reload:"true"`
+	// VersionHistory keeps the chunks of previous versions of a file, one per commit, in a
+	// parallel collection instead of dropping them, for queries with as_of
+	VersionHistory bool `env:"VERSION_HISTORY" default:"false"`
+	// Snapshots of the vector store; SnapshotFolder defaults to VECTOR_STORAGE_FOLDER/snapshots
+	SnapshotFolder string `env:"SNAPSHOT_FOLDER"`
+	SnapshotKeep   int    `env:"SNAPSHOT_KEEP" default:"10" validate:"positive" reload:"true"`
+	// EncryptionKey encrypts the stored vectors and snapshots; EncryptionKeyPrevious is only
+	// tried when reading, so the key can be rotated
+	EncryptionKey         string `env:"ENCRYPTION_KEY,secret"`
+	EncryptionKeyPrevious string `env:"ENCRYPTION_KEY_PREVIOUS,secret"`
+	// Redact replaces secrets and personal data in chunks before they are embedded and
+	// stored; RedactRules adds "name=regexp" lines to the built-in rules
+	Redact      bool   `env:"REDACT" default:"true" reload:"true"`
+	RedactRules string `env:"REDACT_RULES" validate:"patterns" reload:"true"`
+	// IndexInclude and IndexExclude are comma-separated gitignore-style globs, relative to the
+	// repository root, that narrow which files are indexed on top of the repo's .vexignore
+	IndexInclude string `env:"INDEX_INCLUDE" reload:"true"`
+	IndexExclude string `env:"INDEX_EXCLUDE" reload:"true"`
+	// Guards against notes that would be expensive or pointless to embed; 0 disables the
+	// size and chunk limits. MinContentLength counts letters and digits outside of links.
+	MaxFileSize      int64 `env:"MAX_FILE_SIZE" default:"5242880" validate:"nonnegative" reload:"true"`
+	MaxChunksPerFile int   `env:"MAX_CHUNKS_PER_FILE" default:"200" validate:"nonnegative" reload:"true"`
+	MinContentLength int   `env:"MIN_CONTENT_LENGTH" default:"1" validate:"nonnegative" reload:"true"`
+	// BinaryExtensions are comma-separated extensions of files that are skipped without being
+	// read, e.g. recordings too large to transcribe
+	BinaryExtensions string `env:"BINARY_EXTENSIONS" reload:"true"`
+	// PathCollections maps subpaths of the repository to collections of their own, as
+	// comma-separated "glob=collection" pairs, e.g. "work/**=work,personal/**=personal"; the
+	// first matching glob wins and other files go to the collection being indexed
+	PathCollections string `env:"PATH_COLLECTIONS" validate:"pairs" reload:"true"`
+	// NotionToken is the secret of the Notion integration /import/notion reads pages with;
+	// NotionURL is the API it calls
+	NotionToken string `env:"NOTION_TOKEN,secret" reload:"true"`
+	NotionURL   string `env:"NOTION_API_URL" default:"https://api.notion.com/v1" validate:"url" reload:"true"`
+	// ConfluenceURL is the wiki /import/confluence reads spaces from, e.g.
+	// https://example.atlassian.net/wiki. ConfluenceUser is the account's email on Confluence
+	// Cloud, with an API token as ConfluenceToken; left empty, the token is sent as a personal
+	// access token
+	ConfluenceURL   string `env:"CONFLUENCE_URL" validate:"url" reload:"true"`
+	ConfluenceUser  string `env:"CONFLUENCE_USER" reload:"true"`
+	ConfluenceToken string `env:"CONFLUENCE_TOKEN,secret" reload:"true"`
+	// IMAPURL is the mail server /import/imap reads, imaps://host[:port] or imap://host[:port]
+	// upgraded with STARTTLS; IMAPUser and IMAPPassword log in, with an app password where the
+	// provider asks for one. IMAPFolders are the folders (or Gmail labels) imported when a
+	// request names none, at most IMAPMaxMessages of the newest of each, into IMAPCollection.
+	// Attachments are skipped unless their extension is one of IMAPAttachmentExtensions
+	IMAPURL                  string `env:"IMAP_URL" validate:"url=imaps imap" reload:"true"`
+	IMAPUser                 string `env:"IMAP_USER" reload:"true"`
+	IMAPPassword             string `env:"IMAP_PASSWORD,secret" reload:"true"`
+	IMAPFolders              string `env:"IMAP_FOLDERS" default:"INBOX" reload:"true"`
+	IMAPMaxMessages          int    `env:"IMAP_MAX_MESSAGES" default:"500" validate:"nonnegative" reload:"true"`
+	IMAPCollection           string `env:"IMAP_COLLECTION" default:"mail" validate:"collection" reload:"true"`
+	IMAPAttachmentExtensions string `env:"IMAP_ATTACHMENT_EXTENSIONS" default:".txt,.md,.csv" reload:"true"`
+	// SlackCollection is the collection /import/slack imports workspace exports into;
+	// SlackMaxExportSize (bytes) rejects larger export zips, 0 disables the check
+	SlackCollection    string `env:"SLACK_COLLECTION" default:"slack" validate:"collection" reload:"true"`
+	SlackMaxExportSize int64  `env:"SLACK_MAX_EXPORT_SIZE" default:"536870912" validate:"nonnegative" reload:"true"`
+	// LFSFetch downloads the content of Git LFS pointers in the repository from its LFS server;
+	// without it pointers are skipped
+	LFSFetch bool `env:"LFS_FETCH" default:"false" reload:"true"`
+	// MaxDocuments and MaxEmbeddingMemory (bytes) cap the chunks the chromem store holds in
+	// memory, trash included; stores that would exceed them are rejected. 0 disables a cap
+	MaxDocuments       int   `env:"MAX_DOCUMENTS" default:"0" validate:"nonnegative" reload:"true"`
+	MaxEmbeddingMemory int64 `env:"MAX_EMBEDDING_MEMORY" default:"0" validate:"nonnegative" reload:"true"`
+	// OCRProvider extracts the text of images referenced from notes: off, tesseract (a local
+	// binary) or openai (a vision model)
+	OCRProvider  string `env:"OCR_PROVIDER" default:"off" validate:"oneof=off tesseract openai" reload:"true"`
+	OCRTesseract string `env:"OCR_TESSERACT_PATH" default:"tesseract" reload:"true"`
+	// OCRLanguages are tesseract's -l languages, e.g. "eng+deu"
+	OCRLanguages string `env:"OCR_LANGUAGES" default:"eng" reload:"true"`
+	OCRModel     string `env:"OCR_MODEL" default:"gpt-4o-mini" reload:"true"`
+	// Transcribe sends audio files in the repository to a Whisper-compatible transcription
+	// API and embeds the transcripts; TranscribeAPIKey defaults to OPENAI_API_KEY
+	Transcribe       bool   `env:"TRANSCRIBE" default:"false" reload:"true"`
+	TranscribeURL    string `env:"TRANSCRIBE_URL" default:"https://api.openai.com/v1/audio/transcriptions" validate:"url" reload:"true"`
+	TranscribeModel  string `env:"TRANSCRIBE_MODEL" default:"whisper-1" reload:"true"`
+	TranscribeAPIKey string `env:"TRANSCRIBE_API_KEY,secret" reload:"true"`
+	// TranscribeMaxFileSize takes the place of MaxFileSize for audio; Whisper accepts 25 MB
+	TranscribeMaxFileSize int64 `env:"TRANSCRIBE_MAX_FILE_SIZE" default:"26214400" validate:"nonnegative" reload:"true"`
+
+	// IngestRPM paces the fetches of each /ingest/sources connector, shared by all its syncs
+	// (0 for no limit); followed connectors that can't watch for changes sync every
+	// IngestInterval
+	IngestRPM      int           `env:"INGEST_RPM" default:"120" validate:"nonnegative" reload:"true"`
+	IngestInterval time.Duration `env:"INGEST_INTERVAL" default:"1h" validate:"positive" reload:"true"`
+
+	// FeedURLs are comma-separated RSS or Atom feeds polled every FeedInterval; the articles
+	// of their new items are ingested with FeedTags, the first poll of a feed taking no more
+	// than its FeedBackfill newest items
+	FeedURLs     string        `env:"FEED_URLS" reload:"true"`
+	FeedInterval time.Duration `env:"FEED_INTERVAL" default:"1h" validate:"positive" reload:"true"`
+	FeedTags     string        `env:"FEED_TAGS" default:"feed" reload:"true"`
+	FeedBackfill int           `env:"FEED_BACKFILL" default:"10" validate:"nonnegative" reload:"true"`
+
+	// NotifyWebhooks are outbound webhooks told about finished indexing runs, as comma-separated
+	// "format=url" pairs with format slack, discord or json; NotifyOn is failure to only hear
+	// about runs that failed or left files failed, always for every run
+	NotifyWebhooks string `env:"NOTIFY_WEBHOOKS,secret" validate:"pairs" reload:"true"`
+	NotifyOn       string `env:"NOTIFY_ON" default:"failure" validate:"oneof=failure always" reload:"true"`
+
+	// AlertNtfyURL is an ntfy topic URL and AlertPushoverToken/AlertPushoverUser a Pushover
+	// application and user key, pushed error-level events such as a provider going down or
+	// the repository rejecting the credentials; AlertThrottle is how long further alerts of
+	// the same kind are held back after one was sent
+	AlertNtfyURL       string        `env:"ALERT_NTFY_URL,secret" validate:"url=http https" reload:"true"`
+	AlertNtfyToken     string        `env:"ALERT_NTFY_TOKEN,secret" reload:"true"`
+	AlertPushoverToken string        `env:"ALERT_PUSHOVER_TOKEN,secret" reload:"true"`
+	AlertPushoverUser  string        `env:"ALERT_PUSHOVER_USER,secret" reload:"true"`
+	AlertThrottle      time.Duration `env:"ALERT_THROTTLE" default:"15m" validate:"nonnegative" reload:"true"`
+
+	// TelegramBotToken runs a Telegram bot answering questions like /query; only the users
+	// listed in TelegramAllowedUsers (comma-separated numeric IDs) get answers
+	TelegramBotToken     string `env:"TELEGRAM_BOT_TOKEN,secret"`
+	TelegramAllowedUsers string `env:"TELEGRAM_ALLOWED_USERS" reload:"true"`
+
+	// NoteURLTemplate links /quick-search results to the note, with {path} replaced by its
+	// path in the repository, e.g. obsidian://open?vault=Notes&file={path}
+	NoteURLTemplate string `env:"NOTE_URL_TEMPLATE" reload:"true"`
+
+	// ProbeInterval is how often the embedding and chat providers are sent a tiny request to
+	// record their latency and availability; 0 disables the probes. ProbeDailyLimit caps
+	// the probes of each provider per day, ProbeHistory the probes kept per provider
+	ProbeInterval   time.Duration `env:"PROBE_INTERVAL" default:"15m" validate:"nonnegative" reload:"true"`
+	ProbeDailyLimit int           `env:"PROBE_DAILY_LIMIT" default:"100" validate:"nonnegative" reload:"true"`
+	ProbeHistory    int           `env:"PROBE_HISTORY" default:"288" validate:"positive" reload:"true"`
+
+	// FeatureFlags turns experimental pipeline stages on, as a comma-separated list of flag
+	// names; requests can override them with the X-Vex-Flags header (see the flags package)
+	FeatureFlags string `env:"FEATURE_FLAGS" reload:"true"`
+
+	// ExperimentName runs an A/B experiment on /query: a share ExperimentShare of the queries
+	// is answered with the settings of the EXPERIMENT_VARIANT_* variables applied (see
+	// ExperimentVariant), the rest as configured, and both are logged for comparison
+	ExperimentName  string  `env:"EXPERIMENT_NAME" reload:"true"`
+	ExperimentShare float64 `env:"EXPERIMENT_SHARE" default:"0.1" validate:"fraction" reload:"true"`
+	// experimentVariant are the EXPERIMENT_VARIANT_* settings, by the env key they override
+	experimentVariant map[string]string
+
+	// QueryHistory keeps every /query, redacted like notes are, with its retrieval stats
+	// and latency for /analytics; entries older than QueryHistoryRetention are dropped (0
+	// keeps them all). A query whose best chunk is less similar than QueryHistoryMinSimilarity
+	// counts as finding nothing
+	QueryHistory              bool          `env:"QUERY_HISTORY" default:"false" reload:"true"`
+	QueryHistoryRetention     time.Duration `env:"QUERY_HISTORY_RETENTION" default:"2160h" validate:"nonnegative" reload:"true"`
+	QueryHistoryMinSimilarity float64       `env:"QUERY_HISTORY_MIN_SIMILARITY" default:"0.35" validate:"fraction" reload:"true"`
+
+	// WebhookDebounce coalesces webhook deliveries arriving within it into one sync run; 0
+	// runs one per delivery (still one at a time)
+	WebhookDebounce time.Duration `env:"WEBHOOK_DEBOUNCE" default:"2s" validate:"nonnegative" reload:"true"`
+	// BootstrapOnStart clones or pulls the notes repository and indexes all of it in the
+	// background when the server starts, so a fresh deployment doesn't wait for a push
+	BootstrapOnStart bool `env:"BOOTSTRAP_ON_START" default:"false"`
+
+	// Concurrency limits of the LLM-backed routes: at most ConcurrencyLimit requests per route
+	// run at once and ConcurrencyQueue more wait up to ConcurrencyQueueTimeout for a slot.
+	// ConcurrencyRoutes overrides them per route as comma-separated "route=limit[:queue]"
+	// pairs, e.g. "/query=8:16,/summarize=1"; a limit of 0 disables limiting
+	ConcurrencyLimit        int           `env:"CONCURRENCY_LIMIT" default:"4" validate:"nonnegative" reload:"true"`
+	ConcurrencyQueue        int           `env:"CONCURRENCY_QUEUE" default:"8" validate:"nonnegative" reload:"true"`
+	ConcurrencyQueueTimeout time.Duration `env:"CONCURRENCY_QUEUE_TIMEOUT" default:"30s" validate:"positive" reload:"true"`
+	ConcurrencyRoutes       string        `env:"CONCURRENCY_ROUTES" validate:"pairs" reload:"true"`
+
+	// EvalFile is the evaluation set run by /admin/eval and vex eval; relative paths are
+	// resolved against the notes repository, so it can be versioned with the notes
+	EvalFile string `env:"EVAL_FILE" default:"eval.yaml" reload:"true"`
+
+	// Chunking only affects files embedded after a change, so it can be reloaded
+	ChunkSize int `env:"CHUNK_SIZE" default:"50000" validate:"positive" reload:"true"`
+	// ChunkOverlap defaults to a fifth of ChunkSize when negative
+	ChunkOverlap  int    `env:"CHUNK_OVERLAP" default:"-1" reload:"true"`
+	ChunkStrategy string `env:"CHUNK_STRATEGY" default:"words" validate:"oneof=words fixed markdown code sentence" reload:"true"`
+	// ChunkWindow is how many sentences on either side of a chunk the sentence strategy
+	// stores with it, to answer from in its place
+	ChunkWindow int `env:"CHUNK_WINDOW" default:"3" validate:"nonnegative" reload:"true"`
 }
 
 // InitConfig loads and initializes the global config at startup
@@ -36,26 +654,43 @@ func InitConfig() error {
 		return err
 	}
 
-	Config = &EnvConfig{}
-	if err := env.Populate(Config); err != nil {
+	cfg := &EnvConfig{}
+	if err := env.Populate(cfg); err != nil {
 		return err
 	}
+	cfg.experimentVariant = experimentVariantFrom(env)
+	if err := cfg.checkDependencies(); err != nil {
+		return err
+	}
+	current.Store(cfg)
+	Config = cfg
 
 	return nil
 }
 
-// LoadEnv loads environment variables with OS env vars taking priority over .env file
+// LoadEnv loads environment variables with OS env vars taking priority over .env file.
+// The .env file is read from ENV_FILE when set, otherwise from the parent of the
+// working directory. Afterwards every KEY_FILE variable is resolved into KEY by
+// reading the referenced file (Docker/Kubernetes secrets), unless KEY is set directly.
 func LoadEnv() (Env, error) {
 	env := make(Env)
 
 	// First, try to load from .env file (if it exists)
-	cwd, err := os.Getwd()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get current working directory: %w", err)
+	envFilePath, explicit := os.LookupEnv("ENV_FILE")
+	if !explicit || envFilePath == "" {
+		cwd, err := os.Getwd()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get current working directory: %w", err)
+		}
+		envFilePath = filepath.Join(filepath.Dir(cwd), ".env")
+		explicit = false
 	}
 
-	envFilePath := filepath.Join(filepath.Dir(cwd), ".env")
-	file, err := os.Open(envFilePath)
+	file, err := os.Open(expandTilde(envFilePath))
+	if err != nil && explicit {
+		// an explicitly configured file that can't be read is a misconfiguration
+		return nil, fmt.Errorf("failed to open ENV_FILE: %w", err)
+	}
 	if err == nil {
 		// .env file exists, parse it
 		defer file.Close()
@@ -102,10 +737,70 @@ func LoadEnv() (Env, error) {
 		}
 	}
 
+	if err := resolveFileVars(env); err != nil {
+		return nil, err
+	}
+
 	return env, nil
 }
 
-// Populate populates a struct from environment variables using struct tags
+// resolveFileVars fills KEY from the contents of the file named by KEY_FILE.
+// A value set directly for KEY wins over its _FILE variant. Trailing whitespace
+// (typically the newline secret files end with) is trimmed.
+func resolveFileVars(env Env) error {
+	for fileKey, path := range env {
+		if !strings.HasSuffix(fileKey, "_FILE") || fileKey == "ENV_FILE" || path == "" {
+			continue
+		}
+		key := strings.TrimSuffix(fileKey, "_FILE")
+		if env[key] != "" {
+			continue
+		}
+
+		data, err := os.ReadFile(expandTilde(path))
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", fileKey, err)
+		}
+		env[key] = strings.TrimRight(string(data), " \t\r\n")
+	}
+	return nil
+}
+
+// fieldSpec is the parsed form of a struct field's env, default and validate tags.
+type fieldSpec struct {
+	key        string
+	required   bool
+	secret     bool
+	defaultVal string
+	hasDefault bool
+	validate   string
+}
+
+func parseFieldSpec(field reflect.StructField) (fieldSpec, bool) {
+	tag := field.Tag.Get("env")
+	if tag == "" {
+		return fieldSpec{}, false
+	}
+
+	// Parse the tag (format: "ENV_KEY[,required][,secret]")
+	parts := strings.Split(tag, ",")
+	spec := fieldSpec{key: parts[0]}
+	for _, opt := range parts[1:] {
+		switch opt {
+		case "required":
+			spec.required = true
+		case "secret":
+			spec.secret = true
+		}
+	}
+	spec.defaultVal, spec.hasDefault = field.Tag.Lookup("default")
+	spec.validate = field.Tag.Get("validate")
+	return spec, true
+}
+
+// Populate populates a struct from environment variables using struct tags. Supported
+// field kinds are string, bool, ints, floats and time.Duration. All problems (missing,
+// unparsable or invalid values) are collected and reported together.
 func (e Env) Populate(envConfig interface{}) error {
 	configValue := reflect.ValueOf(envConfig)
 	if configValue.Kind() != reflect.Ptr || configValue.IsNil() {
@@ -119,41 +814,100 @@ func (e Env) Populate(envConfig interface{}) error {
 
 	configType := configValue.Type()
 	var missingFields []string
+	var invalidFields []string
 
 	for i := 0; i < configType.NumField(); i++ {
 		field := configType.Field(i)
 		fieldValue := configValue.Field(i)
 
-		// Get the env tag
-		tag := field.Tag.Get("env")
-		if tag == "" {
+		spec, ok := parseFieldSpec(field)
+		if !ok || !fieldValue.CanSet() {
 			continue
 		}
 
-		// Parse the tag (format: "ENV_KEY" or "ENV_KEY,required")
-		parts := strings.Split(tag, ",")
-		envKey := parts[0]
-		isRequired := len(parts) > 1 && parts[1] == "required"
-
-		// Get the value from environment
-		value, exists := e[envKey]
+		// Get the value from environment, falling back to the default
+		value, exists := e[spec.key]
+		if (!exists || value == "") && spec.hasDefault {
+			value, exists = spec.defaultVal, true
+		}
 
 		// Check if required and missing
-		if isRequired && (!exists || value == "") {
-			missingFields = append(missingFields, fmt.Sprintf("%s (%s)", field.Name, envKey))
+		if spec.required && (!exists || value == "") {
+			missingFields = append(missingFields, fmt.Sprintf("%s (%s)", field.Name, spec.key))
+			continue
+		}
+		if !exists {
 			continue
 		}
 
-		// Set the field value if it's settable and we have a value
-		if fieldValue.CanSet() && exists {
-			fieldValue.SetString(value)
+		// the options of oneof are lower case, and so are the values its fields are compared with
+		if strings.HasPrefix(spec.validate, "oneof=") {
+			value = strings.ToLower(value)
+		}
+
+		if err := setField(fieldValue, value); err != nil {
+			invalidFields = append(invalidFields, fmt.Sprintf("%s: %v", spec.key, err))
+			continue
+		}
+
+		if spec.validate != "" && value != "" {
+			if err := validateField(spec.validate, fieldValue); err != nil {
+				invalidFields = append(invalidFields, fmt.Sprintf("%s: %v", spec.key, err))
+			}
 		}
 	}
 
+	var problems []string
 	if len(missingFields) > 0 {
-		return fmt.Errorf("missing required environment variables: %s", strings.Join(missingFields, ", "))
+		problems = append(problems, fmt.Sprintf("missing required environment variables: %s", strings.Join(missingFields, ", ")))
+	}
+	if len(invalidFields) > 0 {
+		problems = append(problems, fmt.Sprintf("invalid environment variables: %s", strings.Join(invalidFields, "; ")))
 	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+
+	return nil
+}
 
+// setField parses value into the field according to its type.
+func setField(fieldValue reflect.Value, value string) error {
+	value = strings.TrimSpace(value)
+
+	if fieldValue.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", value)
+		}
+		fieldValue.SetInt(int64(d))
+		return nil
+	}
+
+	switch fieldValue.Kind() {
+	case reflect.String:
+		fieldValue.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", value)
+		}
+		fieldValue.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, fieldValue.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", value)
+		}
+		fieldValue.SetInt(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(value, fieldValue.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid number %q", value)
+		}
+		fieldValue.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported field type %s", fieldValue.Type())
+	}
 	return nil
 }
 
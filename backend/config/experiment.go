@@ -0,0 +1,109 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// ExperimentVariantPrefix prefixes the settings the variant of EXPERIMENT_NAME answers with,
+// e.g. EXPERIMENT_VARIANT_OPENAI_MODEL=gpt-4o-mini. Any setting that can be reloaded can be
+// varied this way.
+const ExperimentVariantPrefix = "EXPERIMENT_VARIANT_"
+
+// experimentVariantFrom collects the EXPERIMENT_VARIANT_* variables of env, keyed by the
+// setting they override.
+func experimentVariantFrom(env Env) map[string]string {
+	out := map[string]string{}
+	for key, value := range env {
+		if setting, ok := strings.CutPrefix(key, ExperimentVariantPrefix); ok && setting != "" {
+			out[setting] = value
+		}
+	}
+	return out
+}
+
+// ExperimentSettings returns the env keys of the settings the experiment's variant
+// overrides, sorted, or nil when no experiment is running.
+func (c *EnvConfig) ExperimentSettings() []string {
+	if !c.ExperimentRunning() {
+		return nil
+	}
+	keys := make([]string, 0, len(c.experimentVariant))
+	for key := range c.experimentVariant {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ExperimentRunning reports whether a share of /query requests is answered by the variant
+// of EXPERIMENT_NAME.
+func (c *EnvConfig) ExperimentRunning() bool {
+	return c.ExperimentName != "" && c.ExperimentShare > 0
+}
+
+// ExperimentVariant returns the configuration the variant of the experiment answers with:
+// this one with the EXPERIMENT_VARIANT_* settings applied. It is nil when no experiment is
+// running, and the variant runs no experiment of its own.
+func (c *EnvConfig) ExperimentVariant() *EnvConfig {
+	if !c.ExperimentRunning() {
+		return nil
+	}
+	variant, _ := c.experimentVariantConfig()
+	return variant
+}
+
+// experimentVariantConfig applies the EXPERIMENT_VARIANT_* settings to a copy of c, checking
+// that they name settings that can be reloaded and that the result is valid.
+func (c *EnvConfig) experimentVariantConfig() (*EnvConfig, error) {
+	if c.ExperimentName == "" {
+		return nil, nil
+	}
+	if len(c.experimentVariant) == 0 {
+		return nil, fmt.Errorf("EXPERIMENT_NAME is set but no %s* variable sets what its variant changes", ExperimentVariantPrefix)
+	}
+
+	variant := *c
+	variant.ExperimentName, variant.experimentVariant = "", nil
+	v := reflect.ValueOf(&variant).Elem()
+	for _, key := range c.ExperimentSettings() {
+		value := c.experimentVariant[key]
+		i, spec, ok := reloadableField(v.Type(), key)
+		if !ok {
+			return nil, fmt.Errorf("%s%s: %s is not a setting that can be reloaded", ExperimentVariantPrefix, key, key)
+		}
+		if value == "" && spec.hasDefault {
+			value = spec.defaultVal
+		}
+		if err := setField(v.Field(i), value); err != nil {
+			return nil, fmt.Errorf("%s%s: %v", ExperimentVariantPrefix, key, err)
+		}
+		if spec.validate != "" && value != "" {
+			if err := validateField(spec.validate, v.Field(i)); err != nil {
+				return nil, fmt.Errorf("%s%s: %v", ExperimentVariantPrefix, key, err)
+			}
+		}
+	}
+	if err := variant.checkDependencies(); err != nil {
+		return nil, fmt.Errorf("the variant of experiment %q: %w", c.ExperimentName, err)
+	}
+	return &variant, nil
+}
+
+// reloadableField finds the field of t set by the env key, if it is tagged reload:"true".
+// The experiment's own settings can't be varied.
+func reloadableField(t reflect.Type, key string) (int, fieldSpec, bool) {
+	if strings.HasPrefix(key, "EXPERIMENT_") {
+		return 0, fieldSpec{}, false
+	}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		spec, ok := parseFieldSpec(field)
+		if ok && spec.key == key && field.Tag.Get("reload") == "true" {
+			return i, spec, true
+		}
+	}
+	return 0, fieldSpec{}, false
+}
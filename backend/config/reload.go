@@ -0,0 +1,64 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+var reloadMu sync.Mutex
+
+// Reload re-reads the environment (and .env file) and swaps in the fields tagged
+// reload:"true", returning the env keys whose values changed. The new configuration is
+// validated as a whole first; on error the current configuration is left untouched.
+//
+// The global pointer is swapped atomically rather than mutated, so code that read
+// Current once keeps a consistent snapshot for the rest of its work.
+func Reload() ([]string, error) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	prev := Current()
+	if prev == nil {
+		return nil, fmt.Errorf("config not initialized")
+	}
+
+	env, err := LoadEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	next := &EnvConfig{}
+	if err := env.Populate(next); err != nil {
+		return nil, err
+	}
+
+	updated := *prev
+	cur := reflect.ValueOf(&updated).Elem()
+	nv := reflect.ValueOf(next).Elem()
+	changed := []string{}
+	for i := 0; i < cur.NumField(); i++ {
+		field := cur.Type().Field(i)
+		if field.Tag.Get("reload") != "true" {
+			continue
+		}
+		if !reflect.DeepEqual(cur.Field(i).Interface(), nv.Field(i).Interface()) {
+			cur.Field(i).Set(nv.Field(i))
+			spec, _ := parseFieldSpec(field)
+			changed = append(changed, spec.key)
+		}
+	}
+
+	if variant := experimentVariantFrom(env); !reflect.DeepEqual(variant, updated.experimentVariant) {
+		updated.experimentVariant = variant
+		changed = append(changed, ExperimentVariantPrefix+"*")
+	}
+
+	if err := updated.checkDependencies(); err != nil {
+		return nil, err
+	}
+
+	current.Store(&updated)
+	Config = &updated
+	return changed, nil
+}
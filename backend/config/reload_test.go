@@ -0,0 +1,91 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// writeEnvFile writes an .env file with the required keys and extra, and points ENV_FILE at it.
+func writeEnvFile(t *testing.T, path, extra string) {
+	t.Helper()
+	dir := t.TempDir()
+	content := fmt.Sprintf(`GIT_USER=user
+GIT_PAT=pat
+CLONE_FOLDER=%s
+NOTES_REPO=https://example.com/notes.git
+VOYAGE_API_KEY=voyage
+OPENAI_API_KEY=openai
+VECTOR_STORAGE_FOLDER=%s
+HARD_CODED_API_KEY=key
+%s
+`, dir, dir, extra)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("ENV_FILE", path)
+}
+
+func TestReloadWhileReading(t *testing.T) {
+	envFile := filepath.Join(t.TempDir(), ".env")
+	writeEnvFile(t, envFile, "RAG_TOP_K=4")
+	if err := InitConfig(); err != nil {
+		t.Fatalf("InitConfig: %v", err)
+	}
+
+	stop := make(chan struct{})
+	var readers sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				// a request reads the config once and works with that snapshot
+				cfg := Current()
+				if k := cfg.RAGTopK; k != 4 && k != 8 {
+					t.Errorf("RAGTopK = %d, want 4 or 8", k)
+					return
+				}
+				_ = cfg.ChatProviders()
+			}
+		}()
+	}
+
+	for i := 0; i < 50; i++ {
+		writeEnvFile(t, envFile, fmt.Sprintf("RAG_TOP_K=%d", 4<<(i%2)))
+		if _, err := Reload(); err != nil {
+			t.Errorf("Reload: %v", err)
+			break
+		}
+	}
+	close(stop)
+	readers.Wait()
+
+	if got := Current().RAGTopK; got != 8 {
+		t.Errorf("RAGTopK after the last reload = %d, want 8", got)
+	}
+}
+
+func TestReloadKeepsConfigOnError(t *testing.T) {
+	envFile := filepath.Join(t.TempDir(), ".env")
+	writeEnvFile(t, envFile, "RAG_TOP_K=4")
+	if err := InitConfig(); err != nil {
+		t.Fatalf("InitConfig: %v", err)
+	}
+	before := Current()
+
+	writeEnvFile(t, envFile, "RAG_TOP_K=-1")
+	if _, err := Reload(); err == nil {
+		t.Fatal("Reload accepted RAG_TOP_K=-1")
+	}
+	if Current() != before {
+		t.Error("a failed reload replaced the config")
+	}
+}
@@ -0,0 +1,233 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// validateField checks a populated field against a validate tag. Supported rules:
+//
+//	port      integer in 1-65535
+//	positive  number > 0
+//	nonnegative number >= 0
+//	fraction  number in [0, 1]
+//	url       absolute http(s) or ssh URL; url=a b allows the schemes a and b instead
+//	dir       directory that exists or can be created
+//	oneof=a b value is one of the space separated options; Populate lower-cases the value first
+//	listof=a b comma-separated list whose items are each one of the options
+//	patterns  one "name=regexp" per line, each regexp valid
+//	pairs     comma-separated "key=value" items, neither side empty
+//	collection  a valid collection name (see CheckCollectionName)
+func validateField(rule string, v reflect.Value) error {
+	name, arg, _ := strings.Cut(rule, "=")
+	switch name {
+	case "port":
+		if n := v.Int(); n < 1 || n > 65535 {
+			return fmt.Errorf("port must be between 1 and 65535, got %d", n)
+		}
+	case "positive":
+		if numeric(v) <= 0 {
+			return fmt.Errorf("must be > 0, got %v", v.Interface())
+		}
+	case "nonnegative":
+		if numeric(v) < 0 {
+			return fmt.Errorf("must be >= 0, got %v", v.Interface())
+		}
+	case "fraction":
+		if f := numeric(v); f < 0 || f > 1 {
+			return fmt.Errorf("must be between 0 and 1, got %v", f)
+		}
+	case "url":
+		u, err := url.Parse(v.String())
+		if err != nil || u.Host == "" {
+			return fmt.Errorf("must be an absolute URL, got %q", v.String())
+		}
+		schemes := []string{"http", "https", "ssh"}
+		if arg != "" {
+			schemes = strings.Fields(arg)
+		}
+		if !slices.Contains(schemes, u.Scheme) {
+			return fmt.Errorf("unsupported URL scheme %q", u.Scheme)
+		}
+	case "dir":
+		path := v.String()
+		if err := os.MkdirAll(path, 0o755); err != nil {
+			return fmt.Errorf("directory %q is not usable: %v", path, err)
+		}
+		info, err := os.Stat(path)
+		if err != nil || !info.IsDir() {
+			return fmt.Errorf("%q is not a directory", path)
+		}
+	case "oneof":
+		options := strings.Fields(arg)
+		for _, o := range options {
+			if v.String() == o {
+				return nil
+			}
+		}
+		return fmt.Errorf("must be one of %s, got %q", strings.Join(options, ", "), v.String())
+	case "listof":
+		options := strings.Fields(arg)
+		items := 0
+	items:
+		for _, item := range strings.Split(v.String(), ",") {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+			items++
+			for _, o := range options {
+				if strings.EqualFold(item, o) {
+					continue items
+				}
+			}
+			return fmt.Errorf("items must be one of %s, got %q", strings.Join(options, ", "), item)
+		}
+		if items == 0 {
+			return fmt.Errorf("must list at least one of %s", strings.Join(options, ", "))
+		}
+	case "patterns":
+		for _, line := range strings.Split(v.String(), "\n") {
+			if line = strings.TrimSpace(line); line == "" {
+				continue
+			}
+			name, expr, ok := strings.Cut(line, "=")
+			if !ok || strings.TrimSpace(name) == "" {
+				return fmt.Errorf("lines must be name=regexp, got %q", line)
+			}
+			if _, err := regexp.Compile(strings.TrimSpace(expr)); err != nil {
+				return fmt.Errorf("pattern %q: %v", strings.TrimSpace(name), err)
+			}
+		}
+	case "pairs":
+		for _, item := range strings.Split(v.String(), ",") {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+			key, value, ok := strings.Cut(item, "=")
+			if !ok || strings.TrimSpace(key) == "" || strings.TrimSpace(value) == "" {
+				return fmt.Errorf("items must be key=value, got %q", item)
+			}
+		}
+	case "collection":
+		return CheckCollectionName(v.String())
+	default:
+		return fmt.Errorf("unknown validation rule %q", name)
+	}
+	return nil
+}
+
+// collectionName is what a collection may be called: lowercase letters, digits, '-' and
+// '_', starting with a letter or digit
+var collectionName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// CheckCollectionName reports whether name can name a vector collection. "trash" and names
+// containing "-reindex-" are reserved for the collections the vector store keeps itself.
+func CheckCollectionName(name string) error {
+	if !collectionName.MatchString(name) {
+		return fmt.Errorf("collection name must be 1-64 lowercase letters, digits, '-' or '_', got %q", name)
+	}
+	if name == "trash" || strings.Contains(name, "-reindex-") {
+		return fmt.Errorf("collection name %q is reserved", name)
+	}
+	return nil
+}
+
+// checkDependencies validates rules spanning several fields, which field tags can't express.
+func (c *EnvConfig) checkDependencies() error {
+	for _, p := range c.ChatProviders() {
+		if p == "openai" && c.OpenAiAPIKey == "" {
+			return fmt.Errorf("missing required environment variables: OpenAiAPIKey (OPENAI_API_KEY) when CHAT_PROVIDER includes openai")
+		}
+	}
+	if strings.EqualFold(c.OCRProvider, "openai") && c.OpenAiAPIKey == "" {
+		return fmt.Errorf("missing required environment variables: OpenAiAPIKey (OPENAI_API_KEY) when OCR_PROVIDER is openai")
+	}
+	// self-hosted transcription servers may not need a key, OpenAI's does
+	if c.Transcribe && c.TranscribeKey() == "" && strings.Contains(c.TranscribeURL, "api.openai.com") {
+		return fmt.Errorf("missing required environment variables: TranscribeAPIKey (TRANSCRIBE_API_KEY or OPENAI_API_KEY) when TRANSCRIBE is enabled")
+	}
+	if _, err := c.routeLimits(); err != nil {
+		return err
+	}
+	if _, err := c.pathCollections(); err != nil {
+		return err
+	}
+	if _, err := c.notifyWebhooks(); err != nil {
+		return err
+	}
+	if _, err := c.featureFlags(); err != nil {
+		return err
+	}
+	if (c.AlertPushoverToken == "") != (c.AlertPushoverUser == "") {
+		return fmt.Errorf("ALERT_PUSHOVER_TOKEN and ALERT_PUSHOVER_USER must be set together")
+	}
+	ids, err := c.telegramAllowedUsers()
+	if err != nil {
+		return err
+	}
+	// anyone can message a bot, so it must not answer everyone
+	if c.TelegramBotToken != "" && len(ids) == 0 {
+		return fmt.Errorf("missing required environment variables: TelegramAllowedUsers (TELEGRAM_ALLOWED_USERS) when TELEGRAM_BOT_TOKEN is set")
+	}
+	if c.TelegramBotToken != "" && c.HTTPTimeout <= TelegramPollTimeout {
+		return fmt.Errorf("HTTP_TIMEOUT must be longer than the Telegram poll timeout of %s when TELEGRAM_BOT_TOKEN is set, got %s", TelegramPollTimeout, c.HTTPTimeout)
+	}
+	// chromem-go and the database servers keep float32 vectors of their own
+	if c.VectorQuantization != "none" && c.VectorBackend != "memory" {
+		return fmt.Errorf("VECTOR_QUANTIZATION=%s needs VECTOR_BACKEND=memory, got %q", c.VectorQuantization, c.VectorBackend)
+	}
+	if c.NoteURLTemplate != "" && !strings.Contains(c.NoteURLTemplate, "{path}") {
+		return fmt.Errorf("NOTE_URL_TEMPLATE must contain {path}, got %q", c.NoteURLTemplate)
+	}
+	if _, err := c.experimentVariantConfig(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func numeric(v reflect.Value) float64 {
+	switch v.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return v.Float()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int())
+	}
+	return 0
+}
+
+// Report returns one "KEY=value" line per configured field, in declaration order, with
+// secrets masked, suitable for logging at startup.
+func Report(envConfig interface{}) []string {
+	v := reflect.Indirect(reflect.ValueOf(envConfig))
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+
+	var lines []string
+	for i := 0; i < v.NumField(); i++ {
+		spec, ok := parseFieldSpec(v.Type().Field(i))
+		if !ok || !v.Field(i).CanInterface() {
+			continue
+		}
+
+		value := fmt.Sprint(v.Field(i).Interface())
+		if spec.secret {
+			value = mask(value)
+		}
+		lines = append(lines, fmt.Sprintf("%s=%s", spec.key, value))
+	}
+	return lines
+}
+
+// mask hides a secret, revealing only whether it is set.
+func mask(secret string) string {
+	if secret == "" {
+		return "(unset)"
+	}
+	return "********"
+}
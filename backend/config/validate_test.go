@@ -0,0 +1,44 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPopulateLowercasesOneOf(t *testing.T) {
+	var cfg struct {
+		Mode string `env:"MODE" default:"skip" validate:"oneof=skip instruct"`
+	}
+	if err := (Env{"MODE": " Instruct "}).Populate(&cfg); err != nil {
+		t.Fatalf("Populate: %v", err)
+	}
+	if cfg.Mode != "instruct" {
+		t.Errorf("Mode = %q, want %q", cfg.Mode, "instruct")
+	}
+
+	if err := (Env{"MODE": "never"}).Populate(&cfg); err == nil {
+		t.Error("Populate accepted a value that isn't one of the options")
+	}
+}
+
+func TestQuantizationNeedsMemoryBackend(t *testing.T) {
+	cfg := EnvConfig{VectorBackend: "chromem", VectorQuantization: "int8"}
+	if err := cfg.checkDependencies(); err == nil {
+		t.Error("VECTOR_QUANTIZATION=int8 accepted with VECTOR_BACKEND=chromem")
+	}
+	cfg.VectorBackend = "memory"
+	if err := cfg.checkDependencies(); err != nil {
+		t.Errorf("VECTOR_QUANTIZATION=int8 with VECTOR_BACKEND=memory: %v", err)
+	}
+}
+
+func TestTelegramNeedsTimeoutAbovePoll(t *testing.T) {
+	cfg := EnvConfig{VectorQuantization: "none", TelegramBotToken: "token", TelegramAllowedUsers: "42", HTTPTimeout: 20 * time.Second}
+	if err := cfg.checkDependencies(); err == nil {
+		t.Error("HTTP_TIMEOUT=20s accepted with TELEGRAM_BOT_TOKEN set")
+	}
+	cfg.HTTPTimeout = 2 * time.Minute
+	if err := cfg.checkDependencies(); err != nil {
+		t.Errorf("HTTP_TIMEOUT=2m with TELEGRAM_BOT_TOKEN set: %v", err)
+	}
+}
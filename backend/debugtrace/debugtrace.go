@@ -0,0 +1,188 @@
+// Package debugtrace collects the golden trace of a single query for /query?debug=true: every
+// embedding and chat call with its latency and token counts, the stages of the pipeline, the
+// rewritten query, the retrieved chunks and the prompt the answer was generated from.
+// Collection is off unless the context carries a Trace, so the packages making the calls
+// record into it unconditionally, like they do for usage.
+package debugtrace
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// Call is one request to an embedding or chat API.
+type Call struct {
+	// Stage is the pipeline stage the call was made in, e.g. "optimize_query"
+	Stage string `json:"stage,omitempty"`
+	// Kind is "embedding" or "chat"
+	Kind       string  `json:"kind"`
+	Provider   string  `json:"provider"`
+	Model      string  `json:"model"`
+	DurationMS float64 `json:"duration_ms"`
+	// Tokens is the token count of an embedding request
+	Tokens           int    `json:"tokens,omitempty"`
+	PromptTokens     int    `json:"prompt_tokens,omitempty"`
+	CompletionTokens int    `json:"completion_tokens,omitempty"`
+	Error            string `json:"error,omitempty"`
+}
+
+// Stage is a step of the pipeline and how long it took, calls included.
+type Stage struct {
+	Name       string  `json:"name"`
+	DurationMS float64 `json:"duration_ms"`
+}
+
+// Chunk is a retrieved chunk as handed to the LLM.
+type Chunk struct {
+	ID         string            `json:"id"`
+	Path       string            `json:"path"`
+	Similarity float32           `json:"similarity"`
+	Content    string            `json:"content"`
+	Metadata   map[string]string `json:"metadata,omitempty"`
+}
+
+// Message is a message of the prompt.
+type Message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+	// SHA256 and Length stand in for Content in a report without content
+	SHA256 string `json:"sha256,omitempty"`
+	Length int    `json:"length,omitempty"`
+}
+
+// Report is the collected trace.
+type Report struct {
+	OptimizedQuery string    `json:"optimized_query,omitempty"`
+	Chunks         []Chunk   `json:"chunks"`
+	Prompt         []Message `json:"prompt,omitempty"`
+	Stages         []Stage   `json:"stages"`
+	Calls          []Call    `json:"calls"`
+	TotalMS        float64   `json:"total_ms"`
+}
+
+// Trace collects the trace of one query. All methods are safe on a nil Trace, which
+// records nothing.
+type Trace struct {
+	start time.Time
+
+	mu     sync.Mutex
+	report Report
+}
+
+type ctxKey struct{}
+
+type stageKey struct{}
+
+// New returns a context that collects a trace of everything done with it.
+func New(ctx context.Context) (context.Context, *Trace) {
+	t := &Trace{start: time.Now(), report: Report{Chunks: []Chunk{}, Stages: []Stage{}, Calls: []Call{}}}
+	return context.WithValue(ctx, ctxKey{}, t), t
+}
+
+// FromContext returns the trace carried by ctx, or nil if the query isn't traced.
+func FromContext(ctx context.Context) *Trace {
+	t, _ := ctx.Value(ctxKey{}).(*Trace)
+	return t
+}
+
+// StartStage marks the start of the stage name: calls made with the returned context are
+// attributed to it, and done records its duration.
+func StartStage(ctx context.Context, name string) (context.Context, func()) {
+	t := FromContext(ctx)
+	if t == nil {
+		return ctx, func() {}
+	}
+	start := time.Now()
+	return context.WithValue(ctx, stageKey{}, name), func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		t.report.Stages = append(t.report.Stages, Stage{Name: name, DurationMS: millis(time.Since(start))})
+	}
+}
+
+// RecordCall records c, which started at start, in the trace of ctx, if any.
+func RecordCall(ctx context.Context, start time.Time, c Call) {
+	t := FromContext(ctx)
+	if t == nil {
+		return
+	}
+	c.DurationMS = millis(time.Since(start))
+	c.Stage, _ = ctx.Value(stageKey{}).(string)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.report.Calls = append(t.report.Calls, c)
+}
+
+// SetOptimizedQuery records the query as rewritten for retrieval.
+func (t *Trace) SetOptimizedQuery(query string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.report.OptimizedQuery = query
+}
+
+// AddChunks records retrieved chunks.
+func (t *Trace) AddChunks(chunks ...Chunk) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.report.Chunks = append(t.report.Chunks, chunks...)
+}
+
+// SetPrompt records the messages the answer was generated from.
+func (t *Trace) SetPrompt(messages ...Message) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.report.Prompt = messages
+}
+
+// Report returns the trace collected so far.
+func (t *Trace) Report() Report {
+	if t == nil {
+		return Report{}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	r := t.report
+	r.Chunks = append(make([]Chunk, 0, len(r.Chunks)), r.Chunks...)
+	r.Stages = append(make([]Stage, 0, len(r.Stages)), r.Stages...)
+	r.Calls = append(make([]Call, 0, len(r.Calls)), r.Calls...)
+	r.TotalMS = millis(time.Since(t.start))
+	return r
+}
+
+// WithoutContent returns r with the content and metadata of the chunks left out, and the
+// prompt, which holds the chunks too, reduced to the hash and length of each message, for
+// keeping traces in logs without copying the notes into them.
+func (r Report) WithoutContent() Report {
+	chunks := make([]Chunk, len(r.Chunks))
+	for i, c := range r.Chunks {
+		c.Content, c.Metadata = "", nil
+		chunks[i] = c
+	}
+	r.Chunks = chunks
+
+	if r.Prompt != nil {
+		prompt := make([]Message, len(r.Prompt))
+		for i, m := range r.Prompt {
+			sum := sha256.Sum256([]byte(m.Content))
+			prompt[i] = Message{Role: m.Role, SHA256: hex.EncodeToString(sum[:]), Length: len(m.Content)}
+		}
+		r.Prompt = prompt
+	}
+	return r
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
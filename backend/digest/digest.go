@@ -0,0 +1,255 @@
+package digest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"vex-backend/chat"
+	"vex-backend/config"
+	"vex-backend/git"
+	"vex-backend/httpclient"
+	"vex-backend/usage"
+	"vex-backend/vector"
+	vectormgr "vex-backend/vector/manager"
+)
+
+// Schedules selectable through DIGEST_SCHEDULE
+const (
+	ScheduleOff    = "off"
+	ScheduleDaily  = "daily"
+	ScheduleWeekly = "weekly"
+)
+
+// checkInterval is how often the scheduler checks whether a digest is due
+const checkInterval = time.Hour
+
+// Period returns the time a digest covers for schedule; without a schedule (on-demand
+// digests) the first digest covers a week.
+func Period(schedule string) time.Duration {
+	if schedule == ScheduleDaily {
+		return 24 * time.Hour
+	}
+	return 7 * 24 * time.Hour
+}
+
+// State is what the generator remembers between digests, persisted as JSON.
+type State struct {
+	// LastCommit is the HEAD the previous digest covered up to
+	LastCommit string `json:"last_commit"`
+	LastRun    string `json:"last_run"`
+}
+
+// Digest is a generated digest.
+type Digest struct {
+	Markdown string           `json:"markdown"`
+	From     string           `json:"from_commit,omitempty"`
+	To       string           `json:"to_commit"`
+	Changes  []git.FileChange `json:"changes"`
+	// CommittedPath is where the digest was committed in the notes repository, if it was
+	CommittedPath string `json:"committed_path,omitempty"`
+	Provider      string `json:"provider,omitempty"`
+}
+
+// Generator builds digests of the notes changed since the previous digest.
+type Generator struct {
+	cfg       config.Source
+	client    httpclient.Doer
+	repo      *git.Repo
+	m         vectormgr.Manager
+	statePath string
+
+	// mu serializes digests so two runs never cover the same changes
+	mu    sync.Mutex
+	state State
+}
+
+// New returns a Generator whose state is kept at statePath. A missing state file means no
+// digest was made yet.
+func New(cfg config.Source, client httpclient.Doer, repo *git.Repo, m vectormgr.Manager, statePath string) (*Generator, error) {
+	g := &Generator{
+		cfg:       cfg,
+		client:    client,
+		repo:      repo,
+		m:         m,
+		statePath: statePath,
+	}
+
+	data, err := os.ReadFile(statePath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read digest state: %w", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &g.state); err != nil {
+			return nil, fmt.Errorf("failed to parse digest state: %w", err)
+		}
+	}
+	return g, nil
+}
+
+// Generate builds a digest of the markdown notes changed since the previous digest (or, for
+// the first one, within the configured period): an LLM summary of their indexed content
+// plus the git change list with line counts. With commit set, a non-empty digest is also
+// committed to DIGEST_FOLDER in the notes repository and pushed.
+func (g *Generator) Generate(ctx context.Context, commit bool) (Digest, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	cfg := g.cfg()
+	now := time.Now().UTC()
+	ctx = usage.WithSource(ctx, "digest")
+
+	changes, head, err := g.repo.ChangesSince(g.state.LastCommit, now.Add(-Period(cfg.DigestSchedule)))
+	if err != nil {
+		return Digest{}, err
+	}
+
+	folder := strings.Trim(filepath.ToSlash(cfg.DigestFolder), "/") + "/"
+	var notes []git.FileChange
+	var paths []string
+	for _, c := range changes {
+		// earlier digests are notes too, but not news
+		if strings.ToLower(filepath.Ext(c.Path)) != ".md" || strings.HasPrefix(c.Path, folder) {
+			continue
+		}
+		notes = append(notes, c)
+		if c.Action != git.ActionDeleted {
+			paths = append(paths, filepath.Join(g.repo.Path(), c.Path))
+		}
+	}
+
+	d := Digest{
+		From:    g.state.LastCommit,
+		To:      head,
+		Changes: notes,
+	}
+	if d.Changes == nil {
+		d.Changes = []git.FileChange{}
+	}
+
+	var summary *chat.Summary
+	if len(paths) > 0 {
+		s, err := chat.Summarize(ctx, cfg, g.client, g.m, chat.SummarizeTarget{Paths: paths}, false)
+		switch {
+		case err == nil:
+			summary = &s
+			d.Provider = s.Provider
+		case errors.Is(err, vector.ErrNotFound):
+			// changed notes that aren't indexed yet only appear in the change list
+		default:
+			return Digest{}, err
+		}
+	}
+	d.Markdown = render(now, notes, summary)
+
+	// an empty digest is returned but not worth a commit
+	if commit && len(notes) > 0 {
+		rel := filepath.Join(filepath.FromSlash(folder), now.Format("2006-01-02")+".md")
+		if err := g.repo.CommitFile(rel, []byte(d.Markdown), "Add digest for "+now.Format("2006-01-02")); err != nil {
+			return Digest{}, err
+		}
+		d.CommittedPath = filepath.ToSlash(rel)
+		// the digest commit itself must not show up in the next digest's range
+		if h, err := g.repo.Head(); err == nil {
+			head = h
+		}
+	}
+
+	g.state = State{LastCommit: head, LastRun: now.Format(time.RFC3339)}
+	if err := g.save(); err != nil {
+		log.Printf("[Digest] warning: failed to save state: %v", err)
+	}
+	return d, nil
+}
+
+// render formats a digest as markdown.
+func render(now time.Time, notes []git.FileChange, summary *chat.Summary) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Digest %s\n\n", now.Format("2006-01-02"))
+
+	if len(notes) == 0 {
+		b.WriteString("No notes changed since the last digest.\n")
+		return b.String()
+	}
+
+	if summary != nil {
+		b.WriteString("## Overview\n\n")
+		b.WriteString(summary.Summary)
+		b.WriteString("\n\n")
+		if len(summary.KeyPoints) > 0 {
+			b.WriteString("## Key points\n\n")
+			for _, p := range summary.KeyPoints {
+				fmt.Fprintf(&b, "- %s\n", p)
+			}
+			b.WriteString("\n")
+		}
+	}
+
+	b.WriteString("## Changed notes\n\n")
+	for _, c := range notes {
+		name := strings.TrimSuffix(c.Path, filepath.Ext(c.Path))
+		if c.Action == git.ActionDeleted {
+			fmt.Fprintf(&b, "- %s (%s)\n", name, c.Action)
+			continue
+		}
+		fmt.Fprintf(&b, "- [[%s]] (%s, +%d/-%d)\n", name, c.Action, c.Added, c.Deleted)
+	}
+	return b.String()
+}
+
+// Run generates digests on the DIGEST_SCHEDULE until ctx is done. The schedule is re-read
+// on every check, so it can be switched on or off by a config reload.
+func (g *Generator) Run(ctx context.Context) {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+
+	for {
+		cfg := g.cfg()
+		if cfg.DigestSchedule != "" && cfg.DigestSchedule != ScheduleOff && g.due(Period(cfg.DigestSchedule)) {
+			d, err := g.Generate(ctx, cfg.DigestCommit)
+			if err != nil {
+				log.Printf("[Digest] scheduled digest failed: %v", err)
+			} else {
+				log.Printf("[Digest] scheduled digest generated: notes=%d committed=%q", len(d.Changes), d.CommittedPath)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// due reports whether the previous digest is at least period old.
+func (g *Generator) due(period time.Duration) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	last, err := time.Parse(time.RFC3339, g.state.LastRun)
+	return err != nil || time.Since(last) >= period
+}
+
+// save writes the state file atomically. Callers must hold g.mu.
+func (g *Generator) save() error {
+	data, err := json.MarshalIndent(g.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(g.statePath), 0o755); err != nil {
+		return err
+	}
+	tmp := g.statePath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, g.statePath)
+}
@@ -0,0 +1,194 @@
+package eval
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Case is one question of an evaluation set and the files that should answer it.
+type Case struct {
+	Question string `json:"question"`
+	// Expected are paths relative to the repository root; retrieving any of them is a hit
+	Expected []string `json:"expected"`
+	// Tags restricts retrieval like the tags of /query
+	Tags []string `json:"tags,omitempty"`
+}
+
+// Load reads the evaluation set at path (see Parse).
+func Load(path string) ([]Case, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read evaluation set: %w", err)
+	}
+	cases, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return cases, nil
+}
+
+// Parse reads an evaluation set: a YAML list of cases, or the same as JSON.
+//
+//	# eval.yaml
+//	- question: How does Raft elect a leader?
+//	  expected:
+//	    - Academia/distributed/raft.md
+//	- question: "What is our retention policy?"
+//	  expected: [policies/retention.md, policies/backup.md]
+//	  tags: [work]
+//
+// Only this subset of YAML is understood: a top-level list of mappings whose values are
+// plain or quoted strings, block lists of them, or flow lists ([a, b]). Comments and
+// blank lines are ignored.
+func Parse(data []byte) ([]Case, error) {
+	if trimmed := strings.TrimSpace(string(data)); strings.HasPrefix(trimmed, "[") {
+		var cases []Case
+		if err := json.Unmarshal(data, &cases); err != nil {
+			return nil, err
+		}
+		return cases, validate(cases)
+	}
+
+	var cases []Case
+	var cur *Case
+	// list is the field receiving block list items, set by a key without a value
+	var list *[]string
+	for n, raw := range strings.Split(string(data), "\n") {
+		lineNo := n + 1
+		line := stripComment(raw)
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		indent := len(line) - len(strings.TrimLeft(line, " "))
+		line = strings.TrimSpace(line)
+
+		// a new case starts with "- " at the top level
+		if indent == 0 {
+			if !strings.HasPrefix(line, "- ") {
+				return nil, fmt.Errorf("line %d: expected a list item starting with \"- \"", lineNo)
+			}
+			cases = append(cases, Case{})
+			cur = &cases[len(cases)-1]
+			list = nil
+			line = strings.TrimSpace(line[2:])
+		} else if cur == nil {
+			return nil, fmt.Errorf("line %d: indented line outside of a list item", lineNo)
+		} else if strings.HasPrefix(line, "- ") {
+			if list == nil {
+				return nil, fmt.Errorf("line %d: list item without a key", lineNo)
+			}
+			v, err := scalar(line[2:])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNo, err)
+			}
+			*list = append(*list, v)
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key: value", lineNo)
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		list = nil
+		switch key {
+		case "question":
+			v, err := scalar(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNo, err)
+			}
+			cur.Question = v
+		case "expected", "tags":
+			field := &cur.Expected
+			if key == "tags" {
+				field = &cur.Tags
+			}
+			if value == "" {
+				list = field
+				continue
+			}
+			items, err := flowList(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNo, err)
+			}
+			*field = append(*field, items...)
+		default:
+			return nil, fmt.Errorf("line %d: unknown key %q", lineNo, key)
+		}
+	}
+	return cases, validate(cases)
+}
+
+func validate(cases []Case) error {
+	if len(cases) == 0 {
+		return fmt.Errorf("evaluation set has no questions")
+	}
+	for i, c := range cases {
+		if strings.TrimSpace(c.Question) == "" {
+			return fmt.Errorf("case %d has no question", i+1)
+		}
+		if len(c.Expected) == 0 {
+			return fmt.Errorf("case %d (%q) has no expected files", i+1, c.Question)
+		}
+	}
+	return nil
+}
+
+// stripComment removes a # comment, unless the # is inside quotes or part of a word.
+func stripComment(line string) string {
+	var quote rune
+	for i, r := range line {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// scalar unquotes a plain, 'single' or "double" quoted value.
+func scalar(v string) (string, error) {
+	v = strings.TrimSpace(v)
+	switch {
+	case strings.HasPrefix(v, `"`):
+		return strconv.Unquote(v)
+	case strings.HasPrefix(v, "'"):
+		if len(v) < 2 || !strings.HasSuffix(v, "'") {
+			return "", fmt.Errorf("unterminated quote in %s", v)
+		}
+		return strings.ReplaceAll(v[1:len(v)-1], "''", "'"), nil
+	}
+	return v, nil
+}
+
+// flowList reads [a, "b"] as a list, and a single value as a list of one.
+func flowList(v string) ([]string, error) {
+	if !strings.HasPrefix(v, "[") {
+		s, err := scalar(v)
+		return []string{s}, err
+	}
+	if !strings.HasSuffix(v, "]") {
+		return nil, fmt.Errorf("unterminated list %s", v)
+	}
+	var out []string
+	for _, item := range strings.Split(v[1:len(v)-1], ",") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+		s, err := scalar(item)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, nil
+}
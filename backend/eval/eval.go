@@ -0,0 +1,220 @@
+// Package eval measures retrieval quality against a set of questions whose answers are known
+// to be in particular notes, so the effect of chunking, model or ranking changes can be
+// measured instead of guessed. Each run reports the hit rate and mean reciprocal rank (MRR)
+// of the expected files and is compared with the previous run.
+package eval
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"vex-backend/chat"
+	"vex-backend/config"
+	"vex-backend/httpclient"
+	vectormgr "vex-backend/vector/manager"
+)
+
+// DefaultK is how many files a question may retrieve for its expected file to count as a hit
+const DefaultK = 5
+
+// HistoryFile is the name of the run history inside VECTOR_STORAGE_FOLDER
+const HistoryFile = "eval_history.jsonl"
+
+// chunksPerFile is how many chunks are retrieved per file considered, since several of
+// the top chunks often come from the same note
+const chunksPerFile = 3
+
+// Options tunes a run.
+type Options struct {
+	// K is the number of distinct files considered per question; 0 uses DefaultK
+	K int
+	// RAG also answers every question through the full pipeline and includes the answers,
+	// for reading alongside the scores
+	RAG bool
+}
+
+// CaseResult is the outcome of one question.
+type CaseResult struct {
+	Question string   `json:"question"`
+	Expected []string `json:"expected"`
+	// Retrieved are the distinct files retrieved, best first, relative to the repository root
+	Retrieved []string `json:"retrieved"`
+	// Rank is the 1-based position of the first expected file in Retrieved, 0 if none
+	Rank   int    `json:"rank"`
+	Hit    bool   `json:"hit"`
+	Answer string `json:"answer,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Summary is the aggregate of a run, as kept in the history.
+type Summary struct {
+	Time    string  `json:"time"`
+	K       int     `json:"k"`
+	Cases   int     `json:"cases"`
+	HitRate float64 `json:"hit_rate"`
+	MRR     float64 `json:"mrr"`
+	// the settings most likely to explain a change between runs
+	Model         string `json:"model"`
+	ChunkStrategy string `json:"chunk_strategy"`
+	ChunkSize     int    `json:"chunk_size"`
+}
+
+// Report is the outcome of a run.
+type Report struct {
+	Summary
+	DurationMS int64        `json:"duration_ms"`
+	Results    []CaseResult `json:"results"`
+	// Previous is the summary of the run before this one, if any
+	Previous *Summary `json:"previous,omitempty"`
+}
+
+// FilePath returns the evaluation set configured by EVAL_FILE for the repository at root.
+func FilePath(cfg *config.EnvConfig, root string) string {
+	if filepath.IsAbs(cfg.EvalFile) {
+		return cfg.EvalFile
+	}
+	return filepath.Join(root, cfg.EvalFile)
+}
+
+// Runner evaluates retrieval on a vector store.
+type Runner struct {
+	Cfg    *config.EnvConfig
+	Client httpclient.Doer
+	M      vectormgr.Manager
+	// Root is the repository root the expected paths are relative to
+	Root string
+	// HistoryPath is the JSON lines file summaries are appended to; "" keeps no history
+	HistoryPath string
+}
+
+// Run asks every question of cases and scores the files retrieved for it. A question that
+// fails counts as a miss and records its error; the run itself only fails if the history
+// can't be written.
+func (r Runner) Run(ctx context.Context, cases []Case, opts Options) (Report, error) {
+	start := time.Now()
+	k := opts.K
+	if k <= 0 {
+		k = DefaultK
+	}
+
+	report := Report{Summary: Summary{
+		Time:          start.UTC().Format(time.RFC3339),
+		K:             k,
+		Cases:         len(cases),
+		Model:         r.Cfg.VoyageModel,
+		ChunkStrategy: r.Cfg.ChunkStrategy,
+		ChunkSize:     r.Cfg.ChunkSize,
+	}}
+	var hits int
+	var reciprocal float64
+	for _, c := range cases {
+		res := r.runCase(ctx, c, k, opts.RAG)
+		if res.Hit {
+			hits++
+			reciprocal += 1 / float64(res.Rank)
+		}
+		report.Results = append(report.Results, res)
+	}
+	if len(cases) > 0 {
+		report.HitRate = float64(hits) / float64(len(cases))
+		report.MRR = reciprocal / float64(len(cases))
+	}
+	report.DurationMS = time.Since(start).Milliseconds()
+
+	if r.HistoryPath == "" {
+		return report, nil
+	}
+	report.Previous = lastSummary(r.HistoryPath)
+	return report, appendSummary(r.HistoryPath, report.Summary)
+}
+
+func (r Runner) runCase(ctx context.Context, c Case, k int, rag bool) CaseResult {
+	res := CaseResult{Question: c.Question, Expected: c.Expected, Retrieved: []string{}}
+	opts := chat.QueryOptions{Tags: c.Tags}
+
+	chunks, err := chat.Retrieve(ctx, r.Cfg, r.M, c.Question, k*chunksPerFile, opts)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	expected := make(map[string]bool, len(c.Expected))
+	for _, e := range c.Expected {
+		expected[filepath.ToSlash(filepath.Clean(e))] = true
+	}
+	seen := map[string]bool{}
+	for _, chunk := range chunks {
+		path := r.relative(chunk.Metadata["filepath"])
+		if seen[path] {
+			continue
+		}
+		seen[path] = true
+		res.Retrieved = append(res.Retrieved, path)
+		if res.Rank == 0 && expected[path] {
+			res.Rank = len(res.Retrieved)
+		}
+		if len(res.Retrieved) == k {
+			break
+		}
+	}
+	res.Hit = res.Rank > 0
+
+	if rag {
+		result, err := chat.ProcessQuery(ctx, r.Cfg, r.Client, r.M, c.Question, opts)
+		if err != nil {
+			res.Error = err.Error()
+		} else {
+			res.Answer = result.Answer
+		}
+	}
+	return res
+}
+
+// relative returns path relative to the repository root, with forward slashes.
+func (r Runner) relative(path string) string {
+	if root, err := filepath.Abs(r.Root); err == nil {
+		if rel, err := filepath.Rel(root, path); err == nil && !strings.HasPrefix(rel, "..") {
+			return filepath.ToSlash(rel)
+		}
+	}
+	return filepath.ToSlash(path)
+}
+
+// lastSummary returns the most recent summary in the history at path, or nil.
+func lastSummary(path string) *Summary {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var last *Summary
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var s Summary
+		if json.Unmarshal(scanner.Bytes(), &s) == nil {
+			last = &s
+		}
+	}
+	return last
+}
+
+func appendSummary(path string, s Summary) error {
+	line, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open evaluation history: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write evaluation history: %w", err)
+	}
+	return nil
+}
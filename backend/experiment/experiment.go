@@ -0,0 +1,349 @@
+// Package experiment runs A/B experiments on /query. While EXPERIMENT_NAME is set, a share
+// EXPERIMENT_SHARE of the queries is answered by the variant, the configuration with the
+// EXPERIMENT_VARIANT_* settings applied (another model, prompt or number of chunks), and the
+// rest by the control, the configuration as it is. Every query of an experiment is appended
+// to a JSON lines log with its arm, latency, token usage and golden trace, and so is the
+// feedback given on its answer, so the arms can be compared by Reports and read side by side.
+package experiment
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"vex-backend/config"
+	"vex-backend/debugtrace"
+	"vex-backend/usage"
+)
+
+// LogFile is the name of the experiment log inside VECTOR_STORAGE_FOLDER
+const LogFile = "experiments.jsonl"
+
+// The arms of an experiment
+const (
+	Control = "control"
+	Variant = "variant"
+)
+
+// Entry kinds of the log
+const (
+	KindQuery    = "query"
+	KindFeedback = "feedback"
+)
+
+// maxRemembered caps the queries feedback can still be given on, and the latencies kept per
+// arm for the percentiles
+const maxRemembered = 10000
+
+// ErrUnknownQuery is returned by Feedback for a request ID that wasn't answered in an
+// experiment, or too long ago.
+var ErrUnknownQuery = errors.New("no query of an experiment with this request ID")
+
+// Assignment is the arm a query was answered by.
+type Assignment struct {
+	Experiment string `json:"experiment"`
+	Arm        string `json:"arm"`
+}
+
+// Entry is a line of the log: a query answered in an experiment, or feedback on one.
+type Entry struct {
+	Kind string    `json:"kind"`
+	Time time.Time `json:"time"`
+	Assignment
+	RequestID string `json:"request_id"`
+
+	Query     string        `json:"query,omitempty"`
+	LatencyMS int64         `json:"latency_ms,omitempty"`
+	Error     string        `json:"error,omitempty"`
+	Usage     *usage.Totals `json:"usage,omitempty"`
+	// Trace leaves out the content of the chunks and the prompt
+	Trace *debugtrace.Report `json:"trace,omitempty"`
+
+	// Rating is 1 for a helpful answer and -1 for an unhelpful one
+	Rating int `json:"rating,omitempty"`
+}
+
+// arm is what the log says about an arm of an experiment.
+type arm struct {
+	queries, errors int
+	// latencies are those of the newest successful queries
+	latencies []int64
+	tokens    int
+	up, down  int
+}
+
+// ArmReport sums up how an arm of an experiment did.
+type ArmReport struct {
+	Arm     string `json:"arm"`
+	Queries int    `json:"queries"`
+	Errors  int    `json:"errors"`
+	// MeanMS, P50MS and P95MS are latencies of the successful queries
+	MeanMS int64 `json:"mean_ms"`
+	P50MS  int64 `json:"p50_ms"`
+	P95MS  int64 `json:"p95_ms"`
+	// MeanTokens are the LLM tokens, prompt and completion, spent per query
+	MeanTokens float64 `json:"mean_tokens"`
+	ThumbsUp   int     `json:"thumbs_up"`
+	ThumbsDown int     `json:"thumbs_down"`
+	// Approval is the share of the feedback that was positive, 0 without feedback
+	Approval float64 `json:"approval"`
+}
+
+// Report compares the arms of an experiment.
+type Report struct {
+	Name string `json:"name"`
+	// Running is whether the experiment is the one of EXPERIMENT_NAME; Share and Settings
+	// are only set then
+	Running  bool        `json:"running"`
+	Share    float64     `json:"share,omitempty"`
+	Settings []string    `json:"settings,omitempty"`
+	Started  time.Time   `json:"started"`
+	Arms     []ArmReport `json:"arms"`
+}
+
+// experiment is what the log says about an experiment.
+type experiment struct {
+	started time.Time
+	arms    map[string]*arm
+}
+
+// Tracker assigns queries to the arms of the running experiment and keeps their log.
+type Tracker struct {
+	cfg     config.Source
+	logPath string
+
+	mu          sync.Mutex
+	experiments map[string]*experiment
+	// assigned are the newest queries by request ID, in order, for feedback
+	assigned map[string]Assignment
+	order    []string
+	// ratings are the feedback given on them, so that feedback given again replaces it
+	ratings map[string]int
+}
+
+// New returns a Tracker logging to logPath, reading the experiments logged there so far. A
+// missing file means no experiment ran yet.
+func New(cfg config.Source, logPath string) (*Tracker, error) {
+	t := &Tracker{cfg: cfg, logPath: logPath, experiments: map[string]*experiment{}, assigned: map[string]Assignment{}, ratings: map[string]int{}}
+	f, err := os.Open(logPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return t, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("failed to read the experiment log %s, line %d: %w", logPath, line, err)
+		}
+		t.apply(e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read the experiment log %s: %w", logPath, err)
+	}
+	return t, nil
+}
+
+// Assign picks the arm a query is answered by and the configuration it is answered with.
+// Outside an experiment it returns cfg and an empty Assignment.
+func (t *Tracker) Assign(cfg *config.EnvConfig) (*config.EnvConfig, Assignment) {
+	variant := cfg.ExperimentVariant()
+	if variant == nil {
+		return cfg, Assignment{}
+	}
+	if rand.Float64() < cfg.ExperimentShare {
+		return variant, Assignment{Experiment: cfg.ExperimentName, Arm: Variant}
+	}
+	return cfg, Assignment{Experiment: cfg.ExperimentName, Arm: Control}
+}
+
+// RecordQuery logs a query answered by the arm of a, the error it failed with if any, and
+// what it cost. trace is the query's golden trace.
+func (t *Tracker) RecordQuery(a Assignment, requestID, query string, latency time.Duration, err error, totals usage.Totals, trace *debugtrace.Report) {
+	if a.Experiment == "" {
+		return
+	}
+	e := Entry{Kind: KindQuery, Time: time.Now().UTC(), Assignment: a, RequestID: requestID, Query: query, LatencyMS: latency.Milliseconds(), Usage: &totals}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	if trace != nil {
+		stripped := trace.WithoutContent()
+		e.Trace = &stripped
+	}
+	t.append(e)
+}
+
+// Feedback logs whether the answer to the query of requestID helped, returning the
+// assignment it counts for. Feedback given again on the same answer replaces the earlier.
+func (t *Tracker) Feedback(requestID string, helpful bool) (Assignment, error) {
+	t.mu.Lock()
+	a, ok := t.assigned[requestID]
+	t.mu.Unlock()
+	if !ok {
+		return Assignment{}, ErrUnknownQuery
+	}
+	e := Entry{Kind: KindFeedback, Time: time.Now().UTC(), Assignment: a, RequestID: requestID, Rating: -1}
+	if helpful {
+		e.Rating = 1
+	}
+	t.append(e)
+	return a, nil
+}
+
+// Reports compares the arms of every experiment logged, the running one first and the
+// others newest first.
+func (t *Tracker) Reports() []Report {
+	cfg := t.cfg()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	out := make([]Report, 0, len(t.experiments))
+	for name, x := range t.experiments {
+		r := Report{Name: name, Started: x.started, Arms: []ArmReport{}}
+		if cfg.ExperimentRunning() && name == cfg.ExperimentName {
+			r.Running, r.Share, r.Settings = true, cfg.ExperimentShare, cfg.ExperimentSettings()
+		}
+		for _, armName := range []string{Control, Variant} {
+			if a := x.arms[armName]; a != nil {
+				r.Arms = append(r.Arms, a.report(armName))
+			}
+		}
+		out = append(out, r)
+	}
+	if _, logged := t.experiments[cfg.ExperimentName]; cfg.ExperimentRunning() && !logged {
+		out = append(out, Report{Name: cfg.ExperimentName, Running: true, Share: cfg.ExperimentShare, Settings: cfg.ExperimentSettings(), Arms: []ArmReport{}})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Running != out[j].Running {
+			return out[i].Running
+		}
+		return out[i].Started.After(out[j].Started)
+	})
+	return out
+}
+
+func (a *arm) report(name string) ArmReport {
+	r := ArmReport{Arm: name, Queries: a.queries, Errors: a.errors, ThumbsUp: a.up, ThumbsDown: a.down}
+	if n := len(a.latencies); n > 0 {
+		var sum int64
+		for _, l := range a.latencies {
+			sum += l
+		}
+		r.MeanMS = sum / int64(n)
+		r.P50MS, r.P95MS = percentile(a.latencies, 0.5), percentile(a.latencies, 0.95)
+	}
+	if a.queries > 0 {
+		r.MeanTokens = float64(a.tokens) / float64(a.queries)
+	}
+	if feedback := a.up + a.down; feedback > 0 {
+		r.Approval = float64(a.up) / float64(feedback)
+	}
+	return r
+}
+
+// append writes e to the log and counts it.
+func (t *Tracker) append(e Entry) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.apply(e)
+	data, err := json.Marshal(e)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(t.logPath), 0o755)
+	}
+	if err == nil {
+		var f *os.File
+		if f, err = os.OpenFile(t.logPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644); err == nil {
+			_, err = f.Write(append(data, '\n'))
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+		}
+	}
+	if err != nil {
+		log.Printf("[Experiment] failed to log the %s of request %s: %v", e.Kind, e.RequestID, err)
+	}
+}
+
+// apply counts e in the experiment it belongs to.
+func (t *Tracker) apply(e Entry) {
+	x := t.experiments[e.Experiment]
+	if x == nil {
+		x = &experiment{started: e.Time, arms: map[string]*arm{}}
+		t.experiments[e.Experiment] = x
+	}
+	a := x.arms[e.Arm]
+	if a == nil {
+		a = &arm{}
+		x.arms[e.Arm] = a
+	}
+
+	switch e.Kind {
+	case KindQuery:
+		a.queries++
+		if e.Error != "" {
+			a.errors++
+		} else {
+			a.latencies = append(a.latencies, e.LatencyMS)
+			if len(a.latencies) > maxRemembered {
+				a.latencies = a.latencies[len(a.latencies)-maxRemembered:]
+			}
+		}
+		if e.Usage != nil {
+			a.tokens += e.Usage.OpenAIPromptTokens + e.Usage.OpenAICompletionTokens
+		}
+		if _, ok := t.assigned[e.RequestID]; !ok {
+			t.order = append(t.order, e.RequestID)
+		}
+		t.assigned[e.RequestID] = e.Assignment
+		if len(t.order) > maxRemembered {
+			delete(t.assigned, t.order[0])
+			delete(t.ratings, t.order[0])
+			t.order = t.order[1:]
+		}
+	case KindFeedback:
+		switch t.ratings[e.RequestID] {
+		case 1:
+			a.up--
+		case -1:
+			a.down--
+		}
+		t.ratings[e.RequestID] = e.Rating
+		if e.Rating > 0 {
+			a.up++
+		} else {
+			a.down++
+		}
+	}
+}
+
+// percentile returns the q-th percentile of values by the nearest-rank method, 0 for none.
+func percentile(values []int64, q float64) int64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]int64{}, values...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	rank := int(q*float64(len(sorted))+0.5) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
+}
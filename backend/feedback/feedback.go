@@ -0,0 +1,417 @@
+// Package feedback records whether answers of /query helped, to measure whether a change to
+// the prompts, the model or the chunking actually makes answers better. The newest queries
+// are remembered with their golden trace, so feedback given on one is stored with the trace
+// of how its answer came about, in a JSON lines file; the notes the answer was given from
+// have the feedback counted in the catalog. Summary groups the feedback by the settings the
+// answers were given with, or by route, provider, experiment arm, day or note.
+package feedback
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"vex-backend/catalog"
+	"vex-backend/config"
+	"vex-backend/debugtrace"
+	"vex-backend/experiment"
+	"vex-backend/redact"
+)
+
+// LogFile is the name of the feedback log inside VECTOR_STORAGE_FOLDER
+const LogFile = "feedback.jsonl"
+
+// Ratings
+const (
+	Up   = "up"
+	Down = "down"
+)
+
+// maxRecent caps the queries remembered for feedback; their traces hold the prompts, so
+// they aren't small
+const maxRecent = 1000
+
+// maxCommentLength bounds the comment given with feedback
+const maxCommentLength = 2000
+
+// Dimensions are what Summary can group the feedback by
+var Dimensions = []string{"settings", "route", "provider", "experiment", "day", "note"}
+
+// ErrUnknownQuery is returned by Record for a query ID that isn't among the newest queries.
+var ErrUnknownQuery = errors.New("no recent query with this ID")
+
+// Settings are the settings an answer was given with that prompt, model and chunking
+// changes touch, so that feedback can be compared across them.
+type Settings struct {
+	Model         string `json:"model"`
+	RAGTopK       int    `json:"rag_top_k"`
+	ChunkStrategy string `json:"chunk_strategy"`
+	ChunkSize     int    `json:"chunk_size"`
+	// Strategy is the context strategy of a RAG answer
+	Strategy string `json:"strategy,omitempty"`
+	// Prompt is "built-in", or a hash of the QUERY_OPTIMIZATION_PROMPT and ANSWER_PROMPT overrides
+	Prompt string `json:"prompt"`
+	// Flags are the feature flags that were on
+	Flags []string `json:"flags,omitempty"`
+}
+
+// SettingsOf returns the settings of cfg an answer given by the chat provider with the
+// feature flags and context strategy was given with.
+func SettingsOf(cfg *config.EnvConfig, provider string, flags map[string]bool, strategy string) Settings {
+	s := Settings{Model: cfg.OpenAIModel, RAGTopK: cfg.RAGTopK, ChunkStrategy: cfg.ChunkStrategy, ChunkSize: cfg.ChunkSize, Strategy: strategy, Prompt: "built-in"}
+	if provider == "local" {
+		s.Model = cfg.LocalLLMModel
+	}
+	if cfg.QueryOptimizationPrompt != "" || cfg.AnswerPrompt != "" {
+		sum := sha256.Sum256([]byte(cfg.QueryOptimizationPrompt + "\x00" + cfg.AnswerPrompt))
+		s.Prompt = hex.EncodeToString(sum[:])[:12]
+	}
+	for name, on := range flags {
+		if on {
+			s.Flags = append(s.Flags, name)
+		}
+	}
+	sort.Strings(s.Flags)
+	return s
+}
+
+// String names the settings in a line, e.g. "model=gpt-4o k=4 chunks=words/50000 prompt=built-in".
+func (s Settings) String() string {
+	out := fmt.Sprintf("model=%s k=%d chunks=%s/%d prompt=%s", s.Model, s.RAGTopK, s.ChunkStrategy, s.ChunkSize, s.Prompt)
+	// stuffing is how every answer was given before strategies could be chosen
+	if s.Strategy != "" && s.Strategy != "stuff" {
+		out += " strategy=" + s.Strategy
+	}
+	if len(s.Flags) > 0 {
+		out += " flags=" + strings.Join(s.Flags, ",")
+	}
+	return out
+}
+
+// Query is an answered query, remembered so feedback can be given on it.
+type Query struct {
+	ID       string    `json:"query_id"`
+	Time     time.Time `json:"time"`
+	Question string    `json:"query"`
+	Answer   string    `json:"answer"`
+	Route    string    `json:"route"`
+	Provider string    `json:"provider,omitempty"`
+	Settings Settings  `json:"settings"`
+	// Experiment is the arm of the experiment that answered, if one ran
+	Experiment *experiment.Assignment `json:"experiment,omitempty"`
+	Trace      *debugtrace.Report     `json:"trace,omitempty"`
+}
+
+// notes returns the notes the query's answer was given from, each once.
+func (q Query) notes() []string {
+	if q.Trace == nil {
+		return nil
+	}
+	var out []string
+	seen := map[string]bool{}
+	for _, c := range q.Trace.Chunks {
+		if c.Path != "" && !seen[c.Path] {
+			seen[c.Path] = true
+			out = append(out, c.Path)
+		}
+	}
+	return out
+}
+
+// Entry is feedback on an answer, with the query it was given on.
+type Entry struct {
+	Query
+	// Rating is Up or Down
+	Rating  string    `json:"rating"`
+	Comment string    `json:"comment,omitempty"`
+	RatedAt time.Time `json:"rated_at"`
+	// Notes are the notes the answer was given from
+	Notes []string `json:"notes"`
+}
+
+// Tally counts feedback.
+type Tally struct {
+	Ratings int `json:"ratings"`
+	Up      int `json:"up"`
+	Down    int `json:"down"`
+	// Approval is the share of the ratings that were up, 0 without ratings
+	Approval float64 `json:"approval"`
+	Comments int     `json:"comments"`
+}
+
+func (t *Tally) add(e Entry) {
+	t.Ratings++
+	if e.Rating == Up {
+		t.Up++
+	} else {
+		t.Down++
+	}
+	if e.Comment != "" {
+		t.Comments++
+	}
+	t.Approval = float64(t.Up) / float64(t.Ratings)
+}
+
+// Group is the feedback of the answers sharing a value of the dimension grouped by.
+type Group struct {
+	Key string `json:"key"`
+	Tally
+}
+
+// Store remembers the newest queries and keeps the feedback given on them.
+type Store struct {
+	cfg     config.Source
+	logPath string
+	cat     *catalog.Catalog
+
+	mu     sync.Mutex
+	recent map[string]Query
+	order  []string
+	// entries are the feedback given, by query ID, without their traces, which stay in the log
+	entries map[string]Entry
+}
+
+// New returns a Store logging to logPath, reading the feedback given so far and counting it
+// in cat. A missing file means no feedback was given yet.
+func New(cfg config.Source, logPath string, cat *catalog.Catalog) (*Store, error) {
+	s := &Store{cfg: cfg, logPath: logPath, cat: cat, recent: map[string]Query{}, entries: map[string]Entry{}}
+	err := s.scan(func(e Entry) { s.apply(e) })
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Remember keeps q so feedback can be given on it, forgetting the oldest of the remembered
+// queries beyond maxRecent.
+func (s *Store) Remember(q Query) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.recent[q.ID]; !ok {
+		s.order = append(s.order, q.ID)
+	}
+	s.recent[q.ID] = q
+	if len(s.order) > maxRecent {
+		delete(s.recent, s.order[0])
+		s.order = s.order[1:]
+	}
+}
+
+// Record stores feedback on the remembered query id: rating is Up or Down, comment is
+// optional. Feedback given again on the same query replaces the earlier.
+func (s *Store) Record(id, rating, comment string) (Entry, error) {
+	if rating != Up && rating != Down {
+		return Entry{}, fmt.Errorf("rating must be %q or %q, got %q", Up, Down, rating)
+	}
+	if len(comment) > maxCommentLength {
+		return Entry{}, fmt.Errorf("comment must not exceed %d bytes", maxCommentLength)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q, ok := s.recent[id]
+	if !ok {
+		return Entry{}, ErrUnknownQuery
+	}
+	// the log keeps the query redacted and the trace without the notes' content
+	q.Question, _ = redact.Apply(q.Question, redact.QueryRules(s.cfg()))
+	if q.Trace != nil {
+		stripped := q.Trace.WithoutContent()
+		q.Trace = &stripped
+	}
+	e := Entry{Query: q, Rating: rating, Comment: strings.TrimSpace(comment), RatedAt: time.Now().UTC(), Notes: q.notes()}
+	if e.Notes == nil {
+		e.Notes = []string{}
+	}
+
+	data, err := json.Marshal(e)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(s.logPath), 0o755)
+	}
+	if err == nil {
+		var f *os.File
+		if f, err = os.OpenFile(s.logPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600); err == nil {
+			_, err = f.Write(append(data, '\n'))
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+		}
+	}
+	if err != nil {
+		log.Printf("[Feedback] failed to log the feedback on query %s: %v", id, err)
+		return Entry{}, err
+	}
+	s.apply(e)
+	return e, nil
+}
+
+// Get returns the feedback on the query id, with the trace of its answer.
+func (s *Store) Get(id string) (Entry, bool, error) {
+	s.mu.Lock()
+	_, ok := s.entries[id]
+	s.mu.Unlock()
+	if !ok {
+		return Entry{}, false, nil
+	}
+	var found Entry
+	err := s.scan(func(e Entry) {
+		if e.ID == id {
+			found = e
+		}
+	})
+	return found, err == nil, err
+}
+
+// Filter selects feedback. Zero fields match everything.
+type Filter struct {
+	Since  time.Time
+	Rating string
+}
+
+func (f Filter) matches(e Entry) bool {
+	return !e.RatedAt.Before(f.Since) && (f.Rating == "" || e.Rating == f.Rating)
+}
+
+// List returns the feedback matching f, newest first and without traces, at most limit
+// entries (0 for all), along with the number of matches.
+func (s *Store) List(f Filter, limit int) ([]Entry, int) {
+	s.mu.Lock()
+	out := []Entry{}
+	for _, e := range s.entries {
+		if f.matches(e) {
+			out = append(out, e)
+		}
+	}
+	s.mu.Unlock()
+
+	sort.Slice(out, func(i, j int) bool { return out[i].RatedAt.After(out[j].RatedAt) })
+	total := len(out)
+	if limit > 0 && len(out) > limit {
+		out = out[:limit]
+	}
+	return out, total
+}
+
+// Summary tallies the feedback matching f overall and grouped by the dimension by, one of
+// Dimensions. Groups are ordered by day for "day" and by their number of ratings otherwise.
+func (s *Store) Summary(f Filter, by string) (Tally, []Group) {
+	var total Tally
+	groups := map[string]*Group{}
+	count := func(key string, e Entry) {
+		g := groups[key]
+		if g == nil {
+			g = &Group{Key: key}
+			groups[key] = g
+		}
+		g.add(e)
+	}
+
+	s.mu.Lock()
+	for _, e := range s.entries {
+		if !f.matches(e) {
+			continue
+		}
+		total.add(e)
+		switch by {
+		case "settings":
+			count(e.Settings.String(), e)
+		case "route":
+			count(e.Route, e)
+		case "provider":
+			count(e.Provider, e)
+		case "experiment":
+			if e.Experiment != nil {
+				count(e.Experiment.Experiment+"/"+e.Experiment.Arm, e)
+			}
+		case "day":
+			count(e.RatedAt.Format(time.DateOnly), e)
+		case "note":
+			for _, note := range e.Notes {
+				count(note, e)
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	out := make([]Group, 0, len(groups))
+	for _, g := range groups {
+		out = append(out, *g)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if by != "day" && out[i].Ratings != out[j].Ratings {
+			return out[i].Ratings > out[j].Ratings
+		}
+		return out[i].Key < out[j].Key
+	})
+	return total, out
+}
+
+// apply keeps e, without its trace, replacing earlier feedback on the same query, and counts
+// it for its notes in the catalog; s.mu must be held.
+func (s *Store) apply(e Entry) {
+	if prev, ok := s.entries[e.ID]; ok {
+		up, down := counts(prev.Rating)
+		s.cat.AddFeedback(prev.Notes, -up, -down)
+	}
+	e.Trace = nil
+	s.entries[e.ID] = e
+	up, down := counts(e.Rating)
+	s.cat.AddFeedback(e.Notes, up, down)
+}
+
+func counts(rating string) (up, down int) {
+	if rating == Up {
+		return 1, 0
+	}
+	return 0, 1
+}
+
+// scan calls fn with every entry of the log, in order.
+func (s *Store) scan(fn func(Entry)) error {
+	f, err := os.Open(s.logPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return fmt.Errorf("failed to read the feedback log %s, line %d: %w", s.logPath, line, err)
+		}
+		fn(e)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read the feedback log %s: %w", s.logPath, err)
+	}
+	return nil
+}
+
+// ParseDimension checks by, returning "settings" for an empty one.
+func ParseDimension(by string) (string, error) {
+	if by == "" {
+		return "settings", nil
+	}
+	for _, d := range Dimensions {
+		if by == d {
+			return by, nil
+		}
+	}
+	return "", fmt.Errorf("must be one of %s, got %s", strings.Join(Dimensions, ", "), strconv.Quote(by))
+}
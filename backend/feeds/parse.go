@@ -0,0 +1,157 @@
+package feeds
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html/charset"
+)
+
+// Item is an entry of a feed.
+type Item struct {
+	// ID identifies the item across polls: its guid or Atom id, its link otherwise
+	ID        string
+	Title     string
+	Link      string
+	Author    string
+	Published time.Time
+}
+
+// Feed is a parsed RSS or Atom feed.
+type Feed struct {
+	Title string
+	Items []Item
+}
+
+// feedXML covers RSS 2.0, RSS 1.0 (whose items sit next to the channel) and Atom alike; the
+// decoder matches elements by local name, whatever their namespace.
+type feedXML struct {
+	XMLName xml.Name
+	Channel struct {
+		Title string    `xml:"title"`
+		Items []rssItem `xml:"item"`
+	} `xml:"channel"`
+	Items   []rssItem   `xml:"item"`
+	Title   string      `xml:"title"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type rssItem struct {
+	Title   string `xml:"title"`
+	Link    string `xml:"link"`
+	GUID    string `xml:"guid"`
+	PubDate string `xml:"pubDate"`
+	// dc:date and dc:creator
+	Date    string `xml:"date"`
+	Author  string `xml:"author"`
+	Creator string `xml:"creator"`
+}
+
+type atomEntry struct {
+	ID    string `xml:"id"`
+	Title string `xml:"title"`
+	Links []struct {
+		Href string `xml:"href,attr"`
+		Rel  string `xml:"rel,attr"`
+	} `xml:"link"`
+	Published string `xml:"published"`
+	Updated   string `xml:"updated"`
+	Author    struct {
+		Name string `xml:"name"`
+	} `xml:"author"`
+}
+
+// Parse reads an RSS or Atom feed, in any encoding it declares.
+func Parse(data []byte) (Feed, error) {
+	d := xml.NewDecoder(bytes.NewReader(data))
+	d.Strict = false
+	d.Entity = xml.HTMLEntity
+	d.CharsetReader = func(label string, input io.Reader) (io.Reader, error) {
+		return charset.NewReaderLabel(label, input)
+	}
+	var x feedXML
+	if err := d.Decode(&x); err != nil {
+		return Feed{}, fmt.Errorf("failed to parse the feed: %w", err)
+	}
+
+	switch strings.ToLower(x.XMLName.Local) {
+	case "feed":
+		f := Feed{Title: clean(x.Title)}
+		for _, e := range x.Entries {
+			it := Item{ID: clean(e.ID), Title: clean(e.Title), Link: atomLink(e), Author: clean(e.Author.Name)}
+			it.Published = parseDate(e.Published)
+			if it.Published.IsZero() {
+				it.Published = parseDate(e.Updated)
+			}
+			f.Items = append(f.Items, it.withID())
+		}
+		return f, nil
+	case "rss", "rdf":
+		f := Feed{Title: clean(x.Channel.Title)}
+		for _, i := range append(x.Channel.Items, x.Items...) {
+			it := Item{ID: clean(i.GUID), Title: clean(i.Title), Link: clean(i.Link), Author: clean(i.Creator)}
+			if it.Author == "" {
+				it.Author = clean(i.Author)
+			}
+			it.Published = parseDate(i.PubDate)
+			if it.Published.IsZero() {
+				it.Published = parseDate(i.Date)
+			}
+			f.Items = append(f.Items, it.withID())
+		}
+		return f, nil
+	}
+	return Feed{}, fmt.Errorf("not an RSS or Atom feed: <%s>", x.XMLName.Local)
+}
+
+// withID falls back to the link for items without an ID of their own.
+func (it Item) withID() Item {
+	if it.ID == "" {
+		it.ID = it.Link
+	}
+	return it
+}
+
+// atomLink returns the entry's alternate link, the one pointing at the article.
+func atomLink(e atomEntry) string {
+	for _, l := range e.Links {
+		if l.Rel == "" || l.Rel == "alternate" {
+			return clean(l.Href)
+		}
+	}
+	return ""
+}
+
+func clean(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// dateLayouts are the date formats found in feeds: RFC 822 variants in RSS, RFC 3339 in Atom
+// and Dublin Core
+var dateLayouts = []string{
+	time.RFC1123Z,
+	time.RFC1123,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"2 Jan 2006 15:04:05 -0700",
+	time.RFC822Z,
+	time.RFC822,
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+}
+
+// parseDate parses a feed date, returning the zero time if it is missing or unreadable.
+func parseDate(s string) time.Time {
+	s = strings.TrimSpace(s)
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
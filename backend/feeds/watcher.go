@@ -0,0 +1,296 @@
+// Package feeds watches RSS and Atom feeds, such as those of read-later services, and
+// ingests the articles of their new items like /ingest/url does, tagged with the feed they
+// came from.
+package feeds
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"vex-backend/breaker"
+	"vex-backend/config"
+	"vex-backend/httpclient"
+	"vex-backend/importer"
+	"vex-backend/usage"
+	vectormgr "vex-backend/vector/manager"
+)
+
+// Metadata keys of the chunks of feed items, on top of those of web pages.
+const (
+	FeedURLMetadataKey       = "feed_url"
+	FeedTitleMetadataKey     = "feed_title"
+	ItemTitleMetadataKey     = "feed_item_title"
+	ItemPublishedMetadataKey = "feed_item_published"
+)
+
+// checkInterval is how often the watcher looks for feeds due for a poll
+const checkInterval = time.Minute
+
+// maxFeedSize bounds a feed document; feeds are small, but the URL may point elsewhere
+const maxFeedSize = 10 << 20
+
+// FeedState is what the watcher remembers of a feed between polls.
+type FeedState struct {
+	Title    string    `json:"title,omitempty"`
+	LastPoll time.Time `json:"last_poll"`
+	// Seen are the IDs of the items of the last poll that were ingested or given up on
+	Seen      []string `json:"seen,omitempty"`
+	LastError string   `json:"last_error,omitempty"`
+	// Ingested counts the items ingested since the feed was added
+	Ingested int `json:"ingested"`
+}
+
+// PollResult is the outcome of polling one feed.
+type PollResult struct {
+	Feed     string   `json:"feed"`
+	New      int      `json:"new"`
+	Ingested []string `json:"ingested"`
+	Failed   []string `json:"failed"`
+}
+
+// Watcher polls the feeds of FEED_URLS every FEED_INTERVAL. Its state survives restarts in a
+// JSON file, so items are ingested once.
+type Watcher struct {
+	cfg       config.Source
+	client    httpclient.Doer
+	m         vectormgr.Manager
+	statePath string
+
+	// mu guards state; polls serializes polls, which may run long
+	mu    sync.Mutex
+	polls sync.Mutex
+	state map[string]*FeedState
+}
+
+// New returns a Watcher whose state is kept at statePath. A missing state file means no feed
+// was polled yet.
+func New(cfg config.Source, client httpclient.Doer, m vectormgr.Manager, statePath string) (*Watcher, error) {
+	w := &Watcher{cfg: cfg, client: client, m: m, statePath: statePath, state: map[string]*FeedState{}}
+	data, err := os.ReadFile(statePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return w, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &w.state); err != nil {
+		return nil, fmt.Errorf("failed to read the feed state %s: %w", statePath, err)
+	}
+	return w, nil
+}
+
+// Run polls the feeds that are due until ctx is done. FEED_URLS and FEED_INTERVAL are
+// re-read on every check, so feeds can be added by a config reload.
+func (w *Watcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+
+	for {
+		cfg := w.cfg()
+		for _, url := range cfg.FeedURLList() {
+			if !w.due(url, cfg.FeedInterval) {
+				continue
+			}
+			res, err := w.Poll(usage.WithSource(ctx, "feeds"), url)
+			if err != nil {
+				log.Printf("[Feeds] polling %s failed: %v", url, err)
+			} else if res.New > 0 {
+				log.Printf("[Feeds] %s: %d new items, %d ingested, %d failed", url, res.New, len(res.Ingested), len(res.Failed))
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// due reports whether feed was last polled at least interval ago.
+func (w *Watcher) due(feed string, interval time.Duration) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	s, ok := w.state[feed]
+	return !ok || time.Since(s.LastPoll) >= interval
+}
+
+// PollAll polls every feed of FEED_URLS now, whether due or not.
+func (w *Watcher) PollAll(ctx context.Context) ([]PollResult, error) {
+	var results []PollResult
+	for _, url := range w.cfg().FeedURLList() {
+		res, err := w.Poll(ctx, url)
+		if errors.Is(err, breaker.ErrOpen) {
+			return results, err
+		}
+		results = append(results, res)
+	}
+	return results, nil
+}
+
+// Poll fetches a feed and ingests the articles its new items link to. The first poll of a
+// feed only ingests its FEED_BACKFILL newest items and marks the others seen. Items whose
+// article can't be ingested are given up on and not retried, unless the import stopped on an
+// open circuit breaker or ctx, which leaves them for the next poll.
+func (w *Watcher) Poll(ctx context.Context, feedURL string) (PollResult, error) {
+	w.polls.Lock()
+	defer w.polls.Unlock()
+
+	res := PollResult{Feed: feedURL, Ingested: []string{}, Failed: []string{}}
+	cfg := w.cfg()
+	feed, err := w.fetch(ctx, feedURL)
+
+	w.mu.Lock()
+	state, known := w.state[feedURL]
+	if !known {
+		state = &FeedState{}
+		w.state[feedURL] = state
+	}
+	state.LastPoll = time.Now()
+	seen := make(map[string]bool, len(state.Seen))
+	for _, id := range state.Seen {
+		seen[id] = true
+	}
+	if err != nil {
+		state.LastError = err.Error()
+		w.saveLocked()
+		w.mu.Unlock()
+		return res, err
+	}
+	state.Title, state.LastError = feed.Title, ""
+	w.mu.Unlock()
+
+	// newest first, so the backfill keeps the latest items
+	items := append([]Item(nil), feed.Items...)
+	sort.SliceStable(items, func(i, j int) bool { return items[i].Published.After(items[j].Published) })
+
+	var next []string
+	var stopErr error
+	for _, item := range items {
+		if seen[item.ID] || item.Link == "" {
+			next = append(next, item.ID)
+			continue
+		}
+		res.New++
+		if stopErr != nil || (!known && res.New > cfg.FeedBackfill) {
+			if stopErr == nil {
+				next = append(next, item.ID)
+			}
+			continue
+		}
+
+		src := &importer.Web{
+			URL:     item.Link,
+			MaxSize: cfg.MaxFileSize,
+			Client:  w.client,
+			Metadata: map[string]string{
+				FeedURLMetadataKey:   feedURL,
+				FeedTitleMetadataKey: feed.Title,
+				ItemTitleMetadataKey: item.Title,
+			},
+			Tags: cfg.FeedTagList(),
+		}
+		if !item.Published.IsZero() {
+			src.Metadata[ItemPublishedMetadataKey] = item.Published.UTC().Format(time.RFC3339)
+		}
+		if item.Author != "" {
+			src.Metadata[importer.AuthorMetadataKey] = item.Author
+		}
+		imported, err := importer.Import(ctx, w.m, src)
+		if err == nil && len(imported.Failed) > 0 {
+			for _, e := range imported.Failed {
+				err = e
+			}
+		}
+		if errors.Is(err, breaker.ErrOpen) || ctx.Err() != nil {
+			// leave this item and the rest for the next poll
+			stopErr = err
+			res.Failed = append(res.Failed, item.Link)
+			continue
+		}
+		next = append(next, item.ID)
+		if err != nil {
+			log.Printf("[Feeds] giving up on %s from %s: %v", item.Link, feedURL, err)
+			res.Failed = append(res.Failed, item.Link)
+			continue
+		}
+		res.Ingested = append(res.Ingested, item.Link)
+	}
+
+	w.mu.Lock()
+	// items gone from the feed are forgotten, which keeps the state as small as the feed
+	state.Seen = next
+	state.Ingested += len(res.Ingested)
+	if stopErr != nil {
+		state.LastError = stopErr.Error()
+	}
+	w.saveLocked()
+	w.mu.Unlock()
+	return res, stopErr
+}
+
+// fetch downloads and parses a feed.
+func (w *Watcher) fetch(ctx context.Context, url string) (Feed, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return Feed{}, err
+	}
+	req.Header.Set("Accept", "application/rss+xml, application/atom+xml, application/xml;q=0.9, text/xml;q=0.9")
+	resp, err := httpclient.OrDefault(w.client).Do(req)
+	if err != nil {
+		return Feed{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Feed{}, fmt.Errorf("feed answered %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxFeedSize))
+	if err != nil {
+		return Feed{}, err
+	}
+	return Parse(data)
+}
+
+// Status returns the state of every feed of FEED_URLS, keyed by URL, without the seen items.
+// Feeds not polled yet have a zero state.
+func (w *Watcher) Status() map[string]FeedState {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	out := map[string]FeedState{}
+	for _, url := range w.cfg().FeedURLList() {
+		var s FeedState
+		if known, ok := w.state[url]; ok {
+			s = *known
+			s.Seen = nil
+		}
+		out[url] = s
+	}
+	return out
+}
+
+// saveLocked writes the state file atomically, logging failures. Callers must hold w.mu.
+func (w *Watcher) saveLocked() {
+	data, err := json.MarshalIndent(w.state, "", "  ")
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(w.statePath), 0o755)
+	}
+	if err == nil {
+		tmp := w.statePath + ".tmp"
+		if err = os.WriteFile(tmp, data, 0o644); err == nil {
+			err = os.Rename(tmp, w.statePath)
+		}
+	}
+	if err != nil {
+		log.Printf("[Feeds] failed to save the feed state: %v", err)
+	}
+}
@@ -0,0 +1,82 @@
+// Package flags decides whether an experimental pipeline stage runs for a request. Stages
+// are off unless FEATURE_FLAGS turns them on, and a request can turn them on or off for
+// itself with the X-Vex-Flags header, so a stage can be A/B tested on a running server.
+package flags
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"vex-backend/config"
+)
+
+// Flags of the experimental stages
+const (
+	// Rerank has the LLM reorder a wider set of retrieved chunks before answering
+	Rerank = "rerank"
+	// MultiQuery retrieves for several rephrasings of the question and merges the results
+	MultiQuery = "multi_query"
+	// HallucinationCheck has the LLM check the answer against the chunks it was given
+	HallucinationCheck = "hallucination_check"
+)
+
+// Header overrides FEATURE_FLAGS for one request, e.g. "rerank, multi_query=off": a name
+// alone or name=on turns a flag on, name=off turns it off.
+const Header = "X-Vex-Flags"
+
+type ctxKey struct{}
+
+// Parse reads an X-Vex-Flags value into the flags it sets on or off.
+func Parse(value string) (map[string]bool, error) {
+	out := map[string]bool{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		name, state, hasState := strings.Cut(item, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !slices.Contains(config.KnownFeatureFlags, name) {
+			return nil, fmt.Errorf("unknown flag %q, expected one of %s", name, strings.Join(config.KnownFeatureFlags, ", "))
+		}
+		on := true
+		if hasState {
+			switch strings.ToLower(strings.TrimSpace(state)) {
+			case "on", "true", "1":
+			case "off", "false", "0":
+				on = false
+			default:
+				return nil, fmt.Errorf("flag %q must be on or off, got %q", name, state)
+			}
+		}
+		out[name] = on
+	}
+	return out, nil
+}
+
+// WithOverrides returns a context whose flags are overrides where they set one, and
+// FEATURE_FLAGS elsewhere.
+func WithOverrides(ctx context.Context, overrides map[string]bool) context.Context {
+	return context.WithValue(ctx, ctxKey{}, overrides)
+}
+
+// Enabled reports whether the flag name is on for the request of ctx.
+func Enabled(ctx context.Context, cfg *config.EnvConfig, name string) bool {
+	if overrides, ok := ctx.Value(ctxKey{}).(map[string]bool); ok {
+		if on, set := overrides[name]; set {
+			return on
+		}
+	}
+	return cfg.FeatureFlagSet()[name]
+}
+
+// Effective returns the state of every known flag for the request of ctx, to report which
+// stages an answer went through.
+func Effective(ctx context.Context, cfg *config.EnvConfig) map[string]bool {
+	out := make(map[string]bool, len(config.KnownFeatureFlags))
+	for _, name := range config.KnownFeatureFlags {
+		out[name] = Enabled(ctx, cfg, name)
+	}
+	return out
+}
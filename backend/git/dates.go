@@ -0,0 +1,139 @@
+package git
+
+import (
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// Commit is the commit that last changed a file.
+type Commit struct {
+	Hash   string
+	Author string
+	// When is the committer time, in UTC
+	When time.Time
+}
+
+// Metadata keys of the last commit of a file, recorded on every chunk of a file in a git
+// repository; dates are RFC 3339 in UTC, so they sort as strings
+const (
+	CommitDateMetadataKey   = "commit_date"
+	CommitHashMetadataKey   = "commit_hash"
+	CommitAuthorMetadataKey = "commit_author"
+	// SubmoduleMetadataKey holds the path of the submodule a file belongs to
+	SubmoduleMetadataKey = "submodule"
+)
+
+// commitLog caches, per repository root, the commit that last changed each file as of a HEAD
+type commitLog struct {
+	head    string
+	commits map[string]Commit
+}
+
+var (
+	datesMu    sync.Mutex
+	datesCache = map[string]commitLog{}
+)
+
+// FileMetadata returns the metadata of the git repository the file at path is in: its last
+// commit's date, hash and author and the submodule it belongs to, as far as they are known.
+func FileMetadata(path string) map[string]string {
+	metadata := map[string]string{}
+	if c, ok := LastCommit(path); ok {
+		metadata[CommitDateMetadataKey] = c.When.Format(time.RFC3339)
+		metadata[CommitHashMetadataKey] = c.Hash
+		metadata[CommitAuthorMetadataKey] = c.Author
+	}
+	if sub, ok := SubmodulePath(path); ok {
+		metadata[SubmoduleMetadataKey] = sub
+	}
+	return metadata
+}
+
+// LastCommit returns the commit that last changed the file at path in the git repository
+// containing it. It reports false if path is not inside a repository or was never committed.
+// The commits of all files are read in one pass over the history and cached until HEAD
+// moves, so looking up every file of a reindex stays cheap.
+func LastCommit(path string) (Commit, bool) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return Commit{}, false
+	}
+	repo, err := git.PlainOpenWithOptions(filepath.Dir(abs), &git.PlainOpenOptions{DetectDotGit: true})
+	if err != nil {
+		return Commit{}, false
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return Commit{}, false
+	}
+	root := worktree.Filesystem.Root()
+	rel, err := filepath.Rel(root, abs)
+	if err != nil {
+		return Commit{}, false
+	}
+	ref, err := repo.Head()
+	if err != nil {
+		return Commit{}, false
+	}
+
+	datesMu.Lock()
+	defer datesMu.Unlock()
+	cached, ok := datesCache[root]
+	if !ok || cached.head != ref.Hash().String() {
+		commits, err := lastCommits(repo, ref.Hash())
+		if err != nil {
+			return Commit{}, false
+		}
+		cached = commitLog{head: ref.Hash().String(), commits: commits}
+		datesCache[root] = cached
+	}
+	c, ok := cached.commits[filepath.ToSlash(rel)]
+	return c, ok
+}
+
+// lastCommits walks the history from head, newest first, and records for every path the
+// newest commit that touched it.
+func lastCommits(repo *git.Repository, head plumbing.Hash) (map[string]Commit, error) {
+	iter, err := repo.Log(&git.LogOptions{From: head, Order: git.LogOrderCommitterTime})
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	commits := map[string]Commit{}
+	err = iter.ForEach(func(c *object.Commit) error {
+		tree, err := c.Tree()
+		if err != nil {
+			return err
+		}
+		// a root commit diffs against the empty tree; merges against their first parent
+		var parentTree *object.Tree
+		if c.NumParents() > 0 {
+			parent, err := c.Parent(0)
+			if err != nil {
+				return err
+			}
+			if parentTree, err = parent.Tree(); err != nil {
+				return err
+			}
+		}
+		changes, err := object.DiffTree(parentTree, tree)
+		if err != nil {
+			return err
+		}
+		for _, change := range changes {
+			for _, name := range []string{change.To.Name, change.From.Name} {
+				if _, seen := commits[name]; name != "" && !seen {
+					commits[name] = Commit{Hash: c.Hash.String(), Author: c.Author.Name, When: c.Committer.When.UTC()}
+				}
+			}
+		}
+		return nil
+	})
+	return commits, err
+}
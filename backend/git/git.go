@@ -1,21 +1,63 @@
 package git
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"vex-backend/config"
+	"vex-backend/lfs"
 
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
 	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
 	"github.com/go-git/go-git/v5/plumbing/transport/http"
 )
 
-// CloneRepo clones a git repository and returns a list of all files in the repo
-// repoURL should be the full URL to the git repository
-func CloneRepo(repoURL string) ([]string, error) {
-	clonePath := filepath.Join(config.Config.CloneFolder, filepath.Base(repoURL))
+// Repo is a remote git repository and where it is cloned locally.
+type Repo struct {
+	// URL is the full URL to the git repository
+	URL string
+	// CloneFolder is the directory the repository is cloned into (as a subdirectory)
+	CloneFolder string
+	// User and PAT authenticate clones and pulls
+	User string
+	PAT  string
+	// Submodules checks out the repository's submodules along with it, and reports the
+	// files that change in them like its own
+	Submodules bool
+}
+
+// NewRepo returns the notes repository described by cfg (NOTES_REPO, CLONE_FOLDER,
+// GIT_USER, GIT_PAT and GIT_SUBMODULES).
+func NewRepo(cfg *config.EnvConfig) *Repo {
+	return &Repo{
+		URL:         cfg.NotesRepo,
+		CloneFolder: cfg.CloneFolder,
+		User:        cfg.GitUser,
+		PAT:         cfg.GitPAT,
+		Submodules:  cfg.GitSubmodules,
+	}
+}
+
+// Path returns the local directory the repository is cloned into.
+func (r *Repo) Path() string {
+	return filepath.Join(r.CloneFolder, filepath.Base(r.URL))
+}
+
+func (r *Repo) auth() *http.BasicAuth {
+	return &http.BasicAuth{
+		Username: r.User,
+		Password: r.PAT,
+	}
+}
+
+// Clone clones the repository and returns a list of all files in the repo
+func (r *Repo) Clone() ([]string, error) {
+	defer r.lock()()
+	clonePath := r.Path()
 
 	// Remove the directory if it already exists
 	if _, err := os.Stat(clonePath); err == nil {
@@ -25,16 +67,22 @@ func CloneRepo(repoURL string) ([]string, error) {
 	}
 
 	// Clone the repository
-	_, err := git.PlainClone(clonePath, false, &git.CloneOptions{
-		URL: repoURL,
-		Auth: &http.BasicAuth{
-			Username: config.Config.GitUser,
-			Password: config.Config.GitPAT,
-		},
+	repo, err := git.PlainClone(clonePath, false, &git.CloneOptions{
+		URL:  r.URL,
+		Auth: r.auth(),
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to clone repository: %w", err)
 	}
+	if r.Submodules {
+		worktree, err := repo.Worktree()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get worktree: %w", err)
+		}
+		if err := r.updateSubmodules(worktree); err != nil {
+			return nil, err
+		}
+	}
 
 	// Get all files in the cloned repository
 	files, err := getAllFiles(clonePath)
@@ -45,10 +93,10 @@ func CloneRepo(repoURL string) ([]string, error) {
 	return files, nil
 }
 
-// PullRepo pulls updates from a git repository and returns a list of changed files
-// repoURL should be the full URL to the git repository
-func PullRepo(repoURL string) ([]string, error) {
-	clonePath := filepath.Join(config.Config.CloneFolder, filepath.Base(repoURL))
+// Pull pulls updates from the repository and returns a list of changed files
+func (r *Repo) Pull() ([]string, error) {
+	defer r.lock()()
+	clonePath := r.Path()
 
 	// Check if the repository exists
 	if _, err := os.Stat(clonePath); os.IsNotExist(err) {
@@ -74,20 +122,33 @@ func PullRepo(repoURL string) ([]string, error) {
 		return nil, fmt.Errorf("failed to get worktree: %w", err)
 	}
 
+	// put back the LFS pointers whose content was fetched, or the pull refuses the dirty worktree
+	if err := lfs.Restore(clonePath); err != nil {
+		return nil, err
+	}
+
+	// remember where the submodules are, to tell what changed in them after the update
+	var heads map[string]plumbing.Hash
+	if r.Submodules {
+		if heads, err = submoduleHeads(worktree); err != nil {
+			return nil, err
+		}
+	}
+
 	// Pull the latest changes
 	err = worktree.Pull(&git.PullOptions{
-		Auth: &http.BasicAuth{
-			Username: config.Config.GitUser,
-			Password: config.Config.GitPAT,
-		},
+		Auth: r.auth(),
 	})
 	if err != nil && err != git.NoErrAlreadyUpToDate {
 		return nil, fmt.Errorf("failed to pull repository: %w", err)
 	}
 
-	// If no changes, return empty list
+	// If no changes, return empty list (or what changed in submodules initialised just now)
 	if err == git.NoErrAlreadyUpToDate {
-		return []string{}, nil
+		if !r.Submodules {
+			return []string{}, nil
+		}
+		return r.pullSubmodules(worktree, heads, []string{})
 	}
 
 	// Get new HEAD after pulling
@@ -103,9 +164,76 @@ func PullRepo(repoURL string) ([]string, error) {
 		return nil, fmt.Errorf("failed to get changed files: %w", err)
 	}
 
+	if r.Submodules {
+		return r.pullSubmodules(worktree, heads, changedFiles)
+	}
 	return changedFiles, nil
 }
 
+// pullSubmodules checks out the submodule commits the pulled superproject records and adds
+// the files that changed in them, relative to the superproject, to changed.
+func (r *Repo) pullSubmodules(worktree *git.Worktree, heads map[string]plumbing.Hash, changed []string) ([]string, error) {
+	if err := r.updateSubmodules(worktree); err != nil {
+		return nil, err
+	}
+	files, err := submoduleChanges(worktree, heads)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get changed files of submodules: %w", err)
+	}
+	return append(changed, files...), nil
+}
+
+// ChangedFiles returns only changed files on pull, all files on first clone
+func (r *Repo) ChangedFiles() ([]string, error) {
+	// Check if the repository already exists
+	if _, err := os.Stat(r.Path()); os.IsNotExist(err) {
+		// Repository doesn't exist, clone it (returns all files)
+		return r.Clone()
+	}
+
+	// Repository exists, pull the latest changes (returns only changed files)
+	return r.Pull()
+}
+
+// IsAuthError reports whether err is the remote rejecting the credentials (GIT_USER and
+// GIT_PAT), e.g. because the token expired or was revoked.
+func IsAuthError(err error) bool {
+	return errors.Is(err, transport.ErrAuthenticationRequired) || errors.Is(err, transport.ErrAuthorizationFailed)
+}
+
+// Files lists the files of the local clone under dir, relative to the repository root,
+// without pulling. dir is relative to the root as well and may name a single file; ""
+// lists the whole repository.
+func (r *Repo) Files(dir string) ([]string, error) {
+	files, err := getAllFiles(filepath.Join(r.Path(), dir))
+	if err != nil {
+		return nil, err
+	}
+	for i, f := range files {
+		files[i] = filepath.Join(dir, f)
+	}
+	return files, nil
+}
+
+// configRepo returns repoURL with the clone folder and credentials of the global config.
+func configRepo(repoURL string) *Repo {
+	r := NewRepo(config.Current())
+	r.URL = repoURL
+	return r
+}
+
+// CloneRepo clones a git repository and returns a list of all files in the repo
+// repoURL should be the full URL to the git repository
+func CloneRepo(repoURL string) ([]string, error) {
+	return configRepo(repoURL).Clone()
+}
+
+// PullRepo pulls updates from a git repository and returns a list of changed files
+// repoURL should be the full URL to the git repository
+func PullRepo(repoURL string) ([]string, error) {
+	return configRepo(repoURL).Pull()
+}
+
 // GetFiles clones the repository if it doesn't exist, or pulls if it does
 // Returns the list of changed files (or all files if newly cloned)
 // repoURL should be the full URL to the git repository
@@ -115,19 +243,11 @@ func GetFiles(repoURL string) ([]string, error) {
 
 // GetChangedFiles returns only changed files on pull, all files on first clone
 func GetChangedFiles(repoURL string) ([]string, error) {
-	clonePath := filepath.Join(config.Config.CloneFolder, filepath.Base(repoURL))
-
-	// Check if the repository already exists
-	if _, err := os.Stat(clonePath); os.IsNotExist(err) {
-		// Repository doesn't exist, clone it (returns all files)
-		return CloneRepo(repoURL)
-	}
-
-	// Repository exists, pull the latest changes (returns only changed files)
-	return PullRepo(repoURL)
+	return configRepo(repoURL).ChangedFiles()
 }
 
-// getAllFiles returns a list of all files in the repository (excluding .git directory)
+// getAllFiles returns a list of all files in the repository (excluding .git directory, and
+// the .git files of submodules)
 func getAllFiles(repoPath string) ([]string, error) {
 	var files []string
 
@@ -137,8 +257,11 @@ func getAllFiles(repoPath string) ([]string, error) {
 		}
 
 		// Skip the .git directory
-		if info.IsDir() && info.Name() == ".git" {
-			return filepath.SkipDir
+		if info.Name() == ".git" {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
 		}
 
 		// Add files only (not directories)
@@ -161,7 +284,8 @@ func getAllFiles(repoPath string) ([]string, error) {
 	return files, nil
 }
 
-// getChangedFiles returns the list of files that changed between two commits
+// getChangedFiles returns the list of files that changed between two commits, including
+// deleted ones
 func getChangedFiles(repo *git.Repository, oldCommit, newCommit plumbing.Hash) ([]string, error) {
 	// Get the commit objects
 	oldCommitObj, err := repo.CommitObject(oldCommit)
@@ -193,12 +317,19 @@ func getChangedFiles(repo *git.Repository, oldCommit, newCommit plumbing.Hash) (
 
 	var changedFiles []string
 	for _, change := range changes {
+		// submodules are diffed in their own repositories
+		if change.From.TreeEntry.Mode == filemode.Submodule || change.To.TreeEntry.Mode == filemode.Submodule {
+			continue
+		}
 		// Include files that are added, modified, or renamed
 		if change.To.Name != "" {
 			changedFiles = append(changedFiles, change.To.Name)
 		}
-		// For deleted files, we might want to handle them differently
-		// but for now we'll skip them since they don't need re-embedding
+		// Deleted files, and the old name of renamed ones, are included too so that the
+		// indexer drops their vectors
+		if change.From.Name != "" && change.From.Name != change.To.Name {
+			changedFiles = append(changedFiles, change.From.Name)
+		}
 	}
 
 	return changedFiles, nil
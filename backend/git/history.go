@@ -0,0 +1,196 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/utils/merkletrie"
+)
+
+// File change actions reported by ChangesSince
+const (
+	ActionAdded    = "added"
+	ActionModified = "modified"
+	ActionDeleted  = "deleted"
+)
+
+// FileChange is a file that changed between two commits, with its line counts.
+type FileChange struct {
+	Path    string `json:"path"`
+	Action  string `json:"action"`
+	Added   int    `json:"lines_added"`
+	Deleted int    `json:"lines_deleted"`
+}
+
+// locks serializes git operations per clone directory, so a digest commit can't race a
+// webhook pull on the same worktree.
+var locks sync.Map
+
+func (r *Repo) lock() func() {
+	mu, _ := locks.LoadOrStore(r.Path(), &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	return mu.(*sync.Mutex).Unlock
+}
+
+// Head returns the hash of the checked-out commit.
+func (r *Repo) Head() (string, error) {
+	defer r.lock()()
+
+	repo, err := git.PlainOpen(r.Path())
+	if err != nil {
+		return "", fmt.Errorf("failed to open repository: %w", err)
+	}
+	ref, err := repo.Head()
+	if err != nil {
+		return "", fmt.Errorf("failed to get HEAD: %w", err)
+	}
+	return ref.Hash().String(), nil
+}
+
+// ChangesSince returns the files changed between a commit and HEAD, along with HEAD's hash.
+// from is a commit hash; if it is empty or no longer known, the last commit at or before
+// since is used instead, and if the history doesn't reach back that far every file counts
+// as added.
+func (r *Repo) ChangesSince(from string, since time.Time) ([]FileChange, string, error) {
+	defer r.lock()()
+
+	repo, err := git.PlainOpen(r.Path())
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to open repository: %w", err)
+	}
+	ref, err := repo.Head()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get HEAD: %w", err)
+	}
+	head, err := repo.CommitObject(ref.Hash())
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get HEAD commit: %w", err)
+	}
+
+	var base *object.Commit
+	if from != "" {
+		base, _ = repo.CommitObject(plumbing.NewHash(from))
+	}
+	if base == nil {
+		base, err = commitAtOrBefore(repo, ref.Hash(), since)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	headTree, err := head.Tree()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get HEAD tree: %w", err)
+	}
+	var baseTree *object.Tree
+	if base != nil {
+		if baseTree, err = base.Tree(); err != nil {
+			return nil, "", fmt.Errorf("failed to get base tree: %w", err)
+		}
+	}
+
+	// a nil base tree diffs against the empty tree, reporting every file as added
+	changes, err := object.DiffTree(baseTree, headTree)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to diff trees: %w", err)
+	}
+
+	out := make([]FileChange, 0, len(changes))
+	for _, change := range changes {
+		fc := FileChange{Path: change.To.Name}
+		action, err := change.Action()
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to classify change: %w", err)
+		}
+		switch action {
+		case merkletrie.Insert:
+			fc.Action = ActionAdded
+		case merkletrie.Delete:
+			fc.Action = ActionDeleted
+			fc.Path = change.From.Name
+		default:
+			fc.Action = ActionModified
+		}
+		if patch, err := change.Patch(); err == nil {
+			for _, stat := range patch.Stats() {
+				fc.Added += stat.Addition
+				fc.Deleted += stat.Deletion
+			}
+		}
+		out = append(out, fc)
+	}
+	return out, ref.Hash().String(), nil
+}
+
+// commitAtOrBefore walks back from start and returns the newest commit made at or before t,
+// or nil if every commit is newer.
+func commitAtOrBefore(repo *git.Repository, start plumbing.Hash, t time.Time) (*object.Commit, error) {
+	iter, err := repo.Log(&git.LogOptions{From: start, Order: git.LogOrderCommitterTime})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history: %w", err)
+	}
+	defer iter.Close()
+
+	var found *object.Commit
+	errStop := errors.New("stop")
+	err = iter.ForEach(func(c *object.Commit) error {
+		if !c.Committer.When.After(t) {
+			found = c
+			return errStop
+		}
+		return nil
+	})
+	if err != nil && err != errStop {
+		return nil, fmt.Errorf("failed to read history: %w", err)
+	}
+	return found, nil
+}
+
+// CommitFile writes content to rel (relative to the repository root), commits it with
+// message and pushes the commit.
+func (r *Repo) CommitFile(rel string, content []byte, message string) error {
+	defer r.lock()()
+
+	repo, err := git.PlainOpen(r.Path())
+	if err != nil {
+		return fmt.Errorf("failed to open repository: %w", err)
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return fmt.Errorf("failed to get worktree: %w", err)
+	}
+
+	full := filepath.Join(r.Path(), rel)
+	if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	if err := os.WriteFile(full, content, 0o644); err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	if _, err := worktree.Add(filepath.ToSlash(rel)); err != nil {
+		return fmt.Errorf("failed to stage file: %w", err)
+	}
+
+	_, err = worktree.Commit(message, &git.CommitOptions{
+		Author: &object.Signature{
+			Name:  r.User,
+			Email: r.User + "@users.noreply.local",
+			When:  time.Now(),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to commit: %w", err)
+	}
+
+	if err := repo.Push(&git.PushOptions{Auth: r.auth()}); err != nil && err != git.NoErrAlreadyUpToDate {
+		return fmt.Errorf("failed to push: %w", err)
+	}
+	return nil
+}
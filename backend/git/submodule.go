@@ -0,0 +1,135 @@
+package git
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// submoduleHeads returns the commit every initialised submodule of the worktree has checked
+// out, keyed by its path.
+func submoduleHeads(worktree *git.Worktree) (map[string]plumbing.Hash, error) {
+	subs, err := worktree.Submodules()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list submodules: %w", err)
+	}
+	heads := make(map[string]plumbing.Hash, len(subs))
+	for _, s := range subs {
+		status, err := s.Status()
+		if err != nil || status.Current.IsZero() {
+			continue
+		}
+		heads[s.Config().Path] = status.Current
+	}
+	return heads, nil
+}
+
+// updateSubmodules initialises the submodules of the worktree that aren't yet and checks out
+// the commits the superproject records for all of them. Nested submodules are not followed.
+func (r *Repo) updateSubmodules(worktree *git.Worktree) error {
+	subs, err := worktree.Submodules()
+	if err != nil {
+		return fmt.Errorf("failed to list submodules: %w", err)
+	}
+	err = subs.Update(&git.SubmoduleUpdateOptions{
+		Init:              true,
+		RecurseSubmodules: git.NoRecurseSubmodules,
+		Auth:              r.auth(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update submodules: %w", err)
+	}
+	return nil
+}
+
+// submoduleChanges returns the files, relative to the superproject, that changed in the
+// submodules of the worktree since they had the heads checked out: all files of a
+// submodule that wasn't initialised before.
+func submoduleChanges(worktree *git.Worktree, heads map[string]plumbing.Hash) ([]string, error) {
+	subs, err := worktree.Submodules()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list submodules: %w", err)
+	}
+
+	var changed []string
+	for _, s := range subs {
+		status, err := s.Status()
+		if err != nil || status.Current.IsZero() || status.Current == heads[s.Config().Path] {
+			continue
+		}
+		repo, err := s.Repository()
+		if err != nil {
+			return nil, fmt.Errorf("failed to open submodule %s: %w", s.Config().Path, err)
+		}
+
+		var files []string
+		if old, ok := heads[s.Config().Path]; ok {
+			files, err = getChangedFiles(repo, old, status.Current)
+		} else {
+			files, err = commitFiles(repo, status.Current)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("submodule %s: %w", s.Config().Path, err)
+		}
+		log.Printf("[Git] submodule %s moved to %s, %d files changed", s.Config().Path, status.Current.String()[:7], len(files))
+		for _, f := range files {
+			changed = append(changed, filepath.FromSlash(path.Join(s.Config().Path, f)))
+		}
+	}
+	return changed, nil
+}
+
+// commitFiles lists every file of the tree of commit.
+func commitFiles(repo *git.Repository, commit plumbing.Hash) ([]string, error) {
+	c, err := repo.CommitObject(commit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get commit object: %w", err)
+	}
+	tree, err := c.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tree: %w", err)
+	}
+	var files []string
+	err = tree.Files().ForEach(func(f *object.File) error {
+		files = append(files, f.Name)
+		return nil
+	})
+	return files, err
+}
+
+// SubmodulePath returns the path, relative to the superproject's root, of the submodule the
+// file at path belongs to. It reports false for files outside of any submodule.
+func SubmodulePath(file string) (string, bool) {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return "", false
+	}
+
+	// a submodule's checkout has a .git file pointing into the superproject's .git folder,
+	// the superproject's own is a folder
+	sub := ""
+	for dir := filepath.Dir(abs); ; dir = filepath.Dir(dir) {
+		if info, err := os.Lstat(filepath.Join(dir, ".git")); err == nil {
+			if !info.IsDir() {
+				if sub == "" {
+					sub = dir
+				}
+			} else {
+				if sub == "" {
+					return "", false
+				}
+				rel, err := filepath.Rel(dir, sub)
+				return filepath.ToSlash(rel), err == nil
+			}
+		}
+		if parent := filepath.Dir(dir); parent == dir {
+			return "", false
+		}
+	}
+}
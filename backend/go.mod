@@ -4,7 +4,10 @@ go 1.21.0
 
 require (
 	github.com/go-git/go-git/v5 v5.10.0
+	github.com/mattn/go-sqlite3 v1.14.22
 	github.com/philippgille/chromem-go v0.7.0
+	golang.org/x/crypto v0.24.0
+	golang.org/x/net v0.26.0
 )
 
 require (
@@ -25,11 +28,10 @@ require (
 	github.com/skeema/knownhosts v1.2.0 // indirect
 	github.com/stretchr/testify v1.9.0 // indirect
 	github.com/xanzy/ssh-agent v0.3.3 // indirect
-	golang.org/x/crypto v0.24.0 // indirect
 	golang.org/x/mod v0.18.0 // indirect
-	golang.org/x/net v0.26.0 // indirect
 	golang.org/x/sync v0.7.0 // indirect
 	golang.org/x/sys v0.21.0 // indirect
+	golang.org/x/text v0.16.0 // indirect
 	golang.org/x/tools v0.22.0 // indirect
 	gopkg.in/warnings.v0 v0.1.2 // indirect
 )
@@ -0,0 +1,158 @@
+// Package guard decides whether a note is worth embedding before any of it is sent to
+// Voyage. Oversized files and configured binary types are rejected before they are read; the
+// content of the rest runs through a chain of filters, such as a minimum amount of meaningful
+// text and a cap on the number of chunks a file may produce.
+package guard
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+	"vex-backend/chunking"
+	"vex-backend/config"
+)
+
+// Filter inspects the content of the note at path (relative to the repository root) and
+// returns why it should not be embedded, or "" to let it through.
+type Filter func(path, content string) string
+
+// Chain runs its filters in order; the first to reject a note decides.
+type Chain []Filter
+
+// Check returns the reason the first rejecting filter gives, or "" if all let the note through.
+func (c Chain) Check(path, content string) string {
+	for _, f := range c {
+		if reason := f(path, content); reason != "" {
+			return reason
+		}
+	}
+	return ""
+}
+
+// Guard is the set of checks applied to a note before it is embedded.
+type Guard struct {
+	// MaxFileSize in bytes rejects larger files without reading them; 0 disables the check
+	MaxFileSize int64
+	// BinaryExtensions (lower-case, with the dot) reject files by their name alone
+	BinaryExtensions []string
+	// Filters check the content of the files that passed the size check
+	Filters Chain
+}
+
+// New returns the guard configured by MAX_FILE_SIZE, BINARY_EXTENSIONS, MIN_CONTENT_LENGTH
+// and MAX_CHUNKS_PER_FILE, counting chunks with the configured chunking.
+func New(cfg *config.EnvConfig) Guard {
+	opts, err := chunking.OptionsFrom(cfg)
+	if err != nil {
+		opts = chunking.DefaultOptions()
+	}
+	chunker, err := chunking.New(opts)
+	if err != nil {
+		chunker, _ = chunking.New(chunking.DefaultOptions())
+	}
+
+	return Guard{
+		MaxFileSize:      cfg.MaxFileSize,
+		BinaryExtensions: cfg.BinaryExtensionList(),
+		Filters: Chain{
+			NotBinary(),
+			MinContent(cfg.MinContentLength),
+			MaxChunks(cfg.MaxChunksPerFile, chunker),
+		},
+	}
+}
+
+// CheckSize returns why a file of size bytes should not be embedded, or "".
+func (g Guard) CheckSize(size int64) string {
+	if g.MaxFileSize > 0 && size > g.MaxFileSize {
+		return fmt.Sprintf("file is %d bytes, more than the maximum of %d", size, g.MaxFileSize)
+	}
+	return ""
+}
+
+// CheckPath returns why the file at path should not be embedded judging by its name, or "".
+func (g Guard) CheckPath(path string) string {
+	if ext := strings.ToLower(filepath.Ext(path)); ext != "" && slices.Contains(g.BinaryExtensions, ext) {
+		return fmt.Sprintf("%s files are listed in BINARY_EXTENSIONS", ext)
+	}
+	return ""
+}
+
+// Check runs the content filters.
+func (g Guard) Check(path, content string) string {
+	return g.Filters.Check(path, content)
+}
+
+var (
+	reFrontmatter = regexp.MustCompile(`(?s)\A---.*?---\s*`)
+	reComments    = regexp.MustCompile(`(?s)<!--.*?-->`)
+	reMDLinks     = regexp.MustCompile(`\[[^\]]+\]\([^)]+\)`)
+	reWikiLinks   = regexp.MustCompile(`\[\[[^\]]+\]\]`)
+)
+
+// MeaningfulLength counts the letters and digits of content once frontmatter, HTML comments,
+// markdown links and wiki links are removed.
+func MeaningfulLength(content string) int {
+	content = reFrontmatter.ReplaceAllString(content, "")
+	content = reComments.ReplaceAllString(content, "")
+	content = reMDLinks.ReplaceAllString(content, "")
+	content = reWikiLinks.ReplaceAllString(content, "")
+
+	n := 0
+	for _, r := range content {
+		if unicode.IsLetter(r) || unicode.IsNumber(r) {
+			n++
+		}
+	}
+	return n
+}
+
+// sniffLength is how much of a file is searched for NUL bytes, as git does to tell binary
+// files from text
+const sniffLength = 8000
+
+// NotBinary rejects content with a NUL byte near its start, such as a binary file saved with
+// a text extension.
+func NotBinary() Filter {
+	return func(path, content string) string {
+		if strings.IndexByte(content[:min(len(content), sniffLength)], 0) >= 0 {
+			return "file is binary"
+		}
+		return ""
+	}
+}
+
+// MinContent rejects notes with fewer than min letters and digits outside of frontmatter,
+// comments and links, such as index notes made only of wiki links. A min of 1 or less only
+// rejects notes without any such text.
+func MinContent(min int) Filter {
+	if min < 1 {
+		min = 1
+	}
+	return func(path, content string) string {
+		n := MeaningfulLength(content)
+		switch {
+		case n == 0:
+			return "file has no content besides links"
+		case n < min:
+			return fmt.Sprintf("file has %d characters of content, less than the minimum of %d", n, min)
+		}
+		return ""
+	}
+}
+
+// MaxChunks rejects notes that chunker splits into more than max chunks; 0 disables the check.
+func MaxChunks(max int, chunker chunking.Chunker) Filter {
+	return func(path, content string) string {
+		if max <= 0 {
+			return ""
+		}
+		if n := len(chunker.Chunk(content)); n > max {
+			return fmt.Sprintf("file splits into %d chunks, more than the maximum of %d", n, max)
+		}
+		return ""
+	}
+}
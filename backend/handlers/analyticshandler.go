@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"vex-backend/analytics"
+	"vex-backend/apierror"
+	"vex-backend/config"
+)
+
+const (
+	// defaultAnalyticsLimit is how many queries the analytics list when not specified
+	defaultAnalyticsLimit = 20
+	// maxAnalyticsLimit caps the queries one request lists
+	maxAnalyticsLimit = 1000
+)
+
+// TopQueriesHandler returns an http.HandlerFunc listing the queries of the history most often
+// asked, each with how often, when last, how often it found nothing, and the mean similarity
+// of its best chunk and latency. ?since=<RFC 3339> only counts queries asked since then and
+// ?limit caps the queries listed.
+func TopQueriesHandler(cfg config.Source, h *analytics.History) http.HandlerFunc {
+	return analyticsQueriesHandler(cfg, "TopQueries", h.TopQueries)
+}
+
+// ZeroResultQueriesHandler returns an http.HandlerFunc listing the queries of the history
+// that found nothing, no chunk at least QUERY_HISTORY_MIN_SIMILARITY similar, most often
+// asked first: what the notes are missing. It takes ?since and ?limit like /analytics/top-queries.
+func ZeroResultQueriesHandler(cfg config.Source, h *analytics.History) http.HandlerFunc {
+	return analyticsQueriesHandler(cfg, "ZeroResultQueries", h.ZeroResults)
+}
+
+func analyticsQueriesHandler(cfg config.Source, tag string, list func(since time.Time, limit int) []analytics.QueryStats) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			apierror.Write(w, r, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		since, ok := analyticsSince(w, r)
+		if !ok {
+			return
+		}
+		limit := defaultAnalyticsLimit
+		if raw := r.URL.Query().Get("limit"); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n < 1 || n > maxAnalyticsLimit {
+				apierror.Write(w, r, http.StatusBadRequest, "query parameter 'limit' must be an integer between 1 and "+strconv.Itoa(maxAnalyticsLimit))
+				return
+			}
+			limit = n
+		}
+
+		respBytes, err := json.Marshal(map[string]any{
+			"enabled": cfg().QueryHistory,
+			"queries": list(since, limit),
+		})
+		if err != nil {
+			log.Printf("[%s] failed to marshal response: %v", tag, err)
+			apierror.Write(w, r, http.StatusInternalServerError, "internal error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(respBytes)
+	}
+}
+
+// QuerySimilarityHandler returns an http.HandlerFunc reporting, per day and overall, the
+// queries of the history, how many found nothing, the mean similarity of their best chunk
+// and their mean latency, so a drop in retrieval quality shows. It takes ?since like
+// /analytics/top-queries.
+func QuerySimilarityHandler(cfg config.Source, h *analytics.History) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			apierror.Write(w, r, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		since, ok := analyticsSince(w, r)
+		if !ok {
+			return
+		}
+
+		days, total := h.Days(since)
+		respBytes, err := json.Marshal(map[string]any{
+			"enabled":        cfg().QueryHistory,
+			"min_similarity": cfg().QueryHistoryMinSimilarity,
+			"total":          total,
+			"days":           days,
+		})
+		if err != nil {
+			log.Printf("[QuerySimilarity] failed to marshal response: %v", err)
+			apierror.Write(w, r, http.StatusInternalServerError, "internal error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(respBytes)
+	}
+}
+
+// analyticsSince reads ?since, answering 400 and returning false if it is invalid.
+func analyticsSince(w http.ResponseWriter, r *http.Request) (time.Time, bool) {
+	raw := r.URL.Query().Get("since")
+	if raw == "" {
+		return time.Time{}, true
+	}
+	t, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		apierror.Write(w, r, http.StatusBadRequest, "query parameter 'since' must be an RFC 3339 time")
+		return time.Time{}, false
+	}
+	return t, true
+}
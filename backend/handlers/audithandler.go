@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"vex-backend/apierror"
+	"vex-backend/audit"
+)
+
+const (
+	// defaultAuditLimit is how many entries are returned when ?limit is not given
+	defaultAuditLimit = 100
+	// maxAuditLimit caps ?limit
+	maxAuditLimit = 1000
+)
+
+// AuditHandler returns an http.HandlerFunc that lists audit log entries, newest first.
+// Optional query parameters narrow them down: since and until (RFC 3339), action, actor,
+// path (a file the entry touched) and limit.
+func AuditHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			apierror.Write(w, r, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		q := r.URL.Query()
+		filter := audit.Filter{
+			Action: q.Get("action"),
+			Actor:  q.Get("actor"),
+			Path:   q.Get("path"),
+			Limit:  defaultAuditLimit,
+		}
+		if raw := q.Get("limit"); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n < 1 || n > maxAuditLimit {
+				apierror.Write(w, r, http.StatusBadRequest, "query parameter 'limit' must be an integer between 1 and "+strconv.Itoa(maxAuditLimit))
+				return
+			}
+			filter.Limit = n
+		}
+		for name, dst := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+			raw := q.Get(name)
+			if raw == "" {
+				continue
+			}
+			t, err := time.Parse(time.RFC3339, raw)
+			if err != nil {
+				apierror.Write(w, r, http.StatusBadRequest, "query parameter '"+name+"' must be an RFC 3339 time")
+				return
+			}
+			*dst = t
+		}
+
+		entries, err := audit.Query(filter)
+		if err != nil {
+			writeError(w, r, "audit log error", err)
+			return
+		}
+
+		respBytes, err := json.Marshal(map[string]any{"entries": entries})
+		if err != nil {
+			log.Printf("[Audit] failed to marshal response: %v", err)
+			apierror.Write(w, r, http.StatusInternalServerError, "internal error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(respBytes)
+	}
+}
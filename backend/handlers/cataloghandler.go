@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"vex-backend/apierror"
+	"vex-backend/catalog"
+)
+
+const (
+	// defaultCatalogLimit is how many notes are listed when ?limit is not given
+	defaultCatalogLimit = 100
+	// maxCatalogLimit caps ?limit
+	maxCatalogLimit = 1000
+)
+
+// CatalogHandler returns an http.HandlerFunc that lists the indexed notes from the catalog.
+// Optional query parameters filter and page the list: prefix (path prefix), like (SQL LIKE
+// pattern on the path), tag (repeatable, all must match), since and until (RFC 3339 bounds on
+// the commit or modification date), sort (path, title or modified), limit and offset.
+func CatalogHandler(cat *catalog.Catalog) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			apierror.Write(w, r, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		q := r.URL.Query()
+		query := catalog.Query{
+			Prefix: q.Get("prefix"),
+			Like:   q.Get("like"),
+			Tags:   q["tag"],
+			Sort:   q.Get("sort"),
+			Limit:  defaultCatalogLimit,
+		}
+		switch query.Sort {
+		case "", "path", "title", "modified":
+		default:
+			apierror.Write(w, r, http.StatusBadRequest, "query parameter 'sort' must be path, title or modified")
+			return
+		}
+		if raw := q.Get("limit"); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n < 1 || n > maxCatalogLimit {
+				apierror.Write(w, r, http.StatusBadRequest, "query parameter 'limit' must be an integer between 1 and "+strconv.Itoa(maxCatalogLimit))
+				return
+			}
+			query.Limit = n
+		}
+		for name, dst := range map[string]*time.Time{"since": &query.ModifiedSince, "until": &query.ModifiedBefore} {
+			raw := q.Get(name)
+			if raw == "" {
+				continue
+			}
+			t, err := time.Parse(time.RFC3339, raw)
+			if err != nil {
+				apierror.Write(w, r, http.StatusBadRequest, "query parameter '"+name+"' must be an RFC 3339 time")
+				return
+			}
+			*dst = t
+		}
+		if raw := q.Get("offset"); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n < 0 {
+				apierror.Write(w, r, http.StatusBadRequest, "query parameter 'offset' must be a non-negative integer")
+				return
+			}
+			query.Offset = n
+		}
+
+		docs, total, err := cat.List(query)
+		if err != nil {
+			writeError(w, r, "catalog error", err)
+			return
+		}
+		respBytes, err := json.Marshal(map[string]any{
+			"notes": docs,
+			"total": total,
+		})
+		if err != nil {
+			log.Printf("[Catalog] failed to marshal response: %v", err)
+			apierror.Write(w, r, http.StatusInternalServerError, "internal error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(respBytes)
+	}
+}
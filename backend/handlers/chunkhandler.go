@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"vex-backend/apierror"
+	"vex-backend/vector/embed"
+	vectormgr "vex-backend/vector/manager"
+)
+
+// defaultExcerptContext is how many lines around a chunk are returned when not specified
+const defaultExcerptContext = 3
+
+type excerptLine struct {
+	Number    int    `json:"number"`
+	Text      string `json:"text"`
+	Highlight bool   `json:"highlight"`
+}
+
+// ChunkExcerptHandler returns an http.HandlerFunc that, given ?id=<chunk id>, returns the
+// lines of the source note the chunk was taken from plus ?context=<n> surrounding lines,
+// with the chunk's own lines marked for highlighting. Only files under cloneFolder are served.
+func ChunkExcerptHandler(cloneFolder string, m vectormgr.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.URL.Query().Get("id")
+		if id == "" {
+			apierror.Write(w, r, http.StatusBadRequest, "query parameter 'id' is required")
+			return
+		}
+
+		contextLines := defaultExcerptContext
+		if raw := r.URL.Query().Get("context"); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n < 0 {
+				apierror.Write(w, r, http.StatusBadRequest, "query parameter 'context' must be a non-negative integer")
+				return
+			}
+			contextLines = n
+		}
+
+		v, err := m.GetByID(r.Context(), id)
+		if err != nil {
+			writeError(w, r, "chunk lookup error", err)
+			return
+		}
+
+		path := v.Metadata["filepath"]
+		startLine, errStart := strconv.Atoi(v.Metadata[embed.StartLineMetadataKey])
+		endLine, errEnd := strconv.Atoi(v.Metadata[embed.EndLineMetadataKey])
+		if path == "" || errStart != nil || errEnd != nil || endLine < startLine {
+			apierror.Write(w, r, http.StatusUnprocessableEntity, "chunk has no source location; re-index the note to record it")
+			return
+		}
+
+		// only serve files from the notes clone
+		cloneRoot, err := filepath.Abs(cloneFolder)
+		if err != nil || !strings.HasPrefix(filepath.Clean(path), cloneRoot+string(filepath.Separator)) {
+			apierror.Write(w, r, http.StatusForbidden, "chunk source is outside the notes repository")
+			return
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Printf("[ChunkExcerpt] failed to read %s: %v", path, err)
+			apierror.Write(w, r, http.StatusNotFound, "source file unavailable")
+			return
+		}
+		lines := strings.Split(string(data), "\n")
+		// the note may have shrunk since the chunk was indexed
+		if startLine < 1 || startLine > len(lines) {
+			apierror.Write(w, r, http.StatusGone, "chunk's lines no longer exist in the source file; re-index the note")
+			return
+		}
+
+		from := max(startLine-contextLines, 1)
+		to := min(endLine+contextLines, len(lines))
+
+		excerpt := make([]excerptLine, 0, to-from+1)
+		for n := from; n <= to; n++ {
+			excerpt = append(excerpt, excerptLine{
+				Number:    n,
+				Text:      lines[n-1],
+				Highlight: n >= startLine && n <= endLine,
+			})
+		}
+
+		resp := map[string]any{
+			"id":         id,
+			"filepath":   path,
+			"filename":   v.Metadata["filename"],
+			"start_line": startLine,
+			"end_line":   endLine,
+			"lines":      excerpt,
+		}
+
+		respBytes, err := json.Marshal(resp)
+		if err != nil {
+			log.Printf("[ChunkExcerpt] failed to marshal response: %v", err)
+			apierror.Write(w, r, http.StatusInternalServerError, "internal error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(respBytes)
+	}
+}
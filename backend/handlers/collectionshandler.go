@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+
+	"vex-backend/apierror"
+	"vex-backend/config"
+	vectormgr "vex-backend/vector/manager"
+)
+
+// CollectionsHandler returns an http.HandlerFunc for /admin/collections. GET lists the named
+// collections with their number of chunks and which one is the default (VECTOR_COLLECTION);
+// POST with a JSON body { "name": "work-docs" } creates an empty collection, which /query
+// then answers from when asked for it by name.
+func CollectionsHandler(m vectormgr.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var (
+			resp any
+			code = http.StatusOK
+		)
+		switch r.Method {
+		case http.MethodGet:
+			collections, err := m.Collections(r.Context())
+			if err != nil {
+				log.Printf("[Collections] failed to list collections: %v", err)
+				writeError(w, r, "collections error", err)
+				return
+			}
+			resp = map[string]any{
+				"default":     m.CollectionName(),
+				"collections": collections,
+			}
+		case http.MethodPost:
+			var req struct {
+				Name string `json:"name"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				if err == io.EOF {
+					apierror.Write(w, r, http.StatusBadRequest, "missing JSON body")
+					return
+				}
+				apierror.Write(w, r, http.StatusBadRequest, "invalid JSON: "+err.Error())
+				return
+			}
+			if err := config.CheckCollectionName(req.Name); err != nil {
+				apierror.Write(w, r, http.StatusBadRequest, "field 'name': "+err.Error())
+				return
+			}
+			if err := m.CreateCollection(r.Context(), req.Name); err != nil {
+				log.Printf("[Collections] failed to create collection %s: %v", req.Name, err)
+				writeError(w, r, "failed to create collection", err)
+				return
+			}
+			log.Printf("[Collections] created collection %s", req.Name)
+			resp, code = map[string]any{"name": req.Name, "chunks": 0}, http.StatusCreated
+		default:
+			apierror.Write(w, r, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		respBytes, err := json.Marshal(resp)
+		if err != nil {
+			log.Printf("[Collections] failed to marshal response: %v", err)
+			apierror.Write(w, r, http.StatusInternalServerError, "internal error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		w.Write(respBytes)
+	}
+}
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"vex-backend/apierror"
+	"vex-backend/config"
+	vectormgr "vex-backend/vector/manager"
+)
+
+// DedupHandler returns an http.HandlerFunc that removes duplicate chunks from the existing
+// collection. An optional JSON body { "similarity_threshold": 0.97 } overrides the configured
+// near-duplicate threshold; 0 removes exact duplicates only.
+func DedupHandler(cfg config.Source, m vectormgr.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		log.Printf("[Dedup] invoked from %s", r.RemoteAddr)
+
+		if r.Method != http.MethodPost {
+			apierror.Write(w, r, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		var req struct {
+			SimilarityThreshold *float32 `json:"similarity_threshold"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			apierror.Write(w, r, http.StatusBadRequest, "invalid JSON: "+err.Error())
+			return
+		}
+
+		threshold := vectormgr.DedupSimilarityThresholdFrom(cfg())
+		if req.SimilarityThreshold != nil {
+			threshold = *req.SimilarityThreshold
+		}
+		if threshold < 0 || threshold > 1 {
+			apierror.Write(w, r, http.StatusBadRequest, "field 'similarity_threshold' must be between 0 and 1")
+			return
+		}
+
+		removed, err := m.DeduplicateVectors(r.Context(), threshold)
+		if err != nil {
+			log.Printf("[Dedup] error: %v", err)
+			writeError(w, r, "dedup error", err)
+			return
+		}
+
+		duration := time.Since(start)
+		resp := map[string]any{
+			"status":               "success",
+			"removed_count":        removed,
+			"similarity_threshold": threshold,
+			"duration_ms":          duration.Milliseconds(),
+		}
+
+		respBytes, err := json.Marshal(resp)
+		if err != nil {
+			log.Printf("[Dedup] failed to marshal response: %v", err)
+			apierror.Write(w, r, http.StatusInternalServerError, "internal error")
+			return
+		}
+
+		log.Printf("[Dedup] completed: removed=%d duration=%s", removed, duration)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(respBytes)
+	}
+}
@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"vex-backend/apierror"
+	"vex-backend/digest"
+)
+
+// DigestHandler returns an http.HandlerFunc that generates a digest of the notes changed
+// since the previous digest. It accepts an optional JSON body { "commit": true } to also
+// commit the digest to the notes repository. The digest is returned as JSON, or as plain
+// markdown with ?format=markdown.
+func DigestHandler(g *digest.Generator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		log.Printf("[Digest] invoked from %s", r.RemoteAddr)
+
+		if r.Method != http.MethodPost {
+			apierror.Write(w, r, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		var req struct {
+			Commit bool `json:"commit"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			apierror.Write(w, r, http.StatusBadRequest, "invalid JSON: "+err.Error())
+			return
+		}
+
+		d, err := g.Generate(r.Context(), req.Commit)
+		if err != nil {
+			log.Printf("[Digest] error: %v", err)
+			writeError(w, r, "digest error", err)
+			return
+		}
+		log.Printf("[Digest] completed: notes=%d committed=%q duration=%s", len(d.Changes), d.CommittedPath, time.Since(start))
+
+		if r.URL.Query().Get("format") == "markdown" {
+			w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(d.Markdown))
+			return
+		}
+
+		respBytes, err := json.Marshal(d)
+		if err != nil {
+			log.Printf("[Digest] failed to marshal response: %v", err)
+			apierror.Write(w, r, http.StatusInternalServerError, "internal error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(respBytes)
+	}
+}
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"vex-backend/apierror"
+	"vex-backend/catalog"
+	"vex-backend/git"
+	"vex-backend/manifest"
+)
+
+// DocumentStatusHandler returns an http.HandlerFunc serving GET /documents/<path>/status,
+// which explains whether a note is searchable: its last indexing outcome from the manifest
+// (indexed, skipped with the reason, failed with the error, or deleted, with timestamps) and
+// whether the catalog holds chunks for it. The path is relative to the notes repository
+// (absolute paths inside it are accepted too). Returns 404 if neither knows the file.
+func DocumentStatusHandler(repo *git.Repo, man *manifest.Manifest, cat *catalog.Catalog) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			apierror.Write(w, r, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		raw, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/documents/"), "/status")
+		if !ok || raw == "" {
+			apierror.Write(w, r, http.StatusNotFound, "not found")
+			return
+		}
+		path, ok := resolveRepoPath(repo, raw)
+		if !ok {
+			apierror.Write(w, r, http.StatusBadRequest, "path must be inside the notes repository")
+			return
+		}
+		root, err := filepath.Abs(repo.Path())
+		if err != nil {
+			writeError(w, r, "document status error", err)
+			return
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			writeError(w, r, "document status error", err)
+			return
+		}
+
+		entry, recorded := man.Get(rel)
+		doc, searchable, err := cat.Get(path)
+		if err != nil {
+			writeError(w, r, "document status error", err)
+			return
+		}
+		if !recorded && !searchable {
+			apierror.Write(w, r, http.StatusNotFound, "no indexing status recorded for "+rel)
+			return
+		}
+
+		resp := map[string]any{
+			"path":       rel,
+			"searchable": searchable,
+			"chunks":     len(doc.ChunkIDs),
+		}
+		if recorded {
+			resp["status"] = entry
+		}
+		if searchable {
+			resp["title"] = doc.Title
+		}
+
+		respBytes, err := json.Marshal(resp)
+		if err != nil {
+			log.Printf("[DocumentStatus] failed to marshal response: %v", err)
+			apierror.Write(w, r, http.StatusInternalServerError, "internal error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(respBytes)
+	}
+}
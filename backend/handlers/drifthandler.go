@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"vex-backend/apierror"
+	"vex-backend/config"
+	"vex-backend/usage"
+	vectormgr "vex-backend/vector/manager"
+)
+
+const (
+	// defaultDriftSample is how many chunks are re-embedded when ?sample is not given
+	defaultDriftSample = 20
+	// maxDriftSample caps ?sample, since every sampled chunk costs an embedding request
+	maxDriftSample = 200
+)
+
+// DriftHandler returns an http.HandlerFunc that re-embeds ?sample=<n> stored chunks with the
+// configured model and reports how far the stored vectors have drifted from it, flagging an
+// index that needs re-embedding.
+func DriftHandler(cfg config.Source, m vectormgr.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		log.Printf("[Drift] invoked from %s", r.RemoteAddr)
+
+		if r.Method != http.MethodGet {
+			apierror.Write(w, r, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		sample := defaultDriftSample
+		if raw := r.URL.Query().Get("sample"); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n < 1 || n > maxDriftSample {
+				apierror.Write(w, r, http.StatusBadRequest, "query parameter 'sample' must be an integer between 1 and "+strconv.Itoa(maxDriftSample))
+				return
+			}
+			sample = n
+		}
+
+		ctx := usage.WithSource(r.Context(), "drift")
+		report, err := vectormgr.Drift(ctx, m, cfg().VoyageModel, sample)
+		if err != nil {
+			log.Printf("[Drift] error: %v", err)
+			writeError(w, r, "drift report error", err)
+			return
+		}
+
+		respBytes, err := json.Marshal(report)
+		if err != nil {
+			log.Printf("[Drift] failed to marshal response: %v", err)
+			apierror.Write(w, r, http.StatusInternalServerError, "internal error")
+			return
+		}
+
+		log.Printf("[Drift] completed: sampled=%d mean=%.4f stale=%t duration=%s", report.Sampled, report.MeanSimilarity, report.Stale, time.Since(start))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(respBytes)
+	}
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"vex-backend/apierror"
+	"vex-backend/breaker"
+	"vex-backend/importer"
+	"vex-backend/vector"
+)
+
+// statusForError maps the sentinel errors of the lower layers to the HTTP status that
+// describes them best. Unknown errors are internal server errors.
+func statusForError(err error) int {
+	switch {
+	case errors.Is(err, vector.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, vector.ErrEmptyCollection), errors.Is(err, vector.ErrCollectionExists):
+		return http.StatusConflict
+	case errors.Is(err, vector.ErrRateLimited):
+		return http.StatusTooManyRequests
+	case errors.Is(err, breaker.ErrOpen):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, vector.ErrCapacityExceeded):
+		return http.StatusInsufficientStorage
+	case errors.Is(err, importer.ErrFetch):
+		return http.StatusBadGateway
+	case errors.Is(err, importer.ErrNoContent):
+		return http.StatusUnprocessableEntity
+	default:
+		// includes vector.ErrDimensionMismatch, which needs re-indexing on the server side
+		return http.StatusInternalServerError
+	}
+}
+
+// publicMessage describes err without internal details (upstream bodies, storage paths),
+// naming only the sentinel error it matches, if any.
+func publicMessage(err error) string {
+	for _, sentinel := range []error{
+		vector.ErrNotFound,
+		vector.ErrEmptyCollection,
+		vector.ErrRateLimited,
+		vector.ErrDimensionMismatch,
+		vector.ErrCollectionExists,
+		vector.ErrCapacityExceeded,
+		breaker.ErrOpen,
+		importer.ErrFetch,
+		importer.ErrNoContent,
+	} {
+		if errors.Is(err, sentinel) {
+			return sentinel.Error()
+		}
+	}
+	return "internal error"
+}
+
+// writeError logs err with the request ID and writes an error envelope prefixed with msg,
+// using the status from statusForError. The raw error is never sent to the client.
+func writeError(w http.ResponseWriter, r *http.Request, msg string, err error) {
+	log.Printf("[%s] %s: %v", apierror.RequestID(r.Context()), msg, err)
+	apierror.Write(w, r, statusForError(err), msg+": "+publicMessage(err))
+}
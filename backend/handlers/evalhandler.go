@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"vex-backend/apierror"
+	"vex-backend/config"
+	"vex-backend/eval"
+	"vex-backend/git"
+	"vex-backend/httpclient"
+	"vex-backend/usage"
+	vectormgr "vex-backend/vector/manager"
+)
+
+// maxEvalBody caps an evaluation set posted in the request body
+const maxEvalBody = 1 << 20
+
+// EvalHandler returns an http.HandlerFunc that runs the evaluation set posted as the body
+// (YAML or JSON), or the EVAL_FILE of the repository without one, and reports the hit rate
+// and MRR of the expected files within the top ?k=<n> retrieved. ?rag=true also answers
+// every question. Each run is appended to the evaluation history and compared with the one
+// before it.
+func EvalHandler(cfg config.Source, client httpclient.Doer, repo *git.Repo, m vectormgr.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		log.Printf("[Eval] invoked from %s", r.RemoteAddr)
+
+		if r.Method != http.MethodPost {
+			apierror.Write(w, r, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		var opts eval.Options
+		if raw := r.URL.Query().Get("k"); raw != "" {
+			k, err := strconv.Atoi(raw)
+			if err != nil || k < 1 {
+				apierror.Write(w, r, http.StatusBadRequest, "query parameter 'k' must be a positive integer")
+				return
+			}
+			opts.K = k
+		}
+		if raw := r.URL.Query().Get("rag"); raw != "" {
+			rag, err := strconv.ParseBool(raw)
+			if err != nil {
+				apierror.Write(w, r, http.StatusBadRequest, "query parameter 'rag' must be true or false")
+				return
+			}
+			opts.RAG = rag
+		}
+
+		c := cfg()
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxEvalBody+1))
+		if err != nil {
+			apierror.Write(w, r, http.StatusBadRequest, "failed to read request body")
+			return
+		}
+		if len(body) > maxEvalBody {
+			apierror.Write(w, r, http.StatusRequestEntityTooLarge, "evaluation set too large")
+			return
+		}
+		var cases []eval.Case
+		if len(body) > 0 {
+			cases, err = eval.Parse(body)
+		} else {
+			cases, err = eval.Load(eval.FilePath(c, repo.Path()))
+		}
+		if err != nil {
+			apierror.Write(w, r, http.StatusBadRequest, "invalid evaluation set: "+err.Error())
+			return
+		}
+
+		ctx := usage.WithSource(r.Context(), "eval")
+		runner := eval.Runner{
+			Cfg:         c,
+			Client:      client,
+			M:           m,
+			Root:        repo.Path(),
+			HistoryPath: filepath.Join(c.VectorStorageFolder, eval.HistoryFile),
+		}
+		report, err := runner.Run(ctx, cases, opts)
+		if err != nil {
+			log.Printf("[Eval] error: %v", err)
+			writeError(w, r, "evaluation error", err)
+			return
+		}
+
+		respBytes, err := json.Marshal(struct {
+			eval.Report
+			Usage usage.Totals `json:"usage"`
+		}{report, usage.FromContext(ctx)})
+		if err != nil {
+			log.Printf("[Eval] failed to marshal response: %v", err)
+			apierror.Write(w, r, http.StatusInternalServerError, "internal error")
+			return
+		}
+
+		log.Printf("[Eval] completed: cases=%d k=%d hit_rate=%.3f mrr=%.3f duration=%s", report.Cases, report.K, report.HitRate, report.MRR, time.Since(start))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(respBytes)
+	}
+}
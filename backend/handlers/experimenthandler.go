@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"vex-backend/apierror"
+	"vex-backend/experiment"
+)
+
+// ExperimentsHandler returns an http.HandlerFunc reporting every experiment logged, the
+// running one first: per arm, the queries answered and failed, their mean, p50 and p95
+// latency, the LLM tokens spent per query and the feedback given on the answers.
+func ExperimentsHandler(exp *experiment.Tracker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			apierror.Write(w, r, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		respBytes, err := json.Marshal(map[string]any{"experiments": exp.Reports()})
+		if err != nil {
+			log.Printf("[Experiments] failed to marshal response: %v", err)
+			apierror.Write(w, r, http.StatusInternalServerError, "internal error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(respBytes)
+	}
+}
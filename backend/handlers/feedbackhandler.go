@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"vex-backend/apierror"
+	"vex-backend/experiment"
+	"vex-backend/feedback"
+)
+
+const (
+	// defaultFeedbackLimit is how many entries /admin/feedback lists when not specified
+	defaultFeedbackLimit = 50
+	// maxFeedbackLimit caps the entries one listing returns
+	maxFeedbackLimit = 1000
+)
+
+// FeedbackHandler returns an http.HandlerFunc recording whether an answer of /query helped.
+// It accepts a JSON body { "query_id": "<query_id of the answer>", "rating": "up" | "down",
+// "comment": "optional" } and stores the feedback with the trace of the answer; giving
+// feedback again on the same answer replaces the earlier. Only the newest queries can be
+// rated. When the answer was given in an experiment, the rating counts for its arm.
+func FeedbackHandler(fb *feedback.Store, exp *experiment.Tracker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			apierror.Write(w, r, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		var req struct {
+			QueryID string `json:"query_id"`
+			Rating  string `json:"rating"`
+			Comment string `json:"comment"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			if err == io.EOF {
+				apierror.Write(w, r, http.StatusBadRequest, "missing JSON body")
+				return
+			}
+			apierror.Write(w, r, http.StatusBadRequest, "invalid JSON: "+err.Error())
+			return
+		}
+		if req.QueryID == "" {
+			apierror.Write(w, r, http.StatusBadRequest, "field 'query_id' is required")
+			return
+		}
+		req.Rating = strings.ToLower(strings.TrimSpace(req.Rating))
+		if req.Rating != feedback.Up && req.Rating != feedback.Down {
+			apierror.Write(w, r, http.StatusBadRequest, "field 'rating' must be \"up\" or \"down\"")
+			return
+		}
+
+		entry, err := fb.Record(req.QueryID, req.Rating, req.Comment)
+		if errors.Is(err, feedback.ErrUnknownQuery) {
+			apierror.Write(w, r, http.StatusNotFound, "no recent query has this query_id")
+			return
+		}
+		if err != nil {
+			writeError(w, r, "feedback error", err)
+			return
+		}
+		if entry.Experiment != nil {
+			if _, err := exp.Feedback(entry.ID, entry.Rating == feedback.Up); err != nil && !errors.Is(err, experiment.ErrUnknownQuery) {
+				log.Printf("[Feedback] failed to count the feedback on query %s for its experiment: %v", entry.ID, err)
+			}
+		}
+
+		respBytes, err := json.Marshal(map[string]any{"recorded": true, "query_id": entry.ID, "rating": entry.Rating, "experiment": entry.Experiment})
+		if err != nil {
+			log.Printf("[Feedback] failed to marshal response: %v", err)
+			apierror.Write(w, r, http.StatusInternalServerError, "internal error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(respBytes)
+	}
+}
+
+// FeedbackListHandler returns an http.HandlerFunc listing the feedback given, newest first,
+// at GET /admin/feedback: each entry with its query, answer, rating, comment, the settings
+// the answer was given with and the notes it was given from. ?rating=up|down and
+// ?since=<RFC 3339> filter the entries and ?limit caps them. GET /admin/feedback/<query_id>
+// returns the feedback on one answer together with its debug trace.
+func FeedbackListHandler(fb *feedback.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			apierror.Write(w, r, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		var resp any
+		if id := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/admin/feedback"), "/"); id != "" {
+			entry, ok, err := fb.Get(id)
+			if err != nil {
+				writeError(w, r, "feedback error", err)
+				return
+			}
+			if !ok {
+				apierror.Write(w, r, http.StatusNotFound, "no feedback on query "+strconv.Quote(id))
+				return
+			}
+			resp = entry
+		} else {
+			filter, ok := feedbackFilter(w, r)
+			if !ok {
+				return
+			}
+			limit := defaultFeedbackLimit
+			if raw := r.URL.Query().Get("limit"); raw != "" {
+				n, err := strconv.Atoi(raw)
+				if err != nil || n < 1 || n > maxFeedbackLimit {
+					apierror.Write(w, r, http.StatusBadRequest, "query parameter 'limit' must be an integer between 1 and "+strconv.Itoa(maxFeedbackLimit))
+					return
+				}
+				limit = n
+			}
+			entries, total := fb.List(filter, limit)
+			resp = map[string]any{"total": total, "feedback": entries}
+		}
+
+		respBytes, err := json.Marshal(resp)
+		if err != nil {
+			log.Printf("[FeedbackList] failed to marshal response: %v", err)
+			apierror.Write(w, r, http.StatusInternalServerError, "internal error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(respBytes)
+	}
+}
+
+// FeedbackSummaryHandler returns an http.HandlerFunc tallying the feedback given, overall and
+// grouped by ?by: settings (the default: model, RAG_TOP_K, chunking, prompt overrides and
+// feature flags), route, provider, experiment, day or note. ?rating and ?since filter the
+// feedback like /admin/feedback.
+func FeedbackSummaryHandler(fb *feedback.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			apierror.Write(w, r, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		by, err := feedback.ParseDimension(r.URL.Query().Get("by"))
+		if err != nil {
+			apierror.Write(w, r, http.StatusBadRequest, "query parameter 'by' "+err.Error())
+			return
+		}
+		filter, ok := feedbackFilter(w, r)
+		if !ok {
+			return
+		}
+
+		total, groups := fb.Summary(filter, by)
+		respBytes, err := json.Marshal(map[string]any{"by": by, "total": total, "groups": groups})
+		if err != nil {
+			log.Printf("[FeedbackSummary] failed to marshal response: %v", err)
+			apierror.Write(w, r, http.StatusInternalServerError, "internal error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(respBytes)
+	}
+}
+
+// feedbackFilter reads ?rating and ?since, answering 400 and returning false if either is
+// invalid.
+func feedbackFilter(w http.ResponseWriter, r *http.Request) (feedback.Filter, bool) {
+	var f feedback.Filter
+	q := r.URL.Query()
+	if f.Rating = strings.ToLower(q.Get("rating")); f.Rating != "" && f.Rating != feedback.Up && f.Rating != feedback.Down {
+		apierror.Write(w, r, http.StatusBadRequest, "query parameter 'rating' must be up or down")
+		return f, false
+	}
+	if raw := q.Get("since"); raw != "" {
+		t, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			apierror.Write(w, r, http.StatusBadRequest, "query parameter 'since' must be an RFC 3339 time")
+			return f, false
+		}
+		f.Since = t
+	}
+	return f, true
+}
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"vex-backend/apierror"
+	"vex-backend/feeds"
+	"vex-backend/usage"
+)
+
+// FeedsHandler returns an http.HandlerFunc for the feed watcher. GET lists the feeds of
+// FEED_URLS with when they were last polled, their last error and how many items were
+// ingested from them. POST polls every feed now instead of waiting for FEED_INTERVAL.
+func FeedsHandler(fw *feeds.Watcher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		log.Printf("[Feeds] invoked at %v from %s", start, r.RemoteAddr)
+
+		var resp any
+		switch r.Method {
+		case http.MethodGet:
+			resp = map[string]any{"feeds": fw.Status()}
+		case http.MethodPost:
+			ctx := usage.WithSource(r.Context(), "feeds")
+			results, err := fw.PollAll(ctx)
+			if err != nil {
+				log.Printf("[Feeds] error: %v", err)
+				writeError(w, r, "feed poll error", err)
+				return
+			}
+			log.Printf("[Feeds] polled %d feeds in %s", len(results), time.Since(start))
+			resp = map[string]any{"polled": results, "usage": usage.FromContext(ctx)}
+		default:
+			apierror.Write(w, r, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		respBytes, err := json.Marshal(resp)
+		if err != nil {
+			log.Printf("[Feeds] failed to marshal response: %v", err)
+			apierror.Write(w, r, http.StatusInternalServerError, "internal error")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(respBytes)
+	}
+}
@@ -1,17 +1,21 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"log"
 	"net/http"
-	"os"
-	"path/filepath"
-	"regexp"
-	"strings"
+	"sync"
 	"time"
 
+	"vex-backend/apierror"
 	"vex-backend/config"
 	"vex-backend/git"
+	"vex-backend/httpclient"
+	"vex-backend/indexer"
+	"vex-backend/manifest"
+	"vex-backend/notify"
+	"vex-backend/usage"
 	vectormgr "vex-backend/vector/manager"
 )
 
@@ -19,51 +23,178 @@ type WebhookPayload struct {
 	RepoURL string `json:"repo_url"`
 }
 
-// isOnlyWikiLinks returns true when the content (after removing frontmatter,
-// comments and common link syntaxes) contains no letters or digits — i.e. only
-// wiki links and punctuation/whitespace remain.
-func isOnlyWikiLinks(content string) bool {
-	// Remove YAML frontmatter: --- ... --- at start of file
-	reFront := regexp.MustCompile(`(?s)\A---.*?---\s*`)
-	content = reFront.ReplaceAllString(content, "")
-
-	// Remove HTML comments
-	reComments := regexp.MustCompile(`(?s)<!--.*?-->`)
-	content = reComments.ReplaceAllString(content, "")
-
-	// Remove markdown inline links like [text](url)
-	reMDLinks := regexp.MustCompile(`\[[^\]]+\]\([^)]+\)`)
-	content = reMDLinks.ReplaceAllString(content, "")
-
-	// Remove wiki links [[...]]
-	reWiki := regexp.MustCompile(`\[\[[^\]]+\]\]`)
-	content = reWiki.ReplaceAllString(content, "")
-
-	// If anything letter/number remains, it's not only links.
-	reAlphaNum := regexp.MustCompile(`\p{L}|\p{N}`)
-	return !reAlphaNum.MatchString(content)
+// writeIndexResponse writes the JSON summary of an indexing run. Runs with failures are
+// reported as "partial"; runs cut short (by an open circuit breaker) answer with the
+// status matching the error that stopped them.
+func writeIndexResponse(w http.ResponseWriter, r *http.Request, logPrefix string, res indexer.Result, runErr error, u usage.Totals, start time.Time) {
+	duration := time.Since(start)
+
+	status := "success"
+	code := http.StatusOK
+	if len(res.Failed) > 0 || len(res.Pending) > 0 {
+		status = "partial"
+	}
+	if runErr != nil {
+		code = statusForError(runErr)
+	}
+
+	failed := make(map[string]string, len(res.Failed))
+	for rel, err := range res.Failed {
+		failed[rel] = publicMessage(err)
+	}
+
+	resp := map[string]any{
+		"status":          status,
+		"processed_count": len(res.Processed),
+		"skipped_count":   len(res.Skipped),
+		"failed_count":    len(res.Failed),
+		"pending_count":   len(res.Pending),
+		"deleted_count":   len(res.Deleted),
+		"processed":       res.Processed,
+		"skipped":         res.Skipped,
+		"failed":          failed,
+		"pending":         res.Pending,
+		"deleted":         res.Deleted,
+		"duration_ms":     duration.Milliseconds(),
+		"usage":           u,
+	}
+	if runErr != nil {
+		resp["error"] = publicMessage(runErr)
+	}
+
+	respBytes, err := json.Marshal(resp)
+	if err != nil {
+		log.Printf("[%s] failed to marshal response: %v", logPrefix, err)
+		apierror.Write(w, r, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	log.Printf("[%s] completed: processed=%d skipped=%d failed=%d pending=%d deleted=%d duration=%s",
+		logPrefix, len(res.Processed), len(res.Skipped), len(res.Failed), len(res.Pending), len(res.Deleted), duration)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(respBytes)
 }
 
-// GitWebhookHandler returns an http.HandlerFunc that pulls the repo, deletes any existing
-// vectors for markdown files and re-embeds them. It uses the provided Manager instance.
-func GitWebhookHandler(m vectormgr.Manager) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
+// webhookRun is a sync run shared by the webhook deliveries coalesced into it. err is set,
+// with the stage that failed, when the run failed before indexing.
+type webhookRun struct {
+	done chan struct{}
+	// deliveries counts the deliveries answered by this run
+	deliveries int
+
+	files  []string
+	res    indexer.Result
+	runErr error
+	stage  string
+	err    error
+	usage  usage.Totals
+}
+
+// webhookCoalescer merges deliveries arriving within the debounce window into a single run:
+// the first delivery opens a batch that starts once the window has passed, and deliveries
+// arriving until then join it. Runs never overlap, so deliveries arriving during a run form
+// the next batch. A pull brings in every commit pushed so far, so one run covers the changes
+// of all deliveries it answers.
+type webhookCoalescer struct {
+	mu      sync.Mutex
+	pending *webhookRun
+	// running serializes the runs
+	running sync.Mutex
+}
+
+// join adds a delivery to the pending batch, opening one that calls syncRun after window
+// if there is none, and returns the run that will answer it.
+func (c *webhookCoalescer) join(window time.Duration, syncRun func(*webhookRun)) *webhookRun {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if run := c.pending; run != nil {
+		run.deliveries++
+		return run
+	}
+
+	run := &webhookRun{done: make(chan struct{}), deliveries: 1}
+	c.pending = run
+	go func() {
+		defer close(run.done)
+		time.Sleep(window)
+		c.running.Lock()
+		defer c.running.Unlock()
+		// deliveries from now on may not be covered by the pull, so they open the next batch
+		c.mu.Lock()
+		c.pending = nil
+		c.mu.Unlock()
+		syncRun(run)
+	}()
+	return run
+}
+
+// GitWebhookHandler returns an http.HandlerFunc that pulls the repo and re-embeds the
+// changed notes into m, leaving out ignored files, and records per-file progress in man so
+// failed files can be retried via /resync. Deliveries arriving within WEBHOOK_DEBOUNCE of
+// each other are coalesced into one sync run, whose result answers each of them.
+func GitWebhookHandler(cfg config.Source, client httpclient.Doer, repo *git.Repo, m vectormgr.Manager, man *manifest.Manifest) http.HandlerFunc {
+	var coalescer webhookCoalescer
+	syncRun := func(run *webhookRun) {
+		// the run outlives the delivery that opened its batch
+		ctx := usage.WithSource(context.Background(), "webhook")
 		start := time.Now()
-		log.Printf("[GitWebhook] invoked at %v from %s", start, r.RemoteAddr)
+		defer func() {
+			run.usage = usage.FromContext(ctx)
+			switch {
+			case run.err != nil:
+				notify.Send(cfg, client, notify.FailureEvent("webhook", run.stage, run.err, time.Since(start)))
+				notify.AlertError(cfg, client, "webhook sync", run.err)
+			case len(run.files) > 0:
+				notify.Send(cfg, client, notify.RunEvent("webhook", run.res, run.runErr, time.Since(start)))
+			}
+		}()
 
 		// Ensure repo is up to date (clone or pull)
-		repo := config.Config.NotesRepo
-		log.Printf("[GitWebhook] ensuring notes repo is up-to-date: %s", repo)
-		files, err := git.GetChangedFiles(repo)
+		log.Printf("[GitWebhook] ensuring notes repo is up-to-date for %d deliveries: %s", run.deliveries, repo.URL)
+		run.files, run.err = repo.ChangedFiles()
+		if run.err != nil {
+			run.stage = "git error"
+			return
+		}
+		log.Printf("[GitWebhook] found %d changed files", len(run.files))
+		if len(run.files) == 0 {
+			return
+		}
+
+		// read after the pull, so an updated .vexignore applies to the same push
+		policy, err := indexer.LoadPolicy(cfg(), client, repo.Path())
 		if err != nil {
-			log.Printf("[GitWebhook] git.GetFiles error: %v", err)
-			http.Error(w, "git error: "+err.Error(), http.StatusInternalServerError)
+			run.stage, run.err = "ignore rules error", err
+			return
+		}
+		run.res, run.runErr = indexer.Run(ctx, m, man, policy, repo.Path(), run.files)
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		log.Printf("[GitWebhook] invoked at %v from %s", start, r.RemoteAddr)
+
+		run := coalescer.join(cfg().WebhookDebounce, syncRun)
+		select {
+		case <-run.done:
+		case <-r.Context().Done():
+			// the run goes on without this delivery's answer
+			log.Printf("[GitWebhook] delivery from %s gone before its sync run finished", r.RemoteAddr)
+			return
+		}
+		if run.deliveries > 1 {
+			log.Printf("[GitWebhook] sync run answered %d coalesced deliveries", run.deliveries)
+		}
+
+		if run.err != nil {
+			log.Printf("[GitWebhook] %s: %v", run.stage, run.err)
+			writeError(w, r, run.stage, run.err)
 			return
 		}
-		log.Printf("[GitWebhook] found %d changed files", len(files))
 
 		// If no files changed, return early
-		if len(files) == 0 {
+		if len(run.files) == 0 {
 			duration := time.Since(start)
 			resp := map[string]any{
 				"status":          "success",
@@ -78,7 +209,7 @@ func GitWebhookHandler(m vectormgr.Manager) http.HandlerFunc {
 			respBytes, err := json.Marshal(resp)
 			if err != nil {
 				log.Printf("[GitWebhook] failed to marshal response: %v", err)
-				http.Error(w, "internal error", http.StatusInternalServerError)
+				apierror.Write(w, r, http.StatusInternalServerError, "internal error")
 				return
 			}
 
@@ -89,86 +220,6 @@ func GitWebhookHandler(m vectormgr.Manager) http.HandlerFunc {
 			return
 		}
 
-		basePath := filepath.Join(config.Config.CloneFolder, filepath.Base(repo))
-
-		processed := make([]string, 0, len(files))
-		skipped := make([]string, 0, len(files))
-
-		// Process only changed markdown files:
-		// delete any existing vectors for the file (by metadata) then re-embed it.
-		for _, rel := range files {
-			// only process markdown files
-			if strings.ToLower(filepath.Ext(rel)) != ".md" {
-				skipped = append(skipped, rel)
-				log.Printf("[GitWebhook] skipping non-markdown file: %s", rel)
-				continue
-			}
-
-			fullpath := filepath.Join(basePath, rel)
-			log.Printf("[GitWebhook] processing markdown file: %s", fullpath)
-
-			// Try to read the file to decide whether to embed
-			data, err := os.ReadFile(fullpath)
-			if err != nil {
-				// If we can't read it, log and skip (don't fail the whole webhook).
-				log.Printf("[GitWebhook] warning: failed to read %s: %v", fullpath, err)
-				skipped = append(skipped, rel)
-				continue
-			}
-			content := string(data)
-
-			// If the file contains only wiki-links (like [[a]] [[b]]), skip embedding.
-			if isOnlyWikiLinks(content) {
-				// Optionally delete existing vectors for this file so stale embeddings are removed.
-				if err := m.DeleteVectorsWithMetaData(r.Context(), "filepath", fullpath); err != nil {
-					log.Printf("[GitWebhook] warning: failed to delete existing vectors for %s: %v", fullpath, err)
-				} else {
-					log.Printf("[GitWebhook] deleted existing vectors for %s (file is link-only)", fullpath)
-				}
-
-				skipped = append(skipped, rel)
-				log.Printf("[GitWebhook] skipping link-only file: %s", rel)
-				continue
-			}
-
-			// delete any existing vectors that have metadata filepath = fullpath
-			if err := m.DeleteVectorsWithMetaData(r.Context(), "filepath", fullpath); err != nil {
-				// don't fail the whole webhook on delete errors; log and continue
-				log.Printf("[GitWebhook] warning: failed to delete existing vectors for %s: %v", fullpath, err)
-			} else {
-				log.Printf("[GitWebhook] deleted existing vectors for %s", fullpath)
-			}
-
-			// store (embed) the file into the vector DB
-			if err := m.StoreFileAsVectorsInDB(r.Context(), fullpath); err != nil {
-				log.Printf("[GitWebhook] failed to store vectors for %s: %v", fullpath, err)
-				http.Error(w, "embed error: "+err.Error(), http.StatusInternalServerError)
-				return
-			}
-			log.Printf("[GitWebhook] embedded %s", fullpath)
-			processed = append(processed, rel)
-		}
-
-		duration := time.Since(start)
-		resp := map[string]any{
-			"status":          "success",
-			"processed_count": len(processed),
-			"skipped_count":   len(skipped),
-			"processed":       processed,
-			"skipped":         skipped,
-			"duration_ms":     duration.Milliseconds(),
-		}
-
-		respBytes, err := json.Marshal(resp)
-		if err != nil {
-			log.Printf("[GitWebhook] failed to marshal response: %v", err)
-			http.Error(w, "internal error", http.StatusInternalServerError)
-			return
-		}
-
-		log.Printf("[GitWebhook] completed: processed=%d skipped=%d duration=%s", len(processed), len(skipped), duration)
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		w.Write(respBytes)
+		writeIndexResponse(w, r, "GitWebhook", run.res, run.runErr, run.usage, start)
 	}
 }
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"vex-backend/apierror"
+	"vex-backend/bootstrap"
+	"vex-backend/breaker"
+	"vex-backend/config"
+	"vex-backend/probe"
+)
+
+// HealthHandler returns an http.HandlerFunc that reports service health along with
+// the state of the circuit breakers guarding external APIs and the progress of the startup
+// indexing. An open breaker marks the service as degraded but still answers 200 so
+// container health checks don't restart it.
+func HealthHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status := "healthy"
+		if breaker.AnyOpen() {
+			status = "degraded"
+		}
+
+		resp := map[string]any{
+			"status":    status,
+			"service":   "vex-backend",
+			"breakers":  breaker.All(),
+			"bootstrap": bootstrap.Current(),
+		}
+
+		respBytes, err := json.Marshal(resp)
+		if err != nil {
+			log.Printf("[Health] failed to marshal response: %v", err)
+			apierror.Write(w, r, http.StatusInternalServerError, "internal error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(respBytes)
+	}
+}
+
+// ReadyHandler returns an http.HandlerFunc for readiness probes: it answers 503 while the
+// startup indexing (BOOTSTRAP_ON_START) is running, so no traffic is routed to an instance
+// whose vector store is still being filled, and 200 otherwise.
+func ReadyHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ready := bootstrap.Ready()
+		respBytes, err := json.Marshal(map[string]any{
+			"ready":     ready,
+			"bootstrap": bootstrap.Current(),
+		})
+		if err != nil {
+			log.Printf("[Ready] failed to marshal response: %v", err)
+			apierror.Write(w, r, http.StatusInternalServerError, "internal error")
+			return
+		}
+
+		code := http.StatusOK
+		if !ready {
+			code = http.StatusServiceUnavailable
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		w.Write(respBytes)
+	}
+}
+
+// ProviderHealthHandler returns an http.HandlerFunc reporting the latency and availability
+// history the prober recorded for each embedding and chat provider. GET answers from the
+// recorded probes without sending any; POST first probes the providers not probed within the
+// last minute, within PROBE_DAILY_LIMIT.
+func ProviderHealthHandler(cfg config.Source, pr *probe.Prober) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var reports []probe.Report
+		switch r.Method {
+		case http.MethodGet:
+			reports = pr.Reports()
+		case http.MethodPost:
+			reports = pr.Refresh(r.Context())
+		default:
+			apierror.Write(w, r, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		respBytes, err := json.Marshal(map[string]any{
+			"interval":  cfg().ProbeInterval.String(),
+			"providers": reports,
+		})
+		if err != nil {
+			log.Printf("[ProviderHealth] failed to marshal response: %v", err)
+			apierror.Write(w, r, http.StatusInternalServerError, "internal error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(respBytes)
+	}
+}
@@ -0,0 +1,281 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"vex-backend/apierror"
+	"vex-backend/config"
+	"vex-backend/httpclient"
+	"vex-backend/importer"
+	"vex-backend/usage"
+	"vex-backend/vector"
+	vectormgr "vex-backend/vector/manager"
+)
+
+// NotionImportHandler returns an http.HandlerFunc that imports Notion pages into the vector
+// store, given a JSON body { "database_ids": [...], "page_ids": [...] }: every page of the
+// databases and the single pages. Pages are read with the NOTION_TOKEN integration, which
+// must have been shared with them. Pages unchanged since their last import are not
+// re-embedded, so the import can be repeated to pick up edits.
+func NotionImportHandler(cfg config.Source, client httpclient.Doer, m vectormgr.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		log.Printf("[NotionImport] invoked at %v from %s", start, r.RemoteAddr)
+
+		var req struct {
+			DatabaseIDs []string `json:"database_ids"`
+			PageIDs     []string `json:"page_ids"`
+		}
+		if !decodeImportRequest(w, r, &req) {
+			return
+		}
+		if len(req.DatabaseIDs) == 0 && len(req.PageIDs) == 0 {
+			apierror.Write(w, r, http.StatusBadRequest, "at least one of 'database_ids' and 'page_ids' is required")
+			return
+		}
+
+		src, err := importer.NewNotion(cfg(), client, req.DatabaseIDs, req.PageIDs)
+		if err != nil {
+			apierror.Write(w, r, http.StatusServiceUnavailable, "Notion import is not configured: "+err.Error())
+			return
+		}
+		runImport(w, r, "NotionImport", m, src, start)
+	}
+}
+
+// ConfluenceImportHandler returns an http.HandlerFunc that imports the pages of Confluence
+// spaces into the vector store, given a JSON body { "space_keys": ["ENG"] }. Pages are read
+// from CONFLUENCE_URL with CONFLUENCE_USER and CONFLUENCE_TOKEN; like Notion pages, those
+// unchanged since their last import are not re-embedded.
+func ConfluenceImportHandler(cfg config.Source, client httpclient.Doer, m vectormgr.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		log.Printf("[ConfluenceImport] invoked at %v from %s", start, r.RemoteAddr)
+
+		var req struct {
+			SpaceKeys []string `json:"space_keys"`
+		}
+		if !decodeImportRequest(w, r, &req) {
+			return
+		}
+		if len(req.SpaceKeys) == 0 {
+			apierror.Write(w, r, http.StatusBadRequest, "field 'space_keys' is required")
+			return
+		}
+
+		src, err := importer.NewConfluence(cfg(), client, req.SpaceKeys)
+		if err != nil {
+			apierror.Write(w, r, http.StatusServiceUnavailable, "Confluence import is not configured: "+err.Error())
+			return
+		}
+		runImport(w, r, "ConfluenceImport", m, src, start)
+	}
+}
+
+// IMAPImportHandler returns an http.HandlerFunc that imports email into the IMAP_COLLECTION
+// collection, created on first use, given a JSON body { "folders": ["INBOX"] }; without
+// folders the IMAP_FOLDERS are imported. Messages already imported are not re-embedded.
+func IMAPImportHandler(cfg config.Source, m vectormgr.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		log.Printf("[IMAPImport] invoked at %v from %s", start, r.RemoteAddr)
+
+		var req struct {
+			Folders []string `json:"folders"`
+		}
+		if !decodeImportRequest(w, r, &req) {
+			return
+		}
+
+		c := cfg()
+		src, err := importer.NewIMAP(c, req.Folders)
+		if err != nil {
+			apierror.Write(w, r, http.StatusServiceUnavailable, "IMAP import is not configured: "+err.Error())
+			return
+		}
+		mail, err := openOrCreateCollection(r.Context(), m, c.IMAPCollection)
+		if err != nil {
+			log.Printf("[IMAPImport] failed to open collection %s: %v", c.IMAPCollection, err)
+			writeError(w, r, "failed to open collection", err)
+			return
+		}
+		runImport(w, r, "IMAPImport", mail, src, start)
+	}
+}
+
+// SlackImportHandler returns an http.HandlerFunc that imports a Slack workspace export into
+// the SLACK_COLLECTION collection, created on first use. The export zip is the request body;
+// ?channels=general,random limits the import to those channels. Exports over
+// SLACK_MAX_EXPORT_SIZE are rejected with 413. Conversations unchanged since their last import
+// are not re-embedded, so a newer export can be imported over an older one.
+func SlackImportHandler(cfg config.Source, m vectormgr.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		log.Printf("[SlackImport] invoked at %v from %s", start, r.RemoteAddr)
+
+		if r.Method != http.MethodPost {
+			apierror.Write(w, r, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		c := cfg()
+
+		// the zip is read from its end, so spool it to disk rather than memory
+		tmp, err := os.CreateTemp("", "slack-export-*.zip")
+		if err != nil {
+			writeError(w, r, "failed to store the export", err)
+			return
+		}
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+		body := io.Reader(r.Body)
+		if c.SlackMaxExportSize > 0 {
+			body = io.LimitReader(r.Body, c.SlackMaxExportSize+1)
+		}
+		size, err := io.Copy(tmp, body)
+		if err != nil {
+			writeError(w, r, "failed to read the export", err)
+			return
+		}
+		if c.SlackMaxExportSize > 0 && size > c.SlackMaxExportSize {
+			apierror.Write(w, r, http.StatusRequestEntityTooLarge, "the export is larger than SLACK_MAX_EXPORT_SIZE")
+			return
+		}
+
+		var channels []string
+		if raw := r.URL.Query().Get("channels"); raw != "" {
+			channels = strings.Split(raw, ",")
+		}
+		src, err := importer.NewSlack(tmp, size, channels)
+		if err != nil {
+			apierror.Write(w, r, http.StatusBadRequest, "the body must be a Slack export zip: "+err.Error())
+			return
+		}
+		slack, err := openOrCreateCollection(r.Context(), m, c.SlackCollection)
+		if err != nil {
+			log.Printf("[SlackImport] failed to open collection %s: %v", c.SlackCollection, err)
+			writeError(w, r, "failed to open collection", err)
+			return
+		}
+		runImport(w, r, "SlackImport", slack, src, start)
+	}
+}
+
+// openOrCreateCollection returns the Manager of the named collection, creating it if it
+// doesn't exist yet.
+func openOrCreateCollection(ctx context.Context, m vectormgr.Manager, name string) (vectormgr.Manager, error) {
+	if name == "" || name == m.CollectionName() {
+		return m, nil
+	}
+	c, err := m.Collection(name)
+	if errors.Is(err, vector.ErrNotFound) {
+		if err = m.CreateCollection(ctx, name); err == nil || errors.Is(err, vector.ErrCollectionExists) {
+			c, err = m.Collection(name)
+		}
+	}
+	return c, err
+}
+
+// IngestURLHandler returns an http.HandlerFunc that fetches a web page, given a JSON body
+// { "url": "https://..." }, and embeds the article it shows next to the notes, with the
+// page's address as source_url. Pages over MAX_FILE_SIZE are rejected; a page that can't be
+// fetched answers 502, one without readable text 422. Ingesting a page again updates it.
+func IngestURLHandler(cfg config.Source, client httpclient.Doer, m vectormgr.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		log.Printf("[IngestURL] invoked at %v from %s", start, r.RemoteAddr)
+
+		var req struct {
+			URL string `json:"url"`
+		}
+		if !decodeImportRequest(w, r, &req) {
+			return
+		}
+		if req.URL == "" {
+			apierror.Write(w, r, http.StatusBadRequest, "field 'url' is required")
+			return
+		}
+		if u, err := url.Parse(req.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			apierror.Write(w, r, http.StatusBadRequest, "field 'url' must be an absolute http(s) URL")
+			return
+		}
+
+		src := &importer.Web{URL: req.URL, MaxSize: cfg().MaxFileSize, Client: client}
+		runImport(w, r, "IngestURL", m, src, start)
+	}
+}
+
+// decodeImportRequest checks the method and decodes the JSON body of an import into req,
+// writing the error response and returning false if either is wrong.
+func decodeImportRequest(w http.ResponseWriter, r *http.Request, req any) bool {
+	if r.Method != http.MethodPost {
+		apierror.Write(w, r, http.StatusMethodNotAllowed, "method not allowed")
+		return false
+	}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		if err == io.EOF {
+			apierror.Write(w, r, http.StatusBadRequest, "missing JSON body")
+			return false
+		}
+		apierror.Write(w, r, http.StatusBadRequest, "invalid JSON: "+err.Error())
+		return false
+	}
+	return true
+}
+
+// runImport imports the documents of src into m and writes the JSON summary. Imports with
+// failures are reported as "partial"; imports cut short answer with the status matching the
+// error that stopped them.
+func runImport(w http.ResponseWriter, r *http.Request, logPrefix string, m vectormgr.Manager, src importer.Source, start time.Time) {
+	ctx := usage.WithSource(r.Context(), "import")
+	res, runErr := importer.Import(ctx, m, src)
+
+	status := "success"
+	code := http.StatusOK
+	if len(res.Failed) > 0 {
+		status = "partial"
+	}
+	if runErr != nil {
+		code = statusForError(runErr)
+	}
+	failed := make(map[string]string, len(res.Failed))
+	for path, err := range res.Failed {
+		failed[path] = publicMessage(err)
+	}
+	resp := map[string]any{
+		"status":          status,
+		"imported_count":  len(res.Imported),
+		"unchanged_count": len(res.Unchanged),
+		"failed_count":    len(res.Failed),
+		"imported":        res.Imported,
+		"unchanged":       res.Unchanged,
+		"failed":          failed,
+		"duration_ms":     time.Since(start).Milliseconds(),
+		"usage":           usage.FromContext(ctx),
+	}
+	if runErr != nil {
+		log.Printf("[%s] %v", logPrefix, runErr)
+		resp["error"] = publicMessage(runErr)
+	}
+
+	respBytes, err := json.Marshal(resp)
+	if err != nil {
+		log.Printf("[%s] failed to marshal response: %v", logPrefix, err)
+		apierror.Write(w, r, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	log.Printf("[%s] completed: imported=%d unchanged=%d failed=%d duration=%s",
+		logPrefix, len(res.Imported), len(res.Unchanged), len(res.Failed), time.Since(start))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(respBytes)
+}
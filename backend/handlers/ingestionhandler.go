@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"vex-backend/apierror"
+	"vex-backend/config"
+	"vex-backend/httpclient"
+	"vex-backend/ingestion"
+	"vex-backend/usage"
+)
+
+// IngestSourcesHandler returns an http.HandlerFunc for the registered ingestion connectors.
+// GET lists the connectors, the items checkpointed per sync scope and the scopes being
+// followed. POST with a JSON body { "source": "confluence", "params": { ... } } syncs a
+// connector now; with "follow": true it is synced in the background instead, and kept in sync
+// until restart. DELETE ?scope=... stops following a scope.
+func IngestSourcesHandler(cfg config.Source, client httpclient.Doer, runner *ingestion.Runner) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		log.Printf("[IngestSources] invoked at %v from %s", start, r.RemoteAddr)
+
+		var (
+			resp any
+			code = http.StatusOK
+		)
+		switch r.Method {
+		case http.MethodGet:
+			resp = map[string]any{
+				"sources":     ingestion.Sources(),
+				"checkpoints": runner.Checkpoints().Scopes(),
+				"following":   runner.Following(),
+			}
+		case http.MethodDelete:
+			scope := r.URL.Query().Get("scope")
+			if !runner.Unfollow(scope) {
+				apierror.Write(w, r, http.StatusNotFound, "no follower of scope "+scope)
+				return
+			}
+			resp = map[string]any{"unfollowed": scope}
+		case http.MethodPost:
+			var req struct {
+				Source string          `json:"source"`
+				Params json.RawMessage `json:"params"`
+				Follow bool            `json:"follow"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				if err == io.EOF {
+					apierror.Write(w, r, http.StatusBadRequest, "missing JSON body")
+					return
+				}
+				apierror.Write(w, r, http.StatusBadRequest, "invalid JSON: "+err.Error())
+				return
+			}
+			src, err := ingestion.New(req.Source, cfg(), client, req.Params)
+			if errors.Is(err, ingestion.ErrUnknownSource) {
+				apierror.Write(w, r, http.StatusNotFound, err.Error())
+				return
+			}
+			if err != nil {
+				apierror.Write(w, r, http.StatusBadRequest, req.Source+": "+err.Error())
+				return
+			}
+			scope := ingestion.Scope(req.Source, req.Params)
+			if req.Follow {
+				runner.Follow(scope, src)
+				log.Printf("[IngestSources] following %s", scope)
+				resp, code = map[string]any{"following": scope}, http.StatusAccepted
+				break
+			}
+
+			ctx := usage.WithSource(r.Context(), "ingestion")
+			res, runErr := runner.Sync(ctx, scope, src)
+			failed := make(map[string]string, len(res.Failed))
+			for id, err := range res.Failed {
+				failed[id] = publicMessage(err)
+			}
+			status := "success"
+			if len(res.Failed) > 0 {
+				status = "partial"
+			}
+			body := map[string]any{
+				"status":          status,
+				"scope":           scope,
+				"imported_count":  len(res.Imported),
+				"unchanged_count": len(res.Unchanged),
+				"deleted_count":   len(res.Deleted),
+				"failed_count":    len(res.Failed),
+				"imported":        res.Imported,
+				"unchanged":       res.Unchanged,
+				"deleted":         res.Deleted,
+				"failed":          failed,
+				"duration_ms":     time.Since(start).Milliseconds(),
+				"usage":           usage.FromContext(ctx),
+			}
+			if runErr != nil {
+				log.Printf("[IngestSources] %s: %v", scope, runErr)
+				body["error"] = publicMessage(runErr)
+				code = statusForError(runErr)
+			}
+			log.Printf("[IngestSources] %s completed: imported=%d unchanged=%d deleted=%d failed=%d duration=%s",
+				scope, len(res.Imported), len(res.Unchanged), len(res.Deleted), len(res.Failed), time.Since(start))
+			resp = body
+		default:
+			apierror.Write(w, r, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		respBytes, err := json.Marshal(resp)
+		if err != nil {
+			log.Printf("[IngestSources] failed to marshal response: %v", err)
+			apierror.Write(w, r, http.StatusInternalServerError, "internal error")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		w.Write(respBytes)
+	}
+}
@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"vex-backend/apierror"
+	vectormgr "vex-backend/vector/manager"
+)
+
+// MetricsHandler returns an http.HandlerFunc that reports the query embedding cache of m in
+// the Prometheus text exposition format, for scraping.
+func MetricsHandler(m vectormgr.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			apierror.Write(w, r, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		s := m.QueryCacheStats()
+		var b strings.Builder
+		for _, metric := range []struct {
+			name, kind, help string
+			value            any
+		}{
+			{"vex_query_cache_hits_total", "counter", "Query embeddings served from the cache.", s.Hits},
+			{"vex_query_cache_misses_total", "counter", "Query embeddings not found in the cache.", s.Misses},
+			{"vex_query_cache_hit_ratio", "gauge", "Share of query embeddings served from the cache since the start.", s.HitRate},
+			{"vex_query_cache_entries", "gauge", "Query embeddings currently cached.", s.Size},
+		} {
+			fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", metric.name, metric.help, metric.name, metric.kind, metric.name, metric.value)
+		}
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(b.String()))
+	}
+}
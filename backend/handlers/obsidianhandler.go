@@ -0,0 +1,255 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"vex-backend/apierror"
+	"vex-backend/chat"
+	"vex-backend/config"
+	"vex-backend/git"
+	"vex-backend/vector"
+	"vex-backend/vector/embed"
+	vectormgr "vex-backend/vector/manager"
+)
+
+// The routes below mimic the Obsidian Local REST API plugin, so Obsidian plugins written
+// against it can search the notes through vex with little more than a changed base URL.
+const (
+	// obsidianSearchChunks is how many chunks a search retrieves before grouping them by note
+	obsidianSearchChunks = 30
+	// obsidianMatchesPerNote caps the matches reported for one note
+	obsidianMatchesPerNote = 3
+	// defaultObsidianContextLength is the context shown around a match when not specified
+	defaultObsidianContextLength = 100
+	// obsidianNoteMediaType is the Accept header asking /vault/ for a note as JSON
+	obsidianNoteMediaType = "application/vnd.olrapi.note+json"
+)
+
+type obsidianMatch struct {
+	Match struct {
+		Start int `json:"start"`
+		End   int `json:"end"`
+	} `json:"match"`
+	Context string `json:"context"`
+}
+
+type obsidianSearchResult struct {
+	Filename string          `json:"filename"`
+	Score    float32         `json:"score"`
+	Matches  []obsidianMatch `json:"matches"`
+}
+
+// ObsidianSearchHandler returns an http.HandlerFunc answering POST /search/simple/?query=<q>
+// like the Obsidian Local REST API: the notes matching the query, each with its path
+// relative to the repository, a score and the passages that matched, as byte offsets into
+// the note and ?contextLength=<n> characters of context around each. The notes are found
+// by semantic retrieval rather than by text search, and are ordered by their best passage.
+func ObsidianSearchHandler(cfg config.Source, repo *git.Repo, m vectormgr.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			apierror.Write(w, r, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		query := strings.TrimSpace(r.URL.Query().Get("query"))
+		if query == "" {
+			apierror.Write(w, r, http.StatusBadRequest, "query parameter 'query' is required")
+			return
+		}
+		contextLength := defaultObsidianContextLength
+		if raw := r.URL.Query().Get("contextLength"); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n < 0 {
+				apierror.Write(w, r, http.StatusBadRequest, "query parameter 'contextLength' must be a non-negative integer")
+				return
+			}
+			contextLength = n
+		}
+
+		chunks, err := chat.Retrieve(r.Context(), cfg(), m, query, obsidianSearchChunks, chat.QueryOptions{})
+		if err != nil {
+			log.Printf("[ObsidianSearch] retrieval error: %v", err)
+			writeError(w, r, "search error", err)
+			return
+		}
+
+		results := []*obsidianSearchResult{}
+		byNote := map[string]*obsidianSearchResult{}
+		contents := map[string]string{}
+		for _, c := range chunks {
+			rel, ok := vaultPath(repo, c.Metadata["filepath"])
+			if !ok {
+				// imported documents (Notion, mail, web pages) have no file in the vault
+				continue
+			}
+			res := byNote[rel]
+			if res == nil {
+				data, err := os.ReadFile(c.Metadata["filepath"])
+				if err != nil {
+					continue
+				}
+				contents[rel] = string(data)
+				res = &obsidianSearchResult{Filename: rel, Score: c.Similarity, Matches: []obsidianMatch{}}
+				byNote[rel] = res
+				results = append(results, res)
+			}
+			if len(res.Matches) < obsidianMatchesPerNote {
+				if match, ok := chunkMatch(contents[rel], c, contextLength); ok {
+					res.Matches = append(res.Matches, match)
+				}
+			}
+		}
+		// chunks arrive by similarity, so each note's first chunk is its best
+		sort.SliceStable(results, func(i, j int) bool { return results[i].Score > results[j].Score })
+
+		respBytes, err := json.Marshal(results)
+		if err != nil {
+			log.Printf("[ObsidianSearch] failed to marshal response: %v", err)
+			apierror.Write(w, r, http.StatusInternalServerError, "internal error")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(respBytes)
+	}
+}
+
+// vaultPath returns path, an indexed file, relative to the repository root; ok is false for
+// documents that aren't files of the repository.
+func vaultPath(repo *git.Repo, path string) (string, bool) {
+	root, err := filepath.Abs(repo.Path())
+	if err != nil || path == "" {
+		return "", false
+	}
+	rel, err := filepath.Rel(root, filepath.Clean(path))
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return "", false
+	}
+	return filepath.ToSlash(rel), true
+}
+
+// chunkMatch locates the lines of chunk c in content, the text of its note, returning their
+// byte offsets and the passage shortened to twice contextLength characters. ok is false if
+// the chunk recorded no lines or the note changed since it was indexed.
+func chunkMatch(content string, c vector.VectorData, contextLength int) (obsidianMatch, bool) {
+	var match obsidianMatch
+	startLine, errStart := strconv.Atoi(c.Metadata[embed.StartLineMetadataKey])
+	endLine, errEnd := strconv.Atoi(c.Metadata[embed.EndLineMetadataKey])
+	if errStart != nil || errEnd != nil || startLine < 1 || endLine < startLine {
+		return match, false
+	}
+
+	line, start, end := 1, -1, -1
+	for i := 0; i <= len(content) && end < 0; i++ {
+		if line == startLine && start < 0 {
+			start = i
+		}
+		if i == len(content) || content[i] == '\n' {
+			if line == endLine {
+				end = i
+			}
+			line++
+		}
+	}
+	if start < 0 || end < 0 {
+		return match, false
+	}
+
+	match.Match.Start, match.Match.End = start, end
+	passage := []rune(strings.TrimSpace(content[start:end]))
+	if limit := 2 * contextLength; len(passage) > limit {
+		cut := string(passage[:limit])
+		if i := strings.LastIndexAny(cut, " \n"); i > 0 {
+			cut = cut[:i]
+		}
+		match.Context = cut + "…"
+	} else {
+		match.Context = string(passage)
+	}
+	return match, true
+}
+
+// ObsidianNoteHandler returns an http.HandlerFunc answering GET /vault/<path> like the
+// Obsidian Local REST API: the note at path, relative to the repository, as markdown, or as
+// a JSON object with its content, frontmatter, tags and file times when the Accept header
+// asks for application/vnd.olrapi.note+json. Only indexed notes the caller may see are
+// served, so files excluded from indexing stay private.
+func ObsidianNoteHandler(repo *git.Repo, m vectormgr.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			apierror.Write(w, r, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		raw := strings.TrimPrefix(r.URL.Path, "/vault/")
+		if raw == "" || strings.HasSuffix(raw, "/") {
+			apierror.Write(w, r, http.StatusBadRequest, "a note path is required; directory listings are not supported")
+			return
+		}
+		path, ok := resolveRepoPath(repo, filepath.FromSlash(raw))
+		if !ok || filepath.IsAbs(raw) {
+			apierror.Write(w, r, http.StatusBadRequest, "path must be inside the notes repository")
+			return
+		}
+
+		chunks, err := m.GetChunksByFile(r.Context(), path)
+		if err != nil {
+			writeError(w, r, "note lookup error", err)
+			return
+		}
+		if len(chunks) == 0 {
+			apierror.Write(w, r, http.StatusNotFound, "no indexed note at "+strconv.Quote(raw))
+			return
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Printf("[ObsidianNote] failed to read %s: %v", path, err)
+			apierror.Write(w, r, http.StatusNotFound, "note file unavailable")
+			return
+		}
+
+		if !strings.Contains(r.Header.Get("Accept"), obsidianNoteMediaType) {
+			w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+			w.WriteHeader(http.StatusOK)
+			w.Write(data)
+			return
+		}
+
+		content := string(data)
+		frontmatter := map[string]any{}
+		for k, v := range embed.ExtractFrontmatter(content) {
+			frontmatter[k] = v
+		}
+		tags := embed.ExtractTags(content)
+		if tags == nil {
+			tags = []string{}
+		}
+		stat := map[string]int64{"size": int64(len(data))}
+		if info, err := os.Stat(path); err == nil {
+			// the clone doesn't keep creation times, so both are the modification time
+			stat["ctime"] = info.ModTime().UnixMilli()
+			stat["mtime"] = info.ModTime().UnixMilli()
+		}
+
+		respBytes, err := json.Marshal(map[string]any{
+			"path":        filepath.ToSlash(raw),
+			"content":     content,
+			"frontmatter": frontmatter,
+			"tags":        tags,
+			"stat":        stat,
+		})
+		if err != nil {
+			log.Printf("[ObsidianNote] failed to marshal response: %v", err)
+			apierror.Write(w, r, http.StatusInternalServerError, "internal error")
+			return
+		}
+		w.Header().Set("Content-Type", obsidianNoteMediaType)
+		w.WriteHeader(http.StatusOK)
+		w.Write(respBytes)
+	}
+}
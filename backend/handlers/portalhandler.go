@@ -4,17 +4,20 @@ import (
 	"html/template"
 	"net/http"
 	"path/filepath"
-)
 
-var portalTmpl = template.Must(template.ParseFiles(filepath.FromSlash("templates/portal.html")))
+	"vex-backend/apierror"
+)
 
-// PortalHandler returns an http.HandlerFunc that renders the portal template.
+// PortalHandler returns an http.HandlerFunc that renders the portal template. The template
+// is parsed here, when the routes are set up, rather than when the package is loaded, so
+// the package's tests don't depend on the working directory.
 func PortalHandler() http.HandlerFunc {
+	portalTmpl := template.Must(template.ParseFiles(filepath.FromSlash("templates/portal.html")))
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
 		// Execute the parsed template (no data to pass, but keep gin.H compatibility if needed)
 		if err := portalTmpl.Execute(w, nil); err != nil {
-			http.Error(w, "failed to render template", http.StatusInternalServerError)
+			apierror.Write(w, r, http.StatusInternalServerError, "failed to render template")
 		}
 	}
 }
@@ -5,61 +5,186 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
+	"vex-backend/analytics"
+	"vex-backend/apierror"
 	"vex-backend/chat"
+	"vex-backend/config"
+	"vex-backend/debugtrace"
+	"vex-backend/experiment"
+	"vex-backend/feedback"
+	"vex-backend/httpclient"
+	"vex-backend/lang"
+	"vex-backend/usage"
 	vectormgr "vex-backend/vector/manager"
 )
 
-// QueryHandler returns an http.HandlerFunc that closes over the provided Manager.
-// It accepts a JSON body { "query": "<search text>" } and uses the ProcessQuery function
-// to provide intelligent answers based on the knowledge base.
-func QueryHandler(m vectormgr.Manager) http.HandlerFunc {
+// maxPersonaLength bounds the persona a query may bring, which is sent with every LLM call
+const maxPersonaLength = 2000
+
+// QueryHandler returns an http.HandlerFunc that answers a question from the notes. It
+// accepts a JSON body with the "query", the fields of retrievalScope that select the notes
+// to answer from, and optional fields that shape the answer (mode, answer_language, format,
+// persona, strategy and parent; see the README). ?debug=true adds the golden trace of the
+// pipeline to the response. The answer is remembered for /feedback, logged for a running
+// experiment and, with QUERY_HISTORY, for /analytics. The configuration is read once per
+// request so a reload never changes it mid-query.
+func QueryHandler(cfg config.Source, client httpclient.Doer, m vectormgr.Manager, exp *experiment.Tracker, fb *feedback.Store, hist *analytics.History) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 
 		log.Printf("[QueryHandler] invoked from %s", r.RemoteAddr)
 
-		// Parse JSON body: { "query": "..." }
+		var debug bool
+		if raw := r.URL.Query().Get("debug"); raw != "" {
+			var err error
+			if debug, err = strconv.ParseBool(raw); err != nil {
+				apierror.Write(w, r, http.StatusBadRequest, "query parameter 'debug' must be true or false")
+				return
+			}
+		}
+
+		// Parse the JSON body
 		var req struct {
 			Query string `json:"query"`
+			Mode  string `json:"mode"`
+			// AnswerLanguage is separate from Language, which filters retrieval: a German
+			// answer may well draw on English notes
+			AnswerLanguage string `json:"answer_language"`
+			Format         string `json:"format"`
+			Persona        string `json:"persona"`
+			Strategy       string `json:"strategy"`
+			Parent         string `json:"parent"`
+			retrievalScope
 		}
 		dec := json.NewDecoder(r.Body)
 		if err := dec.Decode(&req); err != nil {
 			if err == io.EOF {
-				http.Error(w, "missing JSON body", http.StatusBadRequest)
+				apierror.Write(w, r, http.StatusBadRequest, "missing JSON body")
 				return
 			}
 			log.Printf("[QueryHandler] invalid JSON: %v", err)
-			http.Error(w, "invalid JSON: "+err.Error(), http.StatusBadRequest)
+			apierror.Write(w, r, http.StatusBadRequest, "invalid JSON: "+err.Error())
 			return
 		}
 		if req.Query == "" {
-			http.Error(w, "field 'query' is required", http.StatusBadRequest)
+			apierror.Write(w, r, http.StatusBadRequest, "field 'query' is required")
+			return
+		}
+		if req.Mode != "" && req.Mode != "agent" {
+			apierror.Write(w, r, http.StatusBadRequest, "field 'mode' must be empty or \"agent\"")
+			return
+		}
+		conf, assignment := exp.Assign(cfg())
+		opts, err := req.options(conf.CloneFolder)
+		if err != nil {
+			apierror.Write(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		req.AnswerLanguage = strings.ToLower(strings.TrimSpace(req.AnswerLanguage))
+		if req.AnswerLanguage != "" && !slices.Contains(lang.Languages(), req.AnswerLanguage) {
+			apierror.Write(w, r, http.StatusBadRequest, "field 'answer_language' must be one of "+strings.Join(lang.Languages(), ", "))
 			return
 		}
 
+		if opts.Format, err = chat.ParseFormat(req.Format); err != nil {
+			apierror.Write(w, r, http.StatusBadRequest, "field 'format' "+err.Error())
+			return
+		}
+		if opts.Strategy, err = chat.ParseStrategy(req.Strategy); err != nil {
+			apierror.Write(w, r, http.StatusBadRequest, "field 'strategy' "+err.Error())
+			return
+		}
+		if opts.Parent, err = chat.ParseParentMode(req.Parent); err != nil {
+			apierror.Write(w, r, http.StatusBadRequest, "field 'parent' "+err.Error())
+			return
+		}
+
+		if len(req.Persona) > maxPersonaLength {
+			apierror.Write(w, r, http.StatusBadRequest, "field 'persona' must not exceed "+strconv.Itoa(maxPersonaLength)+" bytes")
+			return
+		}
+
+		opts.Agent, opts.AnswerLanguage, opts.Persona = req.Mode == "agent", req.AnswerLanguage, req.Persona
+
+		vm, ok := req.collection(w, r, m, opts)
+		if !ok {
+			return
+		}
+
+		// every query is traced, for the feedback on it and the experiment log; ?debug only
+		// adds the trace to the response
+		ctx, trace := debugtrace.New(ctx)
+
 		log.Printf("[QueryHandler] Processing query %q", req.Query)
-		answer, err := chat.ProcessQuery(ctx, m, req.Query)
+		start := time.Now()
+		result, err := chat.ProcessQuery(ctx, conf, client, vm, req.Query, opts)
+		queryID, report := apierror.RequestID(ctx), trace.Report()
+		exp.RecordQuery(assignment, queryID, req.Query, time.Since(start), err, usage.FromContext(ctx), &report)
+		hist.Record(req.Query, string(result.Route), req.Collection, report, result.NoAnswer, time.Since(start), err)
 		if err != nil {
 			log.Printf("[QueryHandler] ProcessQuery error: %v", err)
-			http.Error(w, "query processing error: "+err.Error(), http.StatusInternalServerError)
+			writeError(w, r, "query processing error", err)
 			return
 		}
-		log.Printf("[QueryHandler] Generated answer for query")
+		log.Printf("[QueryHandler] Generated answer for query via %s route (provider %q, flags %v)", result.Route, result.Provider, result.Flags)
+
+		remembered := feedback.Query{ID: queryID, Time: start.UTC(), Question: req.Query, Answer: result.Answer, Route: string(result.Route), Provider: result.Provider, Settings: feedback.SettingsOf(conf, result.Provider, result.Flags, string(result.Strategy)), Trace: &report}
+		if assignment.Experiment != "" {
+			remembered.Experiment = &assignment
+		}
+		fb.Remember(remembered)
 
 		// Prepare response with the answer
 		response := struct {
-			Query  string `json:"query"`
-			Answer string `json:"answer"`
+			QueryID string `json:"query_id"`
+			Query   string `json:"query"`
+			Answer  string `json:"answer"`
+			// NoAnswer is whether nothing relevant was found, so the answer only says so
+			NoAnswer       bool            `json:"no_answer"`
+			AnswerLanguage string          `json:"answer_language,omitempty"`
+			Route          chat.Route      `json:"route"`
+			Strategy       chat.Strategy   `json:"strategy,omitempty"`
+			Provider       string          `json:"provider,omitempty"`
+			Trace          []chat.ToolStep `json:"trace,omitempty"`
+			Grounding      *chat.Grounding `json:"grounding,omitempty"`
+			Flags          map[string]bool `json:"flags"`
+			// Experiment is the arm of the running experiment that answered
+			Experiment *experiment.Assignment `json:"experiment,omitempty"`
+			Usage      usage.Totals           `json:"usage"`
+			Debug      *debugtrace.Report     `json:"debug,omitempty"`
+			// bullets and citations, for format json
+			*chat.StructuredAnswer
 		}{
-			Query:  req.Query,
-			Answer: answer,
+			QueryID:          queryID,
+			Query:            req.Query,
+			Answer:           result.Answer,
+			NoAnswer:         result.NoAnswer,
+			AnswerLanguage:   result.AnswerLanguage,
+			Route:            result.Route,
+			Strategy:         result.Strategy,
+			Provider:         result.Provider,
+			Trace:            result.Trace,
+			Grounding:        result.Grounding,
+			Flags:            result.Flags,
+			Usage:            usage.FromContext(ctx),
+			StructuredAnswer: result.Structured,
+		}
+		if assignment.Experiment != "" {
+			response.Experiment = &assignment
+		}
+		if debug {
+			response.Debug = &report
 		}
 
 		respBytes, err := json.Marshal(response)
 		if err != nil {
 			log.Printf("[QueryHandler] failed to marshal response: %v", err)
-			http.Error(w, "internal error", http.StatusInternalServerError)
+			apierror.Write(w, r, http.StatusInternalServerError, "internal error")
 			return
 		}
 
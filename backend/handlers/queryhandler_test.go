@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"vex-backend/analytics"
+	"vex-backend/config"
+	"vex-backend/experiment"
+	"vex-backend/feedback"
+	"vex-backend/httpclient"
+	"vex-backend/testsupport"
+	vectormgr "vex-backend/vector/manager"
+)
+
+// testConfig returns the configuration of a test server: the defaults, the required keys
+// and extra, with everything on disk under a temporary folder.
+func testConfig(t *testing.T, extra config.Env) *config.EnvConfig {
+	t.Helper()
+	dir := t.TempDir()
+	env := config.Env{
+		"GIT_USER":              "user",
+		"GIT_PAT":               "pat",
+		"CLONE_FOLDER":          dir,
+		"NOTES_REPO":            "https://example.com/notes.git",
+		"VOYAGE_API_KEY":        "voyage",
+		"OPENAI_API_KEY":        "openai",
+		"VECTOR_STORAGE_FOLDER": dir,
+		"HARD_CODED_API_KEY":    "key",
+	}
+	for k, v := range extra {
+		env[k] = v
+	}
+	cfg := &config.EnvConfig{}
+	if err := env.Populate(cfg); err != nil {
+		t.Fatalf("Populate: %v", err)
+	}
+	return cfg
+}
+
+// queryHandler returns the QueryHandler of a server with cfg, logging experiments, feedback
+// and queries under its VECTOR_STORAGE_FOLDER.
+func queryHandler(t *testing.T, cfg *config.EnvConfig, client httpclient.Doer, m vectormgr.Manager) http.HandlerFunc {
+	t.Helper()
+	src := config.Static(cfg)
+	exp, err := experiment.New(src, filepath.Join(cfg.VectorStorageFolder, experiment.LogFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fb, err := feedback.New(src, filepath.Join(cfg.VectorStorageFolder, feedback.LogFile), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hist, err := analytics.New(src, filepath.Join(cfg.VectorStorageFolder, analytics.LogFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return QueryHandler(src, client, m, exp, fb, hist)
+}
+
+// stubOpenAI answers every chat completion with answer and records the prompts it was sent.
+type stubOpenAI struct {
+	answer  string
+	mu      sync.Mutex
+	prompts []string
+}
+
+func (s *stubOpenAI) Do(req *http.Request) (*http.Response, error) {
+	if !strings.Contains(req.URL.Path, "/chat/completions") {
+		return testsupport.JSONResponse(http.StatusNotFound, map[string]any{"error": "unexpected call to " + req.URL.String()}), nil
+	}
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	s.prompts = append(s.prompts, string(body))
+	s.mu.Unlock()
+	return testsupport.JSONResponse(http.StatusOK, testsupport.OpenAIResponse(s.answer)), nil
+}
+
+func TestQueryHandlerAnswersFromTheNotes(t *testing.T) {
+	cfg := testConfig(t, config.Env{"NO_ANSWER_SIMILARITY": "0"})
+	m, err := testsupport.NewManagerWith(context.Background(), map[string]string{
+		"sourdough": "The sourdough starter is fed with rye flour every morning.",
+		"bicycle":   "The bicycle chain was replaced in spring.",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	llm := &stubOpenAI{answer: "Rye flour, every morning."}
+	h := queryHandler(t, cfg, testsupport.DoerFunc(llm.Do), m)
+
+	rec := httptest.NewRecorder()
+	body := `{"query": "What is the sourdough starter fed with?"}`
+	h(rec, httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+
+	var resp struct {
+		Answer   string `json:"answer"`
+		NoAnswer bool   `json:"no_answer"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.NoAnswer || !strings.Contains(resp.Answer, llm.answer) {
+		t.Errorf("answer = %q (no_answer %v), want the LLM's answer", resp.Answer, resp.NoAnswer)
+	}
+	llm.mu.Lock()
+	defer llm.mu.Unlock()
+	if !strings.Contains(strings.Join(llm.prompts, "\n"), "rye flour every morning") {
+		t.Error("the retrieved note wasn't in any prompt")
+	}
+}
+
+func TestQueryHandlerRejectsInvalidRequests(t *testing.T) {
+	cfg := testConfig(t, nil)
+	h := queryHandler(t, cfg, testsupport.DoerFunc(func(req *http.Request) (*http.Response, error) {
+		t.Errorf("unexpected call to %s", req.URL)
+		return testsupport.JSONResponse(http.StatusInternalServerError, nil), nil
+	}), testsupport.NewManager())
+
+	for _, body := range []string{
+		``,
+		`{"query": ""}`,
+		`{"query": "q", "mode": "chat"}`,
+		`{"query": "q", "since": "yesterday"}`,
+		`{"query": "q", "since": "2024-01-01T00:00:00Z", "within_days": 7}`,
+		`{"query": "q", "language": "klingon"}`,
+	} {
+		rec := httptest.NewRecorder()
+		h(rec, httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(body)))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", body, rec.Code)
+		}
+	}
+}
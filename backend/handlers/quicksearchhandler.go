@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"vex-backend/apierror"
+	"vex-backend/catalog"
+	"vex-backend/config"
+	"vex-backend/git"
+)
+
+// quickSearchItem is a result in the shape of an Alfred Script Filter item, with the url
+// Raycast extensions open.
+type quickSearchItem struct {
+	UID          string `json:"uid"`
+	Title        string `json:"title"`
+	Subtitle     string `json:"subtitle"`
+	Arg          string `json:"arg"`
+	URL          string `json:"url,omitempty"`
+	QuicklookURL string `json:"quicklookurl,omitempty"`
+	Autocomplete string `json:"autocomplete"`
+}
+
+// QuickSearchHandler returns an http.HandlerFunc answering ?q from the note titles and
+// headings like /typeahead, in the JSON format of Alfred Script Filters, which Raycast
+// extensions can read too: each note becomes an item with a title, the note's path (and the
+// matching heading) as subtitle, and as arg and url the link NOTE_URL_TEMPLATE makes of the
+// path, or the path itself if it is unset. ?limit sets how many notes are returned.
+func QuickSearchHandler(cfg config.Source, repo *git.Repo, cat *catalog.Catalog) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			apierror.Write(w, r, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		q := r.URL.Query()
+		text := q.Get("q")
+		if len(text) > maxTypeaheadLength {
+			apierror.Write(w, r, http.StatusBadRequest, "query parameter 'q' must be at most "+strconv.Itoa(maxTypeaheadLength)+" bytes")
+			return
+		}
+		limit := defaultTypeaheadLimit
+		if raw := q.Get("limit"); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n < 1 || n > maxTypeaheadLimit {
+				apierror.Write(w, r, http.StatusBadRequest, "query parameter 'limit' must be an integer between 1 and "+strconv.Itoa(maxTypeaheadLimit))
+				return
+			}
+			limit = n
+		}
+
+		template := cfg().NoteURLTemplate
+		items := []quickSearchItem{}
+		for _, m := range cat.Typeahead(r.Context(), text, limit) {
+			item := quickSearchItem{UID: m.Path, Title: m.Title, Subtitle: m.Path, Arg: m.Path, Autocomplete: m.Title}
+			if rel, ok := vaultPath(repo, m.Path); ok {
+				item.Subtitle, item.Arg = rel, rel
+				if template != "" {
+					item.URL = strings.ReplaceAll(template, "{path}", escapeNotePath(rel))
+					item.Arg = item.URL
+				}
+			}
+			if item.Title == "" {
+				item.Title = path.Base(item.Subtitle)
+			}
+			if m.Heading != "" {
+				item.Subtitle = "§ " + m.Heading + " · " + item.Subtitle
+			}
+			if strings.HasPrefix(item.URL, "http://") || strings.HasPrefix(item.URL, "https://") {
+				item.QuicklookURL = item.URL
+			}
+			items = append(items, item)
+		}
+
+		respBytes, err := json.Marshal(map[string]any{"items": items})
+		if err != nil {
+			log.Printf("[QuickSearch] failed to marshal response: %v", err)
+			apierror.Write(w, r, http.StatusInternalServerError, "internal error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(respBytes)
+	}
+}
+
+// noteQueryEscaper escapes what url.PathEscape leaves alone but would end a query parameter
+var noteQueryEscaper = strings.NewReplacer("+", "%2B", "&", "%26", "=", "%3D")
+
+// escapeNotePath escapes each segment of rel so that it can stand in a URL path as well as
+// in a query parameter, as NOTE_URL_TEMPLATE may put it in either.
+func escapeNotePath(rel string) string {
+	segments := strings.Split(rel, "/")
+	for i, s := range segments {
+		segments[i] = noteQueryEscaper.Replace(url.PathEscape(s))
+	}
+	return strings.Join(segments, "/")
+}
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"vex-backend/apierror"
+	"vex-backend/config"
+	"vex-backend/git"
+	"vex-backend/httpclient"
+	"vex-backend/indexer"
+	"vex-backend/manifest"
+	"vex-backend/notify"
+	"vex-backend/usage"
+	vectormgr "vex-backend/vector/manager"
+)
+
+// errReindexIncomplete aborts a reindex in which some files failed, so the swap doesn't
+// replace a complete index with one missing them.
+var errReindexIncomplete = errors.New("files failed to index")
+
+// ReindexHandler returns an http.HandlerFunc that rebuilds the whole index from the local
+// clone of the notes repository, without pulling. The files are embedded into a staging
+// collection while queries keep being answered from the live one, which is swapped for it
+// only once every file was indexed; if any file fails, the staging collection is dropped
+// and the live index is left as it was. The outcome of every file is recorded in a staged
+// manifest, which replaces man only when the collection is swapped.
+func ReindexHandler(cfg config.Source, client httpclient.Doer, repo *git.Repo, m vectormgr.Manager, man *manifest.Manifest) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		log.Printf("[Reindex] invoked at %v from %s", start, r.RemoteAddr)
+
+		if r.Method != http.MethodPost {
+			apierror.Write(w, r, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		policy, err := indexer.LoadPolicy(cfg(), client, repo.Path())
+		if err != nil {
+			log.Printf("[Reindex] ignore rules error: %v", err)
+			writeError(w, r, "ignore rules error", err)
+			return
+		}
+		files, err := repo.Files("")
+		if err != nil {
+			log.Printf("[Reindex] failed to list files: %v", err)
+			writeError(w, r, "failed to list files", err)
+			return
+		}
+		log.Printf("[Reindex] rebuilding the index from %d files", len(files))
+
+		// a full rebuild is a sync cost, attributed like /resync
+		ctx := usage.WithSource(r.Context(), "resync")
+		var res indexer.Result
+		staged := man.Stage()
+		err = m.Reindex(ctx, func(staging vectormgr.Manager) error {
+			var runErr error
+			res, runErr = indexer.Run(ctx, staging, staged, policy, repo.Path(), files)
+			if runErr != nil {
+				return runErr
+			}
+			if len(res.Failed) > 0 {
+				return fmt.Errorf("%w: %d", errReindexIncomplete, len(res.Failed))
+			}
+			return nil
+		})
+		swapped := err == nil
+		if swapped {
+			if err = staged.Commit(); err != nil {
+				err = fmt.Errorf("failed to save the manifest of the swapped index: %w", err)
+			}
+		}
+		duration := time.Since(start)
+		notify.Send(cfg, client, notify.RunEvent("reindex", res, err, duration))
+
+		status, code := "swapped", http.StatusOK
+		switch {
+		case err != nil && swapped:
+			log.Printf("[Reindex] swapped: %v", err)
+			notify.AlertError(cfg, client, "reindex", err)
+			code = statusForError(err)
+		case err != nil:
+			log.Printf("[Reindex] aborted, keeping the live index: %v", err)
+			notify.AlertError(cfg, client, "reindex", err)
+			status, code = "aborted", statusForError(err)
+		}
+
+		failed := make(map[string]string, len(res.Failed))
+		for rel, ferr := range res.Failed {
+			failed[rel] = publicMessage(ferr)
+		}
+		resp := map[string]any{
+			"status":          status,
+			"processed_count": len(res.Processed),
+			"skipped_count":   len(res.Skipped),
+			"failed_count":    len(res.Failed),
+			"pending_count":   len(res.Pending),
+			"failed":          failed,
+			"pending":         res.Pending,
+			"duration_ms":     duration.Milliseconds(),
+			"usage":           usage.FromContext(ctx),
+		}
+		switch {
+		case errors.Is(err, errReindexIncomplete):
+			resp["error"] = fmt.Sprintf("%d files failed to index", len(res.Failed))
+		case err != nil:
+			resp["error"] = publicMessage(err)
+		}
+
+		respBytes, err := json.Marshal(resp)
+		if err != nil {
+			log.Printf("[Reindex] failed to marshal response: %v", err)
+			apierror.Write(w, r, http.StatusInternalServerError, "internal error")
+			return
+		}
+
+		log.Printf("[Reindex] %s: processed=%d skipped=%d failed=%d pending=%d duration=%s",
+			status, len(res.Processed), len(res.Skipped), len(res.Failed), len(res.Pending), duration)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		w.Write(respBytes)
+	}
+}
@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"vex-backend/apierror"
+	"vex-backend/git"
+	vectormgr "vex-backend/vector/manager"
+)
+
+const (
+	// defaultRelatedLimit is how many related notes are returned when ?k is not given
+	defaultRelatedLimit = 5
+	// maxRelatedLimit caps ?k
+	maxRelatedLimit = 50
+)
+
+// RelatedHandler returns an http.HandlerFunc that, given ?filepath=<note>, returns the ?k=<n>
+// notes most similar to it, one entry per note. The path is relative to the notes
+// repository (absolute paths inside it are accepted too).
+func RelatedHandler(repo *git.Repo, m vectormgr.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			apierror.Write(w, r, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		raw := r.URL.Query().Get("filepath")
+		if raw == "" {
+			apierror.Write(w, r, http.StatusBadRequest, "query parameter 'filepath' is required")
+			return
+		}
+		path, ok := resolveRepoPath(repo, raw)
+		if !ok {
+			apierror.Write(w, r, http.StatusBadRequest, "path must be inside the notes repository")
+			return
+		}
+
+		k := defaultRelatedLimit
+		if rawK := r.URL.Query().Get("k"); rawK != "" {
+			n, err := strconv.Atoi(rawK)
+			if err != nil || n < 1 || n > maxRelatedLimit {
+				apierror.Write(w, r, http.StatusBadRequest, "query parameter 'k' must be an integer between 1 and "+strconv.Itoa(maxRelatedLimit))
+				return
+			}
+			k = n
+		}
+
+		notes, err := vectormgr.RelatedNotes(r.Context(), m, path, k)
+		if err != nil {
+			log.Printf("[Related] error for %s: %v", path, err)
+			writeError(w, r, "related notes error", err)
+			return
+		}
+
+		respBytes, err := json.Marshal(map[string]any{
+			"filepath": path,
+			"related":  notes,
+		})
+		if err != nil {
+			log.Printf("[Related] failed to marshal response: %v", err)
+			apierror.Write(w, r, http.StatusInternalServerError, "internal error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(respBytes)
+	}
+}
@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"vex-backend/apierror"
+	"vex-backend/audit"
+	"vex-backend/config"
+)
+
+// ReloadConfigHandler returns an http.HandlerFunc that re-reads the configuration and swaps
+// in the runtime-reloadable settings, reporting which ones changed. An invalid configuration
+// is rejected with 400 and the running configuration is kept.
+func ReloadConfigHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			apierror.Write(w, r, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		changed, err := config.Reload()
+		audit.Record(r.Context(), audit.Entry{Action: audit.ActionReloadConfig, Detail: "changed: " + strings.Join(changed, ",")}, err)
+		if err != nil {
+			log.Printf("[ReloadConfig] reload rejected: %v", err)
+			// config errors name variables and values, never secrets, so they are safe to return
+			apierror.Write(w, r, http.StatusBadRequest, "config reload rejected: "+err.Error())
+			return
+		}
+		log.Printf("[ReloadConfig] reloaded config, changed: %v", changed)
+
+		resp := map[string]any{
+			"status":  "success",
+			"changed": changed,
+		}
+
+		respBytes, err := json.Marshal(resp)
+		if err != nil {
+			log.Printf("[ReloadConfig] failed to marshal response: %v", err)
+			apierror.Write(w, r, http.StatusInternalServerError, "internal error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(respBytes)
+	}
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"vex-backend/apierror"
+	"vex-backend/config"
+	"vex-backend/git"
+	"vex-backend/httpclient"
+	"vex-backend/indexer"
+	"vex-backend/manifest"
+	"vex-backend/notify"
+	"vex-backend/usage"
+	vectormgr "vex-backend/vector/manager"
+)
+
+// ResyncHandler returns an http.HandlerFunc that retries indexing of every file the
+// manifest still lists as pending or failed, without pulling the repository again. Files
+// ignored since they were queued are skipped.
+func ResyncHandler(cfg config.Source, client httpclient.Doer, repo *git.Repo, m vectormgr.Manager, man *manifest.Manifest) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		log.Printf("[Resync] invoked at %v from %s", start, r.RemoteAddr)
+
+		if r.Method != http.MethodPost {
+			apierror.Write(w, r, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		policy, err := indexer.LoadPolicy(cfg(), client, repo.Path())
+		if err != nil {
+			log.Printf("[Resync] ignore rules error: %v", err)
+			writeError(w, r, "ignore rules error", err)
+			return
+		}
+
+		files := man.Unfinished()
+		log.Printf("[Resync] found %d pending/failed files", len(files))
+
+		// Resyncs are sync costs, so attribute them separately from the caller's key
+		ctx := usage.WithSource(r.Context(), "resync")
+		res, runErr := indexer.Run(ctx, m, man, policy, repo.Path(), files)
+		notify.Send(cfg, client, notify.RunEvent("resync", res, runErr, time.Since(start)))
+		writeIndexResponse(w, r, "Resync", res, runErr, usage.FromContext(ctx), start)
+	}
+}
+
+// RetryFailedHandler returns an http.HandlerFunc that re-attempts indexing of the files the
+// manifest lists as failed, without pulling the repository or waiting for a commit that
+// touches them. ?path=<file or folder>, relative to the notes repository, retries only that
+// file or the failed files below the folder. Unlike /resync it leaves pending files alone.
+func RetryFailedHandler(cfg config.Source, client httpclient.Doer, repo *git.Repo, m vectormgr.Manager, man *manifest.Manifest) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		log.Printf("[RetryFailed] invoked at %v from %s", start, r.RemoteAddr)
+
+		if r.Method != http.MethodPost {
+			apierror.Write(w, r, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		dir := r.URL.Query().Get("path")
+		if filepath.IsAbs(dir) || dir == ".." || strings.HasPrefix(filepath.Clean(dir), ".."+string(filepath.Separator)) {
+			apierror.Write(w, r, http.StatusBadRequest, "query parameter 'path' must be relative to the notes repository")
+			return
+		}
+
+		policy, err := indexer.LoadPolicy(cfg(), client, repo.Path())
+		if err != nil {
+			log.Printf("[RetryFailed] ignore rules error: %v", err)
+			writeError(w, r, "ignore rules error", err)
+			return
+		}
+
+		files := man.Failed(dir)
+		log.Printf("[RetryFailed] found %d failed files", len(files))
+
+		// attributed like /resync, whose retries these are
+		ctx := usage.WithSource(r.Context(), "resync")
+		res, runErr := indexer.Run(ctx, m, man, policy, repo.Path(), files)
+		notify.Send(cfg, client, notify.RunEvent("retry-failed", res, runErr, time.Since(start)))
+		writeIndexResponse(w, r, "RetryFailed", res, runErr, usage.FromContext(ctx), start)
+	}
+}
@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"vex-backend/apierror"
+	"vex-backend/chat"
+	"vex-backend/config"
+	"vex-backend/lang"
+	"vex-backend/vector"
+	vectormgr "vex-backend/vector/manager"
+)
+
+const (
+	// defaultSearchLimit is how many chunks /search returns when the body gives no limit
+	defaultSearchLimit = 10
+	// maxSearchLimit caps the limit of /search
+	maxSearchLimit = 100
+)
+
+// retrievalScope holds the fields of a /query or /search body that select what retrieval
+// considers.
+type retrievalScope struct {
+	Tags       []string `json:"tags"`
+	Recency    bool     `json:"recency"`
+	PathPrefix string   `json:"path_prefix"`
+	PathGlob   string   `json:"path_glob"`
+	Since      string   `json:"since"`
+	Until      string   `json:"until"`
+	WithinDays int      `json:"within_days"`
+	AsOf       string   `json:"as_of"`
+	Language   string   `json:"language"`
+	Collection string   `json:"collection"`
+	// Channels scopes retrieval to Slack channels, in the collection they were imported into
+	Channels []string `json:"channels"`
+}
+
+// options validates s into the retrieval options of a query on the notes cloned to root.
+// The error is the message of a 400 response.
+func (s retrievalScope) options(root string) (chat.QueryOptions, error) {
+	opts := chat.QueryOptions{Tags: s.Tags, Recency: s.Recency, Channels: s.Channels}
+	opts.Paths = vectormgr.PathFilter{Root: root, Prefix: s.PathPrefix, Glob: s.PathGlob}
+	if _, err := opts.Paths.Matcher(); err != nil {
+		return chat.QueryOptions{}, errors.New("field 'path_glob': " + err.Error())
+	}
+	for name, field := range map[string]struct {
+		raw string
+		dst *time.Time
+	}{"since": {s.Since, &opts.Dates.Since}, "until": {s.Until, &opts.Dates.Until}, "as_of": {s.AsOf, &opts.AsOf}} {
+		if field.raw == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, field.raw)
+		if err != nil {
+			return chat.QueryOptions{}, errors.New("field '" + name + "' must be an RFC 3339 time")
+		}
+		*field.dst = t
+	}
+	if s.WithinDays < 0 {
+		return chat.QueryOptions{}, errors.New("field 'within_days' must not be negative")
+	}
+	if s.WithinDays > 0 {
+		if s.Since != "" {
+			return chat.QueryOptions{}, errors.New("fields 'since' and 'within_days' are mutually exclusive")
+		}
+		opts.Dates.Since = time.Now().AddDate(0, 0, -s.WithinDays)
+	}
+	opts.Language = strings.ToLower(strings.TrimSpace(s.Language))
+	if opts.Language != "" && !slices.Contains(lang.Languages(), opts.Language) {
+		return chat.QueryOptions{}, errors.New("field 'language' must be one of " + strings.Join(lang.Languages(), ", "))
+	}
+	return opts, nil
+}
+
+// collection returns the manager of the collection s names, m itself if it names none,
+// writing the error response and returning false if there is no such collection or it
+// can't be queried as of opts.AsOf.
+func (s retrievalScope) collection(w http.ResponseWriter, r *http.Request, m vectormgr.Manager, opts chat.QueryOptions) (vectormgr.Manager, bool) {
+	vm := m
+	if s.Collection != "" {
+		var err error
+		if vm, err = m.Collection(s.Collection); err != nil {
+			if errors.Is(err, vector.ErrNotFound) {
+				apierror.Write(w, r, http.StatusNotFound, "no collection named "+strconv.Quote(s.Collection))
+				return nil, false
+			}
+			writeError(w, r, "collection error", err)
+			return nil, false
+		}
+	}
+	if !opts.AsOf.IsZero() {
+		if _, err := vectormgr.AsOf(vm, opts.AsOf); errors.Is(err, vectormgr.ErrNoHistory) {
+			apierror.Write(w, r, http.StatusBadRequest, "field 'as_of' needs VERSION_HISTORY=true")
+			return nil, false
+		}
+	}
+	return vm, true
+}
+
+// searchResult is a chunk found by /search.
+type searchResult struct {
+	ID         string            `json:"id"`
+	Filepath   string            `json:"filepath,omitempty"`
+	Content    string            `json:"content"`
+	Similarity float32           `json:"similarity"`
+	Metadata   map[string]string `json:"metadata"`
+}
+
+// SearchHandler returns an http.HandlerFunc that retrieves the chunks most relevant to a
+// query without asking an LLM for an answer. It accepts the body of /query without the
+// answer options, plus "limit" (default 10, at most 100), and returns the chunks best
+// first with their similarity and metadata.
+func SearchHandler(cfg config.Source, m vectormgr.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			apierror.Write(w, r, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		var req struct {
+			Query string `json:"query"`
+			Limit int    `json:"limit"`
+			retrievalScope
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			if err == io.EOF {
+				apierror.Write(w, r, http.StatusBadRequest, "missing JSON body")
+				return
+			}
+			apierror.Write(w, r, http.StatusBadRequest, "invalid JSON: "+err.Error())
+			return
+		}
+		if req.Query == "" {
+			apierror.Write(w, r, http.StatusBadRequest, "field 'query' is required")
+			return
+		}
+		if req.Limit == 0 {
+			req.Limit = defaultSearchLimit
+		}
+		if req.Limit < 1 || req.Limit > maxSearchLimit {
+			apierror.Write(w, r, http.StatusBadRequest, "field 'limit' must be between 1 and "+strconv.Itoa(maxSearchLimit))
+			return
+		}
+		conf := cfg()
+		opts, err := req.options(conf.CloneFolder)
+		if err != nil {
+			apierror.Write(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		vm, ok := req.collection(w, r, m, opts)
+		if !ok {
+			return
+		}
+
+		chunks, err := chat.Retrieve(r.Context(), conf, vm, req.Query, req.Limit, opts)
+		if err != nil {
+			log.Printf("[Search] retrieval error: %v", err)
+			writeError(w, r, "search error", err)
+			return
+		}
+		results := make([]searchResult, 0, len(chunks))
+		for _, c := range chunks {
+			results = append(results, searchResult{ID: c.Id, Filepath: c.Metadata["filepath"], Content: c.Content, Similarity: c.Similarity, Metadata: c.Metadata})
+		}
+
+		respBytes, err := json.Marshal(map[string]any{
+			"query":   req.Query,
+			"results": results,
+		})
+		if err != nil {
+			log.Printf("[Search] failed to marshal response: %v", err)
+			apierror.Write(w, r, http.StatusInternalServerError, "internal error")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(respBytes)
+	}
+}
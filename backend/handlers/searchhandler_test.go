@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"vex-backend/config"
+	"vex-backend/testsupport"
+)
+
+func TestSearchHandler(t *testing.T) {
+	m, err := testsupport.NewManagerWith(context.Background(), map[string]string{
+		"sourdough": "The sourdough starter is fed with rye flour every morning.",
+		"bicycle":   "The bicycle chain was replaced in spring.",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := SearchHandler(config.Static(testConfig(t, nil)), m)
+
+	rec := httptest.NewRecorder()
+	h(rec, httptest.NewRequest(http.MethodPost, "/search", strings.NewReader(`{"query": "sourdough starter rye flour", "limit": 1}`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+	var resp struct {
+		Results []searchResult `json:"results"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Results) != 1 || resp.Results[0].ID != "sourdough" {
+		t.Errorf("results = %+v, want the sourdough note only", resp.Results)
+	}
+
+	for _, body := range []string{
+		`{"query": ""}`,
+		`{"query": "q", "limit": 1000}`,
+		`{"query": "q", "since": "yesterday"}`,
+		`{"query": "q", "within_days": -1}`,
+	} {
+		rec := httptest.NewRecorder()
+		h(rec, httptest.NewRequest(http.MethodPost, "/search", strings.NewReader(body)))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", body, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"vex-backend/apierror"
+	"vex-backend/audit"
+	"vex-backend/snapshot"
+)
+
+// SnapshotHandler returns an http.HandlerFunc that lists the vector store snapshots on GET
+// and creates one on POST, with an optional JSON body { "label": "before-reindex" }.
+func SnapshotHandler(s *snapshot.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var resp any
+		switch r.Method {
+		case http.MethodGet:
+			infos, err := s.List()
+			if err != nil {
+				log.Printf("[Snapshot] failed to list snapshots: %v", err)
+				writeError(w, r, "snapshot error", err)
+				return
+			}
+			resp = map[string]any{"snapshots": infos}
+		case http.MethodPost:
+			start := time.Now()
+			var req struct {
+				Label string `json:"label"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+				apierror.Write(w, r, http.StatusBadRequest, "invalid JSON: "+err.Error())
+				return
+			}
+			info, err := s.Create(r.Context(), req.Label)
+			audit.Record(r.Context(), audit.Entry{Action: audit.ActionSnapshot, Detail: info.Name}, err)
+			if err != nil {
+				log.Printf("[Snapshot] failed to create snapshot: %v", err)
+				writeError(w, r, "snapshot error", err)
+				return
+			}
+			log.Printf("[Snapshot] created %s (%d bytes) in %s", info.Name, info.Size, time.Since(start))
+			resp = info
+		default:
+			apierror.Write(w, r, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		respBytes, err := json.Marshal(resp)
+		if err != nil {
+			log.Printf("[Snapshot] failed to marshal response: %v", err)
+			apierror.Write(w, r, http.StatusInternalServerError, "internal error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(respBytes)
+	}
+}
+
+// SnapshotRestoreHandler returns an http.HandlerFunc that replaces the vector store with a
+// snapshot, given a JSON body { "name": "<snapshot name>" }. The current contents are
+// snapshotted first.
+func SnapshotRestoreHandler(s *snapshot.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		log.Printf("[Snapshot] restore invoked from %s", r.RemoteAddr)
+
+		if r.Method != http.MethodPost {
+			apierror.Write(w, r, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		var req struct {
+			Name string `json:"name"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			if err == io.EOF {
+				apierror.Write(w, r, http.StatusBadRequest, "missing JSON body")
+				return
+			}
+			apierror.Write(w, r, http.StatusBadRequest, "invalid JSON: "+err.Error())
+			return
+		}
+		if req.Name == "" {
+			apierror.Write(w, r, http.StatusBadRequest, "field 'name' is required")
+			return
+		}
+
+		info, err := s.Restore(r.Context(), req.Name)
+		audit.Record(r.Context(), audit.Entry{Action: audit.ActionSnapshotRestore, Detail: req.Name}, err)
+		if err != nil {
+			log.Printf("[Snapshot] failed to restore %s: %v", req.Name, err)
+			writeError(w, r, "snapshot restore error", err)
+			return
+		}
+
+		respBytes, err := json.Marshal(map[string]any{
+			"status":   "success",
+			"restored": info,
+		})
+		if err != nil {
+			log.Printf("[Snapshot] failed to marshal response: %v", err)
+			apierror.Write(w, r, http.StatusInternalServerError, "internal error")
+			return
+		}
+
+		log.Printf("[Snapshot] restored %s in %s", info.Name, time.Since(start))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(respBytes)
+	}
+}
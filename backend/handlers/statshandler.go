@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"vex-backend/apierror"
+	vectormgr "vex-backend/vector/manager"
+)
+
+// StatsHandler returns an http.HandlerFunc that reports statistics about the vector store:
+// the chunk count, the query cache and the estimated memory the vectors take.
+func StatsHandler(m vectormgr.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		count, err := m.Count(r.Context())
+		if err != nil {
+			log.Printf("[Stats] failed to count documents: %v", err)
+			writeError(w, r, "stats error", err)
+			return
+		}
+
+		memory, err := m.MemoryUsage(r.Context())
+		if err != nil {
+			log.Printf("[Stats] failed to estimate memory usage: %v", err)
+			writeError(w, r, "stats error", err)
+			return
+		}
+
+		resp := map[string]any{
+			"document_count": count,
+			"query_cache":    m.QueryCacheStats(),
+			"memory":         memory,
+		}
+
+		respBytes, err := json.Marshal(resp)
+		if err != nil {
+			log.Printf("[Stats] failed to marshal response: %v", err)
+			apierror.Write(w, r, http.StatusInternalServerError, "internal error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(respBytes)
+	}
+}
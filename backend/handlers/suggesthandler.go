@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"vex-backend/apierror"
+	"vex-backend/suggest"
+)
+
+const (
+	// defaultSuggestions is how many questions are suggested when ?n is not given
+	defaultSuggestions = 5
+	// maxSuggestions caps ?n
+	maxSuggestions = 20
+)
+
+// SuggestHandler returns an http.HandlerFunc that suggests example questions the indexed
+// notes can answer. ?n=<count> sets how many (5 by default) and ?refresh=true generates new
+// ones instead of returning the cached set.
+func SuggestHandler(c *suggest.Cache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		if r.Method != http.MethodGet {
+			apierror.Write(w, r, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		n := defaultSuggestions
+		if raw := r.URL.Query().Get("n"); raw != "" {
+			v, err := strconv.Atoi(raw)
+			if err != nil || v < 1 || v > maxSuggestions {
+				apierror.Write(w, r, http.StatusBadRequest, "query parameter 'n' must be an integer between 1 and "+strconv.Itoa(maxSuggestions))
+				return
+			}
+			n = v
+		}
+		refresh := r.URL.Query().Get("refresh") == "true"
+
+		s, err := c.Get(r.Context(), n, refresh)
+		if err != nil {
+			log.Printf("[Suggest] error: %v", err)
+			writeError(w, r, "suggest error", err)
+			return
+		}
+
+		respBytes, err := json.Marshal(s)
+		if err != nil {
+			log.Printf("[Suggest] failed to marshal response: %v", err)
+			apierror.Write(w, r, http.StatusInternalServerError, "internal error")
+			return
+		}
+
+		log.Printf("[Suggest] completed: questions=%d refresh=%t duration=%s", len(s.Questions), refresh, time.Since(start))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(respBytes)
+	}
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"vex-backend/apierror"
+	"vex-backend/chat"
+	"vex-backend/config"
+	"vex-backend/git"
+	"vex-backend/httpclient"
+	vectormgr "vex-backend/vector/manager"
+)
+
+// SummarizeHandler returns an http.HandlerFunc that summarizes a note or a folder of notes
+// from their stored chunks. It accepts a JSON body
+// { "filepath": "notes/a.md" } or { "folder": "projects/" }, plus an optional "store": true
+// to keep the summary as a derived document. Paths are relative to the notes repository
+// (absolute paths inside it are accepted too).
+func SummarizeHandler(cfg config.Source, client httpclient.Doer, repo *git.Repo, m vectormgr.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		log.Printf("[Summarize] invoked from %s", r.RemoteAddr)
+
+		if r.Method != http.MethodPost {
+			apierror.Write(w, r, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		var req struct {
+			Filepath string `json:"filepath"`
+			Folder   string `json:"folder"`
+			Store    bool   `json:"store"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			if err == io.EOF {
+				apierror.Write(w, r, http.StatusBadRequest, "missing JSON body")
+				return
+			}
+			apierror.Write(w, r, http.StatusBadRequest, "invalid JSON: "+err.Error())
+			return
+		}
+		if (req.Filepath == "") == (req.Folder == "") {
+			apierror.Write(w, r, http.StatusBadRequest, "exactly one of 'filepath' and 'folder' is required")
+			return
+		}
+
+		var target chat.SummarizeTarget
+		var ok bool
+		if req.Filepath != "" {
+			target.Path, ok = resolveRepoPath(repo, req.Filepath)
+		} else {
+			target.Prefix, ok = resolveRepoPath(repo, req.Folder)
+		}
+		if !ok {
+			apierror.Write(w, r, http.StatusBadRequest, "path must be inside the notes repository")
+			return
+		}
+
+		summary, err := chat.Summarize(r.Context(), cfg(), client, m, target, req.Store)
+		if err != nil {
+			log.Printf("[Summarize] error: %v", err)
+			writeError(w, r, "summarize error", err)
+			return
+		}
+
+		respBytes, err := json.Marshal(summary)
+		if err != nil {
+			log.Printf("[Summarize] failed to marshal response: %v", err)
+			apierror.Write(w, r, http.StatusInternalServerError, "internal error")
+			return
+		}
+
+		log.Printf("[Summarize] completed: files=%d stored=%t duration=%s", len(summary.Files), summary.StoredID != "", time.Since(start))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(respBytes)
+	}
+}
+
+// resolveRepoPath turns a path relative to the notes repository into the absolute form
+// stored in chunk metadata, rejecting paths that escape the repository.
+func resolveRepoPath(repo *git.Repo, path string) (string, bool) {
+	root, err := filepath.Abs(repo.Path())
+	if err != nil {
+		return "", false
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(root, path)
+	}
+	path = filepath.Clean(path)
+	if path != root && !strings.HasPrefix(path, root+string(filepath.Separator)) {
+		return "", false
+	}
+	return path, true
+}
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"vex-backend/apierror"
+	"vex-backend/topics"
+)
+
+// maxTopicClusters caps ?k
+const maxTopicClusters = 50
+
+// TopicsHandler returns an http.HandlerFunc that groups the indexed notes into labelled
+// topics. ?k=<n> sets the number of clusters (picked from the vault size by default) and
+// ?refresh=true recomputes the view instead of returning the cached one.
+func TopicsHandler(c *topics.Cache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		if r.Method != http.MethodGet {
+			apierror.Write(w, r, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		k := 0
+		if raw := r.URL.Query().Get("k"); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n < 1 || n > maxTopicClusters {
+				apierror.Write(w, r, http.StatusBadRequest, "query parameter 'k' must be an integer between 1 and "+strconv.Itoa(maxTopicClusters))
+				return
+			}
+			k = n
+		}
+		refresh := r.URL.Query().Get("refresh") == "true"
+
+		view, err := c.Get(r.Context(), k, refresh)
+		if err != nil {
+			log.Printf("[Topics] error: %v", err)
+			writeError(w, r, "topics error", err)
+			return
+		}
+
+		respBytes, err := json.Marshal(view)
+		if err != nil {
+			log.Printf("[Topics] failed to marshal response: %v", err)
+			apierror.Write(w, r, http.StatusInternalServerError, "internal error")
+			return
+		}
+
+		log.Printf("[Topics] completed: topics=%d notes=%d refresh=%t duration=%s", len(view.Topics), view.NoteCount, refresh, time.Since(start))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(respBytes)
+	}
+}
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+
+	"vex-backend/apierror"
+	"vex-backend/config"
+	"vex-backend/git"
+	vectormgr "vex-backend/vector/manager"
+)
+
+// TrashHandler returns an http.HandlerFunc that lists the soft-deleted chunks, grouped per
+// file and deletion, with the time each entry will be purged.
+func TrashHandler(cfg config.Source, m vectormgr.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			apierror.Write(w, r, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		trashed, err := m.ListTrash(r.Context())
+		if err != nil {
+			log.Printf("[Trash] failed to list trash: %v", err)
+			writeError(w, r, "trash error", err)
+			return
+		}
+
+		c := cfg()
+		respBytes, err := json.Marshal(map[string]any{
+			"soft_delete": c.SoftDelete,
+			"retention":   c.SoftDeleteRetention.String(),
+			"entries":     vectormgr.GroupTrash(trashed, c.SoftDeleteRetention),
+		})
+		if err != nil {
+			log.Printf("[Trash] failed to marshal response: %v", err)
+			apierror.Write(w, r, http.StatusInternalServerError, "internal error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(respBytes)
+	}
+}
+
+// RestoreHandler returns an http.HandlerFunc that brings back soft-deleted chunks of a note.
+// It accepts a JSON body { "filepath": "notes/a.md", "deleted_at": "..." }; without
+// deleted_at the latest deletion is restored. The note's current chunks are moved to the
+// trash in exchange. Paths are relative to the notes repository.
+func RestoreHandler(repo *git.Repo, m vectormgr.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("[Restore] invoked from %s", r.RemoteAddr)
+
+		if r.Method != http.MethodPost {
+			apierror.Write(w, r, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		var req struct {
+			Filepath  string `json:"filepath"`
+			DeletedAt string `json:"deleted_at"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			if err == io.EOF {
+				apierror.Write(w, r, http.StatusBadRequest, "missing JSON body")
+				return
+			}
+			apierror.Write(w, r, http.StatusBadRequest, "invalid JSON: "+err.Error())
+			return
+		}
+		if req.Filepath == "" {
+			apierror.Write(w, r, http.StatusBadRequest, "field 'filepath' is required")
+			return
+		}
+		path, ok := resolveRepoPath(repo, req.Filepath)
+		if !ok {
+			apierror.Write(w, r, http.StatusBadRequest, "path must be inside the notes repository")
+			return
+		}
+
+		restored, err := m.RestoreFromTrash(r.Context(), path, req.DeletedAt)
+		if err != nil {
+			log.Printf("[Restore] error for %s: %v", path, err)
+			writeError(w, r, "restore error", err)
+			return
+		}
+
+		respBytes, err := json.Marshal(map[string]any{
+			"status":          "success",
+			"filepath":        path,
+			"restored_chunks": restored,
+		})
+		if err != nil {
+			log.Printf("[Restore] failed to marshal response: %v", err)
+			apierror.Write(w, r, http.StatusInternalServerError, "internal error")
+			return
+		}
+
+		log.Printf("[Restore] restored %d chunks of %s", restored, path)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(respBytes)
+	}
+}
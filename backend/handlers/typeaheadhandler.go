@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"vex-backend/apierror"
+	"vex-backend/catalog"
+)
+
+const (
+	// defaultTypeaheadLimit is how many notes are returned when ?limit is not given
+	defaultTypeaheadLimit = 8
+	// maxTypeaheadLimit caps ?limit
+	maxTypeaheadLimit = 50
+	// maxTypeaheadLength caps ?q; typeahead is for what fits a search box
+	maxTypeaheadLength = 200
+)
+
+// TypeaheadHandler returns an http.HandlerFunc that completes ?q against the note titles and
+// headings in the catalog, for search-as-you-type. It makes no embedding or LLM call.
+// ?limit sets how many notes are returned (8 by default).
+func TypeaheadHandler(cat *catalog.Catalog) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			apierror.Write(w, r, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		q := r.URL.Query()
+		text := q.Get("q")
+		if len(text) > maxTypeaheadLength {
+			apierror.Write(w, r, http.StatusBadRequest, "query parameter 'q' must be at most "+strconv.Itoa(maxTypeaheadLength)+" bytes")
+			return
+		}
+		limit := defaultTypeaheadLimit
+		if raw := q.Get("limit"); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n < 1 || n > maxTypeaheadLimit {
+				apierror.Write(w, r, http.StatusBadRequest, "query parameter 'limit' must be an integer between 1 and "+strconv.Itoa(maxTypeaheadLimit))
+				return
+			}
+			limit = n
+		}
+
+		respBytes, err := json.Marshal(map[string]any{
+			"matches": cat.Typeahead(r.Context(), text, limit),
+		})
+		if err != nil {
+			log.Printf("[Typeahead] failed to marshal response: %v", err)
+			apierror.Write(w, r, http.StatusInternalServerError, "internal error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(respBytes)
+	}
+}
@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"vex-backend/apierror"
+	"vex-backend/usage"
+)
+
+// UsageHandler returns an http.HandlerFunc that reports aggregated embedding and chat
+// usage per day and per source. Optional "from" and "to" query parameters (YYYY-MM-DD)
+// restrict the reported days.
+func UsageHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		report := usage.Snapshot(q.Get("from"), q.Get("to"))
+
+		respBytes, err := json.Marshal(report)
+		if err != nil {
+			log.Printf("[Usage] failed to marshal response: %v", err)
+			apierror.Write(w, r, http.StatusInternalServerError, "internal error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(respBytes)
+	}
+}
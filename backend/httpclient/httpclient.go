@@ -0,0 +1,51 @@
+package httpclient
+
+import (
+	"net"
+	"net/http"
+	"time"
+)
+
+// DefaultTimeout bounds a whole request, including reading the response body. Chat
+// completions for long contexts are the slowest calls and stay well below it.
+const DefaultTimeout = 2 * time.Minute
+
+// Doer is the part of *http.Client used by the API clients (Voyage, OpenAI), so tests
+// can substitute a stub that never touches the network.
+type Doer interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// Default is the client shared by the API clients when none is injected.
+var Default = New(DefaultTimeout)
+
+// New returns a client with the given overall timeout and a pooled transport that keeps
+// connections to the (few) API hosts alive between requests. Proxies are taken from the
+// HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables.
+func New(timeout time.Duration) *http.Client {
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   10 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   10,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+	return &http.Client{
+		Transport: transport,
+		Timeout:   timeout,
+	}
+}
+
+// OrDefault returns d, or Default when d is nil.
+func OrDefault(d Doer) Doer {
+	if d == nil {
+		return Default
+	}
+	return d
+}
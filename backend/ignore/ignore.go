@@ -0,0 +1,82 @@
+// Package ignore decides which files of the notes repository are indexed. Files are
+// excluded by a .vexignore file in the repository root, written in gitignore syntax, and by
+// the INDEX_EXCLUDE globs; when INDEX_INCLUDE is set, only files matching one of its globs are
+// considered at all.
+package ignore
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"vex-backend/config"
+
+	"github.com/go-git/go-git/v5/plumbing/format/gitignore"
+)
+
+// FileName is the ignore file read from the repository root
+const FileName = ".vexignore"
+
+// Rules decides whether a repository file is indexed. The zero value ignores nothing.
+type Rules struct {
+	// include is nil when every file is included
+	include gitignore.Matcher
+	exclude gitignore.Matcher
+}
+
+// Load reads the .vexignore file in root, if any, and combines it with the INDEX_INCLUDE and
+// INDEX_EXCLUDE globs of cfg. Both are read on every call, so changes to either apply to the
+// next indexing run.
+func Load(cfg *config.EnvConfig, root string) (*Rules, error) {
+	var excludes []gitignore.Pattern
+	data, err := os.ReadFile(filepath.Join(root, FileName))
+	switch {
+	case err == nil:
+		excludes = Parse(data)
+	case !errors.Is(err, os.ErrNotExist):
+		return nil, fmt.Errorf("failed to read %s: %w", FileName, err)
+	}
+	// the configured excludes come last, so a .vexignore negation can't re-include them
+	for _, glob := range cfg.IndexExcludes() {
+		excludes = append(excludes, gitignore.ParsePattern(glob, nil))
+	}
+
+	r := &Rules{exclude: gitignore.NewMatcher(excludes)}
+	if includes := cfg.IndexIncludes(); len(includes) > 0 {
+		patterns := make([]gitignore.Pattern, 0, len(includes))
+		for _, glob := range includes {
+			patterns = append(patterns, gitignore.ParsePattern(glob, nil))
+		}
+		r.include = gitignore.NewMatcher(patterns)
+	}
+	return r, nil
+}
+
+// Parse reads gitignore-style patterns, skipping blank lines and comments.
+func Parse(data []byte) []gitignore.Pattern {
+	var patterns []gitignore.Pattern
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, gitignore.ParsePattern(line, nil))
+	}
+	return patterns
+}
+
+// Ignored reports whether the repository-relative file rel is excluded from indexing.
+func (r *Rules) Ignored(rel string) bool {
+	if r == nil {
+		return false
+	}
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	if r.include != nil && !r.include.Match(parts, false) {
+		return true
+	}
+	return r.exclude != nil && r.exclude.Match(parts, false)
+}
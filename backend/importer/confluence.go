@@ -0,0 +1,180 @@
+package importer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"vex-backend/config"
+	"vex-backend/httpclient"
+)
+
+// Metadata keys of the chunks of Confluence pages.
+const (
+	SourceConfluence = "confluence"
+	// ConfluencePageMetadataKey is the ID of the page, ConfluenceURLMetadataKey its link
+	ConfluencePageMetadataKey = "confluence_page_id"
+	ConfluenceURLMetadataKey  = "confluence_url"
+	// ConfluenceSpaceMetadataKey is the key of the space the page belongs to
+	ConfluenceSpaceMetadataKey = "confluence_space"
+	// ConfluenceAuthorMetadataKey is who saved the imported version of the page
+	ConfluenceAuthorMetadataKey = "confluence_author"
+)
+
+// confluencePageSize is how many pages one request of the content API returns; Confluence
+// caps it lower when the bodies are expanded
+const confluencePageSize = 50
+
+// Confluence imports the pages of Confluence spaces through the REST API.
+type Confluence struct {
+	// URL is the base of the wiki, e.g. https://example.atlassian.net/wiki
+	URL string
+	// User and Token authenticate with basic auth, as Confluence Cloud expects an account's
+	// email and API token; without User, Token is sent as a bearer personal access token, as
+	// Confluence Data Center expects
+	User  string
+	Token string
+	// Spaces are the keys of the spaces whose pages are imported
+	Spaces []string
+	// Client sends the API requests; nil uses httpclient.Default
+	Client httpclient.Doer
+}
+
+// NewConfluence returns the Confluence source importing spaces from CONFLUENCE_URL.
+func NewConfluence(cfg *config.EnvConfig, client httpclient.Doer, spaces []string) (*Confluence, error) {
+	if cfg.ConfluenceURL == "" || cfg.ConfluenceToken == "" {
+		return nil, fmt.Errorf("CONFLUENCE_URL and CONFLUENCE_TOKEN must be set")
+	}
+	return &Confluence{URL: cfg.ConfluenceURL, User: cfg.ConfluenceUser, Token: cfg.ConfluenceToken, Spaces: spaces, Client: client}, nil
+}
+
+func (c *Confluence) Name() string { return SourceConfluence }
+
+// confluencePage is the part of a content object the import uses.
+type confluencePage struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+	Space struct {
+		Key string `json:"key"`
+	} `json:"space"`
+	Version struct {
+		When time.Time `json:"when"`
+		By   struct {
+			DisplayName string `json:"displayName"`
+		} `json:"by"`
+	} `json:"version"`
+	Body struct {
+		Storage struct {
+			Value string `json:"value"`
+		} `json:"storage"`
+	} `json:"body"`
+	Links struct {
+		WebUI string `json:"webui"`
+	} `json:"_links"`
+}
+
+// Documents fetches the current version of every page of the spaces, converting their
+// bodies to markdown. Blog posts, attachments and comments are left out.
+func (c *Confluence) Documents(ctx context.Context) ([]Document, error) {
+	var docs []Document
+	for _, space := range c.Spaces {
+		pages, err := c.spacePages(ctx, space)
+		if err != nil {
+			return nil, fmt.Errorf("space %s: %w", space, err)
+		}
+		for _, p := range pages {
+			docs = append(docs, c.document(p))
+		}
+	}
+	return docs, nil
+}
+
+// document converts page into a Document; a body that can't be parsed sets Err.
+func (c *Confluence) document(p confluencePage) Document {
+	doc := Document{
+		Path:     "confluence://" + p.ID,
+		Title:    p.Title,
+		Modified: p.Version.When,
+		Metadata: map[string]string{
+			ConfluencePageMetadataKey:   p.ID,
+			ConfluenceSpaceMetadataKey:  p.Space.Key,
+			ConfluenceAuthorMetadataKey: p.Version.By.DisplayName,
+		},
+	}
+	if p.Links.WebUI != "" {
+		doc.Metadata[ConfluenceURLMetadataKey] = strings.TrimSuffix(c.URL, "/") + p.Links.WebUI
+	}
+	doc.Content, doc.Err = storageMarkdown(p.Body.Storage.Value)
+	return doc
+}
+
+// spacePages returns every current page of a space with its body, following the API's
+// pagination.
+func (c *Confluence) spacePages(ctx context.Context, space string) ([]confluencePage, error) {
+	var pages []confluencePage
+	for start := 0; ; {
+		q := url.Values{
+			"spaceKey": {space},
+			"type":     {"page"},
+			"status":   {"current"},
+			"expand":   {"body.storage,version,space"},
+			"start":    {strconv.Itoa(start)},
+			"limit":    {strconv.Itoa(confluencePageSize)},
+		}
+		var list struct {
+			Results []confluencePage `json:"results"`
+			Size    int              `json:"size"`
+			Links   struct {
+				Next string `json:"next"`
+			} `json:"_links"`
+		}
+		if err := c.call(ctx, "/rest/api/content?"+q.Encode(), &list); err != nil {
+			return nil, err
+		}
+		pages = append(pages, list.Results...)
+		if list.Links.Next == "" || len(list.Results) == 0 {
+			return pages, nil
+		}
+		start += len(list.Results)
+	}
+}
+
+// call sends a GET request to the REST API and decodes the JSON response into out.
+func (c *Confluence) call(ctx context.Context, path string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(c.URL, "/")+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.User != "" {
+		req.SetBasicAuth(c.User, c.Token)
+	} else {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	resp, err := httpclient.OrDefault(c.Client).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Message string `json:"message"`
+		}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Message != "" {
+			return fmt.Errorf("Confluence API returned %s: %s", resp.Status, apiErr.Message)
+		}
+		return fmt.Errorf("Confluence API returned %s", resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode the Confluence response: %w", err)
+	}
+	return nil
+}
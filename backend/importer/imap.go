@@ -0,0 +1,159 @@
+package importer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+
+	"vex-backend/config"
+)
+
+// Metadata keys of the chunks of email messages.
+const (
+	SourceIMAP = "imap"
+	// MessageIDMetadataKey is the message's Message-ID, without angle brackets;
+	// InReplyToMetadataKey that of the message it answers
+	MessageIDMetadataKey = "message_id"
+	InReplyToMetadataKey = "in_reply_to"
+	// ThreadMetadataKey is the Message-ID of the message that started the thread, so the
+	// messages of a conversation can be found together
+	ThreadMetadataKey = "thread_id"
+	// FromMetadataKey, ToMetadataKey and CcMetadataKey are the addresses of the sender and the
+	// recipients, as "Name <address>" joined with ", "
+	FromMetadataKey = "from"
+	ToMetadataKey   = "to"
+	CcMetadataKey   = "cc"
+	// SentMetadataKey is the message's date (RFC 3339)
+	SentMetadataKey = "sent_at"
+	// FolderMetadataKey is the folder the message was imported from
+	FolderMetadataKey = "mail_folder"
+	// SkippedAttachmentsMetadataKey lists the names of the attachments left out, joined with ", "
+	SkippedAttachmentsMetadataKey = "skipped_attachments"
+)
+
+// imapFetchBatch is how many messages are fetched per command
+const imapFetchBatch = 50
+
+// IMAP imports the messages of mail folders, read-only: messages stay unread on the server.
+type IMAP struct {
+	// URL is the server, imaps://host[:port] or imap://host[:port] for STARTTLS
+	URL      string
+	User     string
+	Password string
+	Folders  []string
+	// MaxMessages is how many of the newest messages of each folder are imported; 0 for all
+	MaxMessages int
+	// MaxSize in bytes is how much of each message is read; attachments past it are lost.
+	// 0 reads messages whole
+	MaxSize int64
+	// AttachmentExtensions are the extensions, with their dot, of the attachments whose text
+	// is imported with the message
+	AttachmentExtensions []string
+}
+
+// NewIMAP returns the IMAP source importing folders, or the IMAP_FOLDERS if folders is empty.
+func NewIMAP(cfg *config.EnvConfig, folders []string) (*IMAP, error) {
+	if cfg.IMAPURL == "" {
+		return nil, fmt.Errorf("IMAP_URL is not set")
+	}
+	if cfg.IMAPUser == "" || cfg.IMAPPassword == "" {
+		return nil, fmt.Errorf("IMAP_USER and IMAP_PASSWORD are required")
+	}
+	if len(folders) == 0 {
+		folders = cfg.IMAPFolderList()
+	}
+	return &IMAP{
+		URL:                  cfg.IMAPURL,
+		User:                 cfg.IMAPUser,
+		Password:             cfg.IMAPPassword,
+		Folders:              folders,
+		MaxMessages:          cfg.IMAPMaxMessages,
+		MaxSize:              cfg.MaxFileSize,
+		AttachmentExtensions: cfg.IMAPAttachmentExtensionList(),
+	}, nil
+}
+
+func (im *IMAP) Name() string { return SourceIMAP }
+
+// Documents fetches the newest messages of every folder. A message found in several folders,
+// as Gmail labels are, is imported once, from the first. Failing to connect or log in fails
+// the import with ErrFetch; a folder that can't be read is reported as failed on its own.
+func (im *IMAP) Documents(ctx context.Context) ([]Document, error) {
+	u, err := url.Parse(im.URL)
+	if err != nil || (u.Scheme != "imaps" && u.Scheme != "imap") || u.Host == "" {
+		return nil, fmt.Errorf("%w: %q is not an imap(s) URL", ErrFetch, im.URL)
+	}
+	c, err := dialIMAP(ctx, u)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrFetch, u.Host, err)
+	}
+	defer c.Close()
+	if err := c.login(im.User, im.Password); err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrFetch, u.Host, err)
+	}
+
+	var docs []Document
+	seen := map[string]bool{}
+	for _, folder := range im.Folders {
+		messages, err := im.folderMessages(c, folder)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			docs = append(docs, Document{Path: "imap://" + u.Host + "/" + folder, Err: err})
+			continue
+		}
+		log.Printf("[Import] read %d messages from %s", len(messages), folder)
+		for _, raw := range messages {
+			doc := parseMail(raw.data, im.AttachmentExtensions)
+			id := doc.Metadata[MessageIDMetadataKey]
+			if id == "" {
+				// without a Message-ID the message is only known by its place in the folder
+				id = folder + "/" + strconv.FormatUint(uint64(raw.uid), 10)
+			}
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+			doc.Path = "imap://" + u.Host + "/" + id
+			doc.Metadata[FolderMetadataKey] = folder
+			docs = append(docs, doc)
+		}
+	}
+	return docs, nil
+}
+
+type imapMessage struct {
+	uid  uint32
+	data []byte
+}
+
+// folderMessages returns the newest MaxMessages messages of folder, oldest first.
+func (im *IMAP) folderMessages(c *imapConn, folder string) ([]imapMessage, error) {
+	if err := c.examine(folder); err != nil {
+		return nil, err
+	}
+	uids, err := c.searchAll()
+	if err != nil {
+		return nil, err
+	}
+	if im.MaxMessages > 0 && len(uids) > im.MaxMessages {
+		uids = uids[len(uids)-im.MaxMessages:]
+	}
+	var messages []imapMessage
+	for start := 0; start < len(uids); start += imapFetchBatch {
+		batch := uids[start:min(start+imapFetchBatch, len(uids))]
+		fetched, err := c.fetch(batch, im.MaxSize)
+		if err != nil {
+			return nil, err
+		}
+		for _, uid := range batch {
+			if data, ok := fetched[uid]; ok {
+				messages = append(messages, imapMessage{uid: uid, data: data})
+			}
+		}
+	}
+	return messages, nil
+}
@@ -0,0 +1,226 @@
+package importer
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// imapTimeout bounds every IMAP command, so a stalled server doesn't hang an import
+const imapTimeout = 2 * time.Minute
+
+// maxIMAPLiteral bounds a literal read from the server; fetches ask for less than this
+const maxIMAPLiteral = 64 << 20
+
+var (
+	reIMAPLiteral = regexp.MustCompile(`\{(\d+)\+?\}$`)
+	reIMAPUID     = regexp.MustCompile(`\bUID (\d+)`)
+)
+
+// imapConn is a minimal IMAP4rev1 client (RFC 3501), enough to read mail: it logs in,
+// examines folders read-only, searches and fetches messages. Commands are sent one at a time
+// and wait for their tagged completion.
+type imapConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	tag  int
+	// stop releases the hook closing the connection when the import's context is done
+	stop func() bool
+}
+
+// imapResponse is an untagged response. The literals it carried are replaced by "{}" in
+// line and kept, in order, in literals.
+type imapResponse struct {
+	line     string
+	literals [][]byte
+}
+
+// dialIMAP connects to an imaps:// server over TLS, or to an imap:// one upgraded with
+// STARTTLS; credentials are never sent in the clear.
+func dialIMAP(ctx context.Context, u *url.URL) (*imapConn, error) {
+	host, port := u.Hostname(), u.Port()
+	if port == "" {
+		port = "993"
+		if u.Scheme == "imap" {
+			port = "143"
+		}
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(host, port))
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{ServerName: host}
+	if u.Scheme != "imap" {
+		conn = tls.Client(conn, tlsConfig)
+	}
+	c := &imapConn{conn: conn, r: bufio.NewReader(conn)}
+	c.stop = context.AfterFunc(ctx, func() { c.conn.SetDeadline(time.Now()) })
+
+	c.conn.SetDeadline(time.Now().Add(imapTimeout))
+	greeting, err := c.readResponse()
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	if !strings.HasPrefix(greeting.line, "* OK") && !strings.HasPrefix(greeting.line, "* PREAUTH") {
+		c.Close()
+		return nil, fmt.Errorf("unexpected greeting %q", greeting.line)
+	}
+	if u.Scheme == "imap" {
+		if _, err := c.command("STARTTLS"); err != nil {
+			c.Close()
+			return nil, err
+		}
+		tc := tls.Client(conn, tlsConfig)
+		c.conn, c.r = tc, bufio.NewReader(tc)
+	}
+	return c, nil
+}
+
+// Close logs out, ignoring failures, and closes the connection.
+func (c *imapConn) Close() error {
+	c.stop()
+	c.conn.SetDeadline(time.Now().Add(5 * time.Second))
+	fmt.Fprintf(c.conn, "z LOGOUT\r\n")
+	return c.conn.Close()
+}
+
+// login authenticates with a username and password.
+func (c *imapConn) login(user, password string) error {
+	_, err := c.command("LOGIN " + imapQuote(user) + " " + imapQuote(password))
+	return err
+}
+
+// examine opens folder read-only, so fetching leaves messages unread.
+func (c *imapConn) examine(folder string) error {
+	_, err := c.command("EXAMINE " + imapQuote(folder))
+	return err
+}
+
+// searchAll returns the UIDs of every message of the open folder, in ascending order.
+func (c *imapConn) searchAll() ([]uint32, error) {
+	resps, err := c.command("UID SEARCH ALL")
+	if err != nil {
+		return nil, err
+	}
+	var uids []uint32
+	for _, resp := range resps {
+		fields, ok := strings.CutPrefix(resp.line, "* SEARCH")
+		if !ok {
+			continue
+		}
+		for _, f := range strings.Fields(fields) {
+			if uid, err := strconv.ParseUint(f, 10, 32); err == nil {
+				uids = append(uids, uint32(uid))
+			}
+		}
+	}
+	return uids, nil
+}
+
+// fetch returns the raw messages of uids, keyed by UID. With maxSize > 0 only the first
+// maxSize bytes of each message are fetched.
+func (c *imapConn) fetch(uids []uint32, maxSize int64) (map[uint32][]byte, error) {
+	set := make([]string, len(uids))
+	for i, uid := range uids {
+		set[i] = strconv.FormatUint(uint64(uid), 10)
+	}
+	item := "BODY.PEEK[]"
+	if maxSize > 0 {
+		item += fmt.Sprintf("<0.%d>", maxSize)
+	}
+	resps, err := c.command("UID FETCH " + strings.Join(set, ",") + " (UID " + item + ")")
+	if err != nil {
+		return nil, err
+	}
+	messages := map[uint32][]byte{}
+	for _, resp := range resps {
+		// unsolicited FETCH responses, e.g. flag changes, carry no message
+		if !strings.Contains(resp.line, " FETCH ") || len(resp.literals) == 0 {
+			continue
+		}
+		m := reIMAPUID.FindStringSubmatch(resp.line)
+		if m == nil {
+			continue
+		}
+		uid, _ := strconv.ParseUint(m[1], 10, 32)
+		messages[uint32(uid)] = resp.literals[0]
+	}
+	return messages, nil
+}
+
+// command sends cmd and returns its untagged responses once it completed. Errors only name
+// the command's verb, so credentials don't end up in logs.
+func (c *imapConn) command(cmd string) ([]imapResponse, error) {
+	c.tag++
+	tag := "a" + strconv.Itoa(c.tag)
+	verb, _, _ := strings.Cut(cmd, " ")
+	if verb == "UID" {
+		verb = strings.Join(strings.Fields(cmd)[:2], " ")
+	}
+
+	c.conn.SetDeadline(time.Now().Add(imapTimeout))
+	if _, err := io.WriteString(c.conn, tag+" "+cmd+"\r\n"); err != nil {
+		return nil, fmt.Errorf("%s: %w", verb, err)
+	}
+	var untagged []imapResponse
+	for {
+		resp, err := c.readResponse()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", verb, err)
+		}
+		status, tagged := strings.CutPrefix(resp.line, tag+" ")
+		if !tagged {
+			untagged = append(untagged, resp)
+			continue
+		}
+		if !strings.HasPrefix(status, "OK") {
+			return nil, fmt.Errorf("%s failed: %s", verb, status)
+		}
+		return untagged, nil
+	}
+}
+
+// readResponse reads one response line, along with the literals embedded in it.
+func (c *imapConn) readResponse() (imapResponse, error) {
+	var resp imapResponse
+	var b strings.Builder
+	for {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			return resp, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		m := reIMAPLiteral.FindStringSubmatch(line)
+		if m == nil {
+			b.WriteString(line)
+			break
+		}
+		n, err := strconv.Atoi(m[1])
+		if err != nil || n > maxIMAPLiteral {
+			return resp, fmt.Errorf("literal of %s bytes is too large", m[1])
+		}
+		b.WriteString(strings.TrimSuffix(line, m[0]) + "{}")
+		literal := make([]byte, n)
+		if _, err := io.ReadFull(c.r, literal); err != nil {
+			return resp, err
+		}
+		resp.literals = append(resp.literals, literal)
+	}
+	resp.line = b.String()
+	return resp, nil
+}
+
+// imapQuote returns s as an IMAP quoted string.
+func imapQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
@@ -0,0 +1,141 @@
+// Package importer brings documents kept outside the notes repository, such as Notion pages,
+// into the vector store. Sources only fetch documents and convert them to markdown; Import
+// chunks, embeds and stores them like notes, through the same embedder and redaction.
+package importer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"vex-backend/access"
+	"vex-backend/breaker"
+	"vex-backend/vector/embed"
+	vectormgr "vex-backend/vector/manager"
+)
+
+// SourceMetadataKey is set to the source's name on chunks of imported documents, like the
+// ocr and transcript chunks it sits next to.
+const SourceMetadataKey = "source"
+
+// Document is a page fetched by a Source, converted to markdown.
+type Document struct {
+	// Path stands in for the file path of a note, e.g. notion://<page id>; it is stored as the
+	// chunks' filepath, so the document can be looked up and deleted like a file
+	Path     string
+	Title    string
+	Content  string
+	Modified time.Time
+	// Metadata is stored on every chunk of the document, on top of the common keys
+	Metadata map[string]string
+	// Tags are stored like the tags of notes, so queries can be scoped to them
+	Tags []string
+	// Err is set when the document could not be fetched; it is reported as failed
+	Err error
+}
+
+// Source fetches the documents of an outside service.
+type Source interface {
+	// Name is the source's name, e.g. "notion", stored as SourceMetadataKey
+	Name() string
+	// Documents fetches every document the source is configured for. Documents that fail on
+	// their own carry their error; an error fails the whole import.
+	Documents(ctx context.Context) ([]Document, error)
+}
+
+// Result collects the per-document outcome of an import, by Path.
+type Result struct {
+	Imported []string
+	// Unchanged documents were not modified since they were last imported
+	Unchanged []string
+	Failed    map[string]error
+}
+
+// Import fetches the documents of src and embeds each one into m, replacing the chunks it had
+// from an earlier import. Documents whose modification time matches the stored one are left
+// as they are. Like an indexing run, a failing document doesn't stop the import unless the
+// embedding circuit breaker is open.
+func Import(ctx context.Context, m vectormgr.Manager, src Source) (Result, error) {
+	res := Result{Imported: []string{}, Unchanged: []string{}, Failed: map[string]error{}}
+	docs, err := src.Documents(ctx)
+	if err != nil {
+		return res, fmt.Errorf("failed to fetch %s documents: %w", src.Name(), err)
+	}
+	log.Printf("[Import] fetched %d %s documents", len(docs), src.Name())
+
+	for _, doc := range docs {
+		if doc.Err != nil {
+			log.Printf("[Import] failed to fetch %s: %v", doc.Path, doc.Err)
+			res.Failed[doc.Path] = doc.Err
+			continue
+		}
+		unchanged, err := ImportDocument(ctx, m, src.Name(), doc)
+		switch {
+		case err != nil:
+			log.Printf("[Import] failed to import %s: %v", doc.Path, err)
+			res.Failed[doc.Path] = err
+			if errors.Is(err, breaker.ErrOpen) {
+				return res, err
+			}
+		case unchanged:
+			res.Unchanged = append(res.Unchanged, doc.Path)
+		default:
+			log.Printf("[Import] embedded %s (%s)", doc.Path, doc.Title)
+			res.Imported = append(res.Imported, doc.Path)
+		}
+	}
+	return res, nil
+}
+
+// ImportDocument embeds doc into m for the named source, replacing its chunks, and reports
+// true if it was left alone because its modification time matches the stored one.
+func ImportDocument(ctx context.Context, m vectormgr.Manager, source string, doc Document) (bool, error) {
+	modified := doc.Modified.UTC().Format(time.RFC3339)
+	previous, err := m.GetChunksByFile(ctx, doc.Path)
+	if err != nil {
+		return false, err
+	}
+	if len(previous) > 0 && !doc.Modified.IsZero() && previous[0].Metadata["mod_time"] == modified {
+		return true, nil
+	}
+
+	metadata := map[string]string{
+		"filepath":         doc.Path,
+		"filename":         doc.Title,
+		SourceMetadataKey:  source,
+		access.MetadataKey: access.Shared,
+	}
+	if !doc.Modified.IsZero() {
+		metadata["mod_time"] = modified
+	}
+	for k, v := range doc.Metadata {
+		metadata[k] = v
+	}
+	var tags []string
+	for _, tag := range doc.Tags {
+		if tag = embed.NormalizeTag(tag); tag != "" {
+			tags = append(tags, tag)
+			metadata[embed.TagMetadataPrefix+tag] = "true"
+		}
+	}
+	if len(tags) > 0 {
+		metadata["tags"] = strings.Join(tags, ",")
+	}
+
+	content := strings.TrimSpace(doc.Content)
+	if doc.Title != "" {
+		content = "# " + doc.Title + "\n\n" + content
+	}
+	// embed before touching the stored chunks, so a failure leaves the old ones searchable
+	vs, err := m.GetEmbedder().EmbedStringToVectorData(ctx, content, metadata)
+	if err != nil {
+		return false, err
+	}
+	if err := m.DeleteVectorsWithMetaData(ctx, "filepath", doc.Path); err != nil {
+		return false, err
+	}
+	return false, m.StoreVectorsInDB(ctx, vs)
+}
@@ -0,0 +1,278 @@
+package importer
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+
+	"vex-backend/access"
+)
+
+// maxMIMEDepth bounds the nesting of multiparts and forwarded messages that is followed
+const maxMIMEDepth = 8
+
+var mailWords = &mime.WordDecoder{CharsetReader: charset.NewReaderLabel}
+
+// mimeHeader is what the headers of messages and of their parts have in common
+type mimeHeader interface {
+	Get(key string) string
+}
+
+// parseMail converts a raw message to a Document: the subject as title, the sender,
+// recipients and date as a header block followed by the readable body, with the threading
+// headers as metadata. Messages are private notes. A message that can't be parsed sets Err.
+func parseMail(raw []byte, attachmentExts []string) Document {
+	doc := Document{Metadata: map[string]string{access.MetadataKey: access.Private}}
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		doc.Err = fmt.Errorf("failed to parse the message: %w", err)
+		return doc
+	}
+	h := msg.Header
+
+	doc.Title = decodeMailHeader(h.Get("Subject"))
+	if doc.Title == "" {
+		doc.Title = "(no subject)"
+	}
+	id := messageID(h.Get("Message-Id"))
+	doc.Metadata[MessageIDMetadataKey] = id
+	if replyTo := messageID(h.Get("In-Reply-To")); replyTo != "" {
+		doc.Metadata[InReplyToMetadataKey] = replyTo
+	}
+	// the first of the References is the message that started the thread
+	thread := id
+	if refs := strings.Fields(h.Get("References")); len(refs) > 0 {
+		thread = messageID(refs[0])
+	} else if replyTo := doc.Metadata[InReplyToMetadataKey]; replyTo != "" {
+		thread = replyTo
+	}
+	if thread != "" {
+		doc.Metadata[ThreadMetadataKey] = thread
+	}
+
+	var head strings.Builder
+	for _, f := range []struct{ header, key string }{
+		{"From", FromMetadataKey}, {"To", ToMetadataKey}, {"Cc", CcMetadataKey},
+	} {
+		if addrs := mailAddresses(h.Get(f.header)); addrs != "" {
+			doc.Metadata[f.key] = addrs
+			head.WriteString(f.header + ": " + addrs + "\n")
+		}
+	}
+	if date, err := h.Date(); err == nil {
+		doc.Modified = date
+		doc.Metadata[SentMetadataKey] = date.UTC().Format(time.RFC3339)
+		head.WriteString("Date: " + date.Format(time.RFC1123Z) + "\n")
+	}
+
+	mc := &mailContent{attachmentExts: attachmentExts}
+	mc.walk(h, msg.Body, 0)
+	if len(mc.skipped) > 0 {
+		doc.Metadata[SkippedAttachmentsMetadataKey] = strings.Join(mc.skipped, ", ")
+	}
+	doc.Content = head.String() + "\n" + strings.Join(mc.parts, "\n\n")
+	return doc
+}
+
+// mailContent collects the readable parts of a message.
+type mailContent struct {
+	attachmentExts []string
+	// parts are the body's text and that of the imported attachments, in order
+	parts   []string
+	skipped []string
+}
+
+// walk adds the readable content of a MIME entity. Of the alternatives of a
+// multipart/alternative the plain text is preferred; attachments are imported only if their
+// extension is one of attachmentExts. Entities cut short by the fetch size are read as far as
+// they go.
+func (mc *mailContent) walk(h mimeHeader, body io.Reader, depth int) {
+	mediaType, params, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		mediaType, params = "text/plain", nil
+	}
+	disposition, dparams, _ := mime.ParseMediaType(h.Get("Content-Disposition"))
+	filename := dparams["filename"]
+	if filename == "" {
+		filename = params["name"]
+	}
+	filename = decodeMailHeader(filename)
+
+	switch {
+	case strings.HasPrefix(mediaType, "multipart/"):
+		if depth >= maxMIMEDepth || params["boundary"] == "" {
+			return
+		}
+		mr := multipart.NewReader(body, params["boundary"])
+		var alternatives []*mailContent
+		var types []string
+		for {
+			p, err := mr.NextRawPart()
+			if err != nil {
+				break
+			}
+			if mediaType != "multipart/alternative" {
+				mc.walk(p.Header, p, depth+1)
+				continue
+			}
+			alt := &mailContent{attachmentExts: mc.attachmentExts}
+			alt.walk(p.Header, p, depth+1)
+			t, _, _ := mime.ParseMediaType(p.Header.Get("Content-Type"))
+			alternatives, types = append(alternatives, alt), append(types, t)
+		}
+		if len(alternatives) > 0 {
+			best := alternatives[len(alternatives)-1]
+			if i := slices.Index(types, "text/plain"); i >= 0 && len(alternatives[i].parts) > 0 {
+				best = alternatives[i]
+			}
+			mc.parts = append(mc.parts, best.parts...)
+			mc.skipped = append(mc.skipped, best.skipped...)
+		}
+	case mediaType == "message/rfc822" && disposition != "attachment":
+		if depth >= maxMIMEDepth {
+			return
+		}
+		fwd, err := mail.ReadMessage(transferDecoded(h, body))
+		if err != nil {
+			return
+		}
+		mc.parts = append(mc.parts, "Forwarded message: "+decodeMailHeader(fwd.Header.Get("Subject")))
+		mc.walk(fwd.Header, fwd.Body, depth+1)
+	case disposition == "attachment" || filename != "":
+		if filename == "" {
+			filename = "unnamed " + mediaType
+		}
+		ext := strings.ToLower(filepath.Ext(filename))
+		if !slices.Contains(mc.attachmentExts, ext) || !(strings.HasPrefix(mediaType, "text/") || mediaType == "application/octet-stream") {
+			mc.skipped = append(mc.skipped, filename)
+			return
+		}
+		if text := strings.TrimSpace(readText(h, body, params["charset"])); text != "" {
+			mc.parts = append(mc.parts, "## Attachment: "+filename+"\n\n"+text)
+		}
+	case mediaType == "text/plain":
+		if text := strings.TrimSpace(stripQuoted(readText(h, body, params["charset"]))); text != "" {
+			mc.parts = append(mc.parts, text)
+		}
+	case mediaType == "text/html":
+		if text := htmlMailText(readText(h, body, params["charset"])); text != "" {
+			mc.parts = append(mc.parts, text)
+		}
+	}
+}
+
+// transferDecoded undoes the Content-Transfer-Encoding of an entity's body.
+func transferDecoded(h mimeHeader, body io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(h.Get("Content-Transfer-Encoding"))) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		return quotedprintable.NewReader(body)
+	}
+	return body
+}
+
+// readText reads an entity's body as UTF-8 text, keeping what could be read of a body that
+// is cut short or malformed.
+func readText(h mimeHeader, body io.Reader, label string) string {
+	r := transferDecoded(h, body)
+	if label != "" {
+		if cr, err := charset.NewReaderLabel(label, r); err == nil {
+			r = cr
+		}
+	}
+	data, _ := io.ReadAll(r)
+	return strings.ReplaceAll(string(data), "\r\n", "\n")
+}
+
+// stripQuoted drops the quoted lines of a reply and the "On ..., Alice wrote:" line
+// introducing them, so the messages of a thread don't repeat each other.
+func stripQuoted(text string) string {
+	var out []string
+	for _, line := range strings.Split(text, "\n") {
+		if !strings.HasPrefix(strings.TrimSpace(line), ">") {
+			out = append(out, line)
+			continue
+		}
+		for len(out) > 0 && strings.TrimSpace(out[len(out)-1]) == "" {
+			out = out[:len(out)-1]
+		}
+		if n := len(out); n > 0 && strings.HasSuffix(strings.TrimSpace(out[n-1]), "wrote:") {
+			out = out[:n-1]
+		}
+	}
+	return strings.Join(out, "\n")
+}
+
+// htmlMailText converts an HTML body to markdown, leaving out the quoted message of a reply.
+func htmlMailText(body string) string {
+	doc, err := html.Parse(strings.NewReader(body))
+	if err != nil {
+		return ""
+	}
+	prune(doc)
+	var quotes []*html.Node
+	walk(doc, func(n *html.Node) {
+		if (n.Data == "blockquote" && attr(n, "type") == "cite") || strings.Contains(attr(n, "class"), "gmail_quote") {
+			quotes = append(quotes, n)
+		}
+	})
+	for _, q := range quotes {
+		if q.Parent != nil {
+			q.Parent.RemoveChild(q)
+		}
+	}
+	root := findElement(doc, func(n *html.Node) bool { return n.Data == "body" })
+	if root == nil {
+		return ""
+	}
+	var b strings.Builder
+	writeBlocks(&b, toXNode(root, nil).children)
+	return strings.TrimSpace(b.String())
+}
+
+// decodeMailHeader decodes the RFC 2047 encoded words of a header value.
+func decodeMailHeader(v string) string {
+	if decoded, err := mailWords.DecodeHeader(v); err == nil {
+		v = decoded
+	}
+	return strings.Join(strings.Fields(v), " ")
+}
+
+// mailAddresses formats an address list header as "Name <address>, ...", keeping the raw
+// value if it can't be parsed.
+func mailAddresses(v string) string {
+	if strings.TrimSpace(v) == "" {
+		return ""
+	}
+	parser := mail.AddressParser{WordDecoder: mailWords}
+	list, err := parser.ParseList(v)
+	if err != nil {
+		return decodeMailHeader(v)
+	}
+	out := make([]string, len(list))
+	for i, a := range list {
+		out[i] = a.Address
+		if a.Name != "" {
+			out[i] = a.Name + " <" + a.Address + ">"
+		}
+	}
+	return strings.Join(out, ", ")
+}
+
+// messageID returns a Message-ID without its angle brackets.
+func messageID(v string) string {
+	return strings.Trim(strings.TrimSpace(v), "<>")
+}
@@ -0,0 +1,425 @@
+package importer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"vex-backend/config"
+	"vex-backend/httpclient"
+)
+
+// Metadata keys of the chunks of Notion pages.
+const (
+	SourceNotion = "notion"
+	// NotionPageMetadataKey is the ID of the page, NotionURLMetadataKey its link in Notion
+	NotionPageMetadataKey = "notion_page_id"
+	NotionURLMetadataKey  = "notion_url"
+	// NotionDatabaseMetadataKey is the ID of the database the page was imported from, if any
+	NotionDatabaseMetadataKey = "notion_database_id"
+)
+
+// notionVersion is the API version the requests and the block conversion are written against
+const notionVersion = "2022-06-28"
+
+// notionRetries bounds how often a rate-limited request is retried
+const notionRetries = 3
+
+// Notion imports pages from Notion through an internal integration, which must have been
+// shared with the pages and databases to import.
+type Notion struct {
+	// URL is the base of the Notion API, https://api.notion.com/v1
+	URL   string
+	Token string
+	// Databases are the IDs of databases whose pages are all imported; Pages of single pages
+	Databases []string
+	Pages     []string
+	// Client sends the API requests; nil uses httpclient.Default
+	Client httpclient.Doer
+}
+
+// NewNotion returns the Notion source importing databases and pages with NOTION_TOKEN.
+func NewNotion(cfg *config.EnvConfig, client httpclient.Doer, databases, pages []string) (*Notion, error) {
+	if cfg.NotionToken == "" {
+		return nil, fmt.Errorf("NOTION_TOKEN is not set")
+	}
+	return &Notion{URL: cfg.NotionURL, Token: cfg.NotionToken, Databases: databases, Pages: pages, Client: client}, nil
+}
+
+func (n *Notion) Name() string { return SourceNotion }
+
+// notionPage is the part of a Notion page object the import uses.
+type notionPage struct {
+	ID             string                    `json:"id"`
+	URL            string                    `json:"url"`
+	CreatedTime    time.Time                 `json:"created_time"`
+	LastEditedTime time.Time                 `json:"last_edited_time"`
+	Archived       bool                      `json:"archived"`
+	InTrash        bool                      `json:"in_trash"`
+	Properties     map[string]notionProperty `json:"properties"`
+}
+
+type notionProperty struct {
+	Type  string           `json:"type"`
+	Title []notionRichText `json:"title"`
+}
+
+type notionRichText struct {
+	PlainText   string `json:"plain_text"`
+	Href        string `json:"href"`
+	Annotations struct {
+		Bold          bool `json:"bold"`
+		Italic        bool `json:"italic"`
+		Strikethrough bool `json:"strikethrough"`
+		Code          bool `json:"code"`
+	} `json:"annotations"`
+}
+
+// notionBlock is a block with the content of its type, decoded on conversion.
+type notionBlock struct {
+	ID          string                     `json:"id"`
+	Type        string                     `json:"type"`
+	HasChildren bool                       `json:"has_children"`
+	Content     map[string]json.RawMessage `json:"-"`
+}
+
+func (b *notionBlock) UnmarshalJSON(data []byte) error {
+	type plain notionBlock
+	if err := json.Unmarshal(data, (*plain)(b)); err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &b.Content)
+}
+
+// notionList is a page of a paginated API response.
+type notionList[T any] struct {
+	Results    []T    `json:"results"`
+	HasMore    bool   `json:"has_more"`
+	NextCursor string `json:"next_cursor"`
+}
+
+// Documents fetches the pages of every database and the single pages, converting their
+// blocks to markdown. Archived and trashed pages are left out; a page listed twice is
+// imported once.
+func (n *Notion) Documents(ctx context.Context) ([]Document, error) {
+	var docs []Document
+	seen := map[string]bool{}
+	add := func(p notionPage, database string) {
+		if p.Archived || p.InTrash || seen[p.ID] {
+			return
+		}
+		seen[p.ID] = true
+		docs = append(docs, n.document(ctx, p, database))
+	}
+
+	for _, db := range n.Databases {
+		pages, err := n.queryDatabase(ctx, db)
+		if err != nil {
+			return nil, fmt.Errorf("database %s: %w", db, err)
+		}
+		for _, p := range pages {
+			add(p, db)
+		}
+	}
+	for _, id := range n.Pages {
+		var p notionPage
+		if err := n.call(ctx, http.MethodGet, "/pages/"+url.PathEscape(id), nil, &p); err != nil {
+			docs = append(docs, Document{Path: notionPath(id), Err: err})
+			continue
+		}
+		add(p, "")
+	}
+	return docs, nil
+}
+
+// document converts page into a Document; failing to read its blocks sets Err.
+func (n *Notion) document(ctx context.Context, p notionPage, database string) Document {
+	doc := Document{
+		Path:     notionPath(p.ID),
+		Title:    pageTitle(p),
+		Modified: p.LastEditedTime,
+		Metadata: map[string]string{
+			NotionPageMetadataKey: p.ID,
+			NotionURLMetadataKey:  p.URL,
+			"created_time":        p.CreatedTime.UTC().Format(time.RFC3339),
+		},
+	}
+	if database != "" {
+		doc.Metadata[NotionDatabaseMetadataKey] = database
+	}
+	var b strings.Builder
+	if err := n.writeBlocks(ctx, &b, p.ID, ""); err != nil {
+		doc.Err = err
+	}
+	doc.Content = b.String()
+	return doc
+}
+
+// notionPath is the stand-in file path of a page.
+func notionPath(id string) string {
+	return "notion://" + strings.ReplaceAll(id, "-", "")
+}
+
+// pageTitle returns the text of the page's title property, "Untitled" as Notion shows it if
+// it is empty.
+func pageTitle(p notionPage) string {
+	for _, prop := range p.Properties {
+		if prop.Type == "title" {
+			if t := strings.TrimSpace(plainText(prop.Title)); t != "" {
+				return t
+			}
+		}
+	}
+	return "Untitled"
+}
+
+// queryDatabase returns every page of a database.
+func (n *Notion) queryDatabase(ctx context.Context, id string) ([]notionPage, error) {
+	var pages []notionPage
+	cursor := ""
+	for {
+		body := map[string]any{"page_size": 100}
+		if cursor != "" {
+			body["start_cursor"] = cursor
+		}
+		var list notionList[notionPage]
+		if err := n.call(ctx, http.MethodPost, "/databases/"+url.PathEscape(id)+"/query", body, &list); err != nil {
+			return nil, err
+		}
+		pages = append(pages, list.Results...)
+		if !list.HasMore || list.NextCursor == "" {
+			return pages, nil
+		}
+		cursor = list.NextCursor
+	}
+}
+
+// children returns every child block of the block or page id.
+func (n *Notion) children(ctx context.Context, id string) ([]notionBlock, error) {
+	var blocks []notionBlock
+	cursor := ""
+	for {
+		q := url.Values{"page_size": {"100"}}
+		if cursor != "" {
+			q.Set("start_cursor", cursor)
+		}
+		var list notionList[notionBlock]
+		if err := n.call(ctx, http.MethodGet, "/blocks/"+url.PathEscape(id)+"/children?"+q.Encode(), nil, &list); err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, list.Results...)
+		if !list.HasMore || list.NextCursor == "" {
+			return blocks, nil
+		}
+		cursor = list.NextCursor
+	}
+}
+
+// writeBlocks writes the children of id as markdown, each line prefixed with indent. Nested
+// blocks are indented below list items and toggles, and written as they are otherwise.
+func (n *Notion) writeBlocks(ctx context.Context, b *strings.Builder, id, indent string) error {
+	blocks, err := n.children(ctx, id)
+	if err != nil {
+		return err
+	}
+	number := 0
+	for _, block := range blocks {
+		if block.Type == "numbered_list_item" {
+			number++
+		} else {
+			number = 0
+		}
+		line, nested := blockMarkdown(block, number)
+		if line != "" {
+			for _, l := range strings.Split(line, "\n") {
+				b.WriteString(indent + l + "\n")
+			}
+			if !nested {
+				b.WriteString("\n")
+			}
+		}
+		// child pages and databases are imported on their own, if at all
+		if !block.HasChildren || block.Type == "child_page" || block.Type == "child_database" {
+			continue
+		}
+		inner := indent
+		if nested {
+			inner += "  "
+		}
+		if err := n.writeBlocks(ctx, b, block.ID, inner); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// blockContent is the content of the block types that are converted.
+type blockContent struct {
+	RichText   []notionRichText   `json:"rich_text"`
+	Checked    bool               `json:"checked"`
+	Language   string             `json:"language"`
+	Caption    []notionRichText   `json:"caption"`
+	Title      string             `json:"title"`
+	URL        string             `json:"url"`
+	Cells      [][]notionRichText `json:"cells"`
+	Expression string             `json:"expression"`
+}
+
+// blockMarkdown converts a block to markdown, reporting whether its children belong indented
+// below it, as for list items. Unsupported block types convert to "".
+func blockMarkdown(block notionBlock, number int) (string, bool) {
+	var c blockContent
+	if raw, ok := block.Content[block.Type]; ok {
+		json.Unmarshal(raw, &c)
+	}
+	text := richTextMarkdown(c.RichText)
+	switch block.Type {
+	case "paragraph":
+		return text, false
+	case "heading_1":
+		return "# " + text, false
+	case "heading_2":
+		return "## " + text, false
+	case "heading_3":
+		return "### " + text, false
+	case "bulleted_list_item":
+		return "- " + text, true
+	case "numbered_list_item":
+		return strconv.Itoa(number) + ". " + text, true
+	case "to_do":
+		if c.Checked {
+			return "- [x] " + text, true
+		}
+		return "- [ ] " + text, true
+	case "toggle":
+		return "- " + text, true
+	case "quote", "callout":
+		return "> " + strings.ReplaceAll(text, "\n", "\n> "), false
+	case "code":
+		return "```" + c.Language + "\n" + plainText(c.RichText) + "\n```", false
+	case "equation":
+		return "$$" + c.Expression + "$$", false
+	case "divider":
+		return "---", false
+	case "child_page":
+		return "## " + c.Title, false
+	case "table_row":
+		cells := make([]string, len(c.Cells))
+		for i, cell := range c.Cells {
+			cells[i] = richTextMarkdown(cell)
+		}
+		return "| " + strings.Join(cells, " | ") + " |", true
+	case "bookmark", "embed", "link_preview":
+		if caption := richTextMarkdown(c.Caption); caption != "" {
+			return "[" + caption + "](" + c.URL + ")", false
+		}
+		return c.URL, false
+	case "image", "file", "pdf", "video", "audio":
+		// hosted file URLs expire, so only the caption is worth embedding
+		return richTextMarkdown(c.Caption), false
+	}
+	return "", false
+}
+
+// richTextMarkdown converts rich text to markdown, keeping emphasis, inline code and links.
+func richTextMarkdown(rt []notionRichText) string {
+	var b strings.Builder
+	for _, t := range rt {
+		s := t.PlainText
+		if s == "" {
+			continue
+		}
+		if t.Annotations.Code {
+			s = "`" + s + "`"
+		}
+		if t.Annotations.Bold {
+			s = "**" + s + "**"
+		}
+		if t.Annotations.Italic {
+			s = "*" + s + "*"
+		}
+		if t.Annotations.Strikethrough {
+			s = "~~" + s + "~~"
+		}
+		if t.Href != "" {
+			s = "[" + s + "](" + t.Href + ")"
+		}
+		b.WriteString(s)
+	}
+	return b.String()
+}
+
+// plainText joins the text of rich text without formatting.
+func plainText(rt []notionRichText) string {
+	var b strings.Builder
+	for _, t := range rt {
+		b.WriteString(t.PlainText)
+	}
+	return b.String()
+}
+
+// call sends a request to the Notion API and decodes the JSON response into out, waiting and
+// retrying when rate limited.
+func (n *Notion) call(ctx context.Context, method, path string, body, out any) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(n.URL, "/")+path, bytes.NewReader(payload))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+n.Token)
+		req.Header.Set("Notion-Version", notionVersion)
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		resp, err := httpclient.OrDefault(n.Client).Do(req)
+		if err != nil {
+			return err
+		}
+
+		if resp.StatusCode == http.StatusTooManyRequests && attempt < notionRetries {
+			resp.Body.Close()
+			wait := time.Second
+			if s, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && s > 0 {
+				wait = time.Duration(s) * time.Second
+			}
+			select {
+			case <-time.After(wait):
+				continue
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			var apiErr struct {
+				Code    string `json:"code"`
+				Message string `json:"message"`
+			}
+			data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+			if json.Unmarshal(data, &apiErr) == nil && apiErr.Message != "" {
+				return fmt.Errorf("Notion API returned %s: %s (%s)", resp.Status, apiErr.Message, apiErr.Code)
+			}
+			return fmt.Errorf("Notion API returned %s", resp.Status)
+		}
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return fmt.Errorf("failed to decode the Notion response: %w", err)
+		}
+		return nil
+	}
+}
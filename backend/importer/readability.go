@@ -0,0 +1,253 @@
+package importer
+
+import (
+	"bytes"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// article is the readable part of a web page, converted to markdown.
+type article struct {
+	Title   string
+	Author  string
+	Content string
+	// Published and Modified are the dates the page declares, zero if it doesn't
+	Published time.Time
+	Modified  time.Time
+}
+
+var (
+	// elements that never hold the article's text
+	clutterTags = map[string]bool{
+		"script": true, "style": true, "noscript": true, "iframe": true, "svg": true, "canvas": true,
+		"form": true, "button": true, "input": true, "select": true, "textarea": true,
+		"nav": true, "aside": true, "footer": true,
+	}
+	// class names and IDs of boilerplate, unless they also look like the content
+	reClutter = regexp.MustCompile(`(?i)\b(comment|sidebar|footer|navbar|nav|menu|breadcrumb|share|social|sponsor|promo|related|cookie|banner|newsletter|subscribe|popup|modal|advert|ad-|ads)\b`)
+	reContent = regexp.MustCompile(`(?i)\b(article|content|post|entry|main|story|body|text)\b`)
+)
+
+// extractArticle finds the article in an HTML page, the way reader modes do: the <article>
+// or <main> element if the page has one, the element holding most of the paragraph text
+// otherwise, leaving out scripts, navigation and other boilerplate. Links are resolved
+// against base.
+func extractArticle(data []byte, base *url.URL) (article, error) {
+	doc, err := html.Parse(bytes.NewReader(data))
+	if err != nil {
+		return article{}, err
+	}
+	a := pageMetadata(doc)
+	prune(doc)
+
+	root := findElement(doc, func(n *html.Node) bool { return n.Data == "article" })
+	if root == nil {
+		root = findElement(doc, func(n *html.Node) bool { return n.Data == "main" || attr(n, "role") == "main" })
+	}
+	if root == nil {
+		root = densestElement(doc)
+	}
+	if root == nil {
+		return a, nil
+	}
+
+	var b strings.Builder
+	writeBlocks(&b, toXNode(root, base).children)
+	content := strings.TrimSpace(b.String())
+	// the title is prepended to the content on import, so drop the article's own
+	if a.Title != "" {
+		content = strings.TrimSpace(strings.TrimPrefix(content, "# "+a.Title))
+	}
+	if a.Title == "" {
+		if h1 := findElement(root, func(n *html.Node) bool { return n.Data == "h1" }); h1 != nil {
+			a.Title = strings.TrimSpace(reSpace.ReplaceAllString(nodeText(h1), " "))
+		}
+	}
+	a.Content = content
+	return a, nil
+}
+
+// pageMetadata reads the title, byline and dates from the page's head: Open Graph and
+// article properties first, the <title> element otherwise.
+func pageMetadata(doc *html.Node) article {
+	var a article
+	var title string
+	walk(doc, func(n *html.Node) {
+		switch n.Data {
+		case "title":
+			if title == "" {
+				title = strings.TrimSpace(nodeText(n))
+			}
+		case "meta":
+			key := attr(n, "property")
+			if key == "" {
+				key = attr(n, "name")
+			}
+			value := strings.TrimSpace(attr(n, "content"))
+			switch strings.ToLower(key) {
+			case "og:title":
+				a.Title = value
+			case "author", "article:author":
+				// article:author is often a profile URL, which says nothing
+				if a.Author == "" && !strings.Contains(value, "://") {
+					a.Author = value
+				}
+			case "article:published_time":
+				a.Published, _ = time.Parse(time.RFC3339, value)
+			case "article:modified_time":
+				a.Modified, _ = time.Parse(time.RFC3339, value)
+			}
+		}
+	})
+	if a.Title == "" {
+		a.Title = title
+	}
+	return a
+}
+
+// prune removes the elements that never hold the article's text.
+func prune(n *html.Node) {
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		switch {
+		case c.Type == html.CommentNode:
+			n.RemoveChild(c)
+		case c.Type == html.ElementNode && isClutter(c):
+			n.RemoveChild(c)
+		default:
+			prune(c)
+		}
+		c = next
+	}
+}
+
+func isClutter(n *html.Node) bool {
+	if clutterTags[n.Data] || attr(n, "hidden") != "" || attr(n, "aria-hidden") == "true" {
+		return true
+	}
+	if n.Data == "body" || n.Data == "article" || n.Data == "main" {
+		return false
+	}
+	names := attr(n, "class") + " " + attr(n, "id")
+	return reClutter.MatchString(names) && !reContent.MatchString(names)
+}
+
+// densestElement returns the element whose paragraphs hold the most text, the way
+// readability scores candidates: each paragraph counts for its parent and half for its
+// grandparent, and text inside links counts against it.
+func densestElement(doc *html.Node) *html.Node {
+	scores := map[*html.Node]float64{}
+	walk(doc, func(n *html.Node) {
+		if n.Data != "p" && n.Data != "pre" && n.Data != "td" {
+			return
+		}
+		text := nodeText(n)
+		if len(strings.TrimSpace(text)) < 25 {
+			return
+		}
+		score := 1 + float64(strings.Count(text, ",")) + min(float64(len(text))/100, 3)
+		if p := n.Parent; p != nil {
+			scores[p] += score
+			if gp := p.Parent; gp != nil {
+				scores[gp] += score / 2
+			}
+		}
+	})
+
+	var best *html.Node
+	bestScore := 0.0
+	for n, score := range scores {
+		score *= 1 - linkDensity(n)
+		if score > bestScore {
+			best, bestScore = n, score
+		}
+	}
+	if best == nil {
+		best = findElement(doc, func(n *html.Node) bool { return n.Data == "body" })
+	}
+	return best
+}
+
+// linkDensity is the share of n's text inside links.
+func linkDensity(n *html.Node) float64 {
+	total := len(nodeText(n))
+	if total == 0 {
+		return 0
+	}
+	linked := 0
+	walk(n, func(c *html.Node) {
+		if c.Data == "a" {
+			linked += len(nodeText(c))
+		}
+	})
+	return float64(linked) / float64(total)
+}
+
+// toXNode converts an HTML element to the tree writeBlocks converts to markdown, resolving
+// link targets against base.
+func toXNode(n *html.Node, base *url.URL) *xnode {
+	x := &xnode{name: n.Data, attr: map[string]string{}}
+	for _, a := range n.Attr {
+		x.attr[a.Key] = a.Val
+	}
+	if href := x.attr["href"]; href != "" && base != nil {
+		if u, err := base.Parse(href); err == nil {
+			x.attr["href"] = u.String()
+		}
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		switch c.Type {
+		case html.TextNode:
+			x.children = append(x.children, &xnode{text: c.Data})
+		case html.ElementNode:
+			x.children = append(x.children, toXNode(c, base))
+		}
+	}
+	return x
+}
+
+// walk calls fn for every element below n, n included.
+func walk(n *html.Node, fn func(*html.Node)) {
+	if n.Type == html.ElementNode {
+		fn(n)
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		walk(c, fn)
+	}
+}
+
+// findElement returns the first element below n, in document order, that match accepts.
+func findElement(n *html.Node, match func(*html.Node) bool) *html.Node {
+	var found *html.Node
+	walk(n, func(c *html.Node) {
+		if found == nil && match(c) {
+			found = c
+		}
+	})
+	return found
+}
+
+// nodeText joins the text below n.
+func nodeText(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	var b strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		b.WriteString(nodeText(c))
+	}
+	return b.String()
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
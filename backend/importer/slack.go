@@ -0,0 +1,390 @@
+package importer
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"vex-backend/access"
+)
+
+// Metadata keys of the chunks of Slack conversations.
+const (
+	SourceSlack = "slack"
+	// SlackChannelMetadataKey is the channel's name, without '#'; queries can be scoped to it.
+	// Direct messages are named "dm-" followed by their members' names
+	SlackChannelMetadataKey   = "slack_channel"
+	SlackChannelIDMetadataKey = "slack_channel_id"
+	// SlackParticipantsMetadataKey lists the names of the people who wrote in the
+	// conversation, joined with ", "
+	SlackParticipantsMetadataKey = "slack_participants"
+	// SlackThreadMetadataKey is the timestamp identifying a thread, SlackDateMetadataKey the
+	// day (YYYY-MM-DD, UTC) of the messages outside threads
+	SlackThreadMetadataKey = "slack_thread_ts"
+	SlackDateMetadataKey   = "slack_date"
+)
+
+// slackTitleLength bounds the part of a thread's first message used as its title
+const slackTitleLength = 60
+
+// Slack imports the conversations of a Slack workspace export, the zip an admin downloads
+// from the workspace settings. Each thread becomes a document of its own, and the messages
+// of a channel outside threads one document per day. Public channels are shared notes;
+// private channels and direct messages are private.
+type Slack struct {
+	Export *zip.Reader
+	// Channels limits the import to these channel names; empty imports every channel
+	Channels []string
+}
+
+// NewSlack returns the source importing channels from the export read by r, or every channel
+// if channels is empty. It fails if r isn't a zip.
+func NewSlack(r io.ReaderAt, size int64, channels []string) (*Slack, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	for i, c := range channels {
+		channels[i] = NormalizeSlackChannel(c)
+	}
+	return &Slack{Export: zr, Channels: channels}, nil
+}
+
+// NormalizeSlackChannel returns a channel name as SlackChannelMetadataKey holds it.
+func NormalizeSlackChannel(name string) string {
+	return strings.TrimPrefix(strings.ToLower(strings.TrimSpace(name)), "#")
+}
+
+func (s *Slack) Name() string { return SourceSlack }
+
+type slackUser struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	RealName string `json:"real_name"`
+	Profile  struct {
+		DisplayName string `json:"display_name"`
+		RealName    string `json:"real_name"`
+	} `json:"profile"`
+}
+
+func (u slackUser) displayName() string {
+	for _, name := range []string{u.Profile.DisplayName, u.Profile.RealName, u.RealName, u.Name} {
+		if name != "" {
+			return name
+		}
+	}
+	return u.ID
+}
+
+type slackChannel struct {
+	ID      string   `json:"id"`
+	Name    string   `json:"name"`
+	Members []string `json:"members"`
+	// dir is the channel's folder in the export; private is set for private channels and
+	// direct messages
+	dir     string
+	private bool
+}
+
+type slackMessage struct {
+	Subtype     string `json:"subtype"`
+	User        string `json:"user"`
+	Username    string `json:"username"`
+	Text        string `json:"text"`
+	TS          string `json:"ts"`
+	ThreadTS    string `json:"thread_ts"`
+	ReplyCount  int    `json:"reply_count"`
+	UserProfile struct {
+		DisplayName string `json:"display_name"`
+		RealName    string `json:"real_name"`
+	} `json:"user_profile"`
+	Files []struct {
+		Name string `json:"name"`
+	} `json:"files"`
+}
+
+// slackSkippedSubtypes are messages that say nothing, such as members joining a channel
+var slackSkippedSubtypes = map[string]bool{
+	"channel_join": true, "channel_leave": true, "group_join": true, "group_leave": true,
+}
+
+// reSlackRef matches the <target|label> references of Slack's message markup
+var reSlackRef = regexp.MustCompile(`<([^<>|]+)(?:\|([^<>]*))?>`)
+
+// slackExport is an export being converted, with its users and channels by ID.
+type slackExport struct {
+	users    map[string]slackUser
+	channels map[string]slackChannel
+}
+
+// Documents reads the channels of the export and converts their conversations. A channel
+// whose messages can't be read is reported as failed on its own.
+func (s *Slack) Documents(ctx context.Context) ([]Document, error) {
+	files := map[string]*zip.File{}
+	for _, f := range s.Export.File {
+		files[f.Name] = f
+	}
+	ex := &slackExport{users: map[string]slackUser{}, channels: map[string]slackChannel{}}
+
+	var users []slackUser
+	if err := readZipJSON(files["users.json"], &users); err != nil {
+		return nil, fmt.Errorf("users.json: %w", err)
+	}
+	for _, u := range users {
+		ex.users[u.ID] = u
+	}
+	found := false
+	for _, list := range []struct {
+		file    string
+		private bool
+	}{{"channels.json", false}, {"groups.json", true}, {"mpims.json", true}, {"dms.json", true}} {
+		if files[list.file] == nil {
+			continue
+		}
+		found = true
+		var channels []slackChannel
+		if err := readZipJSON(files[list.file], &channels); err != nil {
+			return nil, fmt.Errorf("%s: %w", list.file, err)
+		}
+		for _, c := range channels {
+			c.dir, c.private = c.Name, list.private
+			if list.file == "dms.json" {
+				// direct messages are kept under their ID and have no name
+				c.dir, c.Name = c.ID, "dm-"+ex.memberNames(c.Members)
+			}
+			ex.channels[c.ID] = c
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("not a Slack export: channels.json is missing")
+	}
+
+	ids := make([]string, 0, len(ex.channels))
+	for id := range ex.channels {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ex.channels[ids[i]].Name < ex.channels[ids[j]].Name })
+
+	var docs []Document
+	for _, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		c := ex.channels[id]
+		name := NormalizeSlackChannel(c.Name)
+		if len(s.Channels) > 0 && !slices.Contains(s.Channels, name) {
+			continue
+		}
+		channelDocs, err := ex.conversations(files, c)
+		if err != nil {
+			docs = append(docs, Document{Path: "slack://" + name, Err: err})
+			continue
+		}
+		docs = append(docs, channelDocs...)
+	}
+	return docs, nil
+}
+
+// conversations converts the messages of a channel: one document per thread and one per day
+// for the messages outside threads.
+func (ex *slackExport) conversations(files map[string]*zip.File, c slackChannel) ([]Document, error) {
+	var days []string
+	for name := range files {
+		if path.Dir(name) == c.dir && strings.HasSuffix(name, ".json") {
+			days = append(days, name)
+		}
+	}
+	sort.Strings(days)
+
+	byDay := map[string][]slackMessage{}
+	threads := map[string][]slackMessage{}
+	var dayOrder, threadOrder []string
+	for _, file := range days {
+		var messages []slackMessage
+		if err := readZipJSON(files[file], &messages); err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		day := strings.TrimSuffix(path.Base(file), ".json")
+		for _, m := range messages {
+			if slackSkippedSubtypes[m.Subtype] || (strings.TrimSpace(m.Text) == "" && len(m.Files) == 0) {
+				continue
+			}
+			// thread parents have replies, replies a thread_ts of another message
+			if m.ThreadTS != "" && (m.ThreadTS != m.TS || m.ReplyCount > 0) {
+				if threads[m.ThreadTS] == nil {
+					threadOrder = append(threadOrder, m.ThreadTS)
+				}
+				threads[m.ThreadTS] = append(threads[m.ThreadTS], m)
+				continue
+			}
+			if byDay[day] == nil {
+				dayOrder = append(dayOrder, day)
+			}
+			byDay[day] = append(byDay[day], m)
+		}
+	}
+
+	name := NormalizeSlackChannel(c.Name)
+	var docs []Document
+	for _, day := range dayOrder {
+		doc := ex.document(c, byDay[day], "15:04")
+		doc.Path = "slack://" + name + "/" + day
+		doc.Title = "#" + name + " on " + day
+		doc.Metadata[SlackDateMetadataKey] = day
+		docs = append(docs, doc)
+	}
+	for _, ts := range threadOrder {
+		messages := threads[ts]
+		sort.SliceStable(messages, func(i, j int) bool { return slackTime(messages[i].TS).Before(slackTime(messages[j].TS)) })
+		doc := ex.document(c, messages, "2006-01-02 15:04")
+		doc.Path = "slack://" + name + "/thread/" + ts
+		doc.Title = "#" + name + " thread: " + slackTitle(ex.text(messages[0].Text))
+		doc.Metadata[SlackThreadMetadataKey] = ts
+		docs = append(docs, doc)
+	}
+	return docs, nil
+}
+
+// document writes messages as "**Name** (time): text" lines, with times in UTC in layout.
+func (ex *slackExport) document(c slackChannel, messages []slackMessage, layout string) Document {
+	level := access.Shared
+	if c.private {
+		level = access.Private
+	}
+	var b strings.Builder
+	var participants []string
+	var latest time.Time
+	for _, m := range messages {
+		author := ex.author(m)
+		if !slices.Contains(participants, author) {
+			participants = append(participants, author)
+		}
+		t := slackTime(m.TS)
+		if t.After(latest) {
+			latest = t
+		}
+		text := ex.text(m.Text)
+		if len(m.Files) > 0 {
+			names := make([]string, len(m.Files))
+			for i, f := range m.Files {
+				names[i] = f.Name
+			}
+			text = strings.TrimSpace(text + " (shared " + strings.Join(names, ", ") + ")")
+		}
+		fmt.Fprintf(&b, "**%s** (%s): %s\n\n", author, t.UTC().Format(layout), text)
+	}
+	return Document{
+		Content:  b.String(),
+		Modified: latest,
+		Metadata: map[string]string{
+			SlackChannelMetadataKey:      NormalizeSlackChannel(c.Name),
+			SlackChannelIDMetadataKey:    c.ID,
+			SlackParticipantsMetadataKey: strings.Join(participants, ", "),
+			access.MetadataKey:           level,
+		},
+	}
+}
+
+// author names the writer of m: the user's display name, or the bot's name.
+func (ex *slackExport) author(m slackMessage) string {
+	if u, ok := ex.users[m.User]; ok {
+		return u.displayName()
+	}
+	for _, name := range []string{m.UserProfile.DisplayName, m.UserProfile.RealName, m.Username, m.User} {
+		if name != "" {
+			return name
+		}
+	}
+	return "unknown"
+}
+
+// text converts Slack's markup to markdown: user and channel mentions become names, links
+// markdown links, and the HTML escapes are undone.
+func (ex *slackExport) text(t string) string {
+	t = reSlackRef.ReplaceAllStringFunc(t, func(ref string) string {
+		m := reSlackRef.FindStringSubmatch(ref)
+		target, label := m[1], m[2]
+		switch {
+		case strings.HasPrefix(target, "@"):
+			if u, ok := ex.users[target[1:]]; ok {
+				return "@" + u.displayName()
+			}
+			return "@" + target[1:]
+		case strings.HasPrefix(target, "#"):
+			if label == "" {
+				label = ex.channels[target[1:]].Name
+			}
+			return "#" + label
+		case strings.HasPrefix(target, "!"):
+			if label != "" {
+				return label
+			}
+			return "@" + strings.TrimPrefix(target, "!")
+		case label != "" && label != target:
+			return "[" + label + "](" + target + ")"
+		}
+		return strings.TrimPrefix(target, "mailto:")
+	})
+	return strings.NewReplacer("&lt;", "<", "&gt;", ">", "&amp;", "&").Replace(t)
+}
+
+// memberNames joins the names of the members of a direct message conversation.
+func (ex *slackExport) memberNames(ids []string) string {
+	names := make([]string, len(ids))
+	for i, id := range ids {
+		names[i] = NormalizeSlackChannel(ex.users[id].Name)
+		if names[i] == "" {
+			names[i] = strings.ToLower(id)
+		}
+	}
+	sort.Strings(names)
+	return strings.Join(names, "-")
+}
+
+// slackTitle shortens a message to a title on word boundaries.
+func slackTitle(text string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if len(text) <= slackTitleLength {
+		return text
+	}
+	cut := strings.LastIndex(text[:slackTitleLength], " ")
+	if cut <= 0 {
+		cut = slackTitleLength
+	}
+	return text[:cut] + "…"
+}
+
+// slackTime parses a message timestamp, seconds since the epoch with a fraction that makes
+// it unique within the channel.
+func slackTime(ts string) time.Time {
+	sec, frac, _ := strings.Cut(ts, ".")
+	s, err := strconv.ParseInt(sec, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	frac = (frac + "000000")[:6]
+	us, _ := strconv.ParseInt(frac, 10, 64)
+	return time.Unix(s, us*1000)
+}
+
+// readZipJSON decodes a JSON file of the export into out; a missing file leaves out as it is.
+func readZipJSON(f *zip.File, out any) error {
+	if f == nil {
+		return nil
+	}
+	r, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return json.NewDecoder(r).Decode(out)
+}
@@ -0,0 +1,341 @@
+package importer
+
+import (
+	"encoding/xml"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Confluence stores pages in its "storage format": XHTML with ac: and ri: elements for
+// macros, links and images. storageMarkdown converts the parts that carry text and drops
+// the rest, such as images, emoticons and macros without a body.
+
+// xnode is an element of a parsed storage-format document, or a text node when name is "".
+type xnode struct {
+	name     string
+	attr     map[string]string
+	text     string
+	children []*xnode
+}
+
+// child returns n's first child element named name, or nil.
+func (n *xnode) child(name string) *xnode {
+	for _, c := range n.children {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// param returns the value of the ac:parameter named name of a macro.
+func (n *xnode) param(name string) string {
+	for _, c := range n.children {
+		if c.name == "ac:parameter" && c.attr["ac:name"] == name {
+			return textContent(c)
+		}
+	}
+	return ""
+}
+
+// voidElements are the HTML elements Confluence may leave unclosed. xml.HTMLAutoClose won't
+// do, as it matches local names only and would close ac:link.
+var voidElements = []string{"br", "hr", "img", "col", "wbr"}
+
+// parseStorage parses a storage-format fragment, tolerating HTML entities and unclosed void
+// elements as Confluence writes them.
+func parseStorage(body string) (*xnode, error) {
+	d := xml.NewDecoder(strings.NewReader("<root>" + body + "</root>"))
+	d.Strict = false
+	d.AutoClose = voidElements
+	d.Entity = xml.HTMLEntity
+
+	// doc holds the root element
+	doc := &xnode{}
+	stack := []*xnode{doc}
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse the storage format: %w", err)
+		}
+		top := stack[len(stack)-1]
+		switch t := tok.(type) {
+		case xml.StartElement:
+			n := &xnode{name: qualified(t.Name), attr: map[string]string{}}
+			for _, a := range t.Attr {
+				n.attr[qualified(a.Name)] = a.Value
+			}
+			top.children = append(top.children, n)
+			stack = append(stack, n)
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+			if len(stack) == 1 {
+				return doc.children[0], nil
+			}
+		case xml.CharData:
+			top.children = append(top.children, &xnode{text: string(t)})
+		}
+	}
+}
+
+// qualified names an element or attribute as written, e.g. "ac:structured-macro".
+func qualified(n xml.Name) string {
+	if n.Space == "" {
+		return strings.ToLower(n.Local)
+	}
+	return n.Space + ":" + n.Local
+}
+
+// textContent joins the text below n as it is, for code.
+func textContent(n *xnode) string {
+	if n.name == "" {
+		return n.text
+	}
+	var b strings.Builder
+	for _, c := range n.children {
+		b.WriteString(textContent(c))
+	}
+	return b.String()
+}
+
+var reSpace = regexp.MustCompile(`\s+`)
+
+// storageMarkdown converts a page body in the storage format to markdown.
+func storageMarkdown(body string) (string, error) {
+	root, err := parseStorage(body)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	writeBlocks(&b, root.children)
+	return strings.TrimSpace(b.String()) + "\n", nil
+}
+
+// writeBlocks writes nodes as markdown blocks separated by blank lines. Runs of inline nodes
+// between block elements form a paragraph.
+func writeBlocks(b *strings.Builder, nodes []*xnode) {
+	var para strings.Builder
+	flush := func() {
+		if s := strings.TrimSpace(para.String()); s != "" {
+			b.WriteString(s + "\n\n")
+		}
+		para.Reset()
+	}
+	block := func(s string) {
+		flush()
+		if s = strings.TrimRight(s, "\n "); strings.TrimSpace(s) != "" {
+			b.WriteString(s + "\n\n")
+		}
+	}
+
+	for _, n := range nodes {
+		switch n.name {
+		case "h1", "h2", "h3", "h4", "h5", "h6":
+			level, _ := strconv.Atoi(n.name[1:])
+			block(strings.Repeat("#", level) + " " + strings.TrimSpace(inline(n.children)))
+		case "p", "figcaption", "summary", "dt", "dd":
+			block(strings.TrimSpace(inline(n.children)))
+		case "ul", "ol":
+			block(list(n, ""))
+		case "pre":
+			block("```\n" + strings.Trim(textContent(n), "\n") + "\n```")
+		case "blockquote":
+			block(quote(n.children))
+		case "hr":
+			block("---")
+		case "table":
+			block(table(n))
+		case "ac:structured-macro":
+			block(macro(n))
+		case "div", "section", "article", "main", "header", "figure", "details", "dl", "tbody", "thead", "ac:layout", "ac:layout-section", "ac:layout-cell", "ac:rich-text-body":
+			flush()
+			writeBlocks(b, n.children)
+		default:
+			para.WriteString(inline([]*xnode{n}))
+		}
+	}
+	flush()
+}
+
+// inline converts nodes to a single line of markdown, keeping emphasis, code and links.
+func inline(nodes []*xnode) string {
+	var b strings.Builder
+	for _, n := range nodes {
+		inner := func() string { return inline(n.children) }
+		switch n.name {
+		case "":
+			b.WriteString(reSpace.ReplaceAllString(n.text, " "))
+		case "strong", "b":
+			b.WriteString(wrap("**", inner()))
+		case "em", "i":
+			b.WriteString(wrap("*", inner()))
+		case "s", "del":
+			b.WriteString(wrap("~~", inner()))
+		case "code":
+			b.WriteString(wrap("`", textContent(n)))
+		case "br":
+			b.WriteString("\n")
+		case "a":
+			if text := strings.TrimSpace(inner()); n.attr["href"] != "" && text != "" {
+				b.WriteString("[" + text + "](" + n.attr["href"] + ")")
+			} else {
+				b.WriteString(text)
+			}
+		case "ac:link":
+			// links to other pages name them; the link body, if any, is what the reader sees
+			if body := n.child("ac:link-body"); body != nil {
+				b.WriteString(inline(body.children))
+			} else if body := n.child("ac:plain-text-link-body"); body != nil {
+				b.WriteString(textContent(body))
+			} else if page := n.child("ri:page"); page != nil {
+				b.WriteString(page.attr["ri:content-title"])
+			}
+		case "ac:structured-macro":
+			// inline macros such as status lozenges show their title
+			b.WriteString(n.param("title"))
+		case "ac:image", "ac:emoticon", "ac:parameter", "ac:placeholder":
+		default:
+			b.WriteString(inner())
+		}
+	}
+	return b.String()
+}
+
+// wrap surrounds s with marker, keeping the surrounding whitespace outside, so that
+// "<strong>bold </strong>" becomes "**bold** ".
+func wrap(marker, s string) string {
+	trimmed := strings.TrimSpace(s)
+	if trimmed == "" {
+		return s
+	}
+	lead := s[:len(s)-len(strings.TrimLeft(s, " \n"))]
+	trail := s[len(strings.TrimRight(s, " \n")):]
+	return lead + marker + trimmed + marker + trail
+}
+
+// list converts a ul or ol to markdown list items, nested lists indented below their item.
+func list(n *xnode, indent string) string {
+	var b strings.Builder
+	number := 0
+	for _, li := range n.children {
+		if li.name != "li" {
+			continue
+		}
+		number++
+		marker := "- "
+		if n.name == "ol" {
+			marker = strconv.Itoa(number) + ". "
+		}
+		var text []*xnode
+		var nested []*xnode
+		for _, c := range li.children {
+			switch c.name {
+			case "ul", "ol":
+				nested = append(nested, c)
+			case "p":
+				text = append(text, c.children...)
+				text = append(text, &xnode{text: " "})
+			default:
+				text = append(text, c)
+			}
+		}
+		b.WriteString(indent + marker + strings.TrimSpace(inline(text)) + "\n")
+		for _, c := range nested {
+			b.WriteString(list(c, indent+"  "))
+		}
+	}
+	return b.String()
+}
+
+// quote converts nodes to markdown blocks and quotes every line.
+func quote(nodes []*xnode) string {
+	var inner strings.Builder
+	writeBlocks(&inner, nodes)
+	lines := strings.Split(strings.TrimSpace(inner.String()), "\n")
+	for i, l := range lines {
+		lines[i] = strings.TrimRight("> "+l, " ")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// table converts a table to a markdown table, its first row serving as the header.
+func table(n *xnode) string {
+	var rows [][]string
+	var collect func(n *xnode)
+	collect = func(n *xnode) {
+		for _, c := range n.children {
+			switch c.name {
+			case "tr":
+				var cells []string
+				for _, cell := range c.children {
+					if cell.name == "th" || cell.name == "td" {
+						text := strings.ReplaceAll(strings.TrimSpace(inline(cellContent(cell))), "\n", " ")
+						cells = append(cells, strings.ReplaceAll(text, "|", `\|`))
+					}
+				}
+				rows = append(rows, cells)
+			case "thead", "tbody", "tfoot":
+				collect(c)
+			}
+		}
+	}
+	collect(n)
+	if len(rows) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	for i, cells := range rows {
+		b.WriteString("| " + strings.Join(cells, " | ") + " |\n")
+		if i == 0 {
+			b.WriteString("|" + strings.Repeat(" --- |", len(cells)) + "\n")
+		}
+	}
+	return b.String()
+}
+
+// cellContent flattens the paragraphs of a table cell into inline nodes.
+func cellContent(cell *xnode) []*xnode {
+	var out []*xnode
+	for _, c := range cell.children {
+		if c.name == "p" {
+			out = append(out, c.children...)
+			out = append(out, &xnode{text: " "})
+			continue
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+// macro converts a block macro: code blocks keep their code and language, panels such as
+// info and warning are quoted, and other macros keep their body, if they have one.
+func macro(n *xnode) string {
+	name := n.attr["ac:name"]
+	switch name {
+	case "code", "noformat":
+		body := n.child("ac:plain-text-body")
+		if body == nil {
+			return ""
+		}
+		return "```" + n.param("language") + "\n" + strings.Trim(textContent(body), "\n") + "\n```"
+	}
+
+	body := n.child("ac:rich-text-body")
+	if body == nil {
+		return ""
+	}
+	var b strings.Builder
+	if title := n.param("title"); title != "" {
+		b.WriteString("**" + title + "**\n\n")
+	}
+	switch name {
+	case "info", "note", "tip", "warning", "panel":
+		b.WriteString(quote(body.children))
+	default:
+		writeBlocks(&b, body.children)
+	}
+	return b.String()
+}
@@ -0,0 +1,140 @@
+package importer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"vex-backend/httpclient"
+)
+
+// Metadata keys of the chunks of web pages.
+const (
+	SourceWeb = "web"
+	// SourceURLMetadataKey is the address the page was fetched from, after redirects
+	SourceURLMetadataKey = "source_url"
+	// SiteMetadataKey is the host of that address
+	SiteMetadataKey = "site"
+	// AuthorMetadataKey and PublishedMetadataKey are the page's byline and publication date,
+	// when it declares them
+	AuthorMetadataKey    = "author"
+	PublishedMetadataKey = "published_time"
+	// FetchedMetadataKey is when the page was fetched (RFC 3339)
+	FetchedMetadataKey = "fetched_at"
+)
+
+var (
+	// ErrFetch is returned when a web page can't be fetched, e.g. because the server answers
+	// with an error or with content that isn't a web page
+	ErrFetch = errors.New("the page could not be fetched")
+	// ErrNoContent is returned when a fetched page has no readable text
+	ErrNoContent = errors.New("the page has no readable content")
+)
+
+// Web ingests a single web page: the article it shows, extracted from the navigation,
+// sidebars and other clutter around it. Plain text and markdown are taken as they are.
+type Web struct {
+	URL string
+	// MaxSize in bytes rejects larger pages; 0 disables the check
+	MaxSize int64
+	// Client sends the request; nil uses httpclient.Default
+	Client httpclient.Doer
+	// Metadata and Tags are added to those of the page, e.g. to record the feed it came from
+	Metadata map[string]string
+	Tags     []string
+}
+
+func (wb *Web) Name() string { return SourceWeb }
+
+// Documents fetches the page. Failing to fetch it or finding nothing to read fails the
+// whole import, with ErrFetch or ErrNoContent.
+func (wb *Web) Documents(ctx context.Context) ([]Document, error) {
+	u, err := url.Parse(wb.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("%w: %q is not an http(s) URL", ErrFetch, wb.URL)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/html,application/xhtml+xml,text/plain;q=0.9,text/markdown;q=0.9")
+	resp, err := httpclient.OrDefault(wb.Client).Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFetch, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %s answered %s", ErrFetch, u.Host, resp.Status)
+	}
+
+	body := io.Reader(resp.Body)
+	if wb.MaxSize > 0 {
+		body = io.LimitReader(resp.Body, wb.MaxSize+1)
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFetch, err)
+	}
+	if wb.MaxSize > 0 && int64(len(data)) > wb.MaxSize {
+		return nil, fmt.Errorf("%w: the page is larger than the maximum of %d bytes", ErrFetch, wb.MaxSize)
+	}
+
+	// identify the page by where it was found, without the fragment
+	final := *u
+	if resp.Request != nil && resp.Request.URL != nil {
+		final = *resp.Request.URL
+	}
+	final.Fragment = ""
+	doc := Document{
+		Path: final.String(),
+		Metadata: map[string]string{
+			SourceURLMetadataKey: final.String(),
+			SiteMetadataKey:      final.Hostname(),
+			FetchedMetadataKey:   time.Now().UTC().Format(time.RFC3339),
+		},
+		Tags: wb.Tags,
+	}
+	for k, v := range wb.Metadata {
+		doc.Metadata[k] = v
+	}
+	if t, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		doc.Modified = t
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	switch mediaType {
+	case "text/html", "application/xhtml+xml", "":
+		a, err := extractArticle(data, &final)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrFetch, err)
+		}
+		doc.Title, doc.Content = a.Title, a.Content
+		if a.Author != "" {
+			doc.Metadata[AuthorMetadataKey] = a.Author
+		}
+		if !a.Published.IsZero() {
+			doc.Metadata[PublishedMetadataKey] = a.Published.UTC().Format(time.RFC3339)
+		}
+		if doc.Modified.IsZero() {
+			doc.Modified = a.Modified
+		}
+	case "text/plain", "text/markdown", "text/x-markdown":
+		doc.Content = string(data)
+	default:
+		return nil, fmt.Errorf("%w: unsupported content type %q", ErrFetch, mediaType)
+	}
+
+	if strings.TrimSpace(doc.Content) == "" {
+		return nil, fmt.Errorf("%s: %w", doc.Path, ErrNoContent)
+	}
+	if doc.Title == "" {
+		doc.Title = final.Hostname() + final.Path
+	}
+	return []Document{doc}, nil
+}
@@ -0,0 +1,312 @@
+// Package indexer embeds the files of the notes repository into the vector store. It is
+// shared by the webhook, /resync and the command line, so all of them apply the same ignore
+// rules, guards and per-file manifest bookkeeping.
+package indexer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"vex-backend/breaker"
+	"vex-backend/config"
+	"vex-backend/guard"
+	"vex-backend/httpclient"
+	"vex-backend/ignore"
+	"vex-backend/lfs"
+	"vex-backend/manifest"
+	"vex-backend/ocr"
+	"vex-backend/transcribe"
+	vectormgr "vex-backend/vector/manager"
+)
+
+// Policy decides which files an indexing run embeds: ignore rules select the files,
+// and the guard checks each note before it is embedded. OCR, when enabled, also embeds the
+// text of the images each note references, and Audio the transcripts of recordings. Git LFS
+// pointers are skipped, or replaced by their content when LFS is set.
+type Policy struct {
+	Rules *ignore.Rules
+	Guard guard.Guard
+	// OCR is nil when OCR_PROVIDER is off
+	OCR *ocr.Ingester
+	// Audio is nil when TRANSCRIBE is off
+	Audio *transcribe.Ingester
+	// LFS is nil when LFS_FETCH is off
+	LFS *lfs.Fetcher
+	// Routes send files to other collections than the run's (PATH_COLLECTIONS)
+	Routes []Route
+}
+
+// LoadPolicy reads the ignore rules of the repository at root and the guard, OCR,
+// transcription, LFS and path-to-collection settings from cfg. client sends OCR,
+// transcription and LFS requests.
+func LoadPolicy(cfg *config.EnvConfig, client httpclient.Doer, root string) (Policy, error) {
+	rules, err := ignore.Load(cfg, root)
+	if err != nil {
+		return Policy{}, err
+	}
+	routes, err := loadRoutes(cfg)
+	if err != nil {
+		return Policy{}, err
+	}
+	policy := Policy{Rules: rules, Guard: guard.New(cfg), LFS: lfs.New(cfg, client, root), Routes: routes}
+	if ex := ocr.New(cfg, client); ex != nil {
+		policy.OCR = &ocr.Ingester{Extractor: ex, Root: root, MaxFileSize: cfg.MaxFileSize, Ignored: rules.Ignored}
+		policy.OCR.Prepare = func(ctx context.Context, path string) (string, error) {
+			return policy.resolveLFS(ctx, path, cfg.MaxFileSize)
+		}
+	}
+	if tr := transcribe.New(cfg, client); tr != nil {
+		policy.Audio = &transcribe.Ingester{Transcriber: tr, MaxFileSize: cfg.TranscribeMaxFileSize}
+	}
+	return policy, nil
+}
+
+// indexerFor returns the function that embeds files of rel's type, or nil for types that
+// aren't indexed: markdown notes always, audio files when transcription is enabled.
+func (p Policy) indexerFor(rel string) func(context.Context, vectormgr.Manager, Policy, string, string) (string, error) {
+	switch {
+	case strings.ToLower(filepath.Ext(rel)) == ".md":
+		return indexMarkdownFile
+	case p.Audio != nil && transcribe.IsAudio(rel):
+		return indexAudioFile
+	}
+	return nil
+}
+
+// Result collects the per-file outcome of an indexing run.
+type Result struct {
+	Processed []string
+	Skipped   []string
+	// Failed holds the error of every file that could not be indexed
+	Failed  map[string]error
+	Pending []string
+	// Deleted are the files no longer in the repository, whose vectors were dropped
+	Deleted []string
+}
+
+// Run embeds the given repo-relative files one by one, recording each outcome in
+// the manifest. Files excluded by the policy's rules are skipped and any vectors they still
+// have are removed, as are those of files deleted from the repository. Files the policy
+// routes to another collection are indexed into that one instead of m, while the manifest
+// is shared. A failing file does not stop the run; the remaining files are still
+// processed. The only exception is an open circuit breaker: every further call would fail
+// anyway, so the run stops and the untouched files are left pending for a later resync.
+func Run(ctx context.Context, m vectormgr.Manager, man *manifest.Manifest, policy Policy, basePath string, files []string) (Result, error) {
+	res := Result{
+		Processed: make([]string, 0, len(files)),
+		Skipped:   make([]string, 0, len(files)),
+		Failed:    make(map[string]error),
+		Pending:   []string{},
+		Deleted:   []string{},
+	}
+
+	// Mark every indexable file pending up front so an interrupted run can be resumed.
+	var queued []string
+	targets := newTargets(m, policy.Routes)
+	for _, rel := range files {
+		// only process markdown files, and recordings when they are transcribed
+		if policy.indexerFor(rel) == nil {
+			res.Skipped = append(res.Skipped, rel)
+			log.Printf("[Indexer] skipping unsupported file: %s", rel)
+			continue
+		}
+		m, err := targets.forFile(ctx, rel)
+		if err != nil {
+			log.Printf("[Indexer] failed to index %s: %v", rel, err)
+			res.Failed[rel] = err
+			if mErr := man.MarkFailed(rel, err); mErr != nil {
+				log.Printf("[Indexer] warning: failed to update manifest for %s: %v", rel, mErr)
+			}
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(basePath, rel)); errors.Is(err, fs.ErrNotExist) {
+			res.Deleted = append(res.Deleted, rel)
+			log.Printf("[Indexer] file was deleted: %s", rel)
+			dropVectors(ctx, m, filepath.Join(basePath, rel), "file was deleted")
+			if err := man.MarkDeleted(rel); err != nil {
+				log.Printf("[Indexer] warning: failed to update manifest for %s: %v", rel, err)
+			}
+			continue
+		}
+		if policy.Rules.Ignored(rel) {
+			res.Skipped = append(res.Skipped, rel)
+			log.Printf("[Indexer] skipping ignored file: %s", rel)
+			dropVectors(ctx, m, filepath.Join(basePath, rel), "file is ignored")
+			if err := man.MarkSkipped(rel, "excluded by .vexignore or INDEX_INCLUDE/INDEX_EXCLUDE"); err != nil {
+				log.Printf("[Indexer] warning: failed to update manifest for %s: %v", rel, err)
+			}
+			continue
+		}
+		if reason := policy.Guard.CheckPath(rel); reason != "" {
+			res.Skipped = append(res.Skipped, rel)
+			log.Printf("[Indexer] skipping %s: %s", rel, reason)
+			dropVectors(ctx, m, filepath.Join(basePath, rel), reason)
+			if err := man.MarkSkipped(rel, reason); err != nil {
+				log.Printf("[Indexer] warning: failed to update manifest for %s: %v", rel, err)
+			}
+			continue
+		}
+		if err := man.MarkPending(rel); err != nil {
+			log.Printf("[Indexer] warning: failed to update manifest for %s: %v", rel, err)
+		}
+		queued = append(queued, rel)
+	}
+
+	for i, rel := range queued {
+		// opened while queueing
+		m, _ := targets.forFile(ctx, rel)
+		reason, err := policy.indexerFor(rel)(ctx, m, policy, basePath, rel)
+		switch {
+		case err != nil:
+			log.Printf("[Indexer] failed to index %s: %v", rel, err)
+			res.Failed[rel] = err
+			if mErr := man.MarkFailed(rel, err); mErr != nil {
+				log.Printf("[Indexer] warning: failed to update manifest for %s: %v", rel, mErr)
+			}
+			if errors.Is(err, breaker.ErrOpen) {
+				res.Pending = append(res.Pending, queued[i+1:]...)
+				return res, err
+			}
+		case reason != "":
+			res.Skipped = append(res.Skipped, rel)
+			if mErr := man.MarkSkipped(rel, reason); mErr != nil {
+				log.Printf("[Indexer] warning: failed to update manifest for %s: %v", rel, mErr)
+			}
+		default:
+			res.Processed = append(res.Processed, rel)
+			if mErr := man.MarkIndexed(rel); mErr != nil {
+				log.Printf("[Indexer] warning: failed to update manifest for %s: %v", rel, mErr)
+			}
+		}
+	}
+
+	return res, nil
+}
+
+// dropVectors removes the vectors of a file that is no longer embedded, e.g. because it is
+// now ignored, along with the OCR chunks of its images, logging why.
+func dropVectors(ctx context.Context, m vectormgr.Manager, fullpath, why string) {
+	abs, err := filepath.Abs(fullpath)
+	if err != nil {
+		return
+	}
+	if err := ocr.Drop(ctx, m, abs); err != nil {
+		log.Printf("[Indexer] warning: failed to delete image text of %s: %v", abs, err)
+	}
+	// most skipped files were never indexed, so only delete when there is something to delete
+	chunks, err := m.GetChunksByFile(ctx, abs)
+	if err != nil || len(chunks) == 0 {
+		return
+	}
+	if err := m.DeleteVectorsWithMetaData(ctx, "filepath", abs); err != nil {
+		log.Printf("[Indexer] warning: failed to delete existing vectors for %s: %v", abs, err)
+		return
+	}
+	log.Printf("[Indexer] deleted existing vectors for %s (%s)", abs, why)
+}
+
+// indexMarkdownFile replaces the stored vectors of a single markdown file with a fresh
+// embedding. It returns the guard's reason when the file was intentionally not embedded, in
+// which case its stale vectors are removed. Images the note references are run through OCR
+// when enabled; their failures are logged but don't fail the note.
+func indexMarkdownFile(ctx context.Context, m vectormgr.Manager, policy Policy, basePath, rel string) (string, error) {
+	fullpath := filepath.Join(basePath, rel)
+	log.Printf("[Indexer] processing markdown file: %s", fullpath)
+
+	reason, err := policy.resolveLFS(ctx, fullpath, policy.Guard.MaxFileSize)
+	if err != nil {
+		return "", err
+	}
+	// oversized files are rejected before they are read
+	info, err := os.Stat(fullpath)
+	if err != nil {
+		return "", fmt.Errorf("failed to stat %s: %w", fullpath, err)
+	}
+	var content string
+	if reason == "" {
+		reason = policy.Guard.CheckSize(info.Size())
+	}
+	if reason == "" {
+		data, err := os.ReadFile(fullpath)
+		if err != nil {
+			return "", fmt.Errorf("failed to read %s: %w", fullpath, err)
+		}
+		content = string(data)
+		reason = policy.Guard.Check(rel, content)
+	}
+	if reason != "" {
+		log.Printf("[Indexer] skipping %s: %s", rel, reason)
+		dropVectors(ctx, m, fullpath, reason)
+		return reason, nil
+	}
+
+	// swap the file's vectors for a fresh embedding; on failure the old ones stay searchable
+	if err := m.ReplaceFileVectorsInDB(ctx, fullpath); err != nil {
+		return "", err
+	}
+	log.Printf("[Indexer] embedded %s", fullpath)
+
+	if policy.OCR != nil {
+		n, err := policy.OCR.Sync(ctx, m, fullpath, content)
+		if err != nil {
+			log.Printf("[Indexer] warning: OCR of images in %s: %v", rel, err)
+		}
+		if n > 0 {
+			log.Printf("[Indexer] extracted text from %d images referenced by %s", n, rel)
+		}
+	}
+
+	return "", nil
+}
+
+// resolveLFS checks whether the file at path is a Git LFS pointer and, if so, replaces it by
+// the content it points to when LFS_FETCH is on and the content is at most maxSize bytes (0
+// for any size). It returns why a pointer was left in place, or "" when path holds content.
+func (p Policy) resolveLFS(ctx context.Context, path string, maxSize int64) (string, error) {
+	ptr, ok, err := lfs.ReadPointer(path)
+	if err != nil || !ok {
+		return "", err
+	}
+	switch {
+	case p.LFS == nil:
+		return fmt.Sprintf("file is a pointer to %s; set LFS_FETCH=true to index it", ptr), nil
+	case maxSize > 0 && ptr.Size > maxSize:
+		return fmt.Sprintf("file is a pointer to %s, more than the maximum of %d", ptr, maxSize), nil
+	}
+	if err := p.LFS.Smudge(ctx, path, ptr); err != nil {
+		return "", err
+	}
+	log.Printf("[Indexer] fetched %s for %s", ptr, path)
+	return "", nil
+}
+
+// indexAudioFile replaces the stored vectors of a recording with its transcript. It
+// returns why when the recording is too large to upload, an LFS pointer that isn't fetched
+// or contains no speech, in which case its stale vectors are removed.
+func indexAudioFile(ctx context.Context, m vectormgr.Manager, policy Policy, basePath, rel string) (string, error) {
+	fullpath := filepath.Join(basePath, rel)
+	log.Printf("[Indexer] transcribing audio file: %s", fullpath)
+
+	reason, err := policy.resolveLFS(ctx, fullpath, policy.Audio.MaxFileSize)
+	if err != nil {
+		return "", err
+	}
+	if reason == "" {
+		if reason, err = policy.Audio.Index(ctx, m, fullpath); err != nil {
+			return "", err
+		}
+	}
+	if reason != "" {
+		log.Printf("[Indexer] skipping %s: %s", rel, reason)
+		dropVectors(ctx, m, fullpath, reason)
+		return reason, nil
+	}
+	log.Printf("[Indexer] embedded transcript of %s", fullpath)
+	return "", nil
+}
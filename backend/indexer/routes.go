@@ -0,0 +1,80 @@
+package indexer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+
+	"vex-backend/config"
+	"vex-backend/vector"
+	vectormgr "vex-backend/vector/manager"
+)
+
+// Route sends the files under a subpath of the repository to a collection of their own, so a
+// single repository can feed separately scoped knowledge bases.
+type Route struct {
+	Collection string
+	// Match reports whether a repo-relative, slash-separated path belongs to the collection
+	Match func(rel string) bool
+}
+
+// loadRoutes compiles the PATH_COLLECTIONS entries of cfg.
+func loadRoutes(cfg *config.EnvConfig) ([]Route, error) {
+	var routes []Route
+	for _, pc := range cfg.PathCollectionList() {
+		match, err := vectormgr.PathFilter{Glob: pc.Glob}.Matcher()
+		if err != nil {
+			return nil, fmt.Errorf("PATH_COLLECTIONS: %w", err)
+		}
+		routes = append(routes, Route{Collection: pc.Collection, Match: match})
+	}
+	return routes, nil
+}
+
+// targets hands out the collection each file of a run is indexed into: the one of the first
+// route matching it, or the run's own. Routed collections are opened once per run and created
+// when they don't exist yet.
+type targets struct {
+	m      vectormgr.Manager
+	routes []Route
+	open   map[string]vectormgr.Manager
+}
+
+func newTargets(m vectormgr.Manager, routes []Route) *targets {
+	return &targets{m: m, routes: routes, open: map[string]vectormgr.Manager{}}
+}
+
+// forFile returns the Manager of the collection rel is indexed into.
+func (t *targets) forFile(ctx context.Context, rel string) (vectormgr.Manager, error) {
+	name := ""
+	for _, r := range t.routes {
+		if r.Match(filepath.ToSlash(rel)) {
+			name = r.Collection
+			break
+		}
+	}
+	if name == "" || name == t.m.CollectionName() {
+		return t.m, nil
+	}
+	if m, ok := t.open[name]; ok {
+		return m, nil
+	}
+
+	m, err := t.m.Collection(name)
+	if errors.Is(err, vector.ErrNotFound) {
+		err = t.m.CreateCollection(ctx, name)
+		if err == nil {
+			log.Printf("[Indexer] created collection %q for PATH_COLLECTIONS", name)
+		}
+		if err == nil || errors.Is(err, vector.ErrCollectionExists) {
+			m, err = t.m.Collection(name)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open collection %q: %w", name, err)
+	}
+	t.open[name] = m
+	return m, nil
+}
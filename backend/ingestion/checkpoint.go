@@ -0,0 +1,105 @@
+package ingestion
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Checkpoints remember, per sync scope, the version of every item synced, in a JSON file
+// rewritten after each item. A scope is a connector with its params, so syncs of different
+// Confluence spaces don't see each other's pages as deleted.
+type Checkpoints struct {
+	path string
+
+	mu     sync.Mutex
+	scopes map[string]map[string]string
+}
+
+// LoadCheckpoints reads the checkpoints kept at path; a missing file means nothing was synced.
+func LoadCheckpoints(path string) (*Checkpoints, error) {
+	cp := &Checkpoints{path: path, scopes: map[string]map[string]string{}}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cp, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &cp.scopes); err != nil {
+		return nil, fmt.Errorf("failed to read the ingestion checkpoints %s: %w", path, err)
+	}
+	return cp, nil
+}
+
+// Version returns the version item was last synced at in scope, "" if it never was.
+func (cp *Checkpoints) Version(scope, item string) string {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	return cp.scopes[scope][item]
+}
+
+// Items returns the IDs of the items synced in scope.
+func (cp *Checkpoints) Items(scope string) []string {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	ids := make([]string, 0, len(cp.scopes[scope]))
+	for id := range cp.scopes[scope] {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// Scopes returns the number of items synced per scope.
+func (cp *Checkpoints) Scopes() map[string]int {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	out := make(map[string]int, len(cp.scopes))
+	for scope, items := range cp.scopes {
+		out[scope] = len(items)
+	}
+	return out
+}
+
+// Set records that item was synced at version, and saves the checkpoints.
+func (cp *Checkpoints) Set(scope, item, version string) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	if cp.scopes[scope] == nil {
+		cp.scopes[scope] = map[string]string{}
+	}
+	cp.scopes[scope][item] = version
+	cp.saveLocked()
+}
+
+// Remove forgets item, and saves the checkpoints.
+func (cp *Checkpoints) Remove(scope, item string) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	delete(cp.scopes[scope], item)
+	if len(cp.scopes[scope]) == 0 {
+		delete(cp.scopes, scope)
+	}
+	cp.saveLocked()
+}
+
+// saveLocked writes the checkpoint file atomically, logging failures. Callers must hold cp.mu.
+func (cp *Checkpoints) saveLocked() {
+	data, err := json.MarshalIndent(cp.scopes, "", "  ")
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(cp.path), 0o755)
+	}
+	if err == nil {
+		tmp := cp.path + ".tmp"
+		if err = os.WriteFile(tmp, data, 0o644); err == nil {
+			err = os.Rename(tmp, cp.path)
+		}
+	}
+	if err != nil {
+		log.Printf("[Ingestion] failed to save the checkpoints: %v", err)
+	}
+}
@@ -0,0 +1,106 @@
+package ingestion
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"vex-backend/config"
+	"vex-backend/httpclient"
+	"vex-backend/importer"
+)
+
+// Factory builds a Source from the configuration and the JSON params of a sync request,
+// which it decodes itself; params may be empty.
+type Factory func(cfg *config.EnvConfig, client httpclient.Doer, params json.RawMessage) (Source, error)
+
+var (
+	sourcesMu sync.RWMutex
+	sources   = map[string]Factory{}
+)
+
+// the built-in connectors, adapted from the importers
+func init() {
+	Register(importer.SourceNotion, func(cfg *config.EnvConfig, client httpclient.Doer, params json.RawMessage) (Source, error) {
+		var p struct {
+			DatabaseIDs []string `json:"database_ids"`
+			PageIDs     []string `json:"page_ids"`
+		}
+		if err := decodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		if len(p.DatabaseIDs) == 0 && len(p.PageIDs) == 0 {
+			return nil, fmt.Errorf("at least one of 'database_ids' and 'page_ids' is required")
+		}
+		src, err := importer.NewNotion(cfg, client, p.DatabaseIDs, p.PageIDs)
+		if err != nil {
+			return nil, err
+		}
+		return &Batch{Importer: src}, nil
+	})
+	Register(importer.SourceConfluence, func(cfg *config.EnvConfig, client httpclient.Doer, params json.RawMessage) (Source, error) {
+		var p struct {
+			SpaceKeys []string `json:"space_keys"`
+		}
+		if err := decodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		if len(p.SpaceKeys) == 0 {
+			return nil, fmt.Errorf("'space_keys' is required")
+		}
+		src, err := importer.NewConfluence(cfg, client, p.SpaceKeys)
+		if err != nil {
+			return nil, err
+		}
+		return &Batch{Importer: src}, nil
+	})
+}
+
+// Register makes a connector available under name. It is meant to be called from an init
+// function and panics if name is already registered.
+func Register(name string, f Factory) {
+	sourcesMu.Lock()
+	defer sourcesMu.Unlock()
+
+	if _, ok := sources[name]; ok {
+		panic(fmt.Sprintf("ingestion source %q registered twice", name))
+	}
+	sources[name] = f
+}
+
+// Sources returns the names of the registered connectors, sorted.
+func Sources() []string {
+	sourcesMu.RLock()
+	defer sourcesMu.RUnlock()
+
+	names := make([]string, 0, len(sources))
+	for name := range sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New builds the Source of the connector registered as name.
+func New(name string, cfg *config.EnvConfig, client httpclient.Doer, params json.RawMessage) (Source, error) {
+	sourcesMu.RLock()
+	f, ok := sources[name]
+	sourcesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w %q, expected one of %s", ErrUnknownSource, name, strings.Join(Sources(), ", "))
+	}
+	return f(cfg, client, params)
+}
+
+// decodeParams decodes the params of a sync request into p, if there are any.
+func decodeParams(params json.RawMessage, p any) error {
+	if len(params) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(params, p); err != nil {
+		return fmt.Errorf("invalid params: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,209 @@
+package ingestion
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"vex-backend/breaker"
+	"vex-backend/config"
+	"vex-backend/importer"
+	"vex-backend/usage"
+	"vex-backend/vector/embed"
+	vectormgr "vex-backend/vector/manager"
+)
+
+// ErrUnknownSource is returned by New for names no connector is registered under
+var ErrUnknownSource = errors.New("unknown ingestion source")
+
+// Result collects the per-item outcome of a sync, by item ID.
+type Result struct {
+	Imported []string
+	// Unchanged items had the version of the checkpoint and weren't fetched
+	Unchanged []string
+	// Deleted items were gone from the listing; their chunks were deleted
+	Deleted []string
+	Failed  map[string]error
+}
+
+// Runner syncs sources into a vector store, sharing checkpoints and, per connector, a rate
+// limit of INGEST_RPM fetches a minute.
+type Runner struct {
+	cfg config.Source
+	m   vectormgr.Manager
+	cp  *Checkpoints
+
+	mu        sync.Mutex
+	limits    map[string]*embed.Throttle
+	following map[string]context.CancelFunc
+}
+
+// NewRunner returns a Runner storing into m and checkpointing to cp.
+func NewRunner(cfg config.Source, m vectormgr.Manager, cp *Checkpoints) *Runner {
+	return &Runner{cfg: cfg, m: m, cp: cp, limits: map[string]*embed.Throttle{}, following: map[string]context.CancelFunc{}}
+}
+
+// Checkpoints returns the checkpoints of the runner's syncs.
+func (r *Runner) Checkpoints() *Checkpoints { return r.cp }
+
+// Scope names the checkpoint scope of the connector name synced with params: the name alone
+// without params, followed by the compacted params otherwise.
+func Scope(name string, params json.RawMessage) string {
+	var b bytes.Buffer
+	if len(params) == 0 || json.Compact(&b, params) != nil || b.String() == "{}" || b.String() == "null" {
+		return name
+	}
+	return name + " " + b.String()
+}
+
+// Sync brings m in line with src: items whose version changed since the last sync of scope
+// are fetched and embedded, items gone from the listing are deleted. Like an import, failing
+// items don't stop the sync unless the embedding circuit breaker is open.
+func (r *Runner) Sync(ctx context.Context, scope string, src Source) (Result, error) {
+	res := Result{Imported: []string{}, Unchanged: []string{}, Deleted: []string{}, Failed: map[string]error{}}
+	items, err := src.List(ctx)
+	if err != nil {
+		return res, fmt.Errorf("failed to list %s items: %w", src.Name(), err)
+	}
+	log.Printf("[Ingestion] %s lists %d items", scope, len(items))
+
+	listed := make(map[string]bool, len(items))
+	for _, item := range items {
+		listed[item.ID] = true
+		if item.Version != "" && r.cp.Version(scope, item.ID) == item.Version {
+			res.Unchanged = append(res.Unchanged, item.ID)
+			continue
+		}
+		if err := r.syncItem(ctx, scope, src, item); err != nil {
+			log.Printf("[Ingestion] failed to sync %s: %v", item.ID, err)
+			res.Failed[item.ID] = err
+			if errors.Is(err, breaker.ErrOpen) || ctx.Err() != nil {
+				return res, err
+			}
+			continue
+		}
+		res.Imported = append(res.Imported, item.ID)
+	}
+
+	for _, id := range r.cp.Items(scope) {
+		if listed[id] {
+			continue
+		}
+		if err := r.m.DeleteVectorsWithMetaData(ctx, "filepath", id); err != nil {
+			log.Printf("[Ingestion] failed to delete %s: %v", id, err)
+			res.Failed[id] = err
+			continue
+		}
+		r.cp.Remove(scope, id)
+		res.Deleted = append(res.Deleted, id)
+	}
+	sort.Strings(res.Deleted)
+	return res, nil
+}
+
+// syncItem fetches and embeds one item, checkpointing it once stored.
+func (r *Runner) syncItem(ctx context.Context, scope string, src Source, item Item) error {
+	if err := r.limit(src.Name()).Wait(ctx, 0); err != nil {
+		return err
+	}
+	doc, err := src.Fetch(ctx, item)
+	if err == nil {
+		err = doc.Err
+	}
+	if err != nil {
+		return err
+	}
+	doc.Path = item.ID
+	if _, err := importer.ImportDocument(ctx, r.m, src.Name(), doc); err != nil {
+		return err
+	}
+	r.cp.Set(scope, item.ID, item.Version)
+	return nil
+}
+
+// limit returns the rate limit shared by every sync of the connector name.
+func (r *Runner) limit(name string) *embed.Throttle {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t, ok := r.limits[name]
+	if !ok {
+		t = embed.NewThrottle(func() embed.Budget { return embed.Budget{RequestsPerMinute: r.cfg().IngestRPM} })
+		r.limits[name] = t
+	}
+	return t
+}
+
+// Follow keeps scope in sync in the background until Unfollow: it syncs, then watches src
+// for changes, syncing again every INGEST_INTERVAL when src can't watch or its watch ends.
+// Following a scope again replaces the earlier follower. Followers don't survive restarts.
+func (r *Runner) Follow(scope string, src Source) {
+	ctx, cancel := context.WithCancel(usage.WithSource(context.Background(), "ingestion"))
+	r.mu.Lock()
+	if stop, ok := r.following[scope]; ok {
+		stop()
+	}
+	r.following[scope] = cancel
+	r.mu.Unlock()
+
+	go func() {
+		for {
+			res, err := r.Sync(ctx, scope, src)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				log.Printf("[Ingestion] sync of %s failed: %v", scope, err)
+			} else if len(res.Imported)+len(res.Deleted)+len(res.Failed) > 0 {
+				log.Printf("[Ingestion] %s: imported=%d deleted=%d failed=%d", scope, len(res.Imported), len(res.Deleted), len(res.Failed))
+			}
+
+			err = src.Watch(ctx, func(item Item) {
+				if err := r.syncItem(ctx, scope, src, item); err != nil && ctx.Err() == nil {
+					log.Printf("[Ingestion] failed to sync %s: %v", item.ID, err)
+				}
+			})
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil && !errors.Is(err, ErrWatchUnsupported) {
+				log.Printf("[Ingestion] watching %s failed: %v", scope, err)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(r.cfg().IngestInterval):
+			}
+		}
+	}()
+}
+
+// Unfollow stops the follower of scope, reporting whether there was one.
+func (r *Runner) Unfollow(scope string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stop, ok := r.following[scope]
+	if ok {
+		stop()
+		delete(r.following, scope)
+	}
+	return ok
+}
+
+// Following returns the scopes being followed, sorted.
+func (r *Runner) Following() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	scopes := make([]string, 0, len(r.following))
+	for scope := range r.following {
+		scopes = append(scopes, scope)
+	}
+	sort.Strings(scopes)
+	return scopes
+}
@@ -0,0 +1,92 @@
+// Package ingestion runs connectors that bring outside documents into the vector store. A
+// connector only implements Source: listing what the service holds, fetching one item and,
+// if the service can notify changes, watching for them. The Runner does the rest the same
+// way for every connector: it skips items unchanged since the last sync, paces fetches with
+// a rate limit shared by every sync of a source, embeds through the importer, deletes the
+// chunks of items gone from the service and checkpoints its progress, so an interrupted sync
+// resumes where it stopped.
+package ingestion
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"vex-backend/importer"
+)
+
+// ErrWatchUnsupported is returned by Watch when the service can't notify changes; such
+// sources are polled instead.
+var ErrWatchUnsupported = errors.New("the source can't watch for changes")
+
+// Item is an entry of a source's listing.
+type Item struct {
+	// ID identifies the item across syncs and is stored as its chunks' filepath, e.g.
+	// confluence://<page id>
+	ID string `json:"id"`
+	// Version changes whenever the item does, e.g. its modification time or ETag; items
+	// whose version matches the checkpoint aren't fetched again. Empty always fetches
+	Version string `json:"version"`
+}
+
+// Source is a connector to an outside service.
+type Source interface {
+	// Name is the source's name, stored on chunks as importer.SourceMetadataKey
+	Name() string
+	// List returns every item the source is configured for. An error fails the sync, and
+	// nothing is deleted.
+	List(ctx context.Context) ([]Item, error)
+	// Fetch returns the document of an item, with item.ID as its Path
+	Fetch(ctx context.Context, item Item) (importer.Document, error)
+	// Watch blocks until ctx is done, calling changed for every item the service reports as
+	// added or modified. Sources that can't watch return ErrWatchUnsupported right away.
+	Watch(ctx context.Context, changed func(Item)) error
+}
+
+// Polling is embedded by sources that can't watch for changes.
+type Polling struct{}
+
+func (Polling) Watch(context.Context, func(Item)) error { return ErrWatchUnsupported }
+
+// Batch adapts an importer.Source, which fetches all of its documents at once, to a Source:
+// List fetches them and Fetch hands them out. Versions are the documents' modification times.
+type Batch struct {
+	Polling
+	Importer importer.Source
+
+	mu   sync.Mutex
+	docs map[string]importer.Document
+}
+
+func (b *Batch) Name() string { return b.Importer.Name() }
+
+func (b *Batch) List(ctx context.Context) ([]Item, error) {
+	docs, err := b.Importer.Documents(ctx)
+	if err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.docs = make(map[string]importer.Document, len(docs))
+	items := make([]Item, 0, len(docs))
+	for _, doc := range docs {
+		b.docs[doc.Path] = doc
+		item := Item{ID: doc.Path}
+		if !doc.Modified.IsZero() {
+			item.Version = doc.Modified.UTC().Format(time.RFC3339Nano)
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+func (b *Batch) Fetch(_ context.Context, item Item) (importer.Document, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	doc, ok := b.docs[item.ID]
+	if !ok {
+		return importer.Document{}, errors.New("not in the last listing")
+	}
+	return doc, nil
+}
@@ -0,0 +1,104 @@
+// Package lang detects the language of a chunk of text from its most common function words,
+// so notes in several languages can be told apart without calling an external service.
+package lang
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// MetadataKey records the detected language of a chunk as an ISO 639-1 code
+const MetadataKey = "language"
+
+// Unknown is recorded for chunks whose language could not be determined (ISO 639-2 "und")
+const Unknown = "und"
+
+// minHits is how many function words of a language a text needs before it is attributed to it
+const minHits = 2
+
+// stopwords lists frequent function words per language. Words shared by several languages
+// say nothing about which one a text is in, so they are dropped when the index is built.
+var stopwords = map[string]string{
+	"en": "the and of to is that it for was with as on are be this have from or by not but what all were when there can an your which their if will would about has been its they you we he she our who them than also into only other some could these",
+	"de": "der die das und ist nicht ein eine zu den von mit sich des auf für im dem als auch es an werden aus er hat dass sie nach wird bei einer um noch wie einem über einen so zum war haben nur oder aber vor zur bis mehr durch man kann ich wir ihr sind doch wenn schon",
+	"fr": "le la les et des est une du en que qui dans pour pas sur au avec il elle ce sont ou mais plus par nous vous leur cette aux été être fait comme tout",
+	"es": "el la los las y de que en un una es por con para no se del al lo como más pero sus le ya o este sí porque esta entre cuando muy sin sobre también",
+	"it": "il di che è e la per un una sono non con del della le si da gli ma come anche questo nel alla più lo dei delle ha essere",
+	"nl": "de het een en van is dat op te zijn met voor niet aan er maar om ook als bij dan nog wel naar hij zij wordt worden deze dit",
+}
+
+// names holds the English name of every language, for instructing an LLM
+var names = map[string]string{
+	"en": "English",
+	"de": "German",
+	"fr": "French",
+	"es": "Spanish",
+	"it": "Italian",
+	"nl": "Dutch",
+}
+
+// Name returns the English name of the language code, or the code itself if it is unknown.
+func Name(code string) string {
+	if name, ok := names[code]; ok {
+		return name
+	}
+	return code
+}
+
+// index maps every distinctive function word to its language
+var index = buildIndex()
+
+func buildIndex() map[string]string {
+	owners := map[string][]string{}
+	for code, words := range stopwords {
+		for _, w := range strings.Fields(words) {
+			owners[w] = append(owners[w], code)
+		}
+	}
+	idx := map[string]string{}
+	for w, codes := range owners {
+		if len(codes) == 1 {
+			idx[w] = codes[0]
+		}
+	}
+	return idx
+}
+
+// Languages returns the codes Detect can return, sorted.
+func Languages() []string {
+	out := make([]string, 0, len(stopwords))
+	for code := range stopwords {
+		out = append(out, code)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Detect returns the ISO 639-1 code of the language text is most likely written in, or
+// Unknown if it has too few distinctive words or two languages tie.
+func Detect(text string) string {
+	hits := map[string]int{}
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+	for _, w := range words {
+		if code, ok := index[w]; ok {
+			hits[code]++
+		}
+	}
+
+	best, bestHits, runnerUp := Unknown, 0, 0
+	for _, code := range Languages() {
+		switch n := hits[code]; {
+		case n > bestHits:
+			best, bestHits, runnerUp = code, n, bestHits
+		case n > runnerUp:
+			runnerUp = n
+		}
+	}
+	if bestHits < minHits || bestHits == runnerUp {
+		return Unknown
+	}
+	return best
+}
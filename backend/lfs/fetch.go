@@ -0,0 +1,248 @@
+package lfs
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"vex-backend/config"
+	"vex-backend/httpclient"
+)
+
+const mediaType = "application/vnd.git-lfs+json"
+
+// smudgedFile lists, inside the clone's .git folder, the files whose pointer was replaced by
+// its content, with the pointer to put back before the next pull
+const smudgedFile = "vex-lfs-smudged.json"
+
+// recordMu serialises updates of the smudged file
+var recordMu sync.Mutex
+
+// Fetcher downloads LFS content from the notes repository's LFS server through the batch API
+// and checks it out in place of the pointers.
+type Fetcher struct {
+	// URL is the repository URL the LFS endpoint is derived from, as git-lfs does
+	URL string
+	// User and PAT authenticate the batch request, like clones and pulls
+	User string
+	PAT  string
+	// Root is the local clone; downloads are cached in its .git/lfs/objects
+	Root   string
+	Client httpclient.Doer
+}
+
+// New returns the fetcher of the clone at root when LFS_FETCH is on, nil otherwise.
+func New(cfg *config.EnvConfig, client httpclient.Doer, root string) *Fetcher {
+	if !cfg.LFSFetch {
+		return nil
+	}
+	return &Fetcher{URL: cfg.NotesRepo, User: cfg.GitUser, PAT: cfg.GitPAT, Root: root, Client: httpclient.OrDefault(client)}
+}
+
+// endpoint is the LFS server of the repository: <url>.git/info/lfs.
+func (f *Fetcher) endpoint() string {
+	u := strings.TrimSuffix(f.URL, "/")
+	if !strings.HasSuffix(u, ".git") {
+		u += ".git"
+	}
+	return u + "/info/lfs"
+}
+
+// cachePath is where the content of p is kept, in the layout git-lfs uses.
+func (f *Fetcher) cachePath(p Pointer) string {
+	return filepath.Join(f.Root, ".git", "lfs", "objects", p.OID[:2], p.OID[2:4], p.OID)
+}
+
+// Smudge replaces the pointer file at path with the content it points to, downloading it
+// unless it is cached, and records the pointer so Restore can put it back.
+func (f *Fetcher) Smudge(ctx context.Context, path string, p Pointer) error {
+	rel, err := filepath.Rel(f.Root, path)
+	if err != nil {
+		return err
+	}
+	pointer, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	cached := f.cachePath(p)
+	if _, err := os.Stat(cached); err != nil {
+		if err := f.download(ctx, p, cached); err != nil {
+			return fmt.Errorf("failed to fetch %s: %w", p, err)
+		}
+	}
+	content, err := os.ReadFile(cached)
+	if err != nil {
+		return err
+	}
+
+	// record the pointer first, so a failed write can't leave content nothing restores
+	if err := updateRecord(f.Root, func(r map[string]string) { r[filepath.ToSlash(rel)] = string(pointer) }); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, content, 0o644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
+
+type batchObject struct {
+	OID     string `json:"oid"`
+	Size    int64  `json:"size"`
+	Actions struct {
+		Download *struct {
+			Href   string            `json:"href"`
+			Header map[string]string `json:"header"`
+		} `json:"download"`
+	} `json:"actions"`
+	Error *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// download asks the batch API where the content of p is and saves it to dst once its hash
+// checks out.
+func (f *Fetcher) download(ctx context.Context, p Pointer, dst string) error {
+	body, err := json.Marshal(map[string]any{
+		"operation": "download",
+		"transfers": []string{"basic"},
+		"objects":   []map[string]any{{"oid": p.OID, "size": p.Size}},
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, f.endpoint()+"/objects/batch", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", mediaType)
+	req.Header.Set("Content-Type", mediaType)
+	if f.User != "" || f.PAT != "" {
+		req.SetBasicAuth(f.User, f.PAT)
+	}
+	resp, err := f.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("LFS batch API returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	var batch struct {
+		Objects []batchObject `json:"objects"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&batch); err != nil {
+		return fmt.Errorf("failed to decode the LFS batch response: %w", err)
+	}
+	if len(batch.Objects) == 0 {
+		return fmt.Errorf("LFS batch response lists no objects")
+	}
+	obj := batch.Objects[0]
+	switch {
+	case obj.Error != nil:
+		return fmt.Errorf("LFS server: %s (%d)", obj.Error.Message, obj.Error.Code)
+	case obj.Actions.Download == nil:
+		return fmt.Errorf("LFS server offers no download")
+	}
+
+	get, err := http.NewRequestWithContext(ctx, http.MethodGet, obj.Actions.Download.Href, nil)
+	if err != nil {
+		return err
+	}
+	for k, v := range obj.Actions.Download.Header {
+		get.Header.Set(k, v)
+	}
+	dl, err := f.Client.Do(get)
+	if err != nil {
+		return err
+	}
+	defer dl.Body.Close()
+	if dl.StatusCode != http.StatusOK {
+		return fmt.Errorf("LFS download returned %s", dl.Status)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".download-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tmp, h), io.LimitReader(dl.Body, p.Size+1))
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if n != p.Size || hex.EncodeToString(h.Sum(nil)) != p.OID {
+		return fmt.Errorf("downloaded content doesn't match the pointer")
+	}
+	return os.Rename(tmp.Name(), dst)
+}
+
+// Restore puts back the pointers of every file Smudge replaced in the clone at root, leaving
+// the worktree as git checked it out so that it can be pulled.
+func Restore(root string) error {
+	recordMu.Lock()
+	defer recordMu.Unlock()
+
+	record, err := readRecord(root)
+	if err != nil || len(record) == 0 {
+		return err
+	}
+	for rel, pointer := range record {
+		path := filepath.Join(root, filepath.FromSlash(rel))
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			continue
+		}
+		if err := os.WriteFile(path, []byte(pointer), 0o644); err != nil {
+			return fmt.Errorf("failed to restore the LFS pointer of %s: %w", rel, err)
+		}
+	}
+	return os.Remove(filepath.Join(root, ".git", smudgedFile))
+}
+
+func readRecord(root string) (map[string]string, error) {
+	record := map[string]string{}
+	data, err := os.ReadFile(filepath.Join(root, ".git", smudgedFile))
+	if os.IsNotExist(err) {
+		return record, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", smudgedFile, err)
+	}
+	return record, nil
+}
+
+func updateRecord(root string, update func(map[string]string)) error {
+	recordMu.Lock()
+	defer recordMu.Unlock()
+
+	record, err := readRecord(root)
+	if err != nil {
+		return err
+	}
+	update(record)
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(root, ".git", smudgedFile), data, 0o600)
+}
@@ -0,0 +1,84 @@
+// Package lfs recognises Git LFS pointer files in the notes repository. The clone doesn't run
+// LFS's smudge filter, so files tracked by LFS are checked out as small text pointers naming
+// the real content by hash. Those are skipped rather than embedded, unless LFS_FETCH downloads
+// the content in their place.
+package lfs
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Spec is the version line every pointer starts with
+const Spec = "version https://git-lfs.github.com/spec/v1"
+
+// maxPointerSize bounds what is read to recognise a pointer; real pointers are about 130 bytes
+const maxPointerSize = 1024
+
+var reOID = regexp.MustCompile(`^sha256:([0-9a-f]{64})$`)
+
+// Pointer is the content an LFS pointer file stands for.
+type Pointer struct {
+	// OID is the hex SHA-256 of the content
+	OID  string
+	Size int64
+}
+
+// String describes the pointer for log lines and skip reasons.
+func (p Pointer) String() string {
+	return fmt.Sprintf("Git LFS object %s (%d bytes)", p.OID[:12], p.Size)
+}
+
+// Parse recognises an LFS pointer, returning false for any other content.
+func Parse(data []byte) (Pointer, bool) {
+	if len(data) > maxPointerSize || !bytes.HasPrefix(data, []byte(Spec+"\n")) {
+		return Pointer{}, false
+	}
+
+	var p Pointer
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		key, value, ok := strings.Cut(sc.Text(), " ")
+		if !ok {
+			return Pointer{}, false
+		}
+		switch key {
+		case "oid":
+			m := reOID.FindStringSubmatch(value)
+			if m == nil {
+				return Pointer{}, false
+			}
+			p.OID = m[1]
+		case "size":
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || n < 0 {
+				return Pointer{}, false
+			}
+			p.Size = n
+		}
+	}
+	return p, p.OID != ""
+}
+
+// ReadPointer reports whether the file at path is an LFS pointer, reading no more than a
+// pointer's worth of it.
+func ReadPointer(path string) (Pointer, bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Pointer{}, false, err
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(io.LimitReader(f, maxPointerSize+1))
+	if err != nil {
+		return Pointer{}, false, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	p, ok := Parse(data)
+	return p, ok, nil
+}
@@ -1,38 +1,217 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
 	"time"
 
+	"vex-backend/analytics"
+	"vex-backend/audit"
+	"vex-backend/bootstrap"
+	"vex-backend/breaker"
 	"vex-backend/config"
+	"vex-backend/digest"
+	"vex-backend/experiment"
+	"vex-backend/feedback"
+	"vex-backend/feeds"
+	"vex-backend/ingestion"
+	"vex-backend/middleware"
+	"vex-backend/notify"
+	"vex-backend/probe"
 	"vex-backend/routes"
-	"vex-backend/vector/embed"
+	"vex-backend/telegram"
+	"vex-backend/usage"
 	vectormgr "vex-backend/vector/manager"
 )
 
+// command is a subcommand of the vex binary; args are the arguments after its name.
+type command struct {
+	summary string
+	run     func(args []string) error
+}
+
+var commands = map[string]command{
+	"serve":  {"run the HTTP server (the default)", serve},
+	"index":  {"embed files of the local clone: index [-pull] [path...]", runIndex},
+	"query":  {"answer a question from the notes: query [flags] \"question\"", runQuery},
+	"chat":   {"talk about the notes in an interactive session: chat [flags]", runChat},
+	"export": {"write every stored chunk to stdout or a file: export [-o file]", runExport},
+	"stats":  {"print statistics about the vector store and the index", runStats},
+	"eval":   {"score retrieval against an evaluation set: eval [-file path] [-k n]", runEval},
+}
+
 func main() {
-	// Initialize config ONCE at startup
-	if err := config.InitConfig(); err != nil {
+	// without a command the binary serves, as it always has
+	name, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	cmd, ok := commands[name]
+	if name == "help" || !ok {
+		printUsage()
+		if name != "help" {
+			os.Exit(2)
+		}
+		return
+	}
+	err := cmd.run(args)
+	// usage is written out in batches; keep what the command used
+	if flushErr := usage.Flush(); flushErr != nil {
+		log.Printf("[Usage] warning: failed to persist usage: %v", flushErr)
+	}
+	if err != nil {
+		if err == flag.ErrHelp {
+			os.Exit(2)
+		}
+		log.SetOutput(os.Stderr)
 		log.Fatal(err)
 	}
+}
+
+func printUsage() {
+	fmt.Fprintln(os.Stderr, "usage: vex <command> [arguments]\n\ncommands:")
+	for _, name := range []string{"serve", "index", "query", "chat", "export", "stats", "eval"} {
+		fmt.Fprintf(os.Stderr, "  %-7s %s\n", name, commands[name].summary)
+	}
+	fmt.Fprintln(os.Stderr, "\nRun vex <command> -h for the flags of a command.")
+}
+
+// serve runs the HTTP server until the process is stopped.
+func serve(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	a, err := newApp()
+	if err != nil {
+		return err
+	}
+	cfg := a.cfg
 
-	fmt.Printf("Loaded config - Git User: %s, Clone Folder: %s\n", config.Config.GitUser, config.Config.CloneFolder)
+	// Report what was loaded, with secrets masked
+	for _, line := range config.Report(cfg()) {
+		log.Printf("[config] %s", line)
+	}
 
-	embedder := embed.NewVoyageEmbed("voyage-4-large")
-	manager := vectormgr.NewChromemManager(embedder)
+	// A provider whose breaker opens is down; tell ALERT_NTFY_URL and Pushover
+	breaker.OnOpen(func(s breaker.Status) {
+		notify.Alert(cfg, a.client, notify.KindProvider+":"+s.Name, "vex: "+s.Name+" is failing",
+			fmt.Sprintf("The %s circuit breaker opened after %d consecutive failures; calls to it fail fast until it recovers.", s.Name, s.Failures))
+	})
 
-	mux := routes.RegisterRoutes(manager)
+	// Digests run on DIGEST_SCHEDULE and on demand through /digest, sharing one state file
+	dg, err := digest.New(cfg, a.client, a.repo, a.vectors, filepath.Join(cfg().VectorStorageFolder, "digest_state.json"))
+	if err != nil {
+		return err
+	}
+	go dg.Run(context.Background())
 
-	port := config.Config.ServerPort
-	if port == "" {
-		port = ":8080"
-	} else if port[0] != ':' {
-		port = ":" + port
+	// Feeds of FEED_URLS are polled every FEED_INTERVAL and on demand through /admin/feeds
+	fw, err := feeds.New(cfg, a.client, a.vectors, filepath.Join(cfg().VectorStorageFolder, "feed_state.json"))
+	if err != nil {
+		return err
 	}
+	go fw.Run(context.Background())
+
+	// Ingestion connectors sync through /ingest/sources, resuming from their checkpoints
+	cp, err := ingestion.LoadCheckpoints(filepath.Join(cfg().VectorStorageFolder, "ingestion_state.json"))
+	if err != nil {
+		return err
+	}
+	ing := ingestion.NewRunner(cfg, a.vectors, cp)
+
+	// Providers are probed every PROBE_INTERVAL; /health/providers reports their history
+	pr, err := probe.New(cfg, a.client, filepath.Join(cfg().VectorStorageFolder, "provider_health.json"))
+	if err != nil {
+		return err
+	}
+	go pr.Run(context.Background())
+
+	// A share of /query is answered by the variant of EXPERIMENT_NAME; /admin/experiments
+	// compares it with the control
+	exp, err := experiment.New(cfg, filepath.Join(cfg().VectorStorageFolder, experiment.LogFile))
+	if err != nil {
+		return err
+	}
+
+	// Feedback on answers is kept with their traces and counted for their notes in the catalog
+	fb, err := feedback.New(cfg, filepath.Join(cfg().VectorStorageFolder, feedback.LogFile), a.cat)
+	if err != nil {
+		return err
+	}
+
+	// With QUERY_HISTORY on, queries are kept for /analytics
+	hist, err := analytics.New(cfg, filepath.Join(cfg().VectorStorageFolder, analytics.LogFile))
+	if err != nil {
+		return err
+	}
+
+	// A fresh deployment indexes the repository without waiting for the first push; /ready
+	// answers 503 until it is done
+	if cfg().BootstrapOnStart {
+		go bootstrap.Run(context.Background(), cfg, a.client, a.repo, a.vectors, a.man)
+	}
+
+	// Questions sent to the Telegram bot are answered from the notes
+	if cfg().TelegramBotToken != "" {
+		go telegram.New(cfg, a.client, a.vectors).Run(context.Background())
+	}
+
+	// Soft-deleted chunks are dropped once SOFT_DELETE_RETENTION has passed
+	go vectormgr.RunTrashPurge(context.Background(), cfg, a.vectors)
+
+	mux := routes.RegisterRoutes(cfg, a.client, a.repo, a.vectors, a.man, dg, fw, ing, pr, exp, fb, hist, a.cat)
+
+	port := fmt.Sprintf(":%d", cfg().ServerPort)
+
+	go reloadOnSIGHUP()
+	go flushOnExit(a)
 
 	currentTime := time.Now().Format("2006-01-02 15:04:05")
 	fmt.Printf("[%s] Server starting on port %s\n", currentTime, port)
-	log.Fatal(http.ListenAndServe(port, mux))
+	// Tag every request with an ID so error envelopes can be matched to server logs, and apply
+	// its X-Vex-Flags
+	return http.ListenAndServe(port, middleware.RequestID(middleware.FeatureFlags(mux)))
+}
+
+// reloadOnSIGHUP reloads the runtime-reloadable configuration whenever the process
+// receives SIGHUP, the same as POST /admin/reload-config.
+func reloadOnSIGHUP() {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP)
+	for range sig {
+		changed, err := config.Reload()
+		if err != nil {
+			log.Printf("[config] SIGHUP reload rejected: %v", err)
+			continue
+		}
+		log.Printf("[config] SIGHUP reload applied, changed: %v", changed)
+		audit.Record(context.Background(), audit.Entry{Action: audit.ActionReloadConfig, Detail: "SIGHUP, changed: " + strings.Join(changed, ",")}, nil)
+	}
+}
+
+// flushOnExit writes out pending changes of the usage totals and the vector store when the
+// process is asked to stop, then exits.
+func flushOnExit(a *app) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	<-sig
+
+	if err := usage.Flush(); err != nil {
+		log.Printf("[main] failed to flush usage before exit: %v", err)
+	}
+	if err := a.flush(); err != nil {
+		log.Printf("[main] failed to flush vectors before exit: %v", err)
+		os.Exit(1)
+	}
+	os.Exit(0)
 }
@@ -0,0 +1,237 @@
+package manifest
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// State is the indexing state of a single file.
+type State string
+
+const (
+	StatePending State = "pending"
+	StateIndexed State = "indexed"
+	StateFailed  State = "failed"
+	StateSkipped State = "skipped"
+	StateDeleted State = "deleted"
+)
+
+// Entry records the last known indexing outcome for a file.
+type Entry struct {
+	State State `json:"state"`
+	// Reason says why a skipped file was not embedded
+	Reason    string `json:"reason,omitempty"`
+	Error     string `json:"error,omitempty"`
+	Attempts  int    `json:"attempts"`
+	UpdatedAt string `json:"updated_at"`
+	// IndexedAt is when the file was last embedded successfully, kept through later failures
+	IndexedAt string `json:"indexed_at,omitempty"`
+}
+
+// Manifest tracks per-file indexing state and persists it as JSON so that
+// interrupted or partially failed syncs can be resumed later.
+// Keys are paths relative to the notes repository root.
+type Manifest struct {
+	path string
+	// from and base are the manifest a staged one was staged from and its entries at the
+	// time; a staged manifest has no path of its own
+	from *Manifest
+	base map[string]Entry
+
+	mu    sync.Mutex
+	Files map[string]Entry `json:"files"`
+}
+
+// Load reads the manifest stored at path. A missing file yields an empty manifest.
+func Load(path string) (*Manifest, error) {
+	m := &Manifest{
+		path:  path,
+		Files: make(map[string]Entry),
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+	if m.Files == nil {
+		m.Files = make(map[string]Entry)
+	}
+
+	return m, nil
+}
+
+// Stage returns a copy of m whose changes stay in memory until Commit. A rebuild records
+// into a stage, so that m keeps describing the live index if the rebuild is aborted.
+func (m *Manifest) Stage() *Manifest {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return &Manifest{from: m, base: maps.Clone(m.Files), Files: maps.Clone(m.Files)}
+}
+
+// Commit writes the entries of a staged manifest to the manifest it was staged from and
+// saves that. Entries the latter changed in the meantime are kept, like the files written
+// to the live index during a rebuild are carried over into the rebuilt one.
+func (m *Manifest) Commit() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.from == nil {
+		return fmt.Errorf("manifest %s is not staged", m.path)
+	}
+
+	live := m.from
+	live.mu.Lock()
+	defer live.mu.Unlock()
+	files := maps.Clone(m.Files)
+	for rel, e := range live.Files {
+		if before, ok := m.base[rel]; !ok || before != e {
+			files[rel] = e
+		}
+	}
+	live.Files = files
+	return live.save()
+}
+
+// MarkPending records that rel is queued for indexing.
+func (m *Manifest) MarkPending(rel string) error {
+	return m.update(rel, StatePending, "", nil)
+}
+
+// MarkIndexed records that rel was embedded successfully.
+func (m *Manifest) MarkIndexed(rel string) error {
+	return m.update(rel, StateIndexed, "", nil)
+}
+
+// MarkSkipped records that rel was intentionally not embedded, and why.
+func (m *Manifest) MarkSkipped(rel, reason string) error {
+	return m.update(rel, StateSkipped, reason, nil)
+}
+
+// MarkFailed records that indexing rel failed with cause.
+func (m *Manifest) MarkFailed(rel string, cause error) error {
+	return m.update(rel, StateFailed, "", cause)
+}
+
+// MarkDeleted records that rel was removed from the repository and its vectors dropped.
+func (m *Manifest) MarkDeleted(rel string) error {
+	return m.update(rel, StateDeleted, "", nil)
+}
+
+// Get returns the entry for rel, if any.
+func (m *Manifest) Get(rel string) (Entry, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.Files[rel]
+	return e, ok
+}
+
+// Counts returns how many files are in each state.
+func (m *Manifest) Counts() map[State]int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	counts := make(map[State]int)
+	for _, e := range m.Files {
+		counts[e.State]++
+	}
+	return counts
+}
+
+// Unfinished returns the files that are pending or failed, sorted by path.
+func (m *Manifest) Unfinished() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var out []string
+	for rel, e := range m.Files {
+		if e.State == StatePending || e.State == StateFailed {
+			out = append(out, rel)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Failed returns the files that failed, sorted by path. A non-empty dir, relative to the
+// repository root, keeps only that file or the files below it.
+func (m *Manifest) Failed(dir string) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	dir = strings.TrimSuffix(filepath.ToSlash(filepath.Clean(dir)), "/")
+	var out []string
+	for rel, e := range m.Files {
+		if e.State != StateFailed {
+			continue
+		}
+		if dir != "" && dir != "." && rel != dir && !strings.HasPrefix(filepath.ToSlash(rel), dir+"/") {
+			continue
+		}
+		out = append(out, rel)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func (m *Manifest) update(rel string, state State, reason string, cause error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e := m.Files[rel]
+	if state == StatePending {
+		e.Attempts++
+	}
+	e.State = state
+	e.Reason = reason
+	e.Error = ""
+	if cause != nil {
+		e.Error = cause.Error()
+	}
+	e.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+	if state == StateIndexed {
+		e.IndexedAt = e.UpdatedAt
+	}
+	m.Files[rel] = e
+
+	return m.save()
+}
+
+// save writes the manifest to disk atomically; staged manifests are only written by
+// Commit. Callers must hold m.mu.
+func (m *Manifest) save() error {
+	if m.from != nil {
+		return nil
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal manifest: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(m.path), 0o755); err != nil {
+		return fmt.Errorf("failed to create manifest directory: %w", err)
+	}
+
+	tmp := m.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	if err := os.Rename(tmp, m.path); err != nil {
+		return fmt.Errorf("failed to replace manifest: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,55 @@
+package manifest
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestStageCommit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index_manifest.json")
+	live, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rel := range []string{"a.md", "b.md", "c.md"} {
+		if err := live.MarkIndexed(rel); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	staged := live.Stage()
+	if err := staged.MarkFailed("a.md", errors.New("embedding failed")); err != nil {
+		t.Fatal(err)
+	}
+	if err := staged.MarkSkipped("b.md", "empty"); err != nil {
+		t.Fatal(err)
+	}
+	// written to the live index during the rebuild
+	if err := live.MarkDeleted("b.md"); err != nil {
+		t.Fatal(err)
+	}
+
+	// an aborted rebuild leaves the manifest on disk as it was
+	if onDisk, err := Load(path); err != nil {
+		t.Fatal(err)
+	} else if e, _ := onDisk.Get("a.md"); e.State != StateIndexed {
+		t.Fatalf("a.md on disk before Commit = %s, want indexed", e.State)
+	}
+
+	if err := staged.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	onDisk, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rel, want := range map[string]State{"a.md": StateFailed, "b.md": StateDeleted, "c.md": StateIndexed} {
+		if e, _ := onDisk.Get(rel); e.State != want {
+			t.Errorf("%s after Commit = %s, want %s", rel, e.State, want)
+		}
+		if e, _ := live.Get(rel); e.State != want {
+			t.Errorf("%s in memory after Commit = %s, want %s", rel, e.State, want)
+		}
+	}
+}
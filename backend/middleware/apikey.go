@@ -1,14 +1,27 @@
 package middleware
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"net/http"
+	"slices"
 	"strings"
 
+	"vex-backend/access"
+	"vex-backend/apierror"
+	"vex-backend/audit"
 	"vex-backend/config"
+	"vex-backend/usage"
 )
 
 // RequireAPIKey is an HTTP middleware that enforces a single hard-coded API key
-// defined in config.Config.HardCodedAPIKeyForNow.
+// defined in config.Current().HardCodedAPIKeyForNow. It is APIKeyAuth(config.Current).
+func RequireAPIKey(next http.Handler) http.Handler {
+	return APIKeyAuth(config.Current)(next)
+}
+
+// APIKeyAuth returns an HTTP middleware that enforces the API key (HARD_CODED_API_KEY)
+// of the configuration yielded by cfg.
 //
 // The middleware accepts the key via either:
 //   - X-API-Key: <key>
@@ -16,38 +29,72 @@ import (
 //
 // If the configured key is empty or missing, requests will be rejected with
 // 401 Unauthorized. If the provided key doesn't match the configured value,
-// the request is rejected with 401 Unauthorized.
-func RequireAPIKey(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		expected := ""
-		if config.Config != nil {
-			expected = config.Config.HardCodedAPIKeyForNow
-		}
-
-		// If there's no key configured, treat as unauthorized.
-		if strings.TrimSpace(expected) == "" {
-			http.Error(w, "api key not configured", http.StatusUnauthorized)
-			return
-		}
-
-		// Try X-API-Key header first.
-		key := strings.TrimSpace(r.Header.Get("X-API-Key"))
-
-		// Fallback to Authorization: Bearer <token>
-		if key == "" {
-			auth := strings.TrimSpace(r.Header.Get("Authorization"))
-			if strings.HasPrefix(strings.ToLower(auth), "bearer ") {
-				key = strings.TrimSpace(auth[len("Bearer "):])
+// the request is rejected with 401 Unauthorized, or 403 Forbidden if it is one of the
+// read-only SHARED_API_KEYS.
+func APIKeyAuth(cfg config.Source) func(http.Handler) http.Handler {
+	return apiKeyAuth(cfg, false)
+}
+
+// SharedAPIKeyAuth is APIKeyAuth that also admits the read-only SHARED_API_KEYS. The
+// request context carries the caller's access.Scope, so the handler only sees the notes
+// that key may see.
+func SharedAPIKeyAuth(cfg config.Source) func(http.Handler) http.Handler {
+	return apiKeyAuth(cfg, true)
+}
+
+func apiKeyAuth(cfg config.Source, allowShared bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			expected := ""
+			var shared []string
+			if c := cfg(); c != nil {
+				expected = c.HardCodedAPIKeyForNow
+				shared = c.SharedKeys()
 			}
-		}
 
-		// Compare the provided key to the expected key.
-		if key == "" || key != expected {
-			http.Error(w, "unauthorized", http.StatusUnauthorized)
-			return
-		}
+			// If there's no key configured, treat as unauthorized.
+			if strings.TrimSpace(expected) == "" {
+				apierror.Write(w, r, http.StatusUnauthorized, "api key not configured")
+				return
+			}
+
+			// Try X-API-Key header first.
+			key := strings.TrimSpace(r.Header.Get("X-API-Key"))
+
+			// Fallback to Authorization: Bearer <token>
+			if key == "" {
+				auth := strings.TrimSpace(r.Header.Get("Authorization"))
+				if strings.HasPrefix(strings.ToLower(auth), "bearer ") {
+					key = strings.TrimSpace(auth[len("Bearer "):])
+				}
+			}
+
+			// Compare the provided key to the expected key, then to the shared keys.
+			scope := access.ScopeFull
+			if key == "" || key != expected {
+				if key == "" || !slices.Contains(shared, key) {
+					apierror.Write(w, r, http.StatusUnauthorized, "unauthorized")
+					return
+				}
+				if !allowShared {
+					apierror.Write(w, r, http.StatusForbidden, "this API key is read-only")
+					return
+				}
+				scope = access.ScopeShared
+			}
+
+			// All good — attribute API usage and audited changes to this key and call the next handler.
+			id := keyFingerprint(key)
+			ctx := audit.WithActor(usage.WithSource(r.Context(), id), id)
+			r = r.WithContext(access.WithScope(ctx, scope))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
 
-		// All good — call the next handler.
-		next.ServeHTTP(w, r)
-	})
+// keyFingerprint returns a short, non-reversible identifier for an API key so usage
+// can be reported per key without storing the key itself.
+func keyFingerprint(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return "key-" + hex.EncodeToString(sum[:])[:12]
 }
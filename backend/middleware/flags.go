@@ -0,0 +1,27 @@
+package middleware
+
+import (
+	"net/http"
+
+	"vex-backend/apierror"
+	"vex-backend/flags"
+)
+
+// FeatureFlags is an HTTP middleware that applies the X-Vex-Flags header of a request to
+// its context, overriding FEATURE_FLAGS for that request. A header naming an unknown flag
+// is rejected with 400, so a typo doesn't silently test the wrong pipeline.
+func FeatureFlags(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		value := r.Header.Get(flags.Header)
+		if value == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		overrides, err := flags.Parse(value)
+		if err != nil {
+			apierror.Write(w, r, http.StatusBadRequest, flags.Header+": "+err.Error())
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(flags.WithOverrides(r.Context(), overrides)))
+	})
+}
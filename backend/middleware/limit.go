@@ -0,0 +1,161 @@
+package middleware
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"vex-backend/apierror"
+	"vex-backend/config"
+)
+
+// ConcurrencyLimit returns an HTTP middleware that bounds how many requests to route run at
+// once, so a burst of queries can't fan out into unbounded OpenAI and Voyage calls. Requests
+// over the limit wait in a FIFO queue for up to CONCURRENCY_QUEUE_TIMEOUT; once the queue is
+// full, or the wait times out, they are rejected with 429 Too Many Requests and a Retry-After
+// estimated from how long requests to the route take. The limits are read from cfg per
+// request (see EnvConfig.RouteLimit), so they can be reloaded; a concurrency of 0 disables
+// limiting for the route.
+func ConcurrencyLimit(cfg config.Source, route string) func(http.Handler) http.Handler {
+	l := &limiter{route: route}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			c := cfg()
+			limit := c.RouteLimit(route)
+			if limit.Concurrency == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			switch l.acquire(r, limit, c.ConcurrencyQueueTimeout) {
+			case admitted:
+			case canceled:
+				// the client is gone, there is no one to answer
+				return
+			default:
+				w.Header().Set("Retry-After", strconv.Itoa(l.retryAfter(limit)))
+				apierror.Write(w, r, http.StatusTooManyRequests, "server busy, retry later")
+				return
+			}
+
+			start := time.Now()
+			defer func() {
+				l.observe(time.Since(start))
+				l.release(cfg().RouteLimit(route))
+			}()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+type admission int
+
+const (
+	admitted admission = iota
+	rejected
+	canceled
+)
+
+// limiter admits up to a route's concurrency at once and queues the rest in arrival order.
+// Finishing requests admit waiters from the front of the queue, as many as the limit
+// currently allows.
+type limiter struct {
+	route string
+
+	mu      sync.Mutex
+	active  int
+	waiters []chan struct{}
+	// avg is a moving average of how long admitted requests take, for Retry-After
+	avg time.Duration
+}
+
+func (l *limiter) acquire(r *http.Request, limit config.RouteLimit, timeout time.Duration) admission {
+	l.mu.Lock()
+	if l.active < limit.Concurrency && len(l.waiters) == 0 {
+		l.active++
+		l.mu.Unlock()
+		return admitted
+	}
+	if len(l.waiters) >= limit.Queue {
+		active, waiting := l.active, len(l.waiters)
+		l.mu.Unlock()
+		log.Printf("[ConcurrencyLimit] %s saturated (%d active, %d queued), rejecting request from %s", l.route, active, waiting, r.RemoteAddr)
+		return rejected
+	}
+	ready := make(chan struct{})
+	l.waiters = append(l.waiters, ready)
+	l.mu.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-ready:
+		return admitted
+	case <-timer.C:
+		if l.abandon(ready) {
+			// admitted just as the wait ran out
+			return admitted
+		}
+		log.Printf("[ConcurrencyLimit] %s: request from %s timed out after %s in the queue", l.route, r.RemoteAddr, timeout)
+		return rejected
+	case <-r.Context().Done():
+		if l.abandon(ready) {
+			l.release(limit)
+		}
+		return canceled
+	}
+}
+
+// abandon takes ready out of the queue, or reports true if it was admitted in the meantime
+// and now holds a slot.
+func (l *limiter) abandon(ready chan struct{}) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i, w := range l.waiters {
+		if w == ready {
+			l.waiters = append(l.waiters[:i], l.waiters[i+1:]...)
+			return false
+		}
+	}
+	return true
+}
+
+// release frees a slot and admits waiters while the limit allows.
+func (l *limiter) release(limit config.RouteLimit) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.active--
+	// a limit reloaded to 0 no longer limits, so everyone waiting may go
+	for len(l.waiters) > 0 && (limit.Concurrency == 0 || l.active < limit.Concurrency) {
+		l.active++
+		close(l.waiters[0])
+		l.waiters = l.waiters[1:]
+	}
+}
+
+// observe adds the duration of a finished request to the moving average.
+func (l *limiter) observe(took time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.avg == 0 {
+		l.avg = took
+	} else {
+		l.avg = (l.avg*4 + took) / 5
+	}
+}
+
+// retryAfter estimates in whole seconds when a slot should be free: the queue ahead drains
+// at the route's concurrency, one average request duration per round.
+func (l *limiter) retryAfter(limit config.RouteLimit) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	rounds := float64(len(l.waiters))/float64(limit.Concurrency) + 1
+	seconds := int(math.Ceil(l.avg.Seconds() * rounds))
+	if seconds < 1 {
+		return 1
+	}
+	return seconds
+}
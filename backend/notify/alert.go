@@ -0,0 +1,151 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"vex-backend/config"
+	"vex-backend/git"
+	"vex-backend/httpclient"
+)
+
+// pushoverURL is Pushover's message API
+const pushoverURL = "https://api.pushover.net/1/messages.json"
+
+// Alert kinds, each throttled on its own
+const (
+	// KindProvider is an external service (Voyage, OpenAI, ...) failing repeatedly; each
+	// service is throttled on its own as KindProvider + ":" + its name
+	KindProvider = "provider"
+	// KindGitAuth is the notes repository rejecting GIT_USER and GIT_PAT
+	KindGitAuth = "git-auth"
+	// KindSync is a sync run, the startup indexing or a rebuild that failed
+	KindSync = "sync"
+)
+
+// throttle remembers when each kind of alert was last sent and how many were held back since
+type throttle struct {
+	mu         sync.Mutex
+	last       map[string]time.Time
+	suppressed map[string]int
+}
+
+var alerts = throttle{last: map[string]time.Time{}, suppressed: map[string]int{}}
+
+// allow reports whether an alert of kind may be sent now, and how many of its kind were held
+// back since the last one sent.
+func (t *throttle) allow(kind string, window time.Duration) (bool, int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if last, ok := t.last[kind]; ok && time.Since(last) < window {
+		t.suppressed[kind]++
+		return false, 0
+	}
+	held := t.suppressed[kind]
+	t.last[kind] = time.Now()
+	t.suppressed[kind] = 0
+	return true, held
+}
+
+// Alert pushes an error-level event to ALERT_NTFY_URL and Pushover in the background. After
+// an alert of a kind is sent, further alerts of that kind are dropped for ALERT_THROTTLE and
+// counted in the next one, so an outage doesn't turn into a storm of notifications.
+func Alert(cfg config.Source, client httpclient.Doer, kind, title, message string) {
+	c := cfg()
+	if c.AlertNtfyURL == "" && c.AlertPushoverToken == "" {
+		return
+	}
+	ok, held := alerts.allow(kind, c.AlertThrottle)
+	if !ok {
+		log.Printf("[Notify] holding back %s alert %q", kind, title)
+		return
+	}
+	if held > 0 {
+		message += fmt.Sprintf("\n(%d similar alerts held back since the last one)", held)
+	}
+	go func() {
+		if c.AlertNtfyURL != "" {
+			if err := pushNtfy(client, c, title, message); err != nil {
+				log.Printf("[Notify] failed to push the %s alert to ntfy: %v", kind, err)
+			}
+		}
+		if c.AlertPushoverToken != "" {
+			if err := pushPushover(client, c, title, message); err != nil {
+				log.Printf("[Notify] failed to push the %s alert to Pushover: %v", kind, err)
+			}
+		}
+	}()
+}
+
+// AlertError raises an alert for a trigger run that failed with err, as a repository
+// authentication failure when the remote rejected the credentials.
+func AlertError(cfg config.Source, client httpclient.Doer, trigger string, err error) {
+	if git.IsAuthError(err) {
+		Alert(cfg, client, KindGitAuth, "vex cannot authenticate to the notes repository",
+			"The notes repository rejected GIT_USER and GIT_PAT; the token may have expired or been revoked. Syncs fail until it is replaced.\nError: "+err.Error())
+		return
+	}
+	Alert(cfg, client, KindSync, "vex "+trigger+" failed", "Error: "+err.Error())
+}
+
+// pushNtfy publishes the alert to the ntfy topic at ALERT_NTFY_URL.
+func pushNtfy(client httpclient.Doer, c *config.EnvConfig, title, message string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.AlertNtfyURL, strings.NewReader(message))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Title", title)
+	req.Header.Set("Priority", "high")
+	req.Header.Set("Tags", "warning")
+	if c.AlertNtfyToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.AlertNtfyToken)
+	}
+	return push(client, req)
+}
+
+// pushPushover sends the alert to the Pushover user ALERT_PUSHOVER_USER.
+func pushPushover(client httpclient.Doer, c *config.EnvConfig, title, message string) error {
+	form := url.Values{
+		"token":    {c.AlertPushoverToken},
+		"user":     {c.AlertPushoverUser},
+		"title":    {title},
+		"message":  {message},
+		"priority": {"1"},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, pushoverURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return push(client, req)
+}
+
+// push sends req, keeping its URL (which may hold the ntfy topic) out of the error.
+func push(client httpclient.Doer, req *http.Request) error {
+	resp, err := httpclient.OrDefault(client).Do(req)
+	if err != nil {
+		var ue *url.Error
+		if errors.As(err, &ue) {
+			err = ue.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("answered %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
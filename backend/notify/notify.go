@@ -0,0 +1,168 @@
+// Package notify tells outbound webhooks how indexing runs went: Slack and Discord incoming
+// webhooks, or any endpoint taking JSON, so broken indexing is noticed without reading logs.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"vex-backend/config"
+	"vex-backend/httpclient"
+	"vex-backend/indexer"
+)
+
+// Statuses of a run
+const (
+	StatusSuccess = "success"
+	// StatusPartial runs completed with some files failed
+	StatusPartial = "partial"
+	// StatusFailed runs stopped before completing, e.g. on a git error or an open breaker
+	StatusFailed = "failed"
+)
+
+// maxListedFiles bounds the failed files named in a notification
+const maxListedFiles = 10
+
+// sendTimeout bounds the delivery to one webhook
+const sendTimeout = 10 * time.Second
+
+// Event is the outcome of an indexing run.
+type Event struct {
+	// Trigger is what started the run: webhook, resync, retry-failed, reindex or bootstrap
+	Trigger   string `json:"trigger"`
+	Status    string `json:"status"`
+	Processed int    `json:"processed"`
+	Skipped   int    `json:"skipped"`
+	Failed    int    `json:"failed"`
+	Deleted   int    `json:"deleted"`
+	Pending   int    `json:"pending"`
+	// FailedFiles names up to ten of the failed files, sorted
+	FailedFiles []string  `json:"failed_files,omitempty"`
+	DurationMS  int64     `json:"duration_ms"`
+	Error       string    `json:"error,omitempty"`
+	FinishedAt  time.Time `json:"finished_at"`
+}
+
+// RunEvent describes an indexing run that returned res and err after d.
+func RunEvent(trigger string, res indexer.Result, err error, d time.Duration) Event {
+	ev := Event{
+		Trigger:    trigger,
+		Status:     StatusSuccess,
+		Processed:  len(res.Processed),
+		Skipped:    len(res.Skipped),
+		Failed:     len(res.Failed),
+		Deleted:    len(res.Deleted),
+		Pending:    len(res.Pending),
+		DurationMS: d.Milliseconds(),
+		FinishedAt: time.Now().UTC(),
+	}
+	for rel := range res.Failed {
+		ev.FailedFiles = append(ev.FailedFiles, rel)
+	}
+	sort.Strings(ev.FailedFiles)
+	if len(ev.FailedFiles) > maxListedFiles {
+		ev.FailedFiles = ev.FailedFiles[:maxListedFiles]
+	}
+	switch {
+	case err != nil:
+		ev.Status, ev.Error = StatusFailed, err.Error()
+	case ev.Failed > 0:
+		ev.Status = StatusPartial
+	}
+	return ev
+}
+
+// FailureEvent describes a run that failed before indexing anything, at stage.
+func FailureEvent(trigger, stage string, err error, d time.Duration) Event {
+	return Event{
+		Trigger:    trigger,
+		Status:     StatusFailed,
+		Error:      stage + ": " + err.Error(),
+		DurationMS: d.Milliseconds(),
+		FinishedAt: time.Now().UTC(),
+	}
+}
+
+// Send posts ev to every NOTIFY_WEBHOOKS entry in the background, unless NOTIFY_ON=failure
+// and the run succeeded. Deliveries that fail are logged, not retried.
+func Send(cfg config.Source, client httpclient.Doer, ev Event) {
+	c := cfg()
+	hooks := c.NotifyWebhookList()
+	if len(hooks) == 0 || (c.NotifyOn != "always" && ev.Status == StatusSuccess) {
+		return
+	}
+	go func() {
+		for _, hook := range hooks {
+			if err := deliver(client, hook, ev); err != nil {
+				log.Printf("[Notify] failed to notify the %s webhook of the %s run: %v", hook.Format, ev.Trigger, err)
+			}
+		}
+	}()
+}
+
+// deliver posts ev to one webhook in its format.
+func deliver(client httpclient.Doer, hook config.NotifyWebhook, ev Event) error {
+	var payload any = ev
+	switch hook.Format {
+	case "slack":
+		payload = map[string]string{"text": Text(ev)}
+	case "discord":
+		payload = map[string]string{"content": Text(ev)}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := httpclient.OrDefault(client).Do(req)
+	if err != nil {
+		// the URL holds the webhook's secret; keep it out of the logs
+		var ue *url.Error
+		if errors.As(err, &ue) {
+			err = ue.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
+
+// Text summarizes ev in a line, with the failed files and the error on lines of their own.
+func Text(ev Event) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "vex %s sync %s: %d processed, %d failed, %d skipped, %d deleted",
+		ev.Trigger, ev.Status, ev.Processed, ev.Failed, ev.Skipped, ev.Deleted)
+	if ev.Pending > 0 {
+		fmt.Fprintf(&b, ", %d pending", ev.Pending)
+	}
+	fmt.Fprintf(&b, " in %s", (time.Duration(ev.DurationMS) * time.Millisecond).Round(time.Millisecond))
+	if len(ev.FailedFiles) > 0 {
+		b.WriteString("\nFailed: " + strings.Join(ev.FailedFiles, ", "))
+		if ev.Failed > len(ev.FailedFiles) {
+			fmt.Fprintf(&b, " and %d more", ev.Failed-len(ev.FailedFiles))
+		}
+	}
+	if ev.Error != "" {
+		b.WriteString("\nError: " + ev.Error)
+	}
+	return b.String()
+}
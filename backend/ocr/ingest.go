@@ -0,0 +1,277 @@
+package ocr
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"vex-backend/access"
+	"vex-backend/vector/embed"
+	vectormgr "vex-backend/vector/manager"
+)
+
+// Metadata keys of OCR chunks. Their filepath is the image, so they can be listed and
+// filtered like any other file.
+const (
+	// ReferencedByMetadataKey links an OCR chunk to the note that references its image
+	ReferencedByMetadataKey = "referenced_by"
+	// SourceMetadataKey is set to SourceOCR on chunks extracted from images
+	SourceMetadataKey = "source"
+	SourceOCR         = "ocr"
+	// ImageHashMetadataKey records the image content the text was extracted from, so
+	// unchanged images aren't sent through OCR again
+	ImageHashMetadataKey = "image_hash"
+)
+
+var (
+	// ![[image.png]] and ![[image.png|300]]
+	reWikiEmbed = regexp.MustCompile(`!\[\[([^\]|#]+)(?:[|#][^\]]*)?\]\]`)
+	// ![alt](path/to/image.png) and ![alt](<path with spaces.png> "title")
+	reMDImage = regexp.MustCompile(`!\[[^\]]*\]\(\s*<?([^)>"]+?)>?(?:\s+"[^"]*")?\s*\)`)
+)
+
+// References returns the image targets embedded in content, in order of appearance and
+// without duplicates. Remote images are left out.
+func References(content string) []string {
+	var out []string
+	seen := map[string]bool{}
+	add := func(ref string) {
+		ref = strings.TrimSpace(ref)
+		if unescaped, err := url.PathUnescape(ref); err == nil {
+			ref = unescaped
+		}
+		if ref == "" || seen[ref] || strings.Contains(ref, "://") || !IsImage(ref) {
+			return
+		}
+		seen[ref] = true
+		out = append(out, ref)
+	}
+	for _, m := range reWikiEmbed.FindAllStringSubmatch(content, -1) {
+		add(m[1])
+	}
+	for _, m := range reMDImage.FindAllStringSubmatch(content, -1) {
+		add(m[1])
+	}
+	return out
+}
+
+// Ingester keeps the OCR chunks of the images a note references in step with the note.
+type Ingester struct {
+	Extractor Extractor
+	// Root is the repository root; references resolve against the note's directory, then
+	// Root, then, like Obsidian's wiki embeds, any file of that name in the repository
+	Root string
+	// MaxFileSize in bytes skips larger images; 0 disables the check
+	MaxFileSize int64
+	// Ignored, if set, reports repository-relative images that must not be ingested
+	Ignored func(rel string) bool
+	// Prepare, if set, runs before an image is read and returns why it must be skipped, e.g.
+	// because it is a Git LFS pointer that isn't fetched
+	Prepare func(ctx context.Context, path string) (string, error)
+
+	// byName indexes the repository's images by file name, built on first use
+	byName map[string]string
+}
+
+// resolve returns the absolute path of the image ref points to from the note at notePath.
+func (in *Ingester) resolve(notePath, ref string) (string, bool) {
+	candidates := []string{filepath.Join(filepath.Dir(notePath), ref), filepath.Join(in.Root, ref)}
+	for _, c := range candidates {
+		if info, err := os.Stat(c); err == nil && !info.IsDir() {
+			abs, err := filepath.Abs(c)
+			return abs, err == nil
+		}
+	}
+
+	if in.byName == nil {
+		in.byName = map[string]string{}
+		filepath.WalkDir(in.Root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			if d.IsDir() && d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			if !d.IsDir() && IsImage(path) {
+				if _, dup := in.byName[d.Name()]; !dup {
+					in.byName[d.Name()] = path
+				}
+			}
+			return nil
+		})
+	}
+	if path, ok := in.byName[filepath.Base(ref)]; ok {
+		abs, err := filepath.Abs(path)
+		return abs, err == nil
+	}
+	return "", false
+}
+
+// Sync extracts the text of every image the note at notePath references and stores it as
+// chunks linked to the note, replacing the chunks of images that changed and removing those
+// of images no longer referenced. content is the note's text. It returns how many images
+// were extracted; images that fail are logged and reported in the error without stopping
+// the others.
+func (in *Ingester) Sync(ctx context.Context, m vectormgr.Manager, notePath, content string) (int, error) {
+	note, err := filepath.Abs(notePath)
+	if err != nil {
+		return 0, err
+	}
+	root, err := filepath.Abs(in.Root)
+	if err != nil {
+		return 0, err
+	}
+
+	existing, err := m.GetByMetadata(ctx, map[string]string{ReferencedByMetadataKey: note})
+	if err != nil {
+		return 0, err
+	}
+	stored := map[string][]string{}
+	hashes := map[string]string{}
+	for _, c := range existing {
+		img := c.Metadata["filepath"]
+		stored[img] = append(stored[img], c.Id)
+		hashes[img] = c.Metadata[ImageHashMetadataKey]
+	}
+
+	var errs []error
+	extracted := 0
+	current := map[string]bool{}
+	for _, ref := range References(content) {
+		img, ok := in.resolve(note, ref)
+		if !ok {
+			log.Printf("[OCR] %s references missing image %s", note, ref)
+			continue
+		}
+		if rel, err := filepath.Rel(root, img); err == nil && in.Ignored != nil && in.Ignored(filepath.ToSlash(rel)) {
+			continue
+		}
+		if _, seen := current[img]; seen {
+			continue
+		}
+		current[img] = true
+
+		if in.Prepare != nil {
+			reason, err := in.Prepare(ctx, img)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", filepath.Base(img), err))
+				continue
+			}
+			if reason != "" {
+				log.Printf("[OCR] skipping %s: %s", img, reason)
+				current[img] = false
+				continue
+			}
+		}
+		data, err := os.ReadFile(img)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if in.MaxFileSize > 0 && int64(len(data)) > in.MaxFileSize {
+			log.Printf("[OCR] skipping %s: %d bytes, more than the maximum of %d", img, len(data), in.MaxFileSize)
+			current[img] = false
+			continue
+		}
+		sum := sha256.Sum256(data)
+		hash := hex.EncodeToString(sum[:])[:16]
+		if hashes[img] == hash {
+			continue
+		}
+
+		if err := deleteIDs(ctx, m, stored[img]); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := in.ingest(ctx, m, note, img, hash, content); err != nil {
+			log.Printf("[OCR] failed to extract %s: %v", img, err)
+			errs = append(errs, fmt.Errorf("%s: %w", filepath.Base(img), err))
+			continue
+		}
+		extracted++
+	}
+
+	// images the note no longer references (or that became too large, ignored or LFS pointers)
+	for img, ids := range stored {
+		if !current[img] {
+			if err := deleteIDs(ctx, m, ids); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return extracted, errors.Join(errs...)
+}
+
+// ingest extracts the text of img and stores it as chunks linked to note. The chunks take
+// the note's access level and tags, so private screenshots stay private.
+func (in *Ingester) ingest(ctx context.Context, m vectormgr.Manager, note, img, hash, content string) error {
+	text, err := in.Extractor.Extract(ctx, img)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(text) == "" {
+		log.Printf("[OCR] no text found in %s", img)
+		return nil
+	}
+
+	metadata := map[string]string{
+		"filepath":              img,
+		"filename":              filepath.Base(img),
+		ReferencedByMetadataKey: note,
+		SourceMetadataKey:       SourceOCR,
+		ImageHashMetadataKey:    hash,
+		access.MetadataKey:      embed.ExtractAccess(content),
+	}
+	if info, err := os.Stat(img); err == nil {
+		metadata["mod_time"] = info.ModTime().UTC().Format(time.RFC3339)
+		metadata["size"] = strconv.FormatInt(info.Size(), 10)
+	}
+	if tags := embed.ExtractTags(content); len(tags) > 0 {
+		metadata["tags"] = strings.Join(tags, ",")
+		for _, tag := range tags {
+			metadata[embed.TagMetadataPrefix+tag] = "true"
+		}
+	}
+
+	vs, err := m.GetEmbedder().EmbedStringToVectorData(ctx, text, metadata)
+	if err != nil {
+		return err
+	}
+	return m.StoreVectorsInDB(ctx, vs)
+}
+
+// Drop removes every OCR chunk linked to the note at notePath.
+func Drop(ctx context.Context, m vectormgr.Manager, notePath string) error {
+	note, err := filepath.Abs(notePath)
+	if err != nil {
+		return err
+	}
+	existing, err := m.GetByMetadata(ctx, map[string]string{ReferencedByMetadataKey: note})
+	if err != nil {
+		return err
+	}
+	ids := make([]string, 0, len(existing))
+	for _, c := range existing {
+		ids = append(ids, c.Id)
+	}
+	return deleteIDs(ctx, m, ids)
+}
+
+func deleteIDs(ctx context.Context, m vectormgr.Manager, ids []string) error {
+	for _, id := range ids {
+		if err := m.DeleteVectorWithID(ctx, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
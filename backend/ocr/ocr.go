@@ -0,0 +1,182 @@
+// Package ocr extracts the text of images attached to notes, such as screenshots, so it can
+// be embedded alongside the notes that reference them. Text is read either by a local
+// tesseract binary or by an OpenAI vision model.
+package ocr
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"vex-backend/breaker"
+	"vex-backend/config"
+	"vex-backend/httpclient"
+	"vex-backend/usage"
+	"vex-backend/vector"
+)
+
+// OCR providers selected by OCR_PROVIDER
+const (
+	ProviderOff       = "off"
+	ProviderTesseract = "tesseract"
+	ProviderOpenAI    = "openai"
+)
+
+// imageTypes maps the extensions of supported images to their MIME type
+var imageTypes = map[string]string{
+	".png":  "image/png",
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".gif":  "image/gif",
+	".webp": "image/webp",
+	".bmp":  "image/bmp",
+	".tif":  "image/tiff",
+	".tiff": "image/tiff",
+}
+
+// IsImage reports whether path has the extension of a supported image type.
+func IsImage(path string) bool {
+	_, ok := imageTypes[strings.ToLower(filepath.Ext(path))]
+	return ok
+}
+
+// Extractor reads the text shown in an image file.
+type Extractor interface {
+	Extract(ctx context.Context, path string) (string, error)
+}
+
+// New returns the extractor selected by OCR_PROVIDER, or nil when OCR is off. client may be
+// nil to use the shared httpclient.Default.
+func New(cfg *config.EnvConfig, client httpclient.Doer) Extractor {
+	switch strings.ToLower(cfg.OCRProvider) {
+	case ProviderTesseract:
+		return Tesseract{Binary: cfg.OCRTesseract, Languages: cfg.OCRLanguages}
+	case ProviderOpenAI:
+		return OpenAIVision{APIKey: cfg.OpenAiAPIKey, Model: cfg.OCRModel, Client: client}
+	default:
+		return nil
+	}
+}
+
+// Tesseract runs the tesseract command line tool.
+type Tesseract struct {
+	// Binary is the tesseract executable, looked up in PATH unless it is a path
+	Binary string
+	// Languages is passed as -l, e.g. "eng+deu"; empty uses tesseract's default
+	Languages string
+}
+
+func (t Tesseract) Extract(ctx context.Context, path string) (string, error) {
+	args := []string{path, "stdout"}
+	if t.Languages != "" {
+		args = append(args, "-l", t.Languages)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, t.Binary, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("tesseract failed on %s: %w: %s", path, err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// visionBreaker fails OCR requests fast once OpenAI has failed repeatedly.
+var visionBreaker = breaker.New("openai-vision")
+
+// visionEndpoint is the chat completions URL of the OpenAI API.
+const visionEndpoint = "https://api.openai.com/v1/chat/completions"
+
+// visionPrompt asks for a plain transcription, so the text embeds like the note it belongs to
+const visionPrompt = "Transcribe all text visible in this image, keeping its line breaks. Reply with the text only, and with nothing at all if the image contains no text."
+
+// OpenAIVision asks an OpenAI vision model to transcribe the image.
+type OpenAIVision struct {
+	APIKey string
+	Model  string
+	// Client sends the API requests; nil uses httpclient.Default
+	Client httpclient.Doer
+}
+
+func (ov OpenAIVision) Extract(ctx context.Context, path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	mime := imageTypes[strings.ToLower(filepath.Ext(path))]
+	if mime == "" {
+		return "", fmt.Errorf("unsupported image type: %s", path)
+	}
+
+	reqBody := map[string]any{
+		"model": ov.Model,
+		"messages": []map[string]any{{
+			"role": "user",
+			"content": []map[string]any{
+				{"type": "text", "text": visionPrompt},
+				{"type": "image_url", "image_url": map[string]string{
+					"url": "data:" + mime + ";base64," + base64.StdEncoding.EncodeToString(data),
+				}},
+			},
+		}},
+	}
+	reqBytes, err := json.Marshal(reqBody)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, visionEndpoint, bytes.NewReader(reqBytes))
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+ov.APIKey)
+
+	if err := visionBreaker.Allow(); err != nil {
+		return "", err
+	}
+	resp, err := httpclient.OrDefault(ov.Client).Do(req)
+	visionBreaker.Record(ctx, resp, err)
+	if err != nil {
+		return "", fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return "", fmt.Errorf("%w: OpenAI API returned status %d: %s", vector.ErrRateLimited, resp.StatusCode, string(body))
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status code: %d, body: %s", resp.StatusCode, string(body))
+	}
+
+	var completion struct {
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+		Usage struct {
+			PromptTokens     int `json:"prompt_tokens"`
+			CompletionTokens int `json:"completion_tokens"`
+		} `json:"usage"`
+	}
+	if err := json.Unmarshal(body, &completion); err != nil {
+		return "", fmt.Errorf("failed to parse response: %w", err)
+	}
+	usage.RecordOpenAI(ctx, completion.Usage.PromptTokens, completion.Usage.CompletionTokens)
+	if len(completion.Choices) == 0 {
+		return "", fmt.Errorf("no response from OpenAI")
+	}
+	return strings.TrimSpace(completion.Choices[0].Message.Content), nil
+}
@@ -0,0 +1,299 @@
+// Package probe sends the embedding and chat providers a tiny request every PROBE_INTERVAL
+// and records how long they took and whether they answered, so degraded answers can be
+// matched against the providers' health at the time. The history is kept in a JSON file and
+// reported by /health/providers.
+package probe
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"vex-backend/chat"
+	"vex-backend/config"
+	"vex-backend/httpclient"
+	"vex-backend/usage"
+	"vex-backend/vector/embed"
+)
+
+// checkInterval is how often the prober looks whether a round of probes is due
+const checkInterval = time.Minute
+
+// probeTimeout bounds a single probe; a provider slower than that counts as unavailable
+const probeTimeout = 30 * time.Second
+
+// minRefreshAge is how old a provider's last probe must be before Refresh probes it again,
+// so reloading the health page doesn't spend a request per reload
+const minRefreshAge = time.Minute
+
+// probeText is what the embedder is asked to embed
+const probeText = "vex health probe"
+
+// Provider kinds
+const (
+	KindEmbedder = "embedder"
+	KindChat     = "chat"
+)
+
+// Sample is the outcome of one probe.
+type Sample struct {
+	At        time.Time `json:"at"`
+	OK        bool      `json:"ok"`
+	LatencyMS int64     `json:"latency_ms"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// history is what the prober keeps of a provider.
+type history struct {
+	Kind    string   `json:"kind"`
+	Samples []Sample `json:"samples"`
+	// Day is the UTC date ProbesToday counts the probes of
+	Day         string `json:"day"`
+	ProbesToday int    `json:"probes_today"`
+}
+
+// Report is the health of a provider as its probes saw it.
+type Report struct {
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+	// Available is whether the last probe succeeded
+	Available bool    `json:"available"`
+	Last      *Sample `json:"last,omitempty"`
+	// Availability is the share of the kept probes that succeeded
+	Availability float64 `json:"availability"`
+	// P50MS and P95MS are latency percentiles of the successful probes
+	P50MS       int64    `json:"p50_ms"`
+	P95MS       int64    `json:"p95_ms"`
+	ProbesToday int      `json:"probes_today"`
+	History     []Sample `json:"history"`
+}
+
+// Prober probes the configured providers. Its history survives restarts in a JSON file.
+type Prober struct {
+	cfg       config.Source
+	client    httpclient.Doer
+	statePath string
+
+	// mu guards state and lastRound; rounds serializes the rounds of probes
+	mu        sync.Mutex
+	rounds    sync.Mutex
+	state     map[string]*history
+	lastRound time.Time
+}
+
+// New returns a Prober whose history is kept at statePath. A missing file means nothing was
+// probed yet.
+func New(cfg config.Source, client httpclient.Doer, statePath string) (*Prober, error) {
+	p := &Prober{cfg: cfg, client: client, statePath: statePath, state: map[string]*history{}}
+	data, err := os.ReadFile(statePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return p, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &p.state); err != nil {
+		return nil, fmt.Errorf("failed to read the provider health history %s: %w", statePath, err)
+	}
+	return p, nil
+}
+
+// Run probes the providers every PROBE_INTERVAL until ctx is done. The interval is re-read
+// on every check, so probes can be turned on or off by a config reload.
+func (p *Prober) Run(ctx context.Context) {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+
+	for {
+		interval := p.cfg().ProbeInterval
+		p.mu.Lock()
+		due := interval > 0 && time.Since(p.lastRound) >= interval
+		p.mu.Unlock()
+		if due {
+			p.probe(ctx, 0)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Refresh probes the providers whose last probe is older than a minute, within the daily
+// limit, and returns their reports.
+func (p *Prober) Refresh(ctx context.Context) []Report {
+	p.probe(ctx, minRefreshAge)
+	return p.Reports()
+}
+
+// target is a provider and how to probe it
+type target struct {
+	name, kind string
+	call       func(ctx context.Context) error
+}
+
+// targets are the providers currently configured: the embedder of EMBED_PROVIDER and each
+// chat provider of CHAT_PROVIDER.
+func (p *Prober) targets(cfg *config.EnvConfig) []target {
+	var out []target
+	out = append(out, target{name: "embed:" + cfg.EmbedProvider, kind: KindEmbedder, call: func(ctx context.Context) error {
+		e, err := embed.New(p.cfg, p.client, nil)
+		if err != nil {
+			return err
+		}
+		_, err = e.EmbedToVector(ctx, probeText)
+		return err
+	}})
+	for _, name := range cfg.ChatProviders() {
+		name := name
+		out = append(out, target{name: "chat:" + name, kind: KindChat, call: func(ctx context.Context) error {
+			return chat.Ping(ctx, cfg, p.client, name)
+		}})
+	}
+	return out
+}
+
+// probe runs one round, skipping providers probed less than minAge ago and those that used
+// up PROBE_DAILY_LIMIT.
+func (p *Prober) probe(ctx context.Context, minAge time.Duration) {
+	p.rounds.Lock()
+	defer p.rounds.Unlock()
+
+	cfg := p.cfg()
+	// probes are a cost of their own in /usage
+	ctx = usage.WithSource(ctx, "probe")
+	for _, t := range p.targets(cfg) {
+		if !p.reserve(t, cfg.ProbeDailyLimit, minAge) {
+			continue
+		}
+		probeCtx, cancel := context.WithTimeout(ctx, probeTimeout)
+		start := time.Now()
+		err := t.call(probeCtx)
+		cancel()
+
+		s := Sample{At: start.UTC(), OK: err == nil, LatencyMS: time.Since(start).Milliseconds()}
+		if err != nil {
+			s.Error = err.Error()
+			log.Printf("[Probe] %s failed after %dms: %v", t.name, s.LatencyMS, err)
+		}
+		p.record(t.name, s, cfg.ProbeHistory)
+	}
+
+	p.mu.Lock()
+	p.lastRound = time.Now()
+	p.saveLocked()
+	p.mu.Unlock()
+}
+
+// reserve counts a probe of t against today's limit, reporting false if the limit is used up
+// or t was probed less than minAge ago.
+func (p *Prober) reserve(t target, limit int, minAge time.Duration) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	h := p.state[t.name]
+	if h == nil {
+		h = &history{}
+		p.state[t.name] = h
+	}
+	h.Kind = t.kind
+	if n := len(h.Samples); n > 0 && time.Since(h.Samples[n-1].At) < minAge {
+		return false
+	}
+	if today := time.Now().UTC().Format(time.DateOnly); h.Day != today {
+		h.Day, h.ProbesToday = today, 0
+	}
+	if limit > 0 && h.ProbesToday >= limit {
+		return false
+	}
+	h.ProbesToday++
+	if h.ProbesToday == limit {
+		log.Printf("[Probe] %s reached PROBE_DAILY_LIMIT (%d), not probing it again today", t.name, limit)
+	}
+	return true
+}
+
+// record appends s to the history of name, keeping the newest keep samples.
+func (p *Prober) record(name string, s Sample, keep int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	h := p.state[name]
+	h.Samples = append(h.Samples, s)
+	if keep > 0 && len(h.Samples) > keep {
+		h.Samples = h.Samples[len(h.Samples)-keep:]
+	}
+}
+
+// Reports returns the health of every provider probed so far, sorted by name.
+func (p *Prober) Reports() []Report {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	out := make([]Report, 0, len(p.state))
+	for name, h := range p.state {
+		r := Report{Name: name, Kind: h.Kind, ProbesToday: h.ProbesToday, History: append([]Sample{}, h.Samples...)}
+		if r.ProbesToday > 0 && h.Day != time.Now().UTC().Format(time.DateOnly) {
+			r.ProbesToday = 0
+		}
+		if n := len(h.Samples); n > 0 {
+			last := h.Samples[n-1]
+			r.Last, r.Available = &last, last.OK
+		}
+		var latencies []int64
+		for _, s := range h.Samples {
+			if s.OK {
+				latencies = append(latencies, s.LatencyMS)
+			}
+		}
+		if len(h.Samples) > 0 {
+			r.Availability = float64(len(latencies)) / float64(len(h.Samples))
+		}
+		r.P50MS, r.P95MS = percentile(latencies, 0.5), percentile(latencies, 0.95)
+		out = append(out, r)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// percentile returns the q-th percentile of values by the nearest-rank method, 0 for none.
+func percentile(values []int64, q float64) int64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]int64{}, values...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	rank := int(q*float64(len(sorted))+0.5) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
+}
+
+func (p *Prober) saveLocked() {
+	data, err := json.MarshalIndent(p.state, "", "  ")
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(p.statePath), 0o755)
+	}
+	if err == nil {
+		tmp := p.statePath + ".tmp"
+		if err = os.WriteFile(tmp, data, 0o644); err == nil {
+			err = os.Rename(tmp, p.statePath)
+		}
+	}
+	if err != nil {
+		log.Printf("[Probe] failed to save the provider health history: %v", err)
+	}
+}
@@ -0,0 +1,186 @@
+// Package redact removes secrets and personal data from note content before it is sent to
+// the embedding API and stored, replacing each match with a placeholder naming the rule.
+package redact
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"vex-backend/config"
+)
+
+// secretGroup names the capture group a rule may use to redact only part of its match,
+// e.g. the value of "password: hunter2" but not the "password:" label
+const secretGroup = "secret"
+
+// Rule replaces every match of Pattern with Placeholder(Name).
+type Rule struct {
+	Name    string
+	Pattern *regexp.Regexp
+}
+
+// builtinRules cover the secrets and personal data most likely to end up in notes.
+var builtinRules = []Rule{
+	{"private_key", regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`)},
+	{"aws_access_key", regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{"github_token", regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,})\b`)},
+	{"api_key", regexp.MustCompile(`\b(?:sk|pk|rk)-[A-Za-z0-9_-]{20,}`)},
+	{"slack_token", regexp.MustCompile(`\bxox[abposr]-[A-Za-z0-9-]{10,}`)},
+	{"jwt", regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+`)},
+	{"password", regexp.MustCompile(`(?i)\b(?:password|passwd|pwd|secret|api[_-]?key|access[_-]?token)\b["']?\s*[:=]\s*["']?(?P<secret>[^\s"'\[][^\s"']{3,})`)},
+	{"email", regexp.MustCompile(`\b[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}\b`)},
+}
+
+// Placeholder is the text that replaces a match of the named rule.
+func Placeholder(name string) string {
+	return "[REDACTED:" + name + "]"
+}
+
+// Rules returns the rules cfg enables: none with REDACT off, otherwise the built-in rules
+// followed by the REDACT_RULES lines. Lines are validated with the config, so invalid ones
+// can only come from an unvalidated config and are skipped.
+func Rules(cfg *config.EnvConfig) []Rule {
+	if cfg == nil || !cfg.Redact {
+		return nil
+	}
+	rules := append([]Rule(nil), builtinRules...)
+	for _, line := range strings.Split(cfg.RedactRules, "\n") {
+		name, expr, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if !ok {
+			continue
+		}
+		re, err := regexp.Compile(strings.TrimSpace(expr))
+		if err != nil {
+			continue
+		}
+		rules = append(rules, Rule{Name: strings.TrimSpace(name), Pattern: re})
+	}
+	return rules
+}
+
+// QueryRules returns the rules for queries kept in logs: those of Rules with REDACT on,
+// since queries are redacted even with REDACT off, which is about the notes.
+func QueryRules(cfg *config.EnvConfig) []Rule {
+	if cfg == nil {
+		return nil
+	}
+	withRedact := *cfg
+	withRedact.Redact = true
+	return Rules(&withRedact)
+}
+
+// Apply runs the rules over text in order and returns the redacted text with the number of
+// matches per rule. A rule with a "secret" group only replaces that group.
+func Apply(text string, rules []Rule) (string, map[string]int) {
+	counts := map[string]int{}
+	for _, rule := range rules {
+		group := rule.Pattern.SubexpIndex(secretGroup)
+		matches := rule.Pattern.FindAllStringSubmatchIndex(text, -1)
+		if len(matches) == 0 {
+			continue
+		}
+
+		var b strings.Builder
+		last := 0
+		for _, m := range matches {
+			start, end := m[0], m[1]
+			if group > 0 {
+				start, end = m[2*group], m[2*group+1]
+			}
+			if start < 0 {
+				continue
+			}
+			b.WriteString(text[last:start])
+			b.WriteString(Placeholder(rule.Name))
+			last = end
+			counts[rule.Name]++
+		}
+		b.WriteString(text[last:])
+		text = b.String()
+	}
+	return text, counts
+}
+
+// Entry is one line of the audit log: how often a rule matched in one chunk. The redacted
+// values themselves are never recorded.
+type Entry struct {
+	Time       string `json:"time"`
+	Filepath   string `json:"filepath,omitempty"`
+	ChunkIndex int    `json:"chunk_index"`
+	Rule       string `json:"rule"`
+	Count      int    `json:"count"`
+}
+
+// Redactor applies the configured rules and appends what it redacted to a JSONL audit log.
+type Redactor struct {
+	cfg     config.Source
+	logPath string
+
+	mu sync.Mutex
+}
+
+// New returns a Redactor following cfg, so REDACT and REDACT_RULES can be reloaded, that
+// appends to the audit log at logPath.
+func New(cfg config.Source, logPath string) *Redactor {
+	return &Redactor{cfg: cfg, logPath: logPath}
+}
+
+// Redact returns chunk i of the file at path with its secrets replaced by placeholders. A
+// nil Redactor returns the chunk unchanged.
+func (r *Redactor) Redact(chunk string, path string, i int) string {
+	if r == nil {
+		return chunk
+	}
+	redacted, counts := Apply(chunk, Rules(r.cfg()))
+	if len(counts) == 0 {
+		return redacted
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	entries := make([]Entry, 0, len(counts))
+	for name, n := range counts {
+		entries = append(entries, Entry{Time: now, Filepath: path, ChunkIndex: i, Rule: name, Count: n})
+	}
+	sort.Slice(entries, func(a, b int) bool { return entries[a].Rule < entries[b].Rule })
+	if err := r.appendLog(entries); err != nil {
+		log.Printf("[Redact] failed to write audit log: %v", err)
+	}
+	return redacted
+}
+
+// RedactContext returns text stored alongside a chunk, such as its sentence window, with its
+// secrets replaced by placeholders, without logging them: the text is made of chunks whose
+// own redactions are logged. A nil Redactor returns the text unchanged.
+func (r *Redactor) RedactContext(text string) string {
+	if r == nil {
+		return text
+	}
+	redacted, _ := Apply(text, Rules(r.cfg()))
+	return redacted
+}
+
+// appendLog appends entries to the audit log, one JSON object per line.
+func (r *Redactor) appendLog(entries []Entry) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	f, err := os.OpenFile(r.logPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	enc := json.NewEncoder(f)
+	for _, e := range entries {
+		if err := enc.Encode(e); err != nil {
+			return fmt.Errorf("failed to append %s entry: %w", e.Rule, err)
+		}
+	}
+	return nil
+}
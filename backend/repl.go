@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+
+	"vex-backend/chat"
+	"vex-backend/vector"
+)
+
+const replHelp = `Ask a question about your notes, or enter a command:
+  /sources  show excerpts of the sources of the last answer
+  /reset    forget the conversation so far
+  /help     show this help
+  /quit     leave (as does Ctrl-D)
+Ctrl-C stops an answer that is being written.`
+
+// runChat opens an interactive conversation about the notes in the terminal. Answers are
+// printed as they are generated, followed by the sources they were given from.
+func runChat(args []string) error {
+	fs, verbose := newFlagSet("chat", "chat [flags]")
+	filters := addFilterFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	setVerbose(*verbose)
+
+	a, err := newApp()
+	if err != nil {
+		return err
+	}
+	cfg := a.cfg()
+	opts, err := filters.options(cfg.CloneFolder)
+	if err != nil {
+		return err
+	}
+	session, err := chat.NewSession(cfg, a.client, a.vectors, opts)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(replHelp)
+	var sources []vector.VectorData
+	in := bufio.NewScanner(os.Stdin)
+	for {
+		fmt.Print("\n> ")
+		if !in.Scan() {
+			fmt.Println()
+			return in.Err()
+		}
+		line := strings.TrimSpace(in.Text())
+
+		switch line {
+		case "":
+			continue
+		case "/quit", "/exit":
+			return nil
+		case "/help":
+			fmt.Println(replHelp)
+			continue
+		case "/reset":
+			session.Reset()
+			sources = nil
+			fmt.Println("Conversation cleared.")
+			continue
+		case "/sources":
+			printSourceExcerpts(sources, a.repo.Path())
+			continue
+		}
+		if strings.HasPrefix(line, "/") {
+			fmt.Printf("Unknown command %s, see /help.\n", line)
+			continue
+		}
+
+		// Ctrl-C cancels this answer only, not the session
+		ctx, stop := signal.NotifyContext(cliContext(), os.Interrupt)
+		turn, err := session.Ask(ctx, line, func(delta string) {
+			fmt.Print(delta)
+		})
+		stop()
+		fmt.Println()
+		if err != nil {
+			if errors.Is(err, context.Canceled) {
+				fmt.Println("(stopped)")
+			} else {
+				fmt.Printf("error: %v\n", err)
+			}
+			continue
+		}
+
+		sources = turn.Sources
+		if len(sources) > 0 {
+			fmt.Println("\nSources:")
+			for i, src := range sources {
+				fmt.Printf("  [%d] %s  %.3f\n", i+1, displayPath(a.repo.Path(), src.Metadata["filepath"]), src.Similarity)
+			}
+		}
+	}
+}
+
+// printSourceExcerpts shows the beginning of each source of the last answer.
+func printSourceExcerpts(sources []vector.VectorData, root string) {
+	if len(sources) == 0 {
+		fmt.Println("No sources yet.")
+		return
+	}
+	for i, src := range sources {
+		fmt.Printf("[%d] %s\n    %s\n", i+1, displayPath(root, src.Metadata["filepath"]), excerpt(src.Content, 300))
+	}
+}
@@ -3,26 +3,104 @@ package routes
 import (
 	"net/http"
 
+	"vex-backend/analytics"
+	"vex-backend/catalog"
+	"vex-backend/config"
+	"vex-backend/digest"
+	"vex-backend/experiment"
+	"vex-backend/feedback"
+	"vex-backend/feeds"
+	"vex-backend/git"
 	"vex-backend/handlers"
+	"vex-backend/httpclient"
+	"vex-backend/ingestion"
+	"vex-backend/manifest"
 	"vex-backend/middleware"
+	"vex-backend/probe"
+	"vex-backend/snapshot"
+	"vex-backend/suggest"
+	"vex-backend/topics"
 	vectormgr "vex-backend/vector/manager"
 )
 
 // RegisterRoutes accepts a single Manager instance which is passed into handler constructors.
 // This lets us create the embedder/manager once in main and reuse it across handlers.
-func RegisterRoutes(m vectormgr.Manager) *http.ServeMux {
+// The indexing manifest is shared the same way between the webhook and /resync.
+// cfg is read per request by handlers with reloadable settings; everything else is fixed
+// from its value at registration. client is shared by every handler calling an external API,
+// and repo and dg are shared with the background digest scheduler, fw with the background
+// feed polls, ing with the followers of ingestion sources and pr with the scheduled provider
+// probes. cat is the note catalog kept up to date by m.
+func RegisterRoutes(cfg config.Source, client httpclient.Doer, repo *git.Repo, m vectormgr.Manager, man *manifest.Manifest, dg *digest.Generator, fw *feeds.Watcher, ing *ingestion.Runner, pr *probe.Prober, exp *experiment.Tracker, fb *feedback.Store, hist *analytics.History, cat *catalog.Catalog) *http.ServeMux {
 	mux := http.NewServeMux()
+	requireAPIKey := middleware.APIKeyAuth(cfg)
+	// read-only SHARED_API_KEYS are admitted on the read routes, which only see shared notes
+	allowSharedKey := middleware.SharedAPIKeyAuth(cfg)
+	// LLM-backed routes get a bounded number of concurrent requests each; indexing isn't
+	// limited, since a rejected webhook would lose the push
+	limit := func(route string, h http.Handler) http.Handler {
+		return middleware.ConcurrencyLimit(cfg, route)(h)
+	}
+	m = vectormgr.WithAccessControl(m)
 
 	// handlers.GitWebhookHandler and handlers.QueryHandler are expected to be functions that
 	// take a vectormgr.Manager and return an http.HandlerFunc.
-	mux.HandleFunc("/git-webhook", handlers.GitWebhookHandler(m))
+	mux.HandleFunc("/git-webhook", handlers.GitWebhookHandler(cfg, client, repo, m, man))
+	// Retrying failed/pending files is protected like /query.
+	mux.Handle("/resync", requireAPIKey(handlers.ResyncHandler(cfg, client, repo, m, man)))
 	// Protect the /query route with the API key middleware.
-	mux.Handle("/query", middleware.RequireAPIKey(handlers.QueryHandler(m)))
-	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte(`{"status":"healthy","service":"vex-backend"}`))
-	})
+	mux.Handle("/query", allowSharedKey(limit("/query", handlers.QueryHandler(cfg, client, m, exp, fb, hist))))
+	mux.Handle("/search", allowSharedKey(handlers.SearchHandler(cfg, m)))
+	mux.Handle("/feedback", allowSharedKey(handlers.FeedbackHandler(fb, exp)))
+	mux.Handle("/import/notion", requireAPIKey(handlers.NotionImportHandler(cfg, client, m)))
+	mux.Handle("/import/confluence", requireAPIKey(handlers.ConfluenceImportHandler(cfg, client, m)))
+	mux.Handle("/import/imap", requireAPIKey(handlers.IMAPImportHandler(cfg, m)))
+	mux.Handle("/import/slack", requireAPIKey(handlers.SlackImportHandler(cfg, m)))
+	mux.Handle("/ingest/sources", requireAPIKey(handlers.IngestSourcesHandler(cfg, client, ing)))
+	mux.Handle("/ingest/url", requireAPIKey(handlers.IngestURLHandler(cfg, client, m)))
+	mux.Handle("/admin/feeds", requireAPIKey(handlers.FeedsHandler(fw)))
+	mux.Handle("/admin/retry-failed", requireAPIKey(handlers.RetryFailedHandler(cfg, client, repo, m, man)))
+	mux.Handle("/admin/reindex", requireAPIKey(handlers.ReindexHandler(cfg, client, repo, m, man)))
+	mux.Handle("/admin/collections", requireAPIKey(handlers.CollectionsHandler(m)))
+	mux.Handle("/admin/reload-config", requireAPIKey(handlers.ReloadConfigHandler()))
+	mux.Handle("/admin/audit", requireAPIKey(handlers.AuditHandler()))
+	mux.Handle("/admin/drift", requireAPIKey(handlers.DriftHandler(cfg, m)))
+	mux.Handle("/admin/experiments", requireAPIKey(handlers.ExperimentsHandler(exp)))
+	feedbackList := requireAPIKey(handlers.FeedbackListHandler(fb))
+	mux.Handle("/admin/feedback", feedbackList)
+	mux.Handle("/admin/feedback/", feedbackList)
+	mux.Handle("/admin/feedback/summary", requireAPIKey(handlers.FeedbackSummaryHandler(fb)))
+	mux.Handle("/admin/eval", requireAPIKey(limit("/admin/eval", handlers.EvalHandler(cfg, client, repo, m))))
+	mux.Handle("/admin/trash", requireAPIKey(handlers.TrashHandler(cfg, m)))
+	mux.Handle("/admin/restore", requireAPIKey(handlers.RestoreHandler(repo, m)))
+	snapshots := snapshot.New(cfg, m)
+	mux.Handle("/admin/snapshot", requireAPIKey(handlers.SnapshotHandler(snapshots)))
+	mux.Handle("/admin/snapshot/restore", requireAPIKey(handlers.SnapshotRestoreHandler(snapshots)))
+	mux.Handle("/chunk", allowSharedKey(handlers.ChunkExcerptHandler(cfg().CloneFolder, m)))
+	mux.Handle("/summarize", requireAPIKey(limit("/summarize", handlers.SummarizeHandler(cfg, client, repo, m))))
+	mux.Handle("/related", allowSharedKey(handlers.RelatedHandler(repo, m)))
+	mux.Handle("/topics", requireAPIKey(limit("/topics", handlers.TopicsHandler(topics.New(cfg, client, m)))))
+	mux.Handle("/suggest", allowSharedKey(limit("/suggest", handlers.SuggestHandler(suggest.New(cfg, client, m, cat)))))
+	mux.Handle("/digest", requireAPIKey(limit("/digest", handlers.DigestHandler(dg))))
+	mux.Handle("/dedup", requireAPIKey(handlers.DedupHandler(cfg, m)))
+	mux.Handle("/stats", requireAPIKey(handlers.StatsHandler(m)))
+	mux.Handle("/metrics", requireAPIKey(handlers.MetricsHandler(m)))
+	mux.Handle("/catalog", requireAPIKey(handlers.CatalogHandler(cat)))
+	mux.Handle("/documents/", requireAPIKey(handlers.DocumentStatusHandler(repo, man, cat)))
+	mux.Handle("/typeahead", allowSharedKey(handlers.TypeaheadHandler(cat)))
+	mux.Handle("/quick-search", allowSharedKey(handlers.QuickSearchHandler(cfg, repo, cat)))
+	// Obsidian Local REST API compatible search and note fetch, for Obsidian plugins
+	obsidianSearch := allowSharedKey(handlers.ObsidianSearchHandler(cfg, repo, m))
+	mux.Handle("/search/simple", obsidianSearch)
+	mux.Handle("/search/simple/", obsidianSearch)
+	mux.Handle("/vault/", allowSharedKey(handlers.ObsidianNoteHandler(repo, m)))
+	mux.Handle("/usage", requireAPIKey(handlers.UsageHandler()))
+	mux.Handle("/analytics/top-queries", requireAPIKey(handlers.TopQueriesHandler(cfg, hist)))
+	mux.Handle("/analytics/zero-results", requireAPIKey(handlers.ZeroResultQueriesHandler(cfg, hist)))
+	mux.Handle("/analytics/similarity", requireAPIKey(handlers.QuerySimilarityHandler(cfg, hist)))
+	mux.HandleFunc("/health", handlers.HealthHandler())
+	mux.HandleFunc("/ready", handlers.ReadyHandler())
+	mux.Handle("/health/providers", requireAPIKey(handlers.ProviderHealthHandler(cfg, pr)))
 
 	// Serve the portal template at /portal (and also at /portal/).
 	mux.HandleFunc("/portal", handlers.PortalHandler())
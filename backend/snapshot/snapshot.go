@@ -0,0 +1,209 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"vex-backend/config"
+	"vex-backend/vector"
+	vectormgr "vex-backend/vector/manager"
+)
+
+const (
+	// fileSuffix marks snapshot files; chromem skips files with it if the snapshot folder
+	// sits inside the vector storage folder
+	fileSuffix = ".snapshot.gz"
+	// nameFormat names snapshots by their creation time, so names sort chronologically
+	nameFormat = "20060102T150405.000000000Z"
+)
+
+// Info describes a stored snapshot.
+type Info struct {
+	Name      string `json:"name"`
+	CreatedAt string `json:"created_at"`
+	Size      int64  `json:"size_bytes"`
+	// Label is an optional note given at creation, e.g. "pre-restore"
+	Label string `json:"label,omitempty"`
+}
+
+// Store creates, lists and restores snapshots of a manager's contents, kept as files in
+// SNAPSHOT_FOLDER.
+type Store struct {
+	cfg config.Source
+	m   vectormgr.Manager
+
+	// mu serializes snapshot operations, so a restore never reads a half-written snapshot
+	mu sync.Mutex
+}
+
+// New returns a Store for m.
+func New(cfg config.Source, m vectormgr.Manager) *Store {
+	return &Store{cfg: cfg, m: m}
+}
+
+// Create writes a snapshot of every stored and trashed chunk. The file is written to a
+// temporary name and renamed, so a failed snapshot never shows up in List. The oldest
+// snapshots beyond SNAPSHOT_KEEP are removed afterwards.
+func (s *Store) Create(ctx context.Context, label string) (Info, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.create(ctx, label)
+}
+
+func (s *Store) create(ctx context.Context, label string) (Info, error) {
+	dir := s.cfg().SnapshotDir()
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return Info{}, fmt.Errorf("failed to create snapshot folder: %w", err)
+	}
+
+	name := time.Now().UTC().Format(nameFormat)
+	if label = sanitizeLabel(label); label != "" {
+		name += "_" + label
+	}
+	path := filepath.Join(dir, name+fileSuffix)
+
+	tmp, err := os.CreateTemp(dir, ".snapshot-*")
+	if err != nil {
+		return Info{}, fmt.Errorf("failed to create snapshot file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := s.m.Export(ctx, tmp); err != nil {
+		tmp.Close()
+		return Info{}, fmt.Errorf("failed to export snapshot: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return Info{}, err
+	}
+	if err := tmp.Close(); err != nil {
+		return Info{}, err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return Info{}, fmt.Errorf("failed to store snapshot: %w", err)
+	}
+
+	s.prune(dir)
+	return describe(path)
+}
+
+// List returns the stored snapshots, newest first.
+func (s *Store) List() ([]Info, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.list(s.cfg().SnapshotDir())
+}
+
+func (s *Store) list(dir string) ([]Info, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return []Info{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	out := []Info{}
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), fileSuffix) {
+			continue
+		}
+		info, err := describe(filepath.Join(dir, e.Name()))
+		if err != nil {
+			continue
+		}
+		out = append(out, info)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name > out[j].Name })
+	return out, nil
+}
+
+// Restore replaces the store's contents with the named snapshot. A "pre-restore" snapshot
+// of the current contents is taken first, so a restore can itself be undone. The manager
+// only swaps in the snapshot once it was read completely.
+func (s *Store) Restore(ctx context.Context, name string) (Info, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dir := s.cfg().SnapshotDir()
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return Info{}, fmt.Errorf("invalid snapshot name %q: %w", name, vector.ErrNotFound)
+	}
+	path := filepath.Join(dir, name+fileSuffix)
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return Info{}, fmt.Errorf("snapshot %q: %w", name, vector.ErrNotFound)
+	}
+	if err != nil {
+		return Info{}, err
+	}
+	defer f.Close()
+	info, err := describe(path)
+	if err != nil {
+		return Info{}, err
+	}
+
+	backup, err := s.create(ctx, "pre-restore")
+	if err != nil {
+		return Info{}, fmt.Errorf("failed to snapshot current state before restoring: %w", err)
+	}
+	log.Printf("[Snapshot] saved current state as %s before restoring %s", backup.Name, name)
+
+	if err := s.m.Import(ctx, f); err != nil {
+		return Info{}, err
+	}
+	return info, nil
+}
+
+// prune removes the oldest snapshots beyond SNAPSHOT_KEEP.
+func (s *Store) prune(dir string) {
+	keep := s.cfg().SnapshotKeep
+	infos, err := s.list(dir)
+	if err != nil || keep <= 0 || len(infos) <= keep {
+		return
+	}
+	for _, info := range infos[keep:] {
+		if err := os.Remove(filepath.Join(dir, info.Name+fileSuffix)); err != nil {
+			log.Printf("[Snapshot] warning: failed to remove old snapshot %s: %v", info.Name, err)
+		}
+	}
+}
+
+// describe returns the Info of the snapshot file at path.
+func describe(path string) (Info, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return Info{}, err
+	}
+	name := strings.TrimSuffix(filepath.Base(path), fileSuffix)
+	stamp, label, _ := strings.Cut(name, "_")
+	info := Info{Name: name, Size: fi.Size(), Label: label}
+	if t, err := time.Parse(nameFormat, stamp); err == nil {
+		info.CreatedAt = t.Format(time.RFC3339)
+	}
+	return info, nil
+}
+
+// sanitizeLabel keeps the letters, digits and dashes of label, so it is safe in a file name.
+func sanitizeLabel(label string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(label) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-':
+			b.WriteRune(r)
+		case r == ' ' || r == '_':
+			b.WriteRune('-')
+		}
+	}
+	return strings.Trim(b.String(), "-")
+}
@@ -0,0 +1,160 @@
+// Package suggest generates example questions the knowledge base can answer, for clients
+// to offer before the user has asked anything.
+package suggest
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"vex-backend/access"
+	"vex-backend/catalog"
+	"vex-backend/chat"
+	"vex-backend/config"
+	"vex-backend/httpclient"
+	"vex-backend/usage"
+	vectormgr "vex-backend/vector/manager"
+)
+
+const (
+	// recentNotes is how many of the most recently changed notes are sampled
+	recentNotes = 6
+	// randomNotes is how many other notes are sampled at random, so older notes get a turn
+	randomNotes = 4
+	// maxAge is how long a set of suggestions is served before it is generated again
+	maxAge = time.Hour
+)
+
+// Question is a suggested question and the note answering it.
+type Question struct {
+	Question string `json:"question"`
+	Filepath string `json:"filepath"`
+	Title    string `json:"title"`
+}
+
+// Suggestions are the example questions for one caller scope.
+type Suggestions struct {
+	Questions   []Question `json:"questions"`
+	GeneratedAt string     `json:"generated_at"`
+	Provider    string     `json:"provider,omitempty"`
+
+	generated time.Time
+}
+
+// key identifies cached suggestions: callers with shared access must only be shown
+// questions about shared notes.
+type key struct {
+	scope access.Scope
+	n     int
+}
+
+// Cache generates suggestions and keeps them per scope and count for an hour, since the
+// portal asks for them on every visit and each set costs an LLM call.
+type Cache struct {
+	cfg    config.Source
+	client httpclient.Doer
+	m      vectormgr.Manager
+	cat    *catalog.Catalog
+
+	// mu also serializes generation, so concurrent visits don't each ask the LLM
+	mu          sync.Mutex
+	suggestions map[key]Suggestions
+}
+
+// New returns an empty Cache. m must be access controlled, so that excerpts are only read
+// from notes the caller may see.
+func New(cfg config.Source, client httpclient.Doer, m vectormgr.Manager, cat *catalog.Catalog) *Cache {
+	return &Cache{
+		cfg:         cfg,
+		client:      client,
+		m:           m,
+		cat:         cat,
+		suggestions: map[key]Suggestions{},
+	}
+}
+
+// Get returns up to n suggested questions for the caller of ctx. Cached suggestions are
+// returned unless refresh is set or they are older than an hour.
+func (c *Cache) Get(ctx context.Context, n int, refresh bool) (Suggestions, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	k := key{scope: access.ScopeFrom(ctx), n: n}
+	if s, ok := c.suggestions[k]; ok && !refresh && time.Since(s.generated) < maxAge {
+		return s, nil
+	}
+
+	s, err := c.generate(usage.WithSource(ctx, "suggest"), n)
+	if err != nil {
+		return Suggestions{}, err
+	}
+	c.suggestions[k] = s
+	return s, nil
+}
+
+// generate samples notes visible to the caller of ctx and asks the LLM for questions on them.
+func (c *Cache) generate(ctx context.Context, n int) (Suggestions, error) {
+	docs, err := c.sample(ctx)
+	if err != nil {
+		return Suggestions{}, err
+	}
+
+	notes := make([]chat.NoteExcerpt, 0, len(docs))
+	sampled := make([]catalog.Document, 0, len(docs))
+	for _, doc := range docs {
+		if len(doc.ChunkIDs) == 0 {
+			continue
+		}
+		chunk, err := c.m.GetByID(ctx, doc.ChunkIDs[0])
+		if err != nil {
+			// removed since the catalog was read, or not visible after all
+			continue
+		}
+		notes = append(notes, chat.NoteExcerpt{Title: doc.Title, Excerpt: chunk.Content})
+		sampled = append(sampled, doc)
+	}
+
+	questions, provider, err := chat.SuggestQuestions(ctx, c.cfg(), c.client, notes, n)
+	if err != nil {
+		return Suggestions{}, err
+	}
+
+	now := time.Now()
+	s := Suggestions{
+		Questions:   make([]Question, 0, len(questions)),
+		GeneratedAt: now.UTC().Format(time.RFC3339),
+		Provider:    provider,
+		generated:   now,
+	}
+	for _, q := range questions {
+		doc := sampled[q.Note]
+		s.Questions = append(s.Questions, Question{Question: q.Question, Filepath: doc.Path, Title: doc.Title})
+	}
+	return s, nil
+}
+
+// sample returns the most recently changed notes visible to the caller of ctx, followed by
+// a few others picked at random.
+func (c *Cache) sample(ctx context.Context) ([]catalog.Document, error) {
+	docs, _, err := c.cat.List(catalog.Query{Sort: "modified"})
+	if err != nil {
+		return nil, err
+	}
+	visible := docs[:0]
+	for _, doc := range docs {
+		if access.Visible(ctx, map[string]string{access.MetadataKey: doc.Access}) {
+			visible = append(visible, doc)
+		}
+	}
+
+	if len(visible) <= recentNotes+randomNotes {
+		return visible, nil
+	}
+	out := append([]catalog.Document{}, visible[:recentNotes]...)
+	rest := visible[recentNotes:]
+	for _, i := range rand.Perm(len(rest))[:randomNotes] {
+		out = append(out, rest[i])
+	}
+	return out, nil
+}
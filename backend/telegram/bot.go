@@ -0,0 +1,239 @@
+// Package telegram answers questions sent to a Telegram bot from the notes, like /query
+// does, so the vault can be asked from a phone. Updates are fetched by long polling, so the
+// server needs no public URL for the bot.
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"vex-backend/chat"
+	"vex-backend/config"
+	"vex-backend/httpclient"
+	"vex-backend/usage"
+	vectormgr "vex-backend/vector/manager"
+)
+
+// apiURL is the Bot API, followed by the bot's token and the method
+const apiURL = "https://api.telegram.org/bot"
+
+// pollTimeout is how long a getUpdates call waits for a message; checkDependencies keeps
+// HTTP_TIMEOUT above it
+const pollTimeout = config.TelegramPollTimeout
+
+// retryDelay is how long polling pauses after getUpdates failed
+const retryDelay = 5 * time.Second
+
+// maxMessageLength is the most characters Telegram accepts in a message
+const maxMessageLength = 4096
+
+// helpText answers /start and /help
+const helpText = "Ask me anything about your notes and I'll answer from them, naming the notes I used."
+
+// Bot polls Telegram for messages to the bot of TELEGRAM_BOT_TOKEN and answers those of the
+// TELEGRAM_ALLOWED_USERS from the notes in m.
+type Bot struct {
+	cfg    config.Source
+	client httpclient.Doer
+	m      vectormgr.Manager
+	// offset is the ID of the next update to fetch, confirming those before it
+	offset int64
+}
+
+// New returns a Bot answering from m; client (nil for the shared default) talks to both
+// Telegram and the LLM.
+func New(cfg config.Source, client httpclient.Doer, m vectormgr.Manager) *Bot {
+	return &Bot{cfg: cfg, client: client, m: m}
+}
+
+type user struct {
+	ID       int64  `json:"id"`
+	Username string `json:"username"`
+}
+
+type message struct {
+	MessageID int64 `json:"message_id"`
+	From      *user `json:"from"`
+	Chat      struct {
+		ID int64 `json:"id"`
+	} `json:"chat"`
+	Text string `json:"text"`
+}
+
+type update struct {
+	UpdateID int64    `json:"update_id"`
+	Message  *message `json:"message"`
+}
+
+// Run answers messages one at a time until ctx is done.
+func (b *Bot) Run(ctx context.Context) {
+	log.Printf("[Telegram] polling for messages")
+	for ctx.Err() == nil {
+		updates, err := b.getUpdates(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("[Telegram] polling failed, retrying in %s: %v", retryDelay, err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(retryDelay):
+			}
+			continue
+		}
+		for _, u := range updates {
+			b.offset = u.UpdateID + 1
+			if u.Message != nil && u.Message.From != nil && u.Message.Text != "" {
+				b.handle(ctx, u.Message)
+			}
+		}
+	}
+}
+
+// handle answers msg, or tells its sender they aren't allowed to ask.
+func (b *Bot) handle(ctx context.Context, msg *message) {
+	cfg := b.cfg()
+	if !slices.Contains(cfg.TelegramAllowedUserIDs(), msg.From.ID) {
+		log.Printf("[Telegram] refusing user %d (@%s), who isn't in TELEGRAM_ALLOWED_USERS", msg.From.ID, msg.From.Username)
+		b.reply(ctx, msg, fmt.Sprintf("You are not allowed to use this bot. Your user ID is %d.", msg.From.ID))
+		return
+	}
+
+	text := strings.TrimSpace(msg.Text)
+	if command, _, _ := strings.Cut(text, " "); command == "/start" || command == "/help" {
+		b.reply(ctx, msg, helpText)
+		return
+	}
+
+	// the typing indicator is cosmetic, so failing to show it doesn't matter
+	b.call(ctx, "sendChatAction", map[string]any{"chat_id": msg.Chat.ID, "action": "typing"}, nil)
+	log.Printf("[Telegram] answering %q from user %d", text, msg.From.ID)
+	qctx := usage.WithSource(ctx, "telegram")
+	result, err := chat.ProcessQuery(qctx, cfg, b.client, b.m, text, chat.QueryOptions{Format: chat.FormatJSON})
+	if err != nil {
+		log.Printf("[Telegram] ProcessQuery error: %v", err)
+		b.reply(ctx, msg, "Sorry, answering failed. Please try again later.")
+		return
+	}
+	b.reply(ctx, msg, render(result))
+}
+
+// render writes result as plain text: the answer, its key points and the notes it cites.
+func render(result chat.QueryResult) string {
+	var sb strings.Builder
+	sb.WriteString(result.Answer)
+	if s := result.Structured; s != nil {
+		if len(s.Bullets) > 0 {
+			sb.WriteString("\n")
+			for _, bullet := range s.Bullets {
+				sb.WriteString("\n• " + bullet)
+			}
+		}
+		if len(s.Citations) > 0 {
+			sb.WriteString("\n\nSources:")
+			for _, c := range s.Citations {
+				sb.WriteString("\n- " + c.Note)
+			}
+		}
+	}
+	return sb.String()
+}
+
+// reply sends text in answer to msg, split into several messages if it is too long for one.
+func (b *Bot) reply(ctx context.Context, msg *message, text string) {
+	for _, part := range split(text, maxMessageLength) {
+		err := b.call(ctx, "sendMessage", map[string]any{
+			"chat_id":             msg.Chat.ID,
+			"text":                part,
+			"reply_to_message_id": msg.MessageID,
+		}, nil)
+		if err != nil {
+			log.Printf("[Telegram] failed to reply to user %d: %v", msg.From.ID, err)
+			return
+		}
+	}
+}
+
+// split cuts text into parts of at most n characters, preferring to cut at line breaks.
+func split(text string, n int) []string {
+	var parts []string
+	for utf8.RuneCountInString(text) > n {
+		// end is where the n-th character ends
+		end, count := len(text), 0
+		for i := range text {
+			if count == n {
+				end = i
+				break
+			}
+			count++
+		}
+		cut := end
+		if i := strings.LastIndex(text[:end], "\n"); i > 0 {
+			cut = i
+		}
+		parts = append(parts, text[:cut])
+		text = strings.TrimLeft(text[cut:], "\n")
+	}
+	return append(parts, text)
+}
+
+// getUpdates waits up to pollTimeout for the messages after the offset.
+func (b *Bot) getUpdates(ctx context.Context) ([]update, error) {
+	var updates []update
+	err := b.call(ctx, "getUpdates", map[string]any{
+		"offset":          b.offset,
+		"timeout":         int(pollTimeout.Seconds()),
+		"allowed_updates": []string{"message"},
+	}, &updates)
+	return updates, err
+}
+
+// call invokes a Bot API method with params, decoding its result into out unless it is nil.
+func (b *Bot) call(ctx context.Context, method string, params map[string]any, out any) error {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, apiURL+b.cfg().TelegramBotToken+"/"+method, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := httpclient.OrDefault(b.client).Do(req)
+	if err != nil {
+		// the URL holds the bot's token; keep it out of the logs
+		var ue *url.Error
+		if errors.As(err, &ue) {
+			err = ue.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+
+	var res struct {
+		OK          bool            `json:"ok"`
+		Description string          `json:"description"`
+		Result      json.RawMessage `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return fmt.Errorf("%s: decoding the response (%s): %w", method, resp.Status, err)
+	}
+	if !res.OK {
+		return fmt.Errorf("%s: %s", method, res.Description)
+	}
+	if out != nil {
+		return json.Unmarshal(res.Result, out)
+	}
+	return nil
+}
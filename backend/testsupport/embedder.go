@@ -0,0 +1,129 @@
+// Package testsupport provides deterministic, offline stand-ins for the external services
+// (Voyage, OpenAI, the vector DB) so handlers and pipelines can be tested without API keys.
+package testsupport
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"vex-backend/chunking"
+	"vex-backend/lang"
+	"vex-backend/vector"
+	"vex-backend/vector/embed"
+)
+
+// DefaultDimensions is the vector length of a MockEmbedder created with NewMockEmbedder(0).
+const DefaultDimensions = 64
+
+// MockEmbedder is an embed.Embedder that derives vectors from the hashed words of the
+// content instead of calling an API. Equal content always yields equal vectors and texts
+// sharing words are similar, so similarity search behaves plausibly in tests.
+type MockEmbedder struct {
+	Dimensions int
+	Chunker    chunking.Chunker
+}
+
+// NewMockEmbedder returns a MockEmbedder producing vectors of length dims (DefaultDimensions
+// if dims <= 0) that chunks with the default chunking options.
+func NewMockEmbedder(dims int) *MockEmbedder {
+	if dims <= 0 {
+		dims = DefaultDimensions
+	}
+	c, _ := chunking.New(chunking.DefaultOptions())
+	return &MockEmbedder{
+		Dimensions: dims,
+		Chunker:    c,
+	}
+}
+
+func (me *MockEmbedder) EmbedToVector(ctx context.Context, content string) ([]float32, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	v := make([]float32, me.Dimensions)
+	for _, word := range strings.Fields(strings.ToLower(content)) {
+		sum := sha256.Sum256([]byte(word))
+		bucket := binary.BigEndian.Uint32(sum[:4]) % uint32(me.Dimensions)
+		// the sign spreads unrelated words apart instead of piling them onto one side
+		if sum[4]&1 == 0 {
+			v[bucket]++
+		} else {
+			v[bucket]--
+		}
+	}
+
+	var norm float64
+	for _, x := range v {
+		norm += float64(x) * float64(x)
+	}
+	if norm == 0 {
+		// blank content still needs a valid unit vector
+		v[0] = 1
+		return v, nil
+	}
+	norm = math.Sqrt(norm)
+	for i := range v {
+		v[i] = float32(float64(v[i]) / norm)
+	}
+	return v, nil
+}
+
+func (me *MockEmbedder) CreateChunks(ctx context.Context, content string) []string {
+	return chunking.Texts(me.Chunker.Chunk(content))
+}
+
+func (me *MockEmbedder) EmbedStringToVectorData(ctx context.Context, content string, metadata map[string]string) ([]vector.VectorData, error) {
+	vectors := []vector.VectorData{}
+	for i, span := range me.Chunker.Chunk(content) {
+		embedding, err := me.EmbedToVector(ctx, span.Text)
+		if err != nil {
+			return nil, err
+		}
+
+		md := embed.ChunkMetadata(content, metadata, i, span)
+		md[lang.MetadataKey] = lang.Detect(span.Text)
+		sum := sha256.Sum256([]byte(md["filepath"] + "\x00" + span.Text))
+		vectors = append(vectors, vector.VectorData{
+			Content:   span.Text,
+			Embedding: embedding,
+			Metadata:  md,
+			// deterministic so tests can refer to chunks by ID
+			Id: fmt.Sprintf("mock-%x-%d", sum[:8], i),
+		})
+	}
+	return vectors, nil
+}
+
+func (me *MockEmbedder) EmbedFileToVectorData(ctx context.Context, filename string, metadata map[string]string) ([]vector.VectorData, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	md := make(map[string]string, len(metadata)+2)
+	for k, v := range metadata {
+		md[k] = v
+	}
+	if abs, err := filepath.Abs(filename); err == nil {
+		md["filepath"] = abs
+	} else {
+		md["filepath"] = filename
+	}
+	md["filename"] = filepath.Base(filename)
+
+	tags := embed.ExtractTags(string(b))
+	if len(tags) > 0 {
+		md["tags"] = strings.Join(tags, ",")
+		for _, tag := range tags {
+			md[embed.TagMetadataPrefix+tag] = "true"
+		}
+	}
+
+	return me.EmbedStringToVectorData(ctx, string(b), md)
+}
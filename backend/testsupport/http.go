@@ -0,0 +1,54 @@
+package testsupport
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+)
+
+// DoerFunc adapts a function to httpclient.Doer so API calls can be answered in-process.
+type DoerFunc func(req *http.Request) (*http.Response, error)
+
+func (f DoerFunc) Do(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// JSONResponse builds a response with the given status and body marshalled as JSON.
+func JSONResponse(status int, body any) *http.Response {
+	data, err := json.Marshal(body)
+	if err != nil {
+		panic(err)
+	}
+	return &http.Response{
+		StatusCode: status,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(bytes.NewReader(data)),
+	}
+}
+
+// VoyageResponse is the body of a successful Voyage embeddings response for embedding.
+func VoyageResponse(embedding []float32) map[string]any {
+	return map[string]any{
+		"object": "list",
+		"data": []map[string]any{
+			{"object": "embedding", "embedding": embedding, "index": 0},
+		},
+		"usage": map[string]any{"total_tokens": 0},
+	}
+}
+
+// OpenAIResponse is the body of a successful OpenAI chat completion answering with content.
+func OpenAIResponse(content string) map[string]any {
+	return map[string]any{
+		"object": "chat.completion",
+		"choices": []map[string]any{
+			{
+				"index":         0,
+				"message":       map[string]any{"role": "assistant", "content": content},
+				"finish_reason": "stop",
+			},
+		},
+		"usage": map[string]any{"prompt_tokens": 0, "completion_tokens": 0, "total_tokens": 0},
+	}
+}
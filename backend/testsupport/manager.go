@@ -0,0 +1,34 @@
+package testsupport
+
+import (
+	"context"
+	"vex-backend/vector"
+	vectormgr "vex-backend/vector/manager"
+)
+
+// NewManager returns an empty in-memory Manager backed by a MockEmbedder.
+func NewManager() vectormgr.Manager {
+	return vectormgr.NewMemoryManager(NewMockEmbedder(0))
+}
+
+// NewManagerWith returns an in-memory Manager backed by a MockEmbedder and pre-loaded with
+// one chunk per entry of docs (ID to content).
+func NewManagerWith(ctx context.Context, docs map[string]string) (vectormgr.Manager, error) {
+	m := NewManager()
+	for id, content := range docs {
+		embedding, err := m.GetEmbedder().EmbedToVector(ctx, content)
+		if err != nil {
+			return nil, err
+		}
+		v := vector.VectorData{
+			Id:        id,
+			Content:   content,
+			Embedding: embedding,
+			Metadata:  map[string]string{},
+		}
+		if err := m.StoreVectorInDB(ctx, v); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
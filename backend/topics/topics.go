@@ -0,0 +1,138 @@
+package topics
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"vex-backend/chat"
+	"vex-backend/config"
+	"vex-backend/httpclient"
+	"vex-backend/usage"
+	vectormgr "vex-backend/vector/manager"
+)
+
+// representativeNotes is how many notes closest to a cluster's centre are listed per topic
+const representativeNotes = 5
+
+// Note identifies a note in a topic.
+type Note struct {
+	Filepath string `json:"filepath"`
+	Filename string `json:"filename"`
+}
+
+// Topic is a labelled cluster of similar notes.
+type Topic struct {
+	Label string `json:"label"`
+	Size  int    `json:"size"`
+	// Cohesion is the mean cosine similarity of the notes to the cluster centre
+	Cohesion float32 `json:"cohesion"`
+	// Representative are the notes closest to the cluster centre
+	Representative []Note `json:"representative"`
+	// Notes lists every note in the topic, most representative first
+	Notes []Note `json:"notes"`
+}
+
+// View is the topic grouping of the whole vault.
+type View struct {
+	Topics      []Topic `json:"topics"`
+	NoteCount   int     `json:"note_count"`
+	Clusters    int     `json:"clusters"`
+	GeneratedAt string  `json:"generated_at"`
+	Provider    string  `json:"provider,omitempty"`
+	// Stale is set when the index changed since the view was computed
+	Stale bool `json:"stale"`
+
+	// chunkCount is the index size the view was computed from
+	chunkCount int
+}
+
+// Cache computes topic views and keeps the latest one per requested cluster count, since
+// clustering the vault and labelling it with the LLM is too slow to do on every request.
+type Cache struct {
+	cfg    config.Source
+	client httpclient.Doer
+	m      vectormgr.Manager
+
+	// mu also serializes computation, so concurrent requests don't label the vault twice
+	mu    sync.Mutex
+	views map[int]View
+}
+
+// New returns an empty Cache.
+func New(cfg config.Source, client httpclient.Doer, m vectormgr.Manager) *Cache {
+	return &Cache{
+		cfg:    cfg,
+		client: client,
+		m:      m,
+		views:  map[int]View{},
+	}
+}
+
+// Get returns the topic view for k clusters (0 picks a count from the vault size). A cached
+// view is returned unless refresh is set or none exists yet; it is marked stale if chunks
+// were added or removed since.
+func (c *Cache) Get(ctx context.Context, k int, refresh bool) (View, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	count, err := c.m.Count(ctx)
+	if err != nil {
+		return View{}, err
+	}
+
+	if v, ok := c.views[k]; ok && !refresh {
+		v.Stale = v.chunkCount != count
+		return v, nil
+	}
+
+	v, err := c.compute(usage.WithSource(ctx, "topics"), k)
+	if err != nil {
+		return View{}, err
+	}
+	v.chunkCount = count
+	c.views[k] = v
+	return v, nil
+}
+
+// compute clusters the note vectors and labels the clusters.
+func (c *Cache) compute(ctx context.Context, k int) (View, error) {
+	notes, err := vectormgr.NoteVectors(ctx, c.m)
+	if err != nil {
+		return View{}, err
+	}
+	if k <= 0 {
+		k = vectormgr.DefaultClusterCount(len(notes))
+	}
+
+	clusters := vectormgr.ClusterNotes(notes, k)
+	labels, provider, err := chat.LabelClusters(ctx, c.cfg(), c.client, clusters)
+	if err != nil {
+		return View{}, err
+	}
+
+	v := View{
+		Topics:      make([]Topic, 0, len(clusters)),
+		NoteCount:   len(notes),
+		Clusters:    len(clusters),
+		GeneratedAt: time.Now().UTC().Format(time.RFC3339),
+		Provider:    provider,
+	}
+	for i, cl := range clusters {
+		t := Topic{
+			Label:    labels[i],
+			Size:     len(cl.Notes),
+			Cohesion: cl.Cohesion,
+			Notes:    make([]Note, 0, len(cl.Notes)),
+		}
+		for _, n := range cl.Notes {
+			t.Notes = append(t.Notes, Note{Filepath: n.Filepath, Filename: n.Filename})
+		}
+		t.Representative = t.Notes
+		if len(t.Representative) > representativeNotes {
+			t.Representative = t.Representative[:representativeNotes]
+		}
+		v.Topics = append(v.Topics, t)
+	}
+	return v, nil
+}
@@ -0,0 +1,120 @@
+package transcribe
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"vex-backend/access"
+	"vex-backend/git"
+	"vex-backend/vector"
+	vectormgr "vex-backend/vector/manager"
+)
+
+// Metadata keys of transcript chunks, on top of the filepath and other keys every chunk has.
+const (
+	// SourceMetadataKey is set to SourceTranscript on chunks of audio files
+	SourceMetadataKey = "source"
+	SourceTranscript  = "transcript"
+	// StartMetadataKey and EndMetadataKey hold the whole seconds into the recording at which
+	// the chunk's speech starts and ends
+	StartMetadataKey = "start_seconds"
+	EndMetadataKey   = "end_seconds"
+)
+
+// window is how much of a recording goes into one chunk, so that a hit points close to
+// where it was said
+const window = 2 * time.Minute
+
+// Ingester embeds the transcripts of audio files.
+type Ingester struct {
+	Transcriber Transcriber
+	// MaxFileSize in bytes skips larger recordings without uploading them; 0 disables the check
+	MaxFileSize int64
+}
+
+// Index transcribes the audio file at path and replaces its stored chunks with the
+// transcript. It returns why the file was not embedded, e.g. because it is too large or
+// contains no speech, or "" once it has been; skipped files keep their previous chunks for
+// the caller to remove.
+func (in Ingester) Index(ctx context.Context, m vectormgr.Manager, path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Stat(abs)
+	if err != nil {
+		return "", fmt.Errorf("failed to stat %s: %w", abs, err)
+	}
+	if in.MaxFileSize > 0 && info.Size() > in.MaxFileSize {
+		return fmt.Sprintf("recording is %d bytes, more than the maximum of %d", info.Size(), in.MaxFileSize), nil
+	}
+
+	t, err := in.Transcriber.Transcribe(ctx, abs)
+	if err != nil {
+		return "", err
+	}
+	if len(t.Segments) == 0 {
+		return "recording contains no speech", nil
+	}
+
+	metadata := map[string]string{
+		"filepath":         abs,
+		"filename":         filepath.Base(abs),
+		"mod_time":         info.ModTime().UTC().Format(time.RFC3339),
+		"size":             strconv.FormatInt(info.Size(), 10),
+		SourceMetadataKey:  SourceTranscript,
+		access.MetadataKey: access.Shared,
+	}
+	for k, v := range git.FileMetadata(abs) {
+		metadata[k] = v
+	}
+
+	// embed everything before touching the stored chunks, so a failure leaves the old
+	// transcript searchable
+	var vs []vector.VectorData
+	for _, group := range groupSegments(t.Segments, window) {
+		md := make(map[string]string, len(metadata)+2)
+		for k, v := range metadata {
+			md[k] = v
+		}
+		md[StartMetadataKey] = strconv.Itoa(int(group[0].Start.Seconds()))
+		md[EndMetadataKey] = strconv.Itoa(int(group[len(group)-1].End.Seconds()))
+
+		texts := make([]string, len(group))
+		for i, s := range group {
+			texts[i] = s.Text
+		}
+		chunks, err := m.GetEmbedder().EmbedStringToVectorData(ctx, strings.Join(texts, " "), md)
+		if err != nil {
+			return "", err
+		}
+		vs = append(vs, chunks...)
+	}
+
+	if err := m.DeleteVectorsWithMetaData(ctx, "filepath", abs); err != nil {
+		return "", err
+	}
+	return "", m.StoreVectorsInDB(ctx, vs)
+}
+
+// groupSegments splits segments into consecutive groups spanning at most span each; a
+// single segment longer than span forms a group of its own.
+func groupSegments(segments []Segment, span time.Duration) [][]Segment {
+	var groups [][]Segment
+	var current []Segment
+	for _, s := range segments {
+		if len(current) > 0 && s.End-current[0].Start > span {
+			groups = append(groups, current)
+			current = nil
+		}
+		current = append(current, s)
+	}
+	if len(current) > 0 {
+		groups = append(groups, current)
+	}
+	return groups
+}
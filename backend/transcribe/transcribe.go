@@ -0,0 +1,173 @@
+// Package transcribe turns audio files in the notes repository, such as voice memos, into
+// text with a Whisper-compatible transcription API, so they can be embedded and searched
+// like notes. Transcripts keep the timestamps of their segments.
+package transcribe
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"vex-backend/breaker"
+	"vex-backend/config"
+	"vex-backend/httpclient"
+	"vex-backend/usage"
+	"vex-backend/vector"
+)
+
+// audioTypes lists the extensions of the audio files that are transcribed
+var audioTypes = map[string]bool{
+	".m4a": true,
+	".mp3": true,
+}
+
+// IsAudio reports whether path has the extension of a supported audio type.
+func IsAudio(path string) bool {
+	return audioTypes[strings.ToLower(filepath.Ext(path))]
+}
+
+// Segment is a stretch of a transcript, with its offsets from the start of the recording.
+type Segment struct {
+	Start time.Duration
+	End   time.Duration
+	Text  string
+}
+
+// Transcript is the text of a recording, split into timed segments.
+type Transcript struct {
+	Text     string
+	Language string
+	Duration time.Duration
+	Segments []Segment
+}
+
+// Transcriber turns an audio file into a transcript.
+type Transcriber interface {
+	Transcribe(ctx context.Context, path string) (Transcript, error)
+}
+
+// New returns the transcriber configured by the TRANSCRIBE_* settings, or nil when
+// TRANSCRIBE is off. client may be nil to use the shared httpclient.Default.
+func New(cfg *config.EnvConfig, client httpclient.Doer) Transcriber {
+	if !cfg.Transcribe {
+		return nil
+	}
+	return Whisper{URL: cfg.TranscribeURL, APIKey: cfg.TranscribeKey(), Model: cfg.TranscribeModel, Client: client}
+}
+
+// whisperBreaker fails transcription requests fast once the API has failed repeatedly.
+var whisperBreaker = breaker.New("transcription")
+
+// Whisper calls an OpenAI-compatible /audio/transcriptions endpoint.
+type Whisper struct {
+	URL    string
+	APIKey string
+	Model  string
+	// Client sends the API requests; nil uses httpclient.Default
+	Client httpclient.Doer
+}
+
+func (wh Whisper) Transcribe(ctx context.Context, path string) (Transcript, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Transcript{}, err
+	}
+	defer f.Close()
+
+	// verbose_json is the response format that carries segment timestamps
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fields := [][2]string{
+		{"model", wh.Model},
+		{"response_format", "verbose_json"},
+		{"timestamp_granularities[]", "segment"},
+	}
+	for _, field := range fields {
+		if err := mw.WriteField(field[0], field[1]); err != nil {
+			return Transcript{}, fmt.Errorf("failed to build request: %w", err)
+		}
+	}
+	part, err := mw.CreateFormFile("file", filepath.Base(path))
+	if err != nil {
+		return Transcript{}, fmt.Errorf("failed to build request: %w", err)
+	}
+	if _, err := io.Copy(part, f); err != nil {
+		return Transcript{}, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if err := mw.Close(); err != nil {
+		return Transcript{}, fmt.Errorf("failed to build request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, wh.URL, &body)
+	if err != nil {
+		return Transcript{}, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	if wh.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+wh.APIKey)
+	}
+
+	if err := whisperBreaker.Allow(); err != nil {
+		return Transcript{}, err
+	}
+	resp, err := httpclient.OrDefault(wh.Client).Do(req)
+	whisperBreaker.Record(ctx, resp, err)
+	if err != nil {
+		return Transcript{}, fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return Transcript{}, fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return Transcript{}, fmt.Errorf("%w: transcription API returned status %d: %s", vector.ErrRateLimited, resp.StatusCode, string(respBody))
+	}
+	if resp.StatusCode != http.StatusOK {
+		return Transcript{}, fmt.Errorf("unexpected status code: %d, body: %s", resp.StatusCode, string(respBody))
+	}
+
+	var result struct {
+		Text     string  `json:"text"`
+		Language string  `json:"language"`
+		Duration float64 `json:"duration"`
+		Segments []struct {
+			Start float64 `json:"start"`
+			End   float64 `json:"end"`
+			Text  string  `json:"text"`
+		} `json:"segments"`
+	}
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return Transcript{}, fmt.Errorf("failed to parse response: %w", err)
+	}
+	usage.RecordTranscription(ctx, int(math.Ceil(result.Duration)))
+
+	t := Transcript{
+		Text:     strings.TrimSpace(result.Text),
+		Language: result.Language,
+		Duration: seconds(result.Duration),
+	}
+	for _, s := range result.Segments {
+		if text := strings.TrimSpace(s.Text); text != "" {
+			t.Segments = append(t.Segments, Segment{Start: seconds(s.Start), End: seconds(s.End), Text: text})
+		}
+	}
+	// servers that don't return segments still return the text, as one untimed segment
+	if len(t.Segments) == 0 && t.Text != "" {
+		t.Segments = []Segment{{End: t.Duration, Text: t.Text}}
+	}
+	return t, nil
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
@@ -0,0 +1,283 @@
+package usage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Totals accumulates API usage for embedding (Voyage), chat (OpenAI) and transcription calls.
+type Totals struct {
+	VoyageRequests         int `json:"voyage_requests"`
+	VoyageTokens           int `json:"voyage_tokens"`
+	VoyageCharacters       int `json:"voyage_characters"`
+	OpenAIRequests         int `json:"openai_requests"`
+	OpenAIPromptTokens     int `json:"openai_prompt_tokens"`
+	OpenAICompletionTokens int `json:"openai_completion_tokens"`
+	TranscriptionRequests  int `json:"transcription_requests"`
+	TranscriptionSeconds   int `json:"transcription_seconds"`
+}
+
+func (t *Totals) add(o Totals) {
+	t.VoyageRequests += o.VoyageRequests
+	t.VoyageTokens += o.VoyageTokens
+	t.VoyageCharacters += o.VoyageCharacters
+	t.OpenAIRequests += o.OpenAIRequests
+	t.OpenAIPromptTokens += o.OpenAIPromptTokens
+	t.OpenAICompletionTokens += o.OpenAICompletionTokens
+	t.TranscriptionRequests += o.TranscriptionRequests
+	t.TranscriptionSeconds += o.TranscriptionSeconds
+}
+
+// flushInterval is how often recorded usage is written out
+const flushInterval = 10 * time.Second
+
+// Tracker aggregates usage per day (UTC, YYYY-MM-DD) and per source and persists
+// it as JSON. A source is the caller identity, e.g. an API key fingerprint or "webhook".
+// Usage is written out every flushInterval rather than on every call; Flush writes it
+// before the process exits.
+type Tracker struct {
+	path string
+
+	mu   sync.Mutex
+	Days map[string]map[string]*Totals `json:"days"`
+	// dirty is set by add and cleared by a successful save
+	dirty bool
+}
+
+// Global tracker instance, nil until Init is called
+var tracker *Tracker
+
+// Init loads the usage file at path (if present) and installs it as the global tracker,
+// which is flushed every flushInterval from then on.
+func Init(path string) error {
+	t := &Tracker{
+		path: path,
+		Days: make(map[string]map[string]*Totals),
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read usage file: %w", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, t); err != nil {
+			return fmt.Errorf("failed to parse usage file: %w", err)
+		}
+		if t.Days == nil {
+			t.Days = make(map[string]map[string]*Totals)
+		}
+	}
+
+	tracker = t
+	go t.runFlush()
+	return nil
+}
+
+// Flush writes the usage recorded since the last write to the usage file, if there is a
+// global tracker.
+func Flush() error {
+	if tracker == nil {
+		return nil
+	}
+	return tracker.flush()
+}
+
+func (t *Tracker) flush() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.dirty {
+		return nil
+	}
+	if err := t.save(); err != nil {
+		return err
+	}
+	t.dirty = false
+	return nil
+}
+
+// runFlush flushes t every flushInterval.
+func (t *Tracker) runFlush() {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := t.flush(); err != nil {
+			log.Printf("[Usage] warning: failed to persist usage: %v", err)
+		}
+	}
+}
+
+type ctxKey struct{}
+
+// requestUsage is attached to a request context to collect the usage of that request alone.
+type requestUsage struct {
+	source string
+
+	mu     sync.Mutex
+	totals Totals
+}
+
+// WithSource returns a context that attributes usage to source and collects the usage
+// of everything done with that context, retrievable via FromContext.
+func WithSource(ctx context.Context, source string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, &requestUsage{source: source})
+}
+
+// SourceFrom returns the source usage is attributed to for ctx, or "" if there is none.
+func SourceFrom(ctx context.Context) string {
+	if ru, ok := ctx.Value(ctxKey{}).(*requestUsage); ok {
+		return ru.source
+	}
+	return ""
+}
+
+// FromContext returns the usage collected so far for the request carried by ctx.
+func FromContext(ctx context.Context) Totals {
+	ru, ok := ctx.Value(ctxKey{}).(*requestUsage)
+	if !ok {
+		return Totals{}
+	}
+	ru.mu.Lock()
+	defer ru.mu.Unlock()
+	return ru.totals
+}
+
+// RecordVoyage records a single Voyage embedding request.
+func RecordVoyage(ctx context.Context, tokens, characters int) {
+	record(ctx, Totals{
+		VoyageRequests:   1,
+		VoyageTokens:     tokens,
+		VoyageCharacters: characters,
+	})
+}
+
+// RecordOpenAI records a single OpenAI chat completion request.
+func RecordOpenAI(ctx context.Context, promptTokens, completionTokens int) {
+	record(ctx, Totals{
+		OpenAIRequests:         1,
+		OpenAIPromptTokens:     promptTokens,
+		OpenAICompletionTokens: completionTokens,
+	})
+}
+
+// RecordTranscription records a single transcription request for seconds of audio.
+func RecordTranscription(ctx context.Context, seconds int) {
+	record(ctx, Totals{
+		TranscriptionRequests: 1,
+		TranscriptionSeconds:  seconds,
+	})
+}
+
+func record(ctx context.Context, delta Totals) {
+	source := "unknown"
+	if ru, ok := ctx.Value(ctxKey{}).(*requestUsage); ok {
+		source = ru.source
+		ru.mu.Lock()
+		ru.totals.add(delta)
+		ru.mu.Unlock()
+	}
+
+	if tracker == nil {
+		return
+	}
+	tracker.add(time.Now().UTC().Format("2006-01-02"), source, delta)
+}
+
+func (t *Tracker) add(day, source string, delta Totals) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	sources, ok := t.Days[day]
+	if !ok {
+		sources = make(map[string]*Totals)
+		t.Days[day] = sources
+	}
+	totals, ok := sources[source]
+	if !ok {
+		totals = &Totals{}
+		sources[source] = totals
+	}
+	totals.add(delta)
+	t.dirty = true
+}
+
+// save writes the usage file atomically. Callers must hold t.mu.
+func (t *Tracker) save() error {
+	data, err := json.MarshalIndent(t, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(t.path), 0o755); err != nil {
+		return err
+	}
+	tmp := t.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, t.path)
+}
+
+// DayReport is the usage of a single day broken down by source.
+type DayReport struct {
+	Day     string            `json:"day"`
+	Sources map[string]Totals `json:"sources"`
+	Total   Totals            `json:"total"`
+}
+
+// Report is the aggregated usage returned by Snapshot.
+type Report struct {
+	Days    []DayReport       `json:"days"`
+	Sources map[string]Totals `json:"sources"`
+	Total   Totals            `json:"total"`
+}
+
+// Snapshot returns aggregated usage for days in [from, to] (inclusive, YYYY-MM-DD).
+// Empty bounds are open-ended.
+func Snapshot(from, to string) Report {
+	report := Report{
+		Days:    []DayReport{},
+		Sources: make(map[string]Totals),
+	}
+	if tracker == nil {
+		return report
+	}
+
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	days := make([]string, 0, len(tracker.Days))
+	for day := range tracker.Days {
+		if (from != "" && day < from) || (to != "" && day > to) {
+			continue
+		}
+		days = append(days, day)
+	}
+	sort.Strings(days)
+
+	for _, day := range days {
+		dr := DayReport{
+			Day:     day,
+			Sources: make(map[string]Totals),
+		}
+		for source, totals := range tracker.Days[day] {
+			dr.Sources[source] = *totals
+			dr.Total.add(*totals)
+
+			s := report.Sources[source]
+			s.add(*totals)
+			report.Sources[source] = s
+		}
+		report.Total.add(dr.Total)
+		report.Days = append(report.Days, dr)
+	}
+
+	return report
+}
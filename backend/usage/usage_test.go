@@ -0,0 +1,34 @@
+package usage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRecordIsFlushedInBatches(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage.json")
+	if err := Init(path); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { tracker = nil }()
+
+	ctx := WithSource(context.Background(), "test")
+	for i := 0; i < 3; i++ {
+		RecordVoyage(ctx, 10, 40)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("usage file written before a flush: %v", err)
+	}
+
+	if err := Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := Init(path); err != nil {
+		t.Fatal(err)
+	}
+	if got := Snapshot("", "").Sources["test"]; got.VoyageRequests != 3 || got.VoyageTokens != 30 {
+		t.Errorf("usage read back = %+v, want 3 requests and 30 tokens", got)
+	}
+}
@@ -0,0 +1,28 @@
+package embed
+
+import "math"
+
+// Truncate returns the first dims values of embedding scaled back to unit length, the
+// Matryoshka way of shortening embeddings: models trained for it, like Voyage's, keep most
+// of their accuracy in a prefix of the vector. Documents and queries have to be truncated
+// alike. embedding is returned unchanged if dims <= 0 or it is no longer than dims.
+func Truncate(embedding []float32, dims int) []float32 {
+	if dims <= 0 || len(embedding) <= dims {
+		return embedding
+	}
+	out := make([]float32, dims)
+	copy(out, embedding)
+
+	var norm float64
+	for _, v := range out {
+		norm += float64(v) * float64(v)
+	}
+	if norm == 0 {
+		return out
+	}
+	scale := float32(1 / math.Sqrt(norm))
+	for i := range out {
+		out[i] *= scale
+	}
+	return out
+}
@@ -2,12 +2,62 @@ package embed
 
 import (
 	"context"
+	"strconv"
+	"vex-backend/chunking"
 	"vex-backend/vector"
 )
 
+// Metadata keys locating a chunk within its source content
+const (
+	ChunkIndexMetadataKey = "chunk_index"
+	StartByteMetadataKey  = "start_byte"
+	EndByteMetadataKey    = "end_byte"
+	StartLineMetadataKey  = "start_line"
+	EndLineMetadataKey    = "end_line"
+	// WindowMetadataKey holds the text around a chunk to answer from in its place, for
+	// chunkers that give one
+	WindowMetadataKey = "window"
+)
+
+// EmbeddingModelMetadataKey records the model a chunk was embedded with, so a changed
+// model can be detected without re-embedding
+const EmbeddingModelMetadataKey = "embedding_model"
+
+// EmbeddingDimensionsMetadataKey records the length a chunk's embedding was truncated to,
+// absent if it has the model's full length
+const EmbeddingDimensionsMetadataKey = "embedding_dimensions"
+
 type Embedder interface {
 	EmbedToVector(ctx context.Context, content string) ([]float32, error)
 	CreateChunks(ctx context.Context, content string) []string
 	EmbedStringToVectorData(ctx context.Context, content string, metadata map[string]string) ([]vector.VectorData, error)
 	EmbedFileToVectorData(ctx context.Context, filename string, metadat map[string]string) ([]vector.VectorData, error)
 }
+
+// LanguageEmbedder is implemented by embedders that embed the chunks of some languages with a
+// dedicated model. Text compared against such chunks, e.g. a query scoped to their language,
+// has to be embedded with the same model.
+type LanguageEmbedder interface {
+	ModelForLanguage(language string) string
+	EmbedToVectorForLanguage(ctx context.Context, content, language string) ([]float32, error)
+}
+
+// ChunkMetadata returns a copy of base extended with the position of the i-th chunk span
+// within content, so a chunk can be traced back to the exact lines of its source.
+func ChunkMetadata(content string, base map[string]string, i int, span chunking.Span) map[string]string {
+	metadata := make(map[string]string, len(base)+5)
+	for k, v := range base {
+		metadata[k] = v
+	}
+
+	startLine, endLine := chunking.LineRange(content, span)
+	metadata[ChunkIndexMetadataKey] = strconv.Itoa(i)
+	metadata[StartByteMetadataKey] = strconv.Itoa(span.Start)
+	metadata[EndByteMetadataKey] = strconv.Itoa(span.End)
+	metadata[StartLineMetadataKey] = strconv.Itoa(startLine)
+	metadata[EndLineMetadataKey] = strconv.Itoa(endLine)
+	if span.Window != "" {
+		metadata[WindowMetadataKey] = span.Window
+	}
+	return metadata
+}
@@ -0,0 +1,75 @@
+package embed
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"vex-backend/chunking"
+	"vex-backend/config"
+	"vex-backend/httpclient"
+	"vex-backend/redact"
+)
+
+// Factory builds the Embedder of one provider. client is the shared HTTP client and redactor
+// strips secrets from chunks before they are sent; it may be nil.
+type Factory func(cfg config.Source, client httpclient.Doer, redactor *redact.Redactor) (Embedder, error)
+
+var (
+	providersMu sync.RWMutex
+	providers   = map[string]Factory{}
+)
+
+// the built-in providers
+func init() {
+	Register("voyage", newVoyageFromConfig)
+}
+
+// Register makes an embedding provider selectable with EMBED_PROVIDER=name. It is meant to
+// be called from an init function and panics if name is already registered.
+func Register(name string, f Factory) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+
+	if _, ok := providers[name]; ok {
+		panic(fmt.Sprintf("embedding provider %q registered twice", name))
+	}
+	providers[name] = f
+}
+
+// Providers returns the names of the registered providers, sorted.
+func Providers() []string {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New builds the Embedder of the provider named by EMBED_PROVIDER.
+func New(cfg config.Source, client httpclient.Doer, redactor *redact.Redactor) (Embedder, error) {
+	name := cfg().EmbedProvider
+	providersMu.RLock()
+	f, ok := providers[name]
+	providersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown EMBED_PROVIDER %q, expected one of %s", name, strings.Join(Providers(), ", "))
+	}
+	return f(cfg, client, redactor)
+}
+
+// newVoyageFromConfig returns the Voyage embedder of cfg. Every request shares one budget,
+// so parallel chunks and syncs stay within the limits; the budget and the chunking follow
+// reloads.
+func newVoyageFromConfig(cfg config.Source, client httpclient.Doer, redactor *redact.Redactor) (Embedder, error) {
+	throttle := NewThrottle(func() Budget {
+		c := cfg()
+		return Budget{RequestsPerMinute: c.VoyageRPM, TokensPerMinute: c.VoyageTPM, Concurrency: c.VoyageConcurrency}
+	})
+	c := cfg()
+	return NewVoyageEmbed(c.VoyageAPIKey, c.VoyageModel, chunking.ConfigChunker{Source: cfg}, client, redactor, c.LanguageModels(), throttle, c.EmbedDimensions), nil
+}
@@ -0,0 +1,44 @@
+package embed
+
+import (
+	"context"
+	"strconv"
+	"vex-backend/vector"
+)
+
+type reuseKey struct{}
+
+// reusable maps a chunk's model, truncation and content to the embedding stored for it.
+type reusable map[string][]float32
+
+func reuseIndex(model, dimensions, content string) string {
+	return model + "\x00" + dimensions + "\x00" + content
+}
+
+// WithPrevious returns a context under which embedding a file reuses the stored embedding
+// of every chunk whose content is unchanged from previous, the chunks stored for the file so
+// far, instead of embedding it again. Only chunks embedded with the model the chunk would be
+// embedded with now, truncated to the same dimensions, are reused.
+func WithPrevious(ctx context.Context, previous []vector.VectorData) context.Context {
+	index := make(reusable, len(previous))
+	for _, v := range previous {
+		model := v.Metadata[EmbeddingModelMetadataKey]
+		if model == "" || len(v.Embedding) == 0 {
+			continue
+		}
+		index[reuseIndex(model, v.Metadata[EmbeddingDimensionsMetadataKey], v.Content)] = v.Embedding
+	}
+	return context.WithValue(ctx, reuseKey{}, index)
+}
+
+// previousEmbedding returns the stored embedding of content under model truncated to
+// dimensions (0 for none), if ctx carries one.
+func previousEmbedding(ctx context.Context, model string, dimensions int, content string) ([]float32, bool) {
+	dims := ""
+	if dimensions > 0 {
+		dims = strconv.Itoa(dimensions)
+	}
+	index, _ := ctx.Value(reuseKey{}).(reusable)
+	embedding, ok := index[reuseIndex(model, dims, content)]
+	return embedding, ok
+}
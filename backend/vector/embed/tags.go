@@ -0,0 +1,169 @@
+package embed
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"vex-backend/access"
+)
+
+// TagMetadataPrefix prefixes one metadata key per tag (e.g. "tag:project" = "true") so
+// retrieval can be scoped to tags with chromem's exact-match where filters.
+const TagMetadataPrefix = "tag:"
+
+var (
+	reFrontmatter = regexp.MustCompile(`(?s)\A---\r?\n(.*?)\r?\n---`)
+	reCodeFence   = regexp.MustCompile("(?s)```.*?```")
+	reInlineCode  = regexp.MustCompile("`[^`\n]*`")
+	reHashtag     = regexp.MustCompile(`(?:^|[\s(\[,])#([\p{L}\p{N}_/\-]+)`)
+	reOnlyDigits  = regexp.MustCompile(`^\d+$`)
+)
+
+// ExtractTags returns the normalized, de-duplicated tags of a markdown note, taken from
+// the frontmatter "tags" field and from inline #hashtags outside of code.
+func ExtractTags(content string) []string {
+	seen := map[string]bool{}
+	var tags []string
+	add := func(tag string) {
+		tag = NormalizeTag(tag)
+		if tag == "" || reOnlyDigits.MatchString(tag) || seen[tag] {
+			return
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+
+	if m := reFrontmatter.FindStringSubmatch(content); m != nil {
+		for _, tag := range frontmatterTags(m[1]) {
+			add(tag)
+		}
+		content = content[len(m[0]):]
+	}
+
+	content = reCodeFence.ReplaceAllString(content, "")
+	content = reInlineCode.ReplaceAllString(content, "")
+	for _, m := range reHashtag.FindAllStringSubmatch(content, -1) {
+		add(m[1])
+	}
+
+	sort.Strings(tags)
+	return tags
+}
+
+// NormalizeTag lower-cases a tag and strips a leading '#'.
+func NormalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimSpace(tag), "#"))
+}
+
+// frontmatterTags parses the "tags" (or "tag") key of YAML frontmatter in its inline
+// list ("tags: [a, b]"), scalar ("tags: a b") and block list ("tags:\n  - a") forms.
+func frontmatterTags(frontmatter string) []string {
+	var tags []string
+	lines := strings.Split(frontmatter, "\n")
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		if key != "tags" && key != "tag" {
+			continue
+		}
+
+		value = strings.TrimSpace(value)
+		if value != "" {
+			value = strings.Trim(value, "[]")
+			for _, v := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ' ' }) {
+				tags = append(tags, strings.Trim(v, `"'`))
+			}
+			continue
+		}
+
+		// block list: consume following "- item" lines
+		for i+1 < len(lines) {
+			item := strings.TrimSpace(lines[i+1])
+			if !strings.HasPrefix(item, "-") {
+				break
+			}
+			tags = append(tags, strings.Trim(strings.TrimSpace(item[1:]), `"'`))
+			i++
+		}
+	}
+	return tags
+}
+
+// ExtractAccess returns the access level of a markdown note from its "access" frontmatter
+// field, normalized by access.Level.
+func ExtractAccess(content string) string {
+	if m := reFrontmatter.FindStringSubmatch(content); m != nil {
+		for _, line := range strings.Split(m[1], "\n") {
+			key, value, ok := strings.Cut(line, ":")
+			if ok && strings.EqualFold(strings.TrimSpace(key), access.MetadataKey) {
+				return access.Level(value)
+			}
+		}
+	}
+	return access.Shared
+}
+
+var reHeading = regexp.MustCompile(`(?m)^#[ \t]+(.+?)[ \t#]*$`)
+
+// ExtractTitle returns the title of a markdown note: its "title" frontmatter field, else
+// its first top-level heading, else "".
+func ExtractTitle(content string) string {
+	if m := reFrontmatter.FindStringSubmatch(content); m != nil {
+		for _, line := range strings.Split(m[1], "\n") {
+			key, value, ok := strings.Cut(line, ":")
+			if ok && strings.EqualFold(strings.TrimSpace(key), "title") {
+				if title := strings.Trim(strings.TrimSpace(value), `"'`); title != "" {
+					return title
+				}
+			}
+		}
+		content = content[len(m[0]):]
+	}
+	content = reCodeFence.ReplaceAllString(content, "")
+	if m := reHeading.FindStringSubmatch(content); m != nil {
+		return strings.TrimSpace(m[1])
+	}
+	return ""
+}
+
+var reAnyHeading = regexp.MustCompile(`(?m)^#{1,6}[ \t]+(.+?)[ \t#]*$`)
+
+// ExtractHeadings returns the text of every heading of a markdown note or chunk, at any
+// level, in order; headings inside code fences are skipped.
+func ExtractHeadings(content string) []string {
+	content = reCodeFence.ReplaceAllString(content, "")
+	var headings []string
+	for _, m := range reAnyHeading.FindAllStringSubmatch(content, -1) {
+		if h := strings.TrimSpace(m[1]); h != "" {
+			headings = append(headings, h)
+		}
+	}
+	return headings
+}
+
+// ExtractFrontmatter returns the scalar fields of a markdown note's frontmatter, with quotes
+// removed; fields holding lists or nested maps are left out, except tags (see ExtractTags).
+func ExtractFrontmatter(content string) map[string]string {
+	fields := map[string]string{}
+	m := reFrontmatter.FindStringSubmatch(content)
+	if m == nil {
+		return fields
+	}
+	for _, line := range strings.Split(m[1], "\n") {
+		// indented lines belong to the field above
+		if line == "" || line[0] == ' ' || line[0] == '\t' {
+			continue
+		}
+		key, value, ok := strings.Cut(line, ":")
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if !ok || key == "" || value == "" || strings.HasPrefix(value, "[") {
+			continue
+		}
+		fields[key] = strings.Trim(value, `"'`)
+	}
+	return fields
+}
@@ -0,0 +1,136 @@
+package embed
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// Budget is what the embedding provider allows: requests and tokens per minute (0 for no
+// limit) and how many requests a single file may have in flight at once.
+type Budget struct {
+	RequestsPerMinute int
+	TokensPerMinute   int
+	Concurrency       int
+}
+
+// Throttle keeps embedding requests within a Budget. Requests and tokens are two buckets
+// refilled continuously at their per-minute rate and holding at most a second's worth, so a
+// burst of chunks is spread out instead of hitting the provider all at once. Token counts
+// are only known from the response, so a request reserves an estimate that is settled
+// afterwards. All methods are safe on a nil Throttle, which doesn't limit.
+type Throttle struct {
+	budget func() Budget
+
+	mu       sync.Mutex
+	last     time.Time
+	requests float64
+	tokens   float64
+}
+
+// NewThrottle returns a Throttle reading its budget from budget on every request, so
+// reloaded limits apply right away.
+func NewThrottle(budget func() Budget) *Throttle {
+	return &Throttle{budget: budget}
+}
+
+// Budget returns the current budget, or an unlimited one with a concurrency of 1.
+func (t *Throttle) Budget() Budget {
+	if t == nil || t.budget == nil {
+		return Budget{Concurrency: 1}
+	}
+	b := t.budget()
+	if b.Concurrency < 1 {
+		b.Concurrency = 1
+	}
+	return b
+}
+
+// estimateTokens guesses the tokens of content before the provider counts them, at about
+// four characters per token.
+func estimateTokens(content string) int {
+	return len(content)/4 + 1
+}
+
+// Wait blocks until a request of about tokens tokens fits the budget, then reserves it.
+func (t *Throttle) Wait(ctx context.Context, tokens int) error {
+	if t == nil {
+		return nil
+	}
+	for {
+		delay := t.reserve(tokens)
+		if delay == 0 {
+			return nil
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// reserve takes a request and tokens from the buckets if both have enough, returning 0, or
+// returns how long to wait until they should.
+func (t *Throttle) reserve(tokens int) time.Duration {
+	b := t.Budget()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	requestRate := float64(b.RequestsPerMinute) / 60
+	tokenRate := float64(b.TokensPerMinute) / 60
+	if t.last.IsZero() {
+		t.requests, t.tokens = math.Max(requestRate, 1), math.Max(tokenRate, float64(tokens))
+	} else {
+		elapsed := now.Sub(t.last).Seconds()
+		t.requests = math.Min(t.requests+elapsed*requestRate, math.Max(requestRate, 1))
+		// a single request larger than a second's worth must still get through eventually
+		t.tokens = math.Min(t.tokens+elapsed*tokenRate, math.Max(tokenRate, float64(tokens)))
+	}
+	t.last = now
+
+	var wait float64
+	if b.RequestsPerMinute > 0 && t.requests < 1 {
+		wait = (1 - t.requests) / requestRate
+	}
+	if b.TokensPerMinute > 0 && t.tokens < float64(tokens) {
+		wait = math.Max(wait, (float64(tokens)-t.tokens)/tokenRate)
+	}
+	if wait > 0 {
+		return time.Duration(wait*float64(time.Second)) + time.Millisecond
+	}
+	if b.RequestsPerMinute > 0 {
+		t.requests--
+	}
+	if b.TokensPerMinute > 0 {
+		t.tokens -= float64(tokens)
+	}
+	return 0
+}
+
+// Settle corrects the reservation of estimated tokens by the tokens actually used.
+func (t *Throttle) Settle(estimated, actual int) {
+	if t == nil || actual <= 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.tokens += float64(estimated - actual)
+}
+
+// Backoff empties the buckets after the provider rate limited a request, so the requests
+// after it wait for them to refill.
+func (t *Throttle) Backoff() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.requests = math.Min(t.requests, 0)
+	t.tokens = math.Min(t.tokens, 0)
+	t.last = time.Now()
+}
@@ -3,112 +3,114 @@ package embed
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
-	"vex-backend/config"
+	"sync"
+	"time"
+	"vex-backend/access"
+	"vex-backend/breaker"
+	"vex-backend/chunking"
+	"vex-backend/debugtrace"
+	"vex-backend/httpclient"
+	"vex-backend/lang"
+	"vex-backend/redact"
+	"vex-backend/usage"
 	"vex-backend/vector"
 )
 
+// voyageBreaker fails embedding requests fast once Voyage has failed repeatedly.
+var voyageBreaker = breaker.New("voyage")
+
 type voyageEmbed struct {
-	Model string
+	APIKey  string
+	Model   string
+	Chunker chunking.Chunker
+	// Client sends the API requests; nil uses httpclient.Default
+	Client httpclient.Doer
+	// Redactor strips secrets from chunks before they are embedded; nil embeds them as is
+	Redactor *redact.Redactor
+	// LanguageModels replaces Model for chunks detected as one of its languages
+	LanguageModels map[string]string
+	// Throttle paces requests within the provider's limits; nil doesn't limit and embeds
+	// one chunk at a time
+	Throttle *Throttle
+	// Dimensions truncates every embedding to its first Dimensions values (see Truncate);
+	// 0 keeps the model's full length
+	Dimensions int
 }
 
-func NewVoyageEmbed(model string) Embedder {
+// NewVoyageEmbed returns an Embedder using the Voyage API. client may be nil to use the
+// shared httpclient.Default, or any *http.Client (proxy, custom TLS) or test double.
+// redactor may be nil to disable redaction. languageModels maps language codes to the model
+// their chunks are embedded with instead of model, e.g. a multilingual one; it may be nil.
+// throttle keeps the requests, shared by every caller, within the account's rate limits and
+// sets how many chunks of a file are embedded at once; it may be nil. dimensions > 0
+// truncates documents and queries alike to that many dimensions.
+func NewVoyageEmbed(apiKey, model string, chunker chunking.Chunker, client httpclient.Doer, redactor *redact.Redactor, languageModels map[string]string, throttle *Throttle, dimensions int) Embedder {
 	return &voyageEmbed{
-		Model: model,
+		APIKey:         apiKey,
+		Model:          model,
+		Chunker:        chunker,
+		Client:         client,
+		Redactor:       redactor,
+		LanguageModels: languageModels,
+		Throttle:       throttle,
+		Dimensions:     dimensions,
 	}
 }
 
-func (ve voyageEmbed) CreateChunks(ctx context.Context, content string) []string {
-	const maxChunkRunes = 50000 // Large chunk size for comprehensive content sections
-	overlapRunes := maxChunkRunes / 5
-
-	content = strings.TrimSpace(content)
-	if content == "" {
-		return []string{}
-	}
-
-	// If the entire content fits in one chunk, return it as a single chunk
-	if len(content) <= maxChunkRunes {
-		return []string{content}
-	}
-
-	// If content is too large, split by words with overlap
-	var chunks []string
-	words := strings.Fields(content)
-	if len(words) == 0 {
-		return []string{content}
+// ModelForLanguage returns the model chunks in language are embedded with.
+func (ve voyageEmbed) ModelForLanguage(language string) string {
+	if model, ok := ve.LanguageModels[language]; ok {
+		return model
 	}
+	return ve.Model
+}
 
-	for start := 0; start < len(words); {
-		cur := 0
-		end := start
-
-		// build chunk from start..end (exclusive) not exceeding maxChunkRunes
-		for end < len(words) {
-			wlen := len(words[end])
-			add := wlen
-			if end > start {
-				add += 1 // space
-			}
-			if cur+add > maxChunkRunes {
-				// if no progress (single word larger than limit), include it anyway
-				if end == start {
-					end++
-				}
-				break
-			}
-			cur += add
-			end++
-		}
-
-		// create chunk string from this range of words
-		chunk := strings.Join(words[start:end], " ")
-		chunks = append(chunks, strings.TrimSpace(chunk))
+// EmbedToVectorForLanguage embeds content with the model of language.
+func (ve voyageEmbed) EmbedToVectorForLanguage(ctx context.Context, content, language string) ([]float32, error) {
+	return ve.embedWithModel(ctx, content, ve.ModelForLanguage(language))
+}
 
-		// if we've reached the end, break
-		if end >= len(words) {
-			break
-		}
+func (ve voyageEmbed) CreateChunks(ctx context.Context, content string) []string {
+	return chunking.Texts(ve.Chunker.Chunk(content))
+}
 
-		// determine how many words to overlap to reach approximately overlapRunes
-		ovAccum := 0
-		overlapCount := 0
-		for k := end - 1; k >= start; k-- {
-			if overlapCount == 0 {
-				ovAccum += len(words[k])
-			} else {
-				ovAccum += 1 + len(words[k]) // space + word
-			}
-			overlapCount++
-			if ovAccum >= overlapRunes {
-				break
-			}
-		}
+func (ve voyageEmbed) EmbedToVector(ctx context.Context, content string) ([]float32, error) {
+	return ve.embedWithModel(ctx, content, ve.Model)
+}
 
-		newStart := end - overlapCount
-		// ensure progress; if overlap would not move forward, advance to end
-		if newStart <= start {
-			newStart = end
-		}
-		start = newStart
+// embedWithModel embeds content with model, truncated to ve.Dimensions.
+func (ve voyageEmbed) embedWithModel(ctx context.Context, content, model string) ([]float32, error) {
+	embedding, err := ve.request(ctx, content, model)
+	if err != nil {
+		return nil, err
 	}
-
-	return chunks
+	return Truncate(embedding, ve.Dimensions), nil
 }
 
-func (ve voyageEmbed) EmbedToVector(ctx context.Context, content string) ([]float32, error) {
-	voyageAPIKey := config.Config.VoyageAPIKey
+func (ve voyageEmbed) request(ctx context.Context, content, model string) (embedding []float32, err error) {
+	start := time.Now()
+	var tokens int
+	defer func() {
+		call := debugtrace.Call{Kind: "embedding", Provider: "voyage", Model: model, Tokens: tokens}
+		if err != nil {
+			call.Error = err.Error()
+		}
+		debugtrace.RecordCall(ctx, start, call)
+	}()
 
 	// assume that the string here is of appropriate size
 	reqBody := map[string]any{
 		"input":      []string{content},
-		"model":      ve.Model,
+		"model":      model,
 		"input_type": "document",
 	}
 
@@ -122,9 +124,19 @@ func (ve voyageEmbed) EmbedToVector(ctx context.Context, content string) ([]floa
 		return nil, err
 	}
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+voyageAPIKey)
+	req.Header.Set("Authorization", "Bearer "+ve.APIKey)
 
-	resp, err := http.DefaultClient.Do(req)
+	// wait for the budget before asking the breaker, so a long wait can't outlast its decision
+	estimate := estimateTokens(content)
+	if err := ve.Throttle.Wait(ctx, estimate); err != nil {
+		return nil, err
+	}
+	if err := voyageBreaker.Allow(); err != nil {
+		return nil, err
+	}
+
+	resp, err := httpclient.OrDefault(ve.Client).Do(req)
+	voyageBreaker.Record(ctx, resp, err)
 	if err != nil {
 		return nil, err
 	}
@@ -134,25 +146,36 @@ func (ve voyageEmbed) EmbedToVector(ctx context.Context, content string) ([]floa
 	if err != nil {
 		return nil, err
 	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		ve.Throttle.Backoff()
+		return nil, fmt.Errorf("%w: voyage API returned status %d: %s", vector.ErrRateLimited, resp.StatusCode, string(respBytes))
+	}
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		return nil, fmt.Errorf("voyage API returned status %d: %s", resp.StatusCode, string(respBytes))
 	}
 
 	type dataItem struct {
-		Object    string    `json:"object`
-		Embedding []float32 `json"embedding"`
-		Index     int       `json: "index"`
+		Object    string    `json:"object"`
+		Embedding []float32 `json:"embedding"`
+		Index     int       `json:"index"`
 	}
 
 	type voyageResp struct {
 		Object string     `json:"object"`
 		Data   []dataItem `json:"data"`
 		Model  string     `json:"model"`
-		Usage  any        `json:"usage"`
+		Usage  struct {
+			TotalTokens int `json:"total_tokens"`
+		} `json:"usage"`
 	}
 
 	var vr voyageResp
-	if err := json.Unmarshal(respBytes, &vr); err == nil {
+	err = json.Unmarshal(respBytes, &vr)
+	// record usage even if the embedding itself needs the tolerant decode below
+	tokens = vr.Usage.TotalTokens
+	ve.Throttle.Settle(estimate, tokens)
+	usage.RecordVoyage(ctx, tokens, len(content))
+	if err == nil {
 		// if the API returned float32 arrays directly into the struct, we're done
 		if len(vr.Data) > 0 && len(vr.Data[0].Embedding) > 0 {
 			return vr.Data[0].Embedding, nil
@@ -196,31 +219,109 @@ func (ve voyageEmbed) EmbedToVector(ctx context.Context, content string) ([]floa
 }
 
 func (ve voyageEmbed) EmbedStringToVectorData(ctx context.Context, content string, metadata map[string]string) ([]vector.VectorData, error) {
-	chunks := ve.CreateChunks(ctx, content)
-	vectors := []vector.VectorData{}
-	for _, chunk := range chunks {
-		embedding, err := ve.EmbedToVector(ctx, chunk)
-		if err != nil {
-			return nil, err
+	spans := ve.Chunker.Chunk(content)
+	chunks := make([]string, len(spans))
+	languages := make([]string, len(spans))
+	models := make([]string, len(spans))
+	embeddings := make([][]float32, len(spans))
+	var missing []int
+	for i, span := range spans {
+		// the chunk's position still refers to the original content
+		chunks[i] = ve.Redactor.Redact(span.Text, metadata["filepath"], i)
+		languages[i] = lang.Detect(chunks[i])
+		models[i] = ve.ModelForLanguage(languages[i])
+		// an unchanged chunk keeps the embedding it was stored with (see WithPrevious)
+		if embedding, ok := previousEmbedding(ctx, models[i], ve.Dimensions, chunks[i]); ok {
+			embeddings[i] = embedding
+		} else {
+			missing = append(missing, i)
 		}
+	}
+	if err := ve.embedChunks(ctx, chunks, models, embeddings, missing); err != nil {
+		return nil, err
+	}
 
-		short := chunk
-		if len(short) > 32 {
-			short = short[:32]
+	vectors := make([]vector.VectorData, 0, len(spans))
+	for i, span := range spans {
+		chunk := chunks[i]
+		md := ChunkMetadata(content, metadata, i, span)
+		if span.Window != "" {
+			md[WindowMetadataKey] = ve.Redactor.RedactContext(span.Window)
 		}
+		md[EmbeddingModelMetadataKey] = models[i]
+		if ve.Dimensions > 0 {
+			md[EmbeddingDimensionsMetadataKey] = strconv.Itoa(ve.Dimensions)
+		}
+		md[lang.MetadataKey] = languages[i]
 
 		chunkVectorData := vector.VectorData{
 			Content:   chunk,
-			Embedding: embedding,
-			Metadata:  metadata,
-			// create a reasonably unique ID using a short prefix of the chunk, the chunk pointer and embedding length
-			Id: fmt.Sprintf("voyage-%x-%p-%d", short, &chunk, len(embedding)),
+			Embedding: embeddings[i],
+			Metadata:  md,
+			Id:        chunkID(metadata["filepath"], i, chunk),
 		}
 		vectors = append(vectors, chunkVectorData)
 	}
 	return vectors, nil
 }
 
+// chunkID identifies the chunk at position i of the note at path by a hash of the path and
+// its content plus the position, so the same chunk gets the same ID on every reindex and
+// chunks of different notes never share one.
+func chunkID(path string, i int, content string) string {
+	sum := sha256.Sum256([]byte(path + "\x00" + content))
+	return fmt.Sprintf("voyage-%x-%d", sum[:8], i)
+}
+
+// embedChunks embeds the chunks at the indexes in missing into embeddings, with as many
+// requests in flight as the budget's concurrency allows (the Throttle paces them). The
+// first error stops the remaining chunks and is returned.
+func (ve voyageEmbed) embedChunks(ctx context.Context, chunks, models []string, embeddings [][]float32, missing []int) error {
+	if len(missing) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+	next := make(chan int)
+	for w := 0; w < min(ve.Throttle.Budget().Concurrency, len(missing)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				embedding, err := ve.embedWithModel(ctx, chunks[i], models[i])
+				if err != nil {
+					once.Do(func() {
+						firstErr = err
+						cancel()
+					})
+					continue
+				}
+				embeddings[i] = embedding
+			}
+		}()
+	}
+
+feed:
+	for _, i := range missing {
+		select {
+		case next <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(next)
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
 func (ve voyageEmbed) EmbedFileToVectorData(ctx context.Context, filename string, metadata map[string]string) ([]vector.VectorData, error) {
 	// Read the entire file content
 	b, err := os.ReadFile(filename)
@@ -242,6 +343,18 @@ func (ve voyageEmbed) EmbedFileToVectorData(ctx context.Context, filename string
 	}
 	metadata["filename"] = filepath.Base(filename)
 
+	// Record tags both as a readable list and as one key per tag for where filters
+	tags := ExtractTags(string(b))
+	if len(tags) > 0 {
+		metadata["tags"] = strings.Join(tags, ",")
+		for _, tag := range tags {
+			metadata[TagMetadataPrefix+tag] = "true"
+		}
+	}
+
+	// Private notes are filtered out for read-only API keys at query time
+	metadata[access.MetadataKey] = ExtractAccess(string(b))
+
 	// Delegate to EmbedStringToVectorData with the full file contents
 	return ve.EmbedStringToVectorData(ctx, string(b), metadata)
 }
@@ -0,0 +1,23 @@
+package vector
+
+import "errors"
+
+// Sentinel errors shared by the embed and manager packages. Callers should test for
+// them with errors.Is; implementations wrap them with additional context.
+var (
+	// ErrNotFound is returned when a requested document does not exist
+	ErrNotFound = errors.New("not found")
+	// ErrEmptyCollection is returned by similarity queries when there is nothing stored yet.
+	// Asking for more results than there are chunks is not an error; fewer results are returned.
+	ErrEmptyCollection = errors.New("collection is empty")
+	// ErrRateLimited is returned when an upstream API rejected a request with 429
+	ErrRateLimited = errors.New("rate limited")
+	// ErrDimensionMismatch is returned when an embedding's length differs from the stored ones,
+	// typically after switching embedding models without re-indexing
+	ErrDimensionMismatch = errors.New("embedding dimension mismatch")
+	// ErrCollectionExists is returned when creating a collection under a name already in use
+	ErrCollectionExists = errors.New("collection already exists")
+	// ErrCapacityExceeded is returned when storing chunks would take the store past its
+	// configured document or memory cap
+	ErrCapacityExceeded = errors.New("vector store capacity exceeded")
+)
@@ -0,0 +1,100 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"vex-backend/access"
+	"vex-backend/vector"
+)
+
+// accessControlled restricts every read of the wrapped Manager to the notes visible to the
+// caller's scope (see access.ScopeFrom). Writes and maintenance pass through unchanged;
+// read-only keys are kept away from them by the routes.
+type accessControlled struct {
+	Manager
+}
+
+// WithAccessControl wraps m so that callers with a shared scope never retrieve private
+// notes. Callers with full access see m unchanged.
+func WithAccessControl(m Manager) Manager {
+	return accessControlled{Manager: m}
+}
+
+// visibleOnly drops the chunks the caller of ctx may not see.
+func visibleOnly(ctx context.Context, vs []vector.VectorData) []vector.VectorData {
+	if access.ScopeFrom(ctx) == access.ScopeFull {
+		return vs
+	}
+	out := make([]vector.VectorData, 0, len(vs))
+	for _, v := range vs {
+		if access.Visible(ctx, v.Metadata) {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// visibleOrMissing returns v unless the caller of ctx may not see it, in which case it is
+// reported missing, so a read-only key can't tell a private note from an absent one.
+func visibleOrMissing(ctx context.Context, v vector.VectorData) (vector.VectorData, error) {
+	if !access.Visible(ctx, v.Metadata) {
+		return vector.VectorData{}, fmt.Errorf("document %q: %w", v.Id, vector.ErrNotFound)
+	}
+	return v, nil
+}
+
+func (a accessControlled) RetriveVectorByMetadata(ctx context.Context, key string, data string) (vector.VectorData, error) {
+	vs, err := a.GetByMetadata(ctx, map[string]string{key: data})
+	if err != nil {
+		return vector.VectorData{}, err
+	}
+	if len(vs) == 0 {
+		return vector.VectorData{}, fmt.Errorf("no document with metadata %s=%s: %w", key, data, vector.ErrNotFound)
+	}
+	return vs[0], nil
+}
+func (a accessControlled) RetriveVectorWithID(ctx context.Context, id string) (vector.VectorData, error) {
+	v, err := a.Manager.RetriveVectorWithID(ctx, id)
+	if err != nil {
+		return v, err
+	}
+	return visibleOrMissing(ctx, v)
+}
+func (a accessControlled) GetByID(ctx context.Context, id string) (vector.VectorData, error) {
+	v, err := a.Manager.GetByID(ctx, id)
+	if err != nil {
+		return v, err
+	}
+	return visibleOrMissing(ctx, v)
+}
+func (a accessControlled) GetByMetadata(ctx context.Context, where map[string]string) ([]vector.VectorData, error) {
+	return a.Manager.GetByMetadata(ctx, access.Where(ctx, where))
+}
+func (a accessControlled) GetChunksByFile(ctx context.Context, path string) ([]vector.VectorData, error) {
+	vs, err := a.Manager.GetChunksByFile(ctx, path)
+	return visibleOnly(ctx, vs), err
+}
+func (a accessControlled) RetriveNVectorsByQuery(ctx context.Context, query string, n int) ([]vector.VectorData, error) {
+	if access.ScopeFrom(ctx) == access.ScopeFull {
+		return a.Manager.RetriveNVectorsByQuery(ctx, query, n)
+	}
+	return a.Manager.RetriveNVectorsByQueryWithFilter(ctx, query, n, access.Where(ctx, nil))
+}
+func (a accessControlled) RetriveNVectorsByQueryWithFilter(ctx context.Context, query string, n int, where map[string]string) ([]vector.VectorData, error) {
+	return a.Manager.RetriveNVectorsByQueryWithFilter(ctx, query, n, access.Where(ctx, where))
+}
+func (a accessControlled) RetriveNVectorsByEmbedding(ctx context.Context, embedding []float32, n int, where map[string]string) ([]vector.VectorData, error) {
+	return a.Manager.RetriveNVectorsByEmbedding(ctx, embedding, n, access.Where(ctx, where))
+}
+func (a accessControlled) RetriveNVectorsByQueryRanked(ctx context.Context, query string, n int, where map[string]string, rank RankOptions) ([]vector.VectorData, error) {
+	return a.Manager.RetriveNVectorsByQueryRanked(ctx, query, n, access.Where(ctx, where), rank)
+}
+
+// Collection returns the other collection under the same access control.
+func (a accessControlled) Collection(name string) (Manager, error) {
+	m, err := a.Manager.Collection(name)
+	if err != nil {
+		return nil, err
+	}
+	return WithAccessControl(m), nil
+}
@@ -0,0 +1,150 @@
+package manager
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+	"vex-backend/audit"
+	"vex-backend/vector"
+)
+
+// audited records every modification of the wrapped Manager in the audit log, whether it
+// succeeded or not. Reads pass through unchanged.
+type audited struct {
+	Manager
+}
+
+// WithAudit wraps m so that stores, deletions, re-indexing, trash operations, imports and
+// deduplication are recorded with audit.Record.
+func WithAudit(m Manager) Manager {
+	return audited{Manager: m}
+}
+
+// chunkPaths returns the distinct source files of vs.
+func chunkPaths(vs []vector.VectorData) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, v := range vs {
+		if p := v.Metadata["filepath"]; p != "" && !seen[p] {
+			seen[p] = true
+			out = append(out, p)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// absPath returns filename as it is recorded in chunk metadata, so audit entries can be
+// found by the same path.
+func absPath(filename string) string {
+	if abs, err := filepath.Abs(filepath.Clean(filename)); err == nil {
+		return abs
+	}
+	return filename
+}
+
+func (a audited) StoreVectorInDB(ctx context.Context, v vector.VectorData) error {
+	err := a.Manager.StoreVectorInDB(ctx, v)
+	audit.Record(ctx, audit.Entry{Action: audit.ActionStore, Paths: chunkPaths([]vector.VectorData{v}), IDs: []string{v.Id}, Count: 1}, err)
+	return err
+}
+func (a audited) StoreVectorsInDB(ctx context.Context, vs []vector.VectorData) error {
+	err := a.Manager.StoreVectorsInDB(ctx, vs)
+	ids := make([]string, 0, len(vs))
+	for _, v := range vs {
+		ids = append(ids, v.Id)
+	}
+	audit.Record(ctx, audit.Entry{Action: audit.ActionStore, Paths: chunkPaths(vs), IDs: ids, Count: len(vs)}, err)
+	return err
+}
+func (a audited) StoreFileAsVectorsInDB(ctx context.Context, filename string) error {
+	err := a.Manager.StoreFileAsVectorsInDB(ctx, filename)
+	audit.Record(ctx, audit.Entry{Action: audit.ActionStore, Paths: []string{absPath(filename)}}, err)
+	return err
+}
+func (a audited) ReplaceFileVectorsInDB(ctx context.Context, filename string) error {
+	err := a.Manager.ReplaceFileVectorsInDB(ctx, filename)
+	audit.Record(ctx, audit.Entry{Action: audit.ActionReindex, Paths: []string{absPath(filename)}}, err)
+	return err
+}
+
+func (a audited) DeleteVectorWithID(ctx context.Context, id string) error {
+	path := ""
+	if v, err := a.Manager.GetByID(ctx, id); err == nil {
+		path = v.Metadata["filepath"]
+	}
+	err := a.Manager.DeleteVectorWithID(ctx, id)
+	entry := audit.Entry{Action: audit.ActionDelete, IDs: []string{id}}
+	if path != "" {
+		entry.Paths = []string{path}
+	}
+	audit.Record(ctx, entry, err)
+	return err
+}
+func (a audited) DeleteVectorsWithMetaData(ctx context.Context, key string, data string) error {
+	// counted up front, since the deletion doesn't report what it removed
+	matched, _ := a.Manager.GetByMetadata(ctx, map[string]string{key: data})
+	err := a.Manager.DeleteVectorsWithMetaData(ctx, key, data)
+	audit.Record(ctx, audit.Entry{Action: audit.ActionDelete, Paths: chunkPaths(matched), Detail: key + "=" + data, Count: len(matched)}, err)
+	return err
+}
+
+func (a audited) TrashVectorsWithMetaData(ctx context.Context, key string, data string) (int, error) {
+	matched, _ := a.Manager.GetByMetadata(ctx, map[string]string{key: data})
+	n, err := a.Manager.TrashVectorsWithMetaData(ctx, key, data)
+	audit.Record(ctx, audit.Entry{Action: audit.ActionTrash, Paths: chunkPaths(matched), Detail: key + "=" + data, Count: n}, err)
+	return n, err
+}
+func (a audited) RestoreFromTrash(ctx context.Context, path string, deletedAt string) (int, error) {
+	n, err := a.Manager.RestoreFromTrash(ctx, path, deletedAt)
+	detail := ""
+	if deletedAt != "" {
+		detail = "deleted_at=" + deletedAt
+	}
+	audit.Record(ctx, audit.Entry{Action: audit.ActionRestore, Paths: []string{path}, Detail: detail, Count: n}, err)
+	return n, err
+}
+func (a audited) PurgeTrash(ctx context.Context, before time.Time) (int, error) {
+	n, err := a.Manager.PurgeTrash(ctx, before)
+	// the hourly purge usually finds nothing, which isn't worth an entry
+	if n > 0 || err != nil {
+		audit.Record(ctx, audit.Entry{Action: audit.ActionPurge, Detail: "before=" + before.UTC().Format(time.RFC3339), Count: n}, err)
+	}
+	return n, err
+}
+
+func (a audited) Import(ctx context.Context, r io.ReadSeeker) error {
+	before, _ := a.Manager.Count(ctx)
+	err := a.Manager.Import(ctx, r)
+	after, _ := a.Manager.Count(ctx)
+	audit.Record(ctx, audit.Entry{Action: audit.ActionImport, Detail: "chunks before=" + strconv.Itoa(before), Count: after}, err)
+	return err
+}
+func (a audited) DeduplicateVectors(ctx context.Context, threshold float32) (int, error) {
+	n, err := a.Manager.DeduplicateVectors(ctx, threshold)
+	audit.Record(ctx, audit.Entry{Action: audit.ActionDedup, Detail: "threshold=" + strconv.FormatFloat(float64(threshold), 'g', -1, 32), Count: n}, err)
+	return n, err
+}
+func (a audited) Reindex(ctx context.Context, build func(staging Manager) error) error {
+	err := a.Manager.Reindex(ctx, build)
+	after, _ := a.Manager.Count(ctx)
+	audit.Record(ctx, audit.Entry{Action: audit.ActionReindex, Detail: "full rebuild into a staging collection", Count: after}, err)
+	return err
+}
+
+// Collection returns the other collection audited as well.
+func (a audited) Collection(name string) (Manager, error) {
+	m, err := a.Manager.Collection(name)
+	if err != nil {
+		return nil, err
+	}
+	return WithAudit(m), nil
+}
+func (a audited) CreateCollection(ctx context.Context, name string) error {
+	err := a.Manager.CreateCollection(ctx, name)
+	audit.Record(ctx, audit.Entry{Action: audit.ActionCreateCollection, Detail: "name=" + name}, err)
+	return err
+}
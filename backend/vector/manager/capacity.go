@@ -0,0 +1,49 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"vex-backend/config"
+	"vex-backend/vector"
+)
+
+// MemoryUsage estimates how much of the process's memory the vectors of a Manager take: those
+// of every collection, their trash and any staging index of a running Reindex.
+type MemoryUsage struct {
+	Chunks int `json:"chunks"`
+	// Dimensions is the length of the stored embeddings, 0 if nothing is stored yet
+	Dimensions int `json:"dimensions"`
+	// EmbeddingBytes is the memory the embeddings take, chunk text and metadata excluded
+	EmbeddingBytes int64 `json:"embedding_bytes"`
+	// Remote is set for backends keeping the vectors in a separate server, which take no
+	// memory here
+	Remote bool `json:"remote,omitempty"`
+	// MaxDocuments and MaxEmbeddingBytes are the caps enforced on stores, 0 for none
+	MaxDocuments      int   `json:"max_documents,omitempty"`
+	MaxEmbeddingBytes int64 `json:"max_embedding_bytes,omitempty"`
+}
+
+// remoteMemoryUsage is the MemoryUsage of backends that keep nothing in memory.
+func remoteMemoryUsage(ctx context.Context) (MemoryUsage, error) {
+	return MemoryUsage{Remote: true}, nil
+}
+
+// embeddingBytes is the memory n float32 embeddings of dims values take.
+func embeddingBytes(n, dims int) int64 {
+	return int64(n) * int64(dims) * 4
+}
+
+// checkCapacity returns vector.ErrCapacityExceeded if holding add more chunks with embeddings
+// of dims values next to the held ones would exceed MAX_DOCUMENTS or MAX_EMBEDDING_MEMORY.
+func checkCapacity(cfg *config.EnvConfig, held, add, dims int) error {
+	if cfg == nil || add <= 0 {
+		return nil
+	}
+	if cfg.MaxDocuments > 0 && held+add > cfg.MaxDocuments {
+		return fmt.Errorf("%w: %d chunks held, %d more would exceed MAX_DOCUMENTS=%d", vector.ErrCapacityExceeded, held, add, cfg.MaxDocuments)
+	}
+	if need := embeddingBytes(held+add, dims); cfg.MaxEmbeddingMemory > 0 && need > cfg.MaxEmbeddingMemory {
+		return fmt.Errorf("%w: %d more chunks would take %d bytes of embeddings, over MAX_EMBEDDING_MEMORY=%d", vector.ErrCapacityExceeded, add, need, cfg.MaxEmbeddingMemory)
+	}
+	return nil
+}
@@ -1,48 +1,182 @@
 package manager
 
 import (
+	"bytes"
 	"context"
+	"encoding/gob"
+	"errors"
 	"fmt"
+	"io"
+	"log"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 	"vex-backend/config"
+	"vex-backend/git"
 	"vex-backend/vector"
 	"vex-backend/vector/embed"
 
 	"github.com/philippgille/chromem-go"
 )
 
+const (
+	// notesCollection is the default VECTOR_COLLECTION, and the name snapshots hold the
+	// chunks under, whichever collection they were exported from
+	notesCollection = "notes"
+	// reindexInfix joins the name of a collection and a timestamp to name the collections
+	// built by its Reindex
+	reindexInfix = "-reindex-"
+	// liveCollectionFile, in VECTOR_STORAGE_FOLDER, names the collection queries are
+	// answered from once a Reindex swapped one in; chromem can't rename collections
+	liveCollectionFile = "live_collection"
+)
+
+// trashCollectionOf and liveCollectionFileOf name the trash and the alias file of the named
+// collection. Those of notesCollection keep the names they had before collections could be
+// named, so existing stores open unchanged.
+func trashCollectionOf(name string) string {
+	if name == notesCollection {
+		return "trash"
+	}
+	return name + ".trash"
+}
+func liveCollectionFileOf(name string) string {
+	if name == notesCollection {
+		return liveCollectionFile
+	}
+	return liveCollectionFile + "." + name
+}
+
 type chromemManager struct {
 	DBInstance *chromem.DB
 	Embedder   embed.Embedder
+	// Config supplies the reloadable settings (dedup threshold) at store time
+	Config config.Source
+	// encrypted is where the DB is persisted when ENCRYPTION_KEY is set, nil otherwise
+	encrypted *encryptedStore
+	// queries caches the embeddings of recent queries
+	queries *queryCache
+	// name is the collection the manager works on. notes names its live chromem collection,
+	// which changes when Reindex swaps in a new one, and trash its trash. aliasPath is where
+	// notes is persisted, empty for the staging manager of a Reindex
+	name      string
+	notes     string
+	trash     string
+	aliasPath string
+	// collections holds the managers of all collections opened so far
+	collections *chromemCollections
+	// reindexing allows one Reindex at a time
+	reindexing sync.Mutex
+
+	// mu makes every method atomic with respect to the others: writes hold it exclusively,
+	// so a query never sees a file half replaced or a chunk in both the notes and the trash,
+	// and reads share it. Chunks and queries are embedded before taking it, so a slow
+	// embedding call doesn't hold up the other requests. Methods named ...Locked expect the
+	// caller to hold it.
+	mu sync.RWMutex
 }
 
-// creates a Manager object for vectors,
-func NewChromemManager(e embed.Embedder) Manager {
+// creates a Manager object for vectors, persisted under the configured VECTOR_STORAGE_FOLDER.
+// With ENCRYPTION_KEY set the vectors are held in memory and written encrypted every few
+// seconds; call Flush before exiting.
+func NewChromemManager(cfg config.Source, e embed.Embedder) Manager {
 	var db *chromem.DB
+	var store *encryptedStore
 	var err error
 
-	storagePath := config.Config.VectorStorageFolder
+	storagePath := cfg().VectorStorageFolder
 
-	db, err = chromem.NewPersistentDB(storagePath, false)
-	if err != nil {
-		db = chromem.NewDB()
+	if passphrase := cfg().EncryptionKey; passphrase != "" {
+		db, store, err = openEncryptedDB(storagePath, passphrase, cfg().EncryptionKeyPrevious)
+		if err != nil {
+			// starting empty would overwrite the encrypted vectors on the next flush
+			panic(fmt.Sprintf("error opening encrypted vector storage: %v", err))
+		}
+	} else {
+		if _, err := os.Stat(encryptedStorePath(storagePath)); err == nil {
+			panic("vector storage is encrypted but ENCRYPTION_KEY is not set")
+		}
+		db, err = chromem.NewPersistentDB(storagePath, false)
+		if err != nil {
+			db = chromem.NewDB()
+		}
 	}
 
-	_, err = db.GetOrCreateCollection("notes", nil, e.EmbedToVector)
+	name := cfg().VectorCollection
+	if name == "" {
+		name = notesCollection
+	}
+	cm, err := openCollection(db, cfg, e, store, newQueryCache(cfg), name)
 	if err != nil {
-		panic("error getting or creating notes collection")
+		panic(fmt.Sprintf("error getting or creating the %s collection: %v", name, err))
+	}
+	cm.collections = &chromemCollections{byName: map[string]*chromemManager{name: cm}}
+	if store != nil {
+		go cm.runFlush(context.Background())
 	}
+	return cm
+}
 
+// openCollection returns a manager of the named collection of db, creating its chromem
+// collections if they don't exist yet. Its collections field is left to the caller.
+func openCollection(db *chromem.DB, cfg config.Source, e embed.Embedder, store *encryptedStore, queries *queryCache, name string) (*chromemManager, error) {
+	aliasPath := filepath.Join(cfg().VectorStorageFolder, liveCollectionFileOf(name))
+	notes := liveCollection(db, name, aliasPath)
+	if _, err := getOrCreateCollection(db, notes, e.EmbedToVector); err != nil {
+		return nil, err
+	}
+	// soft-deleted chunks live in their own collection, so no query can match them
+	trash := trashCollectionOf(name)
+	if _, err := getOrCreateCollection(db, trash, e.EmbedToVector); err != nil {
+		return nil, err
+	}
 	return &chromemManager{
 		DBInstance: db,
 		Embedder:   e,
+		Config:     cfg,
+		encrypted:  store,
+		queries:    queries,
+		name:       name,
+		notes:      notes,
+		trash:      trash,
+		aliasPath:  aliasPath,
+	}, nil
+}
+
+// liveCollection returns the chromem collection holding the chunks of the named collection:
+// the one named in the file at aliasPath if it exists, else name itself. Collections left
+// over from a Reindex that didn't finish are deleted.
+func liveCollection(db *chromem.DB, name, aliasPath string) string {
+	live := name
+	if data, err := os.ReadFile(aliasPath); err == nil {
+		if alias := strings.TrimSpace(string(data)); db.ListCollections()[alias] != nil {
+			live = alias
+		}
+	}
+	for other := range db.ListCollections() {
+		if strings.HasPrefix(other, name+reindexInfix) && other != live {
+			if err := deleteCollection(db, other); err != nil {
+				log.Printf("[chromemManager] warning: failed to delete unfinished reindex collection %s: %v", other, err)
+			}
+		}
 	}
+	return live
 }
 
-func (cm *chromemManager) getNotesCollection() chromem.Collection {
-	return *cm.DBInstance.GetCollection("notes", cm.Embedder.EmbedToVector)
+// getNotesCollection and getTrashCollection return the DB's own collections; a copy would
+// carry its own copy of chromem's document lock and no longer exclude concurrent writes.
+// The live collection of notes is read under cm.mu, since Reindex swaps it.
+func (cm *chromemManager) getNotesCollection() *chromem.Collection {
+	return cm.DBInstance.GetCollection(cm.notes, cm.Embedder.EmbedToVector)
+}
+func (cm *chromemManager) getTrashCollection() *chromem.Collection {
+	return cm.DBInstance.GetCollection(cm.trash, cm.Embedder.EmbedToVector)
 }
 func (cm *chromemManager) GetDBInstance() any {
 	return cm.DBInstance
@@ -50,123 +184,1177 @@ func (cm *chromemManager) GetDBInstance() any {
 func (cm *chromemManager) GetEmbedder() embed.Embedder {
 	return cm.Embedder
 }
+func (cm *chromemManager) EmbedQuery(ctx context.Context, query string, language string) ([]float32, error) {
+	return cm.queries.embed(ctx, cm.Embedder, query, language)
+}
+func (cm *chromemManager) QueryCacheStats() QueryCacheStats {
+	return cm.queries.stats()
+}
 
-// storage functions
-func (cm *chromemManager) StoreVectorInDB(ctx context.Context, v vector.VectorData) error {
-	doc := chromem.Document{
-		ID:        v.Id,
-		Metadata:  v.Metadata,
-		Embedding: v.Embedding,
-		Content:   v.Content,
+// heldChunks counts the documents of every chromem collection, which all stay in memory.
+func (cm *chromemManager) heldChunks() int {
+	n := 0
+	for _, col := range cm.DBInstance.ListCollections() {
+		n += col.Count()
+	}
+	return n
+}
+
+// dims returns the length of the stored embeddings, reading one stored document the first
+// time if nothing was stored since the DB was opened; 0 if there is none.
+func (cm *chromemManager) dims() int {
+	if d := cm.collections.dims.Load(); d > 0 {
+		return int(d)
+	}
+	for name, col := range cm.DBInstance.ListCollections() {
+		if col.Count() == 0 {
+			continue
+		}
+		docs, err := cm.listCollection(name)
+		if err != nil || len(docs) == 0 {
+			continue
+		}
+		cm.collections.dims.Store(int64(len(docs[0].Embedding)))
+		return len(docs[0].Embedding)
+	}
+	return 0
+}
+
+// MemoryUsage estimates the embeddings of all collections from their number and length,
+// since chromem holds every one of them in memory.
+func (cm *chromemManager) MemoryUsage(ctx context.Context) (MemoryUsage, error) {
+	usage := MemoryUsage{Chunks: cm.heldChunks(), Dimensions: cm.dims()}
+	usage.EmbeddingBytes = embeddingBytes(usage.Chunks, usage.Dimensions)
+	if c := cm.Config; c != nil {
+		usage.MaxDocuments = c().MaxDocuments
+		usage.MaxEmbeddingBytes = c().MaxEmbeddingMemory
+	}
+	return usage, nil
+}
+
+// listDocuments returns every document in the notes collection.
+func (cm *chromemManager) listDocuments() ([]chromem.Document, error) {
+	return cm.listCollection(cm.notes)
+}
+
+// listCollection returns every document in the named collection.
+func (cm *chromemManager) listCollection(name string) ([]chromem.Document, error) {
+	return exportedDocuments(cm.DBInstance, name)
+}
+
+// chromemLayout works around chromem-go reading the map of collections of a DB without its
+// lock when exporting: exports hold it for reading, and creating, deleting or importing
+// collections holds it for writing.
+var chromemLayout sync.RWMutex
+
+func exportCollections(db *chromem.DB, w io.Writer, compress bool, key string, names ...string) error {
+	chromemLayout.RLock()
+	defer chromemLayout.RUnlock()
+	return db.ExportToWriter(w, compress, key, names...)
+}
+func createCollection(db *chromem.DB, name string, f chromem.EmbeddingFunc) (*chromem.Collection, error) {
+	chromemLayout.Lock()
+	defer chromemLayout.Unlock()
+	return db.CreateCollection(name, nil, f)
+}
+func getOrCreateCollection(db *chromem.DB, name string, f chromem.EmbeddingFunc) (*chromem.Collection, error) {
+	chromemLayout.Lock()
+	defer chromemLayout.Unlock()
+	return db.GetOrCreateCollection(name, nil, f)
+}
+func deleteCollection(db *chromem.DB, name string) error {
+	chromemLayout.Lock()
+	defer chromemLayout.Unlock()
+	return db.DeleteCollection(name)
+}
+
+// exportedDocuments returns every document in the named collection of db. chromem has no
+// listing API, so the collection is exported to gob and decoded into a mirror of its export
+// format.
+func exportedDocuments(db *chromem.DB, name string) ([]chromem.Document, error) {
+	var buf bytes.Buffer
+	if err := exportCollections(db, &buf, false, "", name); err != nil {
+		return nil, err
+	}
+
+	var exported struct {
+		Collections map[string]*struct {
+			Name      string
+			Metadata  map[string]string
+			Documents map[string]*chromem.Document
+		}
 	}
+	if err := gob.NewDecoder(&buf).Decode(&exported); err != nil {
+		return nil, fmt.Errorf("failed to decode collection export: %w", err)
+	}
+
+	c, ok := exported.Collections[name]
+	if !ok {
+		return nil, nil
+	}
+	docs := make([]chromem.Document, 0, len(c.Documents))
+	for _, d := range c.Documents {
+		docs = append(docs, *d)
+	}
+	return docs, nil
+}
+
+// translateChromemError maps chromem's untyped errors onto the shared sentinel errors.
+// chromem only reports mismatched vector lengths as a plain string, so it is matched here
+// once rather than in every caller.
+func translateChromemError(err error) error {
+	if err != nil && strings.Contains(err.Error(), "vectors must have the same length") {
+		return fmt.Errorf("%w: %v", vector.ErrDimensionMismatch, err)
+	}
+	return err
+}
 
+func documentToVectorData(d chromem.Document) vector.VectorData {
+	return vector.VectorData{
+		Id:        d.ID,
+		Content:   d.Content,
+		Embedding: d.Embedding,
+		Metadata:  d.Metadata,
+	}
+}
+
+// isDuplicate reports whether v duplicates a stored chunk of the same file: same content
+// hash, or, with a threshold > 0, an embedding at least that similar. The same text in
+// another note is kept, so that note still finds it.
+func (cm *chromemManager) isDuplicate(ctx context.Context, v vector.VectorData, hash string, threshold float32) (bool, error) {
 	col := cm.getNotesCollection()
-	return (&col).AddDocument(ctx, doc)
+	if col.Count() == 0 || len(v.Embedding) == 0 {
+		return false, nil
+	}
+
+	path := v.Metadata["filepath"]
+	exact, err := col.QueryEmbedding(ctx, v.Embedding, 1, map[string]string{"filepath": path, ContentHashMetadataKey: hash}, nil)
+	if err != nil {
+		return false, translateChromemError(err)
+	}
+	if len(exact) > 0 {
+		return true, nil
+	}
+
+	if threshold <= 0 {
+		return false, nil
+	}
+	nearest, err := col.QueryEmbedding(ctx, v.Embedding, 1, map[string]string{"filepath": path}, nil)
+	if err != nil {
+		return false, translateChromemError(err)
+	}
+	return len(nearest) > 0 && nearest[0].Similarity >= threshold, nil
 }
+
+// storage functions
+func (cm *chromemManager) StoreVectorInDB(ctx context.Context, v vector.VectorData) error {
+	return cm.StoreVectorsInDB(ctx, []vector.VectorData{v})
+}
+
+// StoreVectorsInDB stores the vectors, skipping chunks that duplicate a stored chunk or an
+// earlier chunk of the same batch (see DedupSimilarityThresholdFrom for near-duplicates).
 func (cm *chromemManager) StoreVectorsInDB(ctx context.Context, vs []vector.VectorData) error {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	_, err := cm.storeLocked(ctx, vs)
+	return err
+}
+
+// storeLocked is StoreVectorsInDB, also returning the IDs stored before any error so a
+// failed batch can be rolled back.
+func (cm *chromemManager) storeLocked(ctx context.Context, vs []vector.VectorData) ([]string, error) {
+	defer cm.changed()
+	threshold := DedupSimilarityThresholdFrom(cm.Config())
+	// duplicates are looked for within a file, so both are keyed by its path
+	seen := map[string]bool{}
+	kept := map[string][][]float32{}
+	var added []string
+
+	// the whole batch is rejected up front rather than stored in part, duplicates included
+	if len(vs) > 0 {
+		if err := checkCapacity(cm.Config(), cm.heldChunks(), len(vs), len(vs[0].Embedding)); err != nil {
+			return nil, err
+		}
+		if dims := len(vs[0].Embedding); dims > 0 {
+			cm.collections.dims.Store(int64(dims))
+		}
+	}
+
 	for _, v := range vs {
-		if err := cm.StoreVectorInDB(ctx, v); err != nil {
-			return err
+		path, hash := v.Metadata["filepath"], contentHash(v.Content)
+		if seen[path+"\x00"+hash] {
+			continue
+		}
+
+		dup, err := cm.isDuplicate(ctx, v, hash, threshold)
+		if err != nil {
+			return added, err
+		}
+		if !dup && threshold > 0 {
+			for _, e := range kept[path] {
+				if cosineSimilarity(e, v.Embedding) >= threshold {
+					dup = true
+					break
+				}
+			}
+		}
+		if dup {
+			log.Printf("[chromemManager] skipping duplicate chunk %s", v.Id)
+			continue
+		}
+
+		// chunks of one file share their metadata map, so copy it before adding the hash
+		metadata := make(map[string]string, len(v.Metadata)+1)
+		for k, val := range v.Metadata {
+			metadata[k] = val
+		}
+		metadata[ContentHashMetadataKey] = hash
+
+		doc := chromem.Document{
+			ID:        v.Id,
+			Metadata:  metadata,
+			Embedding: v.Embedding,
+			Content:   v.Content,
+		}
+		col := cm.getNotesCollection()
+		if err := col.AddDocument(ctx, doc); err != nil {
+			return added, err
 		}
+
+		seen[path+"\x00"+hash] = true
+		kept[path] = append(kept[path], v.Embedding)
+		added = append(added, v.Id)
 	}
-	return nil
+	return added, nil
 }
 func (cm *chromemManager) StoreFileAsVectorsInDB(ctx context.Context, filename string) error {
-	// get metadata
-	// read the file to obtain emtadata, title, date,
-	// get file data like the actual contents
-	// convert ile data to chunks using chunking function
-	// convert these chunks to StoreFileAsVectorsInDBrun the storevectorsinDB function
-	// return an error if it happens
+	filepathParsed, metadata, err := fileMetadata(filename)
+	if err != nil {
+		return err
+	}
 
-	// properly unfold filepath
-	filepathParsed, err := filepath.Abs(filepath.Clean(filename))
+	vs, err := cm.Embedder.EmbedFileToVectorData(ctx, filepathParsed, metadata)
 	if err != nil {
 		return err
 	}
 
-	info, err := os.Stat(filepathParsed)
+	if err := cm.StoreVectorsInDB(ctx, vs); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ReplaceFileVectorsInDB embeds the whole file before touching the collection, then swaps
+// the file's stored chunks for the new ones. Chunks whose content is unchanged keep their
+// stored embedding instead of being embedded again (see embed.WithPrevious), so editing one
+// paragraph of a large note only embeds the chunks it touched. With soft deletion only the
+// chunks whose content is gone are trashed; unchanged ones are stored again with the file's
+// new metadata. If storing fails midway, the chunks stored so far are deleted and the
+// previous ones restored, so the file is never left half indexed. Queries running meanwhile
+// see either the previous chunks or the new ones.
+func (cm *chromemManager) ReplaceFileVectorsInDB(ctx context.Context, filename string) error {
+	defer cm.changed()
+	path, metadata, err := fileMetadata(filename)
+	if err != nil {
+		return err
+	}
+	reusable, err := cm.GetChunksByFile(ctx, path)
 	if err != nil {
 		return err
 	}
 
-	metadata := map[string]string{
-		"filename": filepath.Base(filepathParsed),
-		"filepath": filepathParsed,
-		"mod_time": info.ModTime().UTC().Format(time.RFC3339),
-		"size":     string(info.Size()),
+	// embedding is the step most likely to fail, and nothing is stored until it succeeded
+	vs, err := cm.Embedder.EmbedFileToVectorData(embed.WithPrevious(ctx, reusable), path, metadata)
+	if err != nil {
+		return err
 	}
 
-	vs, err := cm.Embedder.EmbedFileToVectorData(ctx, filepathParsed, metadata)
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	// the file may have been replaced by another sync while this one was embedding
+	previous, err := cm.getByMetadataLocked(map[string]string{"filepath": path})
 	if err != nil {
 		return err
 	}
+	removed, kept, unchanged := diffChunks(previous, vs)
+	if len(previous) > 0 {
+		log.Printf("[chromemManager] %s: %d of %d chunks unchanged, %d removed", path, unchanged, len(vs), len(removed))
+	}
 
-	if err := cm.StoreVectorsInDB(ctx, vs); err != nil {
+	// the old chunks go first, otherwise unchanged chunks would be skipped as duplicates
+	trashedAt := ""
+	if cm.softDelete() {
+		trashedAt = deletionTime(time.Now())
+		if err = cm.moveToTrashLocked(ctx, removed, trashedAt); err == nil && len(kept) > 0 {
+			col := cm.getNotesCollection()
+			err = col.Delete(ctx, nil, nil, kept...)
+		}
+	} else {
+		col := cm.getNotesCollection()
+		err = col.Delete(ctx, map[string]string{"filepath": path}, nil)
+	}
+	if err != nil {
 		return err
 	}
 
+	added, err := cm.storeLocked(ctx, vs)
+	if err == nil {
+		return nil
+	}
+
+	log.Printf("[chromemManager] storing %s failed after %d chunks, rolling back: %v", path, len(added), err)
+	col := cm.getNotesCollection()
+	if len(added) > 0 {
+		if delErr := col.Delete(ctx, nil, nil, added...); delErr != nil {
+			return errors.Join(err, fmt.Errorf("rollback failed to delete new chunks: %w", delErr))
+		}
+	}
+	if addErr := cm.addDocuments(ctx, col, previous); addErr != nil {
+		return errors.Join(err, fmt.Errorf("rollback failed to restore previous chunks: %w", addErr))
+	}
+	if trashedAt != "" && len(removed) > 0 {
+		trash := cm.getTrashCollection()
+		ids := make([]string, 0, len(removed))
+		for _, v := range removed {
+			ids = append(ids, tombstone(v, trashedAt).Id)
+		}
+		if delErr := trash.Delete(ctx, nil, nil, ids...); delErr != nil {
+			log.Printf("[chromemManager] warning: failed to clear rolled back chunks of %s from the trash: %v", path, delErr)
+		}
+	}
+	return err
+}
+
+// diffChunks splits the previous chunks of a file into those whose content no longer
+// appears among the next chunks and the IDs of those whose content does, and counts the
+// next chunks that were already stored.
+func diffChunks(previous, next []vector.VectorData) (removed []vector.VectorData, kept []string, unchanged int) {
+	nextContents := make(map[string]bool, len(next))
+	for _, v := range next {
+		nextContents[v.Content] = true
+	}
+	previousContents := make(map[string]bool, len(previous))
+	for _, v := range previous {
+		previousContents[v.Content] = true
+		if nextContents[v.Content] {
+			kept = append(kept, v.Id)
+		} else {
+			removed = append(removed, v)
+		}
+	}
+	for _, v := range next {
+		if previousContents[v.Content] {
+			unchanged++
+		}
+	}
+	return removed, kept, unchanged
+}
+
+// addDocuments adds vs to col as they are, without duplicate checks.
+func (cm *chromemManager) addDocuments(ctx context.Context, col *chromem.Collection, vs []vector.VectorData) error {
+	for _, v := range vs {
+		doc := chromem.Document{ID: v.Id, Metadata: v.Metadata, Embedding: v.Embedding, Content: v.Content}
+		if err := col.AddDocument(ctx, doc); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// fileMetadata resolves filename to an absolute path and returns it with the base metadata
+// (name, path, modification time, size and, inside a git repository, the date, hash and
+// author of the last commit and the submodule the file belongs to) recorded for every chunk
+// of the file. Dates are RFC 3339 in UTC, so they sort as strings.
+func fileMetadata(filename string) (string, map[string]string, error) {
+	// properly unfold filepath
+	filepathParsed, err := filepath.Abs(filepath.Clean(filename))
+	if err != nil {
+		return "", nil, err
+	}
+
+	info, err := os.Stat(filepathParsed)
+	if err != nil {
+		return "", nil, err
+	}
+
+	metadata := map[string]string{
+		"filename": filepath.Base(filepathParsed),
+		"filepath": filepathParsed,
+		"mod_time": info.ModTime().UTC().Format(time.RFC3339),
+		"size":     strconv.FormatInt(info.Size(), 10),
+	}
+	// a fresh clone resets every modification time, the commit date survives it
+	for k, v := range git.FileMetadata(filepathParsed) {
+		metadata[k] = v
+	}
+	return filepathParsed, metadata, nil
+}
+
 // retrieval functions
 func (cm *chromemManager) RetriveVectorByMetadata(ctx context.Context, key string, data string) (vector.VectorData, error) {
-	where := map[string]string{key: data}
-	col := cm.getNotesCollection()
-	results, err := (&col).Query(ctx, "", 1, where, nil)
+	vs, err := cm.GetByMetadata(ctx, map[string]string{key: data})
 	if err != nil {
 		return vector.VectorData{}, err
 	}
-	if len(results) == 0 {
-		return vector.VectorData{}, fmt.Errorf("no document found with metadata %s=%s", key, data)
+	if len(vs) == 0 {
+		return vector.VectorData{}, fmt.Errorf("no document with metadata %s=%s: %w", key, data, vector.ErrNotFound)
 	}
-	r := results[0]
-	return vector.VectorData{
-		Content:   r.Content,
-		Embedding: r.Embedding,
-		Metadata:  r.Metadata,
-	}, nil
+	return vs[0], nil
 }
 func (cm *chromemManager) RetriveVectorWithID(ctx context.Context, id string) (vector.VectorData, error) {
+	return cm.GetByID(ctx, id)
+}
+func (cm *chromemManager) GetByID(ctx context.Context, id string) (vector.VectorData, error) {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+
 	col := cm.getNotesCollection()
-	doc, err := (&col).GetByID(ctx, id)
+	doc, err := col.GetByID(ctx, id)
 	if err != nil {
-		return vector.VectorData{}, err
+		// chromem's only GetByID failures are an empty ID and a missing document
+		return vector.VectorData{}, fmt.Errorf("document %q: %w", id, vector.ErrNotFound)
 	}
-	return vector.VectorData{
-		Content:   doc.Content,
-		Embedding: doc.Embedding,
-		Metadata:  doc.Metadata,
-	}, nil
+	return documentToVectorData(doc), nil
+}
+func (cm *chromemManager) GetByMetadata(ctx context.Context, where map[string]string) ([]vector.VectorData, error) {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+
+	return cm.getByMetadataLocked(where)
+}
+func (cm *chromemManager) getByMetadataLocked(where map[string]string) ([]vector.VectorData, error) {
+	docs, err := cm.listDocuments()
+	if err != nil {
+		return nil, err
+	}
+
+	out := []vector.VectorData{}
+	for _, d := range docs {
+		if matchesMetadata(d.Metadata, where) {
+			out = append(out, documentToVectorData(d))
+		}
+	}
+	sortByPosition(out)
+	return out, nil
+}
+func (cm *chromemManager) GetChunksByFile(ctx context.Context, path string) ([]vector.VectorData, error) {
+	return cm.GetByMetadata(ctx, map[string]string{"filepath": path})
 }
 func (cm *chromemManager) RetriveNVectorsByQuery(ctx context.Context, query string, n int) ([]vector.VectorData, error) {
+	return cm.RetriveNVectorsByQueryWithFilter(ctx, query, n, nil)
+}
+func (cm *chromemManager) RetriveNVectorsByQueryWithFilter(ctx context.Context, query string, n int, where map[string]string) ([]vector.VectorData, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("n must be > 0")
+	}
+	if query == "" {
+		return nil, errors.New("query failed: query is empty")
+	}
+	// an empty collection fails without spending an embedding on the query
+	if count, _ := cm.Count(ctx); count == 0 {
+		return nil, vector.ErrEmptyCollection
+	}
+
+	// embedded the way chromem's Query would, but without holding the lock
+	embedding, err := cm.EmbedQuery(ctx, query, "")
+	if err != nil {
+		return nil, fmt.Errorf("query failed: couldn't create embedding of query: %w", err)
+	}
+	return cm.RetriveNVectorsByEmbedding(ctx, embedding, n, where)
+}
+func (cm *chromemManager) RetriveNVectorsByEmbedding(ctx context.Context, embedding []float32, n int, where map[string]string) ([]vector.VectorData, error) {
 	if n <= 0 {
 		return nil, fmt.Errorf("n must be > 0")
 	}
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+
 	col := cm.getNotesCollection()
-	results, err := (&col).Query(ctx, query, n, nil, nil)
+
+	// chromem rejects nResults larger than the collection, so clamp instead of failing
+	count := col.Count()
+	if count == 0 {
+		return nil, vector.ErrEmptyCollection
+	}
+	if n > count {
+		n = count
+	}
+
+	results, err := col.QueryEmbedding(ctx, embedding, n, where, nil)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("query failed: %w", translateChromemError(err))
 	}
+	return resultsToVectorData(results), nil
+}
+
+func resultsToVectorData(results []chromem.Result) []vector.VectorData {
 	out := make([]vector.VectorData, 0, len(results))
 	for _, r := range results {
 		out = append(out, vector.VectorData{
-			Content:   r.Content,
-			Embedding: r.Embedding,
-			Metadata:  r.Metadata,
+			Id:         r.ID,
+			Content:    r.Content,
+			Embedding:  r.Embedding,
+			Metadata:   r.Metadata,
+			Similarity: r.Similarity,
 		})
 	}
-	return out, nil
+	return out
+}
+func (cm *chromemManager) RetriveNVectorsByQueryRanked(ctx context.Context, query string, n int, where map[string]string, rank RankOptions) ([]vector.VectorData, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("n must be > 0")
+	}
+	if rank.RecencyWeight <= 0 {
+		return cm.RetriveNVectorsByQueryWithFilter(ctx, query, n, where)
+	}
+
+	// the candidate pool is clamped to the collection size by the filtered query
+	candidates, err := cm.RetriveNVectorsByQueryWithFilter(ctx, query, n*rankCandidateFactor, where)
+	if err != nil {
+		return nil, err
+	}
+	return rankByRecency(candidates, n, rank, time.Now()), nil
 }
 
 // deletion functions
 func (cm *chromemManager) DeleteVectorWithID(ctx context.Context, id string) error {
+	defer cm.changed()
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
 	col := cm.getNotesCollection()
-	return (&col).Delete(ctx, nil, nil, id)
+	return col.Delete(ctx, nil, nil, id)
 }
 func (cm *chromemManager) DeleteVectorsWithMetaData(ctx context.Context, key string, data string) error {
+	defer cm.changed()
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	if cm.softDelete() {
+		_, err := cm.trashLocked(ctx, key, data)
+		return err
+	}
+
 	where := map[string]string{key: data}
 	col := cm.getNotesCollection()
 
-	return (&col).Delete(ctx, where, nil)
+	return col.Delete(ctx, where, nil)
+}
+
+// softDelete reports whether SOFT_DELETE is currently enabled.
+func (cm *chromemManager) softDelete() bool {
+	return cm.Config != nil && cm.Config().SoftDelete
+}
+
+// trash functions
+func (cm *chromemManager) TrashVectorsWithMetaData(ctx context.Context, key string, data string) (int, error) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	return cm.trashLocked(ctx, key, data)
+}
+func (cm *chromemManager) trashLocked(ctx context.Context, key string, data string) (int, error) {
+	vs, err := cm.getByMetadataLocked(map[string]string{key: data})
+	if err != nil {
+		return 0, err
+	}
+	if err := cm.moveToTrashLocked(ctx, vs, deletionTime(time.Now())); err != nil {
+		return 0, err
+	}
+	return len(vs), nil
+}
+
+// moveToTrashLocked tombstones vs with the deletion time at, adds them to the trash and
+// removes them from the notes collection.
+func (cm *chromemManager) moveToTrashLocked(ctx context.Context, vs []vector.VectorData, at string) error {
+	defer cm.changed()
+	if len(vs) == 0 {
+		return nil
+	}
+	trashed := make([]vector.VectorData, 0, len(vs))
+	ids := make([]string, 0, len(vs))
+	for _, v := range vs {
+		trashed = append(trashed, tombstone(v, at))
+		ids = append(ids, v.Id)
+	}
+
+	trash := cm.getTrashCollection()
+	if err := cm.addDocuments(ctx, trash, trashed); err != nil {
+		return fmt.Errorf("failed to move chunks to the trash: %w", err)
+	}
+	col := cm.getNotesCollection()
+	return col.Delete(ctx, nil, nil, ids...)
+}
+func (cm *chromemManager) ListTrash(ctx context.Context) ([]vector.VectorData, error) {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+
+	return cm.listTrashLocked()
+}
+func (cm *chromemManager) listTrashLocked() ([]vector.VectorData, error) {
+	docs, err := cm.listCollection(cm.trash)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]vector.VectorData, 0, len(docs))
+	for _, d := range docs {
+		out = append(out, documentToVectorData(d))
+	}
+	sortByPosition(out)
+	return out, nil
+}
+func (cm *chromemManager) RestoreFromTrash(ctx context.Context, path string, deletedAt string) (int, error) {
+	defer cm.changed()
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	trashed, err := cm.listTrashLocked()
+	if err != nil {
+		return 0, err
+	}
+	if deletedAt == "" {
+		deletedAt = latestDeletion(trashed, path)
+	}
+
+	var restore []vector.VectorData
+	var trashIDs []string
+	for _, v := range trashed {
+		if v.Metadata["filepath"] == path && deletedAt != "" && v.Metadata[DeletedAtMetadataKey] == deletedAt {
+			restore = append(restore, untombstone(v))
+			trashIDs = append(trashIDs, v.Id)
+		}
+	}
+	if len(restore) == 0 {
+		return 0, fmt.Errorf("no deleted chunks of %q in the trash: %w", path, vector.ErrNotFound)
+	}
+
+	// the current chunks are trashed in exchange, so the restore itself can be undone
+	if _, err := cm.trashLocked(ctx, "filepath", path); err != nil {
+		return 0, err
+	}
+	col := cm.getNotesCollection()
+	if err := cm.addDocuments(ctx, col, restore); err != nil {
+		return 0, fmt.Errorf("failed to restore chunks: %w", err)
+	}
+	trash := cm.getTrashCollection()
+	if err := trash.Delete(ctx, nil, nil, trashIDs...); err != nil {
+		return 0, err
+	}
+	return len(restore), nil
+}
+func (cm *chromemManager) PurgeTrash(ctx context.Context, before time.Time) (int, error) {
+	defer cm.changed()
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	trashed, err := cm.listTrashLocked()
+	if err != nil {
+		return 0, err
+	}
+	cutoff := deletionTime(before)
+	var ids []string
+	for _, v := range trashed {
+		if v.Metadata[DeletedAtMetadataKey] < cutoff {
+			ids = append(ids, v.Id)
+		}
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	trash := cm.getTrashCollection()
+	if err := trash.Delete(ctx, nil, nil, ids...); err != nil {
+		return 0, err
+	}
+	return len(ids), nil
+}
+
+// snapshot functions
+func (cm *chromemManager) Export(ctx context.Context, w io.Writer) error {
+	// every snapshot is encrypted with a key of its own salt, derived before taking the lock
+	export := func(db *chromem.DB, names ...string) error {
+		return exportCollections(db, w, true, "", names...)
+	}
+	if passphrase := cm.Config().EncryptionKey; passphrase != "" {
+		key, err := newEncryptionKey(passphrase)
+		if err != nil {
+			return err
+		}
+		export = func(db *chromem.DB, names ...string) error {
+			return writeSealed(w, db, key, names...)
+		}
+	}
+
+	// the notes and the trash are exported as of the same moment
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+
+	if cm.notes == notesCollection && cm.trash == "trash" {
+		return export(cm.DBInstance, notesCollection, "trash")
+	}
+
+	// snapshots always hold the chunks under notesCollection and "trash", whichever
+	// collection they come from
+	scratch := chromem.NewDB()
+	for name, from := range map[string]string{notesCollection: cm.notes, "trash": cm.trash} {
+		docs, err := cm.listCollection(from)
+		if err != nil {
+			return err
+		}
+		col, err := scratch.CreateCollection(name, nil, cm.Embedder.EmbedToVector)
+		if err != nil {
+			return err
+		}
+		if len(docs) > 0 {
+			if err := col.AddDocuments(ctx, docs, 1); err != nil {
+				return err
+			}
+		}
+	}
+	return export(scratch)
+}
+func (cm *chromemManager) Import(ctx context.Context, r io.ReadSeeker) error {
+	defer cm.changed()
+	// decode into a scratch DB first, so an unreadable snapshot leaves the store untouched
+	scratch, opened, err := cm.readSnapshot(r)
+	if err != nil {
+		return fmt.Errorf("failed to read snapshot: %w", err)
+	}
+
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	if _, ok := scratch.ListCollections()[notesCollection]; !ok {
+		return errors.New("failed to read snapshot: no notes collection")
+	}
+	if cm.name != notesCollection {
+		return cm.importCopyLocked(ctx, scratch)
+	}
+	if _, err := r.Seek(opened.offset, io.SeekStart); err != nil {
+		return err
+	}
+	// a snapshot is imported into notesCollection, which stops being live after a Reindex
+	if _, err := getOrCreateCollection(cm.DBInstance, notesCollection, cm.Embedder.EmbedToVector); err != nil {
+		return err
+	}
+
+	// chromem's import only writes the snapshot's documents to disk, so chunks missing from
+	// the snapshot are deleted first, otherwise they would reappear after a restart
+	for _, name := range []string{notesCollection, "trash"} {
+		keep := map[string]bool{}
+		if _, ok := scratch.ListCollections()[name]; ok {
+			docs, err := exportedDocuments(scratch, name)
+			if err != nil {
+				return err
+			}
+			for _, d := range docs {
+				keep[d.ID] = true
+			}
+		}
+
+		current, err := cm.listCollection(name)
+		if err != nil {
+			return err
+		}
+		var stale []string
+		for _, d := range current {
+			if !keep[d.ID] {
+				stale = append(stale, d.ID)
+			}
+		}
+		if len(stale) > 0 {
+			col := cm.DBInstance.GetCollection(name, cm.Embedder.EmbedToVector)
+			if err := col.Delete(ctx, nil, nil, stale...); err != nil {
+				return err
+			}
+		}
+	}
+
+	// each collection is swapped in one step
+	chromemLayout.Lock()
+	err = cm.DBInstance.ImportFromReader(r, opened.key.key, notesCollection, "trash")
+	chromemLayout.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to import snapshot: %w", err)
+	}
+	old := cm.notes
+	if err := cm.swapLocked(notesCollection); err != nil {
+		return err
+	}
+	if old != notesCollection {
+		if err := deleteCollection(cm.DBInstance, old); err != nil {
+			log.Printf("[chromemManager] warning: failed to delete previous collection %s: %v", old, err)
+		}
+	}
+	// snapshots taken before soft deletion existed have no trash
+	if _, err := getOrCreateCollection(cm.DBInstance, "trash", cm.Embedder.EmbedToVector); err != nil {
+		return err
+	}
+	return nil
+}
+
+// importCopyLocked replaces the chunks and the trash of a collection other than
+// notesCollection with those of the snapshot decoded into scratch. chromem imports
+// collections under the names they were exported with, so the chunks are copied into a new
+// collection that is swapped in, and the trash is emptied and refilled.
+func (cm *chromemManager) importCopyLocked(ctx context.Context, scratch *chromem.DB) error {
+	docs, err := exportedDocuments(scratch, notesCollection)
+	if err != nil {
+		return err
+	}
+	// snapshots taken before soft deletion existed have no trash
+	trashed, err := exportedDocuments(scratch, "trash")
+	if err != nil {
+		return err
+	}
+
+	name := cm.name + reindexInfix + strconv.FormatInt(time.Now().UnixNano(), 10)
+	col, err := createCollection(cm.DBInstance, name, cm.Embedder.EmbedToVector)
+	if err != nil {
+		return err
+	}
+	if len(docs) > 0 {
+		if err := col.AddDocuments(ctx, docs, 1); err != nil {
+			if derr := deleteCollection(cm.DBInstance, name); derr != nil {
+				log.Printf("[chromemManager] warning: failed to delete import collection %s: %v", name, derr)
+			}
+			return fmt.Errorf("failed to import snapshot: %w", err)
+		}
+	}
+
+	current, err := cm.listCollection(cm.trash)
+	if err != nil {
+		return err
+	}
+	trash := cm.getTrashCollection()
+	ids := make([]string, 0, len(current))
+	for _, d := range current {
+		ids = append(ids, d.ID)
+	}
+	if len(ids) > 0 {
+		if err := trash.Delete(ctx, nil, nil, ids...); err != nil {
+			return err
+		}
+	}
+	if len(trashed) > 0 {
+		if err := trash.AddDocuments(ctx, trashed, 1); err != nil {
+			return fmt.Errorf("failed to import snapshot: %w", err)
+		}
+	}
+
+	old := cm.notes
+	if err := cm.swapLocked(name); err != nil {
+		return err
+	}
+	if err := deleteCollection(cm.DBInstance, old); err != nil {
+		log.Printf("[chromemManager] warning: failed to delete previous collection %s: %v", old, err)
+	}
+	return nil
+}
+
+// readSnapshot decodes a snapshot into a scratch DB with the first key that opens it: the
+// current ENCRYPTION_KEY, the previous one, or none for snapshots taken unencrypted.
+func (cm *chromemManager) readSnapshot(r io.ReadSeeker) (*chromem.DB, sealedExport, error) {
+	var passphrases []string
+	for _, passphrase := range []string{cm.Config().EncryptionKey, cm.Config().EncryptionKeyPrevious} {
+		if passphrase != "" {
+			passphrases = append(passphrases, passphrase)
+		}
+	}
+	passphrases = append(passphrases, "")
+
+	var scratch *chromem.DB
+	opened, err := openSealed(r, passphrases, func(export io.ReadSeeker, key string) error {
+		scratch = chromem.NewDB()
+		return scratch.ImportFromReader(export, key)
+	})
+	if err != nil {
+		return nil, sealedExport{}, err
+	}
+	return scratch, opened, nil
+}
+
+// maintenance functions
+func (cm *chromemManager) Count(ctx context.Context) (int, error) {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+
+	col := cm.getNotesCollection()
+	return col.Count(), nil
+}
+func (cm *chromemManager) DeduplicateVectors(ctx context.Context, threshold float32) (int, error) {
+	defer cm.changed()
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	docs, err := cm.listDocuments()
+	if err != nil {
+		return 0, err
+	}
+	// sort for a deterministic choice of which duplicate survives
+	sort.Slice(docs, func(i, j int) bool { return docs[i].ID < docs[j].ID })
+
+	// duplicates are only looked for within a file, as when storing: seen is keyed by
+	// filepath and content hash, kept by filepath
+	seen := map[string]bool{}
+	kept := map[string][][]float32{}
+	var remove []string
+	for _, d := range docs {
+		path := d.Metadata["filepath"]
+		hash := d.Metadata[ContentHashMetadataKey]
+		if hash == "" {
+			hash = contentHash(d.Content)
+		}
+		key := path + "\x00" + hash
+
+		dup := seen[key]
+		if !dup && threshold > 0 {
+			for _, e := range kept[path] {
+				if cosineSimilarity(e, d.Embedding) >= threshold {
+					dup = true
+					break
+				}
+			}
+		}
+		if dup {
+			remove = append(remove, d.ID)
+			continue
+		}
+
+		seen[key] = true
+		kept[path] = append(kept[path], d.Embedding)
+	}
+
+	if len(remove) == 0 {
+		return 0, nil
+	}
+	col := cm.getNotesCollection()
+	if err := col.Delete(ctx, nil, nil, remove...); err != nil {
+		return 0, err
+	}
+	return len(remove), nil
+}
+
+// Reindex builds the new index in a collection of its own, through a manager sharing the
+// DB and the trash, and swaps it in by switching the name of the live collection.
+func (cm *chromemManager) Reindex(ctx context.Context, build func(staging Manager) error) error {
+	defer cm.changed()
+	cm.reindexing.Lock()
+	defer cm.reindexing.Unlock()
+
+	name := cm.name + reindexInfix + strconv.FormatInt(time.Now().UnixNano(), 10)
+	if _, err := createCollection(cm.DBInstance, name, cm.Embedder.EmbedToVector); err != nil {
+		return err
+	}
+	swapped := false
+	defer func() {
+		if !swapped {
+			if err := deleteCollection(cm.DBInstance, name); err != nil {
+				log.Printf("[chromemManager] warning: failed to delete reindex collection %s: %v", name, err)
+			}
+		}
+	}()
+
+	cm.mu.RLock()
+	live, err := cm.listDocuments()
+	cm.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	before := fileFingerprints(documentsToVectorData(live))
+
+	staging := &chromemManager{
+		DBInstance:  cm.DBInstance,
+		Embedder:    cm.Embedder,
+		Config:      cm.Config,
+		queries:     newQueryCache(cm.Config),
+		name:        cm.name,
+		notes:       name,
+		trash:       cm.trash,
+		collections: cm.collections,
+	}
+	if err := build(staging); err != nil {
+		return err
+	}
+
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	live, err = cm.listDocuments()
+	if err != nil {
+		return err
+	}
+	current := documentsToVectorData(live)
+	carried := carriedOver(before, fileFingerprints(current))
+	byFile := map[string][]vector.VectorData{}
+	for _, v := range current {
+		byFile[v.Metadata["filepath"]] = append(byFile[v.Metadata["filepath"]], v)
+	}
+	col := staging.getNotesCollection()
+	for _, path := range carried {
+		if path != "" {
+			if err := col.Delete(ctx, map[string]string{"filepath": path}, nil); err != nil {
+				return err
+			}
+		}
+		if err := cm.addDocuments(ctx, col, byFile[path]); err != nil {
+			return err
+		}
+	}
+	if len(carried) > 1 {
+		log.Printf("[chromemManager] reindex: carried over %d files written during the rebuild", len(carried)-1)
+	}
+
+	old := cm.notes
+	if err := cm.swapLocked(name); err != nil {
+		return err
+	}
+	swapped = true
+	log.Printf("[chromemManager] reindex: swapped in %s with %d chunks", name, col.Count())
+	if old != name {
+		if err := deleteCollection(cm.DBInstance, old); err != nil {
+			log.Printf("[chromemManager] warning: failed to delete previous collection %s: %v", old, err)
+		}
+	}
+	return nil
+}
+
+// swapLocked makes the collection name the live one and persists the choice. The previous
+// live collection is left to the caller.
+func (cm *chromemManager) swapLocked(name string) error {
+	if cm.notes == name {
+		return nil
+	}
+	if cm.aliasPath != "" {
+		tmp := cm.aliasPath + ".tmp"
+		if err := os.WriteFile(tmp, []byte(name+"\n"), 0o644); err != nil {
+			return fmt.Errorf("failed to record live collection: %w", err)
+		}
+		if err := os.Rename(tmp, cm.aliasPath); err != nil {
+			return fmt.Errorf("failed to record live collection: %w", err)
+		}
+	}
+	cm.notes = name
+	return nil
+}
+
+func documentsToVectorData(docs []chromem.Document) []vector.VectorData {
+	out := make([]vector.VectorData, 0, len(docs))
+	for _, d := range docs {
+		out = append(out, documentToVectorData(d))
+	}
+	return out
+}
+
+// chromemCollections holds the manager of every collection opened so far, shared by all of
+// them, so that each collection has a single manager and lock.
+type chromemCollections struct {
+	mu     sync.Mutex
+	byName map[string]*chromemManager
+	// dims is the length of the stored embeddings, once known
+	dims atomic.Int64
+}
+
+// collectionNames returns the names of the collections in db, ordered: every chromem
+// collection but the trashes, with reindex collections counted under the collection they
+// were built for.
+func collectionNames(db *chromem.DB) []string {
+	seen := map[string]bool{}
+	for physical := range db.ListCollections() {
+		if physical == "trash" || strings.HasSuffix(physical, ".trash") {
+			continue
+		}
+		name, _, _ := strings.Cut(physical, reindexInfix)
+		seen[name] = true
+	}
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// rlockAll read-locks the manager of every collection opened so far and returns the function
+// releasing them. Until then no collection can be opened either, so nothing writes to the DB
+// but a Reindex building its staging collection, which isn't live yet.
+func (c *chromemCollections) rlockAll() (unlock func()) {
+	c.mu.Lock()
+	names := make([]string, 0, len(c.byName))
+	for name := range c.byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		c.byName[name].mu.RLock()
+	}
+	return func() {
+		for _, name := range names {
+			c.byName[name].mu.RUnlock()
+		}
+		c.mu.Unlock()
+	}
+}
+
+// openLocked opens the named collection, creating it if needed, and registers its manager.
+// The caller holds c.mu.
+func (c *chromemCollections) openLocked(cm *chromemManager, name string) (*chromemManager, error) {
+	m, err := openCollection(cm.DBInstance, cm.Config, cm.Embedder, cm.encrypted, cm.queries, name)
+	if err != nil {
+		return nil, err
+	}
+	m.collections = c
+	c.byName[name] = m
+	return m, nil
+}
+
+// collection functions
+func (cm *chromemManager) CollectionName() string {
+	return cm.name
+}
+func (cm *chromemManager) Collection(name string) (Manager, error) {
+	c := cm.collections
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if m, ok := c.byName[name]; ok {
+		return m, nil
+	}
+	if !slices.Contains(collectionNames(cm.DBInstance), name) {
+		return nil, fmt.Errorf("collection %q: %w", name, vector.ErrNotFound)
+	}
+	return c.openLocked(cm, name)
+}
+func (cm *chromemManager) CreateCollection(ctx context.Context, name string) error {
+	if err := config.CheckCollectionName(name); err != nil {
+		return err
+	}
+	defer cm.changed()
+	c := cm.collections
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.byName[name]; ok || slices.Contains(collectionNames(cm.DBInstance), name) {
+		return fmt.Errorf("collection %q: %w", name, vector.ErrCollectionExists)
+	}
+	_, err := c.openLocked(cm, name)
+	return err
+}
+func (cm *chromemManager) Collections(ctx context.Context) ([]CollectionInfo, error) {
+	names := collectionNames(cm.DBInstance)
+	out := make([]CollectionInfo, 0, len(names))
+	for _, name := range names {
+		m, err := cm.Collection(name)
+		if err != nil {
+			return nil, err
+		}
+		n, err := m.Count(ctx)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, CollectionInfo{Name: name, Chunks: n})
+	}
+	return out, nil
 }
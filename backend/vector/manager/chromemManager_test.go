@@ -0,0 +1,351 @@
+package manager_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"vex-backend/chunking"
+	"vex-backend/config"
+	"vex-backend/testsupport"
+	"vex-backend/vector"
+	"vex-backend/vector/manager"
+)
+
+// noteVersion returns the content of version v of a note: v+2 paragraphs, so that every
+// version has its own number of chunks.
+func noteVersion(name string, v int) string {
+	var b strings.Builder
+	for p := 0; p < v+2; p++ {
+		fmt.Fprintf(&b, "Paragraph %d of %s in version %d. %s\n\n", p, name, v, strings.Repeat(fmt.Sprintf("%s-%d-%d ", name, v, p), 150))
+	}
+	return b.String()
+}
+
+// TestChromemConcurrentAccess replaces notes while other goroutines query, list, trash,
+// export and reindex the same collection. Run it with -race: besides the data races the
+// detector reports, it checks that no reader sees a note half replaced.
+func TestChromemConcurrentAccess(t *testing.T) {
+	const notes, versions, rounds = 4, 3, 15
+
+	dir := t.TempDir()
+	e := testsupport.NewMockEmbedder(0)
+	e.Chunker = chunking.WordChunker{Size: 1000}
+	m := manager.NewChromemManager(config.Static(&config.EnvConfig{
+		VectorStorageFolder: filepath.Join(dir, "vectors"),
+		VectorCollection:    "notes",
+	}), e)
+	ctx := context.Background()
+
+	// the chunk counts a reader may see of each note: none, or those of one whole version
+	paths := make([]string, notes)
+	allowed := make([]map[int]bool, notes)
+	for i := range paths {
+		name := fmt.Sprintf("note%d", i)
+		paths[i] = filepath.Join(dir, name+".md")
+		allowed[i] = map[int]bool{0: true}
+		for v := 0; v < versions; v++ {
+			vs, err := e.EmbedStringToVectorData(ctx, noteVersion(name, v), map[string]string{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			allowed[i][len(vs)] = true
+		}
+		if len(allowed[i]) != versions+1 {
+			t.Fatalf("the versions of %s don't differ in their number of chunks: %v", name, allowed[i])
+		}
+	}
+	query, err := e.EmbedToVector(ctx, "paragraph of note1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var writers, readers sync.WaitGroup
+	stop := make(chan struct{})
+	// one writer per note, so a note's file isn't rewritten while it is being embedded
+	for i, path := range paths {
+		writers.Add(1)
+		go func(i int, path string) {
+			defer writers.Done()
+			for r := 0; r < rounds; r++ {
+				content := noteVersion(fmt.Sprintf("note%d", i), r%versions)
+				// renamed into place, so a Reindex reads one version or the other
+				tmp := path + ".tmp"
+				if err := os.WriteFile(tmp, []byte(content), 0o600); err != nil {
+					t.Error(err)
+					return
+				}
+				if err := os.Rename(tmp, path); err != nil {
+					t.Error(err)
+					return
+				}
+				if err := m.ReplaceFileVectorsInDB(ctx, path); err != nil {
+					t.Errorf("ReplaceFileVectorsInDB: %v", err)
+					return
+				}
+				if r%5 == 4 {
+					if _, err := m.TrashVectorsWithMetaData(ctx, "filepath", path); err != nil {
+						t.Errorf("TrashVectorsWithMetaData: %v", err)
+					}
+					if _, err := m.RestoreFromTrash(ctx, path, ""); err != nil {
+						t.Errorf("RestoreFromTrash: %v", err)
+					}
+				}
+			}
+		}(i, path)
+	}
+
+	reader := func(read func() error) {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if err := read(); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	reader(func() error {
+		for i, path := range paths {
+			vs, err := m.GetChunksByFile(ctx, path)
+			if err != nil {
+				return fmt.Errorf("GetChunksByFile: %w", err)
+			}
+			if !allowed[i][len(vs)] {
+				return fmt.Errorf("%s has %d chunks, want one of %v", filepath.Base(path), len(vs), allowed[i])
+			}
+		}
+		return nil
+	})
+	reader(func() error {
+		if _, err := m.RetriveNVectorsByEmbedding(ctx, query, 5, nil); err != nil && !errors.Is(err, vector.ErrEmptyCollection) {
+			return fmt.Errorf("RetriveNVectorsByEmbedding: %w", err)
+		}
+		_, err := m.Count(ctx)
+		return err
+	})
+	reader(func() error {
+		return m.Export(ctx, io.Discard)
+	})
+	reader(func() error {
+		return m.Reindex(ctx, func(staging manager.Manager) error {
+			for _, path := range paths {
+				if _, err := os.Stat(path); err != nil {
+					continue
+				}
+				if err := staging.StoreFileAsVectorsInDB(ctx, path); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+
+	writers.Wait()
+	close(stop)
+	readers.Wait()
+
+	for i, path := range paths {
+		vs, err := m.GetChunksByFile(ctx, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		content := noteVersion(fmt.Sprintf("note%d", i), (rounds-1)%versions)
+		want, _ := e.EmbedStringToVectorData(ctx, content, map[string]string{})
+		if len(vs) != len(want) {
+			t.Errorf("%s has %d chunks after the last replacement, want %d", filepath.Base(path), len(vs), len(want))
+		}
+	}
+}
+
+// TestEncryptedSnapshot takes a snapshot of an encrypted store and restores it into a store
+// whose ENCRYPTION_KEY has been rotated since.
+func TestEncryptedSnapshot(t *testing.T) {
+	ctx := context.Background()
+	e := testsupport.NewMockEmbedder(0)
+	open := func(key, previous string) manager.Manager {
+		return manager.NewChromemManager(config.Static(&config.EnvConfig{
+			VectorStorageFolder:   t.TempDir(),
+			VectorCollection:      "notes",
+			EncryptionKey:         key,
+			EncryptionKeyPrevious: previous,
+		}), e)
+	}
+
+	m := open("first passphrase", "")
+	vs, err := e.EmbedStringToVectorData(ctx, noteVersion("note", 0), map[string]string{"filepath": "/notes/note.md"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.StoreVectorsInDB(ctx, vs); err != nil {
+		t.Fatal(err)
+	}
+	var snap bytes.Buffer
+	if err := m.Export(ctx, &snap); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(snap.Bytes(), []byte("Paragraph 0 of note")) {
+		t.Fatal("the snapshot isn't encrypted")
+	}
+
+	if err := open("other passphrase", "").Import(ctx, bytes.NewReader(snap.Bytes())); err == nil {
+		t.Error("a snapshot restored with another passphrase")
+	}
+	rotated := open("second passphrase", "first passphrase")
+	if err := rotated.Import(ctx, bytes.NewReader(snap.Bytes())); err != nil {
+		t.Fatalf("Import after rotating the key: %v", err)
+	}
+	if n, err := rotated.Count(ctx); err != nil || n != len(vs) {
+		t.Errorf("Count after Import = %d, %v, want %d", n, err, len(vs))
+	}
+}
+
+// TestDeduplicateKeepsOtherNotes checks that /dedup, like storing, only removes duplicates
+// within a note: the same text in two notes stays in both.
+func TestDeduplicateKeepsOtherNotes(t *testing.T) {
+	ctx := context.Background()
+	e := testsupport.NewMockEmbedder(0)
+	for name, m := range map[string]manager.Manager{
+		"chromem": manager.NewChromemManager(config.Static(&config.EnvConfig{VectorStorageFolder: t.TempDir(), VectorCollection: "notes"}), e),
+		"memory":  manager.NewMemoryManager(e),
+	} {
+		for _, path := range []string{"/notes/a.md", "/notes/b.md"} {
+			vs, err := e.EmbedStringToVectorData(ctx, noteVersion("shared", 0), map[string]string{"filepath": path})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := m.StoreVectorsInDB(ctx, vs); err != nil {
+				t.Fatal(err)
+			}
+		}
+		n, err := m.DeduplicateVectors(ctx, 0.99)
+		if err != nil {
+			t.Fatalf("%s: DeduplicateVectors: %v", name, err)
+		}
+		if n != 0 {
+			t.Errorf("%s: DeduplicateVectors removed %d chunks shared by two notes", name, n)
+		}
+		for _, path := range []string{"/notes/a.md", "/notes/b.md"} {
+			if vs, err := m.GetChunksByFile(ctx, path); err != nil || len(vs) == 0 {
+				t.Errorf("%s: %s has %d chunks after dedup, %v", name, path, len(vs), err)
+			}
+		}
+	}
+}
+
+// uniqueVersion returns the content of version v of a note whose words are all different,
+// so that none of its small chunks is dropped as a duplicate of another.
+func uniqueVersion(v int) string {
+	var b strings.Builder
+	for w := 0; w < (v+2)*200; w++ {
+		fmt.Fprintf(&b, "word%d-%d ", v, w)
+	}
+	return b.String()
+}
+
+// TestEncryptedFlushDuringWrites flushes an encrypted store while notes are replaced in two
+// collections, and checks that every flushed store holds each note in one whole version.
+func TestEncryptedFlushDuringWrites(t *testing.T) {
+	const versions, flushes = 3, 20
+
+	dir := t.TempDir()
+	e := testsupport.NewMockEmbedder(0)
+	// small chunks, so a replacement takes long enough for a flush to catch it midway
+	e.Chunker = chunking.WordChunker{Size: 20}
+	cfg := config.Static(&config.EnvConfig{
+		VectorStorageFolder: filepath.Join(dir, "vectors"),
+		VectorCollection:    "notes",
+		EncryptionKey:       "passphrase",
+	})
+	ctx := context.Background()
+	root := manager.NewChromemManager(cfg, e)
+	if err := root.CreateCollection(ctx, "other"); err != nil {
+		t.Fatal(err)
+	}
+	other, err := root.Collection("other")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the chunk counts of the versions of a note, none of them 0
+	allowed := map[int]bool{}
+	for v := 0; v < versions; v++ {
+		vs, err := e.EmbedStringToVectorData(ctx, uniqueVersion(v), map[string]string{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		allowed[len(vs)] = true
+	}
+	// one note per collection, each rewritten by its own writer only
+	collections := map[string]manager.Manager{"notes": root, "other": other}
+	paths := map[string]string{}
+	for name, m := range collections {
+		paths[name] = filepath.Join(dir, name+".md")
+		if err := os.WriteFile(paths[name], []byte(uniqueVersion(0)), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.ReplaceFileVectorsInDB(ctx, paths[name]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var writers sync.WaitGroup
+	stop := make(chan struct{})
+	for name, m := range collections {
+		writers.Add(1)
+		go func(m manager.Manager, path string) {
+			defer writers.Done()
+			for r := 1; ; r++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if err := os.WriteFile(path, []byte(uniqueVersion(r%versions)), 0o600); err != nil {
+					t.Error(err)
+					return
+				}
+				if err := m.ReplaceFileVectorsInDB(ctx, path); err != nil {
+					t.Errorf("ReplaceFileVectorsInDB: %v", err)
+					return
+				}
+			}
+		}(m, paths[name])
+	}
+
+	flusher := root.(manager.Flusher)
+	for i := 0; i < flushes; i++ {
+		if err := flusher.Flush(); err != nil {
+			t.Fatalf("Flush: %v", err)
+		}
+		flushed := manager.NewChromemManager(cfg, e)
+		for name, path := range paths {
+			m, err := flushed.Collection(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			vs, err := m.GetChunksByFile(ctx, path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !allowed[len(vs)] {
+				t.Errorf("flush %d: %s holds %d chunks of its note, want one of %v", i, name, len(vs), allowed)
+			}
+		}
+	}
+	close(stop)
+	writers.Wait()
+}
@@ -0,0 +1,243 @@
+package manager
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+const (
+	// clusterMaxIterations bounds k-means when assignments keep changing
+	clusterMaxIterations = 50
+	// clusterSeed makes clustering deterministic, so the same vault yields the same topics
+	clusterSeed = 1
+)
+
+// NoteVector is a note represented by the mean of its chunk embeddings.
+type NoteVector struct {
+	Filepath  string
+	Filename  string
+	Embedding []float32
+	// Excerpt is the content of the note's first chunk
+	Excerpt string
+}
+
+// Cluster is a group of similar notes. Notes are ordered by closeness to the centroid, so
+// the first ones are the most representative.
+type Cluster struct {
+	Notes []NoteVector
+	// Cohesion is the mean cosine similarity of the notes to the centroid
+	Cohesion float32
+}
+
+// NoteVectors returns one vector per indexed note, ordered by filepath. Chunks without a
+// filepath (derived documents) are skipped.
+func NoteVectors(ctx context.Context, m Manager) ([]NoteVector, error) {
+	// chunks come back ordered by file and position
+	chunks, err := m.GetByMetadata(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []NoteVector
+	for start := 0; start < len(chunks); {
+		path := chunks[start].Metadata["filepath"]
+		end := start
+		for end < len(chunks) && chunks[end].Metadata["filepath"] == path {
+			end++
+		}
+		if path != "" {
+			if centroid := meanEmbedding(chunks[start:end]); centroid != nil {
+				out = append(out, NoteVector{
+					Filepath:  path,
+					Filename:  chunks[start].Metadata["filename"],
+					Embedding: centroid,
+					Excerpt:   chunks[start].Content,
+				})
+			}
+		}
+		start = end
+	}
+	return out, nil
+}
+
+// ClusterNotes groups notes into at most k clusters with spherical k-means (cosine
+// similarity, k-means++ seeding). Empty clusters are dropped and the rest are ordered by
+// size, largest first. Notes whose embedding length differs from the first are ignored.
+func ClusterNotes(notes []NoteVector, k int) []Cluster {
+	var points []NoteVector
+	var vecs [][]float64
+	for _, n := range notes {
+		if len(vecs) > 0 && len(n.Embedding) != len(vecs[0]) {
+			continue
+		}
+		if v := normalized(n.Embedding); v != nil {
+			points = append(points, n)
+			vecs = append(vecs, v)
+		}
+	}
+	if len(points) == 0 || k <= 0 {
+		return nil
+	}
+	if k > len(points) {
+		k = len(points)
+	}
+
+	centroids := seedCentroids(vecs, k, rand.New(rand.NewSource(clusterSeed)))
+	assign := make([]int, len(vecs))
+	for iter := 0; iter < clusterMaxIterations; iter++ {
+		changed := iter == 0
+		for i, v := range vecs {
+			best := nearest(v, centroids)
+			if best != assign[i] {
+				assign[i] = best
+				changed = true
+			}
+		}
+		if !changed {
+			break
+		}
+		centroids = recomputeCentroids(vecs, assign, centroids)
+	}
+
+	members := make([][]int, k)
+	for i, c := range assign {
+		members[c] = append(members[c], i)
+	}
+
+	var out []Cluster
+	for c, idx := range members {
+		if len(idx) == 0 {
+			continue
+		}
+		sims := make(map[int]float64, len(idx))
+		var total float64
+		for _, i := range idx {
+			sims[i] = dot(vecs[i], centroids[c])
+			total += sims[i]
+		}
+		sort.SliceStable(idx, func(a, b int) bool { return sims[idx[a]] > sims[idx[b]] })
+
+		cluster := Cluster{Cohesion: float32(total / float64(len(idx)))}
+		for _, i := range idx {
+			cluster.Notes = append(cluster.Notes, points[i])
+		}
+		out = append(out, cluster)
+	}
+	sort.SliceStable(out, func(i, j int) bool { return len(out[i].Notes) > len(out[j].Notes) })
+	return out
+}
+
+// seedCentroids picks k initial centroids with k-means++: each next centroid is drawn with
+// probability proportional to its squared distance from the nearest centroid so far.
+func seedCentroids(vecs [][]float64, k int, rng *rand.Rand) [][]float64 {
+	centroids := [][]float64{vecs[rng.Intn(len(vecs))]}
+	dist := make([]float64, len(vecs))
+	for len(centroids) < k {
+		var total float64
+		for i, v := range vecs {
+			// squared euclidean distance between unit vectors
+			d := 2 - 2*dot(v, centroids[nearest(v, centroids)])
+			if d < 0 {
+				d = 0
+			}
+			dist[i] = d
+			total += d
+		}
+		if total == 0 {
+			// every remaining point coincides with a centroid
+			break
+		}
+		r := rng.Float64() * total
+		pick := len(vecs) - 1
+		for i, d := range dist {
+			if r < d {
+				pick = i
+				break
+			}
+			r -= d
+		}
+		centroids = append(centroids, vecs[pick])
+	}
+	for len(centroids) < k {
+		centroids = append(centroids, centroids[0])
+	}
+	return centroids
+}
+
+// recomputeCentroids returns the normalized mean of each cluster's members, keeping the
+// previous centroid for clusters that lost all members.
+func recomputeCentroids(vecs [][]float64, assign []int, prev [][]float64) [][]float64 {
+	sums := make([][]float64, len(prev))
+	for i, c := range assign {
+		if sums[c] == nil {
+			sums[c] = make([]float64, len(vecs[i]))
+		}
+		for d, x := range vecs[i] {
+			sums[c][d] += x
+		}
+	}
+	out := make([][]float64, len(prev))
+	for c, sum := range sums {
+		out[c] = prev[c]
+		if sum == nil {
+			continue
+		}
+		if v := normalized64(sum); v != nil {
+			out[c] = v
+		}
+	}
+	return out
+}
+
+func nearest(v []float64, centroids [][]float64) int {
+	best, bestSim := 0, math.Inf(-1)
+	for c, centroid := range centroids {
+		if sim := dot(v, centroid); sim > bestSim {
+			best, bestSim = c, sim
+		}
+	}
+	return best
+}
+
+func dot(a, b []float64) float64 {
+	var s float64
+	for i := range a {
+		s += a[i] * b[i]
+	}
+	return s
+}
+
+func normalized(v []float32) []float64 {
+	out := make([]float64, len(v))
+	for i, x := range v {
+		out[i] = float64(x)
+	}
+	return normalized64(out)
+}
+
+func normalized64(v []float64) []float64 {
+	norm := math.Sqrt(dot(v, v))
+	if norm == 0 {
+		return nil
+	}
+	out := make([]float64, len(v))
+	for i, x := range v {
+		out[i] = x / norm
+	}
+	return out
+}
+
+// DefaultClusterCount is a rule-of-thumb number of clusters for n notes, sqrt(n/2),
+// between 2 and 20.
+func DefaultClusterCount(n int) int {
+	k := int(math.Round(math.Sqrt(float64(n) / 2)))
+	if k < 2 {
+		k = 2
+	}
+	if k > 20 {
+		k = 20
+	}
+	return k
+}
@@ -0,0 +1,42 @@
+package manager
+
+import (
+	"time"
+	"vex-backend/vector"
+)
+
+// DateRange restricts retrieval to chunks dated within [Since, Until). A chunk's date is its
+// commit date if known, otherwise its file's modification time; chunks without either never
+// match a bound. Zero bounds are open.
+type DateRange struct {
+	Since time.Time
+	Until time.Time
+}
+
+// IsZero reports whether the range lets every chunk through.
+func (r DateRange) IsZero() bool {
+	return r.Since.IsZero() && r.Until.IsZero()
+}
+
+// Contains reports whether the date of v lies within the range.
+func (r DateRange) Contains(v vector.VectorData) bool {
+	if r.IsZero() {
+		return true
+	}
+	t, ok := documentTime(v)
+	if !ok {
+		return false
+	}
+	if !r.Since.IsZero() && t.Before(r.Since) {
+		return false
+	}
+	if !r.Until.IsZero() && !t.Before(r.Until) {
+		return false
+	}
+	return true
+}
+
+// WithDateRange wraps m so that retrieval only returns chunks dated within r.
+func WithDateRange(m Manager, r DateRange) Manager {
+	return WithPostFilter(m, r.Contains)
+}
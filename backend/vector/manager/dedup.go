@@ -0,0 +1,50 @@
+package manager
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"math"
+	"strings"
+	"vex-backend/config"
+)
+
+// ContentHashMetadataKey is the metadata key holding the hash of a chunk's normalized content.
+const ContentHashMetadataKey = "content_hash"
+
+// contentHash returns the hex sha256 of content with whitespace collapsed, so chunks
+// that only differ in line wrapping or indentation hash the same.
+func contentHash(content string) string {
+	sum := sha256.Sum256([]byte(strings.Join(strings.Fields(content), " ")))
+	return hex.EncodeToString(sum[:])
+}
+
+// cosineSimilarity returns the cosine similarity of a and b, or 0 if they can't be compared.
+func cosineSimilarity(a, b []float32) float32 {
+	if len(a) == 0 || len(a) != len(b) {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return float32(dot / (math.Sqrt(na) * math.Sqrt(nb)))
+}
+
+// DedupSimilarityThresholdFrom returns the near-duplicate similarity threshold configured
+// in cfg (DEDUP_SIMILARITY_THRESHOLD). Zero means only exact duplicates are removed.
+func DedupSimilarityThresholdFrom(cfg *config.EnvConfig) float32 {
+	if cfg == nil {
+		return 0
+	}
+	return float32(cfg.DedupSimilarityThreshold)
+}
+
+// DedupSimilarityThreshold is DedupSimilarityThresholdFrom for the global configuration.
+func DedupSimilarityThreshold() float32 {
+	return DedupSimilarityThresholdFrom(config.Current())
+}
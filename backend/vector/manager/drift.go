@@ -0,0 +1,151 @@
+package manager
+
+import (
+	"context"
+	"sort"
+	"vex-backend/lang"
+	"vex-backend/vector"
+	"vex-backend/vector/embed"
+)
+
+const (
+	// DriftStaleSimilarity is the mean old/new similarity below which the index counts as
+	// stale; re-embedding with an unchanged model reproduces vectors almost exactly
+	DriftStaleSimilarity = 0.98
+	// driftWorstSamples is how many of the least similar samples are listed in a report
+	driftWorstSamples = 5
+)
+
+// DriftSample compares one stored chunk with its re-embedding.
+type DriftSample struct {
+	ID       string `json:"id"`
+	Filepath string `json:"filepath,omitempty"`
+	Model    string `json:"embedding_model,omitempty"`
+	// Similarity is the cosine similarity of the stored and the new vector; 0 on a
+	// dimension mismatch
+	Similarity        float32 `json:"similarity"`
+	DimensionMismatch bool    `json:"dimension_mismatch,omitempty"`
+}
+
+// DriftReport describes how far the stored vectors are from what the current embedder
+// produces.
+type DriftReport struct {
+	ConfiguredModel string `json:"configured_model"`
+	// Models counts stored chunks per recorded embedding model; chunks indexed before models
+	// were recorded count as "unknown"
+	Models            map[string]int `json:"models"`
+	Chunks            int            `json:"chunks"`
+	Sampled           int            `json:"sampled"`
+	MeanSimilarity    float32        `json:"mean_similarity"`
+	MinSimilarity     float32        `json:"min_similarity"`
+	DimensionMismatch int            `json:"dimension_mismatches"`
+	EmbeddingFailures int            `json:"embedding_failures"`
+	Worst             []DriftSample  `json:"worst"`
+	Stale             bool           `json:"stale"`
+	Reasons           []string       `json:"reasons"`
+}
+
+// Drift re-embeds up to sample stored chunks, spread evenly over the collection, with the
+// manager's embedder and compares them with the stored vectors. The index is reported stale
+// if chunks were embedded with a model other than model (or the model the embedder assigns to
+// their language), if dimensions differ, or if the mean similarity is below
+// DriftStaleSimilarity. Chunks that fail to embed are counted but
+// don't fail the report, unless all of them do.
+func Drift(ctx context.Context, m Manager, model string, sample int) (DriftReport, error) {
+	chunks, err := m.GetByMetadata(ctx, nil)
+	if err != nil {
+		return DriftReport{}, err
+	}
+
+	report := DriftReport{
+		ConfiguredModel: model,
+		Models:          map[string]int{},
+		Chunks:          len(chunks),
+		Worst:           []DriftSample{},
+		Reasons:         []string{},
+	}
+	// with per-language models, a chunk's expected model and re-embedding follow its language
+	le, byLanguage := m.GetEmbedder().(embed.LanguageEmbedder)
+	expected := func(c vector.VectorData) string {
+		if byLanguage {
+			return le.ModelForLanguage(c.Metadata[lang.MetadataKey])
+		}
+		return model
+	}
+	reembed := func(c vector.VectorData) ([]float32, error) {
+		if byLanguage {
+			return le.EmbedToVectorForLanguage(ctx, c.Content, c.Metadata[lang.MetadataKey])
+		}
+		return m.GetEmbedder().EmbedToVector(ctx, c.Content)
+	}
+
+	unexpected := map[string]bool{}
+	for _, c := range chunks {
+		name := c.Metadata[embed.EmbeddingModelMetadataKey]
+		if name == "" {
+			name = "unknown"
+		} else if name != expected(c) {
+			unexpected[name] = true
+		}
+		report.Models[name]++
+	}
+	if len(chunks) == 0 {
+		return report, nil
+	}
+
+	if sample <= 0 || sample > len(chunks) {
+		sample = len(chunks)
+	}
+
+	var samples []DriftSample
+	var total float64
+	var lastErr error
+	for i := 0; i < sample; i++ {
+		c := chunks[i*len(chunks)/sample]
+		s := DriftSample{
+			ID:       c.Id,
+			Filepath: c.Metadata["filepath"],
+			Model:    c.Metadata[embed.EmbeddingModelMetadataKey],
+		}
+
+		fresh, err := reembed(c)
+		if err != nil {
+			lastErr = err
+			report.EmbeddingFailures++
+			continue
+		}
+		if len(fresh) != len(c.Embedding) {
+			s.DimensionMismatch = true
+			report.DimensionMismatch++
+		} else {
+			s.Similarity = cosineSimilarity(fresh, c.Embedding)
+		}
+		total += float64(s.Similarity)
+		samples = append(samples, s)
+	}
+	if len(samples) == 0 {
+		return DriftReport{}, lastErr
+	}
+
+	report.Sampled = len(samples)
+	report.MeanSimilarity = float32(total / float64(len(samples)))
+	sort.SliceStable(samples, func(i, j int) bool { return samples[i].Similarity < samples[j].Similarity })
+	report.MinSimilarity = samples[0].Similarity
+	if len(samples) > driftWorstSamples {
+		samples = samples[:driftWorstSamples]
+	}
+	report.Worst = samples
+
+	for name := range unexpected {
+		report.Reasons = append(report.Reasons, "chunks were embedded with "+name)
+	}
+	sort.Strings(report.Reasons)
+	if report.DimensionMismatch > 0 {
+		report.Reasons = append(report.Reasons, "stored vectors have a different dimension")
+	}
+	if report.MeanSimilarity < DriftStaleSimilarity {
+		report.Reasons = append(report.Reasons, "re-embedded vectors differ from the stored ones")
+	}
+	report.Stale = len(report.Reasons) > 0
+	return report, nil
+}
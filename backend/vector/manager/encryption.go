@@ -0,0 +1,283 @@
+package manager
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/philippgille/chromem-go"
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	// encryptedStoreFile holds every collection when ENCRYPTION_KEY is set, replacing
+	// chromem's per-document files, which it can't encrypt
+	encryptedStoreFile = "vectors.gob.gz.enc"
+
+	// encryptedFlushInterval is how often changes to the encrypted store are written out
+	encryptedFlushInterval = 5 * time.Second
+
+	// sealedMagic starts the encrypted store and snapshots, followed by the salt their key
+	// was derived with and chromem's encrypted export
+	sealedMagic = "vex-argon2id-v1\n"
+	saltSize    = 16
+
+	// argon2id parameters, the second recommendation of RFC 9106 (64 MiB of memory)
+	argonTime    = 3
+	argonMemory  = 64 * 1024
+	argonThreads = 4
+)
+
+// Flusher is implemented by managers that persist asynchronously and must be flushed
+// before the process exits.
+type Flusher interface {
+	Flush() error
+}
+
+// encryptedStore persists an in-memory chromem DB as one AES-GCM encrypted export, written
+// periodically once something changed.
+type encryptedStore struct {
+	path string
+	key  encryptionKey
+	// dirty is set by every mutation and cleared by a successful flush
+	dirty atomic.Bool
+	// mu serializes flushes
+	mu sync.Mutex
+}
+
+// encryptionKey is the 32 byte AES-256 key chromem expects, derived from a passphrase with
+// argon2id, and the salt it was derived with.
+type encryptionKey struct {
+	salt []byte
+	key  string
+}
+
+// newEncryptionKey derives a key from passphrase with a fresh random salt.
+func newEncryptionKey(passphrase string) (encryptionKey, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return encryptionKey{}, fmt.Errorf("failed to generate a salt: %w", err)
+	}
+	return deriveKey(passphrase, salt), nil
+}
+
+func deriveKey(passphrase string, salt []byte) encryptionKey {
+	return encryptionKey{salt: salt, key: string(argon2.IDKey([]byte(passphrase), salt, argonTime, argonMemory, argonThreads, 32))}
+}
+
+// legacyKey is the unsalted key files were encrypted with before keys were derived with
+// argon2id. It is only used to read them.
+func legacyKey(passphrase string) string {
+	sum := sha256.Sum256([]byte(passphrase))
+	return string(sum[:])
+}
+
+// writeSealed writes the header carrying k's salt to w, followed by the named collections
+// of db (all of them without names) encrypted with k.
+func writeSealed(w io.Writer, db *chromem.DB, k encryptionKey, names ...string) error {
+	if _, err := w.Write(append([]byte(sealedMagic), k.salt...)); err != nil {
+		return err
+	}
+	return exportCollections(db, w, true, k.key, names...)
+}
+
+// sealedExport is how openSealed opened an export.
+type sealedExport struct {
+	// passphrase opened the export, empty if it isn't encrypted
+	passphrase string
+	// key decrypts the export, which starts at offset
+	key    encryptionKey
+	offset int64
+	// legacy is set for exports encrypted with a legacyKey
+	legacy bool
+}
+
+// openSealed calls read with the export in r and the key to decrypt it with for each of
+// passphrases in turn, until one succeeds; an empty passphrase reads an unencrypted
+// export. Exports written by writeSealed are read with the key derived from their salt,
+// older ones with the legacyKey.
+func openSealed(r io.ReadSeeker, passphrases []string, read func(export io.ReadSeeker, key string) error) (sealedExport, error) {
+	header := make([]byte, len(sealedMagic)+saltSize)
+	n, err := io.ReadFull(r, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return sealedExport{}, err
+	}
+	sealed := n == len(header) && string(header[:len(sealedMagic)]) == sealedMagic
+
+	var errs []error
+	for _, passphrase := range passphrases {
+		opened := sealedExport{passphrase: passphrase}
+		switch {
+		case sealed && passphrase == "":
+			continue
+		case sealed:
+			opened.key, opened.offset = deriveKey(passphrase, header[len(sealedMagic):]), int64(len(header))
+		case passphrase != "":
+			opened.key, opened.legacy = encryptionKey{key: legacyKey(passphrase)}, true
+		}
+		if _, err := r.Seek(opened.offset, io.SeekStart); err != nil {
+			return sealedExport{}, err
+		}
+		err := read(r, opened.key.key)
+		if err == nil {
+			return opened, nil
+		}
+		errs = append(errs, err)
+	}
+	if len(errs) == 0 {
+		return sealedExport{}, errors.New("the export is encrypted, but no key is set")
+	}
+	return sealedExport{}, errors.Join(errs...)
+}
+
+// encryptedStorePath returns where the encrypted store of folder lives.
+func encryptedStorePath(folder string) string {
+	return filepath.Join(folder, encryptedStoreFile)
+}
+
+// openEncryptedDB loads the encrypted store in folder into an in-memory DB. A store only
+// readable with previousPassphrase, or encrypted before keys were salted, is re-encrypted
+// with a key derived from passphrase right away. Without a store, vectors persisted
+// unencrypted in folder are migrated: they are loaded, written encrypted and only then
+// deleted from disk.
+func openEncryptedDB(folder string, passphrase string, previousPassphrase string) (*chromem.DB, *encryptedStore, error) {
+	store := &encryptedStore{path: encryptedStorePath(folder)}
+	db := chromem.NewDB()
+
+	f, err := os.Open(store.path)
+	switch {
+	case err == nil:
+		defer f.Close()
+		passphrases := []string{passphrase}
+		if previousPassphrase != "" {
+			passphrases = append(passphrases, previousPassphrase)
+		}
+		opened, err := openSealed(f, passphrases, func(export io.ReadSeeker, key string) error {
+			db = chromem.NewDB()
+			return db.ImportFromReader(export, key)
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to decrypt %s with ENCRYPTION_KEY or ENCRYPTION_KEY_PREVIOUS: %w", store.path, err)
+		}
+		if opened.passphrase == passphrase && !opened.legacy {
+			store.key = opened.key
+			return db, store, nil
+		}
+		if store.key, err = newEncryptionKey(passphrase); err != nil {
+			return nil, nil, err
+		}
+		if err := store.write(db); err != nil {
+			return nil, nil, fmt.Errorf("failed to re-encrypt %s with ENCRYPTION_KEY: %w", store.path, err)
+		}
+		log.Printf("[chromemManager] re-encrypted %s with a key derived from ENCRYPTION_KEY", store.path)
+		return db, store, nil
+	case !errors.Is(err, fs.ErrNotExist):
+		return nil, nil, err
+	}
+
+	if store.key, err = newEncryptionKey(passphrase); err != nil {
+		return nil, nil, err
+	}
+	plain, err := chromem.NewPersistentDB(folder, false)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read unencrypted vectors in %s: %w", folder, err)
+	}
+	if len(plain.ListCollections()) == 0 {
+		return db, store, nil
+	}
+
+	var buf bytes.Buffer
+	if err := plain.ExportToWriter(&buf, false, ""); err != nil {
+		return nil, nil, err
+	}
+	if err := db.ImportFromReader(bytes.NewReader(buf.Bytes()), ""); err != nil {
+		return nil, nil, err
+	}
+	if err := store.write(db); err != nil {
+		return nil, nil, fmt.Errorf("failed to encrypt the stored vectors: %w", err)
+	}
+	for name := range plain.ListCollections() {
+		if err := plain.DeleteCollection(name); err != nil {
+			log.Printf("[chromemManager] warning: failed to delete unencrypted collection %s: %v", name, err)
+		}
+	}
+	log.Printf("[chromemManager] migrated the stored vectors to encrypted storage in %s", store.path)
+	return db, store, nil
+}
+
+// write exports db to the store atomically, so a crash mid-write keeps the previous version.
+func (s *encryptedStore) write(db *chromem.DB) error {
+	tmp := s.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := writeSealed(f, db, s.key); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// changed marks the store as needing a flush. It is a no-op for unencrypted storage, which
+// chromem persists on every write.
+func (cm *chromemManager) changed() {
+	if cm.encrypted != nil {
+		cm.encrypted.dirty.Store(true)
+	}
+}
+
+// Flush writes the encrypted store if anything changed since the last flush.
+func (cm *chromemManager) Flush() error {
+	s := cm.encrypted
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.dirty.Swap(false) {
+		return nil
+	}
+	// the export holds every collection, and a flush in the middle of a write to any of them
+	// would persist it half done
+	unlock := cm.collections.rlockAll()
+	err := s.write(cm.DBInstance)
+	unlock()
+	if err != nil {
+		s.dirty.Store(true)
+		return err
+	}
+	return nil
+}
+
+// runFlush flushes the encrypted store every encryptedFlushInterval until ctx is done.
+func (cm *chromemManager) runFlush(ctx context.Context) {
+	ticker := time.NewTicker(encryptedFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := cm.Flush(); err != nil {
+			log.Printf("[chromemManager] failed to write encrypted vectors: %v", err)
+		}
+	}
+}
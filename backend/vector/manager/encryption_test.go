@@ -0,0 +1,67 @@
+package manager
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/philippgille/chromem-go"
+)
+
+// TestEncryptedStoreMigratesLegacyKeys opens a store encrypted with the unsalted key of
+// the previous passphrase, and checks it is re-encrypted with a salted key of the new one.
+func TestEncryptedStoreMigratesLegacyKeys(t *testing.T) {
+	folder := t.TempDir()
+	path := encryptedStorePath(folder)
+
+	legacy := chromem.NewDB()
+	col, err := legacy.CreateCollection(notesCollection, nil, func(context.Context, string) ([]float32, error) {
+		return nil, errors.New("no embeddings in this test")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := col.AddDocument(context.Background(), chromem.Document{ID: "a", Content: "note a", Embedding: []float32{1, 0}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := legacy.ExportToFile(path, true, legacyKey("old passphrase")); err != nil {
+		t.Fatal(err)
+	}
+
+	db, _, err := openEncryptedDB(folder, "new passphrase", "old passphrase")
+	if err != nil {
+		t.Fatalf("openEncryptedDB with the previous passphrase: %v", err)
+	}
+	if n := db.GetCollection(notesCollection, nil).Count(); n != 1 {
+		t.Fatalf("%d documents after the migration, want 1", n)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, []byte(sealedMagic)) {
+		t.Fatal("the store wasn't re-encrypted with a salted key")
+	}
+	salt := data[len(sealedMagic) : len(sealedMagic)+saltSize]
+
+	db, store, err := openEncryptedDB(folder, "new passphrase", "")
+	if err != nil {
+		t.Fatalf("openEncryptedDB after the migration: %v", err)
+	}
+	if n := db.GetCollection(notesCollection, nil).Count(); n != 1 {
+		t.Errorf("%d documents after reopening, want 1", n)
+	}
+	if !bytes.Equal(store.key.salt, salt) {
+		t.Error("reopening the store didn't reuse its salt")
+	}
+	if _, _, err := openEncryptedDB(folder, "old passphrase", ""); err == nil {
+		t.Error("the store still opens with the old passphrase")
+	}
+	if _, err := os.Stat(filepath.Join(folder, encryptedStoreFile+".tmp")); !os.IsNotExist(err) {
+		t.Errorf("temporary file left behind: %v", err)
+	}
+}
@@ -0,0 +1,359 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+	"vex-backend/vector"
+	"vex-backend/vector/embed"
+)
+
+// EnsembleCollectionSuffix is appended to a collection's name to name the parallel collection
+// holding its chunks as embedded by the second model of an ensemble.
+const EnsembleCollectionSuffix = "-ensemble"
+
+// ensembleRankOffset is the k of reciprocal rank fusion: a chunk ranked r in one result list
+// scores 1/(k+r), so the top few ranks of either model don't drown out the other.
+const ensembleRankOffset = 60
+
+// ensemble stores every chunk twice, embedded by the wrapped Manager's embedder and by a
+// second one into a parallel collection, and answers queries from both, fused by rank.
+// Lookups, counts, the trash listing and snapshots only involve the wrapped Manager.
+type ensemble struct {
+	Manager
+	// secondary is the parallel collection; it is only ever given embeddings of embedder
+	secondary Manager
+	embedder  embed.Embedder
+}
+
+// WithEnsemble wraps m so that every chunk is also embedded with e and stored in the parallel
+// collection named with EnsembleCollectionSuffix, which is created if it doesn't exist yet.
+// Queries search both collections and merge the results by reciprocal rank fusion, matching
+// chunks by content. Collections created through the returned Manager get a parallel
+// collection as well. The parallel collection lives in the same backend as m, so backends
+// with a fixed dimension need both models to produce vectors of that length.
+func WithEnsemble(ctx context.Context, m Manager, e embed.Embedder) (Manager, error) {
+	secondary, err := parallelCollection(ctx, m, m.CollectionName())
+	if err != nil {
+		return nil, err
+	}
+	return &ensemble{Manager: m, secondary: secondary, embedder: e}, nil
+}
+
+// parallelCollection returns the Manager of the parallel collection of name, creating it if
+// needed.
+func parallelCollection(ctx context.Context, m Manager, name string) (Manager, error) {
+	name += EnsembleCollectionSuffix
+	err := m.CreateCollection(ctx, name)
+	if err != nil && !errors.Is(err, vector.ErrCollectionExists) {
+		return nil, fmt.Errorf("failed to create the ensemble collection %q: %w", name, err)
+	}
+	return m.Collection(name)
+}
+
+// embedAll returns copies of vs embedded by the ensemble's second model.
+func (en *ensemble) embedAll(ctx context.Context, vs []vector.VectorData) ([]vector.VectorData, error) {
+	out := make([]vector.VectorData, 0, len(vs))
+	for _, v := range vs {
+		embedding, err := en.embedder.EmbedToVector(ctx, v.Content)
+		if err != nil {
+			return nil, fmt.Errorf("ensemble embedding failed: %w", err)
+		}
+		v.Embedding = embedding
+		out = append(out, v)
+	}
+	return out, nil
+}
+
+// embedFile returns the chunks of filename embedded by the ensemble's second model.
+func (en *ensemble) embedFile(ctx context.Context, filename string) (string, []vector.VectorData, error) {
+	path, metadata, err := fileMetadata(filename)
+	if err != nil {
+		return "", nil, err
+	}
+	vs, err := en.embedder.EmbedFileToVectorData(ctx, path, metadata)
+	if err != nil {
+		return "", nil, fmt.Errorf("ensemble embedding failed: %w", err)
+	}
+	return path, vs, nil
+}
+
+// storage functions
+func (en *ensemble) StoreVectorInDB(ctx context.Context, v vector.VectorData) error {
+	return en.StoreVectorsInDB(ctx, []vector.VectorData{v})
+}
+func (en *ensemble) StoreVectorsInDB(ctx context.Context, vs []vector.VectorData) error {
+	if err := en.Manager.StoreVectorsInDB(ctx, vs); err != nil {
+		return err
+	}
+	second, err := en.embedAll(ctx, vs)
+	if err != nil {
+		return err
+	}
+	return en.secondary.StoreVectorsInDB(ctx, second)
+}
+func (en *ensemble) StoreFileAsVectorsInDB(ctx context.Context, filename string) error {
+	if err := en.Manager.StoreFileAsVectorsInDB(ctx, filename); err != nil {
+		return err
+	}
+	_, vs, err := en.embedFile(ctx, filename)
+	if err != nil {
+		return err
+	}
+	return en.secondary.StoreVectorsInDB(ctx, vs)
+}
+
+// ReplaceFileVectorsInDB replaces the file's chunks all or nothing in the wrapped Manager,
+// then in the parallel collection, where a failure leaves the file's chunks missing until it
+// is stored again.
+func (en *ensemble) ReplaceFileVectorsInDB(ctx context.Context, filename string) error {
+	if err := en.Manager.ReplaceFileVectorsInDB(ctx, filename); err != nil {
+		return err
+	}
+	path, vs, err := en.embedFile(ctx, filename)
+	if err != nil {
+		return err
+	}
+	if err := en.secondary.DeleteVectorsWithMetaData(ctx, "filepath", path); err != nil {
+		return err
+	}
+	return en.secondary.StoreVectorsInDB(ctx, vs)
+}
+
+// retrieval functions
+func (en *ensemble) RetriveNVectorsByQuery(ctx context.Context, query string, n int) ([]vector.VectorData, error) {
+	return en.RetriveNVectorsByQueryWithFilter(ctx, query, n, nil)
+}
+
+// RetriveNVectorsByQueryWithFilter fuses the n best chunks of both collections. If the
+// parallel collection can't be searched, the wrapped Manager's results are returned alone.
+func (en *ensemble) RetriveNVectorsByQueryWithFilter(ctx context.Context, query string, n int, where map[string]string) ([]vector.VectorData, error) {
+	first, err := en.Manager.RetriveNVectorsByQueryWithFilter(ctx, query, n, where)
+	if err != nil {
+		return nil, err
+	}
+	second, err := en.searchSecondary(ctx, query, n, where)
+	if err != nil {
+		log.Printf("[ensemble] searching %q failed, using %q alone: %v", en.secondary.CollectionName(), en.CollectionName(), err)
+		return first, nil
+	}
+	return en.fuse(ctx, n, first, second), nil
+}
+func (en *ensemble) searchSecondary(ctx context.Context, query string, n int, where map[string]string) ([]vector.VectorData, error) {
+	embedding, err := en.embedder.EmbedToVector(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return en.secondary.RetriveNVectorsByEmbedding(ctx, embedding, n, where)
+}
+func (en *ensemble) RetriveNVectorsByQueryRanked(ctx context.Context, query string, n int, where map[string]string, rank RankOptions) ([]vector.VectorData, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("n must be > 0")
+	}
+	if rank.RecencyWeight <= 0 {
+		return en.RetriveNVectorsByQueryWithFilter(ctx, query, n, where)
+	}
+
+	candidates, err := en.RetriveNVectorsByQueryWithFilter(ctx, query, n*rankCandidateFactor, where)
+	if err != nil {
+		return nil, err
+	}
+	return rankByRecency(candidates, n, rank, time.Now()), nil
+}
+
+// fuse merges two ranked result lists by reciprocal rank fusion and returns the best n.
+// Chunks are matched by content hash, since the two collections give them different IDs;
+// chunks only the parallel collection found are swapped for their counterpart in the wrapped
+// Manager where there is one, so the results can be looked up by ID. A chunk keeps the higher
+// of its two similarities.
+func (en *ensemble) fuse(ctx context.Context, n int, first, second []vector.VectorData) []vector.VectorData {
+	type fused struct {
+		v     vector.VectorData
+		score float64
+	}
+	byKey := map[string]*fused{}
+	var order []string
+	add := func(vs []vector.VectorData, primary bool) {
+		for rank, v := range vs {
+			key := v.Metadata[ContentHashMetadataKey]
+			if key == "" {
+				key = "id:" + v.Id
+			}
+			f, ok := byKey[key]
+			if !ok {
+				if !primary {
+					v = en.counterpart(ctx, v)
+				}
+				f = &fused{v: v}
+				byKey[key] = f
+				order = append(order, key)
+			} else if v.Similarity > f.v.Similarity {
+				f.v.Similarity = v.Similarity
+			}
+			f.score += 1 / float64(ensembleRankOffset+rank+1)
+		}
+	}
+	add(first, true)
+	add(second, false)
+
+	out := make([]fused, 0, len(order))
+	for _, key := range order {
+		out = append(out, *byKey[key])
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].score > out[j].score })
+	if len(out) > n {
+		out = out[:n]
+	}
+	vs := make([]vector.VectorData, 0, len(out))
+	for _, f := range out {
+		vs = append(vs, f.v)
+	}
+	return vs
+}
+
+// counterpart returns the wrapped Manager's chunk with the content of v, with v's
+// similarity, or v itself if there is none.
+func (en *ensemble) counterpart(ctx context.Context, v vector.VectorData) vector.VectorData {
+	hash := v.Metadata[ContentHashMetadataKey]
+	if hash == "" {
+		return v
+	}
+	matches, err := en.Manager.GetByMetadata(ctx, map[string]string{ContentHashMetadataKey: hash})
+	if err != nil || len(matches) == 0 {
+		return v
+	}
+	m := matches[0]
+	m.Similarity = v.Similarity
+	return m
+}
+
+// deletion functions
+func (en *ensemble) DeleteVectorWithID(ctx context.Context, id string) error {
+	v, err := en.Manager.GetByID(ctx, id)
+	if err := en.Manager.DeleteVectorWithID(ctx, id); err != nil {
+		return err
+	}
+	if err != nil || v.Metadata[ContentHashMetadataKey] == "" {
+		return nil
+	}
+	twins, err := en.secondary.GetByMetadata(ctx, map[string]string{ContentHashMetadataKey: v.Metadata[ContentHashMetadataKey]})
+	if err != nil {
+		return err
+	}
+	for _, t := range twins {
+		if err := en.secondary.DeleteVectorWithID(ctx, t.Id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+func (en *ensemble) DeleteVectorsWithMetaData(ctx context.Context, key string, data string) error {
+	if err := en.Manager.DeleteVectorsWithMetaData(ctx, key, data); err != nil {
+		return err
+	}
+	return en.secondary.DeleteVectorsWithMetaData(ctx, key, data)
+}
+
+// trash functions; the counts are the wrapped Manager's
+func (en *ensemble) TrashVectorsWithMetaData(ctx context.Context, key string, data string) (int, error) {
+	n, err := en.Manager.TrashVectorsWithMetaData(ctx, key, data)
+	if err != nil {
+		return n, err
+	}
+	_, err = en.secondary.TrashVectorsWithMetaData(ctx, key, data)
+	return n, err
+}
+
+// RestoreFromTrash restores the file in both collections. The parallel collection may have
+// no matching deletion, e.g. for chunks trashed before the ensemble was enabled, which isn't
+// an error.
+func (en *ensemble) RestoreFromTrash(ctx context.Context, path string, deletedAt string) (int, error) {
+	n, err := en.Manager.RestoreFromTrash(ctx, path, deletedAt)
+	if err != nil {
+		return n, err
+	}
+	if _, err := en.secondary.RestoreFromTrash(ctx, path, deletedAt); err != nil && !errors.Is(err, vector.ErrNotFound) {
+		return n, err
+	}
+	return n, nil
+}
+func (en *ensemble) PurgeTrash(ctx context.Context, before time.Time) (int, error) {
+	n, err := en.Manager.PurgeTrash(ctx, before)
+	if err != nil {
+		return n, err
+	}
+	_, err = en.secondary.PurgeTrash(ctx, before)
+	return n, err
+}
+
+// Reindex rebuilds both collections, each in its own staging index, and swaps the wrapped
+// Manager's in first.
+func (en *ensemble) Reindex(ctx context.Context, build func(staging Manager) error) error {
+	return en.secondary.Reindex(ctx, func(secondary Manager) error {
+		return en.Manager.Reindex(ctx, func(staging Manager) error {
+			return build(&ensemble{Manager: staging, secondary: secondary, embedder: en.embedder})
+		})
+	})
+}
+
+// collection functions; parallel collections are hidden
+func (en *ensemble) Collection(name string) (Manager, error) {
+	if strings.HasSuffix(name, EnsembleCollectionSuffix) {
+		return nil, fmt.Errorf("collection %q: %w", name, vector.ErrNotFound)
+	}
+	m, err := en.Manager.Collection(name)
+	if err != nil {
+		return nil, err
+	}
+	secondary, err := parallelCollection(context.Background(), en.Manager, name)
+	if err != nil {
+		return nil, err
+	}
+	return &ensemble{Manager: m, secondary: secondary, embedder: en.embedder}, nil
+}
+func (en *ensemble) CreateCollection(ctx context.Context, name string) error {
+	if strings.HasSuffix(name, EnsembleCollectionSuffix) {
+		return fmt.Errorf("collection name %q is reserved", name)
+	}
+	if err := en.Manager.CreateCollection(ctx, name); err != nil {
+		return err
+	}
+	_, err := parallelCollection(ctx, en.Manager, name)
+	return err
+}
+func (en *ensemble) Collections(ctx context.Context) ([]CollectionInfo, error) {
+	all, err := en.Manager.Collections(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := all[:0]
+	for _, c := range all {
+		if !strings.HasSuffix(c.Name, EnsembleCollectionSuffix) {
+			out = append(out, c)
+		}
+	}
+	return out, nil
+}
+
+// maintenance functions
+func (en *ensemble) DeduplicateVectors(ctx context.Context, threshold float32) (int, error) {
+	n, err := en.Manager.DeduplicateVectors(ctx, threshold)
+	if err != nil {
+		return n, err
+	}
+	_, err = en.secondary.DeduplicateVectors(ctx, threshold)
+	return n, err
+}
+
+// Flush flushes the wrapped Manager if it persists asynchronously; the parallel collection
+// is part of the same store.
+func (en *ensemble) Flush() error {
+	if f, ok := en.Manager.(Flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
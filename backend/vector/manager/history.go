@@ -0,0 +1,310 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+	"vex-backend/git"
+	"vex-backend/vector"
+)
+
+// HistoryCollectionSuffix is appended to a collection's name to name the collection keeping
+// the previous versions of its files.
+const HistoryCollectionSuffix = "-history"
+
+// Metadata keys of the chunks of previous versions, on top of those they had when current
+const (
+	// VersionMetadataKey is the commit hash of the version of the file the chunk belongs to
+	VersionMetadataKey = "version"
+	// SupersededAtMetadataKey is when that version stopped being the latest (RFC 3339): the
+	// commit date of the version replacing it, or when the file was removed from the index
+	SupersededAtMetadataKey = "superseded_at"
+)
+
+// ErrNoHistory is returned by AsOf for collections whose previous versions aren't kept.
+var ErrNoHistory = errors.New("version history is not kept")
+
+// history keeps the chunks a file had before it was re-embedded or deleted in a parallel
+// collection, one generation per commit, so questions can be answered as of an earlier date.
+// Everything but ReplaceFileVectorsInDB and deletions by file only involves the wrapped
+// Manager, so regular queries only ever see the latest versions.
+type history struct {
+	Manager
+	past Manager
+}
+
+// WithHistory wraps m so that the chunks of a file's previous version are kept in the
+// collection named with HistoryCollectionSuffix, created if it doesn't exist yet, instead of
+// being dropped when the file changes or is deleted. Only versions committed to git are
+// kept. Collections created through the returned Manager keep a history as well.
+func WithHistory(ctx context.Context, m Manager) (Manager, error) {
+	past, err := historyCollection(ctx, m, m.CollectionName())
+	if err != nil {
+		return nil, err
+	}
+	return &history{Manager: m, past: past}, nil
+}
+
+// historyCollection returns the Manager of the history collection of name, creating it if
+// needed.
+func historyCollection(ctx context.Context, m Manager, name string) (Manager, error) {
+	name += HistoryCollectionSuffix
+	err := m.CreateCollection(ctx, name)
+	if err != nil && !errors.Is(err, vector.ErrCollectionExists) {
+		return nil, fmt.Errorf("failed to create the history collection %q: %w", name, err)
+	}
+	return m.Collection(name)
+}
+
+// archive stores copies of chunks, the previous version of a file, in the history collection,
+// marked as superseded at the given time. Chunks without a commit hash aren't versioned and
+// are left out. Chunks whose embedding the backend doesn't hand out are embedded again.
+func (h *history) archive(ctx context.Context, chunks []vector.VectorData, supersededAt time.Time) error {
+	at := supersededAt.UTC().Format(time.RFC3339)
+	out := make([]vector.VectorData, 0, len(chunks))
+	for _, c := range chunks {
+		version := c.Metadata[git.CommitHashMetadataKey]
+		if version == "" {
+			continue
+		}
+		metadata := make(map[string]string, len(c.Metadata)+2)
+		for k, v := range c.Metadata {
+			metadata[k] = v
+		}
+		metadata[VersionMetadataKey] = version
+		metadata[SupersededAtMetadataKey] = at
+		c.Metadata = metadata
+		// the same ID in another version is another chunk; archiving a version again overwrites it
+		c.Id = version + "/" + c.Id
+		c.Similarity = 0
+		if len(c.Embedding) == 0 {
+			embedding, err := h.GetEmbedder().EmbedToVector(ctx, c.Content)
+			if err != nil {
+				return fmt.Errorf("failed to embed a previous version of %s: %w", c.Metadata["filepath"], err)
+			}
+			c.Embedding = embedding
+		}
+		out = append(out, c)
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	if err := h.past.StoreVectorsInDB(ctx, out); err != nil {
+		return fmt.Errorf("failed to keep the previous version of %s: %w", out[0].Metadata["filepath"], err)
+	}
+	log.Printf("[history] kept %d chunks of %s at version %.7s", len(out), out[0].Metadata["filepath"], out[0].Metadata[VersionMetadataKey])
+	return nil
+}
+
+// ReplaceFileVectorsInDB replaces the file's chunks in the wrapped Manager and, if they
+// belonged to another commit than the file now does, keeps them as the previous version.
+// Failing to keep them doesn't undo the replacement.
+func (h *history) ReplaceFileVectorsInDB(ctx context.Context, filename string) error {
+	path, metadata, err := fileMetadata(filename)
+	if err != nil {
+		return h.Manager.ReplaceFileVectorsInDB(ctx, filename)
+	}
+	previous, err := h.Manager.GetChunksByFile(ctx, path)
+	if err != nil {
+		return err
+	}
+	if err := h.Manager.ReplaceFileVectorsInDB(ctx, filename); err != nil {
+		return err
+	}
+
+	version := metadata[git.CommitHashMetadataKey]
+	if version == "" || len(previous) == 0 || previous[0].Metadata[git.CommitHashMetadataKey] == version {
+		return nil
+	}
+	committed, err := time.Parse(time.RFC3339, metadata[git.CommitDateMetadataKey])
+	if err != nil {
+		committed = time.Now()
+	}
+	return h.archive(ctx, previous, committed)
+}
+
+// DeleteVectorsWithMetaData keeps the chunks of a file deleted by its path as the version
+// superseded now; other deletions aren't versioned.
+func (h *history) DeleteVectorsWithMetaData(ctx context.Context, key string, data string) error {
+	if key != "filepath" {
+		return h.Manager.DeleteVectorsWithMetaData(ctx, key, data)
+	}
+	previous, err := h.Manager.GetChunksByFile(ctx, data)
+	if err != nil {
+		return err
+	}
+	if err := h.Manager.DeleteVectorsWithMetaData(ctx, key, data); err != nil {
+		return err
+	}
+	return h.archive(ctx, previous, time.Now())
+}
+
+// Reindex rebuilds the wrapped Manager's index; the history is kept as it is.
+func (h *history) Reindex(ctx context.Context, build func(staging Manager) error) error {
+	return h.Manager.Reindex(ctx, func(staging Manager) error {
+		return build(&history{Manager: staging, past: h.past})
+	})
+}
+
+// collection functions; history collections are listed under their own name only by the
+// wrapped Manager, but can be opened to look through every version kept
+func (h *history) Collection(name string) (Manager, error) {
+	m, err := h.Manager.Collection(name)
+	if err != nil || strings.HasSuffix(name, HistoryCollectionSuffix) {
+		return m, err
+	}
+	past, err := historyCollection(context.Background(), h.Manager, name)
+	if err != nil {
+		return nil, err
+	}
+	return &history{Manager: m, past: past}, nil
+}
+func (h *history) CreateCollection(ctx context.Context, name string) error {
+	if strings.HasSuffix(name, HistoryCollectionSuffix) {
+		return fmt.Errorf("collection name %q is reserved", name)
+	}
+	if err := h.Manager.CreateCollection(ctx, name); err != nil {
+		return err
+	}
+	_, err := historyCollection(ctx, h.Manager, name)
+	return err
+}
+func (h *history) Collections(ctx context.Context) ([]CollectionInfo, error) {
+	all, err := h.Manager.Collections(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := all[:0]
+	for _, c := range all {
+		if !strings.HasSuffix(c.Name, HistoryCollectionSuffix) {
+			out = append(out, c)
+		}
+	}
+	return out, nil
+}
+
+// Flush flushes the wrapped Manager if it persists asynchronously; the history collection
+// is part of the same store.
+func (h *history) Flush() error {
+	if f, ok := h.Manager.(Flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// validAt reports whether a chunk belongs to the version of its file that was the latest at
+// t: dated no later than t and, for previous versions, not yet superseded by then.
+func validAt(t time.Time) func(v vector.VectorData) bool {
+	return func(v vector.VectorData) bool {
+		if d, ok := documentTime(v); ok && d.After(t) {
+			return false
+		}
+		if raw := v.Metadata[SupersededAtMetadataKey]; raw != "" {
+			if s, err := time.Parse(time.RFC3339, raw); err == nil && !s.After(t) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// asOf answers retrieval from the versions of the files that were the latest at one time,
+// current and previous ones alike.
+type asOf struct {
+	Manager
+	past Manager
+	at   time.Time
+}
+
+// AsOf wraps m, whose collection must keep a history (see WithHistory), so that retrieval
+// returns the chunks of every file as it was at t: the version committed last before t,
+// unless the file was deleted by then. Files changed after t that weren't pulled in between
+// are only known in their later version and left out. It returns ErrNoHistory if m's
+// collection has no history.
+func AsOf(m Manager, t time.Time) (Manager, error) {
+	past, err := m.Collection(m.CollectionName() + HistoryCollectionSuffix)
+	if errors.Is(err, vector.ErrNotFound) {
+		return nil, fmt.Errorf("collection %q: %w", m.CollectionName(), ErrNoHistory)
+	}
+	if err != nil {
+		return nil, err
+	}
+	valid := validAt(t)
+	return asOf{Manager: WithPostFilter(m, valid), past: WithPostFilter(past, valid), at: t}, nil
+}
+
+func (a asOf) GetByID(ctx context.Context, id string) (vector.VectorData, error) {
+	v, err := a.Manager.GetByID(ctx, id)
+	if errors.Is(err, vector.ErrNotFound) {
+		return a.past.GetByID(ctx, id)
+	}
+	return v, err
+}
+func (a asOf) GetByMetadata(ctx context.Context, where map[string]string) ([]vector.VectorData, error) {
+	current, err := a.Manager.GetByMetadata(ctx, where)
+	if err != nil {
+		return nil, err
+	}
+	past, err := a.past.GetByMetadata(ctx, where)
+	return append(current, past...), err
+}
+func (a asOf) GetChunksByFile(ctx context.Context, path string) ([]vector.VectorData, error) {
+	current, err := a.Manager.GetChunksByFile(ctx, path)
+	if err != nil || len(current) > 0 {
+		return current, err
+	}
+	return a.past.GetChunksByFile(ctx, path)
+}
+func (a asOf) RetriveNVectorsByQuery(ctx context.Context, query string, n int) ([]vector.VectorData, error) {
+	return a.RetriveNVectorsByQueryWithFilter(ctx, query, n, nil)
+}
+func (a asOf) RetriveNVectorsByQueryWithFilter(ctx context.Context, query string, n int, where map[string]string) ([]vector.VectorData, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("n must be > 0")
+	}
+	embedding, err := a.EmbedQuery(ctx, query, "")
+	if err != nil {
+		return nil, err
+	}
+	return a.RetriveNVectorsByEmbedding(ctx, embedding, n, where)
+}
+
+// RetriveNVectorsByEmbedding merges the n best chunks of both collections by similarity.
+func (a asOf) RetriveNVectorsByEmbedding(ctx context.Context, embedding []float32, n int, where map[string]string) ([]vector.VectorData, error) {
+	current, err := a.Manager.RetriveNVectorsByEmbedding(ctx, embedding, n, where)
+	if err != nil && !errors.Is(err, vector.ErrEmptyCollection) {
+		return nil, err
+	}
+	past, pastErr := a.past.RetriveNVectorsByEmbedding(ctx, embedding, n, where)
+	if pastErr != nil && !errors.Is(pastErr, vector.ErrEmptyCollection) {
+		return nil, pastErr
+	}
+	if err != nil && pastErr != nil {
+		return nil, err
+	}
+
+	all := append(current, past...)
+	sort.SliceStable(all, func(i, j int) bool { return all[i].Similarity > all[j].Similarity })
+	if len(all) > n {
+		all = all[:n]
+	}
+	return all, nil
+}
+func (a asOf) RetriveNVectorsByQueryRanked(ctx context.Context, query string, n int, where map[string]string, rank RankOptions) ([]vector.VectorData, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("n must be > 0")
+	}
+	if rank.RecencyWeight <= 0 {
+		return a.RetriveNVectorsByQueryWithFilter(ctx, query, n, where)
+	}
+	candidates, err := a.RetriveNVectorsByQueryWithFilter(ctx, query, n*rankCandidateFactor, where)
+	if err != nil {
+		return nil, err
+	}
+	// recent as of then
+	return rankByRecency(candidates, n, rank, a.at), nil
+}
@@ -0,0 +1,92 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"time"
+	"vex-backend/lang"
+	"vex-backend/vector"
+)
+
+// languageScoped restricts every read of the wrapped Manager to chunks detected as one
+// language. Queries are embedded with that language's model when the embedder has one, so
+// they land in the same embedding space as the chunks they are compared with.
+type languageScoped struct {
+	Manager
+	language string
+}
+
+// WithLanguage wraps m so that retrieval only returns chunks in language, an ISO 639-1 code.
+// Wrap it around any other filtering decorator, as it embeds queries itself.
+func WithLanguage(m Manager, language string) Manager {
+	return languageScoped{Manager: m, language: language}
+}
+
+// scope adds the language to a metadata filter without modifying the caller's map.
+func (l languageScoped) scope(where map[string]string) map[string]string {
+	scoped := make(map[string]string, len(where)+1)
+	for k, v := range where {
+		scoped[k] = v
+	}
+	scoped[lang.MetadataKey] = l.language
+	return scoped
+}
+
+func (l languageScoped) RetriveVectorByMetadata(ctx context.Context, key string, data string) (vector.VectorData, error) {
+	vs, err := l.GetByMetadata(ctx, map[string]string{key: data})
+	if err != nil {
+		return vector.VectorData{}, err
+	}
+	if len(vs) == 0 {
+		return vector.VectorData{}, fmt.Errorf("no document with metadata %s=%s: %w", key, data, vector.ErrNotFound)
+	}
+	return vs[0], nil
+}
+func (l languageScoped) RetriveVectorWithID(ctx context.Context, id string) (vector.VectorData, error) {
+	return l.GetByID(ctx, id)
+}
+func (l languageScoped) GetByID(ctx context.Context, id string) (vector.VectorData, error) {
+	v, err := l.Manager.GetByID(ctx, id)
+	if err != nil {
+		return v, err
+	}
+	if v.Metadata[lang.MetadataKey] != l.language {
+		return vector.VectorData{}, fmt.Errorf("document %q: %w", id, vector.ErrNotFound)
+	}
+	return v, nil
+}
+func (l languageScoped) GetByMetadata(ctx context.Context, where map[string]string) ([]vector.VectorData, error) {
+	return l.Manager.GetByMetadata(ctx, l.scope(where))
+}
+func (l languageScoped) GetChunksByFile(ctx context.Context, path string) ([]vector.VectorData, error) {
+	return l.GetByMetadata(ctx, map[string]string{"filepath": path})
+}
+func (l languageScoped) RetriveNVectorsByQuery(ctx context.Context, query string, n int) ([]vector.VectorData, error) {
+	return l.RetriveNVectorsByQueryWithFilter(ctx, query, n, nil)
+}
+func (l languageScoped) RetriveNVectorsByQueryWithFilter(ctx context.Context, query string, n int, where map[string]string) ([]vector.VectorData, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("n must be > 0")
+	}
+	embedding, err := l.EmbedQuery(ctx, query, l.language)
+	if err != nil {
+		return nil, err
+	}
+	return l.RetriveNVectorsByEmbedding(ctx, embedding, n, where)
+}
+func (l languageScoped) RetriveNVectorsByEmbedding(ctx context.Context, embedding []float32, n int, where map[string]string) ([]vector.VectorData, error) {
+	return l.Manager.RetriveNVectorsByEmbedding(ctx, embedding, n, l.scope(where))
+}
+func (l languageScoped) RetriveNVectorsByQueryRanked(ctx context.Context, query string, n int, where map[string]string, rank RankOptions) ([]vector.VectorData, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("n must be > 0")
+	}
+	if rank.RecencyWeight <= 0 {
+		return l.RetriveNVectorsByQueryWithFilter(ctx, query, n, where)
+	}
+	candidates, err := l.RetriveNVectorsByQueryWithFilter(ctx, query, n*rankCandidateFactor, where)
+	if err != nil {
+		return nil, err
+	}
+	return rankByRecency(candidates, n, rank, time.Now()), nil
+}
@@ -0,0 +1,34 @@
+package manager
+
+import (
+	"sort"
+	"strconv"
+	"vex-backend/vector"
+	"vex-backend/vector/embed"
+)
+
+// matchesMetadata reports whether metadata contains every key/value pair of where.
+func matchesMetadata(metadata, where map[string]string) bool {
+	for k, v := range where {
+		if metadata[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// sortByPosition orders chunks by source file and then by their position in it.
+func sortByPosition(vs []vector.VectorData) {
+	sort.SliceStable(vs, func(i, j int) bool {
+		pi, pj := vs[i].Metadata["filepath"], vs[j].Metadata["filepath"]
+		if pi != pj {
+			return pi < pj
+		}
+		ci, _ := strconv.Atoi(vs[i].Metadata[embed.ChunkIndexMetadataKey])
+		cj, _ := strconv.Atoi(vs[j].Metadata[embed.ChunkIndexMetadataKey])
+		if ci != cj {
+			return ci < cj
+		}
+		return vs[i].Id < vs[j].Id
+	})
+}
@@ -0,0 +1,113 @@
+package manager
+
+import (
+	"path/filepath"
+	"sort"
+	"sync"
+	"vex-backend/config"
+	"vex-backend/vector"
+	"vex-backend/vector/embed"
+)
+
+// NewLRUManager returns an empty in-memory Manager like NewMemoryManager that holds at most
+// maxDocuments chunks per collection, evicting the least recently stored or returned ones to
+// make room. It suits scratch data that is fine to lose, such as remembered query results, and
+// is unbounded if maxDocuments <= 0.
+func NewLRUManager(e embed.Embedder, maxDocuments int) Manager {
+	mm := NewMemoryManager(e).(*memoryManager)
+	if maxDocuments > 0 {
+		mm.MaxDocuments = maxDocuments
+		mm.recency = newRecency()
+	}
+	return mm
+}
+
+// NewEphemeralManager returns the LRU Manager of VECTOR_BACKEND=memory: collection
+// VECTOR_COLLECTION capped at MEMORY_MAX_DOCUMENTS chunks, with the duplicate, soft deletion
+// and quantization settings of cfg at the time. Nothing survives a restart: the vector file
+// kept for rescoring quantized results is recreated empty.
+func NewEphemeralManager(cfg config.Source, e embed.Embedder) (Manager, error) {
+	c := cfg()
+	mm := NewLRUManager(e, c.MemoryMaxDocuments).(*memoryManager)
+	mm.DedupThreshold = DedupSimilarityThresholdFrom(c)
+	mm.SoftDelete = c.SoftDelete
+	mm.name = c.VectorCollection
+	mm.collections.byName = map[string]*memoryManager{mm.name: mm}
+
+	if c.VectorQuantization != "none" {
+		mm.Quantization = c.VectorQuantization
+		mm.RescoreFactor = c.VectorQuantizationRescore
+	}
+	if mm.quantized() && mm.RescoreFactor > 0 {
+		full, err := createVectorFile(filepath.Join(c.VectorStorageFolder, "memory_vectors.f32"))
+		if err != nil {
+			return nil, err
+		}
+		mm.collections.full = full
+	}
+	return mm, nil
+}
+
+// recency records when each chunk of a capped memoryManager was last stored or returned. It
+// has its own lock so that lookups holding only a read lock on the manager can update it.
+type recency struct {
+	mu    sync.Mutex
+	clock uint64
+	used  map[string]uint64
+}
+
+func newRecency() *recency {
+	return &recency{used: map[string]uint64{}}
+}
+
+// touch marks the chunks as used just now; a nil recency ignores it.
+func (r *recency) touch(vs ...vector.VectorData) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, v := range vs {
+		r.clock++
+		r.used[v.Id] = r.clock
+	}
+}
+
+// evictLocked drops the least recently used chunks until at most MaxDocuments are left, and
+// forgets the use of chunks no longer stored. Chunks never touched, e.g. imported ones, go
+// first. Evicted chunks are dropped, not trashed. Callers must hold mm.mu.
+func (mm *memoryManager) evictLocked() int {
+	r := mm.recency
+	if r == nil || mm.MaxDocuments <= 0 {
+		return 0
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id := range r.used {
+		if _, ok := mm.docs[id]; !ok {
+			delete(r.used, id)
+		}
+	}
+	excess := len(mm.docs) - mm.MaxDocuments
+	if excess <= 0 {
+		return 0
+	}
+
+	ids := make([]string, 0, len(mm.docs))
+	for id := range mm.docs {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if r.used[ids[i]] != r.used[ids[j]] {
+			return r.used[ids[i]] < r.used[ids[j]]
+		}
+		return ids[i] < ids[j]
+	})
+	for _, id := range ids[:excess] {
+		mm.dropLocked(id)
+		delete(r.used, id)
+	}
+	return excess
+}
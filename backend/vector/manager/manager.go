@@ -2,23 +2,100 @@ package manager
 
 import (
 	"context"
+	"io"
+	"time"
 	"vex-backend/vector"
 	"vex-backend/vector/embed"
 )
 
+// Manager stores and retrieves the embedded chunks of the notes. Implementations are safe for
+// concurrent use, e.g. a webhook sync writing while queries read: every method is atomic with
+// respect to the others, so a reader sees a file's chunks either before or after a write to
+// them, never in between. Methods taking several steps, like a file lookup followed by a
+// query, are not atomic together.
 type Manager interface {
 	// can be a link, can be an embedded vector db, just needs to be the consistent throughout the manager's lifetime
 	GetDBInstance() any
 	GetEmbedder() embed.Embedder
+	// embeds a query with the model of language ("" for the default model), reusing the
+	// embedding of a recent identical query where the implementation caches them; the result
+	// must not be modified. QueryCacheStats reports how often the cache answered.
+	EmbedQuery(ctx context.Context, query string, language string) ([]float32, error)
+	QueryCacheStats() QueryCacheStats
+	// estimates the memory the vectors take in this process, across all collections
+	MemoryUsage(ctx context.Context) (MemoryUsage, error)
 
 	StoreVectorInDB(ctx context.Context, v vector.VectorData) error
 	StoreVectorsInDB(ctx context.Context, vs []vector.VectorData) error
 	StoreFileAsVectorsInDB(ctx context.Context, filename string) error
+	// replaces every stored chunk of the file with a fresh embedding of it, all or nothing:
+	// on any embedding or storage error the previously stored chunks are left in place
+	ReplaceFileVectorsInDB(ctx context.Context, filename string) error
 
 	RetriveVectorByMetadata(ctx context.Context, key string, data string) (vector.VectorData, error)
 	RetriveVectorWithID(ctx context.Context, id string) (vector.VectorData, error)
+	// non-similarity lookups: a single chunk by ID, every chunk matching all metadata pairs
+	// (ordered by file and chunk position), and every chunk of one source file
+	GetByID(ctx context.Context, id string) (vector.VectorData, error)
+	GetByMetadata(ctx context.Context, where map[string]string) ([]vector.VectorData, error)
+	GetChunksByFile(ctx context.Context, path string) ([]vector.VectorData, error)
 	RetriveNVectorsByQuery(ctx context.Context, query string, n int) ([]vector.VectorData, error)
+	// where is an exact-match metadata filter; every key/value pair must match
+	RetriveNVectorsByQueryWithFilter(ctx context.Context, query string, n int, where map[string]string) ([]vector.VectorData, error)
+	// like RetriveNVectorsByQueryWithFilter but searches with an existing embedding instead of embedding a query
+	RetriveNVectorsByEmbedding(ctx context.Context, embedding []float32, n int, where map[string]string) ([]vector.VectorData, error)
+	// like RetriveNVectorsByQueryWithFilter but re-ranks a larger candidate pool by blending similarity with recency
+	RetriveNVectorsByQueryRanked(ctx context.Context, query string, n int, where map[string]string, rank RankOptions) ([]vector.VectorData, error)
 
 	DeleteVectorWithID(ctx context.Context, id string) error
+	// with SOFT_DELETE enabled, this and ReplaceFileVectorsInDB move chunks to the trash instead
 	DeleteVectorsWithMetaData(ctx context.Context, key string, data string) error
+
+	// soft deletion: trashed chunks carry a DeletedAtMetadataKey tombstone and are invisible to
+	// every lookup and query until restored or purged. TrashVectorsWithMetaData returns the number
+	// of trashed chunks. RestoreFromTrash brings back the chunks of one file deleted at deletedAt
+	// (the latest deletion if empty), trashing the file's current chunks in exchange, and returns
+	// the number of restored chunks. PurgeTrash hard-deletes chunks trashed before the given time.
+	TrashVectorsWithMetaData(ctx context.Context, key string, data string) (int, error)
+	ListTrash(ctx context.Context) ([]vector.VectorData, error)
+	RestoreFromTrash(ctx context.Context, path string, deletedAt string) (int, error)
+	PurgeTrash(ctx context.Context, before time.Time) (int, error)
+
+	// snapshots: Export writes every stored and trashed chunk to w, Import replaces them with
+	// the contents of an earlier Export, leaving the store untouched if it can't be read
+	Export(ctx context.Context, w io.Writer) error
+	Import(ctx context.Context, r io.ReadSeeker) error
+
+	// rebuilds the whole index without taking the current one offline: build fills a new,
+	// empty index through the Manager it is given, and once it returns nil the new index
+	// replaces the current one in a single step. Queries keep being answered from the current
+	// index until then, and never see a partial one. Files written to the current index while
+	// build ran, and derived documents such as summaries, are carried over into the new one.
+	// If build fails the new index is dropped and the current one stays.
+	Reindex(ctx context.Context, build func(staging Manager) error) error
+
+	// named collections: one deployment can keep several independent indexes, e.g. the notes
+	// and a set of work documents, each with its own chunks and trash. A Manager works on one
+	// of them, named by CollectionName; the one first created works on VECTOR_COLLECTION.
+	// Collection returns the Manager of another collection (vector.ErrNotFound if there is
+	// none by that name), CreateCollection creates an empty one (vector.ErrCollectionExists
+	// if the name is taken) and Collections lists them all, ordered by name.
+	CollectionName() string
+	Collection(name string) (Manager, error)
+	CreateCollection(ctx context.Context, name string) error
+	Collections(ctx context.Context) ([]CollectionInfo, error)
+
+	// number of chunks currently stored
+	Count(ctx context.Context) (int, error)
+
+	// removes exact duplicate chunks and, if threshold > 0, chunks at least that similar to a kept one
+	// of the same file; returns the number of removed chunks
+	DeduplicateVectors(ctx context.Context, threshold float32) (int, error)
+}
+
+// CollectionInfo describes a named collection.
+type CollectionInfo struct {
+	Name string `json:"name"`
+	// Chunks is the number of chunks stored in it, trash excluded
+	Chunks int `json:"chunks"`
 }
@@ -0,0 +1,662 @@
+package manager
+
+import (
+	"context"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+	"vex-backend/config"
+	"vex-backend/vector"
+	"vex-backend/vector/embed"
+)
+
+// memoryManager is a Manager that keeps every chunk in memory and scores queries with a
+// brute-force cosine similarity. It is meant for tests and small setups, not persistence.
+type memoryManager struct {
+	Embedder embed.Embedder
+	// DedupThreshold is the near-duplicate similarity threshold applied on store; 0 only
+	// removes exact duplicates
+	DedupThreshold float32
+	// SoftDelete moves chunks removed by DeleteVectorsWithMetaData and ReplaceFileVectorsInDB
+	// to the trash instead of dropping them
+	SoftDelete bool
+	// MaxDocuments caps the chunks held, evicting the least recently used; 0 is unbounded
+	// (see NewLRUManager)
+	MaxDocuments int
+	// Quantization keeps the embeddings of stored chunks as int8 or binary codes
+	// (QuantizeInt8, QuantizeBinary) instead of float32; "" keeps them as they are
+	Quantization string
+	// RescoreFactor is how many times n results of a quantized search are rescored with
+	// their full-precision vectors, if the collections keep a vector file
+	RescoreFactor int
+
+	// name is the collection the manager holds; collections those created alongside it
+	name        string
+	collections *memoryCollections
+
+	mu    sync.RWMutex
+	docs  map[string]vector.VectorData
+	trash map[string]vector.VectorData
+	// vectors holds the quantized embeddings of the chunks in docs, which then have none
+	vectors map[string]quantizedVector
+	recency *recency
+}
+
+// memoryCollections holds the managers of the collections created alongside one another.
+type memoryCollections struct {
+	mu     sync.Mutex
+	byName map[string]*memoryManager
+	// full keeps the full-precision vectors of quantized chunks for rescoring; nil if not
+	full *vectorFile
+}
+
+// NewMemoryManager returns an empty in-memory Manager of the collection "notes" that embeds
+// with e.
+func NewMemoryManager(e embed.Embedder) Manager {
+	mm := &memoryManager{
+		Embedder: e,
+		name:     notesCollection,
+		docs:     make(map[string]vector.VectorData),
+		trash:    make(map[string]vector.VectorData),
+		vectors:  make(map[string]quantizedVector),
+	}
+	mm.collections = &memoryCollections{byName: map[string]*memoryManager{mm.name: mm}}
+	return mm
+}
+
+func (mm *memoryManager) GetDBInstance() any {
+	return mm.docs
+}
+func (mm *memoryManager) GetEmbedder() embed.Embedder {
+	return mm.Embedder
+}
+
+// EmbedQuery embeds every query afresh; tests shouldn't depend on what an earlier one cached.
+func (mm *memoryManager) EmbedQuery(ctx context.Context, query string, language string) ([]float32, error) {
+	return embedQuery(ctx, mm.Embedder, query, language)
+}
+func (mm *memoryManager) QueryCacheStats() QueryCacheStats {
+	return QueryCacheStats{}
+}
+
+// MemoryUsage adds up the embeddings of every collection, live and trashed, counting
+// quantized ones at the size of their codes.
+func (mm *memoryManager) MemoryUsage(ctx context.Context) (MemoryUsage, error) {
+	c := mm.collections
+	c.mu.Lock()
+	managers := make([]*memoryManager, 0, len(c.byName))
+	for _, m := range c.byName {
+		managers = append(managers, m)
+	}
+	c.mu.Unlock()
+
+	var usage MemoryUsage
+	for _, m := range managers {
+		m.mu.RLock()
+		for _, v := range m.docs {
+			if q, ok := m.vectors[v.Id]; ok && len(v.Embedding) == 0 {
+				usage.Dimensions = q.dims
+				usage.EmbeddingBytes += int64(len(q.codes) + 8*len(q.signs))
+			} else {
+				usage.Dimensions = len(v.Embedding)
+				usage.EmbeddingBytes += embeddingBytes(1, len(v.Embedding))
+			}
+		}
+		for _, v := range m.trash {
+			usage.EmbeddingBytes += embeddingBytes(1, len(v.Embedding))
+		}
+		usage.Chunks += len(m.docs) + len(m.trash)
+		m.mu.RUnlock()
+	}
+	return usage, nil
+}
+
+// sorted returns a copy of every stored chunk ordered by ID. Callers must hold mm.mu.
+func (mm *memoryManager) sorted() []vector.VectorData {
+	out := make([]vector.VectorData, 0, len(mm.docs))
+	for _, v := range mm.docs {
+		out = append(out, v)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Id < out[j].Id })
+	return out
+}
+
+// storage functions
+func (mm *memoryManager) StoreVectorInDB(ctx context.Context, v vector.VectorData) error {
+	return mm.StoreVectorsInDB(ctx, []vector.VectorData{v})
+}
+
+// StoreVectorsInDB stores the vectors with the same duplicate handling as the chromem manager.
+func (mm *memoryManager) StoreVectorsInDB(ctx context.Context, vs []vector.VectorData) error {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	_, err := mm.storeLocked(ctx, vs)
+	mm.evictLocked()
+	return err
+}
+
+// storeLocked stores vs and returns the IDs stored before any error. Callers must hold mm.mu.
+func (mm *memoryManager) storeLocked(ctx context.Context, vs []vector.VectorData) ([]string, error) {
+	var added []string
+	for _, v := range vs {
+		if len(v.Embedding) == 0 {
+			embedding, err := mm.Embedder.EmbedToVector(ctx, v.Content)
+			if err != nil {
+				return added, err
+			}
+			v.Embedding = embedding
+		}
+
+		hash := contentHash(v.Content)
+		dup := false
+		for _, d := range mm.docs {
+			if dims := mm.dimsLocked(d); dims != len(v.Embedding) {
+				return added, fmt.Errorf("%w: stored %d, got %d", vector.ErrDimensionMismatch, dims, len(v.Embedding))
+			}
+			// duplicates are only looked for within the file, see chromemManager.isDuplicate
+			if d.Metadata["filepath"] != v.Metadata["filepath"] {
+				continue
+			}
+			if d.Metadata[ContentHashMetadataKey] == hash ||
+				(mm.DedupThreshold > 0 && mm.similarityLocked(d, v.Embedding) >= mm.DedupThreshold) {
+				dup = true
+				break
+			}
+		}
+		if dup {
+			continue
+		}
+
+		metadata := make(map[string]string, len(v.Metadata)+1)
+		for k, val := range v.Metadata {
+			metadata[k] = val
+		}
+		metadata[ContentHashMetadataKey] = hash
+		v.Metadata = metadata
+		v.Similarity = 0
+		mm.docs[v.Id] = mm.keepLocked(v)
+		mm.recency.touch(v)
+		added = append(added, v.Id)
+	}
+	return added, nil
+}
+func (mm *memoryManager) StoreFileAsVectorsInDB(ctx context.Context, filename string) error {
+	path, metadata, err := fileMetadata(filename)
+	if err != nil {
+		return err
+	}
+
+	vs, err := mm.Embedder.EmbedFileToVectorData(ctx, path, metadata)
+	if err != nil {
+		return err
+	}
+	return mm.StoreVectorsInDB(ctx, vs)
+}
+
+// ReplaceFileVectorsInDB embeds the whole file, then swaps the file's stored chunks for the
+// new ones in one step, restoring the previous chunks if storing fails.
+func (mm *memoryManager) ReplaceFileVectorsInDB(ctx context.Context, filename string) error {
+	path, metadata, err := fileMetadata(filename)
+	if err != nil {
+		return err
+	}
+
+	vs, err := mm.Embedder.EmbedFileToVectorData(ctx, path, metadata)
+	if err != nil {
+		return err
+	}
+
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	at := deletionTime(time.Now())
+	previous := mm.removeLocked("filepath", path, at)
+
+	added, err := mm.storeLocked(ctx, vs)
+	if err != nil {
+		for _, id := range added {
+			mm.dropLocked(id)
+		}
+		for id, v := range previous {
+			mm.docs[id] = mm.keepLocked(v)
+			delete(mm.trash, tombstone(v, at).Id)
+		}
+		return err
+	}
+	mm.evictLocked()
+	return nil
+}
+
+// removeLocked removes the chunks whose metadata key equals data, moving them to the trash
+// stamped with at if SoftDelete is set, and returns them. Callers must hold mm.mu.
+func (mm *memoryManager) removeLocked(key, data, at string) map[string]vector.VectorData {
+	removed := map[string]vector.VectorData{}
+	for id, v := range mm.docs {
+		if v.Metadata[key] != data {
+			continue
+		}
+		v = mm.withEmbeddingLocked(v)
+		removed[id] = v
+		mm.dropLocked(id)
+		if mm.SoftDelete {
+			t := tombstone(v, at)
+			mm.trash[t.Id] = t
+		}
+	}
+	return removed
+}
+
+// retrieval functions
+func (mm *memoryManager) RetriveVectorByMetadata(ctx context.Context, key string, data string) (vector.VectorData, error) {
+	vs, err := mm.GetByMetadata(ctx, map[string]string{key: data})
+	if err != nil {
+		return vector.VectorData{}, err
+	}
+	if len(vs) == 0 {
+		return vector.VectorData{}, fmt.Errorf("no document with metadata %s=%s: %w", key, data, vector.ErrNotFound)
+	}
+	return vs[0], nil
+}
+func (mm *memoryManager) RetriveVectorWithID(ctx context.Context, id string) (vector.VectorData, error) {
+	return mm.GetByID(ctx, id)
+}
+func (mm *memoryManager) GetByID(ctx context.Context, id string) (vector.VectorData, error) {
+	mm.mu.RLock()
+	defer mm.mu.RUnlock()
+
+	v, ok := mm.docs[id]
+	if !ok {
+		return vector.VectorData{}, fmt.Errorf("document %q: %w", id, vector.ErrNotFound)
+	}
+	mm.recency.touch(v)
+	return mm.withEmbeddingLocked(v), nil
+}
+func (mm *memoryManager) GetByMetadata(ctx context.Context, where map[string]string) ([]vector.VectorData, error) {
+	mm.mu.RLock()
+	defer mm.mu.RUnlock()
+
+	out := []vector.VectorData{}
+	for _, v := range mm.sorted() {
+		if matchesMetadata(v.Metadata, where) {
+			out = append(out, mm.withEmbeddingLocked(v))
+		}
+	}
+	sortByPosition(out)
+	mm.recency.touch(out...)
+	return out, nil
+}
+func (mm *memoryManager) GetChunksByFile(ctx context.Context, path string) ([]vector.VectorData, error) {
+	return mm.GetByMetadata(ctx, map[string]string{"filepath": path})
+}
+func (mm *memoryManager) RetriveNVectorsByQuery(ctx context.Context, query string, n int) ([]vector.VectorData, error) {
+	return mm.RetriveNVectorsByQueryWithFilter(ctx, query, n, nil)
+}
+func (mm *memoryManager) RetriveNVectorsByQueryWithFilter(ctx context.Context, query string, n int, where map[string]string) ([]vector.VectorData, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("n must be > 0")
+	}
+
+	mm.mu.RLock()
+	empty := len(mm.docs) == 0
+	mm.mu.RUnlock()
+	if empty {
+		return nil, vector.ErrEmptyCollection
+	}
+
+	embedding, err := mm.EmbedQuery(ctx, query, "")
+	if err != nil {
+		return nil, err
+	}
+	return mm.RetriveNVectorsByEmbedding(ctx, embedding, n, where)
+}
+func (mm *memoryManager) RetriveNVectorsByEmbedding(ctx context.Context, embedding []float32, n int, where map[string]string) ([]vector.VectorData, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("n must be > 0")
+	}
+
+	mm.mu.RLock()
+	defer mm.mu.RUnlock()
+	if len(mm.docs) == 0 {
+		return nil, vector.ErrEmptyCollection
+	}
+
+	out := []vector.VectorData{}
+	for _, v := range mm.sorted() {
+		if !matchesMetadata(v.Metadata, where) {
+			continue
+		}
+		if mm.dimsLocked(v) != len(embedding) {
+			return nil, fmt.Errorf("query failed: %w", vector.ErrDimensionMismatch)
+		}
+		v.Similarity = mm.similarityLocked(v, embedding)
+		out = append(out, v)
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Similarity > out[j].Similarity })
+	out = mm.rescoreLocked(out, embedding, n)
+	if len(out) > n {
+		out = out[:n]
+	}
+	for i := range out {
+		out[i].Embedding = mm.embeddingLocked(out[i])
+	}
+	mm.recency.touch(out...)
+	return out, nil
+}
+func (mm *memoryManager) RetriveNVectorsByQueryRanked(ctx context.Context, query string, n int, where map[string]string, rank RankOptions) ([]vector.VectorData, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("n must be > 0")
+	}
+	if rank.RecencyWeight <= 0 {
+		return mm.RetriveNVectorsByQueryWithFilter(ctx, query, n, where)
+	}
+
+	candidates, err := mm.RetriveNVectorsByQueryWithFilter(ctx, query, n*rankCandidateFactor, where)
+	if err != nil {
+		return nil, err
+	}
+	return rankByRecency(candidates, n, rank, time.Now()), nil
+}
+
+// deletion functions
+func (mm *memoryManager) DeleteVectorWithID(ctx context.Context, id string) error {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	mm.dropLocked(id)
+	return nil
+}
+func (mm *memoryManager) DeleteVectorsWithMetaData(ctx context.Context, key string, data string) error {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	mm.removeLocked(key, data, deletionTime(time.Now()))
+	return nil
+}
+
+// trash functions
+func (mm *memoryManager) TrashVectorsWithMetaData(ctx context.Context, key string, data string) (int, error) {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	return mm.trashLocked(key, data, deletionTime(time.Now())), nil
+}
+
+// trashLocked moves the chunks whose metadata key equals data to the trash, regardless of
+// SoftDelete. Callers must hold mm.mu.
+func (mm *memoryManager) trashLocked(key, data, at string) int {
+	n := 0
+	for id, v := range mm.docs {
+		if v.Metadata[key] == data {
+			t := tombstone(mm.withEmbeddingLocked(v), at)
+			mm.trash[t.Id] = t
+			mm.dropLocked(id)
+			n++
+		}
+	}
+	return n
+}
+func (mm *memoryManager) ListTrash(ctx context.Context) ([]vector.VectorData, error) {
+	mm.mu.RLock()
+	defer mm.mu.RUnlock()
+
+	out := make([]vector.VectorData, 0, len(mm.trash))
+	for _, v := range mm.trash {
+		out = append(out, v)
+	}
+	sortByPosition(out)
+	return out, nil
+}
+func (mm *memoryManager) RestoreFromTrash(ctx context.Context, path string, deletedAt string) (int, error) {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	if deletedAt == "" {
+		trashed := make([]vector.VectorData, 0, len(mm.trash))
+		for _, v := range mm.trash {
+			trashed = append(trashed, v)
+		}
+		deletedAt = latestDeletion(trashed, path)
+	}
+
+	var restore []string
+	for id, v := range mm.trash {
+		if v.Metadata["filepath"] == path && deletedAt != "" && v.Metadata[DeletedAtMetadataKey] == deletedAt {
+			restore = append(restore, id)
+		}
+	}
+	if len(restore) == 0 {
+		return 0, fmt.Errorf("no deleted chunks of %q in the trash: %w", path, vector.ErrNotFound)
+	}
+
+	mm.trashLocked("filepath", path, deletionTime(time.Now()))
+	for _, id := range restore {
+		v := untombstone(mm.trash[id])
+		mm.docs[v.Id] = mm.keepLocked(v)
+		mm.recency.touch(v)
+		delete(mm.trash, id)
+	}
+	mm.evictLocked()
+	return len(restore), nil
+}
+func (mm *memoryManager) PurgeTrash(ctx context.Context, before time.Time) (int, error) {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	cutoff := deletionTime(before)
+	n := 0
+	for id, v := range mm.trash {
+		if v.Metadata[DeletedAtMetadataKey] < cutoff {
+			delete(mm.trash, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// memorySnapshot is the gob encoding of a memoryManager's contents.
+type memorySnapshot struct {
+	Docs  map[string]vector.VectorData
+	Trash map[string]vector.VectorData
+}
+
+// snapshot functions
+func (mm *memoryManager) Export(ctx context.Context, w io.Writer) error {
+	mm.mu.RLock()
+	defer mm.mu.RUnlock()
+
+	docs := mm.docs
+	if mm.quantized() {
+		docs = make(map[string]vector.VectorData, len(mm.docs))
+		for id, v := range mm.docs {
+			docs[id] = mm.withEmbeddingLocked(v)
+		}
+	}
+	return gob.NewEncoder(w).Encode(memorySnapshot{Docs: docs, Trash: mm.trash})
+}
+func (mm *memoryManager) Import(ctx context.Context, r io.ReadSeeker) error {
+	var snap memorySnapshot
+	if err := gob.NewDecoder(r).Decode(&snap); err != nil {
+		return fmt.Errorf("failed to read snapshot: %w", err)
+	}
+	if snap.Docs == nil {
+		snap.Docs = map[string]vector.VectorData{}
+	}
+	if snap.Trash == nil {
+		snap.Trash = map[string]vector.VectorData{}
+	}
+
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	mm.docs, mm.trash = snap.Docs, snap.Trash
+	mm.vectors = make(map[string]quantizedVector)
+	for id, v := range mm.docs {
+		mm.docs[id] = mm.keepLocked(v)
+	}
+	mm.evictLocked()
+	return nil
+}
+
+// Reindex builds the new index in a separate memoryManager and swaps its chunks in.
+func (mm *memoryManager) Reindex(ctx context.Context, build func(staging Manager) error) error {
+	mm.mu.RLock()
+	before := fileFingerprints(mm.sorted())
+	mm.mu.RUnlock()
+
+	staging := &memoryManager{
+		Embedder:       mm.Embedder,
+		DedupThreshold: mm.DedupThreshold,
+		SoftDelete:     mm.SoftDelete,
+		MaxDocuments:   mm.MaxDocuments,
+		Quantization:   mm.Quantization,
+		RescoreFactor:  mm.RescoreFactor,
+		name:           mm.name,
+		collections:    mm.collections,
+		docs:           make(map[string]vector.VectorData),
+		trash:          make(map[string]vector.VectorData),
+		vectors:        make(map[string]quantizedVector),
+	}
+	if mm.recency != nil {
+		staging.recency = newRecency()
+	}
+	if err := build(staging); err != nil {
+		return err
+	}
+
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	current := mm.sorted()
+	carried := map[string]bool{}
+	for _, path := range carriedOver(before, fileFingerprints(current)) {
+		carried[path] = true
+	}
+	for id, v := range staging.docs {
+		if carried[v.Metadata["filepath"]] {
+			staging.dropLocked(id)
+		}
+	}
+	for _, v := range current {
+		if carried[v.Metadata["filepath"]] {
+			staging.docs[v.Id] = v
+			if q, ok := mm.vectors[v.Id]; ok {
+				staging.vectors[v.Id] = q
+			}
+			staging.recency.touch(v)
+		}
+	}
+	mm.docs, mm.vectors, mm.recency = staging.docs, staging.vectors, staging.recency
+	mm.evictLocked()
+	return nil
+}
+
+// collection functions
+func (mm *memoryManager) CollectionName() string {
+	return mm.name
+}
+func (mm *memoryManager) Collection(name string) (Manager, error) {
+	c := mm.collections
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if m, ok := c.byName[name]; ok {
+		return m, nil
+	}
+	return nil, fmt.Errorf("collection %q: %w", name, vector.ErrNotFound)
+}
+func (mm *memoryManager) CreateCollection(ctx context.Context, name string) error {
+	if err := config.CheckCollectionName(name); err != nil {
+		return err
+	}
+	c := mm.collections
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.byName[name]; ok {
+		return fmt.Errorf("collection %q: %w", name, vector.ErrCollectionExists)
+	}
+	c.byName[name] = &memoryManager{
+		Embedder:       mm.Embedder,
+		DedupThreshold: mm.DedupThreshold,
+		SoftDelete:     mm.SoftDelete,
+		MaxDocuments:   mm.MaxDocuments,
+		Quantization:   mm.Quantization,
+		RescoreFactor:  mm.RescoreFactor,
+		name:           name,
+		collections:    c,
+		docs:           make(map[string]vector.VectorData),
+		trash:          make(map[string]vector.VectorData),
+		vectors:        make(map[string]quantizedVector),
+	}
+	if mm.recency != nil {
+		c.byName[name].recency = newRecency()
+	}
+	return nil
+}
+func (mm *memoryManager) Collections(ctx context.Context) ([]CollectionInfo, error) {
+	c := mm.collections
+	c.mu.Lock()
+	managers := make([]*memoryManager, 0, len(c.byName))
+	for _, m := range c.byName {
+		managers = append(managers, m)
+	}
+	c.mu.Unlock()
+
+	sort.Slice(managers, func(i, j int) bool { return managers[i].name < managers[j].name })
+	out := make([]CollectionInfo, 0, len(managers))
+	for _, m := range managers {
+		n, _ := m.Count(ctx)
+		out = append(out, CollectionInfo{Name: m.name, Chunks: n})
+	}
+	return out, nil
+}
+
+// maintenance functions
+func (mm *memoryManager) Count(ctx context.Context) (int, error) {
+	mm.mu.RLock()
+	defer mm.mu.RUnlock()
+
+	return len(mm.docs), nil
+}
+func (mm *memoryManager) DeduplicateVectors(ctx context.Context, threshold float32) (int, error) {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	// duplicates are only looked for within a file, as when storing: seen is keyed by
+	// filepath and content hash, kept by filepath
+	seen := map[string]bool{}
+	kept := map[string][][]float32{}
+	removed := 0
+	for _, v := range mm.sorted() {
+		path := v.Metadata["filepath"]
+		hash := v.Metadata[ContentHashMetadataKey]
+		if hash == "" {
+			hash = contentHash(v.Content)
+		}
+		key := path + "\x00" + hash
+
+		embedding := mm.embeddingLocked(v)
+		dup := seen[key]
+		if !dup && threshold > 0 {
+			for _, e := range kept[path] {
+				if cosineSimilarity(e, embedding) >= threshold {
+					dup = true
+					break
+				}
+			}
+		}
+		if dup {
+			mm.dropLocked(v.Id)
+			removed++
+			continue
+		}
+
+		seen[key] = true
+		kept[path] = append(kept[path], embedding)
+	}
+	return removed, nil
+}
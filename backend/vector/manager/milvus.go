@@ -0,0 +1,391 @@
+package manager
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"vex-backend/httpclient"
+	"vex-backend/vector"
+)
+
+const (
+	// milvusBatchSize is how many entities are sent per upsert request
+	milvusBatchSize = 500
+	// milvusPageSize is how many entities are read per query request
+	milvusPageSize = 1000
+	// milvusMaxTopK is the most results Milvus returns for one search
+	milvusMaxTopK = 16384
+	// milvusChunksPartition and milvusTrashPartition are the partitions of every collection:
+	// searches only look at the chunks, so soft-deleted chunks never match a query
+	milvusChunksPartition = "chunks"
+	milvusTrashPartition  = "trash"
+	// milvusVectorField is the name of the vector field and of its index
+	milvusVectorField = "vector"
+)
+
+// milvusClient is a minimal client of the Milvus RESTful API (v2), covering what
+// milvusManager needs: collections, partitions and aliases, upserts, deletion by
+// expression, queries and HNSW search.
+type milvusClient struct {
+	baseURL string
+	token   string
+	db      string
+	http    httpclient.Doer
+}
+
+// milvusIndex holds the parameters of the HNSW index built on every collection.
+type milvusIndex struct {
+	M              int
+	EfConstruction int
+	Dimension      int
+}
+
+// milvusEntity is a chunk as the RESTful API sends and receives it. The chunk's metadata is a
+// JSON field, which filter expressions address as metadata["key"].
+type milvusEntity struct {
+	ID       string            `json:"id"`
+	Vector   []float32         `json:"vector,omitempty"`
+	Content  string            `json:"content"`
+	Metadata map[string]string `json:"metadata"`
+	// Distance is set on search results; with the COSINE metric it is the similarity
+	Distance *float32 `json:"distance,omitempty"`
+}
+
+func toMilvusEntity(v vector.VectorData) milvusEntity {
+	metadata := v.Metadata
+	if metadata == nil {
+		metadata = map[string]string{}
+	}
+	return milvusEntity{ID: v.Id, Vector: v.Embedding, Content: v.Content, Metadata: metadata}
+}
+
+func (e milvusEntity) vectorData() vector.VectorData {
+	v := vector.VectorData{Id: e.ID, Content: e.Content, Embedding: e.Vector, Metadata: e.Metadata}
+	if v.Metadata == nil {
+		v.Metadata = map[string]string{}
+	}
+	if e.Distance != nil {
+		v.Similarity = *e.Distance
+	}
+	return v
+}
+
+// milvusError maps a failed request onto the shared sentinel errors where one applies. The
+// RESTful API reports most errors with status 200 and a non-zero code.
+func milvusError(code int, msg string) error {
+	lower := strings.ToLower(msg)
+	switch {
+	case strings.Contains(lower, "rate limit"):
+		return fmt.Errorf("%w: milvus returned code %d: %s", vector.ErrRateLimited, code, msg)
+	case strings.Contains(lower, "collection not found"), strings.Contains(lower, "alias not found"):
+		return fmt.Errorf("milvus: %s: %w", msg, vector.ErrNotFound)
+	case strings.Contains(lower, "dim") && (strings.Contains(lower, "not equal") || strings.Contains(lower, "mismatch")):
+		return fmt.Errorf("%w: %s", vector.ErrDimensionMismatch, msg)
+	}
+	return fmt.Errorf("milvus returned code %d: %s", code, msg)
+}
+
+// do posts body to the endpoint under /v2/vectordb and decodes the data of the response
+// into out, if not nil. The database is added to every request.
+func (c *milvusClient) do(ctx context.Context, endpoint string, body map[string]any, out any) error {
+	if c.db != "" {
+		body["dbName"] = c.db
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/v2/vectordb/"+endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("milvus request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	respBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read milvus response: %w", err)
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return milvusError(resp.StatusCode, "rate limit: "+strings.TrimSpace(string(respBytes)))
+	}
+	if resp.StatusCode >= 300 {
+		return milvusError(resp.StatusCode, strings.TrimSpace(string(respBytes)))
+	}
+
+	var envelope struct {
+		Code    int             `json:"code"`
+		Message string          `json:"message"`
+		Data    json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(respBytes, &envelope); err != nil {
+		return fmt.Errorf("failed to decode milvus response: %w", err)
+	}
+	if envelope.Code != 0 {
+		return milvusError(envelope.Code, envelope.Message)
+	}
+	if out == nil || len(envelope.Data) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(envelope.Data, out); err != nil {
+		return fmt.Errorf("failed to decode milvus response: %w", err)
+	}
+	return nil
+}
+
+// collection functions
+func (c *milvusClient) collections(ctx context.Context) ([]string, error) {
+	var names []string
+	err := c.do(ctx, "collections/list", map[string]any{}, &names)
+	return names, err
+}
+
+// describe returns the description of a collection.
+func (c *milvusClient) describe(ctx context.Context, collection string) (string, error) {
+	var resp struct {
+		Description string `json:"description"`
+	}
+	err := c.do(ctx, "collections/describe", map[string]any{"collectionName": collection}, &resp)
+	return resp.Description, err
+}
+
+// createCollection creates a collection for chunks with an HNSW index on their vectors,
+// compared by cosine similarity, and its two partitions, then loads it for search.
+func (c *milvusClient) createCollection(ctx context.Context, collection, description string, index milvusIndex) error {
+	err := c.do(ctx, "collections/create", map[string]any{
+		"collectionName": collection,
+		"description":    description,
+		"schema": map[string]any{
+			"autoId":             false,
+			"enableDynamicField": false,
+			"fields": []map[string]any{
+				{"fieldName": "id", "dataType": "VarChar", "isPrimary": true, "elementTypeParams": map[string]any{"max_length": 1024}},
+				{"fieldName": milvusVectorField, "dataType": "FloatVector", "elementTypeParams": map[string]any{"dim": index.Dimension}},
+				{"fieldName": "content", "dataType": "VarChar", "elementTypeParams": map[string]any{"max_length": 65535}},
+				{"fieldName": "metadata", "dataType": "JSON"},
+			},
+		},
+		"indexParams": []map[string]any{{
+			"fieldName":  milvusVectorField,
+			"indexName":  milvusVectorField,
+			"metricType": "COSINE",
+			"params":     map[string]any{"index_type": "HNSW", "M": index.M, "efConstruction": index.EfConstruction},
+		}},
+	}, nil)
+	if err != nil {
+		return err
+	}
+	for _, partition := range []string{milvusChunksPartition, milvusTrashPartition} {
+		if err := c.do(ctx, "partitions/create", map[string]any{"collectionName": collection, "partitionName": partition}, nil); err != nil {
+			return err
+		}
+	}
+	return c.do(ctx, "collections/load", map[string]any{"collectionName": collection}, nil)
+}
+
+func (c *milvusClient) dropCollection(ctx context.Context, collection string) error {
+	return c.do(ctx, "collections/drop", map[string]any{"collectionName": collection}, nil)
+}
+
+// alias functions
+
+// aliases returns every alias of the database with the collection it points to.
+func (c *milvusClient) aliases(ctx context.Context) (map[string]string, error) {
+	var names []string
+	if err := c.do(ctx, "aliases/list", map[string]any{}, &names); err != nil {
+		return nil, err
+	}
+	out := make(map[string]string, len(names))
+	for _, name := range names {
+		var resp struct {
+			CollectionName string `json:"collectionName"`
+		}
+		if err := c.do(ctx, "aliases/describe", map[string]any{"aliasName": name}, &resp); err != nil {
+			return nil, err
+		}
+		out[name] = resp.CollectionName
+	}
+	return out, nil
+}
+
+// setAlias points alias at collection, creating it if it doesn't exist yet. Moving an alias
+// is atomic: requests through it see either collection, never neither.
+func (c *milvusClient) setAlias(ctx context.Context, alias, collection string, exists bool) error {
+	endpoint := "aliases/create"
+	if exists {
+		endpoint = "aliases/alter"
+	}
+	return c.do(ctx, endpoint, map[string]any{"aliasName": alias, "collectionName": collection}, nil)
+}
+
+// entity functions
+
+// upsert stores vs in a partition of collection, milvusBatchSize entities per request.
+// Chunks with an ID that is already stored replace it.
+func (c *milvusClient) upsert(ctx context.Context, collection, partition string, vs []vector.VectorData) error {
+	for start := 0; start < len(vs); start += milvusBatchSize {
+		batch := vs[start:min(start+milvusBatchSize, len(vs))]
+		entities := make([]milvusEntity, 0, len(batch))
+		for _, v := range batch {
+			entities = append(entities, toMilvusEntity(v))
+		}
+		body := map[string]any{"collectionName": collection, "partitionName": partition, "data": entities}
+		if err := c.do(ctx, "entities/upsert", body, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// delete deletes the entities of a partition of collection matching the filter expression.
+func (c *milvusClient) delete(ctx context.Context, collection, partition, filter string) error {
+	body := map[string]any{"collectionName": collection, "partitionName": partition, "filter": filter}
+	return c.do(ctx, "entities/delete", body, nil)
+}
+
+// deleteIDs deletes the chunks with the given IDs from a partition of collection.
+func (c *milvusClient) deleteIDs(ctx context.Context, collection, partition string, ids []string) error {
+	for start := 0; start < len(ids); start += milvusPageSize {
+		batch := ids[start:min(start+milvusPageSize, len(ids))]
+		if err := c.delete(ctx, collection, partition, "id in "+milvusValue(batch)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// query returns every chunk of a partition of collection matching the filter expression
+// ("" for all of them), paging through the primary keys the way the SDKs' query iterators
+// do.
+func (c *milvusClient) query(ctx context.Context, collection, partition, filter string) ([]vector.VectorData, error) {
+	var out []vector.VectorData
+	last := ""
+	for {
+		page := `id > ` + milvusValue(last)
+		if filter != "" {
+			page += " and (" + filter + ")"
+		}
+		var entities []milvusEntity
+		body := map[string]any{
+			"collectionName": collection,
+			"partitionNames": []string{partition},
+			"filter":         page,
+			"outputFields":   []string{"id", milvusVectorField, "content", "metadata"},
+			"limit":          milvusPageSize,
+		}
+		if err := c.do(ctx, "entities/query", body, &entities); err != nil {
+			return nil, err
+		}
+		for _, e := range entities {
+			out = append(out, e.vectorData())
+			last = max(last, e.ID)
+		}
+		if len(entities) < milvusPageSize {
+			return out, nil
+		}
+	}
+}
+
+// first returns up to n chunks of a partition of collection matching the filter expression,
+// without paging.
+func (c *milvusClient) first(ctx context.Context, collection, partition, filter string, n int) ([]vector.VectorData, error) {
+	var entities []milvusEntity
+	body := map[string]any{
+		"collectionName": collection,
+		"partitionNames": []string{partition},
+		"filter":         filter,
+		"outputFields":   []string{"id", milvusVectorField, "content", "metadata"},
+		"limit":          n,
+	}
+	if err := c.do(ctx, "entities/query", body, &entities); err != nil {
+		return nil, err
+	}
+	out := make([]vector.VectorData, 0, len(entities))
+	for _, e := range entities {
+		out = append(out, e.vectorData())
+	}
+	return out, nil
+}
+
+// search returns the n chunks of the chunks partition of collection closest to embedding
+// among those matching the filter expression, with their cosine similarity. ef, the size of
+// the HNSW candidate list, is raised to n if lower, as Milvus requires.
+func (c *milvusClient) search(ctx context.Context, collection string, embedding []float32, filter string, n, ef int) ([]vector.VectorData, error) {
+	n = min(n, milvusMaxTopK)
+	body := map[string]any{
+		"collectionName": collection,
+		"partitionNames": []string{milvusChunksPartition},
+		"data":           [][]float32{embedding},
+		"annsField":      milvusVectorField,
+		"limit":          n,
+		"outputFields":   []string{"id", milvusVectorField, "content", "metadata"},
+		"searchParams":   map[string]any{"metricType": "COSINE", "params": map[string]any{"ef": max(ef, n)}},
+	}
+	if filter != "" {
+		body["filter"] = filter
+	}
+	var entities []milvusEntity
+	if err := c.do(ctx, "entities/search", body, &entities); err != nil {
+		return nil, err
+	}
+	out := make([]vector.VectorData, 0, len(entities))
+	for _, e := range entities {
+		out = append(out, e.vectorData())
+	}
+	return out, nil
+}
+
+// count returns the number of chunks in a partition of collection.
+func (c *milvusClient) count(ctx context.Context, collection, partition string) (int, error) {
+	var rows []map[string]int
+	body := map[string]any{
+		"collectionName": collection,
+		"partitionNames": []string{partition},
+		"filter":         "",
+		"outputFields":   []string{"count(*)"},
+	}
+	if err := c.do(ctx, "entities/query", body, &rows); err != nil {
+		return 0, err
+	}
+	if len(rows) == 0 {
+		return 0, nil
+	}
+	return rows[0]["count(*)"], nil
+}
+
+// milvusFilter turns an exact-match metadata filter into a filter expression on the
+// metadata JSON field; "" if where is empty.
+func milvusFilter(where map[string]string) string {
+	keys := make([]string, 0, len(where))
+	for k := range where {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	terms := make([]string, 0, len(keys))
+	for _, k := range keys {
+		terms = append(terms, fmt.Sprintf("metadata[%s] == %s", milvusValue(k), milvusValue(where[k])))
+	}
+	return strings.Join(terms, " and ")
+}
+
+// milvusValue encodes v as a literal of a filter expression. JSON strings and lists of
+// strings are valid literals as they are, as long as '<', '>' and '&' aren't escaped.
+func milvusValue(v any) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(v)
+	return strings.TrimSuffix(buf.String(), "\n")
+}
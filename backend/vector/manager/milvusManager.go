@@ -0,0 +1,647 @@
+package manager
+
+import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"vex-backend/config"
+	"vex-backend/httpclient"
+	"vex-backend/vector"
+	"vex-backend/vector/embed"
+)
+
+// milvusDescription marks the Milvus collections vex-backend created, followed by the name of
+// their vex collection; the description of a collection can't be changed, so which one is
+// live is recorded by an alias instead.
+const milvusDescription = "vex collection "
+
+// milvusManager is a Manager storing the chunks in a Milvus instance (VECTOR_BACKEND=milvus).
+// Each vex collection is a Milvus collection reached through an alias, with a partition for
+// the chunks and one for the trash; Reindex and Import build a new Milvus collection and move
+// the alias to it. Vectors are computed by the Embedder and searched with an HNSW index.
+type milvusManager struct {
+	client   *milvusClient
+	Embedder embed.Embedder
+	// Config supplies the reloadable settings (dedup threshold, search ef)
+	Config config.Source
+	// queries caches the embeddings of recent queries
+	queries *queryCache
+	// name is the vex collection the manager works on, alias the Milvus alias pointing to
+	// its live collection, and collection that Milvus collection, which changes when Reindex
+	// or Import move the alias
+	name       string
+	alias      string
+	collection string
+	// reindexing allows one Reindex at a time
+	reindexing sync.Mutex
+	// collections holds the managers of all collections opened so far
+	collections *milvusCollections
+
+	// mu makes every method atomic with respect to the others within this process, as for
+	// the chromem manager. Methods named ...Locked expect the caller to hold it.
+	mu sync.RWMutex
+}
+
+// NewMilvusManager returns a Manager storing vectors in the Milvus instance at MILVUS_URL,
+// creating the collection of VECTOR_COLLECTION if it doesn't exist yet.
+func NewMilvusManager(cfg config.Source, e embed.Embedder, client httpclient.Doer) (Manager, error) {
+	c := cfg()
+	collections := &milvusCollections{
+		client:   &milvusClient{baseURL: strings.TrimRight(c.MilvusURL, "/"), token: c.MilvusToken, db: c.MilvusDatabase, http: client},
+		prefix:   c.MilvusCollectionPrefix,
+		index:    milvusIndex{M: c.MilvusHNSWM, EfConstruction: c.MilvusHNSWEfConstruction, Dimension: c.MilvusDimension},
+		embedder: e,
+		config:   cfg,
+		queries:  newQueryCache(cfg),
+		byName:   map[string]*milvusManager{},
+	}
+	name := c.VectorCollection
+	if name == "" {
+		name = notesCollection
+	}
+
+	collections.mu.Lock()
+	defer collections.mu.Unlock()
+	mm, err := collections.openLocked(context.Background(), name, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the %s collection in milvus: %w", name, err)
+	}
+	return mm, nil
+}
+
+// milvusCollections holds the manager of every collection opened so far, shared by all of
+// them, so that each collection has a single manager and lock.
+type milvusCollections struct {
+	client   *milvusClient
+	prefix   string
+	index    milvusIndex
+	embedder embed.Embedder
+	config   config.Source
+	queries  *queryCache
+
+	mu     sync.Mutex
+	byName map[string]*milvusManager
+}
+
+// aliasOf returns the Milvus alias of the named collection. Milvus names hold letters,
+// digits and '_' only, so '-' becomes '_'; two names mapping to the same alias can't both
+// be created.
+func (c *milvusCollections) aliasOf(name string) string {
+	return c.prefix + "_" + strings.ReplaceAll(name, "-", "_")
+}
+
+// isVersionOf reports whether name is one of the collections or indexes built for alias:
+// the alias followed by sep and a timestamp.
+func isVersionOf(name, alias, sep string) bool {
+	ts, ok := strings.CutPrefix(name, alias+sep)
+	if !ok || ts == "" {
+		return false
+	}
+	_, err := strconv.ParseInt(ts, 10, 64)
+	return err == nil
+}
+
+// newCollection creates the next Milvus collection of the named vex collection.
+func (c *milvusCollections) newCollection(ctx context.Context, name string) (string, error) {
+	collection := c.aliasOf(name) + "_" + strconv.FormatInt(time.Now().UnixNano(), 10)
+	if err := c.client.createCollection(ctx, collection, milvusDescription+name, c.index); err != nil {
+		return "", err
+	}
+	return collection, nil
+}
+
+// openLocked returns a manager of the named collection, resolving its alias. With create
+// set, a missing collection is created; otherwise it is reported as vector.ErrNotFound.
+// Milvus collections of a Reindex or Import that didn't finish, or that were replaced, are
+// dropped. The caller holds c.mu.
+func (c *milvusCollections) openLocked(ctx context.Context, name string, create bool) (*milvusManager, error) {
+	aliases, err := c.client.aliases(ctx)
+	if err != nil {
+		return nil, err
+	}
+	alias := c.aliasOf(name)
+	live, exists := aliases[alias]
+
+	names, err := c.client.collections(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, collection := range names {
+		if collection != live && isVersionOf(collection, alias, "_") {
+			if err := c.client.dropCollection(ctx, collection); err != nil {
+				log.Printf("[milvusManager] warning: failed to drop unused collection %s: %v", collection, err)
+			}
+		}
+	}
+
+	if !exists {
+		if !create {
+			return nil, fmt.Errorf("collection %q: %w", name, vector.ErrNotFound)
+		}
+		if live, err = c.newCollection(ctx, name); err != nil {
+			return nil, err
+		}
+		if err := c.client.setAlias(ctx, alias, live, false); err != nil {
+			return nil, err
+		}
+	}
+
+	mm := &milvusManager{
+		client:      c.client,
+		Embedder:    c.embedder,
+		Config:      c.config,
+		queries:     c.queries,
+		name:        name,
+		alias:       alias,
+		collection:  live,
+		collections: c,
+	}
+	c.byName[name] = mm
+	return mm, nil
+}
+
+func (mm *milvusManager) GetDBInstance() any {
+	return mm.client
+}
+func (mm *milvusManager) GetEmbedder() embed.Embedder {
+	return mm.Embedder
+}
+func (mm *milvusManager) EmbedQuery(ctx context.Context, query string, language string) ([]float32, error) {
+	return mm.queries.embed(ctx, mm.Embedder, query, language)
+}
+func (mm *milvusManager) QueryCacheStats() QueryCacheStats {
+	return mm.queries.stats()
+}
+
+// MemoryUsage reports the vectors as remote: they live in the server.
+func (mm *milvusManager) MemoryUsage(ctx context.Context) (MemoryUsage, error) {
+	return remoteMemoryUsage(ctx)
+}
+
+// softDelete reports whether SOFT_DELETE is currently enabled.
+func (mm *milvusManager) softDelete() bool {
+	return mm.Config != nil && mm.Config().SoftDelete
+}
+
+// searchEf returns MILVUS_SEARCH_EF, the size of the HNSW candidate list of a search.
+func (mm *milvusManager) searchEf() int {
+	if mm.Config == nil {
+		return 0
+	}
+	return mm.Config().MilvusSearchEf
+}
+
+// store returns the chunkStore of the manager: the chunks and trash partitions of its
+// collection.
+func (mm *milvusManager) store() chunkStore {
+	return milvusStore{client: mm.client, collection: mm.collection, ef: mm.searchEf()}
+}
+
+// milvusStore is the chunkStore of a Milvus collection, with the trash in a partition of
+// its own.
+type milvusStore struct {
+	client     *milvusClient
+	collection string
+	ef         int
+}
+
+func (s milvusStore) chunks(ctx context.Context, where map[string]string) ([]vector.VectorData, error) {
+	return s.client.query(ctx, s.collection, milvusChunksPartition, milvusFilter(where))
+}
+func (s milvusStore) nearest(ctx context.Context, embedding []float32, where map[string]string, n int) ([]vector.VectorData, error) {
+	return s.client.search(ctx, s.collection, embedding, milvusFilter(where), n, s.ef)
+}
+func (s milvusStore) put(ctx context.Context, vs []vector.VectorData) error {
+	return s.client.upsert(ctx, s.collection, milvusChunksPartition, vs)
+}
+func (s milvusStore) deleteIDs(ctx context.Context, ids []string) error {
+	return s.client.deleteIDs(ctx, s.collection, milvusChunksPartition, ids)
+}
+func (s milvusStore) deleteWhere(ctx context.Context, where map[string]string) error {
+	return s.client.delete(ctx, s.collection, milvusChunksPartition, milvusFilter(where))
+}
+func (s milvusStore) trashed(ctx context.Context) ([]vector.VectorData, error) {
+	return s.client.query(ctx, s.collection, milvusTrashPartition, "")
+}
+func (s milvusStore) putTrash(ctx context.Context, vs []vector.VectorData) error {
+	return s.client.upsert(ctx, s.collection, milvusTrashPartition, vs)
+}
+func (s milvusStore) deleteTrashIDs(ctx context.Context, ids []string) error {
+	return s.client.deleteIDs(ctx, s.collection, milvusTrashPartition, ids)
+}
+
+// storage functions
+func (mm *milvusManager) StoreVectorInDB(ctx context.Context, v vector.VectorData) error {
+	return mm.StoreVectorsInDB(ctx, []vector.VectorData{v})
+}
+
+// StoreVectorsInDB stores the vectors in batched upserts, with the same duplicate handling
+// as the chromem manager.
+func (mm *milvusManager) StoreVectorsInDB(ctx context.Context, vs []vector.VectorData) error {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	_, err := mm.storeLocked(ctx, vs)
+	return err
+}
+
+// storeLocked is StoreVectorsInDB, also returning the IDs sent to Milvus, so a failed
+// batch can be rolled back.
+func (mm *milvusManager) storeLocked(ctx context.Context, vs []vector.VectorData) ([]string, error) {
+	return storeChunks(ctx, mm.store(), mm.Embedder, DedupSimilarityThresholdFrom(mm.Config()), vs, "milvusManager")
+}
+func (mm *milvusManager) StoreFileAsVectorsInDB(ctx context.Context, filename string) error {
+	path, metadata, err := fileMetadata(filename)
+	if err != nil {
+		return err
+	}
+
+	vs, err := mm.Embedder.EmbedFileToVectorData(ctx, path, metadata)
+	if err != nil {
+		return err
+	}
+	return mm.StoreVectorsInDB(ctx, vs)
+}
+
+// ReplaceFileVectorsInDB works like the chromem manager's: the file is embedded first,
+// reusing the embeddings of unchanged chunks, then its stored chunks are swapped for the new
+// ones, and restored if storing fails.
+func (mm *milvusManager) ReplaceFileVectorsInDB(ctx context.Context, filename string) error {
+	path, metadata, err := fileMetadata(filename)
+	if err != nil {
+		return err
+	}
+	reusable, err := mm.GetChunksByFile(ctx, path)
+	if err != nil {
+		return err
+	}
+
+	vs, err := mm.Embedder.EmbedFileToVectorData(embed.WithPrevious(ctx, reusable), path, metadata)
+	if err != nil {
+		return err
+	}
+
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	return replaceFileChunks(ctx, mm.store(), path, vs, mm.softDelete(), mm.storeLocked, "milvusManager")
+}
+
+// retrieval functions
+func (mm *milvusManager) RetriveVectorByMetadata(ctx context.Context, key string, data string) (vector.VectorData, error) {
+	vs, err := mm.GetByMetadata(ctx, map[string]string{key: data})
+	if err != nil {
+		return vector.VectorData{}, err
+	}
+	if len(vs) == 0 {
+		return vector.VectorData{}, fmt.Errorf("no document with metadata %s=%s: %w", key, data, vector.ErrNotFound)
+	}
+	return vs[0], nil
+}
+func (mm *milvusManager) RetriveVectorWithID(ctx context.Context, id string) (vector.VectorData, error) {
+	return mm.GetByID(ctx, id)
+}
+func (mm *milvusManager) GetByID(ctx context.Context, id string) (vector.VectorData, error) {
+	mm.mu.RLock()
+	defer mm.mu.RUnlock()
+
+	vs, err := mm.client.first(ctx, mm.collection, milvusChunksPartition, "id == "+milvusValue(id), 1)
+	if err != nil {
+		return vector.VectorData{}, err
+	}
+	if len(vs) == 0 {
+		return vector.VectorData{}, fmt.Errorf("document %q: %w", id, vector.ErrNotFound)
+	}
+	return vs[0], nil
+}
+func (mm *milvusManager) GetByMetadata(ctx context.Context, where map[string]string) ([]vector.VectorData, error) {
+	mm.mu.RLock()
+	defer mm.mu.RUnlock()
+
+	return mm.getByMetadataLocked(ctx, where)
+}
+func (mm *milvusManager) getByMetadataLocked(ctx context.Context, where map[string]string) ([]vector.VectorData, error) {
+	return listChunks(ctx, mm.store(), where)
+}
+func (mm *milvusManager) GetChunksByFile(ctx context.Context, path string) ([]vector.VectorData, error) {
+	return mm.GetByMetadata(ctx, map[string]string{"filepath": path})
+}
+func (mm *milvusManager) RetriveNVectorsByQuery(ctx context.Context, query string, n int) ([]vector.VectorData, error) {
+	return mm.RetriveNVectorsByQueryWithFilter(ctx, query, n, nil)
+}
+func (mm *milvusManager) RetriveNVectorsByQueryWithFilter(ctx context.Context, query string, n int, where map[string]string) ([]vector.VectorData, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("n must be > 0")
+	}
+	if query == "" {
+		return nil, errors.New("query failed: query is empty")
+	}
+	// an empty collection fails without spending an embedding on the query
+	if count, err := mm.Count(ctx); err == nil && count == 0 {
+		return nil, vector.ErrEmptyCollection
+	}
+
+	embedding, err := mm.EmbedQuery(ctx, query, "")
+	if err != nil {
+		return nil, fmt.Errorf("query failed: couldn't create embedding of query: %w", err)
+	}
+	return mm.RetriveNVectorsByEmbedding(ctx, embedding, n, where)
+}
+func (mm *milvusManager) RetriveNVectorsByEmbedding(ctx context.Context, embedding []float32, n int, where map[string]string) ([]vector.VectorData, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("n must be > 0")
+	}
+	mm.mu.RLock()
+	defer mm.mu.RUnlock()
+
+	results, err := mm.client.search(ctx, mm.collection, embedding, milvusFilter(where), n, mm.searchEf())
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	// only an empty result is worth telling an empty collection apart
+	if len(results) == 0 {
+		if count, err := mm.client.count(ctx, mm.collection, milvusChunksPartition); err == nil && count == 0 {
+			return nil, vector.ErrEmptyCollection
+		}
+	}
+	return results, nil
+}
+func (mm *milvusManager) RetriveNVectorsByQueryRanked(ctx context.Context, query string, n int, where map[string]string, rank RankOptions) ([]vector.VectorData, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("n must be > 0")
+	}
+	if rank.RecencyWeight <= 0 {
+		return mm.RetriveNVectorsByQueryWithFilter(ctx, query, n, where)
+	}
+
+	candidates, err := mm.RetriveNVectorsByQueryWithFilter(ctx, query, n*rankCandidateFactor, where)
+	if err != nil {
+		return nil, err
+	}
+	return rankByRecency(candidates, n, rank, time.Now()), nil
+}
+
+// deletion functions
+func (mm *milvusManager) DeleteVectorWithID(ctx context.Context, id string) error {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	return mm.client.deleteIDs(ctx, mm.collection, milvusChunksPartition, []string{id})
+}
+func (mm *milvusManager) DeleteVectorsWithMetaData(ctx context.Context, key string, data string) error {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	if mm.softDelete() {
+		_, err := mm.trashLocked(ctx, key, data)
+		return err
+	}
+	return mm.client.delete(ctx, mm.collection, milvusChunksPartition, milvusFilter(map[string]string{key: data}))
+}
+
+// trash functions
+func (mm *milvusManager) TrashVectorsWithMetaData(ctx context.Context, key string, data string) (int, error) {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	return mm.trashLocked(ctx, key, data)
+}
+func (mm *milvusManager) trashLocked(ctx context.Context, key string, data string) (int, error) {
+	return trashWhere(ctx, mm.store(), key, data)
+}
+func (mm *milvusManager) ListTrash(ctx context.Context) ([]vector.VectorData, error) {
+	mm.mu.RLock()
+	defer mm.mu.RUnlock()
+
+	return mm.listTrashLocked(ctx)
+}
+func (mm *milvusManager) listTrashLocked(ctx context.Context) ([]vector.VectorData, error) {
+	return listTrash(ctx, mm.store())
+}
+func (mm *milvusManager) RestoreFromTrash(ctx context.Context, path string, deletedAt string) (int, error) {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	return restoreFromTrash(ctx, mm.store(), path, deletedAt)
+}
+func (mm *milvusManager) PurgeTrash(ctx context.Context, before time.Time) (int, error) {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	return purgeTrash(ctx, mm.store(), before)
+}
+
+// snapshot functions
+
+// Export writes the chunks and the trash in the snapshot format of the in-memory manager;
+// snapshots of the chromem store can't be imported here, nor the other way round.
+func (mm *milvusManager) Export(ctx context.Context, w io.Writer) error {
+	mm.mu.RLock()
+	defer mm.mu.RUnlock()
+
+	return exportSnapshot(ctx, mm.store(), w)
+}
+
+// Import fills a new Milvus collection with the snapshot and moves the alias to it, so
+// queries see the previous chunks until the import is complete.
+func (mm *milvusManager) Import(ctx context.Context, r io.ReadSeeker) error {
+	var snap memorySnapshot
+	if err := gob.NewDecoder(r).Decode(&snap); err != nil {
+		return fmt.Errorf("failed to read snapshot: %w", err)
+	}
+
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	collection, err := mm.collections.newCollection(ctx, mm.name)
+	if err != nil {
+		return err
+	}
+	docs := make([]vector.VectorData, 0, len(snap.Docs))
+	for _, v := range snap.Docs {
+		docs = append(docs, v)
+	}
+	trashed := make([]vector.VectorData, 0, len(snap.Trash))
+	for _, v := range snap.Trash {
+		trashed = append(trashed, v)
+	}
+	err = mm.client.upsert(ctx, collection, milvusChunksPartition, docs)
+	if err == nil {
+		err = mm.client.upsert(ctx, collection, milvusTrashPartition, trashed)
+	}
+	if err != nil {
+		if derr := mm.client.dropCollection(ctx, collection); derr != nil {
+			log.Printf("[milvusManager] warning: failed to drop import collection %s: %v", collection, derr)
+		}
+		return fmt.Errorf("failed to import snapshot: %w", err)
+	}
+	return mm.swapLocked(ctx, collection)
+}
+
+// Reindex builds the new index in a Milvus collection of its own, through a manager sharing
+// the client, copies the trash over and moves the alias to it.
+func (mm *milvusManager) Reindex(ctx context.Context, build func(staging Manager) error) error {
+	mm.reindexing.Lock()
+	defer mm.reindexing.Unlock()
+
+	collection, err := mm.collections.newCollection(ctx, mm.name)
+	if err != nil {
+		return err
+	}
+	swapped := false
+	defer func() {
+		if !swapped {
+			if err := mm.client.dropCollection(context.Background(), collection); err != nil {
+				log.Printf("[milvusManager] warning: failed to drop reindex collection %s: %v", collection, err)
+			}
+		}
+	}()
+
+	mm.mu.RLock()
+	live, err := mm.client.query(ctx, mm.collection, milvusChunksPartition, "")
+	mm.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	before := fileFingerprints(live)
+
+	staging := &milvusManager{
+		client:      mm.client,
+		Embedder:    mm.Embedder,
+		Config:      mm.Config,
+		queries:     newQueryCache(mm.Config),
+		name:        mm.name,
+		alias:       mm.alias,
+		collection:  collection,
+		collections: mm.collections,
+	}
+	if err := build(staging); err != nil {
+		return err
+	}
+
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	if err := carryOver(ctx, mm.store(), staging.store(), before, "milvusManager"); err != nil {
+		return err
+	}
+
+	// the trash is a partition of the live collection, so it moves along
+	trashed, err := mm.store().trashed(ctx)
+	if err != nil {
+		return err
+	}
+	if err := staging.store().putTrash(ctx, trashed); err != nil {
+		return err
+	}
+
+	if err := mm.swapLocked(ctx, collection); err != nil {
+		return err
+	}
+	swapped = true
+	log.Printf("[milvusManager] reindex: swapped in %s", collection)
+	return nil
+}
+
+// swapLocked moves the alias to collection and drops the previous one. A crash in between
+// leaves the previous collection behind, and the next start drops it.
+func (mm *milvusManager) swapLocked(ctx context.Context, collection string) error {
+	if err := mm.client.setAlias(ctx, mm.alias, collection, true); err != nil {
+		return fmt.Errorf("failed to point %s at %s: %w", mm.alias, collection, err)
+	}
+	old := mm.collection
+	mm.collection = collection
+	if err := mm.client.dropCollection(ctx, old); err != nil {
+		log.Printf("[milvusManager] warning: failed to drop previous collection %s: %v", old, err)
+	}
+	return nil
+}
+
+// collection functions
+func (mm *milvusManager) CollectionName() string {
+	return mm.name
+}
+func (mm *milvusManager) Collection(name string) (Manager, error) {
+	c := mm.collections
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if m, ok := c.byName[name]; ok {
+		return m, nil
+	}
+	return c.openLocked(context.Background(), name, false)
+}
+func (mm *milvusManager) CreateCollection(ctx context.Context, name string) error {
+	if err := config.CheckCollectionName(name); err != nil {
+		return err
+	}
+	c := mm.collections
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	aliases, err := c.client.aliases(ctx)
+	if err != nil {
+		return err
+	}
+	if _, ok := aliases[c.aliasOf(name)]; ok {
+		return fmt.Errorf("collection %q: %w", name, vector.ErrCollectionExists)
+	}
+	_, err = c.openLocked(ctx, name, true)
+	return err
+}
+func (mm *milvusManager) Collections(ctx context.Context) ([]CollectionInfo, error) {
+	aliases, err := mm.client.aliases(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for alias, collection := range aliases {
+		if !isVersionOf(collection, alias, "_") {
+			continue
+		}
+		description, err := mm.client.describe(ctx, collection)
+		if err != nil {
+			return nil, err
+		}
+		if name, ok := strings.CutPrefix(description, milvusDescription); ok && mm.collections.aliasOf(name) == alias {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	out := make([]CollectionInfo, 0, len(names))
+	for _, name := range names {
+		m, err := mm.Collection(name)
+		if err != nil {
+			return nil, err
+		}
+		n, err := m.Count(ctx)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, CollectionInfo{Name: name, Chunks: n})
+	}
+	return out, nil
+}
+
+// maintenance functions
+func (mm *milvusManager) Count(ctx context.Context) (int, error) {
+	mm.mu.RLock()
+	defer mm.mu.RUnlock()
+
+	return mm.client.count(ctx, mm.collection, milvusChunksPartition)
+}
+func (mm *milvusManager) DeduplicateVectors(ctx context.Context, threshold float32) (int, error) {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	return deduplicate(ctx, mm.store(), threshold)
+}
@@ -0,0 +1,413 @@
+package manager
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"vex-backend/httpclient"
+	"vex-backend/vector"
+)
+
+const (
+	// openSearchBatchSize is how many documents are sent per bulk request
+	openSearchBatchSize = 500
+	// openSearchPageSize is how many documents are read per search request
+	openSearchPageSize = 1000
+)
+
+// openSearchClient is a minimal client of the OpenSearch REST API, covering what
+// openSearchManager needs: indexes and aliases, bulk indexing, deletion by query, paged
+// searches and k-NN and hybrid queries.
+type openSearchClient struct {
+	baseURL  string
+	username string
+	password string
+	http     httpclient.Doer
+}
+
+// openSearchDoc is a chunk as it is indexed. Metadata is kept as JSON for reading it back,
+// and as "key=value" keywords in meta for exact-match filters; content is analyzed for BM25.
+type openSearchDoc struct {
+	ChunkID  string    `json:"chunk_id"`
+	Content  string    `json:"content"`
+	Metadata string    `json:"metadata"`
+	Meta     []string  `json:"meta"`
+	Vector   []float32 `json:"vector"`
+}
+
+func toOpenSearchDoc(v vector.VectorData) (openSearchDoc, error) {
+	metadata, err := json.Marshal(v.Metadata)
+	if err != nil {
+		return openSearchDoc{}, err
+	}
+	return openSearchDoc{ChunkID: v.Id, Content: v.Content, Metadata: string(metadata), Meta: metaPairs(v.Metadata), Vector: v.Embedding}, nil
+}
+
+func (d openSearchDoc) vectorData() vector.VectorData {
+	metadata := map[string]string{}
+	if d.Metadata != "" {
+		// written by toOpenSearchDoc; a foreign document just comes back without metadata
+		_ = json.Unmarshal([]byte(d.Metadata), &metadata)
+	}
+	return vector.VectorData{Id: d.ChunkID, Content: d.Content, Embedding: d.Vector, Metadata: metadata}
+}
+
+// openSearchError maps a failed request onto the shared sentinel errors where one applies.
+func openSearchError(status int, body []byte) error {
+	msg := strings.TrimSpace(string(body))
+	switch {
+	case status == http.StatusTooManyRequests:
+		return fmt.Errorf("%w: opensearch returned status %d: %s", vector.ErrRateLimited, status, msg)
+	case status == http.StatusNotFound:
+		return fmt.Errorf("opensearch: %w", vector.ErrNotFound)
+	case strings.Contains(msg, "dimension"):
+		return fmt.Errorf("%w: %s", vector.ErrDimensionMismatch, msg)
+	}
+	return fmt.Errorf("opensearch returned status %d: %s", status, msg)
+}
+
+// do sends a request with body encoded as JSON, if not nil, and decodes the response into
+// out, if not nil. A []byte body is sent as it is, as newline-delimited JSON.
+func (c *openSearchClient) do(ctx context.Context, method, path string, body, out any) error {
+	var reader io.Reader
+	contentType := "application/json"
+	switch b := body.(type) {
+	case nil:
+	case []byte:
+		reader = bytes.NewReader(b)
+		contentType = "application/x-ndjson"
+	default:
+		data, err := json.Marshal(b)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	if reader != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("opensearch request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	respBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read opensearch response: %w", err)
+	}
+	if resp.StatusCode >= 300 {
+		return openSearchError(resp.StatusCode, respBytes)
+	}
+	if out == nil || len(respBytes) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(respBytes, out); err != nil {
+		return fmt.Errorf("failed to decode opensearch response: %w", err)
+	}
+	return nil
+}
+
+// index functions
+
+// createIndex creates an index for chunks with a k-NN vector field, an HNSW graph of the
+// Lucene engine compared by cosine similarity, which supports filtering during the search.
+func (c *openSearchClient) createIndex(ctx context.Context, index string, dimension int) error {
+	return c.do(ctx, http.MethodPut, "/"+url.PathEscape(index), map[string]any{
+		"settings": map[string]any{"index": map[string]any{"knn": true}},
+		"mappings": map[string]any{
+			"dynamic": "strict",
+			"properties": map[string]any{
+				"chunk_id": map[string]any{"type": "keyword"},
+				"content":  map[string]any{"type": "text"},
+				"metadata": map[string]any{"type": "keyword", "index": false, "doc_values": false},
+				"meta":     map[string]any{"type": "keyword"},
+				"vector": map[string]any{
+					"type":      "knn_vector",
+					"dimension": dimension,
+					"method":    map[string]any{"name": "hnsw", "engine": "lucene", "space_type": "cosinesimil"},
+				},
+			},
+		},
+	}, nil)
+}
+
+func (c *openSearchClient) deleteIndex(ctx context.Context, index string) error {
+	return c.do(ctx, http.MethodDelete, "/"+url.PathEscape(index), nil, nil)
+}
+
+// indexes returns the names of the indexes matching pattern.
+func (c *openSearchClient) indexes(ctx context.Context, pattern string) ([]string, error) {
+	var resp []struct {
+		Index string `json:"index"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/_cat/indices/"+url.PathEscape(pattern)+"?format=json&h=index", nil, &resp); err != nil {
+		return nil, err
+	}
+	out := make([]string, 0, len(resp))
+	for _, r := range resp {
+		out = append(out, r.Index)
+	}
+	return out, nil
+}
+
+// aliases returns the aliases matching pattern with the index each points to.
+func (c *openSearchClient) aliases(ctx context.Context, pattern string) (map[string]string, error) {
+	var resp map[string]struct {
+		Aliases map[string]any `json:"aliases"`
+	}
+	err := c.do(ctx, http.MethodGet, "/_alias/"+url.PathEscape(pattern), nil, &resp)
+	if err != nil {
+		// no alias matching the pattern is a 404
+		if errors.Is(err, vector.ErrNotFound) {
+			return map[string]string{}, nil
+		}
+		return nil, err
+	}
+	out := map[string]string{}
+	for index, r := range resp {
+		for alias := range r.Aliases {
+			out[alias] = index
+		}
+	}
+	return out, nil
+}
+
+// setAlias points alias at index, removing it from the index it pointed to, if any, in the
+// same atomic request.
+func (c *openSearchClient) setAlias(ctx context.Context, alias, index, previous string) error {
+	var actions []map[string]any
+	if previous != "" {
+		actions = append(actions, map[string]any{"remove": map[string]any{"index": previous, "alias": alias}})
+	}
+	actions = append(actions, map[string]any{"add": map[string]any{"index": index, "alias": alias}})
+	return c.do(ctx, http.MethodPost, "/_aliases", map[string]any{"actions": actions}, nil)
+}
+
+// document functions
+
+// add indexes vs in index, openSearchBatchSize per bulk request, waiting for them to become
+// searchable. Chunks with an ID that is already stored replace it.
+func (c *openSearchClient) add(ctx context.Context, index string, vs []vector.VectorData) error {
+	for start := 0; start < len(vs); start += openSearchBatchSize {
+		batch := vs[start:min(start+openSearchBatchSize, len(vs))]
+		var body bytes.Buffer
+		enc := json.NewEncoder(&body)
+		for _, v := range batch {
+			doc, err := toOpenSearchDoc(v)
+			if err != nil {
+				return err
+			}
+			if err := enc.Encode(map[string]any{"index": map[string]any{"_index": index, "_id": v.Id}}); err != nil {
+				return err
+			}
+			if err := enc.Encode(doc); err != nil {
+				return err
+			}
+		}
+
+		var resp struct {
+			Errors bool `json:"errors"`
+			Items  []map[string]struct {
+				Status int `json:"status"`
+				Error  *struct {
+					Reason string `json:"reason"`
+				} `json:"error"`
+			} `json:"items"`
+		}
+		if err := c.do(ctx, http.MethodPost, "/_bulk?refresh=wait_for", body.Bytes(), &resp); err != nil {
+			return err
+		}
+		if resp.Errors {
+			for _, item := range resp.Items {
+				for _, r := range item {
+					if r.Error != nil {
+						return openSearchError(r.Status, []byte(r.Error.Reason))
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// get returns the chunk with the given ID, vector.ErrNotFound if there is none.
+func (c *openSearchClient) get(ctx context.Context, index, id string) (vector.VectorData, error) {
+	var resp struct {
+		Source openSearchDoc `json:"_source"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/"+url.PathEscape(index)+"/_doc/"+url.PathEscape(id), nil, &resp); err != nil {
+		return vector.VectorData{}, err
+	}
+	return resp.Source.vectorData(), nil
+}
+
+// deleteByQuery deletes the documents of index matching query.
+func (c *openSearchClient) deleteByQuery(ctx context.Context, index string, query map[string]any) error {
+	var resp struct {
+		Failures []any `json:"failures"`
+	}
+	path := "/" + url.PathEscape(index) + "/_delete_by_query?refresh=true&conflicts=proceed"
+	if err := c.do(ctx, http.MethodPost, path, map[string]any{"query": query}, &resp); err != nil {
+		return err
+	}
+	if len(resp.Failures) > 0 {
+		return fmt.Errorf("opensearch failed to delete %d documents", len(resp.Failures))
+	}
+	return nil
+}
+
+// deleteIDs deletes the chunks with the given IDs from index.
+func (c *openSearchClient) deleteIDs(ctx context.Context, index string, ids []string) error {
+	for start := 0; start < len(ids); start += openSearchPageSize {
+		batch := ids[start:min(start+openSearchPageSize, len(ids))]
+		if err := c.deleteByQuery(ctx, index, map[string]any{"ids": map[string]any{"values": batch}}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// openSearchHits is the part of a search response the client reads.
+type openSearchHits struct {
+	Hits struct {
+		Hits []struct {
+			Score  float32       `json:"_score"`
+			Source openSearchDoc `json:"_source"`
+			Sort   []any         `json:"sort"`
+		} `json:"hits"`
+	} `json:"hits"`
+}
+
+// list returns every chunk of index matching the filter clauses, paging through them sorted
+// by chunk ID with search_after, so there is no limit on how many.
+func (c *openSearchClient) list(ctx context.Context, index string, filter []any) ([]vector.VectorData, error) {
+	var out []vector.VectorData
+	var after []any
+	for {
+		body := map[string]any{
+			"size":  openSearchPageSize,
+			"query": map[string]any{"bool": map[string]any{"filter": filter}},
+			"sort":  []any{map[string]any{"chunk_id": "asc"}},
+		}
+		if after != nil {
+			body["search_after"] = after
+		}
+		var resp openSearchHits
+		if err := c.do(ctx, http.MethodPost, "/"+url.PathEscape(index)+"/_search", body, &resp); err != nil {
+			return nil, err
+		}
+		hits := resp.Hits.Hits
+		for _, h := range hits {
+			out = append(out, h.Source.vectorData())
+		}
+		if len(hits) < openSearchPageSize {
+			return out, nil
+		}
+		after = hits[len(hits)-1].Sort
+	}
+}
+
+// knn returns the n chunks of index closest to embedding among those matching the filter
+// clauses, with their cosine similarity; the Lucene engine scores a match (1 + cosine) / 2.
+func (c *openSearchClient) knn(ctx context.Context, index string, embedding []float32, filter []any, n int) ([]vector.VectorData, error) {
+	body := map[string]any{
+		"size":  n,
+		"query": knnQuery(embedding, filter, n),
+	}
+	var resp openSearchHits
+	if err := c.do(ctx, http.MethodPost, "/"+url.PathEscape(index)+"/_search", body, &resp); err != nil {
+		return nil, err
+	}
+	out := make([]vector.VectorData, 0, len(resp.Hits.Hits))
+	for _, h := range resp.Hits.Hits {
+		v := h.Source.vectorData()
+		v.Similarity = 2*h.Score - 1
+		out = append(out, v)
+	}
+	return out, nil
+}
+
+// hybrid returns the n chunks of index best matching both embedding and the query's words
+// (BM25) among those matching the filter clauses. The two scores are min-max normalized and
+// averaged with the weights alpha and 1-alpha by a search pipeline defined in the request.
+func (c *openSearchClient) hybrid(ctx context.Context, index, query string, embedding []float32, filter []any, n int, alpha float64) ([]vector.VectorData, error) {
+	body := map[string]any{
+		"size": n,
+		"query": map[string]any{"hybrid": map[string]any{"queries": []any{
+			knnQuery(embedding, filter, n),
+			map[string]any{"bool": map[string]any{
+				"must":   map[string]any{"match": map[string]any{"content": query}},
+				"filter": filter,
+			}},
+		}}},
+		"search_pipeline": map[string]any{
+			"phase_results_processors": []any{map[string]any{
+				"normalization-processor": map[string]any{
+					"normalization": map[string]any{"technique": "min_max"},
+					"combination": map[string]any{
+						"technique":  "arithmetic_mean",
+						"parameters": map[string]any{"weights": []float64{alpha, 1 - alpha}},
+					},
+				},
+			}},
+		},
+	}
+	var resp openSearchHits
+	if err := c.do(ctx, http.MethodPost, "/"+url.PathEscape(index)+"/_search", body, &resp); err != nil {
+		return nil, err
+	}
+	out := make([]vector.VectorData, 0, len(resp.Hits.Hits))
+	for _, h := range resp.Hits.Hits {
+		v := h.Source.vectorData()
+		v.Similarity = h.Score
+		out = append(out, v)
+	}
+	return out, nil
+}
+
+// knnQuery returns a k-NN query of the vector field, filtered during the search.
+func knnQuery(embedding []float32, filter []any, k int) map[string]any {
+	q := map[string]any{"vector": embedding, "k": k}
+	if len(filter) > 0 {
+		q["filter"] = map[string]any{"bool": map[string]any{"filter": filter}}
+	}
+	return map[string]any{"knn": map[string]any{"vector": q}}
+}
+
+// count returns the number of documents in index.
+func (c *openSearchClient) count(ctx context.Context, index string) (int, error) {
+	var resp struct {
+		Count int `json:"count"`
+	}
+	err := c.do(ctx, http.MethodGet, "/"+url.PathEscape(index)+"/_count", nil, &resp)
+	return resp.Count, err
+}
+
+// openSearchBool returns a query matching the documents with the exact metadata in where.
+func openSearchBool(where map[string]string) map[string]any {
+	return map[string]any{"bool": map[string]any{"filter": openSearchFilter(where)}}
+}
+
+// openSearchFilter turns an exact-match metadata filter into filter clauses on the meta
+// keywords; empty if where is.
+func openSearchFilter(where map[string]string) []any {
+	filter := []any{}
+	for _, pair := range metaPairs(where) {
+		filter = append(filter, map[string]any{"term": map[string]any{"meta": pair}})
+	}
+	return filter
+}
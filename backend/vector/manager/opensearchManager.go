@@ -0,0 +1,646 @@
+package manager
+
+import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"vex-backend/config"
+	"vex-backend/httpclient"
+	"vex-backend/vector"
+	"vex-backend/vector/embed"
+)
+
+// openSearchManager is a Manager storing the chunks in an OpenSearch cluster
+// (VECTOR_BACKEND=opensearch), which scores both the k-NN vectors and the BM25 text of a
+// chunk, so queries can be hybrid. Each collection is an index reached through an alias;
+// Reindex and Import build a new index and move the alias to it. The trash of a collection
+// is an index of its own, shared by all its versions.
+type openSearchManager struct {
+	client   *openSearchClient
+	Embedder embed.Embedder
+	// Config supplies the reloadable settings (dedup threshold, hybrid alpha)
+	Config config.Source
+	// queries caches the embeddings of recent queries
+	queries *queryCache
+	// name is the collection the manager works on, alias the OpenSearch alias pointing to its
+	// live index, index that index, which changes when Reindex or Import move the alias, and
+	// trash the index of its trash
+	name  string
+	alias string
+	index string
+	trash string
+	// reindexing allows one Reindex at a time
+	reindexing sync.Mutex
+	// collections holds the managers of all collections opened so far
+	collections *openSearchCollections
+
+	// mu makes every method atomic with respect to the others within this process, as for
+	// the chromem manager. Methods named ...Locked expect the caller to hold it.
+	mu sync.RWMutex
+}
+
+// NewOpenSearchManager returns a Manager storing vectors in the OpenSearch cluster at
+// OPENSEARCH_URL, creating the indexes of VECTOR_COLLECTION if they don't exist yet.
+func NewOpenSearchManager(cfg config.Source, e embed.Embedder, client httpclient.Doer) (Manager, error) {
+	c := cfg()
+	collections := &openSearchCollections{
+		client: &openSearchClient{
+			baseURL:  strings.TrimRight(c.OpenSearchURL, "/"),
+			username: c.OpenSearchUsername,
+			password: c.OpenSearchPassword,
+			http:     client,
+		},
+		prefix:    c.OpenSearchIndexPrefix,
+		dimension: c.OpenSearchDimension,
+		embedder:  e,
+		config:    cfg,
+		queries:   newQueryCache(cfg),
+		byName:    map[string]*openSearchManager{},
+	}
+	name := c.VectorCollection
+	if name == "" {
+		name = notesCollection
+	}
+
+	collections.mu.Lock()
+	defer collections.mu.Unlock()
+	om, err := collections.openLocked(context.Background(), name, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the %s collection in opensearch: %w", name, err)
+	}
+	return om, nil
+}
+
+// openSearchCollections holds the manager of every collection opened so far, shared by all
+// of them, so that each collection has a single manager and lock.
+type openSearchCollections struct {
+	client    *openSearchClient
+	prefix    string
+	dimension int
+	embedder  embed.Embedder
+	config    config.Source
+	queries   *queryCache
+
+	mu     sync.Mutex
+	byName map[string]*openSearchManager
+}
+
+// aliasOf returns the OpenSearch alias of the named collection. Collection names hold no
+// '.', so the name can be read back from the alias and index names.
+func (c *openSearchCollections) aliasOf(name string) string {
+	return c.prefix + "." + name
+}
+
+// newIndex creates the next index of the named collection.
+func (c *openSearchCollections) newIndex(ctx context.Context, name string) (string, error) {
+	index := c.aliasOf(name) + "." + strconv.FormatInt(time.Now().UnixNano(), 10)
+	if err := c.client.createIndex(ctx, index, c.dimension); err != nil {
+		return "", err
+	}
+	return index, nil
+}
+
+// openLocked returns a manager of the named collection, resolving its alias. With create
+// set, missing indexes are created; otherwise a collection without an alias is reported as
+// vector.ErrNotFound. Indexes of a Reindex or Import that didn't finish, or that were
+// replaced, are deleted. The caller holds c.mu.
+func (c *openSearchCollections) openLocked(ctx context.Context, name string, create bool) (*openSearchManager, error) {
+	alias := c.aliasOf(name)
+	aliases, err := c.client.aliases(ctx, alias)
+	if err != nil {
+		return nil, err
+	}
+	live, exists := aliases[alias]
+
+	indexes, err := c.client.indexes(ctx, alias+".*")
+	if err != nil {
+		return nil, err
+	}
+	trash := alias + ".trash"
+	hasTrash := false
+	for _, index := range indexes {
+		switch {
+		case index == trash:
+			hasTrash = true
+		case index != live && isVersionOf(index, alias, "."):
+			if err := c.client.deleteIndex(ctx, index); err != nil {
+				log.Printf("[openSearchManager] warning: failed to delete unused index %s: %v", index, err)
+			}
+		}
+	}
+
+	if !exists {
+		if !create {
+			return nil, fmt.Errorf("collection %q: %w", name, vector.ErrNotFound)
+		}
+		if live, err = c.newIndex(ctx, name); err != nil {
+			return nil, err
+		}
+		if err := c.client.setAlias(ctx, alias, live, ""); err != nil {
+			return nil, err
+		}
+	}
+	// soft-deleted chunks live in their own index, so no query can match them
+	if !hasTrash {
+		if err := c.client.createIndex(ctx, trash, c.dimension); err != nil {
+			return nil, err
+		}
+	}
+
+	om := &openSearchManager{
+		client:      c.client,
+		Embedder:    c.embedder,
+		Config:      c.config,
+		queries:     c.queries,
+		name:        name,
+		alias:       alias,
+		index:       live,
+		trash:       trash,
+		collections: c,
+	}
+	c.byName[name] = om
+	return om, nil
+}
+
+func (om *openSearchManager) GetDBInstance() any {
+	return om.client
+}
+func (om *openSearchManager) GetEmbedder() embed.Embedder {
+	return om.Embedder
+}
+func (om *openSearchManager) EmbedQuery(ctx context.Context, query string, language string) ([]float32, error) {
+	return om.queries.embed(ctx, om.Embedder, query, language)
+}
+func (om *openSearchManager) QueryCacheStats() QueryCacheStats {
+	return om.queries.stats()
+}
+
+// MemoryUsage reports the vectors as remote: they live in the server.
+func (om *openSearchManager) MemoryUsage(ctx context.Context) (MemoryUsage, error) {
+	return remoteMemoryUsage(ctx)
+}
+
+// softDelete reports whether SOFT_DELETE is currently enabled.
+func (om *openSearchManager) softDelete() bool {
+	return om.Config != nil && om.Config().SoftDelete
+}
+
+// store returns the chunkStore of the manager: its live index and its trash index.
+func (om *openSearchManager) store() chunkStore {
+	return openSearchStore{client: om.client, index: om.index, trash: om.trash}
+}
+
+// openSearchStore is the chunkStore of an OpenSearch index and the index of its trash.
+type openSearchStore struct {
+	client *openSearchClient
+	index  string
+	trash  string
+}
+
+func (s openSearchStore) chunks(ctx context.Context, where map[string]string) ([]vector.VectorData, error) {
+	return s.client.list(ctx, s.index, openSearchFilter(where))
+}
+func (s openSearchStore) nearest(ctx context.Context, embedding []float32, where map[string]string, n int) ([]vector.VectorData, error) {
+	return s.client.knn(ctx, s.index, embedding, openSearchFilter(where), n)
+}
+func (s openSearchStore) put(ctx context.Context, vs []vector.VectorData) error {
+	return s.client.add(ctx, s.index, vs)
+}
+func (s openSearchStore) deleteIDs(ctx context.Context, ids []string) error {
+	return s.client.deleteIDs(ctx, s.index, ids)
+}
+func (s openSearchStore) deleteWhere(ctx context.Context, where map[string]string) error {
+	return s.client.deleteByQuery(ctx, s.index, openSearchBool(where))
+}
+func (s openSearchStore) trashed(ctx context.Context) ([]vector.VectorData, error) {
+	return s.client.list(ctx, s.trash, nil)
+}
+func (s openSearchStore) putTrash(ctx context.Context, vs []vector.VectorData) error {
+	return s.client.add(ctx, s.trash, vs)
+}
+func (s openSearchStore) deleteTrashIDs(ctx context.Context, ids []string) error {
+	return s.client.deleteIDs(ctx, s.trash, ids)
+}
+
+// storage functions
+func (om *openSearchManager) StoreVectorInDB(ctx context.Context, v vector.VectorData) error {
+	return om.StoreVectorsInDB(ctx, []vector.VectorData{v})
+}
+
+// StoreVectorsInDB stores the vectors in pipelined writes, with the same duplicate handling
+// as the chromem manager.
+func (om *openSearchManager) StoreVectorsInDB(ctx context.Context, vs []vector.VectorData) error {
+	om.mu.Lock()
+	defer om.mu.Unlock()
+
+	_, err := om.storeLocked(ctx, vs)
+	return err
+}
+
+// storeLocked is StoreVectorsInDB, also returning the IDs written, so a failed batch can be
+// rolled back.
+func (om *openSearchManager) storeLocked(ctx context.Context, vs []vector.VectorData) ([]string, error) {
+	return storeChunks(ctx, om.store(), om.Embedder, DedupSimilarityThresholdFrom(om.Config()), vs, "openSearchManager")
+}
+func (om *openSearchManager) StoreFileAsVectorsInDB(ctx context.Context, filename string) error {
+	path, metadata, err := fileMetadata(filename)
+	if err != nil {
+		return err
+	}
+
+	vs, err := om.Embedder.EmbedFileToVectorData(ctx, path, metadata)
+	if err != nil {
+		return err
+	}
+	return om.StoreVectorsInDB(ctx, vs)
+}
+
+// ReplaceFileVectorsInDB works like the chromem manager's: the file is embedded first,
+// reusing the embeddings of unchanged chunks, then its stored chunks are swapped for the new
+// ones, and restored if storing fails.
+func (om *openSearchManager) ReplaceFileVectorsInDB(ctx context.Context, filename string) error {
+	path, metadata, err := fileMetadata(filename)
+	if err != nil {
+		return err
+	}
+	reusable, err := om.GetChunksByFile(ctx, path)
+	if err != nil {
+		return err
+	}
+
+	vs, err := om.Embedder.EmbedFileToVectorData(embed.WithPrevious(ctx, reusable), path, metadata)
+	if err != nil {
+		return err
+	}
+
+	om.mu.Lock()
+	defer om.mu.Unlock()
+
+	return replaceFileChunks(ctx, om.store(), path, vs, om.softDelete(), om.storeLocked, "openSearchManager")
+}
+
+// retrieval functions
+func (om *openSearchManager) RetriveVectorByMetadata(ctx context.Context, key string, data string) (vector.VectorData, error) {
+	vs, err := om.GetByMetadata(ctx, map[string]string{key: data})
+	if err != nil {
+		return vector.VectorData{}, err
+	}
+	if len(vs) == 0 {
+		return vector.VectorData{}, fmt.Errorf("no document with metadata %s=%s: %w", key, data, vector.ErrNotFound)
+	}
+	return vs[0], nil
+}
+func (om *openSearchManager) RetriveVectorWithID(ctx context.Context, id string) (vector.VectorData, error) {
+	return om.GetByID(ctx, id)
+}
+func (om *openSearchManager) GetByID(ctx context.Context, id string) (vector.VectorData, error) {
+	om.mu.RLock()
+	defer om.mu.RUnlock()
+
+	return om.client.get(ctx, om.index, id)
+}
+func (om *openSearchManager) GetByMetadata(ctx context.Context, where map[string]string) ([]vector.VectorData, error) {
+	om.mu.RLock()
+	defer om.mu.RUnlock()
+
+	return om.getByMetadataLocked(ctx, where)
+}
+
+func (om *openSearchManager) getByMetadataLocked(ctx context.Context, where map[string]string) ([]vector.VectorData, error) {
+	return listChunks(ctx, om.store(), where)
+}
+func (om *openSearchManager) GetChunksByFile(ctx context.Context, path string) ([]vector.VectorData, error) {
+	return om.GetByMetadata(ctx, map[string]string{"filepath": path})
+}
+func (om *openSearchManager) RetriveNVectorsByQuery(ctx context.Context, query string, n int) ([]vector.VectorData, error) {
+	return om.RetriveNVectorsByQueryWithFilter(ctx, query, n, nil)
+}
+
+// RetriveNVectorsByQueryWithFilter searches by the query's embedding, combined with a BM25
+// search of the chunks' content when OPENSEARCH_HYBRID_ALPHA is below 1.
+func (om *openSearchManager) RetriveNVectorsByQueryWithFilter(ctx context.Context, query string, n int, where map[string]string) ([]vector.VectorData, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("n must be > 0")
+	}
+	if query == "" {
+		return nil, errors.New("query failed: query is empty")
+	}
+	// an empty collection fails without spending an embedding on the query
+	if count, err := om.Count(ctx); err == nil && count == 0 {
+		return nil, vector.ErrEmptyCollection
+	}
+
+	embedding, err := om.EmbedQuery(ctx, query, "")
+	if err != nil {
+		return nil, fmt.Errorf("query failed: couldn't create embedding of query: %w", err)
+	}
+	alpha := om.Config().OpenSearchHybridAlpha
+	if alpha >= 1 {
+		return om.RetriveNVectorsByEmbedding(ctx, embedding, n, where)
+	}
+
+	om.mu.RLock()
+	defer om.mu.RUnlock()
+	results, err := om.client.hybrid(ctx, om.index, query, embedding, openSearchFilter(where), n, alpha)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	return results, nil
+}
+func (om *openSearchManager) RetriveNVectorsByEmbedding(ctx context.Context, embedding []float32, n int, where map[string]string) ([]vector.VectorData, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("n must be > 0")
+	}
+	om.mu.RLock()
+	defer om.mu.RUnlock()
+
+	results, err := om.client.knn(ctx, om.index, embedding, openSearchFilter(where), n)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	// only an empty result is worth telling an empty collection apart
+	if len(results) == 0 {
+		if count, err := om.client.count(ctx, om.index); err == nil && count == 0 {
+			return nil, vector.ErrEmptyCollection
+		}
+	}
+	return results, nil
+}
+func (om *openSearchManager) RetriveNVectorsByQueryRanked(ctx context.Context, query string, n int, where map[string]string, rank RankOptions) ([]vector.VectorData, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("n must be > 0")
+	}
+	if rank.RecencyWeight <= 0 {
+		return om.RetriveNVectorsByQueryWithFilter(ctx, query, n, where)
+	}
+
+	candidates, err := om.RetriveNVectorsByQueryWithFilter(ctx, query, n*rankCandidateFactor, where)
+	if err != nil {
+		return nil, err
+	}
+	return rankByRecency(candidates, n, rank, time.Now()), nil
+}
+
+// deletion functions
+func (om *openSearchManager) DeleteVectorWithID(ctx context.Context, id string) error {
+	om.mu.Lock()
+	defer om.mu.Unlock()
+
+	return om.client.deleteIDs(ctx, om.index, []string{id})
+}
+func (om *openSearchManager) DeleteVectorsWithMetaData(ctx context.Context, key string, data string) error {
+	om.mu.Lock()
+	defer om.mu.Unlock()
+
+	if om.softDelete() {
+		_, err := om.trashLocked(ctx, key, data)
+		return err
+	}
+	return om.client.deleteByQuery(ctx, om.index, openSearchBool(map[string]string{key: data}))
+}
+
+// trash functions
+func (om *openSearchManager) TrashVectorsWithMetaData(ctx context.Context, key string, data string) (int, error) {
+	om.mu.Lock()
+	defer om.mu.Unlock()
+
+	return om.trashLocked(ctx, key, data)
+}
+func (om *openSearchManager) trashLocked(ctx context.Context, key string, data string) (int, error) {
+	return trashWhere(ctx, om.store(), key, data)
+}
+func (om *openSearchManager) ListTrash(ctx context.Context) ([]vector.VectorData, error) {
+	om.mu.RLock()
+	defer om.mu.RUnlock()
+
+	return om.listTrashLocked(ctx)
+}
+func (om *openSearchManager) listTrashLocked(ctx context.Context) ([]vector.VectorData, error) {
+	return listTrash(ctx, om.store())
+}
+func (om *openSearchManager) RestoreFromTrash(ctx context.Context, path string, deletedAt string) (int, error) {
+	om.mu.Lock()
+	defer om.mu.Unlock()
+
+	return restoreFromTrash(ctx, om.store(), path, deletedAt)
+}
+func (om *openSearchManager) PurgeTrash(ctx context.Context, before time.Time) (int, error) {
+	om.mu.Lock()
+	defer om.mu.Unlock()
+
+	return purgeTrash(ctx, om.store(), before)
+}
+
+// snapshot functions
+
+// Export writes the chunks and the trash in the snapshot format of the in-memory manager;
+// snapshots of the chromem store can't be imported here, nor the other way round.
+func (om *openSearchManager) Export(ctx context.Context, w io.Writer) error {
+	om.mu.RLock()
+	defer om.mu.RUnlock()
+
+	return exportSnapshot(ctx, om.store(), w)
+}
+
+// Import fills a new index with the snapshot's chunks and moves the alias to it, so queries
+// see the previous chunks until the import is complete; the trash is replaced afterwards.
+func (om *openSearchManager) Import(ctx context.Context, r io.ReadSeeker) error {
+	var snap memorySnapshot
+	if err := gob.NewDecoder(r).Decode(&snap); err != nil {
+		return fmt.Errorf("failed to read snapshot: %w", err)
+	}
+
+	om.mu.Lock()
+	defer om.mu.Unlock()
+
+	index, err := om.collections.newIndex(ctx, om.name)
+	if err != nil {
+		return err
+	}
+	docs := make([]vector.VectorData, 0, len(snap.Docs))
+	for _, v := range snap.Docs {
+		docs = append(docs, v)
+	}
+	if err := om.client.add(ctx, index, docs); err != nil {
+		if derr := om.client.deleteIndex(ctx, index); derr != nil {
+			log.Printf("[openSearchManager] warning: failed to drop import index %s: %v", index, derr)
+		}
+		return fmt.Errorf("failed to import snapshot: %w", err)
+	}
+	if err := om.swapLocked(ctx, index); err != nil {
+		return err
+	}
+
+	current, err := om.client.list(ctx, om.trash, nil)
+	if err != nil {
+		return err
+	}
+	ids := make([]string, 0, len(current))
+	for _, v := range current {
+		ids = append(ids, v.Id)
+	}
+	if err := om.client.deleteIDs(ctx, om.trash, ids); err != nil {
+		return err
+	}
+	trashed := make([]vector.VectorData, 0, len(snap.Trash))
+	for _, v := range snap.Trash {
+		trashed = append(trashed, v)
+	}
+	return om.client.add(ctx, om.trash, trashed)
+}
+
+// Reindex builds the new index alongside the live one, through a manager sharing the client
+// and the trash, and swaps it in by moving the alias and dropping the previous index.
+func (om *openSearchManager) Reindex(ctx context.Context, build func(staging Manager) error) error {
+	om.reindexing.Lock()
+	defer om.reindexing.Unlock()
+
+	index, err := om.collections.newIndex(ctx, om.name)
+	if err != nil {
+		return err
+	}
+	swapped := false
+	defer func() {
+		if !swapped {
+			if err := om.client.deleteIndex(context.Background(), index); err != nil {
+				log.Printf("[openSearchManager] warning: failed to drop reindex index %s: %v", index, err)
+			}
+		}
+	}()
+
+	om.mu.RLock()
+	live, err := om.client.list(ctx, om.index, nil)
+	om.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	before := fileFingerprints(live)
+
+	staging := &openSearchManager{
+		client:      om.client,
+		Embedder:    om.Embedder,
+		Config:      om.Config,
+		queries:     newQueryCache(om.Config),
+		name:        om.name,
+		alias:       om.alias,
+		index:       index,
+		trash:       om.trash,
+		collections: om.collections,
+	}
+	if err := build(staging); err != nil {
+		return err
+	}
+
+	om.mu.Lock()
+	defer om.mu.Unlock()
+
+	if err := carryOver(ctx, om.store(), staging.store(), before, "openSearchManager"); err != nil {
+		return err
+	}
+
+	if err := om.swapLocked(ctx, index); err != nil {
+		return err
+	}
+	swapped = true
+	log.Printf("[openSearchManager] reindex: swapped in %s", index)
+	return nil
+}
+
+// swapLocked moves the alias to index and deletes the previous index. A crash in between
+// leaves the previous index behind, and the next start deletes it.
+func (om *openSearchManager) swapLocked(ctx context.Context, index string) error {
+	if err := om.client.setAlias(ctx, om.alias, index, om.index); err != nil {
+		return fmt.Errorf("failed to point %s at %s: %w", om.alias, index, err)
+	}
+	old := om.index
+	om.index = index
+	if err := om.client.deleteIndex(ctx, old); err != nil {
+		log.Printf("[openSearchManager] warning: failed to drop previous index %s: %v", old, err)
+	}
+	return nil
+}
+
+// collection functions
+func (om *openSearchManager) CollectionName() string {
+	return om.name
+}
+func (om *openSearchManager) Collection(name string) (Manager, error) {
+	c := om.collections
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if m, ok := c.byName[name]; ok {
+		return m, nil
+	}
+	return c.openLocked(context.Background(), name, false)
+}
+func (om *openSearchManager) CreateCollection(ctx context.Context, name string) error {
+	if err := config.CheckCollectionName(name); err != nil {
+		return err
+	}
+	c := om.collections
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	alias := c.aliasOf(name)
+	aliases, err := c.client.aliases(ctx, alias)
+	if err != nil {
+		return err
+	}
+	if _, ok := aliases[alias]; ok {
+		return fmt.Errorf("collection %q: %w", name, vector.ErrCollectionExists)
+	}
+	_, err = c.openLocked(ctx, name, true)
+	return err
+}
+
+func (om *openSearchManager) Collections(ctx context.Context) ([]CollectionInfo, error) {
+	prefix := om.collections.prefix + "."
+	aliases, err := om.client.aliases(ctx, prefix+"*")
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for alias := range aliases {
+		if name, ok := strings.CutPrefix(alias, prefix); ok && !strings.Contains(name, ".") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	out := make([]CollectionInfo, 0, len(names))
+	for _, name := range names {
+		m, err := om.Collection(name)
+		if err != nil {
+			return nil, err
+		}
+		n, err := m.Count(ctx)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, CollectionInfo{Name: name, Chunks: n})
+	}
+	return out, nil
+}
+
+// maintenance functions
+func (om *openSearchManager) Count(ctx context.Context) (int, error) {
+	om.mu.RLock()
+	defer om.mu.RUnlock()
+
+	return om.client.count(ctx, om.index)
+}
+func (om *openSearchManager) DeduplicateVectors(ctx context.Context, threshold float32) (int, error) {
+	om.mu.Lock()
+	defer om.mu.Unlock()
+
+	return deduplicate(ctx, om.store(), threshold)
+}
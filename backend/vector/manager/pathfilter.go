@@ -0,0 +1,109 @@
+package manager
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"vex-backend/vector"
+)
+
+// PathFilter restricts retrieval to notes whose path starts with Prefix and matches Glob.
+// Relative prefixes and globs are resolved against Root, usually the notes clone; absolute
+// ones are matched against the full path. In globs, * and ? match within one path segment
+// and ** across segments, so "Academia/**" matches every note under Academia.
+type PathFilter struct {
+	Root   string
+	Prefix string
+	Glob   string
+}
+
+// IsZero reports whether the filter lets every path through.
+func (f PathFilter) IsZero() bool {
+	return f.Prefix == "" && f.Glob == ""
+}
+
+// Matcher compiles the filter into a predicate on chunk file paths.
+func (f PathFilter) Matcher() (func(path string) bool, error) {
+	var glob *regexp.Regexp
+	if f.Glob != "" {
+		re, err := globRegexp(f.Glob)
+		if err != nil {
+			return nil, fmt.Errorf("invalid path glob %q: %w", f.Glob, err)
+		}
+		glob = re
+	}
+	// chunks carry absolute paths
+	root := ""
+	if f.Root != "" {
+		abs, err := filepath.Abs(filepath.Clean(f.Root))
+		if err != nil {
+			return nil, err
+		}
+		root = abs
+	}
+
+	// relative returns path relative to root, or false if it lies outside of it
+	relative := func(path string) (string, bool) {
+		if root == "" {
+			return path, true
+		}
+		return strings.CutPrefix(path, root+string(filepath.Separator))
+	}
+	matches := func(pattern, path string, match func(string) bool) bool {
+		if filepath.IsAbs(pattern) {
+			return match(path)
+		}
+		rel, ok := relative(path)
+		return ok && match(rel)
+	}
+
+	return func(path string) bool {
+		if path == "" {
+			return false
+		}
+		if f.Prefix != "" && !matches(f.Prefix, path, func(p string) bool { return strings.HasPrefix(p, f.Prefix) }) {
+			return false
+		}
+		if glob != nil && !matches(f.Glob, path, glob.MatchString) {
+			return false
+		}
+		return true
+	}, nil
+}
+
+// globRegexp translates a glob with *, ? and ** into an anchored regexp.
+func globRegexp(glob string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; {
+		case c == '*' && i+1 < len(glob) && glob[i+1] == '*':
+			i++
+			if i+1 < len(glob) && glob[i+1] == '/' {
+				// "**/" also matches no directory at all
+				i++
+				b.WriteString("(?:.*/)?")
+			} else {
+				b.WriteString(".*")
+			}
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+// WithPathFilter wraps m so that retrieval only returns chunks of files matching f.
+func WithPathFilter(m Manager, f PathFilter) (Manager, error) {
+	match, err := f.Matcher()
+	if err != nil {
+		return nil, err
+	}
+	return WithPostFilter(m, func(v vector.VectorData) bool { return match(v.Metadata["filepath"]) }), nil
+}
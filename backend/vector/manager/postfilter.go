@@ -0,0 +1,126 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"time"
+	"vex-backend/vector"
+)
+
+// postFilterCandidateFactor is how many more candidates than requested are fetched before
+// post-filtering, since chromem can't filter on anything but exact metadata values
+const postFilterCandidateFactor = 4
+
+// postFiltered restricts every read of the wrapped Manager to the chunks accepted by accept.
+// Similarity queries fetch a larger candidate pool and filter it, widening the pool until
+// enough chunks matched or the whole collection was searched.
+type postFiltered struct {
+	Manager
+	accept func(v vector.VectorData) bool
+}
+
+// WithPostFilter wraps m so that retrieval only returns chunks for which keep returns true,
+// for filters chromem's exact-match metadata filters can't express.
+func WithPostFilter(m Manager, keep func(v vector.VectorData) bool) Manager {
+	return postFiltered{Manager: m, accept: keep}
+}
+
+func (p postFiltered) keep(vs []vector.VectorData) []vector.VectorData {
+	out := make([]vector.VectorData, 0, len(vs))
+	for _, v := range vs {
+		if p.accept(v) {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func (p postFiltered) RetriveVectorByMetadata(ctx context.Context, key string, data string) (vector.VectorData, error) {
+	vs, err := p.GetByMetadata(ctx, map[string]string{key: data})
+	if err != nil {
+		return vector.VectorData{}, err
+	}
+	if len(vs) == 0 {
+		return vector.VectorData{}, fmt.Errorf("no document with metadata %s=%s: %w", key, data, vector.ErrNotFound)
+	}
+	return vs[0], nil
+}
+func (p postFiltered) RetriveVectorWithID(ctx context.Context, id string) (vector.VectorData, error) {
+	return p.GetByID(ctx, id)
+}
+func (p postFiltered) GetByID(ctx context.Context, id string) (vector.VectorData, error) {
+	v, err := p.Manager.GetByID(ctx, id)
+	if err != nil {
+		return v, err
+	}
+	if !p.accept(v) {
+		return vector.VectorData{}, fmt.Errorf("document %q: %w", id, vector.ErrNotFound)
+	}
+	return v, nil
+}
+func (p postFiltered) GetByMetadata(ctx context.Context, where map[string]string) ([]vector.VectorData, error) {
+	vs, err := p.Manager.GetByMetadata(ctx, where)
+	return p.keep(vs), err
+}
+func (p postFiltered) GetChunksByFile(ctx context.Context, path string) ([]vector.VectorData, error) {
+	vs, err := p.Manager.GetChunksByFile(ctx, path)
+	return p.keep(vs), err
+}
+func (p postFiltered) RetriveNVectorsByQuery(ctx context.Context, query string, n int) ([]vector.VectorData, error) {
+	return p.RetriveNVectorsByQueryWithFilter(ctx, query, n, nil)
+}
+func (p postFiltered) RetriveNVectorsByQueryWithFilter(ctx context.Context, query string, n int, where map[string]string) ([]vector.VectorData, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("n must be > 0")
+	}
+	// embedded once, since the pool may be widened several times
+	embedding, err := p.EmbedQuery(ctx, query, "")
+	if err != nil {
+		return nil, err
+	}
+	return p.RetriveNVectorsByEmbedding(ctx, embedding, n, where)
+}
+func (p postFiltered) RetriveNVectorsByEmbedding(ctx context.Context, embedding []float32, n int, where map[string]string) ([]vector.VectorData, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("n must be > 0")
+	}
+	total, err := p.Count(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if total == 0 {
+		return p.Manager.RetriveNVectorsByEmbedding(ctx, embedding, n, where)
+	}
+
+	pool := n * postFilterCandidateFactor
+	for {
+		if pool > total {
+			pool = total
+		}
+		results, err := p.Manager.RetriveNVectorsByEmbedding(ctx, embedding, pool, where)
+		if err != nil {
+			return nil, err
+		}
+		matched := p.keep(results)
+		if len(matched) >= n || pool >= total {
+			if len(matched) > n {
+				matched = matched[:n]
+			}
+			return matched, nil
+		}
+		pool *= 2
+	}
+}
+func (p postFiltered) RetriveNVectorsByQueryRanked(ctx context.Context, query string, n int, where map[string]string, rank RankOptions) ([]vector.VectorData, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("n must be > 0")
+	}
+	if rank.RecencyWeight <= 0 {
+		return p.RetriveNVectorsByQueryWithFilter(ctx, query, n, where)
+	}
+	candidates, err := p.RetriveNVectorsByQueryWithFilter(ctx, query, n*rankCandidateFactor, where)
+	if err != nil {
+		return nil, err
+	}
+	return rankByRecency(candidates, n, rank, time.Now()), nil
+}
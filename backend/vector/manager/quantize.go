@@ -0,0 +1,259 @@
+package manager
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"sync"
+	"vex-backend/vector"
+)
+
+// Quantization modes of the in-memory store (VECTOR_QUANTIZATION)
+const (
+	QuantizeInt8   = "int8"
+	QuantizeBinary = "binary"
+)
+
+// quantizedVector is an embedding compressed to one byte (int8) or one bit (binary) per
+// dimension. offset locates the full-precision vector in the store's vectorFile, if it
+// keeps one (-1 otherwise).
+type quantizedVector struct {
+	dims int
+	// int8: the values scaled by 127/max|v|, and the inverse of that scale
+	codes []int8
+	scale float32
+	// binary: the sign bits, 1 for non-negative values
+	signs []uint64
+	// norm is the length of the vector the codes stand for
+	norm   float32
+	offset int64
+}
+
+// quantize compresses v with mode.
+func quantize(mode string, v []float32) quantizedVector {
+	q := quantizedVector{dims: len(v), offset: -1}
+	switch mode {
+	case QuantizeBinary:
+		q.signs = make([]uint64, (len(v)+63)/64)
+		for i, x := range v {
+			if x >= 0 {
+				q.signs[i/64] |= 1 << (i % 64)
+			}
+		}
+		q.norm = float32(math.Sqrt(float64(len(v))))
+	default:
+		var peak float32
+		for _, x := range v {
+			peak = max(peak, float32(math.Abs(float64(x))))
+		}
+		q.codes = make([]int8, len(v))
+		if peak == 0 {
+			return q
+		}
+		q.scale = peak / 127
+		var norm float64
+		for i, x := range v {
+			c := int8(math.Round(float64(x / q.scale)))
+			q.codes[i] = c
+			norm += float64(c) * float64(c)
+		}
+		q.norm = float32(math.Sqrt(norm)) * q.scale
+	}
+	return q
+}
+
+// dequantize returns the vector the codes stand for: the int8 values scaled back, or the
+// signs as a unit vector.
+func (q quantizedVector) dequantize() []float32 {
+	out := make([]float32, q.dims)
+	if q.signs != nil {
+		unit := 1 / float32(math.Sqrt(float64(q.dims)))
+		for i := range out {
+			out[i] = -unit
+			if q.signs[i/64]&(1<<(i%64)) != 0 {
+				out[i] = unit
+			}
+		}
+		return out
+	}
+	for i, c := range q.codes {
+		out[i] = float32(c) * q.scale
+	}
+	return out
+}
+
+// similarity estimates the cosine similarity of the original vector with query, which keeps
+// its full precision.
+func (q quantizedVector) similarity(query []float32) float32 {
+	if len(query) != q.dims || q.norm == 0 {
+		return 0
+	}
+	var dot, qn float64
+	for i, x := range query {
+		qn += float64(x) * float64(x)
+		if q.signs != nil {
+			if q.signs[i/64]&(1<<(i%64)) != 0 {
+				dot += float64(x)
+			} else {
+				dot -= float64(x)
+			}
+		} else {
+			dot += float64(q.codes[i]) * float64(x)
+		}
+	}
+	if qn == 0 {
+		return 0
+	}
+	if q.signs == nil {
+		dot *= float64(q.scale)
+	}
+	return float32(dot / (float64(q.norm) * math.Sqrt(qn)))
+}
+
+// vectorFile keeps full-precision vectors on disk for rescoring quantized search results.
+// It is append-only: a vector written again, or removed, leaves its old bytes behind until
+// the file is recreated when the store is opened the next time.
+type vectorFile struct {
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// createVectorFile creates (or truncates) the vector file at path.
+func createVectorFile(path string) (*vectorFile, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the full-precision vector file: %w", err)
+	}
+	return &vectorFile{f: f}, nil
+}
+
+// append writes v and returns its offset.
+func (vf *vectorFile) append(v []float32) (int64, error) {
+	buf := make([]byte, 4*len(v))
+	for i, x := range v {
+		binary.LittleEndian.PutUint32(buf[4*i:], math.Float32bits(x))
+	}
+
+	vf.mu.Lock()
+	defer vf.mu.Unlock()
+
+	offset := vf.size
+	if _, err := vf.f.WriteAt(buf, offset); err != nil {
+		return 0, fmt.Errorf("failed to write a full-precision vector: %w", err)
+	}
+	vf.size += int64(len(buf))
+	return offset, nil
+}
+
+// read returns the vector of dims values at offset.
+func (vf *vectorFile) read(offset int64, dims int) ([]float32, error) {
+	buf := make([]byte, 4*dims)
+	if _, err := vf.f.ReadAt(buf, offset); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to read a full-precision vector: %w", err)
+	}
+	out := make([]float32, dims)
+	for i := range out {
+		out[i] = math.Float32frombits(binary.LittleEndian.Uint32(buf[4*i:]))
+	}
+	return out, nil
+}
+
+// quantized reports whether the manager keeps its chunks' embeddings quantized.
+func (mm *memoryManager) quantized() bool {
+	return mm.Quantization == QuantizeInt8 || mm.Quantization == QuantizeBinary
+}
+
+// keepLocked returns v as it is held in mm.docs: unchanged, or, with quantization, without
+// its embedding, which goes to mm.vectors (and the vector file, if any) instead. If the
+// vector file can't be written, v is kept unquantized. Callers must hold mm.mu.
+func (mm *memoryManager) keepLocked(v vector.VectorData) vector.VectorData {
+	if !mm.quantized() || len(v.Embedding) == 0 {
+		return v
+	}
+	q := quantize(mm.Quantization, v.Embedding)
+	if full := mm.collections.full; full != nil {
+		offset, err := full.append(v.Embedding)
+		if err != nil {
+			log.Printf("[memoryManager] keeping %s unquantized: %v", v.Id, err)
+			return v
+		}
+		q.offset = offset
+	}
+	mm.vectors[v.Id] = q
+	v.Embedding = nil
+	return v
+}
+
+// embeddingLocked returns the embedding of a chunk held in mm.docs: the full-precision
+// vector if it is kept, else the dequantized one. Callers must hold mm.mu (for reading).
+func (mm *memoryManager) embeddingLocked(v vector.VectorData) []float32 {
+	q, ok := mm.vectors[v.Id]
+	if len(v.Embedding) > 0 || !ok {
+		return v.Embedding
+	}
+	if full := mm.collections.full; full != nil && q.offset >= 0 {
+		if e, err := full.read(q.offset, q.dims); err == nil {
+			return e
+		}
+	}
+	return q.dequantize()
+}
+
+// withEmbeddingLocked returns v with its embedding, for handing it out. Callers must hold
+// mm.mu (for reading).
+func (mm *memoryManager) withEmbeddingLocked(v vector.VectorData) vector.VectorData {
+	v.Embedding = mm.embeddingLocked(v)
+	return v
+}
+
+// dimsLocked returns the length of the embedding of a chunk held in mm.docs. Callers must
+// hold mm.mu (for reading).
+func (mm *memoryManager) dimsLocked(v vector.VectorData) int {
+	if q, ok := mm.vectors[v.Id]; ok && len(v.Embedding) == 0 {
+		return q.dims
+	}
+	return len(v.Embedding)
+}
+
+// similarityLocked returns the cosine similarity of a chunk held in mm.docs with e,
+// estimated from the codes if it is quantized. Callers must hold mm.mu (for reading).
+func (mm *memoryManager) similarityLocked(v vector.VectorData, e []float32) float32 {
+	if q, ok := mm.vectors[v.Id]; ok && len(v.Embedding) == 0 {
+		return q.similarity(e)
+	}
+	return cosineSimilarity(e, v.Embedding)
+}
+
+// rescoreLocked recomputes the similarity of the best n*RescoreFactor of the results, ranked
+// by their estimated similarity to e, from the full-precision vectors, and ranks those again.
+// Without a vector file the results are returned as they are. Callers must hold mm.mu (for
+// reading).
+func (mm *memoryManager) rescoreLocked(ranked []vector.VectorData, e []float32, n int) []vector.VectorData {
+	full := mm.collections.full
+	if full == nil || mm.RescoreFactor <= 0 {
+		return ranked
+	}
+	top := ranked[:min(len(ranked), n*mm.RescoreFactor)]
+	for i, v := range top {
+		q, ok := mm.vectors[v.Id]
+		if !ok || q.offset < 0 {
+			continue
+		}
+		if exact, err := full.read(q.offset, q.dims); err == nil {
+			top[i].Similarity = cosineSimilarity(e, exact)
+		}
+	}
+	sort.SliceStable(top, func(i, j int) bool { return top[i].Similarity > top[j].Similarity })
+	return top
+}
+
+// dropLocked removes a chunk from mm.docs. Callers must hold mm.mu.
+func (mm *memoryManager) dropLocked(id string) {
+	delete(mm.docs, id)
+	delete(mm.vectors, id)
+}
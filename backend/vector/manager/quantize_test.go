@@ -0,0 +1,110 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+	"vex-backend/config"
+	"vex-backend/vector"
+)
+
+// clusteredVectors returns n vectors of dims values around clusters random centers, the way
+// the embeddings of notes on a few topics lie, from a fixed seed.
+func clusteredVectors(rng *rand.Rand, centers [][]float32, n int, spread float64) [][]float32 {
+	out := make([][]float32, n)
+	for i := range out {
+		c := centers[rng.Intn(len(centers))]
+		v := make([]float32, len(c))
+		for j := range v {
+			v[j] = c[j] + float32(rng.NormFloat64()*spread)
+		}
+		out[i] = v
+	}
+	return out
+}
+
+// exactTopK returns the IDs of the k documents most similar to query by float32 cosine
+// similarity.
+func exactTopK(docs [][]float32, query []float32, k int) map[string]bool {
+	ids := make([]int, len(docs))
+	scores := make([]float32, len(docs))
+	for i, d := range docs {
+		ids[i] = i
+		scores[i] = cosineSimilarity(query, d)
+	}
+	sort.SliceStable(ids, func(a, b int) bool { return scores[ids[a]] > scores[ids[b]] })
+	top := map[string]bool{}
+	for _, i := range ids[:k] {
+		top[fmt.Sprintf("doc-%d", i)] = true
+	}
+	return top
+}
+
+// TestQuantizationRecall checks the share of the float32 top 10 that quantized searches
+// still find, through the manager VECTOR_BACKEND=memory builds.
+func TestQuantizationRecall(t *testing.T) {
+	const dims, docCount, queryCount, k = 256, 2000, 50, 10
+
+	rng := rand.New(rand.NewSource(1))
+	centers := clusteredVectors(rng, [][]float32{make([]float32, dims)}, 40, 1)
+	docs := clusteredVectors(rng, centers, docCount, 0.5)
+	queries := clusteredVectors(rng, centers, queryCount, 0.5)
+
+	vs := make([]vector.VectorData, len(docs))
+	for i, d := range docs {
+		vs[i] = vector.VectorData{
+			Id:        fmt.Sprintf("doc-%d", i),
+			Content:   fmt.Sprintf("chunk %d", i),
+			Embedding: d,
+			Metadata:  map[string]string{"filepath": fmt.Sprintf("note-%d.md", i)},
+		}
+	}
+
+	for _, tc := range []struct {
+		mode      string
+		rescore   int
+		minRecall float64
+	}{
+		{QuantizeInt8, 0, 0.95},
+		{QuantizeInt8, 4, 0.99},
+		// binary codes alone rank too coarsely, see the README
+		{QuantizeBinary, 10, 0.95},
+	} {
+		t.Run(fmt.Sprintf("%s rescore %d", tc.mode, tc.rescore), func(t *testing.T) {
+			m, err := NewEphemeralManager(config.Static(&config.EnvConfig{
+				VectorCollection:          notesCollection,
+				VectorStorageFolder:       t.TempDir(),
+				VectorQuantization:        tc.mode,
+				VectorQuantizationRescore: tc.rescore,
+			}), nil)
+			if err != nil {
+				t.Fatalf("NewEphemeralManager: %v", err)
+			}
+			ctx := context.Background()
+			if err := m.StoreVectorsInDB(ctx, vs); err != nil {
+				t.Fatalf("StoreVectorsInDB: %v", err)
+			}
+
+			found := 0
+			for _, q := range queries {
+				want := exactTopK(docs, q, k)
+				got, err := m.RetriveNVectorsByEmbedding(ctx, q, k, nil)
+				if err != nil {
+					t.Fatalf("RetriveNVectorsByEmbedding: %v", err)
+				}
+				for _, v := range got {
+					if want[v.Id] {
+						found++
+					}
+				}
+			}
+			recall := float64(found) / float64(queryCount*k)
+			t.Logf("recall@%d = %.3f", k, recall)
+			if recall < tc.minRecall {
+				t.Errorf("recall@%d = %.3f, want at least %.2f", k, recall, tc.minRecall)
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package manager
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+	"vex-backend/config"
+	"vex-backend/vector/embed"
+)
+
+// QueryCacheStats reports how often query embeddings were served from the cache.
+type QueryCacheStats struct {
+	Size    int     `json:"size"`
+	Hits    int64   `json:"hits"`
+	Misses  int64   `json:"misses"`
+	HitRate float64 `json:"hit_rate"`
+}
+
+// queryCache is an LRU cache of query embeddings keyed by language and query text. The same
+// short queries ("meeting notes", "todo") are asked again and again, and each would otherwise
+// cost an embedding request. Entries expire after QUERY_CACHE_TTL and the least recently used
+// are evicted beyond QUERY_CACHE_SIZE; both are read on every lookup, so they can be reloaded.
+type queryCache struct {
+	cfg config.Source
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	// order holds *queryCacheEntry, most recently used first
+	order  *list.List
+	hits   int64
+	misses int64
+}
+
+type queryCacheEntry struct {
+	key       string
+	embedding []float32
+	expires   time.Time
+}
+
+func newQueryCache(cfg config.Source) *queryCache {
+	return &queryCache{
+		cfg:     cfg,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// embed returns the embedding of query in language from the cache, or embeds it with e and
+// caches it. The returned slice is shared between callers and must not be modified.
+func (qc *queryCache) embed(ctx context.Context, e embed.Embedder, query, language string) ([]float32, error) {
+	c := qc.cfg()
+	if c.QueryCacheSize == 0 || c.QueryCacheTTL == 0 {
+		return embedQuery(ctx, e, query, language)
+	}
+
+	key := language + "\x00" + query
+	if embedding, ok := qc.get(key, time.Now()); ok {
+		return embedding, nil
+	}
+	embedding, err := embedQuery(ctx, e, query, language)
+	if err != nil {
+		return nil, err
+	}
+	qc.put(key, embedding, time.Now().Add(c.QueryCacheTTL), c.QueryCacheSize)
+	return embedding, nil
+}
+
+func (qc *queryCache) get(key string, now time.Time) ([]float32, bool) {
+	qc.mu.Lock()
+	defer qc.mu.Unlock()
+
+	el, ok := qc.entries[key]
+	if ok && now.After(el.Value.(*queryCacheEntry).expires) {
+		qc.remove(el)
+		ok = false
+	}
+	if !ok {
+		qc.misses++
+		return nil, false
+	}
+	qc.hits++
+	qc.order.MoveToFront(el)
+	return el.Value.(*queryCacheEntry).embedding, true
+}
+
+// put caches embedding under key and evicts the least recently used entries beyond size.
+func (qc *queryCache) put(key string, embedding []float32, expires time.Time, size int) {
+	qc.mu.Lock()
+	defer qc.mu.Unlock()
+
+	if el, ok := qc.entries[key]; ok {
+		el.Value = &queryCacheEntry{key: key, embedding: embedding, expires: expires}
+		qc.order.MoveToFront(el)
+	} else {
+		qc.entries[key] = qc.order.PushFront(&queryCacheEntry{key: key, embedding: embedding, expires: expires})
+	}
+	for qc.order.Len() > size {
+		qc.remove(qc.order.Back())
+	}
+}
+
+// remove drops el from the cache. Callers must hold qc.mu.
+func (qc *queryCache) remove(el *list.Element) {
+	qc.order.Remove(el)
+	delete(qc.entries, el.Value.(*queryCacheEntry).key)
+}
+
+func (qc *queryCache) stats() QueryCacheStats {
+	qc.mu.Lock()
+	defer qc.mu.Unlock()
+
+	s := QueryCacheStats{Size: qc.order.Len(), Hits: qc.hits, Misses: qc.misses}
+	if total := qc.hits + qc.misses; total > 0 {
+		s.HitRate = float64(qc.hits) / float64(total)
+	}
+	return s
+}
+
+// embedQuery embeds query with the model of language, or with the default model if language
+// is empty or e has no per-language models.
+func embedQuery(ctx context.Context, e embed.Embedder, query, language string) ([]float32, error) {
+	if le, ok := e.(embed.LanguageEmbedder); ok && language != "" {
+		return le.EmbedToVectorForLanguage(ctx, query, language)
+	}
+	return e.EmbedToVector(ctx, query)
+}
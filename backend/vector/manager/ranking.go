@@ -0,0 +1,88 @@
+package manager
+
+import (
+	"math"
+	"sort"
+	"time"
+	"vex-backend/config"
+	"vex-backend/vector"
+)
+
+// rankCandidateFactor is how many more candidates than requested are fetched before re-ranking
+const rankCandidateFactor = 4
+
+// RankOptions controls how query results are re-ranked.
+type RankOptions struct {
+	// RecencyWeight in [0, 1] is the share of the score taken by recency; 0 disables re-ranking
+	RecencyWeight float64
+	// HalfLife is the document age at which its recency score drops to 0.5
+	HalfLife time.Duration
+}
+
+// RankOptionsFrom returns the recency ranking configured in cfg through RECENCY_WEIGHT and
+// RECENCY_HALF_LIFE_DAYS, falling back to a weight of 0.3 and a half-life of 90 days.
+func RankOptionsFrom(cfg *config.EnvConfig) RankOptions {
+	opts := RankOptions{
+		RecencyWeight: 0.3,
+		HalfLife:      90 * 24 * time.Hour,
+	}
+	if cfg == nil {
+		return opts
+	}
+
+	opts.RecencyWeight = cfg.RecencyWeight
+	opts.HalfLife = time.Duration(cfg.RecencyHalfLifeDays * float64(24*time.Hour))
+	return opts
+}
+
+// DefaultRankOptions is RankOptionsFrom for the global configuration.
+func DefaultRankOptions() RankOptions {
+	return RankOptionsFrom(config.Current())
+}
+
+// documentTime returns the best known date of a chunk, preferring the commit date
+// over the file modification time.
+func documentTime(v vector.VectorData) (time.Time, bool) {
+	for _, key := range []string{"commit_date", "mod_time"} {
+		if raw, ok := v.Metadata[key]; ok {
+			if t, err := time.Parse(time.RFC3339, raw); err == nil {
+				return t, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// rankByRecency blends similarity with an exponential recency decay and returns the best n.
+// Chunks without a usable date get a recency score of 0.
+func rankByRecency(vs []vector.VectorData, n int, rank RankOptions, now time.Time) []vector.VectorData {
+	type scored struct {
+		v     vector.VectorData
+		score float64
+	}
+
+	out := make([]scored, 0, len(vs))
+	for _, v := range vs {
+		recency := 0.0
+		if t, ok := documentTime(v); ok && rank.HalfLife > 0 {
+			age := now.Sub(t)
+			if age < 0 {
+				age = 0
+			}
+			recency = math.Pow(0.5, float64(age)/float64(rank.HalfLife))
+		}
+		score := (1-rank.RecencyWeight)*float64(v.Similarity) + rank.RecencyWeight*recency
+		out = append(out, scored{v: v, score: score})
+	}
+
+	sort.SliceStable(out, func(i, j int) bool { return out[i].score > out[j].score })
+
+	if len(out) > n {
+		out = out[:n]
+	}
+	ranked := make([]vector.VectorData, 0, len(out))
+	for _, s := range out {
+		ranked = append(ranked, s.v)
+	}
+	return ranked
+}
@@ -0,0 +1,614 @@
+package manager
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"vex-backend/vector"
+)
+
+const (
+	// redisPoolSize is how many idle connections are kept open
+	redisPoolSize = 8
+	// redisPageSize is how many documents are read per search or scan
+	redisPageSize = 1000
+	// redisMaxResults is RediSearch's default MAXSEARCHRESULTS, the most documents a filtered
+	// search returns
+	redisMaxResults = 10000
+	// redisMetaSeparator separates the "key=value" pairs of the meta tag field; it can't occur
+	// in a note's metadata
+	redisMetaSeparator = "\x1f"
+)
+
+// redisClient is a minimal Redis client speaking RESP2, covering what redisManager needs:
+// hashes, SCAN and the RediSearch FT.* commands. Connections are pooled, and a pipeline of
+// commands is written and read on one connection.
+type redisClient struct {
+	addr     string
+	username string
+	password string
+	db       int
+	tls      *tls.Config
+	timeout  time.Duration
+
+	pool chan *redisConn
+}
+
+type redisConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// redisError is an error reply of the server.
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// newRedisClient returns a client of the server at a redis:// or rediss:// URL, with the
+// password and database number taken from the URL. No connection is made yet.
+func newRedisClient(rawURL string, timeout time.Duration) (*redisClient, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid REDIS_URL: %w", err)
+	}
+	c := &redisClient{addr: u.Host, timeout: timeout, pool: make(chan *redisConn, redisPoolSize)}
+	if u.Port() == "" {
+		c.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.Scheme == "rediss" {
+		c.tls = &tls.Config{ServerName: u.Hostname()}
+	}
+	if u.User != nil {
+		c.username = u.User.Username()
+		c.password, _ = u.User.Password()
+		// redis://:password@host has no user name, only the default user's password
+		if _, ok := u.User.Password(); !ok {
+			c.username, c.password = "", c.username
+		}
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		if c.db, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("invalid REDIS_URL: database %q is not a number", db)
+		}
+	}
+	return c, nil
+}
+
+// conn returns an idle connection, or dials a new one and authenticates it.
+func (c *redisClient) conn(ctx context.Context) (*redisConn, error) {
+	select {
+	case rc := <-c.pool:
+		return rc, nil
+	default:
+	}
+
+	dialer := &net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second}
+	var nc net.Conn
+	var err error
+	if c.tls != nil {
+		nc, err = (&tls.Dialer{NetDialer: dialer, Config: c.tls}).DialContext(ctx, "tcp", c.addr)
+	} else {
+		nc, err = dialer.DialContext(ctx, "tcp", c.addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to redis: %w", err)
+	}
+	rc := &redisConn{Conn: nc, r: bufio.NewReader(nc)}
+
+	var setup [][]any
+	switch {
+	case c.username != "":
+		setup = append(setup, []any{"AUTH", c.username, c.password})
+	case c.password != "":
+		setup = append(setup, []any{"AUTH", c.password})
+	}
+	if c.db != 0 {
+		setup = append(setup, []any{"SELECT", c.db})
+	}
+	if len(setup) > 0 {
+		replies, err := c.roundTrip(ctx, rc, setup)
+		if err == nil {
+			err = firstError(replies)
+		}
+		if err != nil {
+			rc.Close()
+			return nil, err
+		}
+	}
+	return rc, nil
+}
+
+// put returns a connection to the pool, closing it if the pool is full.
+func (c *redisClient) put(rc *redisConn) {
+	select {
+	case c.pool <- rc:
+	default:
+		rc.Close()
+	}
+}
+
+// pipeline sends the commands in one write and returns their replies, error replies
+// included as redisError values.
+func (c *redisClient) pipeline(ctx context.Context, cmds [][]any) ([]any, error) {
+	rc, err := c.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	replies, err := c.roundTrip(ctx, rc, cmds)
+	if err != nil {
+		// the connection's state is unknown after a network or protocol error
+		rc.Close()
+		return nil, err
+	}
+	c.put(rc)
+	return replies, nil
+}
+
+// do sends one command and returns its reply, an error reply as the error.
+func (c *redisClient) do(ctx context.Context, args ...any) (any, error) {
+	replies, err := c.pipeline(ctx, [][]any{args})
+	if err != nil {
+		return nil, err
+	}
+	if err := firstError(replies); err != nil {
+		return nil, err
+	}
+	return replies[0], nil
+}
+
+func (c *redisClient) roundTrip(ctx context.Context, rc *redisConn, cmds [][]any) ([]any, error) {
+	deadline := time.Now().Add(c.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	rc.SetDeadline(deadline)
+
+	w := bufio.NewWriter(rc)
+	for _, args := range cmds {
+		fmt.Fprintf(w, "*%d\r\n", len(args))
+		for _, a := range args {
+			var b []byte
+			switch v := a.(type) {
+			case []byte:
+				b = v
+			case string:
+				b = []byte(v)
+			case int:
+				b = strconv.AppendInt(nil, int64(v), 10)
+			default:
+				b = []byte(fmt.Sprint(v))
+			}
+			fmt.Fprintf(w, "$%d\r\n", len(b))
+			w.Write(b)
+			w.WriteString("\r\n")
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return nil, fmt.Errorf("redis request failed: %w", err)
+	}
+
+	replies := make([]any, 0, len(cmds))
+	for range cmds {
+		reply, err := readReply(rc.r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read redis reply: %w", err)
+		}
+		replies = append(replies, reply)
+	}
+	return replies, nil
+}
+
+// readReply reads one RESP2 reply: a string, redisError, int64, []byte (nil for a null bulk
+// string) or []any.
+func readReply(r *bufio.Reader) (any, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("empty reply line")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return redisError(line[1:]), nil
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]any, 0, n)
+		for i := 0; i < n; i++ {
+			item, err := readReply(r)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("unexpected reply %q", line)
+}
+
+// firstError returns the first error reply among replies, mapped onto the shared sentinel
+// errors where one applies.
+func firstError(replies []any) error {
+	for _, reply := range replies {
+		if e, ok := reply.(redisError); ok {
+			msg := strings.ToLower(string(e))
+			if strings.Contains(msg, "unknown index name") || strings.Contains(msg, "no such index") {
+				return fmt.Errorf("%w: %w", e, vector.ErrNotFound)
+			}
+			return e
+		}
+	}
+	return nil
+}
+
+// replyString returns a string or bulk string reply as a string.
+func replyString(reply any) string {
+	switch v := reply.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	}
+	return ""
+}
+
+// document functions
+
+// redisDoc returns the fields of the hash holding v.
+func redisDoc(v vector.VectorData) ([]any, error) {
+	metadata, err := json.Marshal(v.Metadata)
+	if err != nil {
+		return nil, err
+	}
+	return []any{
+		"id", v.Id,
+		"content", v.Content,
+		"metadata", metadata,
+		"meta", strings.Join(metaPairs(v.Metadata), redisMetaSeparator),
+		"vector", vectorBytes(v.Embedding),
+	}, nil
+}
+
+// docVectorData returns the chunk held by a hash, given as alternating field names and
+// values.
+func docVectorData(fields []any) vector.VectorData {
+	v := vector.VectorData{Metadata: map[string]string{}}
+	for i := 0; i+1 < len(fields); i += 2 {
+		value, _ := fields[i+1].([]byte)
+		switch replyString(fields[i]) {
+		case "id":
+			v.Id = string(value)
+		case "content":
+			v.Content = string(value)
+		case "metadata":
+			// written by redisDoc; a foreign document just comes back without metadata
+			_ = json.Unmarshal(value, &v.Metadata)
+		case "vector":
+			v.Embedding = bytesVector(value)
+		case "vector_distance":
+			if d, err := strconv.ParseFloat(string(value), 32); err == nil {
+				v.Similarity = 1 - float32(d)
+			}
+		}
+	}
+	return v
+}
+
+// vectorBytes and bytesVector convert between an embedding and the little-endian FLOAT32
+// blob RediSearch indexes.
+func vectorBytes(embedding []float32) []byte {
+	b := make([]byte, 4*len(embedding))
+	for i, f := range embedding {
+		binary.LittleEndian.PutUint32(b[4*i:], math.Float32bits(f))
+	}
+	return b
+}
+func bytesVector(b []byte) []float32 {
+	out := make([]float32, len(b)/4)
+	for i := range out {
+		out[i] = math.Float32frombits(binary.LittleEndian.Uint32(b[4*i:]))
+	}
+	return out
+}
+
+// set stores vs as hashes under prefix, keyed by chunk ID, in one pipeline per
+// redisPageSize chunks. Chunks with an ID that is already stored replace it.
+func (c *redisClient) set(ctx context.Context, prefix string, vs []vector.VectorData) error {
+	for start := 0; start < len(vs); start += redisPageSize {
+		batch := vs[start:min(start+redisPageSize, len(vs))]
+		cmds := make([][]any, 0, 2*len(batch))
+		for _, v := range batch {
+			fields, err := redisDoc(v)
+			if err != nil {
+				return err
+			}
+			// DEL first, so no field of a previous version survives
+			cmds = append(cmds, []any{"DEL", prefix + v.Id}, append([]any{"HSET", prefix + v.Id}, fields...))
+		}
+		replies, err := c.pipeline(ctx, cmds)
+		if err != nil {
+			return err
+		}
+		if err := firstError(replies); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// get returns the chunk stored under prefix with the given ID, vector.ErrNotFound if there
+// is none.
+func (c *redisClient) get(ctx context.Context, prefix, id string) (vector.VectorData, error) {
+	reply, err := c.do(ctx, "HGETALL", prefix+id)
+	if err != nil {
+		return vector.VectorData{}, err
+	}
+	fields, _ := reply.([]any)
+	if len(fields) == 0 {
+		return vector.VectorData{}, fmt.Errorf("document %q: %w", id, vector.ErrNotFound)
+	}
+	return docVectorData(fields), nil
+}
+
+// deleteIDs deletes the chunks stored under prefix with the given IDs.
+func (c *redisClient) deleteIDs(ctx context.Context, prefix string, ids []string) error {
+	for start := 0; start < len(ids); start += redisPageSize {
+		batch := ids[start:min(start+redisPageSize, len(ids))]
+		args := make([]any, 0, len(batch)+1)
+		args = append(args, "DEL")
+		for _, id := range batch {
+			args = append(args, prefix+id)
+		}
+		if _, err := c.do(ctx, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scan returns every chunk stored under prefix, walking the keyspace with SCAN.
+func (c *redisClient) scan(ctx context.Context, prefix string) ([]vector.VectorData, error) {
+	var out []vector.VectorData
+	cursor := "0"
+	for {
+		reply, err := c.do(ctx, "SCAN", cursor, "MATCH", globEscape(prefix)+"*", "COUNT", redisPageSize)
+		if err != nil {
+			return nil, err
+		}
+		parts, _ := reply.([]any)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("unexpected SCAN reply")
+		}
+		cursor = replyString(parts[0])
+		keys, _ := parts[1].([]any)
+
+		cmds := make([][]any, 0, len(keys))
+		for _, k := range keys {
+			cmds = append(cmds, []any{"HGETALL", replyString(k)})
+		}
+		if len(cmds) > 0 {
+			replies, err := c.pipeline(ctx, cmds)
+			if err != nil {
+				return nil, err
+			}
+			if err := firstError(replies); err != nil {
+				return nil, err
+			}
+			for _, r := range replies {
+				// a key deleted since the SCAN comes back empty
+				if fields, _ := r.([]any); len(fields) > 0 {
+					out = append(out, docVectorData(fields))
+				}
+			}
+		}
+		if cursor == "0" {
+			return out, nil
+		}
+	}
+}
+
+// globEscape escapes the characters SCAN's MATCH pattern treats specially.
+func globEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(`*?[]\`, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// index functions
+
+// indexes returns the names of all RediSearch indexes.
+func (c *redisClient) indexes(ctx context.Context) ([]string, error) {
+	reply, err := c.do(ctx, "FT._LIST")
+	if err != nil {
+		return nil, err
+	}
+	items, _ := reply.([]any)
+	out := make([]string, 0, len(items))
+	for _, item := range items {
+		out = append(out, replyString(item))
+	}
+	return out, nil
+}
+
+// createIndex creates an index of the hashes under index+":", with an HNSW index of their
+// vectors compared by cosine distance, the content as text and the metadata as tags.
+func (c *redisClient) createIndex(ctx context.Context, index string, dimension int) error {
+	_, err := c.do(ctx, "FT.CREATE", index, "ON", "HASH", "PREFIX", 1, index+":",
+		"SCHEMA",
+		"content", "TEXT",
+		"meta", "TAG", "SEPARATOR", redisMetaSeparator, "CASESENSITIVE",
+		"vector", "VECTOR", "HNSW", 6, "TYPE", "FLOAT32", "DIM", dimension, "DISTANCE_METRIC", "COSINE")
+	return err
+}
+
+// dropIndex drops an index and the hashes it indexes.
+func (c *redisClient) dropIndex(ctx context.Context, index string) error {
+	_, err := c.do(ctx, "FT.DROPINDEX", index, "DD")
+	return err
+}
+
+// aliasTarget returns the index an alias points to, vector.ErrNotFound if there is no such
+// alias.
+func (c *redisClient) aliasTarget(ctx context.Context, alias string) (string, error) {
+	reply, err := c.do(ctx, "FT.INFO", alias)
+	if err != nil {
+		return "", err
+	}
+	info, _ := reply.([]any)
+	for i := 0; i+1 < len(info); i += 2 {
+		if replyString(info[i]) == "index_name" {
+			return replyString(info[i+1]), nil
+		}
+	}
+	return "", fmt.Errorf("FT.INFO %s: no index_name", alias)
+}
+
+// setAlias points alias at index, creating it if it doesn't exist yet. Moving an alias is
+// atomic: searches through it see either index, never neither.
+func (c *redisClient) setAlias(ctx context.Context, alias, index string, exists bool) error {
+	cmd := "FT.ALIASADD"
+	if exists {
+		cmd = "FT.ALIASUPDATE"
+	}
+	_, err := c.do(ctx, cmd, alias, index)
+	return err
+}
+
+// search runs FT.SEARCH on index and returns the chunks of up to n matching documents
+// starting at offset, with the total number of matches.
+func (c *redisClient) search(ctx context.Context, index, query string, offset, n int, extra ...any) ([]vector.VectorData, int, error) {
+	args := append([]any{"FT.SEARCH", index, query}, extra...)
+	args = append(args, "LIMIT", offset, n, "DIALECT", 2)
+	reply, err := c.do(ctx, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	items, _ := reply.([]any)
+	if len(items) == 0 {
+		return nil, 0, fmt.Errorf("unexpected FT.SEARCH reply")
+	}
+	total, _ := items[0].(int64)
+	var out []vector.VectorData
+	for i := 1; i+1 < len(items); i += 2 {
+		fields, _ := items[i+1].([]any)
+		out = append(out, docVectorData(fields))
+	}
+	return out, int(total), nil
+}
+
+// filter returns up to redisMaxResults chunks of index matching the query, page by page.
+func (c *redisClient) filter(ctx context.Context, index, query string) ([]vector.VectorData, error) {
+	var out []vector.VectorData
+	for offset := 0; offset < redisMaxResults; offset += redisPageSize {
+		page, total, err := c.search(ctx, index, query, offset, redisPageSize)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, page...)
+		if offset+redisPageSize >= total {
+			break
+		}
+	}
+	return out, nil
+}
+
+// knn returns the n chunks of index closest to embedding among those matching the filter
+// query, with their cosine similarity.
+func (c *redisClient) knn(ctx context.Context, index string, embedding []float32, filter string, n int) ([]vector.VectorData, error) {
+	query := fmt.Sprintf("(%s)=>[KNN $k @vector $vec AS vector_distance]", filter)
+	results, _, err := c.search(ctx, index, query, 0, n,
+		"PARAMS", 4, "k", n, "vec", vectorBytes(embedding), "SORTBY", "vector_distance")
+	return results, err
+}
+
+// count returns the number of documents in index.
+func (c *redisClient) count(ctx context.Context, index string) (int, error) {
+	_, total, err := c.search(ctx, index, "*", 0, 0)
+	return total, err
+}
+
+// deleteMatching deletes the chunks of index matching the query.
+func (c *redisClient) deleteMatching(ctx context.Context, index, query string) error {
+	for {
+		reply, err := c.do(ctx, "FT.SEARCH", index, query, "NOCONTENT", "LIMIT", 0, redisPageSize, "DIALECT", 2)
+		if err != nil {
+			return err
+		}
+		items, _ := reply.([]any)
+		if len(items) < 2 {
+			return nil
+		}
+		args := make([]any, 0, len(items))
+		args = append(args, "DEL")
+		for _, k := range items[1:] {
+			args = append(args, replyString(k))
+		}
+		if _, err := c.do(ctx, args...); err != nil {
+			return err
+		}
+	}
+}
+
+// redisFilter turns an exact-match metadata filter into a RediSearch query on the meta
+// tags; "*" if where is empty.
+func redisFilter(where map[string]string) string {
+	if len(where) == 0 {
+		return "*"
+	}
+	pairs := metaPairs(where)
+	terms := make([]string, 0, len(pairs))
+	for _, p := range pairs {
+		terms = append(terms, "@meta:{"+tagEscape(p)+"}")
+	}
+	return strings.Join(terms, " ")
+}
+
+// tagEscape escapes every character of a tag value that isn't a letter, digit or '_'.
+func tagEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if !(r == '_' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r > 127) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
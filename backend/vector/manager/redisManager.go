@@ -0,0 +1,664 @@
+package manager
+
+import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"vex-backend/config"
+	"vex-backend/vector"
+	"vex-backend/vector/embed"
+)
+
+// redisManager is a Manager storing the chunks as hashes in a Redis server with the search
+// module (VECTOR_BACKEND=redis), so Redis provides persistence and replication. Each
+// collection is a RediSearch index reached through an alias, of the hashes under the index
+// name; Reindex and Import build a new index and move the alias to it. The trash of a
+// collection is an index of its own, shared by all its versions.
+type redisManager struct {
+	client   *redisClient
+	Embedder embed.Embedder
+	// Config supplies the reloadable settings (dedup threshold)
+	Config config.Source
+	// queries caches the embeddings of recent queries
+	queries *queryCache
+	// name is the collection the manager works on, alias the RediSearch alias pointing to its
+	// live index, index that index, which changes when Reindex or Import move the alias, and
+	// trash the index of its trash
+	name  string
+	alias string
+	index string
+	trash string
+	// reindexing allows one Reindex at a time
+	reindexing sync.Mutex
+	// collections holds the managers of all collections opened so far
+	collections *redisCollections
+
+	// mu makes every method atomic with respect to the others within this process, as for
+	// the chromem manager. Methods named ...Locked expect the caller to hold it.
+	mu sync.RWMutex
+}
+
+// NewRedisManager returns a Manager storing vectors in the Redis server at REDIS_URL,
+// creating the indexes of VECTOR_COLLECTION if they don't exist yet.
+func NewRedisManager(cfg config.Source, e embed.Embedder) (Manager, error) {
+	c := cfg()
+	client, err := newRedisClient(c.RedisURL, c.HTTPTimeout)
+	if err != nil {
+		return nil, err
+	}
+	collections := &redisCollections{
+		client:    client,
+		prefix:    c.RedisKeyPrefix,
+		dimension: c.RedisDimension,
+		embedder:  e,
+		config:    cfg,
+		queries:   newQueryCache(cfg),
+		byName:    map[string]*redisManager{},
+	}
+	name := c.VectorCollection
+	if name == "" {
+		name = notesCollection
+	}
+
+	collections.mu.Lock()
+	defer collections.mu.Unlock()
+	rm, err := collections.openLocked(context.Background(), name, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the %s collection in redis: %w", name, err)
+	}
+	return rm, nil
+}
+
+// redisCollections holds the manager of every collection opened so far, shared by all of
+// them, so that each collection has a single manager and lock.
+type redisCollections struct {
+	client    *redisClient
+	prefix    string
+	dimension int
+	embedder  embed.Embedder
+	config    config.Source
+	queries   *queryCache
+
+	mu     sync.Mutex
+	byName map[string]*redisManager
+}
+
+// aliasOf returns the RediSearch alias of the named collection. Collection names hold no
+// ':', so the name can be read back from the alias and index names.
+func (c *redisCollections) aliasOf(name string) string {
+	return c.prefix + ":" + name
+}
+
+// newIndex creates the next index of the named collection.
+func (c *redisCollections) newIndex(ctx context.Context, name string) (string, error) {
+	index := c.aliasOf(name) + ":" + strconv.FormatInt(time.Now().UnixNano(), 10)
+	if err := c.client.createIndex(ctx, index, c.dimension); err != nil {
+		return "", err
+	}
+	return index, nil
+}
+
+// openLocked returns a manager of the named collection, resolving its alias. With create
+// set, missing indexes are created; otherwise a collection without an alias is reported as
+// vector.ErrNotFound. Indexes of a Reindex or Import that didn't finish, or that were
+// replaced, are dropped with their hashes. The caller holds c.mu.
+func (c *redisCollections) openLocked(ctx context.Context, name string, create bool) (*redisManager, error) {
+	alias := c.aliasOf(name)
+	live, err := c.client.aliasTarget(ctx, alias)
+	exists := err == nil
+	if err != nil && !errors.Is(err, vector.ErrNotFound) {
+		return nil, err
+	}
+
+	indexes, err := c.client.indexes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	trash := alias + ":trash"
+	hasTrash := false
+	for _, index := range indexes {
+		switch {
+		case index == trash:
+			hasTrash = true
+		case index != live && isVersionOf(index, alias, ":"):
+			if err := c.client.dropIndex(ctx, index); err != nil {
+				log.Printf("[redisManager] warning: failed to drop unused index %s: %v", index, err)
+			}
+		}
+	}
+
+	if !exists {
+		if !create {
+			return nil, fmt.Errorf("collection %q: %w", name, vector.ErrNotFound)
+		}
+		if live, err = c.newIndex(ctx, name); err != nil {
+			return nil, err
+		}
+		if err := c.client.setAlias(ctx, alias, live, false); err != nil {
+			return nil, err
+		}
+	}
+	// soft-deleted chunks live in their own index, so no query can match them
+	if !hasTrash {
+		if err := c.client.createIndex(ctx, trash, c.dimension); err != nil {
+			return nil, err
+		}
+	}
+
+	rm := &redisManager{
+		client:      c.client,
+		Embedder:    c.embedder,
+		Config:      c.config,
+		queries:     c.queries,
+		name:        name,
+		alias:       alias,
+		index:       live,
+		trash:       trash,
+		collections: c,
+	}
+	c.byName[name] = rm
+	return rm, nil
+}
+
+// redisKeys returns the prefix of the keys of the hashes an index covers.
+func redisKeys(index string) string {
+	return index + ":"
+}
+
+func (rm *redisManager) GetDBInstance() any {
+	return rm.client
+}
+func (rm *redisManager) GetEmbedder() embed.Embedder {
+	return rm.Embedder
+}
+func (rm *redisManager) EmbedQuery(ctx context.Context, query string, language string) ([]float32, error) {
+	return rm.queries.embed(ctx, rm.Embedder, query, language)
+}
+func (rm *redisManager) QueryCacheStats() QueryCacheStats {
+	return rm.queries.stats()
+}
+
+// MemoryUsage reports the vectors as remote: they live in the server.
+func (rm *redisManager) MemoryUsage(ctx context.Context) (MemoryUsage, error) {
+	return remoteMemoryUsage(ctx)
+}
+
+// softDelete reports whether SOFT_DELETE is currently enabled.
+func (rm *redisManager) softDelete() bool {
+	return rm.Config != nil && rm.Config().SoftDelete
+}
+
+// store returns the chunkStore of the manager: its live index and its trash index.
+func (rm *redisManager) store() redisStore {
+	return redisStore{client: rm.client, index: rm.index, trash: rm.trash, dimension: rm.collections.dimension}
+}
+
+// redisStore is the chunkStore of a RediSearch index and the index of its trash.
+type redisStore struct {
+	client    *redisClient
+	index     string
+	trash     string
+	dimension int
+}
+
+// checkDimension fails with vector.ErrDimensionMismatch for an embedding of another length
+// than the index's: RediSearch would store the hash but silently leave it out of the index.
+func (s redisStore) checkDimension(embedding []float32) error {
+	if len(embedding) != s.dimension {
+		return fmt.Errorf("%w: index has %d dimensions, got %d", vector.ErrDimensionMismatch, s.dimension, len(embedding))
+	}
+	return nil
+}
+
+// chunks scans the index's hashes without a filter, and lets RediSearch filter otherwise, up
+// to redisMaxResults chunks.
+func (s redisStore) chunks(ctx context.Context, where map[string]string) ([]vector.VectorData, error) {
+	if len(where) == 0 {
+		return s.client.scan(ctx, redisKeys(s.index))
+	}
+	return s.client.filter(ctx, s.index, redisFilter(where))
+}
+func (s redisStore) nearest(ctx context.Context, embedding []float32, where map[string]string, n int) ([]vector.VectorData, error) {
+	if err := s.checkDimension(embedding); err != nil {
+		return nil, err
+	}
+	return s.client.knn(ctx, s.index, embedding, redisFilter(where), n)
+}
+func (s redisStore) put(ctx context.Context, vs []vector.VectorData) error {
+	for _, v := range vs {
+		if err := s.checkDimension(v.Embedding); err != nil {
+			return err
+		}
+	}
+	return s.client.set(ctx, redisKeys(s.index), vs)
+}
+func (s redisStore) deleteIDs(ctx context.Context, ids []string) error {
+	return s.client.deleteIDs(ctx, redisKeys(s.index), ids)
+}
+func (s redisStore) deleteWhere(ctx context.Context, where map[string]string) error {
+	return s.client.deleteMatching(ctx, s.index, redisFilter(where))
+}
+func (s redisStore) trashed(ctx context.Context) ([]vector.VectorData, error) {
+	return s.client.scan(ctx, redisKeys(s.trash))
+}
+func (s redisStore) putTrash(ctx context.Context, vs []vector.VectorData) error {
+	return s.client.set(ctx, redisKeys(s.trash), vs)
+}
+func (s redisStore) deleteTrashIDs(ctx context.Context, ids []string) error {
+	return s.client.deleteIDs(ctx, redisKeys(s.trash), ids)
+}
+
+// storage functions
+func (rm *redisManager) StoreVectorInDB(ctx context.Context, v vector.VectorData) error {
+	return rm.StoreVectorsInDB(ctx, []vector.VectorData{v})
+}
+
+// StoreVectorsInDB stores the vectors in pipelined writes, with the same duplicate handling
+// as the chromem manager.
+func (rm *redisManager) StoreVectorsInDB(ctx context.Context, vs []vector.VectorData) error {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	_, err := rm.storeLocked(ctx, vs)
+	return err
+}
+
+// storeLocked is StoreVectorsInDB, also returning the IDs written, so a failed batch can be
+// rolled back.
+func (rm *redisManager) storeLocked(ctx context.Context, vs []vector.VectorData) ([]string, error) {
+	return storeChunks(ctx, rm.store(), rm.Embedder, DedupSimilarityThresholdFrom(rm.Config()), vs, "redisManager")
+}
+func (rm *redisManager) StoreFileAsVectorsInDB(ctx context.Context, filename string) error {
+	path, metadata, err := fileMetadata(filename)
+	if err != nil {
+		return err
+	}
+
+	vs, err := rm.Embedder.EmbedFileToVectorData(ctx, path, metadata)
+	if err != nil {
+		return err
+	}
+	return rm.StoreVectorsInDB(ctx, vs)
+}
+
+// ReplaceFileVectorsInDB works like the chromem manager's: the file is embedded first,
+// reusing the embeddings of unchanged chunks, then its stored chunks are swapped for the new
+// ones, and restored if storing fails.
+func (rm *redisManager) ReplaceFileVectorsInDB(ctx context.Context, filename string) error {
+	path, metadata, err := fileMetadata(filename)
+	if err != nil {
+		return err
+	}
+	reusable, err := rm.GetChunksByFile(ctx, path)
+	if err != nil {
+		return err
+	}
+
+	vs, err := rm.Embedder.EmbedFileToVectorData(embed.WithPrevious(ctx, reusable), path, metadata)
+	if err != nil {
+		return err
+	}
+
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	return replaceFileChunks(ctx, rm.store(), path, vs, rm.softDelete(), rm.storeLocked, "redisManager")
+}
+
+// retrieval functions
+func (rm *redisManager) RetriveVectorByMetadata(ctx context.Context, key string, data string) (vector.VectorData, error) {
+	vs, err := rm.GetByMetadata(ctx, map[string]string{key: data})
+	if err != nil {
+		return vector.VectorData{}, err
+	}
+	if len(vs) == 0 {
+		return vector.VectorData{}, fmt.Errorf("no document with metadata %s=%s: %w", key, data, vector.ErrNotFound)
+	}
+	return vs[0], nil
+}
+func (rm *redisManager) RetriveVectorWithID(ctx context.Context, id string) (vector.VectorData, error) {
+	return rm.GetByID(ctx, id)
+}
+func (rm *redisManager) GetByID(ctx context.Context, id string) (vector.VectorData, error) {
+	rm.mu.RLock()
+	defer rm.mu.RUnlock()
+
+	return rm.client.get(ctx, redisKeys(rm.index), id)
+}
+func (rm *redisManager) GetByMetadata(ctx context.Context, where map[string]string) ([]vector.VectorData, error) {
+	rm.mu.RLock()
+	defer rm.mu.RUnlock()
+
+	return rm.getByMetadataLocked(ctx, where)
+}
+
+func (rm *redisManager) getByMetadataLocked(ctx context.Context, where map[string]string) ([]vector.VectorData, error) {
+	return listChunks(ctx, rm.store(), where)
+}
+func (rm *redisManager) GetChunksByFile(ctx context.Context, path string) ([]vector.VectorData, error) {
+	return rm.GetByMetadata(ctx, map[string]string{"filepath": path})
+}
+func (rm *redisManager) RetriveNVectorsByQuery(ctx context.Context, query string, n int) ([]vector.VectorData, error) {
+	return rm.RetriveNVectorsByQueryWithFilter(ctx, query, n, nil)
+}
+func (rm *redisManager) RetriveNVectorsByQueryWithFilter(ctx context.Context, query string, n int, where map[string]string) ([]vector.VectorData, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("n must be > 0")
+	}
+	if query == "" {
+		return nil, errors.New("query failed: query is empty")
+	}
+	// an empty collection fails without spending an embedding on the query
+	if count, err := rm.Count(ctx); err == nil && count == 0 {
+		return nil, vector.ErrEmptyCollection
+	}
+
+	embedding, err := rm.EmbedQuery(ctx, query, "")
+	if err != nil {
+		return nil, fmt.Errorf("query failed: couldn't create embedding of query: %w", err)
+	}
+	return rm.RetriveNVectorsByEmbedding(ctx, embedding, n, where)
+}
+func (rm *redisManager) RetriveNVectorsByEmbedding(ctx context.Context, embedding []float32, n int, where map[string]string) ([]vector.VectorData, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("n must be > 0")
+	}
+	if err := rm.store().checkDimension(embedding); err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	rm.mu.RLock()
+	defer rm.mu.RUnlock()
+
+	results, err := rm.client.knn(ctx, rm.index, embedding, redisFilter(where), n)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	// only an empty result is worth telling an empty collection apart
+	if len(results) == 0 {
+		if count, err := rm.client.count(ctx, rm.index); err == nil && count == 0 {
+			return nil, vector.ErrEmptyCollection
+		}
+	}
+	return results, nil
+}
+func (rm *redisManager) RetriveNVectorsByQueryRanked(ctx context.Context, query string, n int, where map[string]string, rank RankOptions) ([]vector.VectorData, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("n must be > 0")
+	}
+	if rank.RecencyWeight <= 0 {
+		return rm.RetriveNVectorsByQueryWithFilter(ctx, query, n, where)
+	}
+
+	candidates, err := rm.RetriveNVectorsByQueryWithFilter(ctx, query, n*rankCandidateFactor, where)
+	if err != nil {
+		return nil, err
+	}
+	return rankByRecency(candidates, n, rank, time.Now()), nil
+}
+
+// deletion functions
+func (rm *redisManager) DeleteVectorWithID(ctx context.Context, id string) error {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	return rm.client.deleteIDs(ctx, redisKeys(rm.index), []string{id})
+}
+func (rm *redisManager) DeleteVectorsWithMetaData(ctx context.Context, key string, data string) error {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	if rm.softDelete() {
+		_, err := rm.trashLocked(ctx, key, data)
+		return err
+	}
+	return rm.client.deleteMatching(ctx, rm.index, redisFilter(map[string]string{key: data}))
+}
+
+// trash functions
+func (rm *redisManager) TrashVectorsWithMetaData(ctx context.Context, key string, data string) (int, error) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	return rm.trashLocked(ctx, key, data)
+}
+func (rm *redisManager) trashLocked(ctx context.Context, key string, data string) (int, error) {
+	return trashWhere(ctx, rm.store(), key, data)
+}
+func (rm *redisManager) ListTrash(ctx context.Context) ([]vector.VectorData, error) {
+	rm.mu.RLock()
+	defer rm.mu.RUnlock()
+
+	return rm.listTrashLocked(ctx)
+}
+func (rm *redisManager) listTrashLocked(ctx context.Context) ([]vector.VectorData, error) {
+	return listTrash(ctx, rm.store())
+}
+func (rm *redisManager) RestoreFromTrash(ctx context.Context, path string, deletedAt string) (int, error) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	return restoreFromTrash(ctx, rm.store(), path, deletedAt)
+}
+func (rm *redisManager) PurgeTrash(ctx context.Context, before time.Time) (int, error) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	return purgeTrash(ctx, rm.store(), before)
+}
+
+// snapshot functions
+
+// Export writes the chunks and the trash in the snapshot format of the in-memory manager;
+// snapshots of the chromem store can't be imported here, nor the other way round.
+func (rm *redisManager) Export(ctx context.Context, w io.Writer) error {
+	rm.mu.RLock()
+	defer rm.mu.RUnlock()
+
+	return exportSnapshot(ctx, rm.store(), w)
+}
+
+// Import fills a new index with the snapshot's chunks and moves the alias to it, so queries
+// see the previous chunks until the import is complete; the trash is replaced afterwards.
+func (rm *redisManager) Import(ctx context.Context, r io.ReadSeeker) error {
+	var snap memorySnapshot
+	if err := gob.NewDecoder(r).Decode(&snap); err != nil {
+		return fmt.Errorf("failed to read snapshot: %w", err)
+	}
+
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	index, err := rm.collections.newIndex(ctx, rm.name)
+	if err != nil {
+		return err
+	}
+	docs := make([]vector.VectorData, 0, len(snap.Docs))
+	for _, v := range snap.Docs {
+		docs = append(docs, v)
+	}
+	if err := rm.client.set(ctx, redisKeys(index), docs); err != nil {
+		if derr := rm.client.dropIndex(ctx, index); derr != nil {
+			log.Printf("[redisManager] warning: failed to drop import index %s: %v", index, derr)
+		}
+		return fmt.Errorf("failed to import snapshot: %w", err)
+	}
+	if err := rm.swapLocked(ctx, index); err != nil {
+		return err
+	}
+
+	current, err := rm.client.scan(ctx, redisKeys(rm.trash))
+	if err != nil {
+		return err
+	}
+	ids := make([]string, 0, len(current))
+	for _, v := range current {
+		ids = append(ids, v.Id)
+	}
+	if err := rm.client.deleteIDs(ctx, redisKeys(rm.trash), ids); err != nil {
+		return err
+	}
+	trashed := make([]vector.VectorData, 0, len(snap.Trash))
+	for _, v := range snap.Trash {
+		trashed = append(trashed, v)
+	}
+	return rm.client.set(ctx, redisKeys(rm.trash), trashed)
+}
+
+// Reindex builds the new index alongside the live one, through a manager sharing the client
+// and the trash, and swaps it in by moving the alias and dropping the previous index.
+func (rm *redisManager) Reindex(ctx context.Context, build func(staging Manager) error) error {
+	rm.reindexing.Lock()
+	defer rm.reindexing.Unlock()
+
+	index, err := rm.collections.newIndex(ctx, rm.name)
+	if err != nil {
+		return err
+	}
+	swapped := false
+	defer func() {
+		if !swapped {
+			if err := rm.client.dropIndex(context.Background(), index); err != nil {
+				log.Printf("[redisManager] warning: failed to drop reindex index %s: %v", index, err)
+			}
+		}
+	}()
+
+	rm.mu.RLock()
+	live, err := rm.client.scan(ctx, redisKeys(rm.index))
+	rm.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	before := fileFingerprints(live)
+
+	staging := &redisManager{
+		client:      rm.client,
+		Embedder:    rm.Embedder,
+		Config:      rm.Config,
+		queries:     newQueryCache(rm.Config),
+		name:        rm.name,
+		alias:       rm.alias,
+		index:       index,
+		trash:       rm.trash,
+		collections: rm.collections,
+	}
+	if err := build(staging); err != nil {
+		return err
+	}
+
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	if err := carryOver(ctx, rm.store(), staging.store(), before, "redisManager"); err != nil {
+		return err
+	}
+
+	if err := rm.swapLocked(ctx, index); err != nil {
+		return err
+	}
+	swapped = true
+	log.Printf("[redisManager] reindex: swapped in %s", index)
+	return nil
+}
+
+// swapLocked moves the alias to index and drops the previous index with its hashes. A crash
+// in between leaves the previous index behind, and the next start drops it.
+func (rm *redisManager) swapLocked(ctx context.Context, index string) error {
+	if err := rm.client.setAlias(ctx, rm.alias, index, true); err != nil {
+		return fmt.Errorf("failed to point %s at %s: %w", rm.alias, index, err)
+	}
+	old := rm.index
+	rm.index = index
+	if err := rm.client.dropIndex(ctx, old); err != nil {
+		log.Printf("[redisManager] warning: failed to drop previous index %s: %v", old, err)
+	}
+	return nil
+}
+
+// collection functions
+func (rm *redisManager) CollectionName() string {
+	return rm.name
+}
+func (rm *redisManager) Collection(name string) (Manager, error) {
+	c := rm.collections
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if m, ok := c.byName[name]; ok {
+		return m, nil
+	}
+	return c.openLocked(context.Background(), name, false)
+}
+func (rm *redisManager) CreateCollection(ctx context.Context, name string) error {
+	if err := config.CheckCollectionName(name); err != nil {
+		return err
+	}
+	c := rm.collections
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_, err := c.client.aliasTarget(ctx, c.aliasOf(name))
+	if err == nil {
+		return fmt.Errorf("collection %q: %w", name, vector.ErrCollectionExists)
+	}
+	if !errors.Is(err, vector.ErrNotFound) {
+		return err
+	}
+	_, err = c.openLocked(ctx, name, true)
+	return err
+}
+
+// Collections lists the collections with a trash index, which every collection has.
+func (rm *redisManager) Collections(ctx context.Context) ([]CollectionInfo, error) {
+	indexes, err := rm.client.indexes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, index := range indexes {
+		rest, ok := strings.CutPrefix(index, rm.collections.prefix+":")
+		if name, isTrash := strings.CutSuffix(rest, ":trash"); ok && isTrash && !strings.Contains(name, ":") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	out := make([]CollectionInfo, 0, len(names))
+	for _, name := range names {
+		m, err := rm.Collection(name)
+		if errors.Is(err, vector.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		n, err := m.Count(ctx)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, CollectionInfo{Name: name, Chunks: n})
+	}
+	return out, nil
+}
+
+// maintenance functions
+func (rm *redisManager) Count(ctx context.Context) (int, error) {
+	rm.mu.RLock()
+	defer rm.mu.RUnlock()
+
+	return rm.client.count(ctx, rm.index)
+}
+func (rm *redisManager) DeduplicateVectors(ctx context.Context, threshold float32) (int, error) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	return deduplicate(ctx, rm.store(), threshold)
+}
@@ -0,0 +1,117 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"vex-backend/config"
+	"vex-backend/httpclient"
+	"vex-backend/redact"
+	"vex-backend/vector/embed"
+)
+
+// Factory builds the Manager of one backend around the embedder e. client is the shared HTTP
+// client, for backends that talk to their server over HTTP.
+type Factory func(cfg config.Source, e embed.Embedder, client httpclient.Doer) (Manager, error)
+
+var (
+	backendsMu sync.RWMutex
+	backends   = map[string]Factory{}
+)
+
+// the built-in backends
+func init() {
+	Register("chromem", func(cfg config.Source, e embed.Embedder, _ httpclient.Doer) (Manager, error) {
+		return NewChromemManager(cfg, e), nil
+	})
+	Register("memory", func(cfg config.Source, e embed.Embedder, _ httpclient.Doer) (Manager, error) {
+		return NewEphemeralManager(cfg, e)
+	})
+	Register("weaviate", NewWeaviateManager)
+	Register("milvus", NewMilvusManager)
+	Register("redis", func(cfg config.Source, e embed.Embedder, _ httpclient.Doer) (Manager, error) {
+		return NewRedisManager(cfg, e)
+	})
+	Register("opensearch", NewOpenSearchManager)
+}
+
+// Register makes a backend selectable with VECTOR_BACKEND=name. It is meant to be called
+// from an init function and panics if name is already registered.
+func Register(name string, f Factory) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+
+	if _, ok := backends[name]; ok {
+		panic(fmt.Sprintf("vector backend %q registered twice", name))
+	}
+	backends[name] = f
+}
+
+// Backends returns the names of the registered backends, sorted.
+func Backends() []string {
+	backendsMu.RLock()
+	defer backendsMu.RUnlock()
+
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New builds the Manager of the backend named by VECTOR_BACKEND around e.
+func New(cfg config.Source, e embed.Embedder, client httpclient.Doer) (Manager, error) {
+	name := cfg().VectorBackend
+	backendsMu.RLock()
+	f, ok := backends[name]
+	backendsMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown VECTOR_BACKEND %q, expected one of %s", name, strings.Join(Backends(), ", "))
+	}
+	return f(cfg, e, client)
+}
+
+// Open builds the embedder named by EMBED_PROVIDER and the Manager of VECTOR_BACKEND around
+// it, as configured in cfg. With ENSEMBLE_PROVIDER set, the Manager is wrapped with
+// WithEnsemble around a second embedder of that provider, and with VERSION_HISTORY with
+// WithHistory.
+func Open(cfg config.Source, client httpclient.Doer, redactor *redact.Redactor) (Manager, error) {
+	e, err := embed.New(cfg, client, redactor)
+	if err != nil {
+		return nil, err
+	}
+	m, err := New(cfg, e, client)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg().EnsembleProvider != "" {
+		second, err := embed.New(ensembleConfig(cfg), client, redactor)
+		if err != nil {
+			return nil, err
+		}
+		if m, err = WithEnsemble(context.Background(), m, second); err != nil {
+			return nil, err
+		}
+	}
+	if cfg().VersionHistory {
+		return WithHistory(context.Background(), m)
+	}
+	return m, nil
+}
+
+// ensembleConfig is cfg as seen by the ensemble's embedder: ENSEMBLE_PROVIDER in place of
+// EMBED_PROVIDER, and ENSEMBLE_MODEL, if set, in place of VOYAGE_MODEL.
+func ensembleConfig(cfg config.Source) config.Source {
+	return func() *config.EnvConfig {
+		c := *cfg()
+		c.EmbedProvider = c.EnsembleProvider
+		if c.EnsembleModel != "" {
+			c.VoyageModel = c.EnsembleModel
+		}
+		return &c
+	}
+}
@@ -0,0 +1,56 @@
+package manager
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strings"
+	"vex-backend/vector"
+)
+
+// fileFingerprints returns a fingerprint of the chunks of every file in vs, keyed by
+// filepath; derived documents without one are collected under "". A file's fingerprint
+// changes whenever any of its chunks is added, removed or replaced.
+func fileFingerprints(vs []vector.VectorData) map[string]string {
+	byFile := map[string][]vector.VectorData{}
+	for _, v := range vs {
+		path := v.Metadata["filepath"]
+		byFile[path] = append(byFile[path], v)
+	}
+
+	out := make(map[string]string, len(byFile))
+	for path, chunks := range byFile {
+		sort.Slice(chunks, func(i, j int) bool { return chunks[i].Id < chunks[j].Id })
+		h := sha256.New()
+		for _, c := range chunks {
+			h.Write([]byte(c.Id))
+			h.Write([]byte{0})
+			h.Write([]byte(c.Content))
+			h.Write([]byte{0})
+		}
+		out[path] = hex.EncodeToString(h.Sum(nil))
+	}
+	return out
+}
+
+// carriedOver returns the files whose live chunks must replace those of a rebuilt index
+// when it is swapped in, sorted: files written to the live index while the rebuild ran
+// (their fingerprint in now differs from before, including files that appeared or went
+// away), since the rebuild may have read them before that write. So are "" for the derived
+// documents and the imported ones, whose filepath is a URL such as notion://<page id>: a
+// rebuild from the notes produces neither.
+func carriedOver(before, now map[string]string) []string {
+	paths := []string{""}
+	for path, fp := range now {
+		if path != "" && (before[path] != fp || strings.Contains(path, "://")) {
+			paths = append(paths, path)
+		}
+	}
+	for path := range before {
+		if _, ok := now[path]; !ok && path != "" {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
@@ -0,0 +1,138 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"vex-backend/vector"
+)
+
+// relatedCandidateFactor is how many chunks are fetched per requested note, since several
+// chunks of one note tend to match together
+const relatedCandidateFactor = 4
+
+// RelatedNote is a note similar to another one.
+type RelatedNote struct {
+	Filepath string `json:"filepath"`
+	Filename string `json:"filename"`
+	// Similarity is that of the note's best matching chunk
+	Similarity float32 `json:"similarity"`
+	// Chunks is how many of the note's chunks were among the matches
+	Chunks int `json:"matching_chunks"`
+	// Excerpt is the content of the best matching chunk
+	Excerpt string `json:"excerpt"`
+}
+
+// RelatedNotes returns up to k notes most similar to the note at path, best first. The note
+// is represented by the mean of its chunk embeddings; matches are grouped by file, so each
+// related note appears once. Chunks without a filepath (derived documents) are skipped.
+// The note must be indexed, otherwise vector.ErrNotFound is returned.
+func RelatedNotes(ctx context.Context, m Manager, path string, k int) ([]RelatedNote, error) {
+	if k <= 0 {
+		return nil, fmt.Errorf("k must be > 0")
+	}
+	chunks, err := m.GetChunksByFile(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	centroid := meanEmbedding(chunks)
+	if centroid == nil {
+		return nil, fmt.Errorf("no indexed chunks for %q: %w", path, vector.ErrNotFound)
+	}
+
+	total, err := m.Count(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// widen the search until enough distinct notes matched or the whole collection was seen
+	n := (k + len(chunks)) * relatedCandidateFactor
+	for {
+		if n > total {
+			n = total
+		}
+		results, err := m.RetriveNVectorsByEmbedding(ctx, centroid, n, nil)
+		if err != nil {
+			return nil, err
+		}
+		notes := groupByNote(results, path)
+		if len(notes) >= k || n >= total {
+			if len(notes) > k {
+				notes = notes[:k]
+			}
+			return notes, nil
+		}
+		n *= 2
+	}
+}
+
+// meanEmbedding averages the normalized embeddings of chunks, ignoring any whose length
+// differs from the first. It returns nil if no chunk has an embedding.
+func meanEmbedding(chunks []vector.VectorData) []float32 {
+	var sum []float64
+	count := 0
+	for _, c := range chunks {
+		if len(c.Embedding) == 0 || (sum != nil && len(c.Embedding) != len(sum)) {
+			continue
+		}
+		if sum == nil {
+			sum = make([]float64, len(c.Embedding))
+		}
+		var norm float64
+		for _, x := range c.Embedding {
+			norm += float64(x) * float64(x)
+		}
+		if norm == 0 {
+			continue
+		}
+		norm = math.Sqrt(norm)
+		for i, x := range c.Embedding {
+			sum[i] += float64(x) / norm
+		}
+		count++
+	}
+	if count == 0 {
+		return nil
+	}
+
+	out := make([]float32, len(sum))
+	for i, x := range sum {
+		out[i] = float32(x / float64(count))
+	}
+	return out
+}
+
+// groupByNote collapses matching chunks into one entry per note, skipping the note at
+// exclude and chunks that don't belong to a note, ordered by best similarity.
+func groupByNote(results []vector.VectorData, exclude string) []RelatedNote {
+	byFile := map[string]*RelatedNote{}
+	for _, r := range results {
+		path := r.Metadata["filepath"]
+		if path == "" || path == exclude {
+			continue
+		}
+		note, ok := byFile[path]
+		if !ok {
+			note = &RelatedNote{Filepath: path, Filename: r.Metadata["filename"]}
+			byFile[path] = note
+		}
+		note.Chunks++
+		if note.Chunks == 1 || r.Similarity > note.Similarity {
+			note.Similarity = r.Similarity
+			note.Excerpt = r.Content
+		}
+	}
+
+	out := make([]RelatedNote, 0, len(byFile))
+	for _, note := range byFile {
+		out = append(out, *note)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Similarity != out[j].Similarity {
+			return out[i].Similarity > out[j].Similarity
+		}
+		return out[i].Filepath < out[j].Filepath
+	})
+	return out
+}
@@ -0,0 +1,381 @@
+package manager
+
+import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"time"
+	"vex-backend/vector"
+	"vex-backend/vector/embed"
+)
+
+// chunkStore is where a manager backed by a database server keeps one collection: its live
+// chunks and its trash. The logic those managers share, storing with deduplication, replacing
+// a file's chunks, the trash and deduplicating, is written against it once, and each backend
+// implements it on its client. Callers hold the manager's lock.
+type chunkStore interface {
+	// chunks returns the live chunks matching where, all of them for an empty where
+	chunks(ctx context.Context, where map[string]string) ([]vector.VectorData, error)
+	// nearest returns the n live chunks matching where most similar to embedding, with
+	// their similarity
+	nearest(ctx context.Context, embedding []float32, where map[string]string, n int) ([]vector.VectorData, error)
+	put(ctx context.Context, vs []vector.VectorData) error
+	deleteIDs(ctx context.Context, ids []string) error
+	// deleteWhere deletes the live chunks matching a non-empty where
+	deleteWhere(ctx context.Context, where map[string]string) error
+
+	trashed(ctx context.Context) ([]vector.VectorData, error)
+	putTrash(ctx context.Context, vs []vector.VectorData) error
+	deleteTrashIDs(ctx context.Context, ids []string) error
+}
+
+// listChunks returns the live chunks of s matching where, in their order within each file.
+func listChunks(ctx context.Context, s chunkStore, where map[string]string) ([]vector.VectorData, error) {
+	out, err := s.chunks(ctx, where)
+	if err != nil {
+		return nil, err
+	}
+	if out == nil {
+		out = []vector.VectorData{}
+	}
+	sortByPosition(out)
+	return out, nil
+}
+
+// listTrash returns the trashed chunks of s, in their order within each file.
+func listTrash(ctx context.Context, s chunkStore) ([]vector.VectorData, error) {
+	out, err := s.trashed(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if out == nil {
+		out = []vector.VectorData{}
+	}
+	sortByPosition(out)
+	return out, nil
+}
+
+// isDuplicateIn reports whether v duplicates a chunk of the same file stored in s, like
+// chromemManager.isDuplicate: same content hash, or, with a threshold > 0, an embedding at
+// least that similar.
+func isDuplicateIn(ctx context.Context, s chunkStore, v vector.VectorData, hash string, threshold float32) (bool, error) {
+	path := v.Metadata["filepath"]
+	exact, err := s.chunks(ctx, map[string]string{"filepath": path, ContentHashMetadataKey: hash})
+	if err != nil {
+		return false, err
+	}
+	if len(exact) > 0 {
+		return true, nil
+	}
+
+	if threshold <= 0 || len(v.Embedding) == 0 {
+		return false, nil
+	}
+	nearest, err := s.nearest(ctx, v.Embedding, map[string]string{"filepath": path}, 1)
+	if err != nil {
+		return false, err
+	}
+	return len(nearest) > 0 && nearest[0].Similarity >= threshold, nil
+}
+
+// storeChunks stores vs in s in one write, with the duplicate handling of the chromem
+// manager, embedding the chunks that come without an embedding with e. It returns the IDs
+// sent to s, so a failed batch can be rolled back; tag names the manager in the log.
+func storeChunks(ctx context.Context, s chunkStore, e embed.Embedder, threshold float32, vs []vector.VectorData, tag string) ([]string, error) {
+	// duplicates are looked for within a file, so both are keyed by its path
+	seen := map[string]bool{}
+	kept := map[string][][]float32{}
+	var batch []vector.VectorData
+
+	for _, v := range vs {
+		path, hash := v.Metadata["filepath"], contentHash(v.Content)
+		if seen[path+"\x00"+hash] {
+			continue
+		}
+		if len(v.Embedding) == 0 {
+			embedding, err := e.EmbedToVector(ctx, v.Content)
+			if err != nil {
+				return nil, err
+			}
+			v.Embedding = embedding
+		}
+
+		dup, err := isDuplicateIn(ctx, s, v, hash, threshold)
+		if err != nil {
+			return nil, err
+		}
+		if !dup && threshold > 0 {
+			for _, k := range kept[path] {
+				if cosineSimilarity(k, v.Embedding) >= threshold {
+					dup = true
+					break
+				}
+			}
+		}
+		if dup {
+			log.Printf("[%s] skipping duplicate chunk %s", tag, v.Id)
+			continue
+		}
+
+		// chunks of one file share their metadata map, so copy it before adding the hash
+		metadata := make(map[string]string, len(v.Metadata)+1)
+		for k, val := range v.Metadata {
+			metadata[k] = val
+		}
+		metadata[ContentHashMetadataKey] = hash
+		v.Metadata = metadata
+		v.Similarity = 0
+
+		seen[path+"\x00"+hash] = true
+		kept[path] = append(kept[path], v.Embedding)
+		batch = append(batch, v)
+	}
+
+	ids := make([]string, 0, len(batch))
+	for _, v := range batch {
+		ids = append(ids, v.Id)
+	}
+	return ids, s.put(ctx, batch)
+}
+
+// replaceFileChunks swaps the stored chunks of path in s for vs, the file's new chunks, the
+// way the chromem manager's ReplaceFileVectorsInDB does: the chunks that went away are
+// trashed with softDelete and deleted otherwise, then vs are stored with store, and on
+// failure the previous chunks are put back.
+func replaceFileChunks(ctx context.Context, s chunkStore, path string, vs []vector.VectorData, softDelete bool, store func(context.Context, []vector.VectorData) ([]string, error), tag string) error {
+	// the file may have been replaced by another sync while this one was embedding
+	previous, err := s.chunks(ctx, map[string]string{"filepath": path})
+	if err != nil {
+		return err
+	}
+	removed, kept, unchanged := diffChunks(previous, vs)
+	if len(previous) > 0 {
+		log.Printf("[%s] %s: %d of %d chunks unchanged, %d removed", tag, path, unchanged, len(vs), len(removed))
+	}
+
+	// the old chunks go first, otherwise unchanged chunks would be skipped as duplicates
+	trashedAt := ""
+	if softDelete {
+		trashedAt = deletionTime(time.Now())
+		if err = moveToTrash(ctx, s, removed, trashedAt); err == nil {
+			err = s.deleteIDs(ctx, kept)
+		}
+	} else {
+		err = s.deleteWhere(ctx, map[string]string{"filepath": path})
+	}
+	if err != nil {
+		return err
+	}
+
+	added, err := store(ctx, vs)
+	if err == nil {
+		return nil
+	}
+
+	log.Printf("[%s] storing %s failed, rolling back: %v", tag, path, err)
+	if delErr := s.deleteIDs(ctx, added); delErr != nil {
+		return errors.Join(err, fmt.Errorf("rollback failed to delete new chunks: %w", delErr))
+	}
+	if addErr := s.put(ctx, previous); addErr != nil {
+		return errors.Join(err, fmt.Errorf("rollback failed to restore previous chunks: %w", addErr))
+	}
+	if trashedAt != "" && len(removed) > 0 {
+		ids := make([]string, 0, len(removed))
+		for _, v := range removed {
+			ids = append(ids, tombstone(v, trashedAt).Id)
+		}
+		if delErr := s.deleteTrashIDs(ctx, ids); delErr != nil {
+			log.Printf("[%s] warning: failed to clear rolled back chunks of %s from the trash: %v", tag, path, delErr)
+		}
+	}
+	return err
+}
+
+// moveToTrash tombstones vs with the deletion time at, adds them to the trash of s and
+// removes them from its live chunks.
+func moveToTrash(ctx context.Context, s chunkStore, vs []vector.VectorData, at string) error {
+	if len(vs) == 0 {
+		return nil
+	}
+	trashed := make([]vector.VectorData, 0, len(vs))
+	ids := make([]string, 0, len(vs))
+	for _, v := range vs {
+		trashed = append(trashed, tombstone(v, at))
+		ids = append(ids, v.Id)
+	}
+	if err := s.putTrash(ctx, trashed); err != nil {
+		return fmt.Errorf("failed to move chunks to the trash: %w", err)
+	}
+	return s.deleteIDs(ctx, ids)
+}
+
+// trashWhere moves the live chunks of s with the metadata key=data to its trash, returning
+// how many.
+func trashWhere(ctx context.Context, s chunkStore, key, data string) (int, error) {
+	vs, err := s.chunks(ctx, map[string]string{key: data})
+	if err != nil {
+		return 0, err
+	}
+	if err := moveToTrash(ctx, s, vs, deletionTime(time.Now())); err != nil {
+		return 0, err
+	}
+	return len(vs), nil
+}
+
+// restoreFromTrash implements Manager.RestoreFromTrash on s.
+func restoreFromTrash(ctx context.Context, s chunkStore, path string, deletedAt string) (int, error) {
+	trashed, err := listTrash(ctx, s)
+	if err != nil {
+		return 0, err
+	}
+	if deletedAt == "" {
+		deletedAt = latestDeletion(trashed, path)
+	}
+
+	var restore []vector.VectorData
+	var trashIDs []string
+	for _, v := range trashed {
+		if v.Metadata["filepath"] == path && deletedAt != "" && v.Metadata[DeletedAtMetadataKey] == deletedAt {
+			restore = append(restore, untombstone(v))
+			trashIDs = append(trashIDs, v.Id)
+		}
+	}
+	if len(restore) == 0 {
+		return 0, fmt.Errorf("no deleted chunks of %q in the trash: %w", path, vector.ErrNotFound)
+	}
+
+	// the current chunks are trashed in exchange, so the restore itself can be undone
+	if _, err := trashWhere(ctx, s, "filepath", path); err != nil {
+		return 0, err
+	}
+	if err := s.put(ctx, restore); err != nil {
+		return 0, fmt.Errorf("failed to restore chunks: %w", err)
+	}
+	if err := s.deleteTrashIDs(ctx, trashIDs); err != nil {
+		return 0, err
+	}
+	return len(restore), nil
+}
+
+// purgeTrash implements Manager.PurgeTrash on s.
+func purgeTrash(ctx context.Context, s chunkStore, before time.Time) (int, error) {
+	trashed, err := s.trashed(ctx)
+	if err != nil {
+		return 0, err
+	}
+	cutoff := deletionTime(before)
+	var ids []string
+	for _, v := range trashed {
+		if v.Metadata[DeletedAtMetadataKey] < cutoff {
+			ids = append(ids, v.Id)
+		}
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	if err := s.deleteTrashIDs(ctx, ids); err != nil {
+		return 0, err
+	}
+	return len(ids), nil
+}
+
+// exportSnapshot writes the live chunks and the trash of s in the snapshot format of the
+// in-memory manager.
+func exportSnapshot(ctx context.Context, s chunkStore, w io.Writer) error {
+	snap := memorySnapshot{Docs: map[string]vector.VectorData{}, Trash: map[string]vector.VectorData{}}
+	docs, err := s.chunks(ctx, nil)
+	if err != nil {
+		return err
+	}
+	for _, v := range docs {
+		snap.Docs[v.Id] = v
+	}
+	trashed, err := s.trashed(ctx)
+	if err != nil {
+		return err
+	}
+	for _, v := range trashed {
+		snap.Trash[v.Id] = v
+	}
+	return gob.NewEncoder(w).Encode(snap)
+}
+
+// carryOver copies the files written to live while a Reindex rebuilt staging (see
+// carriedOver) from live to staging, before staging is swapped in. before are the
+// fingerprints of live when the rebuild started.
+func carryOver(ctx context.Context, live, staging chunkStore, before map[string]string, tag string) error {
+	current, err := live.chunks(ctx, nil)
+	if err != nil {
+		return err
+	}
+	carried := carriedOver(before, fileFingerprints(current))
+	byFile := map[string][]vector.VectorData{}
+	for _, v := range current {
+		byFile[v.Metadata["filepath"]] = append(byFile[v.Metadata["filepath"]], v)
+	}
+	for _, path := range carried {
+		if path != "" {
+			if err := staging.deleteWhere(ctx, map[string]string{"filepath": path}); err != nil {
+				return err
+			}
+		}
+		if err := staging.put(ctx, byFile[path]); err != nil {
+			return err
+		}
+	}
+	if len(carried) > 1 {
+		log.Printf("[%s] reindex: carried over %d files written during the rebuild", tag, len(carried)-1)
+	}
+	return nil
+}
+
+// deduplicate implements Manager.DeduplicateVectors on s: within each file, the chunk with
+// the lowest ID of each group of duplicates survives.
+func deduplicate(ctx context.Context, s chunkStore, threshold float32) (int, error) {
+	docs, err := s.chunks(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	// sort for a deterministic choice of which duplicate survives
+	sort.Slice(docs, func(i, j int) bool { return docs[i].Id < docs[j].Id })
+
+	// duplicates are only looked for within a file, as when storing: seen is keyed by
+	// filepath and content hash, kept by filepath
+	seen := map[string]bool{}
+	kept := map[string][][]float32{}
+	var remove []string
+	for _, d := range docs {
+		path := d.Metadata["filepath"]
+		hash := d.Metadata[ContentHashMetadataKey]
+		if hash == "" {
+			hash = contentHash(d.Content)
+		}
+		key := path + "\x00" + hash
+
+		dup := seen[key]
+		if !dup && threshold > 0 {
+			for _, e := range kept[path] {
+				if cosineSimilarity(e, d.Embedding) >= threshold {
+					dup = true
+					break
+				}
+			}
+		}
+		if dup {
+			remove = append(remove, d.Id)
+			continue
+		}
+
+		seen[key] = true
+		kept[path] = append(kept[path], d.Embedding)
+	}
+
+	if err := s.deleteIDs(ctx, remove); err != nil {
+		return 0, err
+	}
+	return len(remove), nil
+}
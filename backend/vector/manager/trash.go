@@ -0,0 +1,125 @@
+package manager
+
+import (
+	"context"
+	"log"
+	"sort"
+	"time"
+	"vex-backend/config"
+	"vex-backend/vector"
+)
+
+const (
+	// DeletedAtMetadataKey is the tombstone set on chunks in the trash: when they were deleted
+	DeletedAtMetadataKey = "deleted_at"
+	// originalIDMetadataKey keeps a trashed chunk's ID, since several deleted generations
+	// of one file may hold chunks with the same ID
+	originalIDMetadataKey = "original_id"
+
+	// trashPurgeInterval is how often RunTrashPurge looks for expired chunks
+	trashPurgeInterval = time.Hour
+)
+
+// TrashEntry is one deleted generation of a file: the chunks removed from it at one time.
+type TrashEntry struct {
+	Filepath  string `json:"filepath"`
+	DeletedAt string `json:"deleted_at"`
+	Chunks    int    `json:"chunks"`
+	// ExpiresAt is when the purge job will drop the entry, if known
+	ExpiresAt string `json:"expires_at,omitempty"`
+}
+
+// GroupTrash groups trashed chunks into entries per file and deletion time, newest first,
+// with the expiry implied by retention.
+func GroupTrash(chunks []vector.VectorData, retention time.Duration) []TrashEntry {
+	type key struct{ path, at string }
+	counts := map[key]int{}
+	for _, c := range chunks {
+		counts[key{c.Metadata["filepath"], c.Metadata[DeletedAtMetadataKey]}]++
+	}
+
+	out := make([]TrashEntry, 0, len(counts))
+	for k, n := range counts {
+		entry := TrashEntry{Filepath: k.path, DeletedAt: k.at, Chunks: n}
+		if at, err := time.Parse(time.RFC3339Nano, k.at); err == nil {
+			entry.ExpiresAt = at.Add(retention).UTC().Format(time.RFC3339)
+		}
+		out = append(out, entry)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].DeletedAt != out[j].DeletedAt {
+			return out[i].DeletedAt > out[j].DeletedAt
+		}
+		return out[i].Filepath < out[j].Filepath
+	})
+	return out
+}
+
+// tombstone returns a copy of v marked as deleted at the given time, with a trash ID that
+// can't collide with another generation of the same chunk.
+func tombstone(v vector.VectorData, at string) vector.VectorData {
+	metadata := make(map[string]string, len(v.Metadata)+2)
+	for k, val := range v.Metadata {
+		metadata[k] = val
+	}
+	metadata[DeletedAtMetadataKey] = at
+	metadata[originalIDMetadataKey] = v.Id
+	v.Metadata = metadata
+	v.Id = at + "/" + v.Id
+	v.Similarity = 0
+	return v
+}
+
+// untombstone reverses tombstone.
+func untombstone(v vector.VectorData) vector.VectorData {
+	metadata := make(map[string]string, len(v.Metadata))
+	for k, val := range v.Metadata {
+		metadata[k] = val
+	}
+	if id := metadata[originalIDMetadataKey]; id != "" {
+		v.Id = id
+	}
+	delete(metadata, DeletedAtMetadataKey)
+	delete(metadata, originalIDMetadataKey)
+	v.Metadata = metadata
+	return v
+}
+
+// latestDeletion returns the newest deletion time among trashed chunks of path, or "".
+func latestDeletion(trash []vector.VectorData, path string) string {
+	latest := ""
+	for _, v := range trash {
+		if v.Metadata["filepath"] == path && v.Metadata[DeletedAtMetadataKey] > latest {
+			latest = v.Metadata[DeletedAtMetadataKey]
+		}
+	}
+	return latest
+}
+
+// deletionTime formats now as a tombstone. The fixed-width UTC format sorts chronologically
+// as a string.
+func deletionTime(now time.Time) string {
+	return now.UTC().Format("2006-01-02T15:04:05.000000000Z")
+}
+
+// RunTrashPurge hard-deletes trashed chunks older than SOFT_DELETE_RETENTION every hour
+// until ctx is done. The retention is re-read on every run, so it follows config reloads.
+func RunTrashPurge(ctx context.Context, cfg config.Source, m Manager) {
+	ticker := time.NewTicker(trashPurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := m.PurgeTrash(ctx, time.Now().Add(-cfg().SoftDeleteRetention))
+		if err != nil {
+			log.Printf("[Trash] purge failed: %v", err)
+		} else if purged > 0 {
+			log.Printf("[Trash] purged %d expired chunks", purged)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
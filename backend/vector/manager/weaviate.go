@@ -0,0 +1,431 @@
+package manager
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"vex-backend/httpclient"
+	"vex-backend/vector"
+)
+
+const (
+	// weaviateBatchSize is how many objects are sent per batch request
+	weaviateBatchSize = 100
+	// weaviatePageSize is how many objects are listed per request
+	weaviatePageSize = 500
+	// weaviateMaxResults is Weaviate's default QUERY_MAXIMUM_RESULTS, the most objects a
+	// filtered query or batch deletion touches at once
+	weaviateMaxResults = 10000
+)
+
+// weaviateClient is a minimal client of the Weaviate REST and GraphQL APIs, covering what
+// weaviateManager needs: the schema, batch import and deletion, listing objects, and
+// nearVector and hybrid search.
+type weaviateClient struct {
+	baseURL string
+	apiKey  string
+	http    httpclient.Doer
+}
+
+// weaviateProperties are the properties of every object. Chunk IDs are kept in chunk_id,
+// since object IDs must be UUIDs. metadata holds the chunk's metadata as JSON, and meta the
+// same pairs as "key=value" strings, which exact-match filters test with ContainsAll.
+type weaviateProperties struct {
+	ChunkID  string   `json:"chunk_id"`
+	Content  string   `json:"content"`
+	Metadata string   `json:"metadata"`
+	Meta     []string `json:"meta"`
+}
+
+// weaviateObject is an object as the REST API sends and receives it.
+type weaviateObject struct {
+	Class      string             `json:"class,omitempty"`
+	ID         string             `json:"id"`
+	Properties weaviateProperties `json:"properties"`
+	Vector     []float32          `json:"vector,omitempty"`
+}
+
+// weaviateClass is a class of the schema, as far as the manager reads it.
+type weaviateClass struct {
+	Class       string `json:"class"`
+	Description string `json:"description"`
+}
+
+// weaviateObjectID derives the UUID of the object holding the chunk with the given ID: a
+// version 5 style UUID from the SHA-1 of the ID, so the same chunk always maps to the same
+// object.
+func weaviateObjectID(chunkID string) string {
+	sum := sha1.Sum([]byte("vex-chunk:" + chunkID))
+	sum[6] = (sum[6] & 0x0f) | 0x50
+	sum[8] = (sum[8] & 0x3f) | 0x80
+	h := fmt.Sprintf("%x", sum[:16])
+	return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:32]
+}
+
+// metaPairs returns the "key=value" strings of metadata, sorted.
+func metaPairs(metadata map[string]string) []string {
+	pairs := make([]string, 0, len(metadata))
+	for k, v := range metadata {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return pairs
+}
+
+func toWeaviateObject(class string, v vector.VectorData) (weaviateObject, error) {
+	metadata, err := json.Marshal(v.Metadata)
+	if err != nil {
+		return weaviateObject{}, err
+	}
+	return weaviateObject{
+		Class: class,
+		ID:    weaviateObjectID(v.Id),
+		Properties: weaviateProperties{
+			ChunkID:  v.Id,
+			Content:  v.Content,
+			Metadata: string(metadata),
+			Meta:     metaPairs(v.Metadata),
+		},
+		Vector: v.Embedding,
+	}, nil
+}
+
+func (p weaviateProperties) vectorData(embedding []float32) vector.VectorData {
+	metadata := map[string]string{}
+	if p.Metadata != "" {
+		// written by toWeaviateObject; a foreign object just comes back without metadata
+		_ = json.Unmarshal([]byte(p.Metadata), &metadata)
+	}
+	return vector.VectorData{Id: p.ChunkID, Content: p.Content, Embedding: embedding, Metadata: metadata}
+}
+
+// weaviateError maps a failed request onto the shared sentinel errors where one applies.
+func weaviateError(status int, body []byte) error {
+	msg := strings.TrimSpace(string(body))
+	switch {
+	case status == http.StatusTooManyRequests:
+		return fmt.Errorf("%w: weaviate returned status %d: %s", vector.ErrRateLimited, status, msg)
+	case status == http.StatusNotFound:
+		return fmt.Errorf("weaviate: %w", vector.ErrNotFound)
+	case strings.Contains(msg, "vector lengths don't match") || strings.Contains(msg, "has a vector with length"):
+		return fmt.Errorf("%w: %s", vector.ErrDimensionMismatch, msg)
+	}
+	return fmt.Errorf("weaviate returned status %d: %s", status, msg)
+}
+
+// do sends a request with body encoded as JSON, if not nil, and decodes the response into
+// out, if not nil.
+func (c *weaviateClient) do(ctx context.Context, method, path string, body, out any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("weaviate request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	respBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read weaviate response: %w", err)
+	}
+	if resp.StatusCode >= 300 {
+		return weaviateError(resp.StatusCode, respBytes)
+	}
+	if out == nil || len(respBytes) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(respBytes, out); err != nil {
+		return fmt.Errorf("failed to decode weaviate response: %w", err)
+	}
+	return nil
+}
+
+// graphql runs a GraphQL query and decodes its data into out.
+func (c *weaviateClient) graphql(ctx context.Context, query string, out any) error {
+	var resp struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/v1/graphql", map[string]string{"query": query}, &resp); err != nil {
+		return err
+	}
+	if len(resp.Errors) > 0 {
+		msgs := make([]string, 0, len(resp.Errors))
+		for _, e := range resp.Errors {
+			msgs = append(msgs, e.Message)
+		}
+		return weaviateError(http.StatusUnprocessableEntity, []byte(strings.Join(msgs, "; ")))
+	}
+	return json.Unmarshal(resp.Data, out)
+}
+
+// schema functions
+func (c *weaviateClient) classes(ctx context.Context) ([]weaviateClass, error) {
+	var resp struct {
+		Classes []weaviateClass `json:"classes"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/v1/schema", nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Classes, nil
+}
+
+// createClass creates a class for chunks with the properties of weaviateProperties. Vectors
+// are supplied by the manager and compared by cosine distance.
+func (c *weaviateClient) createClass(ctx context.Context, class, description string) error {
+	no := false
+	return c.do(ctx, http.MethodPost, "/v1/schema", map[string]any{
+		"class":             class,
+		"description":       description,
+		"vectorizer":        "none",
+		"vectorIndexConfig": map[string]any{"distance": "cosine"},
+		"properties": []map[string]any{
+			{"name": "chunk_id", "dataType": []string{"text"}, "tokenization": "field"},
+			// searchable, for the keyword half of hybrid queries
+			{"name": "content", "dataType": []string{"text"}, "tokenization": "word"},
+			{"name": "metadata", "dataType": []string{"text"}, "indexFilterable": &no, "indexSearchable": &no},
+			{"name": "meta", "dataType": []string{"text[]"}, "tokenization": "field"},
+		},
+	}, nil)
+}
+
+// setDescription replaces the description of class, leaving the rest of its definition.
+func (c *weaviateClient) setDescription(ctx context.Context, class, description string) error {
+	var def map[string]any
+	if err := c.do(ctx, http.MethodGet, "/v1/schema/"+url.PathEscape(class), nil, &def); err != nil {
+		return err
+	}
+	def["description"] = description
+	return c.do(ctx, http.MethodPut, "/v1/schema/"+url.PathEscape(class), def, nil)
+}
+
+func (c *weaviateClient) deleteClass(ctx context.Context, class string) error {
+	return c.do(ctx, http.MethodDelete, "/v1/schema/"+url.PathEscape(class), nil, nil)
+}
+
+// object functions
+
+// add stores vs in class as they are, weaviateBatchSize objects per request. Chunks with
+// an ID that is already stored replace it.
+func (c *weaviateClient) add(ctx context.Context, class string, vs []vector.VectorData) error {
+	for start := 0; start < len(vs); start += weaviateBatchSize {
+		batch := vs[start:min(start+weaviateBatchSize, len(vs))]
+		objects := make([]weaviateObject, 0, len(batch))
+		for _, v := range batch {
+			o, err := toWeaviateObject(class, v)
+			if err != nil {
+				return err
+			}
+			objects = append(objects, o)
+		}
+
+		var results []struct {
+			ID     string `json:"id"`
+			Result struct {
+				Errors *struct {
+					Error []struct {
+						Message string `json:"message"`
+					} `json:"error"`
+				} `json:"errors"`
+			} `json:"result"`
+		}
+		if err := c.do(ctx, http.MethodPost, "/v1/batch/objects", map[string]any{"objects": objects}, &results); err != nil {
+			return err
+		}
+		for _, r := range results {
+			if r.Result.Errors != nil && len(r.Result.Errors.Error) > 0 {
+				return weaviateError(http.StatusUnprocessableEntity, []byte(r.Result.Errors.Error[0].Message))
+			}
+		}
+	}
+	return nil
+}
+
+// get returns the chunk with the given ID, vector.ErrNotFound if there is none.
+func (c *weaviateClient) get(ctx context.Context, class, chunkID string) (vector.VectorData, error) {
+	var o weaviateObject
+	path := "/v1/objects/" + url.PathEscape(class) + "/" + weaviateObjectID(chunkID) + "?include=vector"
+	if err := c.do(ctx, http.MethodGet, path, nil, &o); err != nil {
+		return vector.VectorData{}, err
+	}
+	return o.Properties.vectorData(o.Vector), nil
+}
+
+// list returns every chunk in class, paging through it with a cursor.
+func (c *weaviateClient) list(ctx context.Context, class string) ([]vector.VectorData, error) {
+	var out []vector.VectorData
+	after := ""
+	for {
+		q := url.Values{"class": {class}, "limit": {strconv.Itoa(weaviatePageSize)}, "include": {"vector"}}
+		if after != "" {
+			q.Set("after", after)
+		}
+		var resp struct {
+			Objects []weaviateObject `json:"objects"`
+		}
+		if err := c.do(ctx, http.MethodGet, "/v1/objects?"+q.Encode(), nil, &resp); err != nil {
+			return nil, err
+		}
+		for _, o := range resp.Objects {
+			out = append(out, o.Properties.vectorData(o.Vector))
+		}
+		if len(resp.Objects) < weaviatePageSize {
+			return out, nil
+		}
+		after = resp.Objects[len(resp.Objects)-1].ID
+	}
+}
+
+// deleteWhere deletes every object of class matching the REST filter where.
+func (c *weaviateClient) deleteWhere(ctx context.Context, class string, where map[string]any) error {
+	for {
+		var resp struct {
+			Results struct {
+				Matches int `json:"matches"`
+				Limit   int `json:"limit"`
+				Failed  int `json:"failed"`
+			} `json:"results"`
+		}
+		body := map[string]any{"match": map[string]any{"class": class, "where": where}, "output": "minimal"}
+		if err := c.do(ctx, http.MethodDelete, "/v1/batch/objects", body, &resp); err != nil {
+			return err
+		}
+		if resp.Results.Failed > 0 {
+			return fmt.Errorf("weaviate failed to delete %d objects", resp.Results.Failed)
+		}
+		// a deletion stops at the query limit; what is left over matches again
+		if resp.Results.Limit == 0 || resp.Results.Matches < resp.Results.Limit {
+			return nil
+		}
+	}
+}
+
+// deleteIDs deletes the chunks with the given IDs from class.
+func (c *weaviateClient) deleteIDs(ctx context.Context, class string, ids []string) error {
+	for start := 0; start < len(ids); start += weaviateMaxResults {
+		batch := ids[start:min(start+weaviateMaxResults, len(ids))]
+		uuids := make([]string, 0, len(batch))
+		for _, id := range batch {
+			uuids = append(uuids, weaviateObjectID(id))
+		}
+		where := map[string]any{"path": []string{"id"}, "operator": "ContainsAny", "valueTextArray": uuids}
+		if err := c.deleteWhere(ctx, class, where); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restWhere turns an exact-match metadata filter into a REST filter; nil if where is empty.
+func restWhere(where map[string]string) map[string]any {
+	if len(where) == 0 {
+		return nil
+	}
+	return map[string]any{"path": []string{"meta"}, "operator": "ContainsAll", "valueTextArray": metaPairs(where)}
+}
+
+// graphqlWhere turns an exact-match metadata filter into a GraphQL where argument,
+// followed by a comma; "" if where is empty.
+func graphqlWhere(where map[string]string) string {
+	if len(where) == 0 {
+		return ""
+	}
+	return fmt.Sprintf(`where: {path: ["meta"], operator: ContainsAll, valueText: %s}, `, graphqlValue(metaPairs(where)))
+}
+
+// graphqlValue encodes v as a GraphQL literal. JSON strings, numbers and lists are valid
+// GraphQL as they are.
+func graphqlValue(v any) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+// search runs a GraphQL Get on class with the search argument (nearVector or hybrid, empty
+// for a plain filtered listing) and returns up to n chunks with their similarity: 1 minus
+// the cosine distance, or the fused score of a hybrid search.
+func (c *weaviateClient) search(ctx context.Context, class, search string, where map[string]string, n int) ([]vector.VectorData, error) {
+	query := fmt.Sprintf(`{ Get { %s(%s%slimit: %d) { chunk_id content metadata _additional { id distance score vector } } } }`,
+		class, search, graphqlWhere(where), n)
+	var data struct {
+		Get map[string][]struct {
+			weaviateProperties
+			Additional struct {
+				Distance *float32  `json:"distance"`
+				Score    string    `json:"score"`
+				Vector   []float32 `json:"vector"`
+			} `json:"_additional"`
+		}
+	}
+	if err := c.graphql(ctx, query, &data); err != nil {
+		return nil, err
+	}
+
+	hits := data.Get[class]
+	out := make([]vector.VectorData, 0, len(hits))
+	for _, h := range hits {
+		v := h.weaviateProperties.vectorData(h.Additional.Vector)
+		switch {
+		case h.Additional.Distance != nil:
+			v.Similarity = 1 - *h.Additional.Distance
+		case h.Additional.Score != "":
+			if score, err := strconv.ParseFloat(h.Additional.Score, 32); err == nil {
+				v.Similarity = float32(score)
+			}
+		}
+		out = append(out, v)
+	}
+	return out, nil
+}
+
+// nearVector and hybrid build the search argument of search.
+func nearVector(embedding []float32) string {
+	return fmt.Sprintf("nearVector: {vector: %s}, ", graphqlValue(embedding))
+}
+func hybrid(query string, embedding []float32, alpha float64) string {
+	return fmt.Sprintf("hybrid: {query: %s, vector: %s, alpha: %s}, ",
+		graphqlValue(query), graphqlValue(embedding), strconv.FormatFloat(alpha, 'g', -1, 64))
+}
+
+// count returns the number of objects in class.
+func (c *weaviateClient) count(ctx context.Context, class string) (int, error) {
+	var data struct {
+		Aggregate map[string][]struct {
+			Meta struct {
+				Count int `json:"count"`
+			} `json:"meta"`
+		}
+	}
+	if err := c.graphql(ctx, fmt.Sprintf(`{ Aggregate { %s { meta { count } } } }`, class), &data); err != nil {
+		return 0, err
+	}
+	if agg := data.Aggregate[class]; len(agg) > 0 {
+		return agg[0].Meta.Count, nil
+	}
+	return 0, nil
+}
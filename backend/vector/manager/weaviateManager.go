@@ -0,0 +1,650 @@
+package manager
+
+import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"vex-backend/config"
+	"vex-backend/httpclient"
+	"vex-backend/vector"
+	"vex-backend/vector/embed"
+)
+
+// Descriptions mark what a Weaviate class holds, followed by the name of its collection:
+// the live chunks, the trash, or the chunks of a Reindex or Import still being built. The
+// live class is found by its description, so swapping one in takes a description update.
+const (
+	weaviateLiveDescription    = "vex collection "
+	weaviateTrashDescription   = "vex trash "
+	weaviateStagingDescription = "vex staging "
+)
+
+// weaviateManager is a Manager storing the chunks in a Weaviate instance (VECTOR_BACKEND=
+// weaviate), one class per collection plus one for its trash. Vectors are computed by the
+// Embedder and sent along; the classes have no vectorizer of their own.
+type weaviateManager struct {
+	client   *weaviateClient
+	Embedder embed.Embedder
+	// Config supplies the reloadable settings (dedup threshold, hybrid alpha)
+	Config config.Source
+	// queries caches the embeddings of recent queries
+	queries *queryCache
+	// name is the collection the manager works on, class its live Weaviate class, which
+	// changes when Reindex or Import swap in a new one, and trash the class of its trash
+	name  string
+	class string
+	trash string
+	// reindexing allows one Reindex at a time
+	reindexing sync.Mutex
+	// collections holds the managers of all collections opened so far
+	collections *weaviateCollections
+
+	// mu makes every method atomic with respect to the others within this process, as for
+	// the chromem manager. Methods named ...Locked expect the caller to hold it.
+	mu sync.RWMutex
+}
+
+// NewWeaviateManager returns a Manager storing vectors in the Weaviate instance at
+// WEAVIATE_URL, creating the classes of VECTOR_COLLECTION if they don't exist yet.
+func NewWeaviateManager(cfg config.Source, e embed.Embedder, client httpclient.Doer) (Manager, error) {
+	c := cfg()
+	collections := &weaviateCollections{
+		client:   &weaviateClient{baseURL: strings.TrimRight(c.WeaviateURL, "/"), apiKey: c.WeaviateAPIKey, http: client},
+		prefix:   c.WeaviateClassPrefix,
+		embedder: e,
+		config:   cfg,
+		queries:  newQueryCache(cfg),
+		byName:   map[string]*weaviateManager{},
+	}
+	name := c.VectorCollection
+	if name == "" {
+		name = notesCollection
+	}
+
+	collections.mu.Lock()
+	defer collections.mu.Unlock()
+	wm, err := collections.openLocked(context.Background(), name, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the %s collection in weaviate: %w", name, err)
+	}
+	return wm, nil
+}
+
+// weaviateCollections holds the manager of every collection opened so far, shared by all of
+// them, so that each collection has a single manager and lock.
+type weaviateCollections struct {
+	client   *weaviateClient
+	prefix   string
+	embedder embed.Embedder
+	config   config.Source
+	queries  *queryCache
+
+	mu     sync.Mutex
+	byName map[string]*weaviateManager
+}
+
+// classOf returns the base Weaviate class of the named collection. Class names start with
+// a capital letter and hold letters, digits and '_' only, so '-' becomes '_'; two names
+// mapping to the same class can't both be created.
+func (c *weaviateCollections) classOf(name string) string {
+	return c.prefix + "_" + strings.ReplaceAll(name, "-", "_")
+}
+
+// openLocked returns a manager of the named collection, resolving its live and trash
+// classes. With create set, missing classes are created; otherwise a collection without a
+// live class is reported as vector.ErrNotFound. Classes of a Reindex or Import that didn't
+// finish are deleted. The caller holds c.mu.
+func (c *weaviateCollections) openLocked(ctx context.Context, name string, create bool) (*weaviateManager, error) {
+	classes, err := c.client.classes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var live []string
+	trash := ""
+	for _, cl := range classes {
+		switch cl.Description {
+		case weaviateLiveDescription + name:
+			live = append(live, cl.Class)
+		case weaviateTrashDescription + name:
+			trash = cl.Class
+		case weaviateStagingDescription + name:
+			if err := c.client.deleteClass(ctx, cl.Class); err != nil {
+				log.Printf("[weaviateManager] warning: failed to delete unfinished class %s: %v", cl.Class, err)
+			}
+		}
+	}
+	// a swap that was cut short leaves two live classes; the newer one won
+	sort.Strings(live)
+	for _, old := range live[:max(len(live)-1, 0)] {
+		if err := c.client.deleteClass(ctx, old); err != nil {
+			log.Printf("[weaviateManager] warning: failed to delete replaced class %s: %v", old, err)
+		}
+	}
+
+	base := c.classOf(name)
+	class := base
+	if len(live) > 0 {
+		class = live[len(live)-1]
+	} else if !create {
+		return nil, fmt.Errorf("collection %q: %w", name, vector.ErrNotFound)
+	} else if err := c.client.createClass(ctx, class, weaviateLiveDescription+name); err != nil {
+		return nil, err
+	}
+	// soft-deleted chunks live in their own class, so no query can match them
+	if trash == "" {
+		trash = base + "_trash"
+		if err := c.client.createClass(ctx, trash, weaviateTrashDescription+name); err != nil {
+			return nil, err
+		}
+	}
+
+	wm := &weaviateManager{
+		client:      c.client,
+		Embedder:    c.embedder,
+		Config:      c.config,
+		queries:     c.queries,
+		name:        name,
+		class:       class,
+		trash:       trash,
+		collections: c,
+	}
+	c.byName[name] = wm
+	return wm, nil
+}
+
+// stagingClass returns the name of a new class to build the next live class of the manager's
+// collection in.
+func (wm *weaviateManager) stagingClass() string {
+	return wm.collections.classOf(wm.name) + "_reindex_" + strconv.FormatInt(time.Now().UnixNano(), 10)
+}
+
+func (wm *weaviateManager) GetDBInstance() any {
+	return wm.client
+}
+func (wm *weaviateManager) GetEmbedder() embed.Embedder {
+	return wm.Embedder
+}
+func (wm *weaviateManager) EmbedQuery(ctx context.Context, query string, language string) ([]float32, error) {
+	return wm.queries.embed(ctx, wm.Embedder, query, language)
+}
+func (wm *weaviateManager) QueryCacheStats() QueryCacheStats {
+	return wm.queries.stats()
+}
+
+// MemoryUsage reports the vectors as remote: they live in the server.
+func (wm *weaviateManager) MemoryUsage(ctx context.Context) (MemoryUsage, error) {
+	return remoteMemoryUsage(ctx)
+}
+
+// softDelete reports whether SOFT_DELETE is currently enabled.
+func (wm *weaviateManager) softDelete() bool {
+	return wm.Config != nil && wm.Config().SoftDelete
+}
+
+// store returns the chunkStore of the manager: its live class and its trash class.
+func (wm *weaviateManager) store() chunkStore {
+	return weaviateStore{client: wm.client, class: wm.class, trash: wm.trash}
+}
+
+// weaviateStore is the chunkStore of a Weaviate class and the class of its trash.
+type weaviateStore struct {
+	client *weaviateClient
+	class  string
+	trash  string
+}
+
+// chunks lists the whole class without a filter, and lets Weaviate filter otherwise, up to
+// weaviateMaxResults chunks.
+func (s weaviateStore) chunks(ctx context.Context, where map[string]string) ([]vector.VectorData, error) {
+	if len(where) == 0 {
+		return s.client.list(ctx, s.class)
+	}
+	return s.client.search(ctx, s.class, "", where, weaviateMaxResults)
+}
+func (s weaviateStore) nearest(ctx context.Context, embedding []float32, where map[string]string, n int) ([]vector.VectorData, error) {
+	return s.client.search(ctx, s.class, nearVector(embedding), where, n)
+}
+func (s weaviateStore) put(ctx context.Context, vs []vector.VectorData) error {
+	return s.client.add(ctx, s.class, vs)
+}
+func (s weaviateStore) deleteIDs(ctx context.Context, ids []string) error {
+	return s.client.deleteIDs(ctx, s.class, ids)
+}
+func (s weaviateStore) deleteWhere(ctx context.Context, where map[string]string) error {
+	return s.client.deleteWhere(ctx, s.class, restWhere(where))
+}
+func (s weaviateStore) trashed(ctx context.Context) ([]vector.VectorData, error) {
+	return s.client.list(ctx, s.trash)
+}
+func (s weaviateStore) putTrash(ctx context.Context, vs []vector.VectorData) error {
+	return s.client.add(ctx, s.trash, vs)
+}
+func (s weaviateStore) deleteTrashIDs(ctx context.Context, ids []string) error {
+	return s.client.deleteIDs(ctx, s.trash, ids)
+}
+
+// storage functions
+func (wm *weaviateManager) StoreVectorInDB(ctx context.Context, v vector.VectorData) error {
+	return wm.StoreVectorsInDB(ctx, []vector.VectorData{v})
+}
+
+// StoreVectorsInDB stores the vectors in one batch import, with the same duplicate handling
+// as the chromem manager.
+func (wm *weaviateManager) StoreVectorsInDB(ctx context.Context, vs []vector.VectorData) error {
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+
+	_, err := wm.storeLocked(ctx, vs)
+	return err
+}
+
+// storeLocked is StoreVectorsInDB, also returning the IDs sent to Weaviate, so a failed
+// batch can be rolled back.
+func (wm *weaviateManager) storeLocked(ctx context.Context, vs []vector.VectorData) ([]string, error) {
+	return storeChunks(ctx, wm.store(), wm.Embedder, DedupSimilarityThresholdFrom(wm.Config()), vs, "weaviateManager")
+}
+func (wm *weaviateManager) StoreFileAsVectorsInDB(ctx context.Context, filename string) error {
+	path, metadata, err := fileMetadata(filename)
+	if err != nil {
+		return err
+	}
+
+	vs, err := wm.Embedder.EmbedFileToVectorData(ctx, path, metadata)
+	if err != nil {
+		return err
+	}
+	return wm.StoreVectorsInDB(ctx, vs)
+}
+
+// ReplaceFileVectorsInDB works like the chromem manager's: the file is embedded first,
+// reusing the embeddings of unchanged chunks, then its stored chunks are swapped for the new
+// ones, and restored if storing fails.
+func (wm *weaviateManager) ReplaceFileVectorsInDB(ctx context.Context, filename string) error {
+	path, metadata, err := fileMetadata(filename)
+	if err != nil {
+		return err
+	}
+	reusable, err := wm.GetChunksByFile(ctx, path)
+	if err != nil {
+		return err
+	}
+
+	vs, err := wm.Embedder.EmbedFileToVectorData(embed.WithPrevious(ctx, reusable), path, metadata)
+	if err != nil {
+		return err
+	}
+
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+
+	return replaceFileChunks(ctx, wm.store(), path, vs, wm.softDelete(), wm.storeLocked, "weaviateManager")
+}
+
+// retrieval functions
+func (wm *weaviateManager) RetriveVectorByMetadata(ctx context.Context, key string, data string) (vector.VectorData, error) {
+	vs, err := wm.GetByMetadata(ctx, map[string]string{key: data})
+	if err != nil {
+		return vector.VectorData{}, err
+	}
+	if len(vs) == 0 {
+		return vector.VectorData{}, fmt.Errorf("no document with metadata %s=%s: %w", key, data, vector.ErrNotFound)
+	}
+	return vs[0], nil
+}
+func (wm *weaviateManager) RetriveVectorWithID(ctx context.Context, id string) (vector.VectorData, error) {
+	return wm.GetByID(ctx, id)
+}
+func (wm *weaviateManager) GetByID(ctx context.Context, id string) (vector.VectorData, error) {
+	wm.mu.RLock()
+	defer wm.mu.RUnlock()
+
+	v, err := wm.client.get(ctx, wm.class, id)
+	if errors.Is(err, vector.ErrNotFound) {
+		return vector.VectorData{}, fmt.Errorf("document %q: %w", id, vector.ErrNotFound)
+	}
+	return v, err
+}
+func (wm *weaviateManager) GetByMetadata(ctx context.Context, where map[string]string) ([]vector.VectorData, error) {
+	wm.mu.RLock()
+	defer wm.mu.RUnlock()
+
+	return wm.getByMetadataLocked(ctx, where)
+}
+func (wm *weaviateManager) getByMetadataLocked(ctx context.Context, where map[string]string) ([]vector.VectorData, error) {
+	return listChunks(ctx, wm.store(), where)
+}
+func (wm *weaviateManager) GetChunksByFile(ctx context.Context, path string) ([]vector.VectorData, error) {
+	return wm.GetByMetadata(ctx, map[string]string{"filepath": path})
+}
+func (wm *weaviateManager) RetriveNVectorsByQuery(ctx context.Context, query string, n int) ([]vector.VectorData, error) {
+	return wm.RetriveNVectorsByQueryWithFilter(ctx, query, n, nil)
+}
+
+// RetriveNVectorsByQueryWithFilter searches by the query's embedding, blended with a keyword
+// search of the chunks' content when WEAVIATE_HYBRID_ALPHA is below 1.
+func (wm *weaviateManager) RetriveNVectorsByQueryWithFilter(ctx context.Context, query string, n int, where map[string]string) ([]vector.VectorData, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("n must be > 0")
+	}
+	if query == "" {
+		return nil, errors.New("query failed: query is empty")
+	}
+	// an empty collection fails without spending an embedding on the query
+	if count, err := wm.Count(ctx); err == nil && count == 0 {
+		return nil, vector.ErrEmptyCollection
+	}
+
+	embedding, err := wm.EmbedQuery(ctx, query, "")
+	if err != nil {
+		return nil, fmt.Errorf("query failed: couldn't create embedding of query: %w", err)
+	}
+	alpha := wm.Config().WeaviateHybridAlpha
+	if alpha >= 1 {
+		return wm.RetriveNVectorsByEmbedding(ctx, embedding, n, where)
+	}
+
+	wm.mu.RLock()
+	defer wm.mu.RUnlock()
+	results, err := wm.client.search(ctx, wm.class, hybrid(query, embedding, alpha), where, n)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	return results, nil
+}
+func (wm *weaviateManager) RetriveNVectorsByEmbedding(ctx context.Context, embedding []float32, n int, where map[string]string) ([]vector.VectorData, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("n must be > 0")
+	}
+	wm.mu.RLock()
+	defer wm.mu.RUnlock()
+
+	results, err := wm.client.search(ctx, wm.class, nearVector(embedding), where, n)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	// only an empty result is worth telling an empty collection apart
+	if len(results) == 0 {
+		if count, err := wm.client.count(ctx, wm.class); err == nil && count == 0 {
+			return nil, vector.ErrEmptyCollection
+		}
+	}
+	return results, nil
+}
+func (wm *weaviateManager) RetriveNVectorsByQueryRanked(ctx context.Context, query string, n int, where map[string]string, rank RankOptions) ([]vector.VectorData, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("n must be > 0")
+	}
+	if rank.RecencyWeight <= 0 {
+		return wm.RetriveNVectorsByQueryWithFilter(ctx, query, n, where)
+	}
+
+	candidates, err := wm.RetriveNVectorsByQueryWithFilter(ctx, query, n*rankCandidateFactor, where)
+	if err != nil {
+		return nil, err
+	}
+	return rankByRecency(candidates, n, rank, time.Now()), nil
+}
+
+// deletion functions
+func (wm *weaviateManager) DeleteVectorWithID(ctx context.Context, id string) error {
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+
+	return wm.client.deleteIDs(ctx, wm.class, []string{id})
+}
+func (wm *weaviateManager) DeleteVectorsWithMetaData(ctx context.Context, key string, data string) error {
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+
+	if wm.softDelete() {
+		_, err := wm.trashLocked(ctx, key, data)
+		return err
+	}
+	return wm.client.deleteWhere(ctx, wm.class, restWhere(map[string]string{key: data}))
+}
+
+// trash functions
+func (wm *weaviateManager) TrashVectorsWithMetaData(ctx context.Context, key string, data string) (int, error) {
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+
+	return wm.trashLocked(ctx, key, data)
+}
+func (wm *weaviateManager) trashLocked(ctx context.Context, key string, data string) (int, error) {
+	return trashWhere(ctx, wm.store(), key, data)
+}
+func (wm *weaviateManager) ListTrash(ctx context.Context) ([]vector.VectorData, error) {
+	wm.mu.RLock()
+	defer wm.mu.RUnlock()
+
+	return wm.listTrashLocked(ctx)
+}
+func (wm *weaviateManager) listTrashLocked(ctx context.Context) ([]vector.VectorData, error) {
+	return listTrash(ctx, wm.store())
+}
+func (wm *weaviateManager) RestoreFromTrash(ctx context.Context, path string, deletedAt string) (int, error) {
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+
+	return restoreFromTrash(ctx, wm.store(), path, deletedAt)
+}
+func (wm *weaviateManager) PurgeTrash(ctx context.Context, before time.Time) (int, error) {
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+
+	return purgeTrash(ctx, wm.store(), before)
+}
+
+// snapshot functions
+
+// Export writes the chunks and the trash in the snapshot format of the in-memory manager;
+// snapshots of the chromem store can't be imported here, nor the other way round.
+func (wm *weaviateManager) Export(ctx context.Context, w io.Writer) error {
+	wm.mu.RLock()
+	defer wm.mu.RUnlock()
+
+	return exportSnapshot(ctx, wm.store(), w)
+}
+
+// Import fills a new class with the snapshot's chunks and swaps it in, so queries see the
+// previous chunks until the import is complete; the trash is replaced afterwards.
+func (wm *weaviateManager) Import(ctx context.Context, r io.ReadSeeker) error {
+	var snap memorySnapshot
+	if err := gob.NewDecoder(r).Decode(&snap); err != nil {
+		return fmt.Errorf("failed to read snapshot: %w", err)
+	}
+
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+
+	class := wm.stagingClass()
+	if err := wm.client.createClass(ctx, class, weaviateStagingDescription+wm.name); err != nil {
+		return err
+	}
+	docs := make([]vector.VectorData, 0, len(snap.Docs))
+	for _, v := range snap.Docs {
+		docs = append(docs, v)
+	}
+	if err := wm.client.add(ctx, class, docs); err != nil {
+		if derr := wm.client.deleteClass(ctx, class); derr != nil {
+			log.Printf("[weaviateManager] warning: failed to delete import class %s: %v", class, derr)
+		}
+		return fmt.Errorf("failed to import snapshot: %w", err)
+	}
+	if err := wm.swapLocked(ctx, class); err != nil {
+		return err
+	}
+
+	current, err := wm.client.list(ctx, wm.trash)
+	if err != nil {
+		return err
+	}
+	ids := make([]string, 0, len(current))
+	for _, v := range current {
+		ids = append(ids, v.Id)
+	}
+	if err := wm.client.deleteIDs(ctx, wm.trash, ids); err != nil {
+		return err
+	}
+	trashed := make([]vector.VectorData, 0, len(snap.Trash))
+	for _, v := range snap.Trash {
+		trashed = append(trashed, v)
+	}
+	return wm.client.add(ctx, wm.trash, trashed)
+}
+
+// Reindex builds the new index in a class of its own, through a manager sharing the client
+// and the trash, and swaps it in by marking it live and dropping the previous class.
+func (wm *weaviateManager) Reindex(ctx context.Context, build func(staging Manager) error) error {
+	wm.reindexing.Lock()
+	defer wm.reindexing.Unlock()
+
+	class := wm.stagingClass()
+	if err := wm.client.createClass(ctx, class, weaviateStagingDescription+wm.name); err != nil {
+		return err
+	}
+	swapped := false
+	defer func() {
+		if !swapped {
+			if err := wm.client.deleteClass(context.Background(), class); err != nil {
+				log.Printf("[weaviateManager] warning: failed to delete reindex class %s: %v", class, err)
+			}
+		}
+	}()
+
+	wm.mu.RLock()
+	live, err := wm.client.list(ctx, wm.class)
+	wm.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	before := fileFingerprints(live)
+
+	staging := &weaviateManager{
+		client:      wm.client,
+		Embedder:    wm.Embedder,
+		Config:      wm.Config,
+		queries:     newQueryCache(wm.Config),
+		name:        wm.name,
+		class:       class,
+		trash:       wm.trash,
+		collections: wm.collections,
+	}
+	if err := build(staging); err != nil {
+		return err
+	}
+
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+
+	if err := carryOver(ctx, wm.store(), staging.store(), before, "weaviateManager"); err != nil {
+		return err
+	}
+
+	if err := wm.swapLocked(ctx, class); err != nil {
+		return err
+	}
+	swapped = true
+	log.Printf("[weaviateManager] reindex: swapped in %s", class)
+	return nil
+}
+
+// swapLocked makes class the live class of the collection and deletes the previous one. A
+// crash in between leaves both marked live, and the next start keeps the newer one.
+func (wm *weaviateManager) swapLocked(ctx context.Context, class string) error {
+	if err := wm.client.setDescription(ctx, class, weaviateLiveDescription+wm.name); err != nil {
+		return fmt.Errorf("failed to mark %s live: %w", class, err)
+	}
+	old := wm.class
+	wm.class = class
+	if err := wm.client.deleteClass(ctx, old); err != nil {
+		log.Printf("[weaviateManager] warning: failed to delete previous class %s: %v", old, err)
+	}
+	return nil
+}
+
+// collection functions
+func (wm *weaviateManager) CollectionName() string {
+	return wm.name
+}
+func (wm *weaviateManager) Collection(name string) (Manager, error) {
+	c := wm.collections
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if m, ok := c.byName[name]; ok {
+		return m, nil
+	}
+	return c.openLocked(context.Background(), name, false)
+}
+func (wm *weaviateManager) CreateCollection(ctx context.Context, name string) error {
+	if err := config.CheckCollectionName(name); err != nil {
+		return err
+	}
+	c := wm.collections
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	classes, err := c.client.classes(ctx)
+	if err != nil {
+		return err
+	}
+	for _, cl := range classes {
+		if cl.Description == weaviateLiveDescription+name || strings.HasPrefix(cl.Class, c.classOf(name)+"_") || cl.Class == c.classOf(name) {
+			return fmt.Errorf("collection %q: %w", name, vector.ErrCollectionExists)
+		}
+	}
+	_, err = c.openLocked(ctx, name, true)
+	return err
+}
+func (wm *weaviateManager) Collections(ctx context.Context) ([]CollectionInfo, error) {
+	classes, err := wm.client.classes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, cl := range classes {
+		if name, ok := strings.CutPrefix(cl.Description, weaviateLiveDescription); ok && !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	out := make([]CollectionInfo, 0, len(names))
+	for _, name := range names {
+		m, err := wm.Collection(name)
+		if err != nil {
+			return nil, err
+		}
+		n, err := m.Count(ctx)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, CollectionInfo{Name: name, Chunks: n})
+	}
+	return out, nil
+}
+
+// maintenance functions
+func (wm *weaviateManager) Count(ctx context.Context) (int, error) {
+	wm.mu.RLock()
+	defer wm.mu.RUnlock()
+
+	return wm.client.count(ctx, wm.class)
+}
+func (wm *weaviateManager) DeduplicateVectors(ctx context.Context, threshold float32) (int, error) {
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+
+	return deduplicate(ctx, wm.store(), threshold)
+}
@@ -5,4 +5,6 @@ type VectorData struct {
 	Embedding []float32
 	Metadata  map[string]string
 	Id        string
+	// Similarity is the cosine similarity to the query, only set on query results
+	Similarity float32
 }